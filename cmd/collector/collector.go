@@ -18,9 +18,11 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -31,7 +33,9 @@ import (
 
 	"github.com/vmware/go-ipfix/pkg/collector"
 	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/filter"
 	"github.com/vmware/go-ipfix/pkg/registry"
+	"github.com/vmware/go-ipfix/pkg/sink"
 )
 
 const (
@@ -39,9 +43,25 @@ const (
 )
 
 var (
-	IPFIXAddr      string
-	IPFIXPort      uint16
-	IPFIXTransport string
+	IPFIXAddr        string
+	IPFIXPort        uint16
+	IPFIXTransport   string
+	DumpMessages     int
+	AllowCompression bool
+	MaxConnections   int
+	DecodeWorkers    int
+	UDPListeners     int
+	AllowedExporters []string
+	RecordFilter     string
+	AddressFamily    string
+	Interface        string
+	TCPKeepAlive     int
+	IdleTimeout      uint32
+	DuplicateWindow  uint32
+	NormalizeTimes   bool
+	APIAddr          string
+	APITokenFile     string
+	ConfigFile       string
 )
 
 func initLoggingToFile(fs *pflag.FlagSet) {
@@ -55,9 +75,34 @@ func initLoggingToFile(fs *pflag.FlagSet) {
 }
 
 func addIPFIXFlags(fs *pflag.FlagSet) {
-	fs.StringVar(&IPFIXAddr, "ipfix.addr", "0.0.0.0", "IPFIX collector address")
+	fs.StringVar(&IPFIXAddr, "ipfix.addr", "0.0.0.0", "IPFIX collector address, or path of the socket for the unix and unixgram transports")
 	fs.Uint16Var(&IPFIXPort, "ipfix.port", 4739, "IPFIX collector port")
-	fs.StringVar(&IPFIXTransport, "ipfix.transport", "tcp", "IPFIX collector transport layer")
+	fs.StringVar(&IPFIXTransport, "ipfix.transport", "tcp", "IPFIX collector transport layer: tcp, udp, sctp, unix or unixgram")
+	fs.IntVar(&DumpMessages, "ipfix.dump-messages", 0, "Number of messages to dump in hexadecimal at the beginning of every transport session")
+	fs.BoolVar(&AllowCompression, "ipfix.allow-compression", false, "Accept zstd compression of TCP connections requested by go-ipfix exporters")
+	fs.IntVar(&MaxConnections, "ipfix.max-connections", 0, "Maximum number of concurrent TCP connections from exporters; 0 means no limit")
+	fs.IntVar(&DecodeWorkers, "ipfix.decode-workers", 0, "Number of goroutines decoding the messages received over UDP; 0 decodes them in the goroutine of every exporter")
+	fs.IntVar(&UDPListeners, "ipfix.udp-listeners", 0, "Number of UDP sockets bound to the collector address with SO_REUSEPORT (Linux only); 0 uses a single socket")
+	fs.StringSliceVar(&AllowedExporters, "ipfix.allowed-exporters", nil, "CIDRs of the only exporters accepted; all exporters are accepted if empty")
+	fs.StringVar(&AddressFamily, "ipfix.address-family", "any", "IP versions of the addresses on which the collector listens: any, ipv4, ipv6 or dual")
+	fs.StringVar(&Interface, "ipfix.interface", "", "Network interface to which the collector sockets are bound (Linux only); sockets are not bound if empty")
+	fs.IntVar(&TCPKeepAlive, "ipfix.tcp-keepalive", 0, "Period in seconds of the keep-alive probes of TCP connections; 0 uses the default of 15 seconds, and a negative value disables them")
+	fs.Uint32Var(&IdleTimeout, "ipfix.idle-timeout", 0, "Period in seconds after which TCP connections without traffic are closed; 0 disables the timeout")
+	fs.Uint32Var(&DuplicateWindow, "ipfix.duplicate-window", 0, "Period in seconds for which UDP messages are remembered to discard duplicates retransmitted by middleboxes; 0 disables duplicate suppression")
+	fs.BoolVar(&NormalizeTimes, "ipfix.normalize-flow-times", false, "Rewrite the flow start and end times of the records into the time of the collector, using the clock skew of their exporter estimated from the export times")
+	fs.StringVar(&RecordFilter, "ipfix.record-filter", "", "Expression selecting the data records to process, e.g. 'protocolIdentifier == 6 && destinationTransportPort == 443'; all records are processed if empty")
+	fs.StringVar(&APIAddr, "api.addr", "", "Address (hostIP:port) of the read-only HTTP API, which also serves /healthz for probes; the API is disabled if empty")
+	fs.StringVar(&APITokenFile, "api.token-file", "", "File containing the bearer token required by the HTTP API")
+	fs.StringVar(&ConfigFile, "config", "", "YAML file declaring the sinks to which the records are written, each with an optional filter and element projection")
+}
+
+func parseAddressFamily(name string) (collector.AddressFamily, error) {
+	for _, family := range []collector.AddressFamily{collector.AddressFamilyAny, collector.AddressFamilyIPv4, collector.AddressFamilyIPv6, collector.AddressFamilyDual} {
+		if family.String() == name {
+			return family, nil
+		}
+	}
+	return collector.AddressFamilyAny, fmt.Errorf("unknown address family %s", name)
 }
 
 func printIPFIXMessage(msg *entities.Message) {
@@ -108,19 +153,58 @@ func run() error {
 	// Load the IPFIX global registry
 	registry.LoadRegistry()
 	// Initialize collecting process
+	address := IPFIXAddr + ":" + strconv.Itoa(int(IPFIXPort))
+	if IPFIXTransport == "unix" || IPFIXTransport == "unixgram" {
+		address = IPFIXAddr
+	}
+	addressFamily, err := parseAddressFamily(AddressFamily)
+	if err != nil {
+		return err
+	}
 	cpInput := collector.CollectorInput{
-		Address:       IPFIXAddr + ":" + strconv.Itoa(int(IPFIXPort)),
-		Protocol:      IPFIXTransport,
-		MaxBufferSize: 65535,
-		TemplateTTL:   0,
-		IsEncrypted:   false,
-		ServerCert:    nil,
-		ServerKey:     nil,
+		Address:               address,
+		Protocol:              IPFIXTransport,
+		MaxBufferSize:         65535,
+		TemplateTTL:           0,
+		IsEncrypted:           false,
+		ServerCert:            nil,
+		ServerKey:             nil,
+		DumpMessages:          DumpMessages,
+		AllowCompression:      AllowCompression,
+		MaxConnections:        MaxConnections,
+		DecodeWorkers:         DecodeWorkers,
+		UDPListeners:          UDPListeners,
+		AllowedExporters:      AllowedExporters,
+		AddressFamily:         addressFamily,
+		Interface:             Interface,
+		TCPKeepAlive:          TCPKeepAlive,
+		ConnectionIdleTimeout: IdleTimeout,
+		DuplicateWindow:       DuplicateWindow,
+		EstimateClockSkew:     NormalizeTimes,
+		NormalizeFlowTimes:    NormalizeTimes,
+	}
+	if RecordFilter != "" {
+		recordFilter, err := filter.ParseExpression(RecordFilter)
+		if err != nil {
+			return err
+		}
+		cpInput.RecordFilter = recordFilter
 	}
 	cp, err := collector.InitCollectingProcess(cpInput)
 	if err != nil {
 		return err
 	}
+	var pipelines []*sink.Pipeline
+	if ConfigFile != "" {
+		config, err := loadConfig(ConfigFile)
+		if err != nil {
+			return err
+		}
+		if pipelines, err = initPipelines(config); err != nil {
+			return err
+		}
+		klog.Infof("Writing records to %d sinks", len(pipelines))
+	}
 	// Start listening to connections and receiving messages.
 	messageReceived := make(chan *entities.Message)
 	go func() {
@@ -128,16 +212,37 @@ func run() error {
 		msgChan := cp.GetMsgChan()
 		for message := range msgChan {
 			klog.Info("Processing IPFIX message")
+			for _, pipeline := range pipelines {
+				pipeline.Write(message)
+			}
 			messageReceived <- message
 		}
 	}()
 
+	if APIAddr != "" {
+		token, err := ioutil.ReadFile(APITokenFile)
+		if err != nil {
+			return fmt.Errorf("cannot read API token: %v", err)
+		}
+		apiServer, err := collector.InitAPIServer(collector.APIInput{
+			Address:           APIAddr,
+			Token:             strings.TrimSpace(string(token)),
+			CollectingProcess: cp,
+		})
+		if err != nil {
+			return err
+		}
+		go apiServer.Start()
+		defer apiServer.Stop()
+	}
+
 	stopCh := make(chan struct{})
 	go signalHandler(stopCh, messageReceived)
 
 	<-stopCh
 	// Stop the collector process
 	cp.Stop()
+	stopPipelines(pipelines)
 	klog.Info("Stopping IPFIX collector")
 	return nil
 }
@@ -0,0 +1,230 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/exporter"
+	"github.com/vmware/go-ipfix/pkg/producer"
+	"github.com/vmware/go-ipfix/pkg/producer/convertor"
+	"github.com/vmware/go-ipfix/pkg/sink"
+)
+
+// collectorConfig is the content of the configuration file of the collector,
+// e.g.
+//
+//	sinks:
+//	- name: siem
+//	  type: file
+//	  filter: 'ingressNetworkPolicyRuleAction == 2 || egressNetworkPolicyRuleAction == 2'
+//	  file:
+//	    path: /var/log/ipfix/denied.json
+//	- name: archive
+//	  type: ipfix
+//	  ipfix:
+//	    address: 10.0.0.10:4739
+//	    transport: tcp
+//	- name: kafka
+//	  type: kafka
+//	  filter: 'sourcePodNamespace == "prod"'
+//	  elements: [sourcePodName, destinationPodName, octetTotalCount]
+//	  transform: |
+//	    set octetTotalCount = octetDeltaCount + reverseOctetDeltaCount
+//	  kafka:
+//	    brokers: [10.0.0.20:9092]
+//	    topic: flows
+//	    protoSchema: <registered proto schema>
+//
+// Every data record received by the collector is written to all the sinks
+// whose filter matches it.
+type collectorConfig struct {
+	Sinks []sinkConfig `yaml:"sinks"`
+}
+
+type sinkConfig struct {
+	Name string `yaml:"name"`
+	// Type is "file", "kafka" or "ipfix".
+	Type string `yaml:"type"`
+	// Filter is a filter expression selecting the records written to the
+	// sink. All records are written if it is empty.
+	Filter string `yaml:"filter"`
+	// Elements are the elements of the records written to the sink, in this
+	// order, with zero values for the elements missing from records. All
+	// elements are written if it is empty.
+	Elements []string `yaml:"elements"`
+	// Transform are the rules renaming, computing and setting elements of
+	// the records written to the sink (see transform.ParseTransform). They
+	// are applied before the filter and the elements.
+	Transform string `yaml:"transform"`
+	File      struct {
+		Path string `yaml:"path"`
+	} `yaml:"file"`
+	Kafka struct {
+		Brokers     []string `yaml:"brokers"`
+		Topic       string   `yaml:"topic"`
+		ProtoSchema string   `yaml:"protoSchema"`
+	} `yaml:"kafka"`
+	IPFIX struct {
+		// Address is the address (hostIP:port) of the IPFIX collector.
+		Address             string `yaml:"address"`
+		Transport           string `yaml:"transport"`
+		ObservationDomainID uint32 `yaml:"observationDomainID"`
+	} `yaml:"ipfix"`
+	// The pipeline options are described in sink.PipelineInput. The defaults
+	// of the pipeline are used if they are 0.
+	BatchSize     int           `yaml:"batchSize"`
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	MaxRetries    int           `yaml:"maxRetries"`
+	QueueSize     int           `yaml:"queueSize"`
+	// DropWhenFull drops the records when the queue of the sink is full,
+	// instead of blocking all the sinks.
+	DropWhenFull bool `yaml:"dropWhenFull"`
+}
+
+func loadConfig(path string) (*collectorConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config file: %v", err)
+	}
+	var config collectorConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("cannot parse config file %s: %v", path, err)
+	}
+	names := make(map[string]bool)
+	for i, sinkConfig := range config.Sinks {
+		if sinkConfig.Name == "" {
+			return nil, fmt.Errorf("sink %d of config file %s has no name", i, path)
+		}
+		if names[sinkConfig.Name] {
+			return nil, fmt.Errorf("sink name %s is used more than once in config file %s", sinkConfig.Name, path)
+		}
+		names[sinkConfig.Name] = true
+	}
+	return &config, nil
+}
+
+// createSink returns the sink of the configuration, with its transform, filter
+// and element projection.
+func createSink(config sinkConfig) (sink.Sink, error) {
+	var output sink.Sink
+	switch config.Type {
+	case "file":
+		if config.File.Path == "" {
+			return nil, fmt.Errorf("file sink %s has no path", config.Name)
+		}
+		output = sink.NewFileSink(config.File.Path)
+	case "kafka":
+		if len(config.Kafka.Brokers) == 0 || config.Kafka.Topic == "" {
+			return nil, fmt.Errorf("kafka sink %s needs brokers and a topic", config.Name)
+		}
+		// The convertors of proto schemas are registered by the programs
+		// using the producer.
+		if _, exist := convertor.ProtoSchemaConvertor[config.Kafka.ProtoSchema]; !exist {
+			return nil, fmt.Errorf("kafka sink %s has unregistered proto schema %q", config.Name, config.Kafka.ProtoSchema)
+		}
+		kafkaProducer, err := producer.InitKafkaProducer(config.Kafka.Brokers, config.Kafka.Topic, config.Kafka.ProtoSchema, true)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create kafka sink %s: %v", config.Name, err)
+		}
+		output = kafkaProducer
+	case "ipfix":
+		if config.IPFIX.Address == "" {
+			return nil, fmt.Errorf("ipfix sink %s has no address", config.Name)
+		}
+		transport := config.IPFIX.Transport
+		if transport == "" {
+			transport = "tcp"
+		}
+		output = exporter.NewExporterSink(exporter.ExporterInput{
+			CollectorAddress:    config.IPFIX.Address,
+			CollectorProtocol:   transport,
+			ObservationDomainID: config.IPFIX.ObservationDomainID,
+		})
+	default:
+		return nil, fmt.Errorf("sink %s has unknown type %q", config.Name, config.Type)
+	}
+	route, err := sink.NewRouteSink(sink.RouteInput{
+		Sink:     output,
+		Filter:   config.Filter,
+		Elements: config.Elements,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid sink %s: %v", config.Name, err)
+	}
+	if config.Transform == "" {
+		return route, nil
+	}
+	transform, err := sink.NewTransformSink(sink.TransformInput{
+		Sink:      route,
+		Transform: config.Transform,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid sink %s: %v", config.Name, err)
+	}
+	return transform, nil
+}
+
+// initPipelines creates and starts the pipelines of the sinks of the
+// configuration. The pipelines started before an error are stopped.
+func initPipelines(config *collectorConfig) ([]*sink.Pipeline, error) {
+	pipelines := make([]*sink.Pipeline, 0, len(config.Sinks))
+	for _, sinkConfig := range config.Sinks {
+		pipeline, err := initPipeline(sinkConfig)
+		if err != nil {
+			stopPipelines(pipelines)
+			return nil, err
+		}
+		go pipeline.Start()
+		pipelines = append(pipelines, pipeline)
+	}
+	return pipelines, nil
+}
+
+func initPipeline(config sinkConfig) (*sink.Pipeline, error) {
+	output, err := createSink(config)
+	if err != nil {
+		return nil, err
+	}
+	overloadPolicy := sink.OverloadPolicyBlock
+	if config.DropWhenFull {
+		overloadPolicy = sink.OverloadPolicyDrop
+	}
+	pipeline, err := sink.InitPipeline(sink.PipelineInput{
+		Sink:           output,
+		BatchSize:      config.BatchSize,
+		FlushInterval:  config.FlushInterval,
+		MaxRetries:     config.MaxRetries,
+		QueueSize:      config.QueueSize,
+		OverloadPolicy: overloadPolicy,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open sink %s: %v", config.Name, err)
+	}
+	return pipeline, nil
+}
+
+func stopPipelines(pipelines []*sink.Pipeline) {
+	for _, pipeline := range pipelines {
+		if err := pipeline.Stop(); err != nil {
+			klog.Errorf("Error when closing sink: %v", err)
+		}
+	}
+}
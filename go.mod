@@ -5,11 +5,13 @@ go 1.15
 require (
 	github.com/Shopify/sarama v1.27.2
 	github.com/golang/mock v1.4.3
+	github.com/klauspost/compress v1.11.0
 	github.com/pion/dtls/v2 v2.0.3
 	github.com/spf13/cobra v0.0.5
 	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.6.1
 	google.golang.org/protobuf v1.26.0
+	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776
 	k8s.io/apimachinery v0.18.4
 	k8s.io/component-base v0.18.4
 	k8s.io/klog v1.0.0
@@ -0,0 +1,83 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"k8s.io/klog/v2"
+)
+
+func parseAllowedExporters(cidrs []string) ([]*net.IPNet, error) {
+	if len(cidrs) == 0 {
+		return nil, nil
+	}
+	allowed := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %s for allowed exporters: %v", cidr, err)
+		}
+		allowed = append(allowed, ipNet)
+	}
+	return allowed, nil
+}
+
+// isExporterAllowed returns whether the exporter with the given address is in
+// the allowed exporter subnets, if any.
+func (cp *CollectingProcess) isExporterAllowed(address net.Addr) bool {
+	if cp.allowedExporters == nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(address.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range cp.allowedExporters {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowConnection closes the accepted connection and counts it if its
+// exporter is not allowed.
+func (cp *CollectingProcess) allowConnection(conn net.Conn) bool {
+	if cp.isExporterAllowed(conn.RemoteAddr()) {
+		return true
+	}
+	atomic.AddUint64(&cp.stats.deniedConnections, 1)
+	klog.V(2).Infof("Rejecting connection from %s: the exporter is not allowed", conn.RemoteAddr())
+	conn.Close()
+	return false
+}
+
+// allowDatagram counts the datagram received over UDP if its exporter is not
+// allowed, in which case it must be discarded.
+func (cp *CollectingProcess) allowDatagram(address net.Addr) bool {
+	if cp.isExporterAllowed(address) {
+		return true
+	}
+	atomic.AddUint64(&cp.stats.deniedDatagrams, 1)
+	klog.V(4).Infof("Discarding datagram from %s: the exporter is not allowed", address)
+	return false
+}
@@ -0,0 +1,288 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/filter"
+	"github.com/vmware/go-ipfix/pkg/intermediate"
+)
+
+const (
+	defaultFlowQueryLimit = 100
+	maxFlowQueryLimit     = 10000
+	apiReadHeaderTimeout  = 10 * time.Second
+)
+
+// APIInput configures the read-only HTTP API of a collecting process, which
+// serves JSON documents on the following paths:
+//
+//	/api/v1/sessions  the transport sessions, with their stats (SessionStats)
+//	/api/v1/templates the templates (TemplateInfo)
+//	/api/v1/stats     the stats of the collecting process (Stats)
+//	/api/v1/flows     the flows of the aggregation process, if any
+//	/api/v1/malformed the messages which failed to be decoded, if captured
+//	                  (MalformedMessage)
+//
+// The sessions, templates and stats are also served on the /connections,
+// /templates and /stats paths. The /healthz path serves the health of the
+// collecting process, with status 200 if it listens for exporters and 503
+// otherwise, without token, so that it can be used by Kubernetes probes.
+//
+// The templates of an observation domain of an exporter are selected with the
+// exporter and obsDomainID query parameters, e.g.
+// ?exporter=10.0.0.1&obsDomainID=1, where the exporter is an IP address or the
+// address of a transport session.
+//
+// Flows can be selected with a filter expression (see filter.Expression) in
+// the filter query parameter, e.g. ?filter=protocolIdentifier==6, and their
+// number is limited by the limit query parameter (100 by default).
+type APIInput struct {
+	// Address needs to be provided in hostIP:port format.
+	Address string
+	// Token is the bearer token which must be given in the Authorization
+	// header of every request.
+	Token string
+	// CollectingProcess is the collecting process to inspect.
+	CollectingProcess *CollectingProcess
+	// AggregationProcess is optional. If given, its flows can be queried.
+	AggregationProcess *intermediate.AggregationProcess
+}
+
+type APIServer struct {
+	token              string
+	collectingProcess  *CollectingProcess
+	aggregationProcess *intermediate.AggregationProcess
+	listener           net.Listener
+	server             *http.Server
+}
+
+// apiFlow is a flow of the aggregation process returned by the API.
+type apiFlow struct {
+	Key         intermediate.FlowKey
+	ReadyToSend bool
+	Elements    map[string]interface{}
+}
+
+type apiFlows struct {
+	Flows []apiFlow
+	// Truncated is true if more flows match the filter than the limit.
+	Truncated bool
+}
+
+type apiError struct {
+	Error string
+}
+
+type apiHealth struct {
+	Listening bool
+}
+
+// InitAPIServer listens on the address of the API. Requests are served once
+// the server is started.
+func InitAPIServer(input APIInput) (*APIServer, error) {
+	if input.Token == "" {
+		return nil, fmt.Errorf("cannot create API server without token")
+	}
+	if input.CollectingProcess == nil {
+		return nil, fmt.Errorf("cannot create API server without collecting process")
+	}
+	listener, err := net.Listen("tcp", input.Address)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on API address %s: %v", input.Address, err)
+	}
+	s := &APIServer{
+		token:              input.Token,
+		collectingProcess:  input.CollectingProcess,
+		aggregationProcess: input.AggregationProcess,
+		listener:           listener,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sessions", s.handleSessions)
+	mux.HandleFunc("/api/v1/templates", s.handleTemplates)
+	mux.HandleFunc("/api/v1/stats", s.handleStats)
+	mux.HandleFunc("/api/v1/flows", s.handleFlows)
+	mux.HandleFunc("/api/v1/malformed", s.handleMalformed)
+	mux.HandleFunc("/connections", s.handleSessions)
+	mux.HandleFunc("/templates", s.handleTemplates)
+	mux.HandleFunc("/stats", s.handleStats)
+	rootMux := http.NewServeMux()
+	rootMux.Handle("/healthz", readOnly(http.HandlerFunc(s.handleHealth)))
+	rootMux.Handle("/", s.authorize(readOnly(mux)))
+	s.server = &http.Server{
+		Handler:           rootMux,
+		ReadHeaderTimeout: apiReadHeaderTimeout,
+	}
+	return s, nil
+}
+
+// Start serves requests until the server is stopped.
+func (s *APIServer) Start() {
+	klog.Infof("Start collector API on %s", s.listener.Addr())
+	if err := s.server.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+		klog.Errorf("Error in collector API: %v", err)
+	}
+}
+
+func (s *APIServer) Stop() {
+	s.server.Close()
+}
+
+func (s *APIServer) GetAddress() net.Addr {
+	return s.listener.Addr()
+}
+
+// authorize rejects requests without the bearer token.
+func (s *APIServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		if token == authorization || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, apiError{"invalid or missing bearer token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readOnly rejects requests which are not reads.
+func readOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeJSON(w, http.StatusMethodNotAllowed, apiError{"the API is read-only"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *APIServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if !s.collectingProcess.IsListening() {
+		writeJSON(w, http.StatusServiceUnavailable, apiHealth{})
+		return
+	}
+	writeJSON(w, http.StatusOK, apiHealth{Listening: true})
+}
+
+func (s *APIServer) handleSessions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.collectingProcess.GetSessions())
+}
+
+func (s *APIServer) handleTemplates(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	exporter, obsDomainID := query.Get("exporter"), query.Get("obsDomainID")
+	if exporter == "" && obsDomainID == "" {
+		writeJSON(w, http.StatusOK, s.collectingProcess.GetTemplates())
+		return
+	}
+	id, err := strconv.ParseUint(obsDomainID, 10, 32)
+	if exporter == "" || err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"exporter and obsDomainID must be given together"})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.collectingProcess.GetExporterTemplates(exporter, uint32(id)))
+}
+
+func (s *APIServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.collectingProcess.GetStats())
+}
+
+func (s *APIServer) handleMalformed(w http.ResponseWriter, r *http.Request) {
+	messages := s.collectingProcess.GetMalformedMessages()
+	if messages == nil {
+		writeJSON(w, http.StatusNotFound, apiError{"malformed messages are not captured by the collector"})
+		return
+	}
+	writeJSON(w, http.StatusOK, messages)
+}
+
+func (s *APIServer) handleFlows(w http.ResponseWriter, r *http.Request) {
+	if s.aggregationProcess == nil {
+		writeJSON(w, http.StatusNotFound, apiError{"no aggregation process is attached to the collector"})
+		return
+	}
+	query := r.URL.Query()
+	var expression *filter.Expression
+	if source := query.Get("filter"); source != "" {
+		var err error
+		if expression, err = filter.ParseExpression(source); err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+			return
+		}
+	}
+	limit := defaultFlowQueryLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > maxFlowQueryLimit {
+			writeJSON(w, http.StatusBadRequest, apiError{fmt.Sprintf("limit must be between 1 and %d", maxFlowQueryLimit)})
+			return
+		}
+	}
+	result := apiFlows{Flows: make([]apiFlow, 0)}
+	err := s.aggregationProcess.ForAllRecordsDo(func(key intermediate.FlowKey, record intermediate.AggregationFlowRecord) error {
+		if expression != nil && !expression.Match(record.Record) {
+			return nil
+		}
+		if len(result.Flows) == limit {
+			result.Truncated = true
+			return nil
+		}
+		result.Flows = append(result.Flows, apiFlow{key, record.ReadyToSend, getAPIElements(record.Record)})
+		return nil
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// getAPIElements returns the values of the elements of the record by name.
+// Addresses are formatted as strings.
+func getAPIElements(record entities.Record) map[string]interface{} {
+	elements := make(map[string]interface{})
+	for _, element := range record.GetOrderedElementList() {
+		switch value := element.Value.(type) {
+		case net.IP:
+			elements[element.Element.Name] = value.String()
+		case net.HardwareAddr:
+			elements[element.Element.Name] = value.String()
+		default:
+			elements[element.Element.Name] = value
+		}
+	}
+	return elements
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		klog.V(2).Infof("Error when writing API response: %v", err)
+	}
+}
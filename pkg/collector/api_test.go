@@ -0,0 +1,218 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/intermediate"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+const testAPIToken = "secret"
+
+func getAPI(t *testing.T, s *APIServer, method, path, token string, value interface{}) int {
+	request, err := http.NewRequest(method, "http://"+s.GetAddress().String()+path, nil)
+	assert.NoError(t, err)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if !assert.NoError(t, err) {
+		return 0
+	}
+	defer response.Body.Close()
+	if value != nil {
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(value))
+	}
+	return response.StatusCode
+}
+
+func TestAPIServer(t *testing.T) {
+	cp, err := InitCollectingProcess(getCollectorInput(tcpTransport, false, false))
+	if err != nil {
+		t.Fatalf("TCP Collecting Process does not start correctly: %v", err)
+	}
+	go cp.Start()
+	defer cp.Stop()
+	waitForCollectorReady(t, cp)
+	conn, err := net.Dial(cp.GetAddress().Network(), cp.GetAddress().String())
+	if err != nil {
+		t.Fatalf("Cannot establish connection to %s", cp.GetAddress().String())
+	}
+	defer conn.Close()
+	cp.addTemplate(conn.LocalAddr().String(), uint32(1), uint16(256), elementsWithValueIPv4, 0)
+	conn.Write(validDataPacket)
+	<-cp.GetMsgChan()
+
+	_, err = InitAPIServer(APIInput{Address: "127.0.0.1:0", CollectingProcess: cp})
+	assert.Error(t, err)
+	ap, err := intermediate.InitAggregationProcess(intermediate.AggregationInput{
+		MessageChan: make(chan *entities.Message),
+		WorkerNum:   1,
+	})
+	assert.NoError(t, err)
+	s, err := InitAPIServer(APIInput{
+		Address:            "127.0.0.1:0",
+		Token:              testAPIToken,
+		CollectingProcess:  cp,
+		AggregationProcess: ap,
+	})
+	assert.NoError(t, err)
+	go s.Start()
+	defer s.Stop()
+
+	// Requests must have the token and must be reads.
+	assert.Equal(t, http.StatusUnauthorized, getAPI(t, s, http.MethodGet, "/api/v1/sessions", "", nil))
+	assert.Equal(t, http.StatusUnauthorized, getAPI(t, s, http.MethodGet, "/api/v1/sessions", "invalid", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, getAPI(t, s, http.MethodPost, "/api/v1/sessions", testAPIToken, nil))
+
+	var sessions []SessionStats
+	assert.Equal(t, http.StatusOK, getAPI(t, s, http.MethodGet, "/api/v1/sessions", testAPIToken, &sessions))
+	// The session opened by waitForCollectorReady may not be closed yet.
+	var session SessionStats
+	for _, session = range sessions {
+		if session.Address == conn.LocalAddr().String() {
+			break
+		}
+	}
+	assert.Equal(t, conn.LocalAddr().String(), session.Address)
+	assert.Equal(t, tcpTransport, session.Protocol)
+	assert.Equal(t, uint64(1), session.Messages)
+	assert.Equal(t, uint64(1), session.Records)
+	assert.Equal(t, uint64(len(validDataPacket)), session.Bytes)
+	assert.False(t, session.LastMessageTime.Before(session.StartTime))
+
+	var templates []TemplateInfo
+	assert.Equal(t, http.StatusOK, getAPI(t, s, http.MethodGet, "/api/v1/templates", testAPIToken, &templates))
+	assert.Equal(t, 1, len(templates))
+	assert.Equal(t, uint16(256), templates[0].TemplateID)
+	assert.Equal(t, "destinationNodeName", templates[0].Elements[2].Name)
+	templates = nil
+	exporterIP, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	assert.Equal(t, http.StatusOK, getAPI(t, s, http.MethodGet, "/api/v1/templates?exporter="+exporterIP+"&obsDomainID=1", testAPIToken, &templates))
+	assert.Equal(t, 1, len(templates))
+	templates = nil
+	assert.Equal(t, http.StatusOK, getAPI(t, s, http.MethodGet, "/api/v1/templates?exporter="+exporterIP+"&obsDomainID=2", testAPIToken, &templates))
+	assert.Equal(t, 0, len(templates))
+	assert.Equal(t, http.StatusBadRequest, getAPI(t, s, http.MethodGet, "/api/v1/templates?exporter="+exporterIP, testAPIToken, nil))
+
+	var stats Stats
+	assert.Equal(t, http.StatusOK, getAPI(t, s, http.MethodGet, "/api/v1/stats", testAPIToken, &stats))
+	assert.Equal(t, uint64(1), stats.Templates[0].DataRecords)
+
+	// Flows of the aggregation process are filtered.
+	for _, port := range []uint16{80, 443} {
+		assert.NoError(t, ap.AggregateMsgByFlowKey(createFlowMessage(t, port)))
+	}
+	var flows apiFlows
+	assert.Equal(t, http.StatusOK, getAPI(t, s, http.MethodGet, "/api/v1/flows", testAPIToken, &flows))
+	assert.Equal(t, 2, len(flows.Flows))
+	flows = apiFlows{}
+	assert.Equal(t, http.StatusOK, getAPI(t, s, http.MethodGet, "/api/v1/flows?filter="+url.QueryEscape("destinationTransportPort == 443"), testAPIToken, &flows))
+	assert.Equal(t, 1, len(flows.Flows))
+	assert.Equal(t, uint16(443), flows.Flows[0].Key.DestinationPort)
+	assert.Equal(t, "10.0.0.2", flows.Flows[0].Elements["destinationIPv4Address"])
+	assert.False(t, flows.Truncated)
+	flows = apiFlows{}
+	assert.Equal(t, http.StatusOK, getAPI(t, s, http.MethodGet, "/api/v1/flows?limit=1", testAPIToken, &flows))
+	assert.Equal(t, 1, len(flows.Flows))
+	assert.True(t, flows.Truncated)
+	assert.Equal(t, http.StatusBadRequest, getAPI(t, s, http.MethodGet, "/api/v1/flows?filter=invalid+==", testAPIToken, nil))
+	assert.Equal(t, http.StatusBadRequest, getAPI(t, s, http.MethodGet, "/api/v1/flows?limit=0", testAPIToken, nil))
+	s.aggregationProcess = nil
+	assert.Equal(t, http.StatusNotFound, getAPI(t, s, http.MethodGet, "/api/v1/flows", testAPIToken, nil))
+
+	// Malformed messages are returned if they are captured.
+	assert.Equal(t, http.StatusNotFound, getAPI(t, s, http.MethodGet, "/api/v1/malformed", testAPIToken, nil))
+	cp.malformedCapture = newMalformedCapture(1, nil)
+	cp.captureMalformedMessage("127.0.0.1:4739", []byte{0, 9}, fmt.Errorf("invalid version"))
+	var malformed []MalformedMessage
+	assert.Equal(t, http.StatusOK, getAPI(t, s, http.MethodGet, "/api/v1/malformed", testAPIToken, &malformed))
+	assert.Equal(t, 1, len(malformed))
+	assert.Equal(t, []byte{0, 9}, malformed[0].Data)
+	assert.Equal(t, "invalid version", malformed[0].Error)
+}
+
+func createFlowMessage(t *testing.T, dstPort uint16) *entities.Message {
+	values := map[string][]byte{
+		"sourceIPv4Address":        {10, 0, 0, 1},
+		"destinationIPv4Address":   {10, 0, 0, 2},
+		"sourceTransportPort":      {0x30, 0x39},
+		"destinationTransportPort": {byte(dstPort >> 8), byte(dstPort)},
+		"protocolIdentifier":       {6},
+	}
+	elements := make([]*entities.InfoElementWithValue, 0, len(values))
+	for _, name := range []string{"sourceIPv4Address", "destinationIPv4Address", "sourceTransportPort", "destinationTransportPort", "protocolIdentifier"} {
+		element, err := registry.GetInfoElement(name, registry.IANAEnterpriseID)
+		assert.NoError(t, err)
+		elements = append(elements, entities.NewInfoElementWithValue(element, bytes.NewBuffer(values[name])))
+	}
+	set := entities.NewSet(true)
+	assert.NoError(t, set.PrepareSet(entities.Data, 256))
+	assert.NoError(t, set.AddRecord(elements, 256))
+	message := entities.NewMessage(true)
+	message.SetExportAddress("127.0.0.1")
+	message.AddSet(set)
+	return message
+}
+
+func TestAPIServer_AdminPaths(t *testing.T) {
+	cp, err := InitCollectingProcess(getCollectorInput(tcpTransport, false, false))
+	if err != nil {
+		t.Fatalf("TCP Collecting Process does not start correctly: %v", err)
+	}
+	s, err := InitAPIServer(APIInput{
+		Address:           "127.0.0.1:0",
+		Token:             testAPIToken,
+		CollectingProcess: cp,
+	})
+	assert.NoError(t, err)
+	go s.Start()
+	defer s.Stop()
+
+	// The health is served without token.
+	var health apiHealth
+	assert.Equal(t, http.StatusServiceUnavailable, getAPI(t, s, http.MethodGet, "/healthz", "", &health))
+	assert.False(t, health.Listening)
+	go cp.Start()
+	waitForCollectorReady(t, cp)
+	assert.Equal(t, http.StatusOK, getAPI(t, s, http.MethodGet, "/healthz", "", &health))
+	assert.True(t, health.Listening)
+	assert.Equal(t, http.StatusMethodNotAllowed, getAPI(t, s, http.MethodPost, "/healthz", "", nil))
+
+	for _, path := range []string{"/connections", "/templates", "/stats"} {
+		assert.Equal(t, http.StatusUnauthorized, getAPI(t, s, http.MethodGet, path, "", nil))
+		assert.Equal(t, http.StatusOK, getAPI(t, s, http.MethodGet, path, testAPIToken, nil))
+	}
+
+	cp.Stop()
+	err = wait.Poll(10*time.Millisecond, time.Second, func() (bool, error) {
+		return !cp.IsListening(), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, getAPI(t, s, http.MethodGet, "/healthz", "", nil))
+}
@@ -0,0 +1,184 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// MalformedMessage is a message which the collecting process failed to
+// decode, captured to diagnose the exporter offline.
+type MalformedMessage struct {
+	Time time.Time
+	// ExporterAddress is the address and port of the transport session.
+	ExporterAddress string
+	// Data are the raw bytes of the message.
+	Data  []byte
+	Error string
+}
+
+// malformedCapture keeps the most recent malformed messages in a ring buffer,
+// and writes them to the capture writer if any.
+type malformedCapture struct {
+	mutex    sync.Mutex
+	messages []MalformedMessage
+	// next is the index of the ring buffer where the next message is kept.
+	next   int
+	writer io.Writer
+}
+
+func newMalformedCapture(size int, writer io.Writer) *malformedCapture {
+	if size == 0 && writer == nil {
+		return nil
+	}
+	return &malformedCapture{
+		messages: make([]MalformedMessage, 0, size),
+		writer:   writer,
+	}
+}
+
+func (c *malformedCapture) capture(message MalformedMessage) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if cap(c.messages) > 0 {
+		if len(c.messages) < cap(c.messages) {
+			c.messages = append(c.messages, message)
+		} else {
+			c.messages[c.next] = message
+		}
+		c.next = (c.next + 1) % cap(c.messages)
+	}
+	if c.writer != nil {
+		if err := WriteMalformedMessage(c.writer, message); err != nil {
+			klog.Errorf("Error when capturing malformed message from %s: %v", message.ExporterAddress, err)
+		}
+	}
+}
+
+// getMessages returns the messages of the ring buffer, from the oldest.
+func (c *malformedCapture) getMessages() []MalformedMessage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	messages := make([]MalformedMessage, 0, len(c.messages))
+	if len(c.messages) == cap(c.messages) {
+		messages = append(messages, c.messages[c.next:]...)
+		return append(messages, c.messages[:c.next]...)
+	}
+	return append(messages, c.messages...)
+}
+
+// captureMalformedMessage captures a copy of the packet which failed to be
+// decoded, if malformed messages are captured.
+func (cp *CollectingProcess) captureMalformedMessage(sessionAddress string, packet []byte, decodeErr error) {
+	if cp.malformedCapture == nil {
+		return
+	}
+	cp.malformedCapture.capture(MalformedMessage{
+		Time:            time.Now(),
+		ExporterAddress: sessionAddress,
+		Data:            append([]byte(nil), packet...),
+		Error:           decodeErr.Error(),
+	})
+}
+
+// GetMalformedMessages returns the most recent messages which failed to be
+// decoded, from the oldest, if CollectorInput.MalformedCaptureSize is given.
+func (cp *CollectingProcess) GetMalformedMessages() []MalformedMessage {
+	if cp.malformedCapture == nil {
+		return nil
+	}
+	return cp.malformedCapture.getMessages()
+}
+
+// WriteMalformedMessage writes the malformed message to a capture file. Every
+// message is written as its time in nanoseconds since the Unix epoch (8
+// bytes), followed by the exporter address, the error and the data, each
+// prefixed with its length (2, 2 and 4 bytes), in network byte order. The
+// messages can be read back with ReadMalformedMessage.
+func WriteMalformedMessage(w io.Writer, message MalformedMessage) error {
+	if len(message.ExporterAddress) > 0xffff || len(message.Error) > 0xffff {
+		return fmt.Errorf("exporter address or error of malformed message is too long")
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, uint64(message.Time.UnixNano()))
+	binary.Write(buf, binary.BigEndian, uint16(len(message.ExporterAddress)))
+	buf.WriteString(message.ExporterAddress)
+	binary.Write(buf, binary.BigEndian, uint16(len(message.Error)))
+	buf.WriteString(message.Error)
+	binary.Write(buf, binary.BigEndian, uint32(len(message.Data)))
+	buf.Write(message.Data)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ReadMalformedMessage reads a malformed message written by
+// WriteMalformedMessage. It returns io.EOF at the end of the capture file.
+func ReadMalformedMessage(r io.Reader) (MalformedMessage, error) {
+	var message MalformedMessage
+	var timestamp uint64
+	if err := binary.Read(r, binary.BigEndian, &timestamp); err != nil {
+		return message, err
+	}
+	message.Time = time.Unix(0, int64(timestamp))
+	address, err := readCaptureField(r, 2)
+	if err != nil {
+		return message, err
+	}
+	message.ExporterAddress = string(address)
+	decodeErr, err := readCaptureField(r, 2)
+	if err != nil {
+		return message, err
+	}
+	message.Error = string(decodeErr)
+	if message.Data, err = readCaptureField(r, 4); err != nil {
+		return message, err
+	}
+	return message, nil
+}
+
+// readCaptureField reads a field prefixed with its length of lengthSize bytes.
+func readCaptureField(r io.Reader, lengthSize int) ([]byte, error) {
+	var length uint32
+	if lengthSize == 2 {
+		var length16 uint16
+		if err := binary.Read(r, binary.BigEndian, &length16); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		length = uint32(length16)
+	} else if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	field := make([]byte, length)
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return field, nil
+}
+
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF, as a capture file
+// cannot end within a message.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"crypto/x509"
+	"fmt"
+)
+
+// verifyAllowedClientNames returns a function for VerifyPeerCertificate of
+// TLS and DTLS configurations, which rejects client certificates that do not
+// have one of the allowed names. It is called after the certificate chain
+// is verified against the CA.
+func verifyAllowedClientNames(allowedNames map[string]bool) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+			return fmt.Errorf("client certificate is not verified")
+		}
+		cert := verifiedChains[0][0]
+		for _, name := range getCertificateNames(cert) {
+			if allowedNames[name] {
+				return nil
+			}
+		}
+		return fmt.Errorf("client certificate of %q is not allowed", cert.Subject.CommonName)
+	}
+}
+
+// getCertificateNames returns the common name and the subject alternative
+// names of the certificate.
+func getCertificateNames(cert *x509.Certificate) []string {
+	names := make([]string, 0)
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
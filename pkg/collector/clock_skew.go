@@ -0,0 +1,94 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// clockSkewWeight is the weight of every sample in the estimate of the clock
+// skew of an exporter, which smooths the variations of the transit delay.
+const clockSkewWeight = 0.125
+
+// clockSkewEstimator estimates the clock skew of every exporter from the
+// export times of its messages.
+type clockSkewEstimator struct {
+	mutex sync.Mutex
+	// skews are the estimated skews in seconds, by exporter IP address.
+	skews map[string]float64
+	// normalizeFlowTimes rewrites the flow times of the records into the
+	// time of the collector.
+	normalizeFlowTimes bool
+}
+
+func newClockSkewEstimator(normalizeFlowTimes bool) *clockSkewEstimator {
+	return &clockSkewEstimator{
+		skews:              make(map[string]float64),
+		normalizeFlowTimes: normalizeFlowTimes,
+	}
+}
+
+// observe updates the clock skew estimate of the exporter of the message
+// received at the given time, and returns it.
+func (e *clockSkewEstimator) observe(exporter string, exportTime uint32, received time.Time) time.Duration {
+	// Export times are truncated to seconds, so half a second is added to
+	// center the samples. The samples also include the transit delay.
+	sample := float64(exportTime) + 0.5 - float64(received.UnixNano())/float64(time.Second)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	skew, exist := e.skews[exporter]
+	if exist {
+		skew += (sample - skew) * clockSkewWeight
+	} else {
+		skew = sample
+	}
+	e.skews[exporter] = skew
+	return time.Duration(skew * float64(time.Second))
+}
+
+// apply attaches the clock skew estimate of its exporter to the message, and
+// rewrites the flow times of its data records into the time of the collector
+// if needed.
+func (e *clockSkewEstimator) apply(message *entities.Message, skew time.Duration) {
+	message.SetClockSkew(skew)
+	if !e.normalizeFlowTimes || message.GetSet().GetSetType() != entities.Data {
+		return
+	}
+	for _, record := range message.GetSet().GetRecords() {
+		normalizeFlowTimes(record, skew)
+	}
+}
+
+// normalizeFlowTimes subtracts the clock skew from the flow start and end
+// times of the record. Times which are not set are left as they are.
+func normalizeFlowTimes(record entities.Record, skew time.Duration) {
+	for _, name := range []string{"flowStartSeconds", "flowEndSeconds"} {
+		if ie, exist := record.GetInfoElementWithValue(name); exist {
+			if value, ok := ie.Value.(uint32); ok && value != 0 {
+				ie.Value = uint32(int64(value) - int64(skew.Round(time.Second)/time.Second))
+			}
+		}
+	}
+	for _, name := range []string{"flowStartMilliseconds", "flowEndMilliseconds"} {
+		if ie, exist := record.GetInfoElementWithValue(name); exist {
+			if value, ok := ie.Value.(uint64); ok && value != 0 {
+				ie.Value = uint64(int64(value) - skew.Milliseconds())
+			}
+		}
+	}
+}
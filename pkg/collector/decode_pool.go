@@ -0,0 +1,131 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"k8s.io/klog/v2"
+)
+
+// defaultDecodeQueueSize is the capacity of the queue of every decode worker
+// if CollectorInput.DecodeQueueSize is not given.
+const defaultDecodeQueueSize = 128
+
+type decodeTask struct {
+	packet  *bytes.Buffer
+	address string
+}
+
+// decodePool decodes the messages received over UDP and DTLS in a fixed number
+// of goroutines, so that reading from the socket does not wait for the
+// messages to be decoded and consumed from the message channel. The messages
+// of a transport session are always decoded by the same worker, in order, so
+// that its data sets are decoded after its templates.
+type decodePool struct {
+	queues []chan decodeTask
+	wg     sync.WaitGroup
+	// mutex protects stopped, so that no message is queued once the queues
+	// are closed.
+	mutex   sync.RWMutex
+	stopped bool
+}
+
+func (cp *CollectingProcess) startDecodePool() {
+	pool := &decodePool{queues: make([]chan decodeTask, cp.decodeWorkers)}
+	for i := range pool.queues {
+		queue := make(chan decodeTask, cp.decodeQueueSize)
+		pool.queues[i] = queue
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for task := range queue {
+				cp.publishRawMessage(task.packet.Bytes(), task.address, false)
+				message, err := cp.decodePacket(task.packet, task.address)
+				if err != nil {
+					klog.Error(err)
+					continue
+				}
+				if message != nil {
+					klog.V(4).Infof("Processed message from exporter %v, number of records: %v, observation domain ID: %v",
+						message.GetExportAddress(), message.GetSet().GetNumberOfRecords(), message.GetObsDomainID())
+				}
+			}
+		}()
+	}
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	cp.decodePool = pool
+}
+
+// stopDecodePool stops the workers once they have decoded the messages in
+// their queues. The messages queued after it is called, e.g. by the goroutines
+// of UDP clients which are still running, are dropped.
+func (cp *CollectingProcess) stopDecodePool() {
+	cp.mutex.Lock()
+	pool := cp.decodePool
+	cp.decodePool = nil
+	cp.mutex.Unlock()
+	if pool == nil {
+		return
+	}
+	pool.mutex.Lock()
+	pool.stopped = true
+	for _, queue := range pool.queues {
+		close(queue)
+	}
+	pool.mutex.Unlock()
+	pool.wg.Wait()
+}
+
+// queuePacket queues the message received from the transport session with
+// the given address for decoding. The message is dropped and counted if the
+// queue of the worker of the session is full, or if the pool is stopped.
+func (p *decodePool) queuePacket(cp *CollectingProcess, packet *bytes.Buffer, address string) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.stopped {
+		atomic.AddUint64(&cp.stats.droppedPackets, 1)
+		klog.V(4).Infof("Decode pool is stopped, dropping message from exporter %s", address)
+		return
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(address))
+	queue := p.queues[hash.Sum32()%uint32(len(p.queues))]
+	select {
+	case queue <- decodeTask{packet: packet, address: address}:
+	default:
+		atomic.AddUint64(&cp.stats.droppedPackets, 1)
+		klog.V(4).Infof("Decode queue is full, dropping message from exporter %s", address)
+	}
+}
+
+// backlog returns the number of messages waiting to be decoded.
+func (p *decodePool) backlog() int {
+	backlog := 0
+	for _, queue := range p.queues {
+		backlog += len(queue)
+	}
+	return backlog
+}
+
+func (cp *CollectingProcess) getDecodePool() *decodePool {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	return cp.decodePool
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sort"
+	"time"
+)
+
+// ObservationDomainStats contains the counters of an observation domain of
+// a transport session.
+type ObservationDomainStats struct {
+	// ExporterAddress is the address and port of the exporter session.
+	ExporterAddress string
+	ObsDomainID     uint32
+	// Messages is the number of messages decoded for the observation domain,
+	// and Records the number of data records in these messages.
+	Messages uint64
+	Records  uint64
+	// LastExportTime is the export time of the last message.
+	LastExportTime time.Time
+	// SequenceGaps is the number of messages whose sequence number was ahead
+	// of the expected one, and MissingRecords the number of data records
+	// which were not received according to the sequence numbers (RFC7011
+	// section 3.1). For NetFlow v9, sequence numbers count packets, so
+	// MissingRecords is the number of missing packets. Messages received out
+	// of order over UDP are also counted as gaps.
+	SequenceGaps   uint64
+	MissingRecords uint64
+}
+
+type domainCounters struct {
+	messages       uint64
+	records        uint64
+	lastExportTime uint32
+	// nextSequenceNum is the expected sequence number of the next message
+	nextSequenceNum uint32
+	sequenceGaps    uint64
+	missingRecords  uint64
+}
+
+// updateDomainCounters counts a message of the observation domain, with the
+// given number of data records, and the sequence number expected for the next
+// message.
+func (cp *CollectingProcess) updateDomainCounters(sessionAddress string, obsDomainID uint32, exportTime uint32, sequenceNum uint32, numRecords uint32, nextSequenceNum uint32) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	if cp.domainCounters == nil {
+		cp.domainCounters = make(map[templateDomain]*domainCounters)
+	}
+	domain := templateDomain{sessionAddress, obsDomainID}
+	counters, exist := cp.domainCounters[domain]
+	if !exist {
+		counters = &domainCounters{}
+		cp.domainCounters[domain] = counters
+	} else if gap := sequenceNum - counters.nextSequenceNum; gap != 0 && gap < 1<<31 {
+		// Sequence numbers wrap around, so a sequence number less than 2^31
+		// ahead of the expected one follows missing messages.
+		counters.sequenceGaps++
+		counters.missingRecords += uint64(gap)
+	}
+	counters.messages++
+	counters.records += uint64(numRecords)
+	counters.lastExportTime = exportTime
+	counters.nextSequenceNum = nextSequenceNum
+}
+
+// deleteDomainCounters deletes the counters of the observation domains of the
+// transport session. It is called with the mutex locked.
+func (cp *CollectingProcess) deleteDomainCounters(sessionAddress string) {
+	for domain := range cp.domainCounters {
+		if domain.sessionAddress == sessionAddress {
+			delete(cp.domainCounters, domain)
+		}
+	}
+}
+
+// getObservationDomainStats returns the counters of the observation domains,
+// sorted by exporter address and observation domain ID. It is called with the
+// mutex locked.
+func (cp *CollectingProcess) getObservationDomainStats() []ObservationDomainStats {
+	stats := make([]ObservationDomainStats, 0, len(cp.domainCounters))
+	for domain, counters := range cp.domainCounters {
+		stats = append(stats, ObservationDomainStats{
+			ExporterAddress: domain.sessionAddress,
+			ObsDomainID:     domain.obsDomainID,
+			Messages:        counters.messages,
+			Records:         counters.records,
+			LastExportTime:  time.Unix(int64(counters.lastExportTime), 0),
+			SequenceGaps:    counters.sequenceGaps,
+			MissingRecords:  counters.missingRecords,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].ExporterAddress != stats[j].ExporterAddress {
+			return stats[i].ExporterAddress < stats[j].ExporterAddress
+		}
+		return stats[i].ObsDomainID < stats[j].ObsDomainID
+	})
+	return stats
+}
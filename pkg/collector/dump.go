@@ -0,0 +1,60 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// dumpPacket dumps the message decoded from the packet, or the packet and the
+// decoding error, if less than dumpMessages messages were dumped for the
+// transport session.
+func (cp *CollectingProcess) dumpPacket(sessionAddress string, packet []byte, message *entities.Message, decodeErr error) {
+	cp.mutex.Lock()
+	if cp.dumpedMessages == nil {
+		cp.dumpedMessages = make(map[string]int)
+	}
+	count := cp.dumpedMessages[sessionAddress]
+	if count >= cp.dumpMessages {
+		cp.mutex.Unlock()
+		return
+	}
+	cp.dumpedMessages[sessionAddress] = count + 1
+	cp.mutex.Unlock()
+
+	out := new(bytes.Buffer)
+	fmt.Fprintf(out, "Message %d from %s:\n", count+1, sessionAddress)
+	if decodeErr != nil {
+		fmt.Fprintf(out, "Cannot be decoded: %v\n%s", decodeErr, hex.Dump(packet))
+	} else if err := message.Dump(out); err != nil {
+		klog.Errorf("Error when dumping message from %s: %v", sessionAddress, err)
+		return
+	}
+	if cp.dumpWriter == nil {
+		klog.Info(out.String())
+		return
+	}
+	cp.dumpMutex.Lock()
+	defer cp.dumpMutex.Unlock()
+	if _, err := cp.dumpWriter.Write(out.Bytes()); err != nil {
+		klog.Errorf("Error when dumping message from %s: %v", sessionAddress, err)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// duplicateKey identifies a message received over UDP. Messages retransmitted
+// by middleboxes have the same exporter, observation domain, sequence number
+// and length. The checksum of the message tells apart different messages with
+// the same sequence number, e.g. template messages, which do not increase it.
+type duplicateKey struct {
+	sessionAddress string
+	obsDomainID    uint32
+	sequenceNum    uint32
+	length         int
+	checksum       uint32
+}
+
+type duplicateEntry struct {
+	key      duplicateKey
+	received time.Time
+}
+
+// duplicateFilter remembers the messages received for the duplicate window.
+type duplicateFilter struct {
+	window time.Duration
+	mutex  sync.Mutex
+	keys   map[duplicateKey]bool
+	// entries are the keys in the order in which they were received, so that
+	// they are forgotten once the window has passed.
+	entries []duplicateEntry
+}
+
+func newDuplicateFilter(window time.Duration) *duplicateFilter {
+	return &duplicateFilter{
+		window: window,
+		keys:   make(map[duplicateKey]bool),
+	}
+}
+
+// isDuplicate returns whether the same message was received from the session
+// within the duplicate window, and remembers the message otherwise.
+func (f *duplicateFilter) isDuplicate(packet []byte, sessionAddress string, now time.Time) bool {
+	key, ok := getDuplicateKey(packet, sessionAddress)
+	if !ok {
+		return false
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	expired := 0
+	for expired < len(f.entries) && now.Sub(f.entries[expired].received) >= f.window {
+		delete(f.keys, f.entries[expired].key)
+		expired++
+	}
+	f.entries = f.entries[expired:]
+	if f.keys[key] {
+		return true
+	}
+	f.keys[key] = true
+	f.entries = append(f.entries, duplicateEntry{key, now})
+	return false
+}
+
+// getDuplicateKey returns the key of an IPFIX message or NetFlow v9 packet,
+// and false if the header is incomplete.
+func getDuplicateKey(packet []byte, sessionAddress string) (duplicateKey, bool) {
+	key := duplicateKey{sessionAddress: sessionAddress, length: len(packet)}
+	if isNetFlowV9Packet(packet) {
+		if len(packet) < 20 {
+			return key, false
+		}
+		key.sequenceNum = binary.BigEndian.Uint32(packet[12:16])
+		key.obsDomainID = binary.BigEndian.Uint32(packet[16:20])
+	} else {
+		if len(packet) < 16 {
+			return key, false
+		}
+		key.sequenceNum = binary.BigEndian.Uint32(packet[8:12])
+		key.obsDomainID = binary.BigEndian.Uint32(packet[12:16])
+	}
+	hash := fnv.New32a()
+	hash.Write(packet)
+	key.checksum = hash.Sum32()
+	return key, true
+}
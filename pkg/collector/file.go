@@ -0,0 +1,134 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// FileReader reads the messages of an IPFIX File (RFC5655), e.g. an archive
+// of the messages of an exporter, and decodes them into the same messages as
+// the collecting process, so that they can be replayed through the same
+// pipeline, e.g. an aggregation process. An IPFIX File is a sequence of IPFIX
+// messages, in which templates precede the data sets using them. The messages
+// of a file are decoded as the messages of a single transport session.
+type FileReader struct {
+	cp     *CollectingProcess
+	reader io.Reader
+	// sessionAddress is the address of the transport session of the file
+	sessionAddress string
+}
+
+// NewFileReader returns a FileReader decoding the messages read from the
+// reader. The input configures the decoding of the records as for the
+// collecting process, e.g. with RegistryOverrides, ProjectedElements, tags,
+// RecordFilter or RecordCallback. Address is the IP address of the exporter of
+// the messages, e.g. the exporter which produced the file, and may be empty.
+// Transport options are ignored.
+func NewFileReader(reader io.Reader, input CollectorInput) (*FileReader, error) {
+	exporterIP := input.Address
+	input.Address = ""
+	input.Protocol = ""
+	input.AddressFamily = AddressFamilyAny
+	input.Interface = ""
+	cp, err := InitCollectingProcess(input)
+	if err != nil {
+		return nil, err
+	}
+	return &FileReader{
+		cp:             cp,
+		reader:         reader,
+		sessionAddress: net.JoinHostPort(exporterIP, "0"),
+	}, nil
+}
+
+// ReadMessage reads and decodes the next message of the file. It returns
+// io.EOF at the end of the file, and io.ErrUnexpectedEOF if the file ends
+// within a message. If the sets of a message cannot be decoded, the error is
+// returned and the next call reads the following message, while the file
+// cannot be read further after an invalid message header. Messages without any data record
+// selected by the RecordFilter are skipped. With a RecordCallback, data
+// records are passed to the callback instead, so the whole file is read and
+// io.EOF is returned.
+func (fr *FileReader) ReadMessage() (*entities.Message, error) {
+	for {
+		data, err := fr.readMessageData()
+		if err != nil {
+			return nil, err
+		}
+		message, _, err := fr.cp.decodeMessage(bytes.NewBuffer(data), fr.sessionAddress)
+		if err != nil {
+			return nil, err
+		}
+		if message == nil {
+			continue
+		}
+		if message = fr.cp.filterRecords(message); message != nil {
+			return message, nil
+		}
+	}
+}
+
+// readMessageData returns the bytes of the next message of the file.
+func (fr *FileReader) readMessageData() ([]byte, error) {
+	header := make([]byte, entities.MsgHeaderLength)
+	if _, err := io.ReadFull(fr.reader, header); err != nil {
+		return nil, err
+	}
+	version := binary.BigEndian.Uint16(header[0:2])
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if version != 10 {
+		return nil, fmt.Errorf("invalid version %d of message in IPFIX file", version)
+	}
+	if length < entities.MsgHeaderLength+entities.SetHeaderLen {
+		return nil, fmt.Errorf("invalid length %d of message in IPFIX file", length)
+	}
+	data := make([]byte, length)
+	copy(data, header)
+	if _, err := io.ReadFull(fr.reader, data[entities.MsgHeaderLength:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// Replay reads the messages of the file until its end, and sends them to the
+// channel, e.g. the MessageChan of an aggregation process. It returns nil at
+// the end of the file, or the first error.
+func (fr *FileReader) Replay(msgChan chan<- *entities.Message) error {
+	for {
+		message, err := fr.ReadMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		msgChan <- message
+	}
+}
+
+// GetTemplates returns the templates read from the file.
+func (fr *FileReader) GetTemplates() []TemplateInfo {
+	return fr.cp.GetTemplates()
+}
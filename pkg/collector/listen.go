@@ -0,0 +1,129 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// AddressFamily selects the IP versions of the addresses on which the
+// collecting process listens.
+type AddressFamily uint8
+
+const (
+	// AddressFamilyAny listens on Address as given. A wildcard address, such
+	// as "0.0.0.0" or "[::]", listens on IPv4 and IPv6 addresses when the host
+	// supports both.
+	AddressFamilyAny AddressFamily = iota
+	// AddressFamilyIPv4 only listens on IPv4 addresses.
+	AddressFamilyIPv4
+	// AddressFamilyIPv6 only listens on IPv6 addresses, without accepting
+	// IPv4 exporters through IPv4-mapped addresses.
+	AddressFamilyIPv6
+	// AddressFamilyDual listens on IPv4 and IPv6 addresses with a single
+	// socket. It requires a wildcard address.
+	AddressFamilyDual
+)
+
+func (f AddressFamily) String() string {
+	switch f {
+	case AddressFamilyAny:
+		return "any"
+	case AddressFamilyIPv4:
+		return "ipv4"
+	case AddressFamilyIPv6:
+		return "ipv6"
+	case AddressFamilyDual:
+		return "dual"
+	}
+	return "unknown"
+}
+
+// validateListenOptions returns an error if the address family or the
+// interface of the input cannot be used by its collecting process.
+func validateListenOptions(input CollectorInput) error {
+	if input.AddressFamily > AddressFamilyDual {
+		return fmt.Errorf("unknown address family %d", input.AddressFamily)
+	}
+	if input.AddressFamily == AddressFamilyAny && input.Interface == "" {
+		return nil
+	}
+	if input.Protocol != "tcp" && input.Protocol != "udp" {
+		return fmt.Errorf("address family and interface are only supported by the TCP and UDP collecting processes")
+	}
+	if input.AddressFamily == AddressFamilyDual {
+		host, _, err := net.SplitHostPort(input.Address)
+		if err != nil {
+			return fmt.Errorf("invalid address %s: %v", input.Address, err)
+		}
+		if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+			return fmt.Errorf("dual-stack address family requires a wildcard address instead of %s", host)
+		}
+	}
+	if input.Interface != "" {
+		if !interfaceBindingSupported {
+			return fmt.Errorf("binding to network interfaces is only supported on Linux")
+		}
+		if input.Protocol == "udp" && input.IsEncrypted {
+			return fmt.Errorf("binding to network interfaces is not supported with DTLS")
+		}
+		if _, err := net.InterfaceByName(input.Interface); err != nil {
+			return fmt.Errorf("invalid interface %s: %v", input.Interface, err)
+		}
+	}
+	return nil
+}
+
+// listenNetwork returns the network, e.g. "tcp4", on which the collecting
+// process listens for the transport, "tcp" or "udp".
+func (cp *CollectingProcess) listenNetwork(transport string) string {
+	switch cp.addressFamily {
+	case AddressFamilyIPv4:
+		return transport + "4"
+	case AddressFamilyIPv6:
+		return transport + "6"
+	}
+	return transport
+}
+
+// listenConfig returns the config of the sockets of the collecting process,
+// which are bound to its interface, and to the same address as other sockets
+// if reusePort is set. TCP connections use the keep-alive period of the
+// collecting process.
+func (cp *CollectingProcess) listenConfig(reusePort bool) *net.ListenConfig {
+	config := &net.ListenConfig{KeepAlive: cp.tcpKeepAlive}
+	if !reusePort && cp.listenInterface == "" {
+		return config
+	}
+	config.Control = func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		if err := c.Control(func(fd uintptr) {
+			if reusePort {
+				if sockErr = setReusePort(fd); sockErr != nil {
+					return
+				}
+			}
+			if cp.listenInterface != "" {
+				sockErr = bindToInterface(fd, cp.listenInterface)
+			}
+		}); err != nil {
+			return err
+		}
+		return sockErr
+	}
+	return config
+}
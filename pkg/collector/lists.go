@@ -0,0 +1,75 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// listResolver resolves the elements and templates referred to by structured
+// data values (RFC6313) in the observation domain of a transport session.
+type listResolver struct {
+	cp              *CollectingProcess
+	sessionRegistry *registry.SessionRegistry
+	sessionAddress  string
+	obsDomainID     uint32
+}
+
+func (r *listResolver) GetInfoElementFromID(elementID uint16, enterpriseID uint32) (*entities.InfoElement, error) {
+	return r.sessionRegistry.GetInfoElementFromID(elementID, enterpriseID)
+}
+
+func (r *listResolver) GetTemplate(templateID uint16) ([]*entities.InfoElement, error) {
+	return r.cp.getTemplate(r.sessionAddress, r.obsDomainID, templateID)
+}
+
+// hasListElements returns whether the template has elements of structured
+// data types.
+func hasListElements(template []*entities.InfoElement) bool {
+	for _, element := range template {
+		if entities.IsListDataType(element.DataType) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeListValues replaces the undecoded content of the structured data
+// values in the records of the data set with their decoded lists. Values
+// which cannot be decoded, e.g. because they refer to an unknown template, are
+// left undecoded.
+func (cp *CollectingProcess) decodeListValues(dataSet entities.Set, sessionRegistry *registry.SessionRegistry, sessionAddress string, obsDomainID uint32) {
+	resolver := &listResolver{cp, sessionRegistry, sessionAddress, obsDomainID}
+	for _, record := range dataSet.GetRecords() {
+		for _, ie := range record.GetOrderedElementList() {
+			if !entities.IsListDataType(ie.Element.DataType) {
+				continue
+			}
+			content, ok := ie.Value.([]byte)
+			if !ok {
+				continue
+			}
+			value, err := entities.DecodeListValue(ie.Element.DataType, content, resolver)
+			if err != nil {
+				klog.V(2).Infof("Cannot decode value of element %s from exporter %s: %v", ie.Element.Name, sessionAddress, err)
+				continue
+			}
+			ie.Value = value
+		}
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+	"github.com/vmware/go-ipfix/pkg/util"
+)
+
+const (
+	// NetFlowV9Version is the version of NetFlow v9 packets (RFC3954), which
+	// are decoded along with IPFIX messages.
+	NetFlowV9Version uint16 = 9
+	// netflowV9HeaderLength is the length of the header of NetFlow v9 packets.
+	netflowV9HeaderLength = 20
+	// netflowV9FlowSetHeaderLength is the length of the header of flowsets.
+	netflowV9FlowSetHeaderLength = 4
+	// Flowsets with IDs from netflowV9OptionsTemplateFlowSetID + 1 to 255 are
+	// reserved.
+	netflowV9TemplateFlowSetID        uint16 = 0
+	netflowV9OptionsTemplateFlowSetID uint16 = 1
+	netflowV9MinDataFlowSetID         uint16 = 256
+)
+
+// netflowV9ScopeNames are the names of the elements of the scope fields of
+// options templates, by scope field type (RFC3954 section 6.1). Scope field
+// types are not Information Elements, so they are decoded as octet arrays.
+var netflowV9ScopeNames = map[uint16]string{
+	1: "netflowV9ScopeSystem",
+	2: "netflowV9ScopeInterface",
+	3: "netflowV9ScopeLineCard",
+	4: "netflowV9ScopeCache",
+	5: "netflowV9ScopeTemplate",
+}
+
+// isNetFlowV9Packet returns whether the packet starts with the version of
+// NetFlow v9.
+func isNetFlowV9Packet(packet []byte) bool {
+	return len(packet) >= 2 && binary.BigEndian.Uint16(packet) == NetFlowV9Version
+}
+
+// decodeNetFlowV9Packet decodes a NetFlow v9 packet into one message per
+// flowset. NetFlow v9 field types are the IDs of the IANA Information
+// Elements, so the records are decoded with the registry, as IPFIX records.
+// The source ID of the packet is the observation domain ID of the messages.
+// Data flowsets whose template is unknown are skipped and counted in the
+// MissingTemplateDataSets stat, as the templates are often received after the
+// first data flowsets.
+func (cp *CollectingProcess) decodeNetFlowV9Packet(packetBuffer *bytes.Buffer, sessionAddress string) ([]*entities.Message, error) {
+	packetLen := packetBuffer.Len()
+	var version, count uint16
+	var sysUptime, unixSecs, sequenceNum, sourceID uint32
+	if err := util.Decode(packetBuffer, binary.BigEndian, &version, &count, &sysUptime, &unixSecs, &sequenceNum, &sourceID); err != nil {
+		return nil, err
+	}
+	exportAddress := getExportAddress(sessionAddress)
+	sessionRegistry := cp.getSessionRegistry(sessionAddress, exportAddress)
+	obsDomainName := cp.resolveObsDomainName(exportAddress, sourceID)
+	messages := make([]*entities.Message, 0)
+	for packetBuffer.Len() >= netflowV9FlowSetHeaderLength {
+		var flowSetID, flowSetLen uint16
+		if err := util.Decode(packetBuffer, binary.BigEndian, &flowSetID, &flowSetLen); err != nil {
+			return nil, err
+		}
+		if flowSetLen < netflowV9FlowSetHeaderLength || int(flowSetLen)-netflowV9FlowSetHeaderLength > packetBuffer.Len() {
+			return nil, fmt.Errorf("error in decoding NetFlow v9 packet: invalid length %d of flowset %d", flowSetLen, flowSetID)
+		}
+		flowSetBuffer := bytes.NewBuffer(packetBuffer.Next(int(flowSetLen) - netflowV9FlowSetHeaderLength))
+		var set entities.Set
+		var err error
+		switch {
+		case flowSetID == netflowV9TemplateFlowSetID || flowSetID == netflowV9OptionsTemplateFlowSetID:
+			set, err = cp.decodeNetFlowV9TemplateFlowSet(flowSetBuffer, sourceID, flowSetID == netflowV9OptionsTemplateFlowSetID, sessionRegistry, sessionAddress)
+		case flowSetID >= netflowV9MinDataFlowSetID:
+			set, err = cp.decodeNetFlowV9DataFlowSet(flowSetBuffer, sourceID, flowSetID, sessionRegistry, sessionAddress)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error in decoding NetFlow v9 packet: %v", err)
+		}
+		if set == nil {
+			continue
+		}
+		if err := cp.addTags(set, exportAddress, obsDomainName); err != nil {
+			return nil, err
+		}
+		message := entities.NewMessage(true)
+		message.SetVersion(version)
+		message.SetMessageLen(uint16(packetLen))
+		message.SetExportTime(unixSecs)
+		message.SetSequenceNum(sequenceNum)
+		message.SetObsDomainID(sourceID)
+		message.SetExportAddress(exportAddress)
+		message.SetObsDomainName(obsDomainName)
+		message.AddSet(set)
+		messages = append(messages, message)
+	}
+	var numDataRecords uint32
+	for _, message := range messages {
+		if message.GetSet().GetSetType() == entities.Data {
+			numDataRecords += message.GetSet().GetNumberOfRecords()
+		}
+	}
+	// The sequence number of NetFlow v9 counts export packets.
+	cp.updateDomainCounters(sessionAddress, sourceID, unixSecs, sequenceNum, numDataRecords, sequenceNum+1)
+	return messages, nil
+}
+
+// decodeNetFlowV9TemplateFlowSet decodes all the templates of a template or
+// options template flowset. The templates keep the lengths of their fields,
+// which may be shorter than the lengths of the registry.
+func (cp *CollectingProcess) decodeNetFlowV9TemplateFlowSet(flowSetBuffer *bytes.Buffer, sourceID uint32, isOptions bool, sessionRegistry *registry.SessionRegistry, sessionAddress string) (entities.Set, error) {
+	setType := entities.Template
+	if isOptions {
+		setType = entities.OptionsTemplate
+	}
+	templateSet := entities.NewSet(true)
+	if err := templateSet.PrepareSet(setType, entities.TemplateSetID); err != nil {
+		return nil, err
+	}
+	// The flowset may end with padding, which is shorter than any template.
+	for flowSetBuffer.Len() >= 4 {
+		var templateID, fieldCount, scopeFieldCount uint16
+		if isOptions {
+			var scopeLen, optionLen uint16
+			if err := util.Decode(flowSetBuffer, binary.BigEndian, &templateID, &scopeLen, &optionLen); err != nil {
+				return nil, err
+			}
+			scopeFieldCount = scopeLen / 4
+			fieldCount = scopeFieldCount + optionLen/4
+		} else if err := util.Decode(flowSetBuffer, binary.BigEndian, &templateID, &fieldCount); err != nil {
+			return nil, err
+		}
+		elementsWithValue := make([]*entities.InfoElementWithValue, 0, fieldCount)
+		for i := 0; i < int(fieldCount); i++ {
+			var fieldType, fieldLen uint16
+			if err := util.Decode(flowSetBuffer, binary.BigEndian, &fieldType, &fieldLen); err != nil {
+				return nil, err
+			}
+			element := getNetFlowV9Element(fieldType, fieldLen, i < int(scopeFieldCount), sessionRegistry)
+			elementsWithValue = append(elementsWithValue, entities.NewInfoElementWithValue(element, nil))
+		}
+		var err error
+		if isOptions {
+			err = templateSet.AddOptionsTemplateRecord(elementsWithValue, scopeFieldCount, templateID)
+		} else {
+			err = templateSet.AddRecord(elementsWithValue, templateID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if changeType, changed := cp.addTemplate(sessionAddress, sourceID, templateID, elementsWithValue, scopeFieldCount); changed {
+			elements, _ := cp.getTemplate(sessionAddress, sourceID, templateID)
+			cp.notifyTemplateChange(TemplateChange{
+				Type:            changeType,
+				ExporterAddress: sessionAddress,
+				ObsDomainID:     sourceID,
+				TemplateID:      templateID,
+				Elements:        elements,
+				ScopeFieldCount: scopeFieldCount,
+			})
+		}
+	}
+	return templateSet, nil
+}
+
+// getNetFlowV9Element returns the element of a template field, with the length
+// of the field. Fields whose type is not in the registry, or whose length
+// cannot be decoded with the data type of the registry, are decoded as octet
+// arrays.
+func getNetFlowV9Element(fieldType uint16, fieldLen uint16, isScope bool, sessionRegistry *registry.SessionRegistry) *entities.InfoElement {
+	if isScope {
+		name, exist := netflowV9ScopeNames[fieldType]
+		if !exist {
+			name = fmt.Sprintf("netflowV9Scope%d", fieldType)
+		}
+		return entities.NewInfoElement(name, fieldType, entities.OctetArray, registry.IANAEnterpriseID, fieldLen)
+	}
+	element, err := sessionRegistry.GetInfoElementFromID(fieldType, registry.IANAEnterpriseID)
+	if err == nil && (element.Len == fieldLen || element.Len == entities.VariableLength || (isIntegerDataType(element.DataType) && fieldLen < element.Len)) {
+		fieldElement := *element
+		fieldElement.Len = fieldLen
+		return &fieldElement
+	}
+	return entities.NewInfoElement(fmt.Sprintf("netflowV9Field%d", fieldType), fieldType, entities.OctetArray, registry.IANAEnterpriseID, fieldLen)
+}
+
+func isIntegerDataType(dataType entities.IEDataType) bool {
+	switch dataType {
+	case entities.Unsigned8, entities.Unsigned16, entities.Unsigned32, entities.Unsigned64,
+		entities.Signed8, entities.Signed16, entities.Signed32, entities.Signed64:
+		return true
+	}
+	return false
+}
+
+// decodeNetFlowV9DataFlowSet decodes the records of a data flowset. It returns
+// a nil set if the template is unknown.
+func (cp *CollectingProcess) decodeNetFlowV9DataFlowSet(flowSetBuffer *bytes.Buffer, sourceID uint32, templateID uint16, sessionRegistry *registry.SessionRegistry, sessionAddress string) (entities.Set, error) {
+	template, err := cp.getTemplate(sessionAddress, sourceID, templateID)
+	if err != nil {
+		atomic.AddUint64(&cp.stats.missingTemplateDataSets, 1)
+		return nil, nil
+	}
+	dataSet := entities.NewSet(true)
+	if err := dataSet.PrepareSet(entities.Data, templateID); err != nil {
+		return nil, err
+	}
+	templateScopeFieldCount := cp.getScopeFieldCount(sessionAddress, sourceID, templateID)
+	// All fields have fixed lengths, and the flowset may end with padding.
+	recordLen := getMinDataRecordLen(template)
+	for recordLen > 0 && flowSetBuffer.Len() >= recordLen {
+		elements := make([]*entities.InfoElementWithValue, 0, len(template))
+		var scopeFieldCount uint16
+		for i, fieldElement := range template {
+			val := flowSetBuffer.Next(int(fieldElement.Len))
+			if cp.projectedElements != nil && !cp.projectedElements[fieldElement.Name] {
+				continue
+			}
+			element, val := getNetFlowV9RecordValue(fieldElement, val, sessionRegistry)
+			elements = append(elements, entities.NewInfoElementWithValue(element, bytes.NewBuffer(val)))
+			if i < int(templateScopeFieldCount) {
+				scopeFieldCount++
+			}
+		}
+		if scopeFieldCount > 0 {
+			err = dataSet.AddOptionsDataRecord(elements, scopeFieldCount, templateID)
+		} else {
+			err = dataSet.AddRecord(elements, templateID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	cp.updateTemplateUsage(sessionAddress, sourceID, templateID, dataSet.GetNumberOfRecords())
+	return dataSet, nil
+}
+
+// getNetFlowV9RecordValue returns the element of the registry for the field
+// of the template, and the value of the field encoded for it: integers shorter
+// than the element are extended, and the trailing NUL bytes of strings are
+// trimmed, as strings are padded to the length of their field.
+func getNetFlowV9RecordValue(fieldElement *entities.InfoElement, val []byte, sessionRegistry *registry.SessionRegistry) (*entities.InfoElement, []byte) {
+	element, err := sessionRegistry.GetInfoElementFromID(fieldElement.ElementId, registry.IANAEnterpriseID)
+	if err != nil || element.DataType != fieldElement.DataType || element.Name != fieldElement.Name {
+		return fieldElement, val
+	}
+	if element.DataType == entities.String {
+		return element, bytes.TrimRight(val, "\x00")
+	}
+	if element.Len == entities.VariableLength || len(val) >= int(element.Len) {
+		return element, val
+	}
+	extended := make([]byte, element.Len)
+	offset := int(element.Len) - len(val)
+	copy(extended[offset:], val)
+	isSigned := element.DataType == entities.Signed8 || element.DataType == entities.Signed16 ||
+		element.DataType == entities.Signed32 || element.DataType == entities.Signed64
+	if isSigned && len(val) > 0 && val[0]&0x80 != 0 {
+		for i := 0; i < offset; i++ {
+			extended[i] = 0xff
+		}
+	}
+	return element, extended
+}
@@ -18,21 +18,43 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"k8s.io/klog/v2"
 
 	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/filter"
 	"github.com/vmware/go-ipfix/pkg/registry"
 	"github.com/vmware/go-ipfix/pkg/util"
 )
 
+// setAlignment is the alignment of data sets padded by exporters, which pad
+// sets with fewer zeros than setAlignment.
+const setAlignment = 4
+
+// templateDomain identifies the templates of an observation domain in a
+// transport session. Exporters may use the same observation domain and
+// template IDs with different templates, so templates are kept per session
+// (RFC7011 section 8).
+type templateDomain struct {
+	sessionAddress string
+	obsDomainID    uint32
+}
+
 type CollectingProcess struct {
-	// for each obsDomainID, there is a map of templates
-	templatesMap map[uint32]map[uint16][]*entities.InfoElement
+	// for each observation domain of every exporter session, there is a map
+	// of templates
+	templatesMap map[templateDomain]map[uint16][]*entities.InfoElement
+	// usage of the templates by every exporter session
+	templateUsageMap map[templateUsageKey]*templateUsage
+	// templates unused for templateIdleTimeout are pruned; 0 disables pruning
+	templateIdleTimeout time.Duration
 	// mutex allows multiple readers or one writer at the same time
 	mutex sync.RWMutex
 	// template lifetime
@@ -49,62 +71,537 @@ type CollectingProcess struct {
 	stopChan chan bool
 	// messageChan is the channel to output message
 	messageChan chan *entities.Message
+	// overloadPolicy decides what happens when messageChan is full
+	overloadPolicy OverloadPolicy
+	// transportOverloadPolicies override overloadPolicy for the sessions of
+	// the given transports
+	transportOverloadPolicies map[string]OverloadPolicy
 	// maps each client to its client handler (required channels)
 	clients map[string]*clientHandler
+	// maps each client to the Information Elements learned from its type records
+	// or configured as overrides for the exporter
+	sessionRegistries map[string]*registry.SessionRegistry
+	// registryOverrides maps exporter IP address to Information Elements which
+	// override the registry for its transport sessions
+	registryOverrides map[string][]*entities.InfoElement
+	// observationPointTags are attached to every decoded record
+	observationPointTags []tag
+	// sourceTags are attached to the decoded records from exporters in a CIDR
+	sourceTags []sourceTags
+	// obsDomainNameResolver resolves the names of the observation domains,
+	// which are attached to the decoded records with obsDomainNameElement
+	obsDomainNameResolver ObservationDomainNameResolver
+	obsDomainNameElement  *entities.InfoElement
+	// stats contains the counters of the collecting process
+	stats collectorStats
+	// subscriptions receive the decoded messages selected by their filters
+	subscriptions      []*Subscription
+	subscriptionsMutex sync.RWMutex
+	// taps receive copies of the messages before they are decoded
+	taps      []*Tap
+	tapsMutex sync.RWMutex
 	// isEncrypted indicates whether to use TLS/DTLS for communication
 	isEncrypted bool
 	// caCert, serverCert and serverKey are for storing encryption info when using TLS/DTLS
 	caCert     []byte
 	serverCert []byte
 	serverKey  []byte
+	// allowedClientNames are the names of the only client certificates
+	// accepted, if not nil
+	allowedClientNames map[string]bool
+	// allowPlainTCP indicates whether to accept plain TCP connections along
+	// with TLS connections on the same port
+	allowPlainTCP bool
+	// alpnProtocols are the application protocols supported with TLS
+	alpnProtocols []string
+	// dumpMessages is the number of messages dumped per transport session
+	dumpMessages int
+	// dumpWriter receives the dumps; they are logged if nil
+	dumpWriter io.Writer
+	dumpMutex  sync.Mutex
+	// dumpedMessages maps each client to the number of messages dumped
+	dumpedMessages map[string]int
+	// projectedElements are the only elements decoded in data records, if not
+	// nil
+	projectedElements map[string]bool
+	// templateChangeCallback is called when a template changes
+	templateChangeCallback TemplateChangeCallback
+	// allowCompression accepts compression requested by exporters over TCP
+	allowCompression bool
+	// latencyProbes measures the latency probes received, if not nil
+	latencyProbes *entities.LatencyProbeTracker
+	// maxConnections is the maximum number of TCP connections, or 0 for no
+	// limit
+	maxConnections int
+	// connections is the number of TCP connections, counted if
+	// maxConnections is not 0
+	connections int32
+	// connectionBufferSize is the size of the read buffer of every TCP
+	// connection
+	connectionBufferSize int
+	// decodeWorkers is the number of goroutines decoding the messages
+	// received over UDP, or 0 to decode them in the goroutine of every session
+	decodeWorkers int
+	// decodeQueueSize is the capacity of the queue of every decode worker
+	decodeQueueSize int
+	// decodePool decodes the messages received over UDP while the server is
+	// running, if decodeWorkers is not 0
+	decodePool *decodePool
+	// allowedExporters are the subnets of the only exporters accepted, if not
+	// nil
+	allowedExporters []*net.IPNet
+	// malformedCapture captures the messages which cannot be decoded, if not
+	// nil
+	malformedCapture *malformedCapture
+	// zeroCopyDecoding adds data records with entities.ValueView values
+	zeroCopyDecoding bool
+	// udpListeners is the number of UDP sockets bound with SO_REUSEPORT
+	udpListeners int
+	// recordCallback is called for every data record instead of sending
+	// messages to the message channel, if not nil
+	recordCallback RecordCallback
+	// recordFilter selects the data records which are delivered, if not nil
+	recordFilter filter.Predicate
+	// addressFamily selects the IP versions of the listening addresses
+	addressFamily AddressFamily
+	// listenInterface is the network interface to which the sockets are
+	// bound, if not empty
+	listenInterface string
+	// tcpKeepAlive is the period of TCP keep-alive probes, 0 for the default
+	// period, or negative to disable them
+	tcpKeepAlive time.Duration
+	// connectionIdleTimeout is the duration after which TCP connections
+	// without traffic are closed, or 0
+	connectionIdleTimeout time.Duration
+	// getServerCertificate returns the certificate for TLS and DTLS, instead
+	// of serverCert and serverKey, if not nil
+	getServerCertificate util.CertificateGetter
+	// domainCounters are the counters of the observation domains of every
+	// transport session
+	domainCounters map[templateDomain]*domainCounters
+	// duplicates discards duplicate UDP messages, if not nil
+	duplicates *duplicateFilter
+	// clockSkew estimates the clock skews of the exporters, if not nil
+	clockSkew *clockSkewEstimator
+	// listening is 1 while the collecting process listens for exporters
+	listening int32
 }
 
 type CollectorInput struct {
-	// Address needs to be provided in hostIP:port format.
+	// Address needs to be provided in hostIP:port format, or is the path of
+	// the socket with Unix domain sockets.
 	Address string
 	// Protocol needs to be provided in lower case format.
-	// We support "tcp" and "udp" protocols.
+	// We support "tcp", "udp" and "sctp" protocols. SCTP is only supported on
+	// Linux, without encryption. Exporters on the same host can use Unix
+	// domain sockets with "unix" (stream) and "unixgram" (datagram), without
+	// encryption and allowed exporters. Unix stream connections are processed
+	// like TCP connections, and Unix datagrams like UDP datagrams.
 	Protocol      string
 	MaxBufferSize uint16
-	TemplateTTL   uint32
-	IsEncrypted   bool
+	// TemplateTTL is the time in seconds after which templates received over
+	// UDP expire, unless they are received again. The default is
+	// entities.TemplateTTL, 3 times the template refresh timeout of exporters.
+	TemplateTTL uint32
+	// TemplateIdleTimeout is the period in seconds after which templates that
+	// are not used by any data record are removed. 0 disables pruning.
+	TemplateIdleTimeout uint32
+	// IsEncrypted enables TLS with "tcp" and DTLS with "udp". With both, if
+	// CACert is given, exporters must present a certificate signed by it.
+	IsEncrypted bool
 	// TODO: group following fields into struct to be reuse in exporter
 	CACert     []byte
 	ServerCert []byte
 	ServerKey  []byte
 	IsIPv6     bool
+	// AllowedClientNames restricts the exporters allowed to connect with TLS
+	// or DTLS to those whose client certificate, signed by CACert, has one of
+	// the names as common name or subject alternative name (DNS name, email
+	// address, IP address or URI). Names are matched exactly. It requires
+	// CACert.
+	AllowedClientNames []string
+	// RegistryOverrides maps exporter IP address to Information Elements which
+	// take precedence over the registry for transport sessions from that
+	// exporter. This allows exporters that use the same element ID with
+	// different meanings to send to the same collector.
+	RegistryOverrides map[string][]*entities.InfoElement
+	// MessageChanSize is the capacity of the channel returned by GetMsgChan.
+	// The channel is unbuffered by default. A capacity bounds the messages
+	// waiting for the consumer, so that the overload policy applies when it
+	// falls behind.
+	MessageChanSize int
+	// OverloadPolicy decides what happens when the channel returned by
+	// GetMsgChan is full. See OverloadPolicy.
+	OverloadPolicy OverloadPolicy
+	// TransportOverloadPolicies map transport protocols ("tcp", "udp",
+	// "sctp", "unix" or "unixgram") to the OverloadPolicy of the transport sessions using them,
+	// overriding OverloadPolicy. TLS and DTLS sessions use the policy of TCP
+	// and UDP.
+	// This allows e.g. applying backpressure to TCP exporters while dropping
+	// UDP messages.
+	TransportOverloadPolicies map[string]OverloadPolicy
+	// ObservationPointTags are Information Elements with static values (e.g.
+	// site or cluster name) which are attached to every decoded record, so
+	// that records are self-describing when several collectors are deployed.
+	ObservationPointTags []*entities.InfoElementWithValue
+	// SourceTags are attached to the records from exporters in the given
+	// CIDRs, after the ObservationPointTags. See SourceTags.
+	SourceTags []SourceTags
+	// ObservationDomainNameResolver is optional. If given, the names of the
+	// observation domains are set in the decoded messages (see
+	// Message.GetObsDomainName), and attached to every record with the
+	// observationDomainName element, after the tags, so that the records
+	// exported downstream carry them. The element is attached with an empty
+	// value if the name is unknown.
+	ObservationDomainNameResolver ObservationDomainNameResolver
+	// AllowPlainTCP accepts plain IPFIX/TCP connections along with TLS
+	// connections on the same port, when IsEncrypted is true. TLS connections
+	// are recognized by the first byte of the TLS handshake. This eases
+	// migrating exporters to TLS gradually.
+	AllowPlainTCP bool
+	// ALPNProtocols are the application protocols supported with TLS, in
+	// order of preference. TLS connections from exporters offering other
+	// protocols with ALPN are rejected, so that the port can be shared safely
+	// with other TLS services behind a proxy routing by ALPN.
+	ALPNProtocols []string
+	// DumpMessages is the number of messages dumped at the beginning of every
+	// transport session, to debug interoperability issues with exporters.
+	// Decoded messages are dumped with Message.Dump, and messages which cannot
+	// be decoded are dumped as raw bytes with the decoding error.
+	DumpMessages int
+	// DumpWriter receives the dumps. If nil, they are logged.
+	DumpWriter io.Writer
+	// ProjectedElements are the names of the only elements decoded in data
+	// records, when downstream consumers only need a few of the exported
+	// elements. Other elements are skipped without being decoded, and are not
+	// in the records of the output messages. If empty, all elements are
+	// decoded. Type records (RFC5610) are always decoded in full.
+	ProjectedElements []string
+	// TemplateChangeCallback is called when a template is added, replaced,
+	// withdrawn or expired. See TemplateChangeCallback.
+	TemplateChangeCallback TemplateChangeCallback
+	// AllowCompression accepts framed zstd compression of TCP connections
+	// when requested by exporters of this library (see
+	// ExporterInput.Compression), e.g. on WAN links between aggregators.
+	// Requests are rejected otherwise, and messages are sent uncompressed.
+	AllowCompression bool
+	// MeasureLatencyProbes enables measuring the latency and the loss of the
+	// latency probes sent by exporters (see ExporterInput.LatencyProbeInterval)
+	// when they are decoded. They are reported in Stats.LatencyProbes. Probes
+	// are still sent to the message channel, so that they can be measured
+	// further down the pipeline.
+	MeasureLatencyProbes bool
+	// MaxConnections is the maximum number of concurrent TCP connections,
+	// including TLS connections, to bound the resources used by exporters.
+	// Connections above it are closed as soon as they are accepted, and are
+	// counted in the RejectedConnections stat. If 0, there is no limit.
+	MaxConnections int
+	// ConnectionBufferSize is the size of the buffer in which the messages of
+	// every TCP connection are read. It defaults to MaxBufferSize.
+	ConnectionBufferSize int
+	// DecodeWorkers is the number of goroutines decoding the messages
+	// received over UDP and DTLS. If it is not 0, messages are read from the
+	// socket into the bounded queues of the workers, so that a slow consumer
+	// of the message channel does not stop the socket from being read and
+	// the kernel from dropping datagrams. Messages are dropped when the queue
+	// of their worker is full, and are counted in the DroppedPackets stat.
+	// The messages of every session are decoded in order by the same worker.
+	// If 0, the messages of every session are decoded as they are read.
+	DecodeWorkers int
+	// DecodeQueueSize is the capacity of the queue of every decode worker. It
+	// defaults to 128.
+	DecodeQueueSize int
+	// AllowedExporters are the CIDRs of the subnets of the only exporters
+	// accepted, e.g. "10.0.0.0/8". TCP, TLS and SCTP connections and DTLS
+	// associations from other exporters are closed as soon as they are
+	// accepted, and counted in the DeniedConnections stat. UDP datagrams from
+	// other exporters are discarded, and counted in the DeniedDatagrams stat.
+	// If empty, all exporters are accepted.
+	AllowedExporters []string
+	// MalformedCaptureSize is the number of the most recent messages which
+	// failed to be decoded kept with their exporter address, time and
+	// decoding error, to diagnose exporters offline. They are returned by
+	// GetMalformedMessages and the HTTP API. If 0, they are not kept.
+	MalformedCaptureSize int
+	// MalformedCaptureWriter is optional. If given, every message which fails
+	// to be decoded is written to it with WriteMalformedMessage, e.g. to a
+	// capture file.
+	MalformedCaptureWriter io.Writer
+	// ZeroCopyDecoding decodes the data records of IPFIX messages without
+	// copying their values: the elements have entities.ValueView values,
+	// which refer to the received messages, instead of values of their data
+	// types. This avoids most of the allocations of decoding, for consumers
+	// which only need a few values or forward the records. Consumers call
+	// Materialize on the messages or records to decode their values. Type
+	// records (RFC5610) and records with structured data types (RFC6313) are
+	// always decoded.
+	ZeroCopyDecoding bool
+	// UDPListeners is the number of UDP sockets bound to the address with
+	// SO_REUSEPORT, each read by its own goroutine, so that the collector
+	// receives high packet rates on several cores. The datagrams of an
+	// exporter are received by the same socket. It is only supported on
+	// Linux, and not with DTLS. If 0 or 1, a single socket is used.
+	UDPListeners int
+	// RecordCallback is called for every decoded data record instead of
+	// sending messages to the message channel and to the subscriptions, for
+	// consumers which only need the records. No entities.Message is built for
+	// IPFIX messages. It cannot be used with MeasureLatencyProbes. See
+	// RecordCallback.
+	RecordCallback RecordCallback
+	// RecordFilter selects the data records delivered to the message channel,
+	// the subscriptions and the record callback, e.g. a filter.Expression
+	// such as "protocolIdentifier == 6 && destinationTransportPort == 443".
+	// Other records are dropped after decoding and counted in the
+	// FilteredRecords stat, and messages without any selected record are not
+	// delivered. It cannot be used with ZeroCopyDecoding. If nil, all records
+	// are delivered.
+	RecordFilter filter.Predicate
+	// AddressFamily selects the IP versions of the addresses on which the TCP
+	// and UDP collecting processes listen, e.g. to only accept IPv6 exporters
+	// on a wildcard address. See AddressFamily.
+	AddressFamily AddressFamily
+	// Interface is the name of the network interface to which the sockets of
+	// the TCP and UDP collecting processes are bound, e.g. "eth1", so that
+	// only exporters reaching the collector through that interface are
+	// received on hosts with multiple NICs. It is only supported on Linux,
+	// and not with DTLS. If empty, sockets are not bound to an interface.
+	Interface string
+	// TCPKeepAlive is the period in seconds of the keep-alive probes of TCP
+	// connections, including TLS connections, which detect dead exporters,
+	// e.g. behind NATs. If 0, the default period of 15 seconds is used. If
+	// negative, keep-alive probes are disabled.
+	TCPKeepAlive int
+	// ConnectionIdleTimeout is the period in seconds after which TCP
+	// connections, including TLS connections, from which nothing was received
+	// are closed, to free the resources of dead exporters. They are counted
+	// in the ClosedIdleConnections stat. 0 disables the timeout.
+	ConnectionIdleTimeout uint32
+	// GetServerCertificate returns the certificate presented to exporters
+	// instead of ServerCert and ServerKey, e.g. the GetCertificate method of a
+	// util.CertificateReloader watching the certificate files. With TLS, it is
+	// called for every handshake, so that rotated certificates are used
+	// without restarting the collecting process. With DTLS, it is only called
+	// when the collecting process starts.
+	GetServerCertificate util.CertificateGetter
+	// DuplicateWindow is the period in seconds for which messages received
+	// over UDP are remembered, to discard the same messages retransmitted by
+	// middleboxes. Messages with the same exporter, observation domain,
+	// sequence number, length and contents are duplicates. They are counted in
+	// the SuppressedDuplicates stat. 0 disables duplicate suppression.
+	DuplicateWindow uint32
+	// EstimateClockSkew estimates the clock skew of every exporter from the
+	// export times of its messages and the times at which they are received,
+	// and attaches it to the messages (see Message.GetClockSkew). The
+	// estimate includes the transit delay of the messages. It is not
+	// supported with a RecordCallback.
+	EstimateClockSkew bool
+	// NormalizeFlowTimes rewrites the flowStartSeconds, flowEndSeconds,
+	// flowStartMilliseconds and flowEndMilliseconds elements of the data
+	// records into the time of the collector, by subtracting the clock skew of
+	// their exporter, so that records of skewed exporters can be aggregated
+	// consistently. It requires EstimateClockSkew, and is not supported with
+	// ZeroCopyDecoding.
+	NormalizeFlowTimes bool
+}
+
+// OverloadPolicy decides what the collector does with decoded messages when
+// the message channel is full.
+type OverloadPolicy uint8
+
+const (
+	// OverloadPolicyBlock stops reading from the exporter until the message
+	// can be added to the channel. For TCP this applies backpressure to the
+	// exporter through flow control. For UDP, datagrams are dropped by the
+	// kernel once the socket receive buffer is full, and are not counted.
+	OverloadPolicyBlock OverloadPolicy = iota
+	// OverloadPolicyDrop drops the message and counts it in the
+	// DroppedMessages stat, so that reading from the exporter never blocks.
+	// The messages already in the channel are kept.
+	OverloadPolicyDrop
+	// OverloadPolicyDropOldest drops the oldest message in the channel to make
+	// room for the message, and counts it in the DroppedMessages stat, so that
+	// consumers get the most recent messages when they fall behind. It
+	// requires a MessageChanSize.
+	OverloadPolicyDropOldest
+)
+
+// validateOverloadPolicy returns an error if the overload policy is unknown, or
+// requires a message channel with a capacity.
+func validateOverloadPolicy(policy OverloadPolicy, messageChanSize int) error {
+	if policy > OverloadPolicyDropOldest {
+		return fmt.Errorf("unknown overload policy %d", policy)
+	}
+	if policy == OverloadPolicyDropOldest && messageChanSize <= 0 {
+		return fmt.Errorf("overload policy dropping the oldest messages requires a message channel size")
+	}
+	return nil
 }
 
 type clientHandler struct {
 	packetChan chan *bytes.Buffer
 	errChan    chan bool
+	// overloadPolicy applies to the messages of the transport session
+	overloadPolicy OverloadPolicy
+	// startTime is the time at which the transport session started
+	startTime time.Time
+	// stats contains the counters of the transport session
+	stats sessionCounters
 }
 
 func InitCollectingProcess(input CollectorInput) (*CollectingProcess, error) {
+	observationPointTags, err := encodeTags(input.ObservationPointTags)
+	if err != nil {
+		return nil, err
+	}
+	sourceTags, err := parseSourceTags(input.SourceTags)
+	if err != nil {
+		return nil, err
+	}
+	obsDomainNameElement, err := getObsDomainNameElement(input.ObservationDomainNameResolver)
+	if err != nil {
+		return nil, err
+	}
+	allowedExporters, err := parseAllowedExporters(input.AllowedExporters)
+	if err != nil {
+		return nil, err
+	}
+	if input.MaxConnections < 0 || input.ConnectionBufferSize < 0 {
+		return nil, fmt.Errorf("max connections and connection buffer size cannot be < 0")
+	}
+	if input.MalformedCaptureSize < 0 {
+		return nil, fmt.Errorf("malformed capture size cannot be < 0")
+	}
+	if input.DecodeWorkers < 0 || input.DecodeQueueSize < 0 {
+		return nil, fmt.Errorf("decode workers and decode queue size cannot be < 0")
+	}
+	if input.UDPListeners < 0 {
+		return nil, fmt.Errorf("UDP listeners cannot be < 0")
+	}
+	if input.UDPListeners > 1 && (input.Protocol != "udp" || input.IsEncrypted) {
+		return nil, fmt.Errorf("multiple listeners are only supported by the UDP collecting process without DTLS")
+	}
+	if (input.Protocol == "unix" || input.Protocol == "unixgram") && (input.IsEncrypted || len(input.AllowedExporters) > 0) {
+		return nil, fmt.Errorf("encryption and allowed exporters are not supported with Unix domain sockets")
+	}
+	if input.RecordCallback != nil && input.MeasureLatencyProbes {
+		return nil, fmt.Errorf("latency probes cannot be measured with a record callback")
+	}
+	if input.RecordFilter != nil && input.ZeroCopyDecoding {
+		return nil, fmt.Errorf("records cannot be filtered with zero-copy decoding")
+	}
+	if input.DuplicateWindow > 0 && input.Protocol != "udp" {
+		return nil, fmt.Errorf("duplicate suppression is only supported by the UDP collecting process")
+	}
+	if input.EstimateClockSkew && input.RecordCallback != nil {
+		return nil, fmt.Errorf("clock skews cannot be estimated with a record callback")
+	}
+	if input.NormalizeFlowTimes && (!input.EstimateClockSkew || input.ZeroCopyDecoding) {
+		return nil, fmt.Errorf("flow times can only be normalized with clock skew estimation, and without zero-copy decoding")
+	}
+	if err := validateListenOptions(input); err != nil {
+		return nil, err
+	}
+	if err := validateOverloadPolicy(input.OverloadPolicy, input.MessageChanSize); err != nil {
+		return nil, err
+	}
+	for _, policy := range input.TransportOverloadPolicies {
+		if err := validateOverloadPolicy(policy, input.MessageChanSize); err != nil {
+			return nil, err
+		}
+	}
+	if len(input.AllowedClientNames) > 0 && input.CACert == nil {
+		return nil, fmt.Errorf("allowed client names require a CA certificate to verify client certificates")
+	}
 	collectProc := &CollectingProcess{
-		templatesMap:  make(map[uint32]map[uint16][]*entities.InfoElement),
-		mutex:         sync.RWMutex{},
-		templateTTL:   input.TemplateTTL,
-		address:       input.Address,
-		protocol:      input.Protocol,
-		maxBufferSize: input.MaxBufferSize,
-		stopChan:      make(chan bool),
-		messageChan:   make(chan *entities.Message),
-		clients:       make(map[string]*clientHandler),
-		isEncrypted:   input.IsEncrypted,
-		caCert:        input.CACert,
-		serverCert:    input.ServerCert,
-		serverKey:     input.ServerKey,
+		templatesMap:              make(map[templateDomain]map[uint16][]*entities.InfoElement),
+		templateUsageMap:          make(map[templateUsageKey]*templateUsage),
+		templateIdleTimeout:       time.Duration(input.TemplateIdleTimeout) * time.Second,
+		mutex:                     sync.RWMutex{},
+		templateTTL:               input.TemplateTTL,
+		address:                   input.Address,
+		protocol:                  input.Protocol,
+		maxBufferSize:             input.MaxBufferSize,
+		stopChan:                  make(chan bool),
+		messageChan:               make(chan *entities.Message, input.MessageChanSize),
+		overloadPolicy:            input.OverloadPolicy,
+		transportOverloadPolicies: input.TransportOverloadPolicies,
+		clients:                   make(map[string]*clientHandler),
+		sessionRegistries:         make(map[string]*registry.SessionRegistry),
+		registryOverrides:         input.RegistryOverrides,
+		observationPointTags:      observationPointTags,
+		sourceTags:                sourceTags,
+		obsDomainNameResolver:     input.ObservationDomainNameResolver,
+		obsDomainNameElement:      obsDomainNameElement,
+		isEncrypted:               input.IsEncrypted,
+		caCert:                    input.CACert,
+		serverCert:                input.ServerCert,
+		serverKey:                 input.ServerKey,
+		allowPlainTCP:             input.AllowPlainTCP,
+		alpnProtocols:             input.ALPNProtocols,
+		dumpMessages:              input.DumpMessages,
+		dumpWriter:                input.DumpWriter,
+		dumpedMessages:            make(map[string]int),
+		templateChangeCallback:    input.TemplateChangeCallback,
+		allowCompression:          input.AllowCompression,
+		maxConnections:            input.MaxConnections,
+		connectionBufferSize:      input.ConnectionBufferSize,
+		decodeWorkers:             input.DecodeWorkers,
+		decodeQueueSize:           input.DecodeQueueSize,
+		allowedExporters:          allowedExporters,
+		malformedCapture:          newMalformedCapture(input.MalformedCaptureSize, input.MalformedCaptureWriter),
+		zeroCopyDecoding:          input.ZeroCopyDecoding,
+		udpListeners:              input.UDPListeners,
+		recordCallback:            input.RecordCallback,
+		recordFilter:              input.RecordFilter,
+		addressFamily:             input.AddressFamily,
+		listenInterface:           input.Interface,
+		tcpKeepAlive:              time.Duration(input.TCPKeepAlive) * time.Second,
+		connectionIdleTimeout:     time.Duration(input.ConnectionIdleTimeout) * time.Second,
+		getServerCertificate:      input.GetServerCertificate,
+	}
+	if collectProc.decodeQueueSize == 0 {
+		collectProc.decodeQueueSize = defaultDecodeQueueSize
+	}
+	if len(input.AllowedClientNames) > 0 {
+		collectProc.allowedClientNames = make(map[string]bool)
+		for _, name := range input.AllowedClientNames {
+			collectProc.allowedClientNames[name] = true
+		}
+	}
+	if input.MeasureLatencyProbes {
+		collectProc.latencyProbes = entities.NewLatencyProbeTracker()
+	}
+	if input.DuplicateWindow > 0 {
+		collectProc.duplicates = newDuplicateFilter(time.Duration(input.DuplicateWindow) * time.Second)
+	}
+	if input.EstimateClockSkew {
+		collectProc.clockSkew = newClockSkewEstimator(input.NormalizeFlowTimes)
+	}
+	if len(input.ProjectedElements) > 0 {
+		collectProc.projectedElements = make(map[string]bool)
+		for _, name := range input.ProjectedElements {
+			collectProc.projectedElements[name] = true
+		}
 	}
 	return collectProc, nil
 }
 
 func (cp *CollectingProcess) Start() {
+	stopPruningCh := make(chan struct{})
+	if cp.templateIdleTimeout > 0 {
+		go cp.runTemplatePruning(stopPruningCh)
+	}
 	if cp.protocol == "tcp" {
 		cp.startTCPServer()
 	} else if cp.protocol == "udp" {
 		cp.startUDPServer()
+	} else if cp.protocol == "sctp" {
+		cp.startSCTPServer()
+	} else if cp.protocol == "unix" || cp.protocol == "unixgram" {
+		cp.startUnixServer()
 	}
+	atomic.StoreInt32(&cp.listening, 0)
+	close(stopPruningCh)
 }
 
 func (cp *CollectingProcess) Stop() {
@@ -127,13 +624,24 @@ func (cp *CollectingProcess) CloseMsgChan() {
 	close(cp.messageChan)
 }
 
-func (cp *CollectingProcess) createClient() *clientHandler {
+func (cp *CollectingProcess) createClient(transport string) *clientHandler {
 	return &clientHandler{
-		packetChan: make(chan *bytes.Buffer),
-		errChan:    make(chan bool),
+		packetChan:     make(chan *bytes.Buffer),
+		errChan:        make(chan bool),
+		overloadPolicy: cp.getOverloadPolicy(transport),
+		startTime:      time.Now(),
 	}
 }
 
+// getOverloadPolicy returns the overload policy of the sessions of the
+// transport.
+func (cp *CollectingProcess) getOverloadPolicy(transport string) OverloadPolicy {
+	if policy, exist := cp.transportOverloadPolicies[transport]; exist {
+		return policy
+	}
+	return cp.overloadPolicy
+}
+
 func (cp *CollectingProcess) addClient(address string, client *clientHandler) {
 	cp.mutex.Lock()
 	defer cp.mutex.Unlock()
@@ -144,6 +652,9 @@ func (cp *CollectingProcess) deleteClient(name string) {
 	cp.mutex.Lock()
 	defer cp.mutex.Unlock()
 	delete(cp.clients, name)
+	delete(cp.sessionRegistries, name)
+	delete(cp.dumpedMessages, name)
+	cp.deleteDomainCounters(name)
 }
 
 func (cp *CollectingProcess) getClientCount() int {
@@ -160,15 +671,124 @@ func (cp *CollectingProcess) closeAllClients() {
 	}
 }
 
+// decodePacket decodes the messages of the packet, an IPFIX message or a
+// NetFlow v9 packet, and sends them to the message channel, or passes their
+// data records to the record callback. It returns the last message, which is
+// nil if a NetFlow v9 packet has no flowset to decode, if an IPFIX message is
+// decoded for the record callback, or if no record of the last message is
+// selected by the record filter.
 func (cp *CollectingProcess) decodePacket(packetBuffer *bytes.Buffer, exportAddress string) (*entities.Message, error) {
+	packet := packetBuffer.Bytes()
+	if cp.duplicates != nil && cp.duplicates.isDuplicate(packet, exportAddress, time.Now()) {
+		klog.V(4).Infof("Discarding duplicate message of %d bytes from %s", len(packet), exportAddress)
+		atomic.AddUint64(&cp.stats.suppressedDuplicates, 1)
+		return nil, nil
+	}
+	var messages []*entities.Message
+	var numRecords uint32
+	var err error
+	if isNetFlowV9Packet(packet) {
+		if messages, err = cp.decodeNetFlowV9Packet(packetBuffer, exportAddress); err == nil {
+			for _, message := range messages {
+				numRecords += message.GetSet().GetNumberOfRecords()
+			}
+		}
+	} else {
+		var message *entities.Message
+		if message, numRecords, err = cp.decodeMessage(packetBuffer, exportAddress); err == nil && message != nil {
+			messages = []*entities.Message{message}
+		}
+	}
+	if cp.dumpMessages > 0 {
+		if err != nil {
+			cp.dumpPacket(exportAddress, packet, nil, err)
+		}
+		for _, message := range messages {
+			cp.dumpPacket(exportAddress, packet, message, nil)
+		}
+	}
+	if err != nil {
+		cp.captureMalformedMessage(exportAddress, packet, err)
+		return nil, err
+	}
+	cp.updateSessionCounters(exportAddress, len(packet), numRecords)
+	var skew time.Duration
+	if cp.clockSkew != nil && len(messages) > 0 {
+		skew = cp.clockSkew.observe(messages[0].GetExportAddress(), messages[0].GetExportTime(), time.Now())
+	}
+	var message *entities.Message
+	for _, message = range messages {
+		if cp.recordCallback != nil {
+			cp.dispatchRecords(exportAddress, message.GetSet())
+			continue
+		}
+		if cp.clockSkew != nil {
+			cp.clockSkew.apply(message, skew)
+		}
+		if cp.latencyProbes != nil {
+			cp.latencyProbes.Observe(message, time.Now())
+		}
+		if message = cp.filterRecords(message); message == nil {
+			continue
+		}
+		cp.sendMessage(message, cp.getSessionOverloadPolicy(exportAddress))
+	}
+	return message, nil
+}
+
+// getSessionOverloadPolicy returns the overload policy of the transport session
+// with the given client address.
+func (cp *CollectingProcess) getSessionOverloadPolicy(address string) OverloadPolicy {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	if client, exist := cp.clients[address]; exist {
+		return client.overloadPolicy
+	}
+	return cp.getOverloadPolicy(cp.protocol)
+}
+
+// decodeMessage decodes the IPFIX message of the packet, and returns it with
+// its number of records. If there is a record callback, the data records are
+// passed to it instead, and no message is returned.
+func (cp *CollectingProcess) decodeMessage(packetBuffer *bytes.Buffer, exportAddress string) (*entities.Message, uint32, error) {
 	var version, msgLen, setID, setLen uint16
 	var exportTime, sequencNum, obsDomainID uint32
 	err := util.Decode(packetBuffer, binary.BigEndian, &version, &msgLen, &exportTime, &sequencNum, &obsDomainID, &setID, &setLen)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if version != uint16(10) {
-		return nil, fmt.Errorf("collector only supports IPFIX (v10); invalid version %d received", version)
+		return nil, 0, fmt.Errorf("collector only supports IPFIX (v10) and NetFlow v9; invalid version %d received", version)
+	}
+
+	sessionAddress := exportAddress
+	exportAddress = getExportAddress(sessionAddress)
+	sessionRegistry := cp.getSessionRegistry(sessionAddress, exportAddress)
+
+	var set entities.Set
+	if setID == entities.TemplateSetID || setID == entities.OptionsTemplateSetID {
+		set, err = cp.decodeTemplateSet(packetBuffer, obsDomainID, setID == entities.OptionsTemplateSetID, sessionRegistry, sessionAddress)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error in decoding message: %v", err)
+		}
+	} else {
+		set, err = cp.decodeDataSet(packetBuffer, obsDomainID, setID, sessionRegistry, sessionAddress)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error in decoding message: %v", err)
+		}
+	}
+	obsDomainName := cp.resolveObsDomainName(exportAddress, obsDomainID)
+	if err := cp.addTags(set, exportAddress, obsDomainName); err != nil {
+		return nil, 0, err
+	}
+	var numDataRecords uint32
+	if set.GetSetType() == entities.Data {
+		numDataRecords = set.GetNumberOfRecords()
+	}
+	cp.updateDomainCounters(sessionAddress, obsDomainID, exportTime, sequencNum, numDataRecords, sequencNum+numDataRecords)
+	if cp.recordCallback != nil {
+		cp.dispatchRecords(sessionAddress, set)
+		return nil, set.GetNumberOfRecords(), nil
 	}
 
 	message := entities.NewMessage(true)
@@ -177,44 +797,97 @@ func (cp *CollectingProcess) decodePacket(packetBuffer *bytes.Buffer, exportAddr
 	message.SetExportTime(exportTime)
 	message.SetSequenceNum(sequencNum)
 	message.SetObsDomainID(obsDomainID)
+	message.SetExportAddress(exportAddress)
+	message.SetObsDomainName(obsDomainName)
+	message.AddSet(set)
+	return message, set.GetNumberOfRecords(), nil
+}
 
+// getExportAddress returns the IP address of the exporter of the transport
+// session with the given address.
+func getExportAddress(sessionAddress string) string {
 	// handle IPv6 address which may involve []
-	portIndex := strings.LastIndex(exportAddress, ":")
-	exportAddress = exportAddress[:portIndex]
+	portIndex := strings.LastIndex(sessionAddress, ":")
+	exportAddress := sessionAddress[:portIndex]
 	exportAddress = strings.Replace(exportAddress, "[", "", -1)
 	exportAddress = strings.Replace(exportAddress, "]", "", -1)
-	message.SetExportAddress(exportAddress)
+	return exportAddress
+}
 
-	var set entities.Set
-	if setID == entities.TemplateSetID {
-		set, err = cp.decodeTemplateSet(packetBuffer, obsDomainID)
-		if err != nil {
-			return nil, fmt.Errorf("error in decoding message: %v", err)
+// sendMessage delivers the message to the subscriptions, and adds it to the
+// message channel according to the overload policy of its session.
+func (cp *CollectingProcess) sendMessage(message *entities.Message, overloadPolicy OverloadPolicy) {
+	cp.publishMessage(message)
+	switch overloadPolicy {
+	case OverloadPolicyDrop:
+		select {
+		case cp.messageChan <- message:
+		default:
+			atomic.AddUint64(&cp.stats.droppedMessages, 1)
+			klog.V(4).Infof("Message channel is full, dropping message from exporter %s", message.GetExportAddress())
 		}
-	} else {
-		set, err = cp.decodeDataSet(packetBuffer, obsDomainID, setID)
-		if err != nil {
-			return nil, fmt.Errorf("error in decoding message: %v", err)
+		return
+	case OverloadPolicyDropOldest:
+		for {
+			select {
+			case cp.messageChan <- message:
+				return
+			default:
+			}
+			// The oldest message may be consumed meanwhile, in which case
+			// nothing is dropped.
+			select {
+			case dropped := <-cp.messageChan:
+				atomic.AddUint64(&cp.stats.droppedMessages, 1)
+				klog.V(4).Infof("Message channel is full, dropping oldest message from exporter %s", dropped.GetExportAddress())
+			default:
+			}
 		}
 	}
-	message.AddSet(set)
-
 	// the thread(s)/client(s) executing the code will get blocked until the message is consumed/read in other goroutines.
 	cp.messageChan <- message
-	return message, nil
 }
 
-func (cp *CollectingProcess) decodeTemplateSet(templateBuffer *bytes.Buffer, obsDomainID uint32) (entities.Set, error) {
+func (cp *CollectingProcess) decodeTemplateSet(templateBuffer *bytes.Buffer, obsDomainID uint32, isOptions bool, sessionRegistry *registry.SessionRegistry, sessionAddress string) (entities.Set, error) {
 	var templateID uint16
 	var fieldCount uint16
+	var scopeFieldCount uint16
 	if err := util.Decode(templateBuffer, binary.BigEndian, &templateID, &fieldCount); err != nil {
 		return nil, err
 	}
+	setType := entities.Template
+	if isOptions {
+		// Options Template Record Header includes the scope field count,
+		// except in template withdrawals.
+		if fieldCount > 0 {
+			if err := util.Decode(templateBuffer, binary.BigEndian, &scopeFieldCount); err != nil {
+				return nil, err
+			}
+		}
+		setType = entities.OptionsTemplate
+	}
 	elementsWithValue := make([]*entities.InfoElementWithValue, 0)
 	templateSet := entities.NewSet(true)
-	if err := templateSet.PrepareSet(entities.Template, templateID); err != nil {
+	if err := templateSet.PrepareSet(setType, templateID); err != nil {
 		return nil, err
 	}
+	if fieldCount == 0 {
+		// A template withdrawal has no fields. The template ID of the set
+		// withdraws all the templates of the observation domain.
+		if err := templateSet.AddRecord(nil, templateID); err != nil {
+			return nil, err
+		}
+		allTemplates := templateID == entities.TemplateSetID || templateID == entities.OptionsTemplateSetID
+		for _, withdrawnID := range cp.withdrawTemplates(sessionAddress, obsDomainID, templateID, allTemplates) {
+			cp.notifyTemplateChange(TemplateChange{
+				Type:            TemplateWithdrawn,
+				ExporterAddress: sessionAddress,
+				ObsDomainID:     obsDomainID,
+				TemplateID:      withdrawnID,
+			})
+		}
+		return templateSet, nil
+	}
 
 	for i := 0; i < int(fieldCount); i++ {
 		var element *entities.InfoElement
@@ -231,7 +904,7 @@ func (cp *CollectingProcess) decodeTemplateSet(templateBuffer *bytes.Buffer, obs
 		if !isNonIANARegistry {
 			elementID = binary.BigEndian.Uint16(elementid)
 			enterpriseID = registry.IANAEnterpriseID
-			element, err = registry.GetInfoElementFromID(elementID, enterpriseID)
+			element, err = sessionRegistry.GetInfoElementFromID(elementID, enterpriseID)
 			if err != nil {
 				return nil, err
 			}
@@ -257,100 +930,293 @@ func (cp *CollectingProcess) decodeTemplateSet(templateBuffer *bytes.Buffer, obs
 			}
 			elementid[0] = elementid[0] ^ 0x80
 			elementID = binary.BigEndian.Uint16(elementid)
-			element, err = registry.GetInfoElementFromID(elementID, enterpriseID)
+			element, err = sessionRegistry.GetInfoElementFromID(elementID, enterpriseID)
 			if err != nil {
-				return nil, err
+				// The values of enterprise elements missing from the registry
+				// are kept as octet arrays, so that the other elements of the
+				// records are not lost.
+				klog.V(2).Infof("Information Element with elementID %d and enterpriseID %d is unknown, decoding it as octetArray", elementID, enterpriseID)
+				atomic.AddUint64(&cp.stats.unknownElements, 1)
+				element = getUnknownEnterpriseElement(elementID, enterpriseID, elementLength)
 			}
 		}
+		if element, err = getTemplateElement(element, elementLength); err != nil {
+			return nil, err
+		}
 		ie := entities.NewInfoElementWithValue(element, nil)
 		elementsWithValue = append(elementsWithValue, ie)
 	}
-	templateSet.AddRecord(elementsWithValue, templateID)
-	cp.addTemplate(obsDomainID, templateID, elementsWithValue)
+	if isOptions {
+		if err := templateSet.AddOptionsTemplateRecord(elementsWithValue, scopeFieldCount, templateID); err != nil {
+			return nil, err
+		}
+	} else if err := templateSet.AddRecord(elementsWithValue, templateID); err != nil {
+		return nil, err
+	}
+	if changeType, changed := cp.addTemplate(sessionAddress, obsDomainID, templateID, elementsWithValue, scopeFieldCount); changed {
+		elements, _ := cp.getTemplate(sessionAddress, obsDomainID, templateID)
+		cp.notifyTemplateChange(TemplateChange{
+			Type:            changeType,
+			ExporterAddress: sessionAddress,
+			ObsDomainID:     obsDomainID,
+			TemplateID:      templateID,
+			Elements:        elements,
+			ScopeFieldCount: scopeFieldCount,
+		})
+	}
 	return templateSet, nil
 }
 
-func (cp *CollectingProcess) decodeDataSet(dataBuffer *bytes.Buffer, obsDomainID uint32, templateID uint16) (entities.Set, error) {
+func (cp *CollectingProcess) decodeDataSet(dataBuffer *bytes.Buffer, obsDomainID uint32, templateID uint16, sessionRegistry *registry.SessionRegistry, sessionAddress string) (entities.Set, error) {
 	// make sure template exists
-	template, err := cp.getTemplate(obsDomainID, templateID)
+	template, err := cp.getTemplate(sessionAddress, obsDomainID, templateID)
 	if err != nil {
-		return nil, fmt.Errorf("template %d with obsDomainID %d does not exist", templateID, obsDomainID)
+		atomic.AddUint64(&cp.stats.missingTemplateDataSets, 1)
+		return nil, err
 	}
 	dataSet := entities.NewSet(true)
 	if err := dataSet.PrepareSet(entities.Data, templateID); err != nil {
 		return nil, err
 	}
 
-	for dataBuffer.Len() > 0 {
+	project := cp.projectedElements != nil && !isTypeRecordTemplate(template)
+	zeroCopy := cp.zeroCopyDecoding && !isTypeRecordTemplate(template) && !hasListElements(template)
+	templateScopeFieldCount := cp.getScopeFieldCount(sessionAddress, obsDomainID, templateID)
+	// The set may end with padding (RFC7011 section 3.3.1), which is shorter
+	// than any record of the template, so records are decoded while the
+	// remaining bytes can hold one, and are not padding.
+	minRecordLen := getMinDataRecordLen(template)
+	for dataBuffer.Len() > 0 && dataBuffer.Len() >= minRecordLen && !isSetPadding(template, dataBuffer.Bytes()) {
 		elements := make([]*entities.InfoElementWithValue, 0)
-		for _, element := range template {
-			var length int
-			if element.Len == entities.VariableLength { // string
-				length = getFieldLength(dataBuffer)
+		// scopeFieldCount is the number of scope fields left after the
+		// projection.
+		var scopeFieldCount uint16
+		for i, element := range template {
+			val, err := entities.ReadElementValue(element, dataBuffer)
+			if err != nil {
+				return nil, err
+			}
+			if project && !cp.projectedElements[element.Name] {
+				continue
+			}
+			var ie *entities.InfoElementWithValue
+			if zeroCopy {
+				ie = entities.NewInfoElementWithValue(element, entities.ValueView(val))
 			} else {
-				length = int(element.Len)
+				ie = entities.NewInfoElementWithValue(element, bytes.NewBuffer(val))
 			}
-			val := dataBuffer.Next(length)
-			ie := entities.NewInfoElementWithValue(element, bytes.NewBuffer(val))
 			elements = append(elements, ie)
+			if i < int(templateScopeFieldCount) {
+				scopeFieldCount++
+			}
 		}
-		dataSet.AddRecord(elements, templateID)
+		if scopeFieldCount > 0 {
+			err = dataSet.AddOptionsDataRecord(elements, scopeFieldCount, templateID)
+		} else {
+			err = dataSet.AddRecord(elements, templateID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if hasListElements(template) {
+		cp.decodeListValues(dataSet, sessionRegistry, sessionAddress, obsDomainID)
 	}
+	if zeroCopy && cp.latencyProbes != nil {
+		// Latency probes are observed with their decoded values.
+		for _, record := range dataSet.GetRecords() {
+			if entities.IsLatencyProbe(record) {
+				record.Materialize()
+			}
+		}
+	}
+	cp.registerTypeRecords(dataSet, sessionRegistry)
+	cp.updateTemplateUsage(sessionAddress, obsDomainID, templateID, dataSet.GetNumberOfRecords())
 	return dataSet, nil
 }
 
-func (cp *CollectingProcess) addTemplate(obsDomainID uint32, templateID uint16, elementsWithValue []*entities.InfoElementWithValue) {
+// addTemplate adds or replaces the template received in the session, and
+// returns whether the elements of the template changed, and how.
+func (cp *CollectingProcess) addTemplate(sessionAddress string, obsDomainID uint32, templateID uint16, elementsWithValue []*entities.InfoElementWithValue, scopeFieldCount uint16) (TemplateChangeType, bool) {
 	cp.mutex.Lock()
 	defer cp.mutex.Unlock()
-	if _, exists := cp.templatesMap[obsDomainID]; !exists {
-		cp.templatesMap[obsDomainID] = make(map[uint16][]*entities.InfoElement)
+	domain := templateDomain{sessionAddress, obsDomainID}
+	if _, exists := cp.templatesMap[domain]; !exists {
+		cp.templatesMap[domain] = make(map[uint16][]*entities.InfoElement)
 	}
 	elements := make([]*entities.InfoElement, 0)
 	for _, elementWithValue := range elementsWithValue {
 		elements = append(elements, elementWithValue.Element)
 	}
-	cp.templatesMap[obsDomainID][templateID] = elements
-	// template lifetime management
-	if cp.protocol == "tcp" {
-		return
+	changeType, changed := TemplateAdded, true
+	if existingElements, exists := cp.templatesMap[domain][templateID]; exists {
+		changeType, changed = TemplateReplaced, !sameTemplateElements(existingElements, elements)
+	}
+	cp.templatesMap[domain][templateID] = elements
+	cp.resetTemplateUsage(sessionAddress, obsDomainID, templateID, scopeFieldCount, changed)
+	// Templates expire over UDP only (RFC7011 section 8.4), as exporters
+	// withdraw them over reliable transports. Exporters refresh them over
+	// Unix datagrams like over UDP.
+	if cp.protocol != "udp" && cp.protocol != "unixgram" {
+		return changeType, changed
 	}
-
-	// Handle udp template expiration
 	if cp.templateTTL == 0 {
 		cp.templateTTL = entities.TemplateTTL // Default value
 	}
-	go func() {
-		ticker := time.NewTicker(time.Duration(cp.templateTTL) * time.Second)
-		defer ticker.Stop()
-		select {
-		case <-ticker.C:
-			klog.Infof("Template with id %d, and obsDomainID %d is expired.", templateID, obsDomainID)
-			cp.deleteTemplate(obsDomainID, templateID)
-			break
-		}
-	}()
+	key := templateUsageKey{sessionAddress, obsDomainID, templateID}
+	time.AfterFunc(time.Duration(cp.templateTTL)*time.Second, func() {
+		cp.expireTemplate(key)
+	})
+	return changeType, changed
 }
 
-func (cp *CollectingProcess) getTemplate(obsDomainID uint32, templateID uint16) ([]*entities.InfoElement, error) {
+// expireTemplate deletes the template if it was not received again within the
+// template TTL. Data sets received for the template afterwards are dropped and
+// counted in the MissingTemplateDataSets stat, until it is received again.
+func (cp *CollectingProcess) expireTemplate(key templateUsageKey) {
+	cp.mutex.Lock()
+	usage, exists := cp.templateUsageMap[key]
+	if !exists || time.Since(usage.received) < time.Duration(cp.templateTTL)*time.Second {
+		// The template was withdrawn, pruned or refreshed.
+		cp.mutex.Unlock()
+		return
+	}
+	cp.mutex.Unlock()
+	if !cp.deleteTemplate(key.sessionAddress, key.obsDomainID, key.templateID) {
+		return
+	}
+	klog.Infof("Template with id %d, and obsDomainID %d from %s is expired.", key.templateID, key.obsDomainID, key.sessionAddress)
+	atomic.AddUint64(&cp.stats.expiredTemplates, 1)
+	cp.notifyTemplateChange(TemplateChange{
+		Type:            TemplateExpired,
+		ExporterAddress: key.sessionAddress,
+		ObsDomainID:     key.obsDomainID,
+		TemplateID:      key.templateID,
+	})
+}
+
+func (cp *CollectingProcess) getTemplate(sessionAddress string, obsDomainID uint32, templateID uint16) ([]*entities.InfoElement, error) {
 	cp.mutex.RLock()
 	defer cp.mutex.RUnlock()
-	if elements, exists := cp.templatesMap[obsDomainID][templateID]; exists {
+	if elements, exists := cp.templatesMap[templateDomain{sessionAddress, obsDomainID}][templateID]; exists {
 		return elements, nil
 	} else {
-		return nil, fmt.Errorf("template %d with obsDomainID %d does not exist", templateID, obsDomainID)
+		return nil, fmt.Errorf("template %d with obsDomainID %d does not exist for exporter %s", templateID, obsDomainID, sessionAddress)
 	}
 }
 
-func (cp *CollectingProcess) deleteTemplate(obsDomainID uint32, templateID uint16) {
+// TemplateInfo describes a template known to the collector.
+type TemplateInfo struct {
+	// ExporterAddress is the address of the transport session which sent the
+	// template.
+	ExporterAddress string
+	ObsDomainID     uint32
+	TemplateID      uint16
+	Elements        []*entities.InfoElement
+	// ScopeFieldCount is the number of scope fields of options templates, and
+	// 0 for other templates.
+	ScopeFieldCount uint16
+}
+
+// GetTemplates returns the templates known to the collector, sorted by
+// exporter address, observation domain ID and template ID.
+func (cp *CollectingProcess) GetTemplates() []TemplateInfo {
+	return cp.listTemplates(func(domain templateDomain) bool {
+		return true
+	})
+}
+
+// GetExporterTemplates returns the templates of the observation domain of an
+// exporter known to the collector, sorted by exporter address and template ID.
+// The exporter is the address of a transport session (IP:port), or an IP
+// address for the templates of all the transport sessions of the exporter.
+func (cp *CollectingProcess) GetExporterTemplates(exporter string, obsDomainID uint32) []TemplateInfo {
+	return cp.listTemplates(func(domain templateDomain) bool {
+		if domain.obsDomainID != obsDomainID {
+			return false
+		}
+		return domain.sessionAddress == exporter || getExportAddress(domain.sessionAddress) == exporter
+	})
+}
+
+// listTemplates returns the templates of the observation domains selected by
+// the function, sorted by exporter address, observation domain ID and
+// template ID.
+func (cp *CollectingProcess) listTemplates(selectDomain func(domain templateDomain) bool) []TemplateInfo {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	templates := make([]TemplateInfo, 0)
+	for domain, templatesMap := range cp.templatesMap {
+		if !selectDomain(domain) {
+			continue
+		}
+		for templateID, elements := range templatesMap {
+			var scopeFieldCount uint16
+			if usage, exists := cp.templateUsageMap[templateUsageKey{domain.sessionAddress, domain.obsDomainID, templateID}]; exists {
+				scopeFieldCount = usage.scopeFieldCount
+			}
+			templates = append(templates, TemplateInfo{domain.sessionAddress, domain.obsDomainID, templateID, elements, scopeFieldCount})
+		}
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].ExporterAddress != templates[j].ExporterAddress {
+			return templates[i].ExporterAddress < templates[j].ExporterAddress
+		}
+		if templates[i].ObsDomainID != templates[j].ObsDomainID {
+			return templates[i].ObsDomainID < templates[j].ObsDomainID
+		}
+		return templates[i].TemplateID < templates[j].TemplateID
+	})
+	return templates
+}
+
+// deleteTemplate deletes the template, and returns false if it does not exist.
+func (cp *CollectingProcess) deleteTemplate(sessionAddress string, obsDomainID uint32, templateID uint16) bool {
 	cp.mutex.Lock()
 	defer cp.mutex.Unlock()
-	delete(cp.templatesMap[obsDomainID], templateID)
+	domain := templateDomain{sessionAddress, obsDomainID}
+	_, exists := cp.templatesMap[domain][templateID]
+	delete(cp.templatesMap[domain], templateID)
+	if len(cp.templatesMap[domain]) == 0 {
+		delete(cp.templatesMap, domain)
+	}
+	cp.deleteTemplateUsage(domain, templateID, false)
+	return exists
+}
+
+// withdrawTemplates deletes the template, or all the templates of the
+// observation domain, and returns the IDs of the deleted templates.
+func (cp *CollectingProcess) withdrawTemplates(sessionAddress string, obsDomainID uint32, templateID uint16, allTemplates bool) []uint16 {
+	if !allTemplates {
+		if cp.deleteTemplate(sessionAddress, obsDomainID, templateID) {
+			return []uint16{templateID}
+		}
+		return nil
+	}
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	domain := templateDomain{sessionAddress, obsDomainID}
+	withdrawnIDs := make([]uint16, 0, len(cp.templatesMap[domain]))
+	for id := range cp.templatesMap[domain] {
+		withdrawnIDs = append(withdrawnIDs, id)
+	}
+	delete(cp.templatesMap, domain)
+	cp.deleteTemplateUsage(domain, 0, true)
+	sort.Slice(withdrawnIDs, func(i, j int) bool { return withdrawnIDs[i] < withdrawnIDs[j] })
+	return withdrawnIDs
 }
 
 func (cp *CollectingProcess) updateAddress(address net.Addr) {
 	cp.mutex.Lock()
 	defer cp.mutex.Unlock()
 	cp.netAddress = address
+	atomic.StoreInt32(&cp.listening, 1)
+}
+
+// IsListening returns whether the collecting process is started and listens
+// for exporters.
+func (cp *CollectingProcess) IsListening() bool {
+	return atomic.LoadInt32(&cp.listening) == 1
 }
 
 // getMessageLength returns buffer length by decoding the header
@@ -366,17 +1232,69 @@ func getMessageLength(msgBuffer *bytes.Buffer) (int, error) {
 	return int(msgLen), nil
 }
 
-// getFieldLength returns string field length for data record
-// (encoding reference: https://tools.ietf.org/html/rfc7011#appendix-A.5)
-func getFieldLength(dataBuffer *bytes.Buffer) int {
-	lengthBuff := dataBuffer.Next(1)
-	var lengthOneByte uint8
-	util.Decode(bytes.NewBuffer(lengthBuff), binary.BigEndian, &lengthOneByte)
-	if lengthOneByte < 255 { // string length is less than 255
-		return int(lengthOneByte)
+// getMinDataRecordLen returns the length of the shortest data record of the
+// template, in which variable-length elements are empty.
+func getMinDataRecordLen(template []*entities.InfoElement) int {
+	minLen := 0
+	for _, element := range template {
+		if element.Len == entities.VariableLength {
+			minLen++
+		} else {
+			minLen += int(element.Len)
+		}
+	}
+	return minLen
+}
+
+// isSetPadding returns whether the remaining bytes of a data set are padding.
+// Records of templates with variable-length elements may be as short as the
+// padding, so zeros shorter than the alignment of padded sets are taken as
+// padding rather than records with empty values.
+func isSetPadding(template []*entities.InfoElement, remaining []byte) bool {
+	if len(remaining) >= setAlignment || !hasVariableLengthElements(template) {
+		return false
+	}
+	for _, b := range remaining {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func hasVariableLengthElements(template []*entities.InfoElement) bool {
+	for _, element := range template {
+		if element.Len == entities.VariableLength {
+			return true
+		}
+	}
+	return false
+}
+
+// getUnknownEnterpriseElement returns the element of a template field whose
+// enterprise element is not in the registry, with a name made of its IDs,
+// e.g. enterprise_32473_id_105.
+func getUnknownEnterpriseElement(elementID uint16, enterpriseID uint32, length uint16) *entities.InfoElement {
+	return entities.NewInfoElement(fmt.Sprintf("enterprise_%d_id_%d", enterpriseID, elementID), elementID, entities.OctetArray, enterpriseID, length)
+}
+
+// getTemplateElement returns the element of a template field with the length
+// of the field, if it differs from the length of the registry: elements whose
+// data type allows it may have a variable length (RFC7011 section 7), and
+// variable-length elements may have a fixed length.
+func getTemplateElement(element *entities.InfoElement, length uint16) (*entities.InfoElement, error) {
+	if element.Len == length {
+		return element, nil
+	}
+	if length == entities.VariableLength && !entities.IsVariableLengthDataType(element.DataType) {
+		return nil, fmt.Errorf("element %s of data type %v cannot have variable length", element.Name, element.DataType)
+	}
+	if length != entities.VariableLength && element.Len != entities.VariableLength {
+		// Reduced-size encoding (RFC7011 section 6.2) is not supported, the
+		// length of the registry is used.
+		return element, nil
 	}
-	var lengthTwoBytes uint16
-	lengthBuff = dataBuffer.Next(2)
-	util.Decode(bytes.NewBuffer(lengthBuff), binary.BigEndian, &lengthTwoBytes)
-	return int(lengthTwoBytes)
+	fieldElement := *element
+	fieldElement.Len = length
+	return &fieldElement, nil
 }
@@ -16,10 +16,28 @@ package collector
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
 	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -28,7 +46,9 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 
 	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/filter"
 	"github.com/vmware/go-ipfix/pkg/registry"
+	"github.com/vmware/go-ipfix/pkg/util"
 )
 
 var validTemplatePacket = []byte{0, 10, 0, 40, 95, 154, 107, 127, 0, 0, 0, 0, 0, 0, 0, 1, 0, 2, 0, 24, 1, 0, 0, 3, 0, 8, 0, 4, 0, 12, 0, 4, 128, 101, 255, 255, 0, 0, 220, 186}
@@ -203,10 +223,105 @@ func TestTCPCollectingProcess_ReceiveTemplateRecord(t *testing.T) {
 	}()
 	<-cp.GetMsgChan()
 	cp.Stop()
-	template, _ := cp.getTemplate(1, 256)
+	template := getTemplateFromAnySession(cp, 1, 256)
 	assert.NotNil(t, template, "TCP Collecting Process should receive and store the received template.")
 }
 
+func TestTCPCollectingProcess_MaxConnections(t *testing.T) {
+	input := getCollectorInput(tcpTransport, false, false)
+	input.MaxConnections = 1
+	input.ConnectionBufferSize = 16
+	cp, err := InitCollectingProcess(input)
+	if err != nil {
+		t.Fatalf("TCP Collecting Process does not start correctly: %v", err)
+	}
+	go cp.Start()
+	waitForCollectorReady(t, cp)
+	// Wait for the connection checking the collector to be closed.
+	err = wait.Poll(10*time.Millisecond, time.Second, func() (bool, error) {
+		return atomic.LoadInt32(&cp.connections) == 0, nil
+	})
+	assert.NoError(t, err)
+	collectorAddr := cp.GetAddress()
+	conn1, err := net.Dial(collectorAddr.Network(), collectorAddr.String())
+	assert.NoError(t, err)
+	// The message is read in several parts of the connection buffer size.
+	_, err = conn1.Write(validTemplatePacket)
+	assert.NoError(t, err)
+	<-cp.GetMsgChan()
+
+	conn2, err := net.Dial(collectorAddr.Network(), collectorAddr.String())
+	assert.NoError(t, err)
+	conn2.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn2.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	conn2.Close()
+	assert.Equal(t, uint64(1), cp.GetStats().RejectedConnections)
+
+	// The connection is accepted once the first one is closed.
+	conn1.Close()
+	err = wait.Poll(10*time.Millisecond, time.Second, func() (bool, error) {
+		return atomic.LoadInt32(&cp.connections) == 0, nil
+	})
+	assert.NoError(t, err)
+	conn3, err := net.Dial(collectorAddr.Network(), collectorAddr.String())
+	assert.NoError(t, err)
+	defer conn3.Close()
+	_, err = conn3.Write(validTemplatePacket)
+	assert.NoError(t, err)
+	<-cp.GetMsgChan()
+	assert.Equal(t, uint64(1), cp.GetStats().RejectedConnections)
+	cp.Stop()
+}
+
+func TestCollectingProcess_AllowedExporters(t *testing.T) {
+	input := getCollectorInput(tcpTransport, false, false)
+	input.AllowedExporters = []string{"10.0.0.0"}
+	_, err := InitCollectingProcess(input)
+	assert.Error(t, err)
+	input.AllowedExporters = []string{"10.0.0.0/8", "2001:db8::/32"}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	assert.True(t, cp.isExporterAllowed(&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 4739}))
+	assert.True(t, cp.isExporterAllowed(&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 4739}))
+	assert.False(t, cp.isExporterAllowed(&net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 4739}))
+
+	// The connections from the loopback address are closed.
+	go cp.Start()
+	waitForCollectorReady(t, cp)
+	collectorAddr := cp.GetAddress()
+	conn, err := net.Dial(collectorAddr.Network(), collectorAddr.String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, cp.getClientCount())
+	assert.NotZero(t, cp.GetStats().DeniedConnections)
+	cp.Stop()
+
+	// The datagrams from the loopback address are discarded.
+	input = getCollectorInput(udpTransport, false, false)
+	input.AllowedExporters = []string{"10.0.0.0/8"}
+	cp, err = InitCollectingProcess(input)
+	assert.NoError(t, err)
+	go cp.Start()
+	waitForCollectorReady(t, cp)
+	collectorAddr = cp.GetAddress()
+	resolveAddr, err := net.ResolveUDPAddr(collectorAddr.Network(), collectorAddr.String())
+	assert.NoError(t, err)
+	udpConn, err := net.DialUDP(udpTransport, nil, resolveAddr)
+	assert.NoError(t, err)
+	defer udpConn.Close()
+	udpConn.Write(validTemplatePacket)
+	err = wait.Poll(10*time.Millisecond, time.Second, func() (bool, error) {
+		return cp.GetStats().DeniedDatagrams == 1, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, cp.getClientCount())
+	cp.Stop()
+}
+
 func TestUDPCollectingProcess_ReceiveTemplateRecord(t *testing.T) {
 	input := getCollectorInput(udpTransport, false, false)
 	cp, err := InitCollectingProcess(input)
@@ -231,7 +346,7 @@ func TestUDPCollectingProcess_ReceiveTemplateRecord(t *testing.T) {
 	}()
 	<-cp.GetMsgChan()
 	cp.Stop()
-	template, _ := cp.getTemplate(1, 256)
+	template := getTemplateFromAnySession(cp, 1, 256)
 	assert.NotNil(t, template, "UDP Collecting Process should receive and store the received template.")
 
 }
@@ -239,8 +354,6 @@ func TestUDPCollectingProcess_ReceiveTemplateRecord(t *testing.T) {
 func TestTCPCollectingProcess_ReceiveDataRecord(t *testing.T) {
 	input := getCollectorInput(tcpTransport, false, false)
 	cp, err := InitCollectingProcess(input)
-	// Add the templates before sending data record
-	cp.addTemplate(uint32(1), uint16(256), elementsWithValueIPv4)
 	if err != nil {
 		t.Fatalf("TCP Collecting Process does not start correctly: %v", err)
 	}
@@ -256,6 +369,8 @@ func TestTCPCollectingProcess_ReceiveDataRecord(t *testing.T) {
 			t.Errorf("Cannot establish connection to %s", collectorAddr.String())
 		}
 		defer conn.Close()
+		// Add the templates of the session before sending data record
+		cp.addTemplate(conn.LocalAddr().String(), uint32(1), uint16(256), elementsWithValueIPv4, 0)
 		conn.Write(validDataPacket)
 	}()
 	<-cp.GetMsgChan()
@@ -265,13 +380,9 @@ func TestTCPCollectingProcess_ReceiveDataRecord(t *testing.T) {
 func TestUDPCollectingProcess_ReceiveDataRecord(t *testing.T) {
 	input := getCollectorInput(udpTransport, false, false)
 	cp, err := InitCollectingProcess(input)
-	// Add the templates before sending data record
-	cp.addTemplate(uint32(1), uint16(256), elementsWithValueIPv4)
 	if err != nil {
 		t.Fatalf("UDP Collecting Process does not start correctly: %v", err)
 	}
-	// Add the templates before sending data record
-	cp.addTemplate(uint32(1), uint16(256), elementsWithValueIPv4)
 
 	go cp.Start()
 	// wait until collector is ready
@@ -287,12 +398,114 @@ func TestUDPCollectingProcess_ReceiveDataRecord(t *testing.T) {
 			t.Errorf("UDP Collecting Process does not start correctly.")
 		}
 		defer conn.Close()
+		// Add the templates of the session before sending data record
+		cp.addTemplate(conn.LocalAddr().String(), uint32(1), uint16(256), elementsWithValueIPv4, 0)
 		conn.Write(validDataPacket)
 	}()
 	<-cp.GetMsgChan()
 	cp.Stop()
 }
 
+func TestUDPCollectingProcess_DecodeWorkers(t *testing.T) {
+	input := getCollectorInput(udpTransport, false, false)
+	input.DecodeWorkers = -1
+	_, err := InitCollectingProcess(input)
+	assert.Error(t, err)
+	input.DecodeWorkers = 2
+	input.DecodeQueueSize = 1
+	cp, err := InitCollectingProcess(input)
+	if err != nil {
+		t.Fatalf("UDP Collecting Process does not start correctly: %v", err)
+	}
+	go cp.Start()
+	waitForCollectorReady(t, cp)
+	collectorAddr := cp.GetAddress()
+	resolveAddr, err := net.ResolveUDPAddr(collectorAddr.Network(), collectorAddr.String())
+	assert.NoError(t, err)
+	conn, err := net.DialUDP(udpTransport, nil, resolveAddr)
+	assert.NoError(t, err)
+	defer conn.Close()
+	// The data set is decoded after the template by the worker of the session.
+	conn.Write(validTemplatePacket)
+	conn.Write(validDataPacket)
+	message := <-cp.GetMsgChan()
+	assert.Equal(t, entities.Template, message.GetSet().GetSetType())
+	message = <-cp.GetMsgChan()
+	assert.Equal(t, entities.Data, message.GetSet().GetSetType())
+
+	// Messages are dropped once the worker is blocked on the message channel
+	// and its queue is full, while the socket is still read.
+	err = wait.Poll(10*time.Millisecond, time.Second, func() (bool, error) {
+		conn.Write(validDataPacket)
+		return cp.GetStats().DroppedPackets > 0, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, cp.GetStats().DecodeBacklog)
+	go func() {
+		for range cp.GetMsgChan() {
+		}
+	}()
+	cp.Stop()
+}
+
+func TestDecodePool_QueueAfterStop(t *testing.T) {
+	input := getCollectorInput(udpTransport, false, false)
+	input.DecodeWorkers = 1
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	cp.startDecodePool()
+	pool := cp.getDecodePool()
+	cp.stopDecodePool()
+	// Messages queued by the goroutines of UDP clients once the pool is
+	// stopped are dropped.
+	pool.queuePacket(cp, bytes.NewBuffer(validDataPacket), "127.0.0.1:4739")
+	assert.Equal(t, uint64(1), cp.GetStats().DroppedPackets)
+}
+
+func TestUDPCollectingProcess_ReusePort(t *testing.T) {
+	input := getCollectorInput(tcpTransport, false, false)
+	input.UDPListeners = 2
+	_, err := InitCollectingProcess(input)
+	assert.Error(t, err)
+	input = getCollectorInput(udpTransport, false, false)
+	input.UDPListeners = -1
+	_, err = InitCollectingProcess(input)
+	assert.Error(t, err)
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT listeners are only supported on Linux")
+	}
+	input.UDPListeners = 4
+	cp, err := InitCollectingProcess(input)
+	if err != nil {
+		t.Fatalf("UDP Collecting Process does not start correctly: %v", err)
+	}
+	go cp.Start()
+	waitForCollectorReady(t, cp)
+	collectorAddr := cp.GetAddress()
+	resolveAddr, err := net.ResolveUDPAddr(collectorAddr.Network(), collectorAddr.String())
+	assert.NoError(t, err)
+	// The datagrams of every exporter are received by one of the listeners,
+	// in order.
+	const exporters = 8
+	for i := 0; i < exporters; i++ {
+		conn, err := net.DialUDP(udpTransport, nil, resolveAddr)
+		assert.NoError(t, err)
+		defer conn.Close()
+		conn.Write(validTemplatePacket)
+		conn.Write(validDataPacket)
+	}
+	dataMessages := 0
+	for i := 0; i < 2*exporters; i++ {
+		message := <-cp.GetMsgChan()
+		if message.GetSet().GetSetType() == entities.Data {
+			dataMessages++
+		}
+	}
+	assert.Equal(t, exporters, dataMessages)
+	assert.Equal(t, uint64(0), cp.GetStats().MissingTemplateDataSets)
+	cp.Stop()
+}
+
 func TestTCPCollectingProcess_ConcurrentClient(t *testing.T) {
 	input := getCollectorInput(tcpTransport, false, false)
 	cp, _ := InitCollectingProcess(input)
@@ -361,7 +574,7 @@ func TestUDPCollectingProcess_ConcurrentClient(t *testing.T) {
 
 func TestCollectingProcess_DecodeTemplateRecord(t *testing.T) {
 	cp := CollectingProcess{}
-	cp.templatesMap = make(map[uint32]map[uint16][]*entities.InfoElement)
+	cp.templatesMap = make(map[templateDomain]map[uint16][]*entities.InfoElement)
 	cp.mutex = sync.RWMutex{}
 	address, err := net.ResolveTCPAddr(tcpTransport, hostPortIPv4)
 	if err != nil {
@@ -379,7 +592,7 @@ func TestCollectingProcess_DecodeTemplateRecord(t *testing.T) {
 	}
 	assert.Equal(t, uint16(10), message.GetVersion(), "Flow record version should be 10.")
 	assert.Equal(t, uint32(1), message.GetObsDomainID(), "Flow record obsDomainID should be 1.")
-	assert.NotNil(t, cp.templatesMap[message.GetObsDomainID()], "Template should be stored in template map")
+	assert.NotNil(t, cp.templatesMap[templateDomain{address.String(), message.GetObsDomainID()}], "Template should be stored in template map")
 
 	templateSet := message.GetSet()
 	assert.NotNil(t, templateSet, "Template record should be stored in message flowset")
@@ -392,17 +605,17 @@ func TestCollectingProcess_DecodeTemplateRecord(t *testing.T) {
 	assert.NotNil(t, err, "Error should be logged for invalid version")
 	// Malformed record
 	templateRecord = []byte{0, 10, 0, 40, 95, 40, 211, 236, 0, 0, 0, 0, 0, 0, 0, 1, 0, 2, 0, 24, 1, 0, 0, 3, 0, 8, 0, 4, 0, 12, 0, 4, 128, 105, 255, 255, 0, 0}
-	cp.templatesMap = make(map[uint32]map[uint16][]*entities.InfoElement)
+	cp.templatesMap = make(map[templateDomain]map[uint16][]*entities.InfoElement)
 	_, err = cp.decodePacket(bytes.NewBuffer(templateRecord), address.String())
 	assert.NotNil(t, err, "Error should be logged for malformed template record")
-	if _, exist := cp.templatesMap[uint32(1)]; exist {
+	if _, exist := cp.templatesMap[templateDomain{address.String(), uint32(1)}]; exist {
 		t.Fatal("Template should not be stored for malformed template record")
 	}
 }
 
 func TestCollectingProcess_DecodeDataRecord(t *testing.T) {
 	cp := CollectingProcess{}
-	cp.templatesMap = make(map[uint32]map[uint16][]*entities.InfoElement)
+	cp.templatesMap = make(map[templateDomain]map[uint16][]*entities.InfoElement)
 	cp.mutex = sync.RWMutex{}
 	address, err := net.ResolveTCPAddr(tcpTransport, hostPortIPv4)
 	if err != nil {
@@ -418,7 +631,7 @@ func TestCollectingProcess_DecodeDataRecord(t *testing.T) {
 	_, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), address.String())
 	assert.NotNil(t, err, "Error should be logged if corresponding template does not exist.")
 	// Decode with template
-	cp.addTemplate(uint32(1), uint16(256), elementsWithValueIPv4)
+	cp.addTemplate(address.String(), uint32(1), uint16(256), elementsWithValueIPv4, 0)
 	message, err := cp.decodePacket(bytes.NewBuffer(validDataPacket), address.String())
 	assert.Nil(t, err, "Error should not be logged if corresponding template exists.")
 	assert.Equal(t, uint16(10), message.GetVersion(), "Flow record version should be 10.")
@@ -436,6 +649,44 @@ func TestCollectingProcess_DecodeDataRecord(t *testing.T) {
 	assert.NotNil(t, err, "Error should be logged for malformed data record")
 }
 
+func TestCollectingProcess_DecodeDataRecordWithUnsupportedElement(t *testing.T) {
+	cp := CollectingProcess{}
+	cp.templatesMap = make(map[templateDomain]map[uint16][]*entities.InfoElement)
+	cp.mutex = sync.RWMutex{}
+	address, err := net.ResolveTCPAddr(tcpTransport, hostPortIPv4)
+	if err != nil {
+		t.Error(err)
+	}
+	cp.netAddress = address
+	cp.messageChan = make(chan *entities.Message)
+	go func() { // remove the message from the message channel
+		for range cp.GetMsgChan() {
+		}
+	}()
+	flowStartMicroseconds, err := registry.GetInfoElement("flowStartMicroseconds", registry.IANAEnterpriseID)
+	assert.NoError(t, err)
+	cp.addTemplate(address.String(), uint32(1), uint16(257), []*entities.InfoElementWithValue{
+		elementsWithValueIPv4[0],
+		entities.NewInfoElementWithValue(flowStartMicroseconds, nil),
+		elementsWithValueIPv4[1],
+	}, 0)
+	// The data record has sourceIPv4Address, flowStartMicroseconds and
+	// destinationIPv4Address.
+	dataPacket := []byte{0, 10, 0, 36, 95, 154, 108, 18, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 0, 20, 1, 2, 3, 4, 0, 5, 191, 90, 14, 37, 80, 0, 5, 6, 7, 8}
+	message, err := cp.decodePacket(bytes.NewBuffer(dataPacket), address.String())
+	assert.NoError(t, err, "Records with elements of unsupported data types should be decoded")
+	records := message.GetSet().GetRecords()
+	assert.Len(t, records, 1)
+	_, exist := records[0].GetInfoElementWithValue("flowStartMicroseconds")
+	assert.False(t, exist)
+	sourceIPv4Address, exist := records[0].GetInfoElementWithValue("sourceIPv4Address")
+	assert.True(t, exist)
+	assert.Equal(t, net.IP([]byte{1, 2, 3, 4}), sourceIPv4Address.Value)
+	destinationIPv4Address, exist := records[0].GetInfoElementWithValue("destinationIPv4Address")
+	assert.True(t, exist)
+	assert.Equal(t, net.IP([]byte{5, 6, 7, 8}), destinationIPv4Address.Value)
+}
+
 func TestUDPCollectingProcess_TemplateExpire(t *testing.T) {
 	input := CollectorInput{
 		Address:       hostPortIPv4,
@@ -471,13 +722,45 @@ func TestUDPCollectingProcess_TemplateExpire(t *testing.T) {
 	}()
 	<-cp.GetMsgChan()
 	cp.Stop()
-	template, err := cp.getTemplate(1, 256)
+	template := getTemplateFromAnySession(cp, 1, 256)
 	assert.NotNil(t, template, "Template should be stored in the template map.")
-	assert.Nil(t, err, "Template should be stored in the template map.")
 	time.Sleep(2 * time.Second)
-	template, err = cp.getTemplate(1, 256)
+	template = getTemplateFromAnySession(cp, 1, 256)
 	assert.Nil(t, template, "Template should be deleted after 5 seconds.")
-	assert.NotNil(t, err, "Template should be deleted after 5 seconds.")
+}
+
+func TestUDPCollectingProcess_TemplateRefresh(t *testing.T) {
+	input := CollectorInput{
+		Address:         hostPortIPv4,
+		Protocol:        udpTransport,
+		MaxBufferSize:   1024,
+		TemplateTTL:     1,
+		MessageChanSize: 10,
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	address := "127.0.0.1:30000"
+	_, err = cp.decodePacket(bytes.NewBuffer(validTemplatePacket), address)
+	assert.NoError(t, err)
+	// The template is refreshed before its TTL, so it does not expire at the
+	// end of the first TTL.
+	time.Sleep(600 * time.Millisecond)
+	_, err = cp.decodePacket(bytes.NewBuffer(validTemplatePacket), address)
+	assert.NoError(t, err)
+	time.Sleep(600 * time.Millisecond)
+	_, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), address)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), cp.GetStats().ExpiredTemplates)
+
+	time.Sleep(time.Second)
+	_, err = cp.getTemplate(address, 1, 256)
+	assert.Error(t, err)
+	// Data records of the expired template are rejected and counted.
+	_, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), address)
+	assert.Error(t, err)
+	stats := cp.GetStats()
+	assert.Equal(t, uint64(1), stats.ExpiredTemplates)
+	assert.Equal(t, uint64(1), stats.MissingTemplateDataSets)
 }
 
 func TestTLSCollectingProcess(t *testing.T) {
@@ -516,7 +799,7 @@ func TestTLSCollectingProcess(t *testing.T) {
 	}()
 	<-cp.GetMsgChan()
 	cp.Stop()
-	assert.NotNil(t, cp.templatesMap[1], "TLS Collecting Process should receive and store the received template.")
+	assert.NotNil(t, getTemplateFromAnySession(cp, 1, 256), "TLS Collecting Process should receive and store the received template.")
 }
 
 func TestDTLSCollectingProcess(t *testing.T) {
@@ -548,7 +831,157 @@ func TestDTLSCollectingProcess(t *testing.T) {
 	}()
 	<-cp.GetMsgChan()
 	cp.Stop()
-	assert.NotNil(t, cp.templatesMap[1], "DTLS Collecting Process should receive and store the received template.")
+	assert.NotNil(t, getTemplateFromAnySession(cp, 1, 256), "DTLS Collecting Process should receive and store the received template.")
+}
+
+// generateTestCert returns a CA certificate, and a certificate for 127.0.0.1
+// signed by it with its key, for both server and client authentication.
+func generateTestCert(t *testing.T) ([]byte, []byte, []byte) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ipfix-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	assert.NoError(t, err)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "ipfix"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestTLSCollectingProcess_AllowedClientNames(t *testing.T) {
+	caCert, certPEM, keyPEM := generateTestCert(t)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caCert)
+	for _, tc := range []struct {
+		allowedNames []string
+		allowed      bool
+	}{
+		{[]string{"exporter-1"}, false},
+		{[]string{"exporter-1", "ipfix"}, true},
+		{[]string{"127.0.0.1"}, true},
+	} {
+		input := CollectorInput{
+			Address:            hostPortIPv4,
+			Protocol:           tcpTransport,
+			MaxBufferSize:      1024,
+			IsEncrypted:        true,
+			CACert:             caCert,
+			ServerCert:         certPEM,
+			ServerKey:          keyPEM,
+			AllowedClientNames: tc.allowedNames,
+			MessageChanSize:    1,
+		}
+		cp, err := InitCollectingProcess(input)
+		assert.NoError(t, err)
+		go cp.Start()
+		waitForCollectorReady(t, cp)
+		conn, err := tls.Dial("tcp", cp.GetAddress().String(), &tls.Config{
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{cert},
+		})
+		if err == nil {
+			_, err = conn.Write(validTemplatePacket)
+			assert.NoError(t, err)
+			// With TLS 1.3, the client learns that its certificate is
+			// rejected once it reads from the connection.
+			conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+			_, err = conn.Read(make([]byte, 1))
+			conn.Close()
+		}
+		if tc.allowed {
+			assert.Equal(t, 1, len(cp.GetMsgChan()), "allowed names: %v", tc.allowedNames)
+		} else {
+			assert.Error(t, err)
+			assert.NotContains(t, err.Error(), "timeout")
+			assert.Equal(t, 0, len(cp.GetMsgChan()), "allowed names: %v", tc.allowedNames)
+		}
+		cp.Stop()
+	}
+
+	// Client certificates cannot be verified without a CA.
+	_, err = InitCollectingProcess(CollectorInput{
+		Address:            hostPortIPv4,
+		Protocol:           tcpTransport,
+		IsEncrypted:        true,
+		ServerCert:         certPEM,
+		ServerKey:          keyPEM,
+		AllowedClientNames: []string{"ipfix"},
+	})
+	assert.Error(t, err)
+}
+
+func TestDTLSCollectingProcess_ClientAuth(t *testing.T) {
+	caCert, certPEM, keyPEM := generateTestCert(t)
+	input := CollectorInput{
+		Address:         hostPortIPv4,
+		Protocol:        udpTransport,
+		MaxBufferSize:   1024,
+		IsEncrypted:     true,
+		CACert:          caCert,
+		ServerCert:      certPEM,
+		ServerKey:       keyPEM,
+		MessageChanSize: 10,
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	go cp.Start()
+	waitForCollectorReady(t, cp)
+	defer cp.Stop()
+	collectorAddr, _ := net.ResolveUDPAddr("udp", cp.GetAddress().String())
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caCert)
+
+	// Exporters without a client certificate are rejected.
+	config := &dtls.Config{
+		RootCAs:              roots,
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+		ConnectContextMaker: func() (context.Context, func()) {
+			return context.WithTimeout(context.Background(), time.Second)
+		},
+	}
+	_, err = dtls.Dial("udp", collectorAddr, config)
+	assert.Error(t, err)
+
+	// Every exporter has its own association.
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.NoError(t, err)
+	config.Certificates = []tls.Certificate{cert}
+	for i := 0; i < 2; i++ {
+		conn, err := dtls.Dial("udp", collectorAddr, config)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		_, err = conn.Write(validTemplatePacket)
+		assert.NoError(t, err)
+		message := <-cp.GetMsgChan()
+		assert.Equal(t, "127.0.0.1", message.GetExportAddress())
+	}
+	assert.Equal(t, 2, cp.getClientCount())
 }
 
 func TestTCPCollectingProcessIPv6(t *testing.T) {
@@ -573,7 +1006,7 @@ func TestTCPCollectingProcessIPv6(t *testing.T) {
 	<-cp.GetMsgChan()
 	message := <-cp.GetMsgChan()
 	cp.Stop()
-	template, _ := cp.getTemplate(1, 256)
+	template := getTemplateFromAnySession(cp, 1, 256)
 	assert.NotNil(t, template)
 	ie, exist := message.GetSet().GetRecords()[0].GetInfoElementWithValue("sourceIPv6Address")
 	assert.True(t, exist)
@@ -602,7 +1035,7 @@ func TestUDPCollectingProcessIPv6(t *testing.T) {
 	<-cp.GetMsgChan()
 	message := <-cp.GetMsgChan()
 	cp.Stop()
-	template, _ := cp.getTemplate(1, 256)
+	template := getTemplateFromAnySession(cp, 1, 256)
 	assert.NotNil(t, template)
 	ie, exist := message.GetSet().GetRecords()[0].GetInfoElementWithValue("sourceIPv6Address")
 	assert.True(t, exist)
@@ -662,6 +1095,17 @@ func getCollectorInput(network string, isEncrypted bool, isIPv6 bool) CollectorI
 	}
 }
 
+// getTemplateFromAnySession returns the template received in any exporter
+// session, or nil.
+func getTemplateFromAnySession(cp *CollectingProcess, obsDomainID uint32, templateID uint16) []*entities.InfoElement {
+	for _, template := range cp.GetTemplates() {
+		if template.ObsDomainID == obsDomainID && template.TemplateID == templateID {
+			return template.Elements
+		}
+	}
+	return nil
+}
+
 func waitForCollectorReady(t *testing.T, cp *CollectingProcess) {
 	checkConn := func() (bool, error) {
 		if conn, err := net.Dial(cp.GetAddress().Network(), cp.GetAddress().String()); err != nil {
@@ -675,3 +1119,1926 @@ func waitForCollectorReady(t *testing.T, cp *CollectingProcess) {
 		t.Errorf("Cannot establish connection to %s", cp.GetAddress().String())
 	}
 }
+
+func TestCollectingProcess_DecodeTypeRecords(t *testing.T) {
+	cp := CollectingProcess{}
+	cp.templatesMap = make(map[templateDomain]map[uint16][]*entities.InfoElement)
+	cp.mutex = sync.RWMutex{}
+	cp.protocol = tcpTransport
+	cp.messageChan = make(chan *entities.Message)
+	go func() { // remove the message from the message channel
+		for range cp.GetMsgChan() {
+		}
+	}()
+	address := "127.0.0.1:4739"
+	// Options template 257 scoped by informationElementId and privateEnterpriseNumber.
+	optionsTemplatePacket := []byte{0, 10, 0, 46, 96, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 3, 0, 30, 1, 1, 0, 5, 0, 2, 1, 47, 0, 2, 1, 90, 0, 4, 1, 83, 0, 1, 1, 88, 0, 1, 1, 85, 255, 255}
+	// Type record for element customField (elementID 1, enterpriseID 99999, unsigned32).
+	typeRecordPacket := []byte{0, 10, 0, 40, 96, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 1, 1, 0, 24, 0, 1, 0, 1, 134, 159, 3, 0, 11, 99, 117, 115, 116, 111, 109, 70, 105, 101, 108, 100}
+	// Template 258 with customField.
+	templatePacket := []byte{0, 10, 0, 32, 96, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 0, 2, 0, 16, 1, 2, 0, 1, 128, 1, 0, 4, 0, 1, 134, 159}
+	dataPacket := []byte{0, 10, 0, 24, 96, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 1, 1, 2, 0, 8, 0, 0, 0, 42}
+
+	// The element is unknown before the type record is received.
+	message, err := cp.decodePacket(bytes.NewBuffer(templatePacket), address)
+	assert.NoError(t, err)
+	assert.Equal(t, "enterprise_99999_id_1", message.GetSet().GetRecords()[0].GetOrderedElementList()[0].Element.Name)
+	message, err = cp.decodePacket(bytes.NewBuffer(optionsTemplatePacket), address)
+	assert.NoError(t, err)
+	assert.Equal(t, entities.OptionsTemplate, message.GetSet().GetSetType())
+	assert.Equal(t, uint16(2), message.GetSet().GetRecords()[0].GetScopeFieldCount())
+	_, err = cp.decodePacket(bytes.NewBuffer(typeRecordPacket), address)
+	assert.NoError(t, err)
+	_, err = cp.decodePacket(bytes.NewBuffer(templatePacket), address)
+	assert.NoError(t, err)
+	message, err = cp.decodePacket(bytes.NewBuffer(dataPacket), address)
+	assert.NoError(t, err)
+	customField, exist := message.GetSet().GetRecords()[0].GetInfoElementWithValue("customField")
+	assert.True(t, exist)
+	assert.Equal(t, uint32(99999), customField.Element.EnterpriseId)
+	assert.Equal(t, uint32(42), customField.Value)
+	// Elements learned from type records are only known to the session.
+	message, err = cp.decodePacket(bytes.NewBuffer(templatePacket), "127.0.0.1:4740")
+	assert.NoError(t, err)
+	assert.Equal(t, "enterprise_99999_id_1", message.GetSet().GetRecords()[0].GetOrderedElementList()[0].Element.Name)
+	cp.deleteClient(address)
+	message, err = cp.decodePacket(bytes.NewBuffer(templatePacket), address)
+	assert.NoError(t, err)
+	assert.Equal(t, "enterprise_99999_id_1", message.GetSet().GetRecords()[0].GetOrderedElementList()[0].Element.Name)
+}
+
+func TestCollectingProcess_DecodeOptionsDataRecords(t *testing.T) {
+	cp := CollectingProcess{}
+	cp.templatesMap = make(map[templateDomain]map[uint16][]*entities.InfoElement)
+	cp.mutex = sync.RWMutex{}
+	cp.protocol = tcpTransport
+	cp.messageChan = make(chan *entities.Message)
+	go func() { // remove the message from the message channel
+		for range cp.GetMsgChan() {
+		}
+	}()
+	address := "127.0.0.1:4739"
+	// Options template 259 with exportedMessageTotalCount and
+	// exportedFlowRecordTotalCount scoped by exportingProcessId.
+	optionsTemplatePacket := []byte{0, 10, 0, 38, 96, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 3, 0, 22, 1, 3, 0, 3, 0, 1, 0, 144, 0, 4, 0, 41, 0, 8, 0, 42, 0, 8}
+	dataPacket := []byte{0, 10, 0, 40, 96, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 1, 3, 0, 24, 0, 0, 0, 7, 0, 0, 0, 0, 0, 0, 0, 100, 0, 0, 0, 0, 0, 0, 3, 232}
+
+	message, err := cp.decodePacket(bytes.NewBuffer(optionsTemplatePacket), address)
+	assert.NoError(t, err)
+	scopeElements := message.GetSet().GetRecords()[0].GetScopeElements()
+	assert.Len(t, scopeElements, 1)
+	assert.Equal(t, "exportingProcessId", scopeElements[0].Element.Name)
+	message, err = cp.decodePacket(bytes.NewBuffer(dataPacket), address)
+	assert.NoError(t, err)
+	record := message.GetSet().GetRecords()[0]
+	assert.Equal(t, uint16(1), record.GetScopeFieldCount())
+	scopeElements = record.GetScopeElements()
+	assert.Len(t, scopeElements, 1)
+	assert.Equal(t, "exportingProcessId", scopeElements[0].Element.Name)
+	assert.Equal(t, uint32(7), scopeElements[0].Value)
+	ieWithValue, _ := record.GetInfoElementWithValue("exportedFlowRecordTotalCount")
+	assert.Equal(t, uint64(1000), ieWithValue.Value)
+
+	// The data records of a template replacing the options template have no
+	// scope.
+	templatePacket := []byte{0, 10, 0, 28, 96, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 1, 0, 2, 0, 12, 1, 3, 0, 1, 0, 144, 0, 4}
+	_, err = cp.decodePacket(bytes.NewBuffer(templatePacket), address)
+	assert.NoError(t, err)
+	dataPacket = []byte{0, 10, 0, 24, 96, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 1, 1, 3, 0, 8, 0, 0, 0, 7}
+	message, err = cp.decodePacket(bytes.NewBuffer(dataPacket), address)
+	assert.NoError(t, err)
+	assert.Nil(t, message.GetSet().GetRecords()[0].GetScopeElements())
+
+	// Options template withdrawals have no scope field count.
+	withdrawalPacket := []byte{0, 10, 0, 24, 96, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 1, 0, 3, 0, 8, 1, 3, 0, 0}
+	_, err = cp.decodePacket(bytes.NewBuffer(withdrawalPacket), address)
+	assert.NoError(t, err)
+	_, err = cp.getTemplate(address, 1, 259)
+	assert.Error(t, err)
+}
+
+func TestCollectingProcess_RegistryOverrides(t *testing.T) {
+	input := CollectorInput{
+		Address:       hostPortIPv4,
+		Protocol:      tcpTransport,
+		MaxBufferSize: 1024,
+		RegistryOverrides: map[string][]*entities.InfoElement{
+			"127.0.0.1": {entities.NewInfoElement("customCounter", 1, entities.Unsigned32, 99999, 4)},
+			"127.0.0.2": {entities.NewInfoElement("customAddress", 1, entities.Ipv4Address, 99999, 4)},
+		},
+	}
+	cp, _ := InitCollectingProcess(input)
+	go func() { // remove the message from the message channel
+		for range cp.GetMsgChan() {
+		}
+	}()
+	// Template 258 with element 1 of enterprise 99999, followed by a data record.
+	templatePacket := []byte{0, 10, 0, 32, 96, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 0, 2, 0, 16, 1, 2, 0, 1, 128, 1, 0, 4, 0, 1, 134, 159}
+	dataPacket := []byte{0, 10, 0, 24, 96, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 1, 1, 2, 0, 8, 10, 0, 0, 1}
+
+	_, err := cp.decodePacket(bytes.NewBuffer(templatePacket), "127.0.0.1:30000")
+	assert.NoError(t, err)
+	message, err := cp.decodePacket(bytes.NewBuffer(dataPacket), "127.0.0.1:30000")
+	assert.NoError(t, err)
+	customCounter, exist := message.GetSet().GetRecords()[0].GetInfoElementWithValue("customCounter")
+	assert.True(t, exist)
+	assert.Equal(t, uint32(0x0a000001), customCounter.Value)
+	assert.Equal(t, uint64(0), cp.GetStats().RegistryConflicts)
+
+	_, err = cp.decodePacket(bytes.NewBuffer(templatePacket), "127.0.0.2:30000")
+	assert.NoError(t, err)
+	message, err = cp.decodePacket(bytes.NewBuffer(dataPacket), "127.0.0.2:30000")
+	assert.NoError(t, err)
+	customAddress, exist := message.GetSet().GetRecords()[0].GetInfoElementWithValue("customAddress")
+	assert.True(t, exist)
+	assert.Equal(t, net.IP([]byte{10, 0, 0, 1}), customAddress.Value)
+	assert.Equal(t, uint64(1), cp.GetStats().RegistryConflicts)
+	// Registering the same definition again, e.g. from refreshed type records,
+	// is not counted as another conflict.
+	cp.registerSessionElement(cp.getSessionRegistry("127.0.0.2:30000", "127.0.0.2"), entities.NewInfoElement("customAddress", 1, entities.Ipv4Address, 99999, 4), 0)
+	assert.Equal(t, uint64(1), cp.GetStats().RegistryConflicts)
+	// Exporters without overrides do not know the element.
+	message, err = cp.decodePacket(bytes.NewBuffer(templatePacket), "127.0.0.3:30000")
+	assert.NoError(t, err)
+	assert.Equal(t, "enterprise_99999_id_1", message.GetSet().GetRecords()[0].GetOrderedElementList()[0].Element.Name)
+
+	// Sessions are never visible without their overrides.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sessionRegistry := cp.getSessionRegistry("127.0.0.1:30001", "127.0.0.1")
+			_, exist := sessionRegistry.GetSessionInfoElementFromID(1, 99999)
+			assert.True(t, exist)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCollectingProcess_TemplateUsageAndPruning(t *testing.T) {
+	cp := CollectingProcess{}
+	cp.templatesMap = make(map[templateDomain]map[uint16][]*entities.InfoElement)
+	cp.mutex = sync.RWMutex{}
+	cp.protocol = tcpTransport
+	cp.templateIdleTimeout = time.Minute
+	cp.messageChan = make(chan *entities.Message)
+	go func() { // remove the message from the message channel
+		for range cp.GetMsgChan() {
+		}
+	}()
+	cp.addTemplate("127.0.0.1:4739", uint32(1), uint16(256), elementsWithValueIPv4, 0)
+	cp.addTemplate("127.0.0.1:4739", uint32(1), uint16(257), elementsWithValueIPv4, 0)
+	_, err := cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.1:4739")
+	assert.NoError(t, err)
+
+	stats := cp.GetStats()
+	assert.Len(t, stats.Templates, 2)
+	for _, templateStats := range stats.Templates {
+		assert.Equal(t, uint32(1), templateStats.ObsDomainID)
+		if templateStats.TemplateID == 256 {
+			assert.Equal(t, uint64(1), templateStats.DataRecords)
+		} else {
+			assert.Equal(t, uint64(0), templateStats.DataRecords)
+		}
+	}
+	// Nothing is pruned before the idle timeout.
+	assert.Equal(t, 0, cp.pruneTemplates(time.Now()))
+	// Template 257 is unused for the idle timeout.
+	cp.templateUsageMap[templateUsageKey{"127.0.0.1:4739", 1, 257}].lastUsed = time.Now().Add(-2 * time.Minute)
+	assert.Equal(t, 1, cp.pruneTemplates(time.Now()))
+	_, err = cp.getTemplate("127.0.0.1:4739", uint32(1), uint16(257))
+	assert.Error(t, err)
+	_, err = cp.getTemplate("127.0.0.1:4739", uint32(1), uint16(256))
+	assert.NoError(t, err)
+	stats = cp.GetStats()
+	assert.Len(t, stats.Templates, 1)
+	assert.Equal(t, uint64(1), stats.PrunedTemplates)
+
+	// Records are still counted when the template is received again, but not
+	// when it changes.
+	lastUsed := stats.Templates[0].LastUsed
+	cp.addTemplate("127.0.0.1:4739", uint32(1), uint16(256), elementsWithValueIPv4, 0)
+	stats = cp.GetStats()
+	assert.Equal(t, uint64(1), stats.Templates[0].DataRecords)
+	assert.Equal(t, lastUsed, stats.Templates[0].LastUsed)
+	cp.addTemplate("127.0.0.1:4739", uint32(1), uint16(256), elementsWithValueIPv4[:2], 0)
+	assert.Equal(t, uint64(0), cp.GetStats().Templates[0].DataRecords)
+}
+
+func TestCollectingProcess_TemplateUsagePerSession(t *testing.T) {
+	cp := CollectingProcess{}
+	cp.templatesMap = make(map[templateDomain]map[uint16][]*entities.InfoElement)
+	cp.mutex = sync.RWMutex{}
+	cp.protocol = tcpTransport
+	cp.templateIdleTimeout = time.Minute
+	cp.messageChan = make(chan *entities.Message, 2)
+	// Both exporters send template 256 in observation domain 1.
+	cp.addTemplate("127.0.0.1:4739", uint32(1), uint16(256), elementsWithValueIPv4, 0)
+	cp.addTemplate("127.0.0.2:4739", uint32(1), uint16(256), elementsWithValueIPv4, 0)
+	_, err := cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.1:4739")
+	assert.NoError(t, err)
+
+	stats := cp.GetStats()
+	assert.Len(t, stats.Templates, 2)
+	for _, templateStats := range stats.Templates {
+		if templateStats.ExporterAddress == "127.0.0.1:4739" {
+			assert.Equal(t, uint64(1), templateStats.DataRecords)
+		} else {
+			assert.Equal(t, uint64(0), templateStats.DataRecords)
+		}
+	}
+	// Only the template of the idle exporter is pruned.
+	cp.templateUsageMap[templateUsageKey{"127.0.0.2:4739", 1, 256}].lastUsed = time.Now().Add(-2 * time.Minute)
+	assert.Equal(t, 1, cp.pruneTemplates(time.Now()))
+	_, err = cp.getTemplate("127.0.0.1:4739", uint32(1), uint16(256))
+	assert.NoError(t, err)
+	_, err = cp.getTemplate("127.0.0.2:4739", uint32(1), uint16(256))
+	assert.Error(t, err)
+	stats = cp.GetStats()
+	assert.Len(t, stats.Templates, 1)
+	assert.Equal(t, "127.0.0.1:4739", stats.Templates[0].ExporterAddress)
+	assert.Equal(t, 1, cp.pruneTemplates(time.Now().Add(2*time.Minute)))
+	_, err = cp.getTemplate("127.0.0.1:4739", uint32(1), uint16(256))
+	assert.Error(t, err)
+}
+
+func TestCollectingProcess_TemplatesPerExporter(t *testing.T) {
+	cp := CollectingProcess{}
+	cp.templatesMap = make(map[templateDomain]map[uint16][]*entities.InfoElement)
+	cp.mutex = sync.RWMutex{}
+	cp.protocol = tcpTransport
+	cp.messageChan = make(chan *entities.Message, 4)
+	// Both exporters use template 256 in observation domain 1 with different
+	// fields.
+	_, err := cp.decodePacket(bytes.NewBuffer(validTemplatePacket), "127.0.0.1:4739")
+	assert.NoError(t, err)
+	_, err = cp.decodePacket(bytes.NewBuffer(validTemplatePacketIPv6), "127.0.0.2:4739")
+	assert.NoError(t, err)
+
+	message, err := cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.1:4739")
+	assert.NoError(t, err)
+	ie, exist := message.GetSet().GetRecords()[0].GetInfoElementWithValue("sourceIPv4Address")
+	assert.True(t, exist)
+	assert.Equal(t, net.IP([]byte{1, 2, 3, 4}), ie.Value)
+	message, err = cp.decodePacket(bytes.NewBuffer(validDataPacketIPv6), "127.0.0.2:4739")
+	assert.NoError(t, err)
+	ie, exist = message.GetSet().GetRecords()[0].GetInfoElementWithValue("sourceIPv6Address")
+	assert.True(t, exist)
+	assert.Equal(t, net.ParseIP("2001:0:3238:dfe1:63::fefb"), ie.Value)
+	// The template of an exporter is unknown to other exporters.
+	_, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.3:4739")
+	assert.Error(t, err)
+
+	templates := cp.GetTemplates()
+	if assert.Len(t, templates, 2) {
+		assert.Equal(t, "127.0.0.1:4739", templates[0].ExporterAddress)
+		assert.Len(t, templates[0].Elements, 3)
+		assert.Equal(t, "127.0.0.2:4739", templates[1].ExporterAddress)
+		assert.Len(t, templates[1].Elements, 2)
+	}
+	// Templates of an exporter are selected by session or IP address.
+	for _, exporter := range []string{"127.0.0.2:4739", "127.0.0.2"} {
+		templates = cp.GetExporterTemplates(exporter, 1)
+		if assert.Len(t, templates, 1) {
+			assert.Equal(t, uint16(256), templates[0].TemplateID)
+			assert.Equal(t, "sourceIPv6Address", templates[0].Elements[0].Name)
+			assert.Equal(t, uint16(16), templates[0].Elements[0].Len)
+		}
+	}
+	assert.Empty(t, cp.GetExporterTemplates("127.0.0.2", 2))
+	assert.Empty(t, cp.GetExporterTemplates("127.0.0.3", 1))
+}
+
+func TestCollectingProcess_OverloadPolicy(t *testing.T) {
+	input := CollectorInput{
+		Address:         hostPortIPv4,
+		Protocol:        udpTransport,
+		MaxBufferSize:   1024,
+		MessageChanSize: 1,
+		OverloadPolicy:  OverloadPolicyDrop,
+	}
+	cp, _ := InitCollectingProcess(input)
+	cp.addTemplate("127.0.0.1:4739", uint32(1), uint16(256), elementsWithValueIPv4, 0)
+	// The second message is dropped as nobody reads from the channel.
+	for i := 0; i < 2; i++ {
+		_, err := cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.1:4739")
+		assert.NoError(t, err)
+	}
+	stats := cp.GetStats()
+	assert.Equal(t, 1, stats.MessageBacklog)
+	assert.Equal(t, uint64(1), stats.DroppedMessages)
+	<-cp.GetMsgChan()
+	assert.Equal(t, 0, cp.GetStats().MessageBacklog)
+}
+
+func TestCollectingProcess_OverloadPolicyDropOldest(t *testing.T) {
+	input := CollectorInput{
+		Address:        hostPortIPv4,
+		Protocol:       udpTransport,
+		MaxBufferSize:  1024,
+		OverloadPolicy: OverloadPolicyDropOldest,
+	}
+	_, err := InitCollectingProcess(input)
+	assert.Error(t, err)
+	input.MessageChanSize = 2
+	input.TransportOverloadPolicies = map[string]OverloadPolicy{tcpTransport: OverloadPolicyDropOldest + 1}
+	_, err = InitCollectingProcess(input)
+	assert.Error(t, err)
+	input.TransportOverloadPolicies = nil
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	cp.addTemplate("127.0.0.1:4739", uint32(1), uint16(256), elementsWithValueIPv4, 0)
+	// The first message is dropped to make room for the third one.
+	messages := make([]*entities.Message, 0)
+	for i := 0; i < 3; i++ {
+		message, err := cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.1:4739")
+		assert.NoError(t, err)
+		messages = append(messages, message)
+	}
+	stats := cp.GetStats()
+	assert.Equal(t, 2, stats.MessageBacklog)
+	assert.Equal(t, uint64(1), stats.DroppedMessages)
+	assert.Same(t, messages[1], <-cp.GetMsgChan())
+	assert.Same(t, messages[2], <-cp.GetMsgChan())
+}
+
+func TestCollectingProcess_TransportOverloadPolicies(t *testing.T) {
+	input := CollectorInput{
+		Address:                   hostPortIPv4,
+		Protocol:                  tcpTransport,
+		MaxBufferSize:             1024,
+		MessageChanSize:           1,
+		OverloadPolicy:            OverloadPolicyBlock,
+		TransportOverloadPolicies: map[string]OverloadPolicy{udpTransport: OverloadPolicyDrop},
+	}
+	cp, _ := InitCollectingProcess(input)
+	assert.Equal(t, OverloadPolicyBlock, cp.createClient(tcpTransport).overloadPolicy)
+	assert.Equal(t, OverloadPolicyDrop, cp.createClient(udpTransport).overloadPolicy)
+	cp.addClient("127.0.0.1:4739", cp.createClient(udpTransport))
+	cp.addTemplate("127.0.0.1:4739", uint32(1), uint16(256), elementsWithValueIPv4, 0)
+	// The session uses the policy of its transport, so the second message is
+	// dropped instead of blocking.
+	for i := 0; i < 2; i++ {
+		_, err := cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.1:4739")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, uint64(1), cp.GetStats().DroppedMessages)
+}
+
+func TestCollectingProcess_Tags(t *testing.T) {
+	siteElement := entities.NewInfoElement("site", 1, entities.String, 99999, entities.VariableLength)
+	rackElement := entities.NewInfoElement("rack", 2, entities.Unsigned16, 99999, 2)
+	podNameElement, _ := registry.GetInfoElement("sourcePodName", registry.AntreaEnterpriseID)
+	input := CollectorInput{
+		Address:       hostPortIPv4,
+		Protocol:      tcpTransport,
+		MaxBufferSize: 1024,
+		ObservationPointTags: []*entities.InfoElementWithValue{
+			entities.NewInfoElementWithValue(siteElement, "site-a"),
+		},
+		SourceTags: []SourceTags{
+			{"127.0.0.0/24", []*entities.InfoElementWithValue{entities.NewInfoElementWithValue(rackElement, uint16(7))}},
+			{"127.0.0.2/32", []*entities.InfoElementWithValue{entities.NewInfoElementWithValue(siteElement, "site-b")}},
+			{"127.0.0.3/32", []*entities.InfoElementWithValue{entities.NewInfoElementWithValue(podNameElement, "pod-tagged")}},
+		},
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	go func() { // remove the message from the message channel
+		for range cp.GetMsgChan() {
+		}
+	}()
+
+	message, err := cp.decodePacket(bytes.NewBuffer(validTemplatePacket), "127.0.0.1:30000")
+	assert.NoError(t, err)
+	site, exist := message.GetSet().GetRecords()[0].GetInfoElementWithValue("site")
+	assert.True(t, exist)
+	assert.Nil(t, site.Value)
+	message, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.1:30000")
+	assert.NoError(t, err)
+	record := message.GetSet().GetRecords()[0]
+	site, _ = record.GetInfoElementWithValue("site")
+	assert.Equal(t, "site-a", site.Value)
+	rack, exist := record.GetInfoElementWithValue("rack")
+	assert.True(t, exist)
+	assert.Equal(t, uint16(7), rack.Value)
+
+	// Source tags replace the observation point tag with the same element.
+	_, err = cp.decodePacket(bytes.NewBuffer(validTemplatePacket), "127.0.0.2:30000")
+	assert.NoError(t, err)
+	message, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.2:30000")
+	assert.NoError(t, err)
+	record = message.GetSet().GetRecords()[0]
+	site, _ = record.GetInfoElementWithValue("site")
+	assert.Equal(t, "site-b", site.Value)
+	assert.Equal(t, 5, len(record.GetOrderedElementList()))
+
+	// Tags replace the values of the elements of the records.
+	message, err = cp.decodePacket(bytes.NewBuffer(validTemplatePacket), "127.0.0.3:30000")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(message.GetSet().GetRecords()[0].GetOrderedElementList()))
+	message, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.3:30000")
+	assert.NoError(t, err)
+	record = message.GetSet().GetRecords()[0]
+	podName, _ := record.GetInfoElementWithValue("sourcePodName")
+	assert.Equal(t, "pod-tagged", podName.Value)
+	assert.Equal(t, 5, len(record.GetOrderedElementList()))
+
+	_, err = cp.decodePacket(bytes.NewBuffer(validTemplatePacket), "10.0.0.1:30000")
+	assert.NoError(t, err)
+	message, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), "10.0.0.1:30000")
+	assert.NoError(t, err)
+	_, exist = message.GetSet().GetRecords()[0].GetInfoElementWithValue("rack")
+	assert.False(t, exist)
+
+	input.SourceTags = []SourceTags{{"127.0.0.0", nil}}
+	_, err = InitCollectingProcess(input)
+	assert.Error(t, err)
+	input.SourceTags = nil
+	input.ObservationPointTags = []*entities.InfoElementWithValue{entities.NewInfoElementWithValue(rackElement, "rack-1")}
+	_, err = InitCollectingProcess(input)
+	assert.Error(t, err)
+}
+
+func TestCollectingProcess_ObsDomainNameResolver(t *testing.T) {
+	input := CollectorInput{
+		Address:       hostPortIPv4,
+		Protocol:      tcpTransport,
+		MaxBufferSize: 1024,
+		ObservationDomainNameResolver: func(exporterIP string, obsDomainID uint32) string {
+			if exporterIP == "127.0.0.1" && obsDomainID == 1 {
+				return "node-1"
+			}
+			return ""
+		},
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	go func() { // remove the message from the message channel
+		for range cp.GetMsgChan() {
+		}
+	}()
+
+	message, err := cp.decodePacket(bytes.NewBuffer(validTemplatePacket), "127.0.0.1:30000")
+	assert.NoError(t, err)
+	assert.Equal(t, "node-1", message.GetObsDomainName())
+	_, exist := message.GetSet().GetRecords()[0].GetInfoElementWithValue("observationDomainName")
+	assert.True(t, exist)
+	message, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.1:30000")
+	assert.NoError(t, err)
+	assert.Equal(t, "node-1", message.GetObsDomainName())
+	name, _ := message.GetSet().GetRecords()[0].GetInfoElementWithValue("observationDomainName")
+	assert.Equal(t, "node-1", name.Value)
+
+	// The element is attached with an empty value to the records of unknown
+	// observation domains.
+	_, err = cp.decodePacket(bytes.NewBuffer(validTemplatePacket), "127.0.0.2:30000")
+	assert.NoError(t, err)
+	message, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.2:30000")
+	assert.NoError(t, err)
+	assert.Equal(t, "", message.GetObsDomainName())
+	name, exist = message.GetSet().GetRecords()[0].GetInfoElementWithValue("observationDomainName")
+	assert.True(t, exist)
+	assert.Equal(t, "", name.Value)
+}
+
+func TestCollectingProcess_Subscribe(t *testing.T) {
+	input := CollectorInput{
+		Address:       hostPortIPv4,
+		Protocol:      tcpTransport,
+		MaxBufferSize: 1024,
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	go func() { // remove the message from the message channel
+		for range cp.GetMsgChan() {
+		}
+	}()
+	_, err = cp.Subscribe(filter.RecordFilter{SourceCIDRs: []string{"1.2.3"}}, 1)
+	assert.Error(t, err)
+	matching, err := cp.Subscribe(filter.RecordFilter{SourceCIDRs: []string{"1.2.3.0/24"}}, 10)
+	assert.NoError(t, err)
+	other, err := cp.Subscribe(filter.RecordFilter{DestinationCIDRs: []string{"10.0.0.0/8"}}, 10)
+	assert.NoError(t, err)
+
+	_, err = cp.decodePacket(bytes.NewBuffer(validTemplatePacket), "127.0.0.1:30000")
+	assert.NoError(t, err)
+	_, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.1:30000")
+	assert.NoError(t, err)
+
+	// Template sets are delivered to every subscription.
+	assert.Equal(t, 2, len(matching.GetMsgChan()))
+	assert.Equal(t, 1, len(other.GetMsgChan()))
+	message := <-other.GetMsgChan()
+	assert.Equal(t, entities.Template, message.GetSet().GetSetType())
+	<-matching.GetMsgChan()
+	message = <-matching.GetMsgChan()
+	assert.Equal(t, entities.Data, message.GetSet().GetSetType())
+	assert.Equal(t, uint32(1), message.GetSet().GetNumberOfRecords())
+
+	// A full subscription channel does not block the collector.
+	full, err := cp.Subscribe(filter.RecordFilter{}, 0)
+	assert.NoError(t, err)
+	_, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.1:30000")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), full.GetDroppedMessages())
+
+	cp.Unsubscribe(matching)
+	_, ok := <-matching.GetMsgChan()
+	assert.True(t, ok)
+	_, ok = <-matching.GetMsgChan()
+	assert.False(t, ok)
+}
+
+func TestCollectingProcess_SubscribeCopies(t *testing.T) {
+	input := CollectorInput{
+		Address:       hostPortIPv4,
+		Protocol:      tcpTransport,
+		MaxBufferSize: 1024,
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	go func() { // remove the message from the message channel
+		for range cp.GetMsgChan() {
+		}
+	}()
+	subscription1, err := cp.Subscribe(filter.RecordFilter{}, 10)
+	assert.NoError(t, err)
+	subscription2, err := cp.Subscribe(filter.RecordFilter{}, 10)
+	assert.NoError(t, err)
+	_, err = cp.decodePacket(bytes.NewBuffer(validTemplatePacket), "127.0.0.1:30000")
+	assert.NoError(t, err)
+	_, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.1:30000")
+	assert.NoError(t, err)
+	<-subscription1.GetMsgChan()
+	<-subscription2.GetMsgChan()
+
+	// Subscriptions receive their own copies of the records, even when all the
+	// records match.
+	record1 := (<-subscription1.GetMsgChan()).GetSet().GetRecords()[0]
+	record2 := (<-subscription2.GetMsgChan()).GetSet().GetRecords()[0]
+	assert.NotSame(t, record1, record2)
+	element, _ := registry.GetInfoElement("originalObservationDomainId", registry.IANAEnterpriseID)
+	_, err = record1.AddInfoElement(entities.NewInfoElementWithValue(element, uint32(1)), false)
+	assert.NoError(t, err)
+	_, exist := record2.GetInfoElementWithValue("originalObservationDomainId")
+	assert.False(t, exist)
+	assert.Equal(t, len(record1.GetOrderedElementList())-1, len(record2.GetOrderedElementList()))
+}
+
+func TestFilterMessage(t *testing.T) {
+	message := entities.NewMessage(true)
+	message.SetObsDomainID(1)
+	set := entities.NewSet(true)
+	assert.NoError(t, set.PrepareSet(entities.Data, 256))
+	element, _ := registry.GetInfoElement("protocolIdentifier", registry.IANAEnterpriseID)
+	for _, protocol := range []uint8{6, 17, 6} {
+		err := set.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, bytes.NewBuffer([]byte{protocol}))}, 256)
+		assert.NoError(t, err)
+	}
+	message.AddSet(set)
+
+	f, _ := filter.NewFilter(filter.RecordFilter{Protocols: []uint8{6}})
+	filtered := filterMessage(message, f)
+	assert.Equal(t, uint32(1), filtered.GetObsDomainID())
+	assert.Equal(t, uint32(2), filtered.GetSet().GetNumberOfRecords())
+	assert.Equal(t, uint32(3), message.GetSet().GetNumberOfRecords())
+	f, _ = filter.NewFilter(filter.RecordFilter{Protocols: []uint8{1}})
+	assert.Nil(t, filterMessage(message, f))
+	f, _ = filter.NewFilter(filter.RecordFilter{})
+	assert.Equal(t, message, filterMessage(message, f))
+}
+
+func TestTLSCollectingProcess_AllowPlainTCP(t *testing.T) {
+	input := getCollectorInput(tcpTransport, true, false)
+	input.AllowPlainTCP = true
+	input.ALPNProtocols = []string{"ipfix"}
+	cp, err := InitCollectingProcess(input)
+	if err != nil {
+		t.Fatalf("Collecting Process does not initiate correctly: %v", err)
+	}
+	go cp.Start()
+	// wait until collector is ready
+	waitForCollectorReady(t, cp)
+	collectorAddr := cp.GetAddress()
+
+	roots := x509.NewCertPool()
+	assert.True(t, roots.AppendCertsFromPEM([]byte(fakeCACert)))
+	cert, err := tls.X509KeyPair([]byte(fakeClientCert), []byte(fakeClientKey))
+	assert.NoError(t, err)
+	config := &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"ipfix"},
+	}
+	tlsConn, err := tls.Dial("tcp", collectorAddr.String(), config)
+	assert.NoError(t, err)
+	defer tlsConn.Close()
+	assert.Equal(t, "ipfix", tlsConn.ConnectionState().NegotiatedProtocol)
+	_, err = tlsConn.Write(validTemplatePacket)
+	assert.NoError(t, err)
+	message := <-cp.GetMsgChan()
+	assert.Equal(t, entities.Template, message.GetSet().GetSetType())
+
+	conn, err := net.Dial("tcp", collectorAddr.String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(append(append([]byte{}, validTemplatePacket...), validDataPacket...))
+	assert.NoError(t, err)
+	<-cp.GetMsgChan()
+	message = <-cp.GetMsgChan()
+	assert.Equal(t, entities.Data, message.GetSet().GetSetType())
+
+	// Exporters offering only other application protocols are rejected.
+	config.NextProtos = []string{"h2"}
+	_, err = tls.Dial("tcp", collectorAddr.String(), config)
+	assert.Error(t, err)
+	cp.Stop()
+}
+
+func TestCollectingProcess_DumpMessages(t *testing.T) {
+	dump := new(bytes.Buffer)
+	input := CollectorInput{
+		Address:         hostPortIPv4,
+		Protocol:        tcpTransport,
+		MessageChanSize: 10,
+		DumpMessages:    2,
+		DumpWriter:      dump,
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	// The data message cannot be decoded without the template.
+	_, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.1:30000")
+	assert.Error(t, err)
+	assert.Contains(t, dump.String(), "Message 1 from 127.0.0.1:30000:\nCannot be decoded: ")
+	assert.Contains(t, dump.String(), hex.Dump(validDataPacket))
+	_, err = cp.decodePacket(bytes.NewBuffer(validTemplatePacket), "127.0.0.1:30000")
+	assert.NoError(t, err)
+	assert.Contains(t, dump.String(), "Message 2 from 127.0.0.1:30000:\nMessage header (offset 0)\n")
+	assert.Contains(t, dump.String(), "sourceIPv4Address: elementID=8 length=4\n")
+	// Only the first messages of every transport session are dumped.
+	dumpLen := dump.Len()
+	_, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.1:30000")
+	assert.NoError(t, err)
+	assert.Equal(t, dumpLen, dump.Len())
+	_, err = cp.decodePacket(bytes.NewBuffer(validTemplatePacket), "127.0.0.1:30001")
+	assert.NoError(t, err)
+	assert.Contains(t, dump.String(), "Message 1 from 127.0.0.1:30001:\nMessage header (offset 0)\n")
+}
+
+// createPacket returns an IPFIX message with a single set of the given ID,
+// whose content is written in network byte order.
+func createPacket(setID uint16, content ...interface{}) []byte {
+	return createPacketWithHeader(1612345678, 1, 1, setID, content...)
+}
+
+// createPacketWithHeader returns an IPFIX message with the given header fields
+// and a single set of the given ID.
+func createPacketWithHeader(exportTime, sequenceNum, obsDomainID uint32, setID uint16, content ...interface{}) []byte {
+	set := new(bytes.Buffer)
+	for _, field := range content {
+		binary.Write(set, binary.BigEndian, field)
+	}
+	packet := new(bytes.Buffer)
+	binary.Write(packet, binary.BigEndian, []uint16{10, uint16(set.Len() + 20)})
+	binary.Write(packet, binary.BigEndian, []uint32{exportTime, sequenceNum, obsDomainID})
+	binary.Write(packet, binary.BigEndian, []uint16{setID, uint16(set.Len() + 4)})
+	return append(packet.Bytes(), set.Bytes()...)
+}
+
+func TestCollectingProcess_DecodeVariableLengthElements(t *testing.T) {
+	input := getCollectorInput(udpTransport, false, false)
+	input.MessageChanSize = 10
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	address := "127.0.0.1:4739"
+	// interfaceName has a fixed length of 8 bytes instead of a variable
+	// length, and interfaceDescription and sourceIPv4Address have the lengths
+	// of the registry.
+	_, err = cp.decodePacket(bytes.NewBuffer(createPacket(2, []uint16{256, 3, 82, 8, 83, 65535, 8, 4})), address)
+	assert.NoError(t, err)
+	<-cp.GetMsgChan()
+	template, err := cp.getTemplate(address, 1, 256)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(8), template[0].Len)
+	assert.Equal(t, entities.VariableLength, template[1].Len)
+
+	long := strings.Repeat("a", 300)
+	_, err = cp.decodePacket(bytes.NewBuffer(createPacket(256,
+		[]byte("eth0\x00\x00\x00\x00"), []byte{4}, []byte("wan0"), []byte{10, 0, 0, 1},
+		[]byte("eth1\x00\x00\x00\x00"), []byte{255, 1, 44}, []byte(long), []byte{10, 0, 0, 2})), address)
+	assert.NoError(t, err)
+	records := (<-cp.GetMsgChan()).GetSet().GetRecords()
+	assert.Equal(t, 2, len(records))
+	ie, _ := records[0].GetInfoElementWithValue("interfaceDescription")
+	assert.Equal(t, "wan0", ie.Value)
+	ie, _ = records[1].GetInfoElementWithValue("interfaceDescription")
+	assert.Equal(t, long, ie.Value)
+	ie, _ = records[1].GetInfoElementWithValue("sourceIPv4Address")
+	assert.Equal(t, net.IP{10, 0, 0, 2}, ie.Value)
+
+	// Values longer than the set cannot be decoded.
+	_, err = cp.decodePacket(bytes.NewBuffer(createPacket(256, []byte("eth0\x00\x00\x00\x00"), []byte{10}, []byte("wan0"))), address)
+	assert.Error(t, err)
+	// Fixed-length data types cannot have variable lengths.
+	_, err = cp.decodePacket(bytes.NewBuffer(createPacket(2, []uint16{257, 1, 8, 65535})), address)
+	assert.Error(t, err)
+}
+
+func TestCollectingProcess_DecodeListElements(t *testing.T) {
+	input := getCollectorInput(udpTransport, false, false)
+	input.MessageChanSize = 10
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	address := "127.0.0.1:4739"
+	// Template 256 is used in the subTemplateList of template 257, which also
+	// has a basicList.
+	for _, template := range [][]uint16{
+		{256, 2, 8, 4, 11, 2},
+		{257, 3, 8, 4, 291, 65535, 292, 65535},
+	} {
+		_, err = cp.decodePacket(bytes.NewBuffer(createPacket(2, template)), address)
+		assert.NoError(t, err)
+		<-cp.GetMsgChan()
+	}
+
+	_, err = cp.decodePacket(bytes.NewBuffer(createPacket(257,
+		[]byte{10, 0, 0, 1},
+		[]byte{9, uint8(entities.AllOf)}, []uint16{11, 2, 80, 443},
+		[]byte{9, uint8(entities.Ordered)}, uint16(256), []byte{10, 0, 0, 2}, uint16(53),
+		// The subTemplateList of the second record refers to an unknown
+		// template.
+		[]byte{10, 0, 0, 3},
+		[]byte{5, uint8(entities.AllOf)}, []uint16{11, 2},
+		[]byte{5, uint8(entities.Ordered)}, uint16(300), []byte{1, 2})), address)
+	assert.NoError(t, err)
+	records := (<-cp.GetMsgChan()).GetSet().GetRecords()
+	assert.Equal(t, 2, len(records))
+	ie, _ := records[0].GetInfoElementWithValue("basicList")
+	basicList, ok := ie.Value.(*entities.BasicListValue)
+	if assert.True(t, ok) {
+		assert.Equal(t, entities.AllOf, basicList.Semantic)
+		assert.Equal(t, "destinationTransportPort", basicList.Element.Name)
+		assert.Equal(t, []interface{}{uint16(80), uint16(443)}, basicList.Values)
+	}
+	ie, _ = records[0].GetInfoElementWithValue("subTemplateList")
+	subTemplateList, ok := ie.Value.(*entities.SubTemplateListValue)
+	if assert.True(t, ok) {
+		assert.Equal(t, uint16(256), subTemplateList.TemplateID)
+		assert.Equal(t, 1, len(subTemplateList.Records))
+		assert.Equal(t, net.IP{10, 0, 0, 2}, subTemplateList.Records[0][0].Value)
+		assert.Equal(t, uint16(53), subTemplateList.Records[0][1].Value)
+	}
+	ie, _ = records[1].GetInfoElementWithValue("basicList")
+	basicList, ok = ie.Value.(*entities.BasicListValue)
+	if assert.True(t, ok) {
+		assert.Empty(t, basicList.Values)
+	}
+	ie, _ = records[1].GetInfoElementWithValue("subTemplateList")
+	assert.Equal(t, []byte{uint8(entities.Ordered), 1, 44, 1, 2}, ie.Value)
+}
+
+func TestCollectingProcess_ZeroCopyDecoding(t *testing.T) {
+	input := getCollectorInput(udpTransport, false, false)
+	input.MessageChanSize = 10
+	input.ZeroCopyDecoding = true
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	address := "127.0.0.1:4739"
+	_, err = cp.decodePacket(bytes.NewBuffer(createPacket(2, []uint16{256, 3, 8, 4, 83, 65535, 2, 8})), address)
+	assert.NoError(t, err)
+	<-cp.GetMsgChan()
+
+	packet := createPacket(256, []byte{10, 0, 0, 1}, []byte{4}, []byte("wan0"), uint64(42))
+	_, err = cp.decodePacket(bytes.NewBuffer(packet), address)
+	assert.NoError(t, err)
+	message := <-cp.GetMsgChan()
+	record := message.GetSet().GetRecords()[0]
+	// The values are views of the packet.
+	ie, _ := record.GetInfoElementWithValue("sourceIPv4Address")
+	assert.Equal(t, entities.ValueView{10, 0, 0, 1}, ie.Value)
+	ie, _ = record.GetInfoElementWithValue("interfaceDescription")
+	assert.Equal(t, entities.ValueView("wan0"), ie.Value)
+	packet[20] = 192
+	ie, _ = record.GetInfoElementWithValue("sourceIPv4Address")
+	assert.Equal(t, entities.ValueView{192, 0, 0, 1}, ie.Value)
+
+	// Materialized values are decoded and no longer share the packet.
+	message.Materialize()
+	packet[20] = 10
+	ie, _ = record.GetInfoElementWithValue("sourceIPv4Address")
+	assert.Equal(t, net.IP{192, 0, 0, 1}, ie.Value)
+	ie, _ = record.GetInfoElementWithValue("interfaceDescription")
+	assert.Equal(t, "wan0", ie.Value)
+	ie, _ = record.GetInfoElementWithValue("packetDeltaCount")
+	assert.Equal(t, uint64(42), ie.Value)
+}
+
+func TestCollectingProcess_DecodeNetFlowV9(t *testing.T) {
+	input := getCollectorInput(udpTransport, false, false)
+	input.MessageChanSize = 10
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	address := "127.0.0.1:2055"
+
+	flowSet := func(id uint16, content ...interface{}) []byte {
+		buf := new(bytes.Buffer)
+		for _, field := range content {
+			binary.Write(buf, binary.BigEndian, field)
+		}
+		header := new(bytes.Buffer)
+		binary.Write(header, binary.BigEndian, []uint16{id, uint16(buf.Len() + 4)})
+		return append(header.Bytes(), buf.Bytes()...)
+	}
+	packet := new(bytes.Buffer)
+	// version, count, sysUptime, unixSecs, sequence number and source ID
+	binary.Write(packet, binary.BigEndian, []uint16{9, 6})
+	binary.Write(packet, binary.BigEndian, []uint32{1000, 1612345678, 42, 7})
+	// Template 256 has 4 bytes octet and packet counters, which are 8 bytes
+	// in the registry, and field type 200 (mplsTopLabelTTL) with 2 bytes
+	// instead of 1.
+	packet.Write(flowSet(0, []uint16{256, 6, 8, 4, 12, 4, 1, 4, 2, 4, 7, 2, 200, 2}))
+	// Data flowsets of unknown templates are skipped.
+	packet.Write(flowSet(300, []byte{1, 2, 3, 4}))
+	// 2 records of 20 bytes with 2 bytes of padding
+	packet.Write(flowSet(256,
+		[]byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}, uint32(1500), uint32(3), uint16(443), []byte{0, 1},
+		[]byte{10, 0, 0, 3}, []byte{10, 0, 0, 4}, uint32(0xffffffff), uint32(1), uint16(80), []byte{0, 2},
+		uint16(0)))
+	// Options template 257 is scoped by the system, with samplingInterval.
+	packet.Write(flowSet(1, []uint16{257, 4, 4, 1, 4, 34, 4}))
+	packet.Write(flowSet(256+1, []byte{192, 168, 0, 1}, uint32(100)))
+	// Flowsets with reserved IDs are skipped.
+	packet.Write(flowSet(2, []byte{0, 0, 0, 0}))
+
+	message, err := cp.decodePacket(bytes.NewBuffer(packet.Bytes()), address)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(cp.GetMsgChan()))
+	assert.Equal(t, uint64(1), cp.GetStats().MissingTemplateDataSets)
+
+	message = <-cp.GetMsgChan()
+	assert.Equal(t, uint16(9), message.GetVersion())
+	assert.Equal(t, uint32(1612345678), message.GetExportTime())
+	assert.Equal(t, uint32(42), message.GetSequenceNum())
+	assert.Equal(t, uint32(7), message.GetObsDomainID())
+	assert.Equal(t, "127.0.0.1", message.GetExportAddress())
+	assert.Equal(t, entities.Template, message.GetSet().GetSetType())
+	template, err := cp.getTemplate(address, 7, 256)
+	assert.NoError(t, err)
+	assert.Equal(t, "octetDeltaCount", template[2].Name)
+	assert.Equal(t, uint16(4), template[2].Len)
+	assert.Equal(t, "netflowV9Field200", template[5].Name)
+
+	message = <-cp.GetMsgChan()
+	records := message.GetSet().GetRecords()
+	assert.Equal(t, 2, len(records))
+	ie, _ := records[0].GetInfoElementWithValue("sourceIPv4Address")
+	assert.Equal(t, net.IP{10, 0, 0, 1}, ie.Value)
+	ie, _ = records[0].GetInfoElementWithValue("octetDeltaCount")
+	assert.Equal(t, uint64(1500), ie.Value)
+	assert.Equal(t, uint16(8), ie.Element.Len)
+	ie, _ = records[1].GetInfoElementWithValue("octetDeltaCount")
+	assert.Equal(t, uint64(0xffffffff), ie.Value)
+	ie, _ = records[1].GetInfoElementWithValue("sourceTransportPort")
+	assert.Equal(t, uint16(80), ie.Value)
+	ie, _ = records[1].GetInfoElementWithValue("netflowV9Field200")
+	assert.Equal(t, []byte{0, 2}, ie.Value)
+
+	message = <-cp.GetMsgChan()
+	assert.Equal(t, entities.OptionsTemplate, message.GetSet().GetSetType())
+	message = <-cp.GetMsgChan()
+	records = message.GetSet().GetRecords()
+	assert.Equal(t, 1, len(records))
+	scope := records[0].GetScopeElements()
+	assert.Equal(t, 1, len(scope))
+	assert.Equal(t, "netflowV9ScopeSystem", scope[0].Element.Name)
+	ie, _ = records[0].GetInfoElementWithValue("samplingInterval")
+	assert.Equal(t, uint32(100), ie.Value)
+
+	// Packets with only unknown templates have no message.
+	packet.Truncate(netflowV9HeaderLength)
+	packet.Write(flowSet(300, []byte{1, 2, 3, 4}))
+	message, err = cp.decodePacket(bytes.NewBuffer(packet.Bytes()), address)
+	assert.NoError(t, err)
+	assert.Nil(t, message)
+	// Flowsets longer than the packet cannot be decoded.
+	packet.Truncate(packet.Len() - 1)
+	_, err = cp.decodePacket(bytes.NewBuffer(packet.Bytes()), address)
+	assert.Error(t, err)
+}
+
+func TestCollectingProcess_MalformedCapture(t *testing.T) {
+	_, err := InitCollectingProcess(CollectorInput{MalformedCaptureSize: -1})
+	assert.Error(t, err)
+	capture := new(bytes.Buffer)
+	input := getCollectorInput(udpTransport, false, false)
+	input.MalformedCaptureSize = 2
+	input.MalformedCaptureWriter = capture
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	assert.Empty(t, cp.GetMalformedMessages())
+	// Messages with versions 6, 7 and 8 cannot be decoded.
+	packets := make([][]byte, 0)
+	for version := byte(6); version <= 8; version++ {
+		packet := append([]byte(nil), validDataPacket...)
+		packet[1] = version
+		packets = append(packets, packet)
+		_, err := cp.decodePacket(bytes.NewBuffer(packet), "127.0.0.1:4739")
+		assert.Error(t, err)
+	}
+	// The ring buffer keeps the 2 most recent messages.
+	messages := cp.GetMalformedMessages()
+	assert.Equal(t, 2, len(messages))
+	for i, message := range messages {
+		assert.Equal(t, "127.0.0.1:4739", message.ExporterAddress)
+		assert.Equal(t, packets[i+1], message.Data)
+		assert.Contains(t, message.Error, "invalid version")
+	}
+	// All messages are written to the capture.
+	for i := range packets {
+		message, err := ReadMalformedMessage(capture)
+		assert.NoError(t, err)
+		assert.Equal(t, packets[i], message.Data)
+		assert.Equal(t, "127.0.0.1:4739", message.ExporterAddress)
+		assert.False(t, message.Time.IsZero())
+	}
+	_, err = ReadMalformedMessage(capture)
+	assert.Equal(t, io.EOF, err)
+	_, err = ReadMalformedMessage(bytes.NewReader([]byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 5}))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestCollectingProcess_ProjectedElements(t *testing.T) {
+	input := CollectorInput{
+		Address:           hostPortIPv4,
+		Protocol:          tcpTransport,
+		MessageChanSize:   10,
+		ProjectedElements: []string{"sourcePodName", "sourceIPv4Address", "customField"},
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	_, err = cp.decodePacket(bytes.NewBuffer(validTemplatePacket), "127.0.0.1:30000")
+	assert.NoError(t, err)
+	// Template records are not projected.
+	message := <-cp.GetMsgChan()
+	assert.Equal(t, 3, len(message.GetSet().GetRecords()[0].GetOrderedElementList()))
+	_, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), "127.0.0.1:30000")
+	assert.NoError(t, err)
+	message = <-cp.GetMsgChan()
+	record := message.GetSet().GetRecords()[0]
+	elements := record.GetOrderedElementList()
+	assert.Equal(t, 2, len(elements))
+	assert.Equal(t, "sourceIPv4Address", elements[0].Element.Name)
+	assert.Equal(t, net.IP([]byte{1, 2, 3, 4}), elements[0].Value)
+	assert.Equal(t, "sourcePodName", elements[1].Element.Name)
+	assert.Equal(t, "pod1", elements[1].Value)
+	_, exist := record.GetInfoElementWithValue("destinationIPv4Address")
+	assert.False(t, exist)
+
+	// Type records are decoded in full, so that the elements they describe
+	// can be projected.
+	address := "127.0.0.1:4739"
+	optionsTemplatePacket := []byte{0, 10, 0, 46, 96, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 3, 0, 30, 1, 1, 0, 5, 0, 2, 1, 47, 0, 2, 1, 90, 0, 4, 1, 83, 0, 1, 1, 88, 0, 1, 1, 85, 255, 255}
+	typeRecordPacket := []byte{0, 10, 0, 40, 96, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 1, 1, 0, 24, 0, 1, 0, 1, 134, 159, 3, 0, 11, 99, 117, 115, 116, 111, 109, 70, 105, 101, 108, 100}
+	templatePacket := []byte{0, 10, 0, 32, 96, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 0, 2, 0, 16, 1, 2, 0, 1, 128, 1, 0, 4, 0, 1, 134, 159}
+	dataPacket := []byte{0, 10, 0, 24, 96, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 1, 1, 2, 0, 8, 0, 0, 0, 42}
+	for _, packet := range [][]byte{optionsTemplatePacket, typeRecordPacket, templatePacket, dataPacket} {
+		_, err = cp.decodePacket(bytes.NewBuffer(packet), address)
+		assert.NoError(t, err)
+		message = <-cp.GetMsgChan()
+	}
+	elements = message.GetSet().GetRecords()[0].GetOrderedElementList()
+	assert.Equal(t, 1, len(elements))
+	assert.Equal(t, "customField", elements[0].Element.Name)
+	assert.Equal(t, uint32(42), elements[0].Value)
+}
+
+func TestCollectingProcess_TemplateChangeCallback(t *testing.T) {
+	changes := make([]TemplateChange, 0)
+	input := CollectorInput{
+		Address:         hostPortIPv4,
+		Protocol:        tcpTransport,
+		MessageChanSize: 10,
+		TemplateChangeCallback: func(change TemplateChange) {
+			changes = append(changes, change)
+		},
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	cp.templateIdleTimeout = time.Minute
+	address := "127.0.0.1:30000"
+	replacingTemplatePacket := []byte{0, 10, 0, 32, 95, 154, 107, 127, 0, 0, 0, 0, 0, 0, 0, 1, 0, 2, 0, 16, 1, 0, 0, 2, 0, 8, 0, 4, 0, 12, 0, 4}
+	withdrawalPacket := []byte{0, 10, 0, 24, 95, 154, 107, 127, 0, 0, 0, 0, 0, 0, 0, 1, 0, 2, 0, 8, 1, 0, 0, 0}
+	withdrawAllPacket := []byte{0, 10, 0, 24, 95, 154, 107, 127, 0, 0, 0, 0, 0, 0, 0, 1, 0, 2, 0, 8, 0, 2, 0, 0}
+	for _, packet := range [][]byte{validTemplatePacket, validTemplatePacket, replacingTemplatePacket, withdrawalPacket, validTemplatePacket, withdrawAllPacket, validTemplatePacket} {
+		_, err = cp.decodePacket(bytes.NewBuffer(packet), address)
+		assert.NoError(t, err)
+		<-cp.GetMsgChan()
+	}
+	_, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), address)
+	assert.NoError(t, err)
+	<-cp.GetMsgChan()
+	assert.Equal(t, 1, cp.pruneTemplates(time.Now().Add(2*time.Minute)))
+
+	expectedTypes := []TemplateChangeType{TemplateAdded, TemplateReplaced, TemplateWithdrawn, TemplateAdded, TemplateWithdrawn, TemplateAdded, TemplateExpired}
+	if assert.Equal(t, len(expectedTypes), len(changes)) {
+		for i, change := range changes {
+			assert.Equal(t, expectedTypes[i], change.Type, "change %d", i)
+			assert.Equal(t, uint32(1), change.ObsDomainID)
+			assert.Equal(t, uint16(256), change.TemplateID)
+		}
+	}
+	assert.Equal(t, address, changes[0].ExporterAddress)
+	assert.Equal(t, 3, len(changes[0].Elements))
+	assert.Equal(t, "sourcePodName", changes[0].Elements[2].Name)
+	assert.Equal(t, 2, len(changes[1].Elements))
+	assert.Nil(t, changes[2].Elements)
+	assert.Equal(t, address, changes[6].ExporterAddress)
+	_, err = cp.getTemplate(address, 1, 256)
+	assert.Error(t, err)
+}
+
+func TestCollectingProcess_DecodePaddedSets(t *testing.T) {
+	input := CollectorInput{
+		Address:         hostPortIPv4,
+		Protocol:        tcpTransport,
+		MessageChanSize: 10,
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	address := "127.0.0.1:30000"
+	// Sets padded with zeros to a multiple of 4 bytes, and with non-zero
+	// padding, which is shorter than any record of the template.
+	templatePacket := append(append([]byte{}, validTemplatePacket...), 0, 0)
+	templatePacket[3], templatePacket[19] = 42, 26
+	dataPacket := append(append([]byte{}, validDataPacket...), 0, 0, 0)
+	dataPacket[3], dataPacket[19] = 36, 20
+	dataPacketWithNonZeroPadding := append(append([]byte{}, validDataPacket...), 1, 2, 3, 4, 5, 6, 7, 8)
+	dataPacketWithNonZeroPadding[3], dataPacketWithNonZeroPadding[19] = 41, 25
+
+	_, err = cp.decodePacket(bytes.NewBuffer(templatePacket), address)
+	assert.NoError(t, err)
+	message := <-cp.GetMsgChan()
+	assert.Equal(t, uint32(1), message.GetSet().GetNumberOfRecords())
+	assert.Equal(t, 3, len(message.GetSet().GetRecords()[0].GetOrderedElementList()))
+	for _, packet := range [][]byte{dataPacket, dataPacketWithNonZeroPadding} {
+		_, err = cp.decodePacket(bytes.NewBuffer(packet), address)
+		assert.NoError(t, err)
+		message = <-cp.GetMsgChan()
+		assert.Equal(t, uint32(1), message.GetSet().GetNumberOfRecords())
+		sourcePodName, exist := message.GetSet().GetRecords()[0].GetInfoElementWithValue("sourcePodName")
+		assert.True(t, exist)
+		assert.Equal(t, "pod1", sourcePodName.Value)
+	}
+
+	// Records of template 257, with only sourcePodName, can be as short as the
+	// padding, which must not be decoded as records with empty values.
+	templatePacket = []byte{0, 10, 0, 32, 95, 154, 107, 127, 0, 0, 0, 0, 0, 0, 0, 1, 0, 2, 0, 16, 1, 1, 0, 1, 128, 101, 255, 255, 0, 0, 220, 186}
+	dataPacket = []byte{0, 10, 0, 28, 95, 154, 108, 18, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 0, 12, 4, 112, 111, 100, 50, 0, 0, 0}
+	_, err = cp.decodePacket(bytes.NewBuffer(templatePacket), address)
+	assert.NoError(t, err)
+	<-cp.GetMsgChan()
+	_, err = cp.decodePacket(bytes.NewBuffer(dataPacket), address)
+	assert.NoError(t, err)
+	message = <-cp.GetMsgChan()
+	if assert.Equal(t, uint32(1), message.GetSet().GetNumberOfRecords()) {
+		sourcePodName, _ := message.GetSet().GetRecords()[0].GetInfoElementWithValue("sourcePodName")
+		assert.Equal(t, "pod2", sourcePodName.Value)
+	}
+}
+
+func TestCollectingProcess_TruncatedMessages(t *testing.T) {
+	input := CollectorInput{
+		Address:         hostPortIPv4,
+		Protocol:        tcpTransport,
+		MessageChanSize: 10,
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	address := "127.0.0.1:30000"
+	// A message declaring two records, truncated in the second record.
+	record := validDataPacket[20:]
+	truncatedPacket := append(append(append([]byte{}, validDataPacket[:20]...), record...), record[:5]...)
+	truncatedPacket[3], truncatedPacket[19] = 46, 30
+	// Garbage before the first message is discarded.
+	var stream []byte
+	stream = append(stream, 1, 2, 3)
+	stream = append(stream, validTemplatePacket...)
+	stream = append(stream, truncatedPacket...)
+	stream = append(stream, validDataPacket...)
+
+	// The stream is received in small chunks, which split messages.
+	pending := &tcpStream{}
+	for start := 0; start < len(stream); start += 7 {
+		end := start + 7
+		if end > len(stream) {
+			end = len(stream)
+		}
+		pending.data = append(pending.data, stream[start:end]...)
+		assert.NoError(t, cp.processStream(pending, address))
+	}
+	assert.Empty(t, pending.data)
+	assert.Equal(t, 3, len(cp.GetMsgChan()))
+	message := <-cp.GetMsgChan()
+	assert.Equal(t, entities.Template, message.GetSet().GetSetType())
+	for i := 0; i < 2; i++ {
+		message = <-cp.GetMsgChan()
+		assert.Equal(t, uint32(1), message.GetSet().GetNumberOfRecords())
+		sourcePodName, _ := message.GetSet().GetRecords()[0].GetInfoElementWithValue("sourcePodName")
+		assert.Equal(t, "pod1", sourcePodName.Value)
+	}
+	stats := cp.GetStats()
+	assert.Equal(t, uint64(1), stats.TruncatedMessages)
+	assert.Equal(t, uint64(3), stats.DiscardedBytes)
+
+	// The end of a stream is a truncated message too.
+	cp.decodeTruncatedMessage(truncatedPacket, address)
+	message = <-cp.GetMsgChan()
+	assert.Equal(t, uint32(1), message.GetSet().GetNumberOfRecords())
+	assert.Equal(t, uint64(2), cp.GetStats().TruncatedMessages)
+}
+
+func TestCollectingProcess_StreamResumesHeaderSearch(t *testing.T) {
+	input := CollectorInput{
+		Address:         hostPortIPv4,
+		Protocol:        tcpTransport,
+		MessageChanSize: 10,
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	// A message declaring 1000 bytes, received in small chunks.
+	message := make([]byte, 1000)
+	copy(message, validDataPacket[:20])
+	binary.BigEndian.PutUint16(message[2:4], 1000)
+	binary.BigEndian.PutUint16(message[18:20], uint16(1000-entities.MsgHeaderLength))
+	pending := &tcpStream{}
+	for start := 0; start < len(message)-10; start += 10 {
+		pending.data = append(pending.data, message[start:start+10]...)
+		assert.NoError(t, cp.processStream(pending, "127.0.0.1:30000"))
+		if len(pending.data) >= 2*entities.MsgHeaderLength {
+			// The search for the next header resumes after the offsets
+			// already searched.
+			assert.Equal(t, len(pending.data)-entities.MsgHeaderLength+1, pending.scanned)
+		}
+	}
+	assert.Equal(t, 0, len(cp.GetMsgChan()))
+}
+
+func TestCollectingProcess_Tap(t *testing.T) {
+	input := CollectorInput{
+		Address:         hostPortIPv4,
+		Protocol:        tcpTransport,
+		MessageChanSize: 10,
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	tap := cp.AddTap(10)
+	full := cp.AddTap(0)
+	// A message with an unknown template is received by the tap, although
+	// it cannot be decoded.
+	unknownTemplatePacket := append([]byte{}, validDataPacket...)
+	unknownTemplatePacket[16] = 2
+	truncatedPacket := append([]byte{}, validDataPacket[:30]...)
+	stream := &tcpStream{}
+	stream.data = append(append(stream.data, validTemplatePacket...), unknownTemplatePacket...)
+	assert.Error(t, cp.processStream(stream, "127.0.0.1:30000"))
+	cp.decodeTruncatedMessage(truncatedPacket, "127.0.0.1:30000")
+
+	assert.Equal(t, 3, len(tap.GetMsgChan()))
+	message := <-tap.GetMsgChan()
+	assert.Equal(t, validTemplatePacket, message.Data)
+	assert.Equal(t, "127.0.0.1:30000", message.ExporterAddress)
+	assert.Equal(t, tcpTransport, message.Transport)
+	assert.False(t, message.Truncated)
+	message = <-tap.GetMsgChan()
+	assert.Equal(t, unknownTemplatePacket, message.Data)
+	message = <-tap.GetMsgChan()
+	assert.Equal(t, truncatedPacket, message.Data)
+	assert.True(t, message.Truncated)
+	// A full tap channel does not block the collector.
+	assert.Equal(t, uint64(3), full.GetDroppedMessages())
+
+	cp.RemoveTap(tap)
+	_, ok := <-tap.GetMsgChan()
+	assert.False(t, ok)
+}
+
+func TestCollectingProcess_SCTPStreams(t *testing.T) {
+	input := CollectorInput{
+		Address:         hostPortIPv4,
+		Protocol:        "sctp",
+		MessageChanSize: 10,
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	address := "127.0.0.1:30000"
+	association := &sctpAssociation{}
+	// The data set is received before its template, which is sent on
+	// another stream, and the template set is received in two reads.
+	association.data = append(association.data, validDataPacket...)
+	association.data = append(association.data, validTemplatePacket[:10]...)
+	assert.NoError(t, cp.processSCTPData(association, address))
+	assert.Equal(t, 0, len(cp.GetMsgChan()))
+	assert.Equal(t, 1, len(association.pendingMessages))
+	association.data = append(association.data, validTemplatePacket[10:]...)
+	assert.NoError(t, cp.processSCTPData(association, address))
+	assert.Empty(t, association.data)
+	assert.Empty(t, association.pendingMessages)
+	assert.Equal(t, 2, len(cp.GetMsgChan()))
+	message := <-cp.GetMsgChan()
+	assert.Equal(t, entities.Template, message.GetSet().GetSetType())
+	message = <-cp.GetMsgChan()
+	assert.Equal(t, entities.Data, message.GetSet().GetSetType())
+
+	// The number of messages waiting for their template is bounded.
+	unknownTemplatePacket := append([]byte{}, validDataPacket...)
+	unknownTemplatePacket[16] = 2
+	for i := 0; i < maxSCTPPendingMessages+1; i++ {
+		assert.NoError(t, cp.processSCTPMessage(association, unknownTemplatePacket, address))
+	}
+	assert.Equal(t, maxSCTPPendingMessages, len(association.pendingMessages))
+}
+
+func TestCollectingProcess_LatencyProbes(t *testing.T) {
+	input := getCollectorInput(tcpTransport, false, false)
+	input.MeasureLatencyProbes = true
+	input.MessageChanSize = 2
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	templatePacket := []byte{0, 10, 0, 40, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 2, 0, 24, 1, 0, 0, 2, 128, 152, 0, 8, 0, 0, 220, 186, 128, 153, 0, 8, 0, 0, 220, 186}
+	dataPacket := make([]byte, 36)
+	copy(dataPacket, []byte{0, 10, 0, 36, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 1, 0, 0, 20})
+	binary.BigEndian.PutUint64(dataPacket[20:28], 1)
+	binary.BigEndian.PutUint64(dataPacket[28:36], uint64(time.Now().Add(-time.Second).UnixNano()))
+	address := "127.0.0.1:30000"
+	_, err = cp.decodePacket(bytes.NewBuffer(templatePacket), address)
+	assert.NoError(t, err)
+	_, err = cp.decodePacket(bytes.NewBuffer(dataPacket), address)
+	assert.NoError(t, err)
+	// Probes are still sent to the message channel.
+	assert.Equal(t, 2, len(cp.GetMsgChan()))
+	stats := cp.GetStats().LatencyProbes[entities.LatencyProbeSource{ExporterAddress: "127.0.0.1", ObsDomainID: 1}]
+	assert.Equal(t, uint64(1), stats.Received)
+	assert.Equal(t, uint64(1), stats.LastSequence)
+	assert.True(t, stats.LastLatency >= time.Second)
+}
+
+func TestTCPCollectingProcess_Compression(t *testing.T) {
+	for _, allowCompression := range []bool{true, false} {
+		input := getCollectorInput(tcpTransport, false, false)
+		input.AllowCompression = allowCompression
+		cp, err := InitCollectingProcess(input)
+		if err != nil {
+			t.Fatalf("TCP Collecting Process does not start correctly: %v", err)
+		}
+		go cp.Start()
+		// wait until collector is ready
+		waitForCollectorReady(t, cp)
+		collectorAddr := cp.GetAddress()
+
+		conn, err := net.Dial(collectorAddr.Network(), collectorAddr.String())
+		assert.NoError(t, err)
+		_, err = conn.Write(util.CompressionHello)
+		assert.NoError(t, err)
+		reply := make([]byte, 1)
+		_, err = conn.Read(reply)
+		assert.NoError(t, err)
+		if allowCompression {
+			assert.Equal(t, util.CompressionAccepted, reply[0])
+			conn, err = util.NewCompressedConn(conn, nil)
+			assert.NoError(t, err)
+		} else {
+			assert.Equal(t, util.CompressionRejected, reply[0])
+		}
+		_, err = conn.Write(validTemplatePacket)
+		assert.NoError(t, err)
+		message := <-cp.GetMsgChan()
+		assert.Equal(t, entities.Template, message.GetSet().GetSetType())
+		_, err = conn.Write(validDataPacket)
+		assert.NoError(t, err)
+		message = <-cp.GetMsgChan()
+		sourcePodName, _ := message.GetSet().GetRecords()[0].GetInfoElementWithValue("sourcePodName")
+		assert.Equal(t, "pod1", sourcePodName.Value)
+		conn.Close()
+		cp.Stop()
+	}
+}
+
+func TestCollectingProcess_UnixSockets(t *testing.T) {
+	for _, protocol := range []string{"unix", "unixgram"} {
+		t.Run(protocol, func(t *testing.T) {
+			dir := t.TempDir()
+			address := filepath.Join(dir, "ipfix.sock")
+			input := CollectorInput{
+				Address:       address,
+				Protocol:      protocol,
+				MaxBufferSize: 1024,
+			}
+			cp, err := InitCollectingProcess(input)
+			if err != nil {
+				t.Fatalf("Collecting Process does not start correctly: %v", err)
+			}
+			go cp.Start()
+			waitForCollectorReady(t, cp)
+			var conn net.Conn
+			if protocol == "unix" {
+				conn, err = net.Dial(protocol, address)
+			} else {
+				// Datagrams are sent from a named socket, so that the exporter
+				// has its own transport session.
+				laddr := &net.UnixAddr{Name: filepath.Join(dir, "exporter.sock"), Net: protocol}
+				conn, err = net.DialUnix(protocol, laddr, &net.UnixAddr{Name: address, Net: protocol})
+			}
+			if err != nil {
+				t.Fatalf("Cannot establish connection to %s: %v", address, err)
+			}
+			defer conn.Close()
+			conn.Write(validTemplatePacket)
+			conn.Write(validDataPacket)
+			<-cp.GetMsgChan()
+			message := <-cp.GetMsgChan()
+			assert.Equal(t, address, message.GetExportAddress())
+			assert.Equal(t, entities.Data, message.GetSet().GetSetType())
+			cp.Stop()
+			// The socket is removed when the collecting process stops.
+			err = wait.Poll(10*time.Millisecond, time.Second, func() (bool, error) {
+				_, err := os.Stat(address)
+				return os.IsNotExist(err), nil
+			})
+			assert.NoError(t, err)
+		})
+	}
+
+	input := CollectorInput{Address: filepath.Join(t.TempDir(), "ipfix.sock"), Protocol: "unix", IsEncrypted: true}
+	_, err := InitCollectingProcess(input)
+	assert.Error(t, err)
+	input = CollectorInput{Address: filepath.Join(t.TempDir(), "ipfix.sock"), Protocol: "unixgram", AllowedExporters: []string{"10.0.0.0/8"}}
+	_, err = InitCollectingProcess(input)
+	assert.Error(t, err)
+}
+
+func TestCollectingProcess_RecordCallback(t *testing.T) {
+	var sources []net.Addr
+	var records []entities.Record
+	input := CollectorInput{
+		Address:         hostPortIPv4,
+		Protocol:        tcpTransport,
+		MessageChanSize: 10,
+		RecordCallback: func(source net.Addr, record entities.Record) error {
+			sources = append(sources, source)
+			records = append(records, record)
+			if len(records) > 1 {
+				return fmt.Errorf("cannot process record")
+			}
+			return nil
+		},
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	address := "127.0.0.1:30000"
+	message, err := cp.decodePacket(bytes.NewBuffer(validTemplatePacket), address)
+	assert.NoError(t, err)
+	assert.Nil(t, message)
+	assert.Empty(t, records, "Template records should not be passed to the callback")
+	assert.NotNil(t, getTemplateFromAnySession(cp, 1, 256))
+	message, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), address)
+	assert.NoError(t, err)
+	assert.Nil(t, message)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "tcp", sources[0].Network())
+		assert.Equal(t, address, sources[0].String())
+		ie, exist := records[0].GetInfoElementWithValue("sourceIPv4Address")
+		assert.True(t, exist)
+		assert.Equal(t, net.IP{1, 2, 3, 4}, ie.Value)
+	}
+	assert.Equal(t, uint64(0), cp.GetStats().RecordCallbackErrors)
+	_, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), address)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, uint64(1), cp.GetStats().RecordCallbackErrors)
+	assert.Empty(t, cp.GetMsgChan(), "Messages should not be sent to the message channel")
+
+	input.MeasureLatencyProbes = true
+	_, err = InitCollectingProcess(input)
+	assert.Error(t, err)
+}
+
+func TestCollectingProcess_RecordFilter(t *testing.T) {
+	// Template 256 with protocolIdentifier and destinationTransportPort.
+	templatePacket := createPacket(2, []uint16{256, 2, 4, 1, 11, 2})
+	recordFilter, err := filter.ParseExpression("protocolIdentifier == 6 && destinationTransportPort == 443")
+	assert.NoError(t, err)
+	input := CollectorInput{
+		Address:         hostPortIPv4,
+		Protocol:        tcpTransport,
+		MessageChanSize: 10,
+		RecordFilter:    recordFilter,
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	address := "127.0.0.1:30000"
+	_, err = cp.decodePacket(bytes.NewBuffer(templatePacket), address)
+	assert.NoError(t, err)
+	<-cp.GetMsgChan()
+	message, err := cp.decodePacket(bytes.NewBuffer(createPacket(256, uint8(6), uint16(443), uint8(17), uint16(53), uint8(6), uint16(80))), address)
+	assert.NoError(t, err)
+	assert.Equal(t, message, <-cp.GetMsgChan())
+	if assert.Len(t, message.GetSet().GetRecords(), 1) {
+		ie, _ := message.GetSet().GetRecords()[0].GetInfoElementWithValue("destinationTransportPort")
+		assert.Equal(t, uint16(443), ie.Value)
+	}
+	assert.Equal(t, uint64(2), cp.GetStats().FilteredRecords)
+	message, err = cp.decodePacket(bytes.NewBuffer(createPacket(256, uint8(17), uint16(53))), address)
+	assert.NoError(t, err)
+	assert.Nil(t, message)
+	assert.Empty(t, cp.GetMsgChan(), "Messages without selected records should not be delivered")
+	assert.Equal(t, uint64(3), cp.GetStats().FilteredRecords)
+
+	// Records are filtered before being passed to the record callback.
+	ports := make([]uint16, 0)
+	input.RecordCallback = func(source net.Addr, record entities.Record) error {
+		ie, _ := record.GetInfoElementWithValue("destinationTransportPort")
+		ports = append(ports, ie.Value.(uint16))
+		return nil
+	}
+	cp, err = InitCollectingProcess(input)
+	assert.NoError(t, err)
+	_, err = cp.decodePacket(bytes.NewBuffer(templatePacket), address)
+	assert.NoError(t, err)
+	_, err = cp.decodePacket(bytes.NewBuffer(createPacket(256, uint8(6), uint16(443), uint8(6), uint16(80), uint8(6), uint16(443))), address)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{443, 443}, ports)
+	assert.Equal(t, uint64(1), cp.GetStats().FilteredRecords)
+
+	input.RecordCallback = nil
+	input.ZeroCopyDecoding = true
+	_, err = InitCollectingProcess(input)
+	assert.Error(t, err)
+}
+
+func TestFileReader(t *testing.T) {
+	file := new(bytes.Buffer)
+	for _, packet := range [][]byte{validTemplatePacket, validDataPacket, validDataPacket} {
+		file.Write(packet)
+	}
+	reader, err := NewFileReader(bytes.NewReader(file.Bytes()), CollectorInput{Address: "10.0.0.1"})
+	assert.NoError(t, err)
+	message, err := reader.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, entities.Template, message.GetSet().GetSetType())
+	templates := reader.GetTemplates()
+	if assert.Len(t, templates, 1) {
+		assert.Equal(t, uint16(256), templates[0].TemplateID)
+	}
+	msgChan := make(chan *entities.Message, 2)
+	assert.NoError(t, reader.Replay(msgChan))
+	assert.Len(t, msgChan, 2)
+	message = <-msgChan
+	assert.Equal(t, "10.0.0.1", message.GetExportAddress())
+	assert.Equal(t, uint32(1), message.GetObsDomainID())
+	ie, exist := message.GetSet().GetRecords()[0].GetInfoElementWithValue("sourceIPv4Address")
+	assert.True(t, exist)
+	assert.Equal(t, net.IP{1, 2, 3, 4}, ie.Value)
+	<-msgChan
+	_, err = reader.ReadMessage()
+	assert.Equal(t, io.EOF, err)
+
+	// The file ends within the second message.
+	reader, err = NewFileReader(bytes.NewReader(file.Bytes()[:len(validTemplatePacket)+10]), CollectorInput{})
+	assert.NoError(t, err)
+	assert.Equal(t, io.ErrUnexpectedEOF, reader.Replay(msgChan))
+	assert.Equal(t, "", (<-msgChan).GetExportAddress())
+
+	// Data sets with an unknown template are errors, and are skipped.
+	reader, err = NewFileReader(bytes.NewReader(file.Bytes()[len(validTemplatePacket):]), CollectorInput{})
+	assert.NoError(t, err)
+	_, err = reader.ReadMessage()
+	assert.Error(t, err)
+	_, err = reader.ReadMessage()
+	assert.Error(t, err)
+	_, err = reader.ReadMessage()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestCollectingProcess_ListenOptions(t *testing.T) {
+	// IPv6 only: IPv4 exporters are not accepted on the wildcard address.
+	input := CollectorInput{
+		Address:       "[::]:0",
+		Protocol:      tcpTransport,
+		MaxBufferSize: 1024,
+		AddressFamily: AddressFamilyIPv6,
+	}
+	cp, err := InitCollectingProcess(input)
+	if err != nil {
+		t.Fatalf("TCP Collecting Process does not start correctly: %v", err)
+	}
+	go cp.Start()
+	waitForCollectorReady(t, cp)
+	_, port, _ := net.SplitHostPort(cp.GetAddress().String())
+	conn, err := net.Dial("tcp", net.JoinHostPort("::1", port))
+	if assert.NoError(t, err) {
+		conn.Write(validTemplatePacket)
+		<-cp.GetMsgChan()
+		conn.Close()
+	}
+	_, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	assert.Error(t, err, "IPv4 exporters should not be accepted")
+	cp.Stop()
+
+	// IPv4 only, bound to the loopback interface.
+	input = CollectorInput{
+		Address:       ":0",
+		Protocol:      udpTransport,
+		MaxBufferSize: 1024,
+		AddressFamily: AddressFamilyIPv4,
+	}
+	if runtime.GOOS == "linux" {
+		interfaces, _ := net.Interfaces()
+		for _, iface := range interfaces {
+			if iface.Flags&net.FlagLoopback != 0 {
+				input.Interface = iface.Name
+				break
+			}
+		}
+	}
+	cp, err = InitCollectingProcess(input)
+	if err != nil {
+		t.Fatalf("UDP Collecting Process does not start correctly: %v", err)
+	}
+	go cp.Start()
+	waitForCollectorReady(t, cp)
+	collectorAddr := cp.GetAddress().(*net.UDPAddr)
+	assert.NotNil(t, collectorAddr.IP.To4())
+	conn, err = net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(collectorAddr.Port)))
+	if assert.NoError(t, err) {
+		conn.Write(validTemplatePacket)
+		<-cp.GetMsgChan()
+		conn.Close()
+	}
+	cp.Stop()
+
+	for _, input := range []CollectorInput{
+		{Address: hostPortIPv4, Protocol: tcpTransport, AddressFamily: AddressFamilyDual},
+		{Address: hostPortIPv4, Protocol: tcpTransport, AddressFamily: AddressFamilyDual + 1},
+		{Address: hostPortIPv4, Protocol: "sctp", AddressFamily: AddressFamilyIPv4},
+		{Address: hostPortIPv4, Protocol: udpTransport, Interface: "nonexistent0"},
+		{Address: hostPortIPv4, Protocol: udpTransport, Interface: "lo", IsEncrypted: true},
+	} {
+		_, err := InitCollectingProcess(input)
+		assert.Error(t, err, "Input %+v should be invalid", input)
+	}
+	_, err = InitCollectingProcess(CollectorInput{Address: "0.0.0.0:0", Protocol: tcpTransport, AddressFamily: AddressFamilyDual})
+	assert.NoError(t, err)
+}
+
+func TestTCPCollectingProcess_ConnectionIdleTimeout(t *testing.T) {
+	input := getCollectorInput(tcpTransport, false, false)
+	input.TCPKeepAlive = 5
+	input.ConnectionIdleTimeout = 1
+	cp, err := InitCollectingProcess(input)
+	if err != nil {
+		t.Fatalf("TCP Collecting Process does not start correctly: %v", err)
+	}
+	go cp.Start()
+	defer cp.Stop()
+	waitForCollectorReady(t, cp)
+	collectorAddr := cp.GetAddress()
+	conn, err := net.Dial(collectorAddr.Network(), collectorAddr.String())
+	if err != nil {
+		t.Fatalf("Cannot establish connection to %s: %v", collectorAddr.String(), err)
+	}
+	defer conn.Close()
+	_, err = conn.Write(validTemplatePacket)
+	assert.NoError(t, err)
+	<-cp.GetMsgChan()
+	// The connection is closed by the collector once it has been idle for the
+	// timeout.
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(900*time.Millisecond))
+	err = wait.Poll(10*time.Millisecond, time.Second, func() (bool, error) {
+		return cp.GetStats().ClosedIdleConnections >= 1, nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestTLSCollectingProcess_CertificateReload(t *testing.T) {
+	caCert1, certPEM1, keyPEM1 := generateTestCert(t)
+	caCert2, certPEM2, keyPEM2 := generateTestCert(t)
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeCert := func(certPEM, keyPEM []byte, modTime time.Time) {
+		assert.NoError(t, ioutil.WriteFile(certFile, certPEM, 0600))
+		assert.NoError(t, ioutil.WriteFile(keyFile, keyPEM, 0600))
+		assert.NoError(t, os.Chtimes(certFile, modTime, modTime))
+		assert.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+	}
+	writeCert(certPEM1, keyPEM1, time.Now().Add(-time.Minute))
+	reloader, err := util.NewCertificateReloader(certFile, keyFile, 0)
+	if err != nil {
+		t.Fatalf("Cannot load certificate: %v", err)
+	}
+	input := CollectorInput{
+		Address:              hostPortIPv4,
+		Protocol:             tcpTransport,
+		MaxBufferSize:        1024,
+		IsEncrypted:          true,
+		GetServerCertificate: reloader.GetCertificate,
+	}
+	cp, err := InitCollectingProcess(input)
+	if err != nil {
+		t.Fatalf("TLS Collecting Process does not start correctly: %v", err)
+	}
+	go cp.Start()
+	defer cp.Stop()
+	waitForCollectorReady(t, cp)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caCert1)
+	roots.AppendCertsFromPEM(caCert2)
+	getPeerCertificate := func() []byte {
+		conn, err := tls.Dial("tcp", cp.GetAddress().String(), &tls.Config{RootCAs: roots})
+		if err != nil {
+			t.Fatalf("Cannot establish connection to %s: %v", cp.GetAddress().String(), err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Raw
+	}
+	block, _ := pem.Decode(certPEM1)
+	assert.Equal(t, block.Bytes, getPeerCertificate())
+
+	// Connections use the rotated certificate once the files are modified.
+	writeCert(certPEM2, keyPEM2, time.Now())
+	block, _ = pem.Decode(certPEM2)
+	assert.Equal(t, block.Bytes, getPeerCertificate())
+
+	// The previous certificate is kept if the modified files are invalid.
+	writeCert(certPEM1, keyPEM2, time.Now().Add(time.Minute))
+	assert.Equal(t, block.Bytes, getPeerCertificate())
+}
+
+func TestCollectingProcess_ObservationDomainStats(t *testing.T) {
+	input := CollectorInput{
+		Address:         hostPortIPv4,
+		Protocol:        tcpTransport,
+		MessageChanSize: 10,
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	address := "127.0.0.1:30000"
+	// Template 256 with sourceTransportPort, in observation domains 1 and 2.
+	for _, obsDomainID := range []uint32{1, 2} {
+		_, err = cp.decodePacket(bytes.NewBuffer(createPacketWithHeader(1612345678, 0, obsDomainID, 2, []uint16{256, 1, 7, 2})), address)
+		assert.NoError(t, err)
+		<-cp.GetMsgChan()
+	}
+	packets := [][]byte{
+		createPacketWithHeader(1612345678, 0, 1, 256, []uint16{80, 443}),
+		createPacketWithHeader(1612345678, 2, 1, 256, []uint16{80}),
+		// Two records of the domain are missing.
+		createPacketWithHeader(1612345678, 5, 1, 256, []uint16{80, 443, 8080}),
+		createPacketWithHeader(1612345678, 0, 2, 256, []uint16{53}),
+	}
+	for _, packet := range packets {
+		_, err = cp.decodePacket(bytes.NewBuffer(packet), address)
+		assert.NoError(t, err)
+		<-cp.GetMsgChan()
+	}
+	assert.Equal(t, []ObservationDomainStats{
+		{
+			ExporterAddress: address,
+			ObsDomainID:     1,
+			Messages:        4,
+			Records:         6,
+			LastExportTime:  time.Unix(1612345678, 0),
+			SequenceGaps:    1,
+			MissingRecords:  2,
+		},
+		{
+			ExporterAddress: address,
+			ObsDomainID:     2,
+			Messages:        2,
+			Records:         1,
+			LastExportTime:  time.Unix(1612345678, 0),
+		},
+	}, cp.GetStats().ObservationDomains)
+
+	cp.deleteClient(address)
+	assert.Empty(t, cp.GetStats().ObservationDomains)
+}
+
+func TestCollectingProcess_UnknownEnterpriseElements(t *testing.T) {
+	input := CollectorInput{
+		Address:         hostPortIPv4,
+		Protocol:        tcpTransport,
+		MessageChanSize: 10,
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	address := "127.0.0.1:30000"
+	// Template 256 with sourceIPv4Address and element 105 of enterprise 32473,
+	// which is not in the registry.
+	templatePacket := []byte{0, 10, 0, 36, 95, 154, 107, 127, 0, 0, 0, 0, 0, 0, 0, 1, 0, 2, 0, 20, 1, 0, 0, 2, 0, 8, 0, 4, 128, 105, 0, 2, 0, 0, 126, 217}
+	dataPacket := []byte{0, 10, 0, 26, 95, 154, 108, 18, 0, 0, 0, 1, 0, 0, 0, 1, 1, 0, 0, 10, 1, 2, 3, 4, 5, 6}
+	_, err = cp.decodePacket(bytes.NewBuffer(templatePacket), address)
+	assert.NoError(t, err)
+	<-cp.GetMsgChan()
+	assert.Equal(t, uint64(1), cp.GetStats().UnknownElements)
+	_, err = cp.decodePacket(bytes.NewBuffer(dataPacket), address)
+	assert.NoError(t, err)
+	message := <-cp.GetMsgChan()
+	if assert.Equal(t, uint32(1), message.GetSet().GetNumberOfRecords()) {
+		record := message.GetSet().GetRecords()[0]
+		sourceIPv4Address, _ := record.GetInfoElementWithValue("sourceIPv4Address")
+		assert.Equal(t, net.IP{1, 2, 3, 4}, sourceIPv4Address.Value)
+		ie, exist := record.GetInfoElementWithValue("enterprise_32473_id_105")
+		if assert.True(t, exist) {
+			assert.Equal(t, entities.OctetArray, ie.Element.DataType)
+			assert.Equal(t, uint32(32473), ie.Element.EnterpriseId)
+			assert.Equal(t, []byte{5, 6}, ie.Value)
+		}
+	}
+}
+
+func TestTCPCollectingProcess_FragmentedAndCoalescedMessages(t *testing.T) {
+	for _, bufferSize := range []int{0, 7} {
+		t.Run(fmt.Sprintf("buffer size %d", bufferSize), func(t *testing.T) {
+			input := getCollectorInput(tcpTransport, false, false)
+			input.ConnectionBufferSize = bufferSize
+			cp, err := InitCollectingProcess(input)
+			if err != nil {
+				t.Fatalf("TCP Collecting Process does not start correctly: %v", err)
+			}
+			go cp.Start()
+			defer cp.Stop()
+			waitForCollectorReady(t, cp)
+			collectorAddr := cp.GetAddress()
+			conn, err := net.Dial(collectorAddr.Network(), collectorAddr.String())
+			if err != nil {
+				t.Fatalf("Cannot establish connection to %s: %v", collectorAddr.String(), err)
+			}
+			defer conn.Close()
+			checkDataMessage := func() {
+				select {
+				case message := <-cp.GetMsgChan():
+					assert.Equal(t, uint32(1), message.GetSet().GetNumberOfRecords())
+					sourcePodName, _ := message.GetSet().GetRecords()[0].GetInfoElementWithValue("sourcePodName")
+					assert.Equal(t, "pod1", sourcePodName.Value)
+				case <-time.After(5 * time.Second):
+					t.Fatalf("Data message was not received")
+				}
+			}
+
+			// Several messages coalesced in one segment.
+			var coalesced []byte
+			coalesced = append(coalesced, validTemplatePacket...)
+			coalesced = append(coalesced, validDataPacket...)
+			coalesced = append(coalesced, validDataPacket...)
+			_, err = conn.Write(coalesced)
+			assert.NoError(t, err)
+			message := <-cp.GetMsgChan()
+			assert.Equal(t, entities.Template, message.GetSet().GetSetType())
+			checkDataMessage()
+			checkDataMessage()
+
+			// A message split across segments, which ends in the same segment
+			// as the start of the next message.
+			fragments := [][]byte{
+				validDataPacket[:3],
+				validDataPacket[3:19],
+				append(append([]byte{}, validDataPacket[19:]...), validDataPacket[:10]...),
+				validDataPacket[10:],
+			}
+			for i, fragment := range fragments {
+				_, err = conn.Write(fragment)
+				assert.NoError(t, err)
+				time.Sleep(10 * time.Millisecond)
+				if i < 2 {
+					assert.Empty(t, cp.GetMsgChan(), "Incomplete messages should not be decoded")
+				}
+			}
+			checkDataMessage()
+			checkDataMessage()
+		})
+	}
+}
+
+func TestUDPCollectingProcess_DuplicateSuppression(t *testing.T) {
+	input := getCollectorInput(udpTransport, false, false)
+	input.MessageChanSize = 10
+	input.DuplicateWindow = 1
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	address := "127.0.0.1:30000"
+	_, err = cp.decodePacket(bytes.NewBuffer(validTemplatePacket), address)
+	assert.NoError(t, err)
+	<-cp.GetMsgChan()
+	_, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), address)
+	assert.NoError(t, err)
+	<-cp.GetMsgChan()
+	// The same message retransmitted is discarded.
+	message, err := cp.decodePacket(bytes.NewBuffer(validDataPacket), address)
+	assert.NoError(t, err)
+	assert.Nil(t, message)
+	assert.Empty(t, cp.GetMsgChan())
+	assert.Equal(t, uint64(1), cp.GetStats().SuppressedDuplicates)
+	// Messages with another sequence number, or from another exporter, are
+	// not duplicates.
+	nextDataPacket := append([]byte{}, validDataPacket...)
+	nextDataPacket[11] = 1
+	message, err = cp.decodePacket(bytes.NewBuffer(nextDataPacket), address)
+	assert.NoError(t, err)
+	assert.NotNil(t, message)
+	<-cp.GetMsgChan()
+	otherAddress := "127.0.0.2:30000"
+	_, err = cp.decodePacket(bytes.NewBuffer(validTemplatePacket), otherAddress)
+	assert.NoError(t, err)
+	<-cp.GetMsgChan()
+	message, err = cp.decodePacket(bytes.NewBuffer(validDataPacket), otherAddress)
+	assert.NoError(t, err)
+	assert.NotNil(t, message)
+	<-cp.GetMsgChan()
+	assert.Equal(t, uint64(1), cp.GetStats().SuppressedDuplicates)
+
+	// Messages are forgotten after the window.
+	now := time.Now()
+	duplicates := newDuplicateFilter(time.Second)
+	assert.False(t, duplicates.isDuplicate(validDataPacket, address, now))
+	assert.True(t, duplicates.isDuplicate(validDataPacket, address, now.Add(500*time.Millisecond)))
+	assert.False(t, duplicates.isDuplicate(validDataPacket, address, now.Add(time.Second)))
+	assert.Len(t, duplicates.keys, 1)
+
+	input.Protocol = tcpTransport
+	_, err = InitCollectingProcess(input)
+	assert.Error(t, err)
+}
+
+func TestCollectingProcess_DecodeReverseElements(t *testing.T) {
+	input := CollectorInput{
+		Address:         hostPortIPv4,
+		Protocol:        tcpTransport,
+		MessageChanSize: 10,
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	address := "127.0.0.1:30000"
+	// Template 256 with packetDeltaCount and its reverse element.
+	templatePacket := []byte{0, 10, 0, 36, 95, 154, 107, 127, 0, 0, 0, 0, 0, 0, 0, 1, 0, 2, 0, 20, 1, 0, 0, 2, 0, 2, 0, 8, 128, 2, 0, 8, 0, 0, 114, 121}
+	dataPacket := []byte{0, 10, 0, 36, 95, 154, 108, 18, 0, 0, 0, 0, 0, 0, 0, 1, 1, 0, 0, 20, 0, 0, 0, 0, 0, 0, 0, 10, 0, 0, 0, 0, 0, 0, 0, 20}
+	_, err = cp.decodePacket(bytes.NewBuffer(templatePacket), address)
+	assert.NoError(t, err)
+	<-cp.GetMsgChan()
+	_, err = cp.decodePacket(bytes.NewBuffer(dataPacket), address)
+	assert.NoError(t, err)
+	message := <-cp.GetMsgChan()
+	record := message.GetSet().GetRecords()[0]
+	ie, exist := record.GetReverseInfoElementWithValue("packetDeltaCount")
+	if assert.True(t, exist) {
+		assert.Equal(t, registry.IANAReversedEnterpriseID, ie.Element.EnterpriseId)
+		assert.Equal(t, uint64(20), ie.Value)
+	}
+	assert.Equal(t, uint64(0), cp.GetStats().UnknownElements)
+}
+
+func TestCollectingProcess_ClockSkew(t *testing.T) {
+	input := CollectorInput{
+		Address:            hostPortIPv4,
+		Protocol:           tcpTransport,
+		MessageChanSize:    10,
+		EstimateClockSkew:  true,
+		NormalizeFlowTimes: true,
+	}
+	cp, err := InitCollectingProcess(input)
+	assert.NoError(t, err)
+	address := "127.0.0.1:30000"
+	// The clock of the exporter is 100 seconds ahead.
+	exporterTime := uint32(time.Now().Unix() + 100)
+	// Template 256 with flowStartSeconds and flowEndMilliseconds.
+	_, err = cp.decodePacket(bytes.NewBuffer(createPacketWithHeader(exporterTime, 0, 1, 2, []uint16{256, 2, 150, 4, 153, 8})), address)
+	assert.NoError(t, err)
+	message := <-cp.GetMsgChan()
+	assert.InDelta(t, float64(100*time.Second), float64(message.GetClockSkew()), float64(2*time.Second))
+	_, err = cp.decodePacket(bytes.NewBuffer(createPacketWithHeader(exporterTime, 0, 1, 256, exporterTime-60, uint64(exporterTime-10)*1000)), address)
+	assert.NoError(t, err)
+	message = <-cp.GetMsgChan()
+	assert.InDelta(t, float64(100*time.Second), float64(message.GetClockSkew()), float64(2*time.Second))
+	record := message.GetSet().GetRecords()[0]
+	flowStartSeconds, _ := record.GetInfoElementWithValue("flowStartSeconds")
+	assert.InDelta(t, time.Now().Unix()-60, flowStartSeconds.Value, 2)
+	flowEndMilliseconds, _ := record.GetInfoElementWithValue("flowEndMilliseconds")
+	assert.InDelta(t, (time.Now().Unix()-10)*1000, flowEndMilliseconds.Value, 2000)
+
+	// The estimate is smoothed over the messages of the exporter.
+	estimator := newClockSkewEstimator(false)
+	received := time.Unix(1000, 0)
+	assert.Equal(t, 100500*time.Millisecond, estimator.observe("10.0.0.1", 1100, received))
+	assert.Equal(t, 88000*time.Millisecond, estimator.observe("10.0.0.1", 1000, received))
+	assert.Equal(t, 500*time.Millisecond, estimator.observe("10.0.0.2", 1000, received))
+
+	input.EstimateClockSkew = false
+	_, err = InitCollectingProcess(input)
+	assert.Error(t, err)
+}
@@ -0,0 +1,68 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"net"
+	"sync/atomic"
+
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// RecordCallback is called by the collecting process for every decoded data
+// record, with the address of the transport session of the exporter, e.g.
+// "10.0.0.1:4739" for TCP and UDP. It is called from the goroutine decoding
+// the messages of the exporter, so it should return quickly, and records are
+// passed in the order of the messages of the session. Records decoded with
+// CollectorInput.ZeroCopyDecoding must be materialized to be kept after the
+// callback returns. Errors are logged and counted in the RecordCallbackErrors
+// stat; they do not stop the collecting process.
+type RecordCallback func(source net.Addr, record entities.Record) error
+
+// sessionAddr is the address of a transport session, for the network of the
+// collecting process.
+type sessionAddr struct {
+	network string
+	address string
+}
+
+func (a *sessionAddr) Network() string {
+	return a.network
+}
+
+func (a *sessionAddr) String() string {
+	return a.address
+}
+
+// dispatchRecords passes the data records of the set selected by the record
+// filter to the record callback.
+func (cp *CollectingProcess) dispatchRecords(sessionAddress string, set entities.Set) {
+	if set.GetSetType() != entities.Data {
+		return
+	}
+	source := &sessionAddr{cp.protocol, sessionAddress}
+	for _, record := range set.GetRecords() {
+		if cp.recordFilter != nil && !cp.recordFilter.Match(record) {
+			atomic.AddUint64(&cp.stats.filteredRecords, 1)
+			continue
+		}
+		if err := cp.recordCallback(source, record); err != nil {
+			atomic.AddUint64(&cp.stats.recordCallbackErrors, 1)
+			klog.V(2).Infof("Error when processing record from %s: %v", sessionAddress, err)
+		}
+	}
+}
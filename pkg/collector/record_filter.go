@@ -0,0 +1,37 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sync/atomic"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// filterRecords returns the message with the data records selected by the
+// record filter, or nil if none is selected. The records which are not
+// selected are counted in the FilteredRecords stat.
+func (cp *CollectingProcess) filterRecords(message *entities.Message) *entities.Message {
+	if cp.recordFilter == nil {
+		return message
+	}
+	filtered := filterMessage(message, cp.recordFilter)
+	dropped := message.GetSet().GetNumberOfRecords()
+	if filtered != nil {
+		dropped -= filtered.GetSet().GetNumberOfRecords()
+	}
+	atomic.AddUint64(&cp.stats.filteredRecords, uint64(dropped))
+	return filtered
+}
@@ -0,0 +1,38 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// soReusePort returns the value of SO_REUSEPORT, which is not defined by the
+// syscall package on Linux.
+func soReusePort() int {
+	switch runtime.GOARCH {
+	case "mips", "mipsle", "mips64", "mips64le", "sparc64":
+		return 0x200
+	}
+	return 0xf
+}
+
+// setReusePort sets SO_REUSEPORT on the socket, so that several sockets can be
+// bound to the same address. The kernel distributes the datagrams among them
+// by hashing their source and destination addresses, so the datagrams of an
+// exporter are received by the same socket.
+func setReusePort(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort(), 1)
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// maxSCTPPendingMessages is the maximum number of messages of an SCTP
+// association held until their template is received.
+const maxSCTPPendingMessages = 128
+
+// sctpAssociation contains the state of an SCTP association from an exporter.
+// Exporters send template sets and data sets on different streams of the
+// association (RFC7011 section 10.2), and messages are only ordered within a
+// stream, so a data set can be received before the template set it refers
+// to. Such messages are held until their template is received.
+type sctpAssociation struct {
+	// data contains the received bytes which do not form a complete message
+	// yet.
+	data []byte
+	// pendingMessages are the messages whose template is not received yet,
+	// in the order in which they were received.
+	pendingMessages [][]byte
+}
+
+func (cp *CollectingProcess) startSCTPServer() {
+	if cp.isEncrypted {
+		klog.Errorf("Cannot start collecting process on %s: encryption is not supported with SCTP", cp.address)
+		return
+	}
+	listener, err := listenSCTP(cp.address)
+	if err != nil {
+		klog.Errorf("Cannot start collecting process on %s: %v", cp.address, err)
+		return
+	}
+	cp.updateAddress(listener.Addr())
+	klog.Infof("Start SCTP collecting process on %s", cp.address)
+
+	go func() {
+		defer listener.Close()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				klog.Errorf("Cannot start collecting process on %s: %v", cp.address, err)
+				return
+			}
+			if !cp.allowConnection(conn) {
+				continue
+			}
+			go cp.handleSCTPClient(conn)
+		}
+	}()
+	<-cp.stopChan
+	// close all connections
+	cp.closeAllClients()
+}
+
+func (cp *CollectingProcess) handleSCTPClient(conn net.Conn) {
+	address := conn.RemoteAddr().String()
+	client := cp.createClient("sctp")
+	cp.addClient(address, client)
+	go func() {
+		defer conn.Close()
+		association := &sctpAssociation{}
+		buff := make([]byte, cp.maxBufferSize)
+		for {
+			size, err := conn.Read(buff)
+			if size > 0 {
+				klog.V(2).Infof("Receiving %d bytes from %s", size, address)
+				association.data = append(association.data, buff[:size]...)
+				if decodeErr := cp.processSCTPData(association, address); decodeErr != nil {
+					klog.Error(decodeErr)
+					client.errChan <- true
+					return
+				}
+			}
+			if err != nil {
+				if err == io.EOF {
+					klog.Infof("Connection from %s has been closed.", address)
+				} else {
+					klog.Errorf("Error in collecting process: %v", err)
+				}
+				client.errChan <- true
+				return
+			}
+		}
+	}()
+	<-client.errChan
+	cp.deleteClient(address)
+}
+
+// processSCTPData decodes the complete messages received on the association.
+// Every SCTP message carries a single IPFIX message, but a message larger than
+// the read buffer is received in several reads.
+func (cp *CollectingProcess) processSCTPData(association *sctpAssociation, address string) error {
+	for len(association.data) >= entities.MsgHeaderLength {
+		msgLen := int(binary.BigEndian.Uint16(association.data[2:4]))
+		if msgLen < entities.MsgHeaderLength {
+			return fmt.Errorf("invalid message length %d from %s", msgLen, address)
+		}
+		if len(association.data) < msgLen {
+			break
+		}
+		message := make([]byte, msgLen)
+		copy(message, association.data)
+		association.data = association.data[msgLen:]
+		cp.publishRawMessage(message, address, false)
+		if err := cp.processSCTPMessage(association, message, address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processSCTPMessage decodes a message received on the association, or holds
+// it if its template is not received yet. The messages held are decoded once
+// a template set is received, if their template is part of it.
+func (cp *CollectingProcess) processSCTPMessage(association *sctpAssociation, message []byte, address string) error {
+	if !cp.hasSCTPMessageTemplate(message, address) {
+		if len(association.pendingMessages) == maxSCTPPendingMessages {
+			klog.Errorf("Too many messages from %s waiting for their template, dropping the oldest one", address)
+			association.pendingMessages = association.pendingMessages[1:]
+		}
+		association.pendingMessages = append(association.pendingMessages, message)
+		return nil
+	}
+	if _, err := cp.decodePacket(bytes.NewBuffer(message), address); err != nil {
+		return err
+	}
+	if !isTemplateMessage(message) || len(association.pendingMessages) == 0 {
+		return nil
+	}
+	pendingMessages := association.pendingMessages
+	association.pendingMessages = nil
+	for _, pending := range pendingMessages {
+		if !cp.hasSCTPMessageTemplate(pending, address) {
+			association.pendingMessages = append(association.pendingMessages, pending)
+			continue
+		}
+		if _, err := cp.decodePacket(bytes.NewBuffer(pending), address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isTemplateMessage returns true if the set of the message is a template set
+// or an options template set.
+func isTemplateMessage(message []byte) bool {
+	if len(message) < entities.MsgHeaderLength+entities.SetHeaderLen {
+		return false
+	}
+	setID := binary.BigEndian.Uint16(message[16:18])
+	return setID == entities.TemplateSetID || setID == entities.OptionsTemplateSetID
+}
+
+// hasSCTPMessageTemplate returns false if the message is a data set whose
+// template is unknown. Other messages are decoded as they are, so that their
+// errors are reported.
+func (cp *CollectingProcess) hasSCTPMessageTemplate(message []byte, address string) bool {
+	if len(message) < entities.MsgHeaderLength+entities.SetHeaderLen {
+		return true
+	}
+	setID := binary.BigEndian.Uint16(message[16:18])
+	if setID < 256 {
+		return true
+	}
+	obsDomainID := binary.BigEndian.Uint32(message[12:16])
+	_, err := cp.getTemplate(address, obsDomainID, setID)
+	return err == nil
+}
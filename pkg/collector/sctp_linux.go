@@ -0,0 +1,62 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenSCTP listens on a one-to-one style SCTP socket (RFC6458 section 4),
+// whose associations are accepted and read like TCP connections. The address
+// of the listener is reported as a TCP address.
+func listenSCTP(address string) (net.Listener, error) {
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	family := syscall.AF_INET
+	var sockaddr syscall.Sockaddr
+	if addr.IP == nil || addr.IP.To4() != nil {
+		sockaddr4 := &syscall.SockaddrInet4{Port: addr.Port}
+		if addr.IP != nil {
+			copy(sockaddr4.Addr[:], addr.IP.To4())
+		}
+		sockaddr = sockaddr4
+	} else {
+		family = syscall.AF_INET6
+		sockaddr6 := &syscall.SockaddrInet6{Port: addr.Port}
+		copy(sockaddr6.Addr[:], addr.IP.To16())
+		sockaddr = sockaddr6
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, syscall.IPPROTO_SCTP)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	file := os.NewFile(uintptr(fd), "sctp:"+address)
+	// The listener uses a duplicate of the socket.
+	defer file.Close()
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if err := syscall.Bind(fd, sockaddr); err != nil {
+		return nil, os.NewSyscallError("bind", err)
+	}
+	if err := syscall.Listen(fd, syscall.SOMAXCONN); err != nil {
+		return nil, os.NewSyscallError("listen", err)
+	}
+	return net.FileListener(file)
+}
@@ -0,0 +1,154 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// getSessionRegistry returns the registry of the transport session with the
+// given client address, creating it if needed. Registry overrides configured
+// for the exporter IP are registered when the session registry is created, so
+// that messages of the session are never decoded without them.
+func (cp *CollectingProcess) getSessionRegistry(address string, exporterIP string) *registry.SessionRegistry {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	if cp.sessionRegistries == nil {
+		cp.sessionRegistries = make(map[string]*registry.SessionRegistry)
+	}
+	sessionRegistry, exist := cp.sessionRegistries[address]
+	if exist {
+		return sessionRegistry
+	}
+	sessionRegistry = registry.NewSessionRegistry()
+	for _, element := range cp.registryOverrides[exporterIP] {
+		cp.registerSessionElementLocked(sessionRegistry, element, 0)
+	}
+	cp.sessionRegistries[address] = sessionRegistry
+	return sessionRegistry
+}
+
+// registerSessionElement registers the element in the session registry. If the
+// element has a different meaning than the element with the same ID in the
+// global registry or in another session, the conflict is logged and counted.
+func (cp *CollectingProcess) registerSessionElement(sessionRegistry *registry.SessionRegistry, element *entities.InfoElement, semantics uint8) {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	cp.registerSessionElementLocked(sessionRegistry, element, semantics)
+}
+
+// registerSessionElementLocked is the same as registerSessionElement. The
+// caller must hold cp.mutex.
+func (cp *CollectingProcess) registerSessionElementLocked(sessionRegistry *registry.SessionRegistry, element *entities.InfoElement, semantics uint8) {
+	// Elements registered again with the same definition, e.g. from refreshed
+	// type records, were already checked.
+	sessionElement, exist := sessionRegistry.GetSessionInfoElementFromID(element.ElementId, element.EnterpriseId)
+	isRegistered := exist && !registry.IsConflicting(element, sessionElement)
+	if !isRegistered && cp.isConflictingElement(sessionRegistry, element) {
+		klog.V(2).Infof("Information Element %s (elementID %d, enterpriseID %d) conflicts with an existing definition", element.Name, element.ElementId, element.EnterpriseId)
+		atomic.AddUint64(&cp.stats.registryConflicts, 1)
+	}
+	sessionRegistry.RegisterInfoElement(element, semantics)
+}
+
+// isConflictingElement must be called with cp.mutex held.
+func (cp *CollectingProcess) isConflictingElement(sessionRegistry *registry.SessionRegistry, element *entities.InfoElement) bool {
+	if globalElement, err := registry.GetInfoElementFromID(element.ElementId, element.EnterpriseId); err == nil {
+		if registry.IsConflicting(element, globalElement) {
+			return true
+		}
+	}
+	for _, otherRegistry := range cp.sessionRegistries {
+		if otherRegistry == sessionRegistry {
+			continue
+		}
+		if otherElement, exist := otherRegistry.GetSessionInfoElementFromID(element.ElementId, element.EnterpriseId); exist {
+			if registry.IsConflicting(element, otherElement) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SessionStats describes a transport session from an exporter.
+type SessionStats struct {
+	// Address is the address and port of the exporter.
+	Address string
+	// Protocol is the transport protocol of the session, e.g. "tcp" or "udp".
+	Protocol string
+	// StartTime is the time at which the session started.
+	StartTime time.Time
+	// LastMessageTime is the time at which the last message was decoded, or
+	// zero if no message was decoded yet.
+	LastMessageTime time.Time
+	// Messages, Records and Bytes are the number of messages decoded in the
+	// session, of records in these messages, and of bytes of these messages.
+	Messages uint64
+	Records  uint64
+	Bytes    uint64
+}
+
+type sessionCounters struct {
+	messages uint64
+	records  uint64
+	bytes    uint64
+	// lastMessageTime is the time of the last message in Unix nanoseconds.
+	lastMessageTime int64
+}
+
+func (cp *CollectingProcess) updateSessionCounters(address string, numBytes int, numRecords uint32) {
+	cp.mutex.RLock()
+	client, exist := cp.clients[address]
+	cp.mutex.RUnlock()
+	if !exist {
+		return
+	}
+	atomic.AddUint64(&client.stats.messages, 1)
+	atomic.AddUint64(&client.stats.records, uint64(numRecords))
+	atomic.AddUint64(&client.stats.bytes, uint64(numBytes))
+	atomic.StoreInt64(&client.stats.lastMessageTime, time.Now().UnixNano())
+}
+
+// GetSessions returns the stats of the current transport sessions, sorted by
+// address.
+func (cp *CollectingProcess) GetSessions() []SessionStats {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	sessions := make([]SessionStats, 0, len(cp.clients))
+	for address, client := range cp.clients {
+		session := SessionStats{
+			Address:   address,
+			Protocol:  cp.protocol,
+			StartTime: client.startTime,
+			Messages:  atomic.LoadUint64(&client.stats.messages),
+			Records:   atomic.LoadUint64(&client.stats.records),
+			Bytes:     atomic.LoadUint64(&client.stats.bytes),
+		}
+		if lastMessageTime := atomic.LoadInt64(&client.stats.lastMessageTime); lastMessageTime != 0 {
+			session.LastMessageTime = time.Unix(0, lastMessageTime)
+		}
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Address < sessions[j].Address })
+	return sessions
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/util"
+)
+
+const (
+	// tlsHandshakeRecordType is the first byte of a TLS ClientHello. IPFIX
+	// messages start with the version number 10 over two bytes, so their
+	// first byte is 0.
+	tlsHandshakeRecordType = 0x16
+	// sniffTimeout is the time given to an exporter to send its first byte.
+	sniffTimeout = 10 * time.Second
+)
+
+// sniffedConn is a connection whose first bytes were read into reader.
+type sniffedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *sniffedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// sniffTLS returns the connection wrapped in a TLS server connection if the
+// exporter starts a TLS handshake, and the plain connection otherwise.
+func sniffTLS(conn net.Conn, config *tls.Config) (net.Conn, error) {
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	firstByte, err := reader.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	sniffed := &sniffedConn{conn, reader}
+	if firstByte[0] != tlsHandshakeRecordType {
+		klog.V(2).Infof("Accepted plain TCP connection from %s", conn.RemoteAddr())
+		return sniffed, nil
+	}
+	tlsConn := tls.Server(sniffed, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	klog.V(2).Infof("Accepted TLS connection from %s with application protocol %q", conn.RemoteAddr(), tlsConn.ConnectionState().NegotiatedProtocol)
+	return tlsConn, nil
+}
+
+// negotiateCompression replies to the compression request of the exporter if
+// it starts with one, and returns the connection with compression if it is
+// accepted. Otherwise the plain connection is returned.
+func (cp *CollectingProcess) negotiateCompression(conn net.Conn) (net.Conn, error) {
+	reader := bufio.NewReader(conn)
+	firstByte, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	sniffed := &sniffedConn{conn, reader}
+	if firstByte[0] != util.CompressionHello[0] {
+		return sniffed, nil
+	}
+	hello := make([]byte, len(util.CompressionHello))
+	if _, err := io.ReadFull(reader, hello); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hello, util.CompressionHello) {
+		return nil, fmt.Errorf("invalid compression request from %s", conn.RemoteAddr())
+	}
+	if !cp.allowCompression {
+		klog.V(2).Infof("Rejecting compression requested by %s", conn.RemoteAddr())
+		if _, err := conn.Write([]byte{util.CompressionRejected}); err != nil {
+			return nil, err
+		}
+		return sniffed, nil
+	}
+	if _, err := conn.Write([]byte{util.CompressionAccepted}); err != nil {
+		return nil, err
+	}
+	klog.V(2).Infof("Accepted compression requested by %s", conn.RemoteAddr())
+	return util.NewCompressedConn(conn, reader)
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// Stats is a snapshot of the counters of the collecting process.
+type Stats struct {
+	// RegistryConflicts is the number of session Information Elements (from
+	// registry overrides or type records) that have a different meaning than
+	// the element with the same ID in the global registry or in another session.
+	RegistryConflicts uint64
+	// PrunedTemplates is the number of templates removed because they were
+	// not used by any session for the template idle timeout.
+	PrunedTemplates uint64
+	// ExpiredTemplates is the number of templates received over UDP which
+	// expired because they were not received again within the template TTL.
+	ExpiredTemplates uint64
+	// MissingTemplateDataSets is the number of data sets dropped because their
+	// template was unknown, e.g. because it expired.
+	MissingTemplateDataSets uint64
+	// Templates contains the usage of every template known to the collector,
+	// per exporter session.
+	Templates []TemplateStats
+	// DroppedMessages is the number of messages dropped because the message
+	// channel was full, with OverloadPolicyDrop or OverloadPolicyDropOldest.
+	DroppedMessages uint64
+	// MessageBacklog is the number of messages waiting in the message channel.
+	MessageBacklog int
+	// TruncatedMessages is the number of messages received over TCP which were
+	// shorter than their declared length. Their complete records are decoded.
+	TruncatedMessages uint64
+	// DiscardedBytes is the number of bytes received over TCP which were
+	// discarded to resynchronize to the next message header.
+	DiscardedBytes uint64
+	// LatencyProbes are the stats of the latency probes received from every
+	// exporting process, if CollectorInput.MeasureLatencyProbes is set.
+	LatencyProbes map[entities.LatencyProbeSource]entities.LatencyProbeStats
+	// RejectedConnections is the number of TCP connections closed because
+	// CollectorInput.MaxConnections was reached.
+	RejectedConnections uint64
+	// DroppedPackets is the number of messages received over UDP or DTLS which
+	// were dropped because the queue of their decode worker was full, with
+	// CollectorInput.DecodeWorkers.
+	DroppedPackets uint64
+	// DecodeBacklog is the number of messages waiting in the queues of the
+	// decode workers.
+	DecodeBacklog int
+	// DeniedConnections is the number of connections and DTLS associations
+	// closed because their exporter was not in CollectorInput.AllowedExporters.
+	DeniedConnections uint64
+	// DeniedDatagrams is the number of UDP datagrams discarded because their
+	// exporter was not in CollectorInput.AllowedExporters.
+	DeniedDatagrams uint64
+	// RecordCallbackErrors is the number of data records for which
+	// CollectorInput.RecordCallback returned an error.
+	RecordCallbackErrors uint64
+	// FilteredRecords is the number of data records dropped because they were
+	// not selected by CollectorInput.RecordFilter.
+	FilteredRecords uint64
+	// ClosedIdleConnections is the number of TCP connections closed because
+	// nothing was received for CollectorInput.ConnectionIdleTimeout.
+	ClosedIdleConnections uint64
+	// ObservationDomains contains the counters of every observation domain of
+	// the current transport sessions.
+	ObservationDomains []ObservationDomainStats
+	// UnknownElements is the number of template fields with an enterprise
+	// Information Element missing from the registry, whose values are decoded
+	// as octetArray.
+	UnknownElements uint64
+	// SuppressedDuplicates is the number of messages received over UDP which
+	// were discarded as duplicates, with CollectorInput.DuplicateWindow.
+	SuppressedDuplicates uint64
+}
+
+// TemplateStats contains the usage of a template.
+type TemplateStats struct {
+	// ExporterAddress is the address and port of the exporter session which
+	// uses the template.
+	ExporterAddress string
+	ObsDomainID     uint32
+	TemplateID      uint16
+	// DataRecords is the number of data records decoded with the template
+	// since it was first received with its current elements. Refreshes of the
+	// template do not reset it.
+	DataRecords uint64
+	// LastUsed is the last time a data record was decoded with the template,
+	// or the time the template was received if it has not been used yet.
+	LastUsed time.Time
+}
+
+type collectorStats struct {
+	registryConflicts       uint64
+	prunedTemplates         uint64
+	expiredTemplates        uint64
+	droppedMessages         uint64
+	truncatedMessages       uint64
+	discardedBytes          uint64
+	missingTemplateDataSets uint64
+	rejectedConnections     uint64
+	droppedPackets          uint64
+	deniedConnections       uint64
+	deniedDatagrams         uint64
+	recordCallbackErrors    uint64
+	filteredRecords         uint64
+	closedIdleConnections   uint64
+	unknownElements         uint64
+	suppressedDuplicates    uint64
+}
+
+// GetStats returns a snapshot of the counters of the collecting process.
+func (cp *CollectingProcess) GetStats() Stats {
+	stats := Stats{
+		RegistryConflicts:       atomic.LoadUint64(&cp.stats.registryConflicts),
+		PrunedTemplates:         atomic.LoadUint64(&cp.stats.prunedTemplates),
+		ExpiredTemplates:        atomic.LoadUint64(&cp.stats.expiredTemplates),
+		MissingTemplateDataSets: atomic.LoadUint64(&cp.stats.missingTemplateDataSets),
+		Templates:               make([]TemplateStats, 0),
+		DroppedMessages:         atomic.LoadUint64(&cp.stats.droppedMessages),
+		MessageBacklog:          len(cp.messageChan),
+		TruncatedMessages:       atomic.LoadUint64(&cp.stats.truncatedMessages),
+		DiscardedBytes:          atomic.LoadUint64(&cp.stats.discardedBytes),
+		RejectedConnections:     atomic.LoadUint64(&cp.stats.rejectedConnections),
+		DroppedPackets:          atomic.LoadUint64(&cp.stats.droppedPackets),
+		DeniedConnections:       atomic.LoadUint64(&cp.stats.deniedConnections),
+		DeniedDatagrams:         atomic.LoadUint64(&cp.stats.deniedDatagrams),
+		RecordCallbackErrors:    atomic.LoadUint64(&cp.stats.recordCallbackErrors),
+		FilteredRecords:         atomic.LoadUint64(&cp.stats.filteredRecords),
+		ClosedIdleConnections:   atomic.LoadUint64(&cp.stats.closedIdleConnections),
+		UnknownElements:         atomic.LoadUint64(&cp.stats.unknownElements),
+		SuppressedDuplicates:    atomic.LoadUint64(&cp.stats.suppressedDuplicates),
+	}
+	if pool := cp.getDecodePool(); pool != nil {
+		stats.DecodeBacklog = pool.backlog()
+	}
+	if cp.latencyProbes != nil {
+		stats.LatencyProbes = cp.latencyProbes.GetStats()
+	}
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	stats.ObservationDomains = cp.getObservationDomainStats()
+	for key, usage := range cp.templateUsageMap {
+		stats.Templates = append(stats.Templates, TemplateStats{
+			ExporterAddress: key.sessionAddress,
+			ObsDomainID:     key.obsDomainID,
+			TemplateID:      key.templateID,
+			DataRecords:     usage.dataRecords,
+			LastUsed:        usage.lastUsed,
+		})
+	}
+	return stats
+}
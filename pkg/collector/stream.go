@@ -0,0 +1,301 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync/atomic"
+
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// maxExportTimeSkew is the maximum difference between the export times of a
+// message and of a message header found inside it, for the header to be
+// considered the start of the next message.
+const maxExportTimeSkew = 3600
+
+// messageHeader contains the fields of a message header used to recognize the
+// header of the next message of a stream.
+type messageHeader struct {
+	length      int
+	exportTime  uint32
+	obsDomainID uint32
+}
+
+// tcpStream contains the bytes received from a TCP client which are not
+// decoded yet.
+type tcpStream struct {
+	data []byte
+	// scanned is the offset in data from which to resume the search for the
+	// header of the next message inside the first one. The offsets before it
+	// were already rejected, and stay rejected when more bytes are received.
+	scanned int
+}
+
+// parseMessageHeader returns the header at the start of data, and false if
+// data does not start with a plausible IPFIX message header, followed by the
+// header of a template, options template or data set if data contains it.
+func parseMessageHeader(data []byte) (messageHeader, bool) {
+	if len(data) < entities.MsgHeaderLength {
+		return messageHeader{}, false
+	}
+	header := messageHeader{
+		length:      int(binary.BigEndian.Uint16(data[2:4])),
+		exportTime:  binary.BigEndian.Uint32(data[4:8]),
+		obsDomainID: binary.BigEndian.Uint32(data[12:16]),
+	}
+	if binary.BigEndian.Uint16(data[0:2]) != 10 || header.length < entities.MsgHeaderLength+entities.SetHeaderLen {
+		return messageHeader{}, false
+	}
+	if len(data) >= entities.MsgHeaderLength+entities.SetHeaderLen {
+		setID := binary.BigEndian.Uint16(data[16:18])
+		setLen := int(binary.BigEndian.Uint16(data[18:20]))
+		if (setID != entities.TemplateSetID && setID != entities.OptionsTemplateSetID && setID < 256) ||
+			setLen < entities.SetHeaderLen || setLen > header.length-entities.MsgHeaderLength {
+			return messageHeader{}, false
+		}
+	}
+	return header, true
+}
+
+// findMessageHeader returns the offset of the first message header in data
+// starting in [from, to), or -1, and the offset from which to resume the search
+// when more bytes are received. If current is not nil, the header must have the
+// same observation domain and a close export time, which makes it unlikely to
+// match the bytes of a record.
+func findMessageHeader(data []byte, from, to int, current *messageHeader) (int, int) {
+	offset := from
+	for ; offset < to && offset+entities.MsgHeaderLength <= len(data); offset++ {
+		header, ok := parseMessageHeader(data[offset:])
+		if !ok {
+			continue
+		}
+		if current != nil {
+			skew := int64(header.exportTime) - int64(current.exportTime)
+			if header.obsDomainID != current.obsDomainID || skew > maxExportTimeSkew || skew < -maxExportTimeSkew {
+				continue
+			}
+		}
+		return offset, offset
+	}
+	return -1, offset
+}
+
+// processStream decodes the complete messages at the start of the bytes
+// received from a TCP client, and returns the remaining bytes, which are the
+// start of a message not received completely yet. A message whose declared
+// length exceeds its actual length is detected when the header of the next
+// message is found inside it: its complete records are decoded and the stream
+// is resynchronized to the next message. A message whose records do not match
+// its length is only decoded once the bytes after it are received, so that the
+// next message header can be found in it. Bytes which do not start with a
+// message header are discarded up to the next message header. An error is
+// returned if a complete message cannot be decoded. Each byte of the stream is
+// only searched once for a message header, however small the reads are.
+func (cp *CollectingProcess) processStream(stream *tcpStream, address string) error {
+	for len(stream.data) >= entities.MsgHeaderLength {
+		header, ok := parseMessageHeader(stream.data)
+		if !ok {
+			next, _ := findMessageHeader(stream.data, 1, len(stream.data), nil)
+			if next < 0 {
+				// The end of the stream may be the start of the next header.
+				next = len(stream.data) - entities.MsgHeaderLength + 1
+			}
+			klog.Errorf("Invalid message header from %s, discarding %d bytes to resynchronize", address, next)
+			atomic.AddUint64(&cp.stats.discardedBytes, uint64(next))
+			stream.advance(next)
+			continue
+		}
+		from := entities.MsgHeaderLength
+		if stream.scanned > from {
+			from = stream.scanned
+		}
+		next, scanned := findMessageHeader(stream.data, from, header.length, &header)
+		if next > 0 {
+			cp.decodeTruncatedMessage(stream.data[:next], address)
+			stream.advance(next)
+			continue
+		}
+		stream.scanned = scanned
+		if len(stream.data) < header.length {
+			break
+		}
+		// A message whose records do not match its length is likely
+		// truncated, with the next header not received completely yet.
+		if !cp.isConsistentMessage(stream.data[:header.length], address) && len(stream.data) < header.length+entities.MsgHeaderLength-1 {
+			break
+		}
+		cp.publishRawMessage(stream.data[:header.length], address, false)
+		message, err := cp.decodePacket(bytes.NewBuffer(stream.data[:header.length]), address)
+		if err != nil {
+			return err
+		}
+		if message != nil {
+			klog.V(4).Infof("Processed message from exporter %v, number of records: %v, observation domain ID: %v",
+				message.GetExportAddress(), message.GetSet().GetNumberOfRecords(), message.GetObsDomainID())
+		}
+		stream.advance(header.length)
+	}
+	return nil
+}
+
+// advance discards the first n bytes of the stream, which starts a new
+// message.
+func (s *tcpStream) advance(n int) {
+	s.data = s.data[n:]
+	s.scanned = 0
+}
+
+// decodeTruncatedMessage decodes the complete data records of a message which
+// is shorter than its declared length, and reports which record is incomplete.
+// Template sets are only decoded if they are complete, which is never the case
+// for truncated messages, as they contain a single set.
+func (cp *CollectingProcess) decodeTruncatedMessage(data []byte, address string) {
+	atomic.AddUint64(&cp.stats.truncatedMessages, 1)
+	cp.publishRawMessage(data, address, true)
+	header, ok := parseMessageHeader(data)
+	if !ok || len(data) < entities.MsgHeaderLength+entities.SetHeaderLen {
+		klog.Errorf("Message from %s truncated to %d bytes: the set header is incomplete, no records were decoded", address, len(data))
+		return
+	}
+	setID := binary.BigEndian.Uint16(data[16:18])
+	setLen := int(binary.BigEndian.Uint16(data[18:20]))
+	if setID == entities.TemplateSetID || setID == entities.OptionsTemplateSetID {
+		klog.Errorf("Message from %s truncated to %d of %d bytes: set 1 (template set) is incomplete, no records were decoded", address, len(data), header.length)
+		return
+	}
+	template, err := cp.getTemplate(address, header.obsDomainID, setID)
+	if err != nil {
+		klog.Errorf("Message from %s truncated to %d of %d bytes: template %d with obsDomainID %d does not exist, no records were decoded", address, len(data), header.length, setID, header.obsDomainID)
+		return
+	}
+	records := data[entities.MsgHeaderLength+entities.SetHeaderLen:]
+	if setLen-entities.SetHeaderLen < len(records) {
+		records = records[:setLen-entities.SetHeaderLen]
+	}
+	completeLen, numRecords := 0, 0
+	for {
+		recordLen, ok := getDataRecordLen(template, records[completeLen:])
+		if !ok {
+			break
+		}
+		completeLen += recordLen
+		numRecords++
+	}
+	if numRecords > 0 {
+		// Decode the complete records as a message of their length.
+		msgLen := entities.MsgHeaderLength + entities.SetHeaderLen + completeLen
+		packet := make([]byte, msgLen)
+		copy(packet, data[:msgLen])
+		binary.BigEndian.PutUint16(packet[2:4], uint16(msgLen))
+		binary.BigEndian.PutUint16(packet[18:20], uint16(entities.SetHeaderLen+completeLen))
+		if _, err := cp.decodePacket(bytes.NewBuffer(packet), address); err != nil {
+			klog.Errorf("Message from %s truncated to %d of %d bytes: cannot decode the complete records of set 1: %v", address, len(data), header.length, err)
+			return
+		}
+	}
+	klog.Errorf("Message from %s truncated to %d of %d bytes: record %d of set 1 (template ID %d) is incomplete, %d complete records were decoded",
+		address, len(data), header.length, numRecords+1, setID, numRecords)
+}
+
+// isConsistentMessage returns false if the length of the set does not match
+// the length of the message, or if the records of the set do not match the
+// length of the set.
+func (cp *CollectingProcess) isConsistentMessage(message []byte, address string) bool {
+	setID := binary.BigEndian.Uint16(message[16:18])
+	setLen := int(binary.BigEndian.Uint16(message[18:20]))
+	if entities.MsgHeaderLength+setLen != len(message) {
+		return false
+	}
+	records := message[entities.MsgHeaderLength+entities.SetHeaderLen:]
+	if setID == entities.TemplateSetID || setID == entities.OptionsTemplateSetID {
+		return getTemplateRecordLen(records, setID == entities.OptionsTemplateSetID) > 0
+	}
+	template, err := cp.getTemplate(address, binary.BigEndian.Uint32(message[12:16]), setID)
+	if err != nil {
+		// The message cannot be decoded anyway.
+		return true
+	}
+	minRecordLen := getMinDataRecordLen(template)
+	for len(records) > 0 && len(records) >= minRecordLen {
+		recordLen, ok := getDataRecordLen(template, records)
+		if !ok {
+			return false
+		}
+		records = records[recordLen:]
+	}
+	return true
+}
+
+// getTemplateRecordLen returns the length of the template record at the start
+// of data, or 0 if data does not contain the whole record.
+func getTemplateRecordLen(data []byte, isOptions bool) int {
+	offset := 4
+	if isOptions {
+		offset = 6
+	}
+	if len(data) < offset {
+		return 0
+	}
+	fieldCount := int(binary.BigEndian.Uint16(data[2:4]))
+	for i := 0; i < fieldCount; i++ {
+		if offset+4 > len(data) {
+			return 0
+		}
+		isEnterprise := data[offset]>>7 == 1
+		offset += 4
+		if isEnterprise {
+			offset += 4
+		}
+	}
+	if offset > len(data) {
+		return 0
+	}
+	return offset
+}
+
+// getDataRecordLen returns the length of the data record of the template at
+// the start of data, and false if data does not contain the whole record.
+func getDataRecordLen(template []*entities.InfoElement, data []byte) (int, bool) {
+	if len(data) == 0 {
+		return 0, false
+	}
+	offset := 0
+	for _, element := range template {
+		length := int(element.Len)
+		if element.Len == entities.VariableLength {
+			if offset >= len(data) {
+				return 0, false
+			}
+			length = int(data[offset])
+			offset++
+			if length == 255 {
+				if offset+2 > len(data) {
+					return 0, false
+				}
+				length = int(binary.BigEndian.Uint16(data[offset : offset+2]))
+				offset += 2
+			}
+		}
+		offset += length
+		if offset > len(data) {
+			return 0, false
+		}
+	}
+	return offset, true
+}
@@ -0,0 +1,162 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sync/atomic"
+
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/filter"
+)
+
+// Subscription receives the decoded messages whose data records match its
+// filter. Data sets only contain the matching records, and messages without
+// any matching record are not delivered. Template sets are always delivered.
+// Every subscription receives copies of the records, which it may modify
+// without affecting other subscriptions and the message channel.
+// Subscriptions never block the collecting process: when the channel of a
+// subscription is full, the message is dropped for that subscription only.
+type Subscription struct {
+	filter          *filter.Filter
+	messageChan     chan *entities.Message
+	droppedMessages uint64
+}
+
+// GetMsgChan returns the channel of the subscription. It is closed by
+// Unsubscribe.
+func (s *Subscription) GetMsgChan() <-chan *entities.Message {
+	return s.messageChan
+}
+
+// GetDroppedMessages returns the number of messages dropped because the
+// channel of the subscription was full.
+func (s *Subscription) GetDroppedMessages() uint64 {
+	return atomic.LoadUint64(&s.droppedMessages)
+}
+
+// filteredSet is a decoded set with a subset or copies of its records.
+type filteredSet struct {
+	entities.Set
+	records []entities.Record
+}
+
+func (s *filteredSet) GetRecords() []entities.Record {
+	return s.records
+}
+
+func (s *filteredSet) GetNumberOfRecords() uint32 {
+	return uint32(len(s.records))
+}
+
+// Subscribe adds a subscription receiving the messages selected by the record
+// filter, through a channel with the given capacity.
+func (cp *CollectingProcess) Subscribe(recordFilter filter.RecordFilter, chanSize int) (*Subscription, error) {
+	f, err := filter.NewFilter(recordFilter)
+	if err != nil {
+		return nil, err
+	}
+	subscription := &Subscription{
+		filter:      f,
+		messageChan: make(chan *entities.Message, chanSize),
+	}
+	cp.subscriptionsMutex.Lock()
+	defer cp.subscriptionsMutex.Unlock()
+	cp.subscriptions = append(cp.subscriptions, subscription)
+	return subscription, nil
+}
+
+// Unsubscribe removes the subscription and closes its channel.
+func (cp *CollectingProcess) Unsubscribe(subscription *Subscription) {
+	cp.subscriptionsMutex.Lock()
+	defer cp.subscriptionsMutex.Unlock()
+	for i, s := range cp.subscriptions {
+		if s == subscription {
+			cp.subscriptions = append(cp.subscriptions[:i], cp.subscriptions[i+1:]...)
+			close(subscription.messageChan)
+			return
+		}
+	}
+}
+
+// publishMessage delivers a copy of the message to every subscription,
+// filtering its data records with the filter of the subscription. It must be
+// called before the message is sent to the message channel, whose consumer may
+// modify the records.
+func (cp *CollectingProcess) publishMessage(message *entities.Message) {
+	cp.subscriptionsMutex.RLock()
+	defer cp.subscriptionsMutex.RUnlock()
+	for _, subscription := range cp.subscriptions {
+		filtered := filterMessage(message, subscription.filter)
+		if filtered == nil {
+			continue
+		}
+		select {
+		case subscription.messageChan <- copyMessage(filtered):
+		default:
+			atomic.AddUint64(&subscription.droppedMessages, 1)
+			klog.V(4).Infof("Subscription channel is full, dropping message from exporter %s", message.GetExportAddress())
+		}
+	}
+}
+
+// filterMessage returns the message with the data records matching the filter,
+// or nil if there are none. The message is returned as is if all its records
+// match.
+func filterMessage(message *entities.Message, f filter.Predicate) *entities.Message {
+	set := message.GetSet()
+	if set.GetSetType() != entities.Data {
+		return message
+	}
+	records := make([]entities.Record, 0)
+	for _, record := range set.GetRecords() {
+		if f.Match(record) {
+			records = append(records, record)
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	if len(records) == len(set.GetRecords()) {
+		return message
+	}
+	return newMessageWithRecords(message, records)
+}
+
+// copyMessage returns the message with copies of its records.
+func copyMessage(message *entities.Message) *entities.Message {
+	records := make([]entities.Record, 0, len(message.GetSet().GetRecords()))
+	for _, record := range message.GetSet().GetRecords() {
+		records = append(records, entities.CopyRecord(record))
+	}
+	return newMessageWithRecords(message, records)
+}
+
+// newMessageWithRecords returns a message with the header of the message and
+// its set with the given records.
+func newMessageWithRecords(message *entities.Message, records []entities.Record) *entities.Message {
+	newMessage := entities.NewMessage(true)
+	newMessage.SetVersion(message.GetVersion())
+	newMessage.SetMessageLen(message.GetMessageLen())
+	newMessage.SetExportTime(message.GetExportTime())
+	newMessage.SetSequenceNum(message.GetSequenceNum())
+	newMessage.SetObsDomainID(message.GetObsDomainID())
+	newMessage.SetExportAddress(message.GetExportAddress())
+	newMessage.SetObsDomainName(message.GetObsDomainName())
+	newMessage.SetClockSkew(message.GetClockSkew())
+	newMessage.AddSet(&filteredSet{Set: message.GetSet(), records: records})
+	return newMessage
+}
@@ -0,0 +1,176 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// ObservationDomainNameResolver returns a human-readable name for the
+// observation domain of an exporter, e.g. the name of the node or the hostname
+// of the router, or "" if it is unknown. It is called for every decoded
+// message, so it should not block.
+type ObservationDomainNameResolver func(exporterIP string, obsDomainID uint32) string
+
+// SourceTags are Information Elements with static values (e.g. site, rack,
+// region or cluster name) which are attached to the records from exporters in
+// the given CIDR.
+type SourceTags struct {
+	// CIDR is the range of exporter addresses, e.g. "10.0.0.0/24".
+	CIDR string
+	Tags []*entities.InfoElementWithValue
+}
+
+// tag is an element with its value encoded, so that it is decoded like the
+// other elements of the records.
+type tag struct {
+	element *entities.InfoElement
+	value   []byte
+}
+
+type sourceTags struct {
+	ipNet *net.IPNet
+	tags  []tag
+}
+
+func parseSourceTags(input []SourceTags) ([]sourceTags, error) {
+	parsed := make([]sourceTags, 0, len(input))
+	for _, st := range input {
+		_, ipNet, err := net.ParseCIDR(st.CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %s for source tags: %v", st.CIDR, err)
+		}
+		tags, err := encodeTags(st.Tags)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, sourceTags{ipNet, tags})
+	}
+	return parsed, nil
+}
+
+// encodeTags encodes the value of every tag with the data type of its element.
+func encodeTags(input []*entities.InfoElementWithValue) ([]tag, error) {
+	tags := make([]tag, 0, len(input))
+	for _, ie := range input {
+		buff := new(bytes.Buffer)
+		if _, err := entities.EncodeElementValue(ie.Element, ie.Value, buff, entities.OverflowPolicyError); err != nil {
+			return nil, fmt.Errorf("invalid value for tag %s: %v", ie.Element.Name, err)
+		}
+		if ie.Element.Len == entities.VariableLength {
+			// Skip the length, as decoded values do not include it.
+			if _, err := entities.DecodeVariableLength(buff); err != nil {
+				return nil, err
+			}
+		}
+		tags = append(tags, tag{ie.Element, buff.Bytes()})
+	}
+	return tags, nil
+}
+
+// getObsDomainNameElement returns the observationDomainName element, which is
+// attached to the records when names of observation domains are resolved.
+func getObsDomainNameElement(resolver ObservationDomainNameResolver) (*entities.InfoElement, error) {
+	if resolver == nil {
+		return nil, nil
+	}
+	element, err := registry.GetInfoElement("observationDomainName", registry.IANAEnterpriseID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve names of observation domains: %v", err)
+	}
+	return element, nil
+}
+
+// resolveObsDomainName returns the name of the observation domain of the
+// exporter, or "" if names are not resolved.
+func (cp *CollectingProcess) resolveObsDomainName(exporterIP string, obsDomainID uint32) string {
+	if cp.obsDomainNameResolver == nil {
+		return ""
+	}
+	return cp.obsDomainNameResolver(exporterIP, obsDomainID)
+}
+
+// getTags returns the tags for the exporter: the observation point tags,
+// followed by the tags of every source CIDR containing the exporter address,
+// and by the name of the observation domain if names are resolved. A tag with
+// the same element as an earlier tag replaces it.
+func (cp *CollectingProcess) getTags(exporterIP string, obsDomainName string) []tag {
+	if len(cp.observationPointTags) == 0 && len(cp.sourceTags) == 0 && cp.obsDomainNameElement == nil {
+		return nil
+	}
+	tags := make([]tag, 0, len(cp.observationPointTags))
+	indexes := make(map[string]int)
+	addTag := func(t tag) {
+		if i, exist := indexes[t.element.Name]; exist {
+			tags[i] = t
+			return
+		}
+		indexes[t.element.Name] = len(tags)
+		tags = append(tags, t)
+	}
+	for _, t := range cp.observationPointTags {
+		addTag(t)
+	}
+	ip := net.ParseIP(exporterIP)
+	for _, st := range cp.sourceTags {
+		if ip != nil && st.ipNet.Contains(ip) {
+			for _, t := range st.tags {
+				addTag(t)
+			}
+		}
+	}
+	if cp.obsDomainNameElement != nil {
+		// The element is added even if the name is unknown, so that the
+		// records of a template always have the same elements.
+		addTag(tag{cp.obsDomainNameElement, []byte(obsDomainName)})
+	}
+	return tags
+}
+
+// addTags appends the tags of the exporter to every record in the set. Template
+// records get the elements without values, so that consumers building
+// templates from the decoded templates include them. A tag whose element is
+// already in the record replaces its value.
+func (cp *CollectingProcess) addTags(set entities.Set, exporterIP string, obsDomainName string) error {
+	tags := cp.getTags(exporterIP, obsDomainName)
+	if len(tags) == 0 {
+		return nil
+	}
+	isData := set.GetSetType() == entities.Data
+	for _, record := range set.GetRecords() {
+		for _, t := range tags {
+			var err error
+			if ie, exist := record.GetInfoElementWithValue(t.element.Name); exist {
+				ie.Element = t.element
+				if isData {
+					ie.Value, err = entities.DecodeElementValue(t.element, bytes.NewBuffer(t.value))
+				}
+			} else if isData {
+				_, err = record.AddInfoElement(entities.NewInfoElementWithValue(t.element, bytes.NewBuffer(t.value)), true)
+			} else {
+				_, err = record.AddInfoElement(entities.NewInfoElementWithValue(t.element, nil), false)
+			}
+			if err != nil {
+				return fmt.Errorf("error when adding tag %s: %v", t.element.Name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// RawMessage is an exact copy of a message received from an exporter, before
+// it is decoded.
+type RawMessage struct {
+	// Data contains the bytes of the message: a UDP datagram, or a message
+	// framed from a TCP stream or an SCTP association by its length.
+	Data []byte
+	// ExporterAddress is the address of the exporter, in hostIP:port format.
+	ExporterAddress string
+	// Transport is the transport protocol of the collector, "tcp", "udp",
+	// "sctp", "unix" or "unixgram".
+	Transport string
+	// ReceiveTime is the time at which the message was framed.
+	ReceiveTime time.Time
+	// Truncated is true if the message is shorter than its declared length,
+	// because the header of the next message was found inside it or because
+	// the TCP connection was closed.
+	Truncated bool
+}
+
+// Tap receives copies of all the messages received by the collecting process,
+// whether they can be decoded or not, e.g. to archive them or to replay them
+// against another version of the decoder. Like subscriptions, taps never block
+// the collecting process: when the channel of a tap is full, the message is
+// dropped for that tap only.
+type Tap struct {
+	messageChan     chan *RawMessage
+	droppedMessages uint64
+}
+
+// GetMsgChan returns the channel of the tap. It is closed by RemoveTap.
+func (t *Tap) GetMsgChan() <-chan *RawMessage {
+	return t.messageChan
+}
+
+// GetDroppedMessages returns the number of messages dropped because the
+// channel of the tap was full.
+func (t *Tap) GetDroppedMessages() uint64 {
+	return atomic.LoadUint64(&t.droppedMessages)
+}
+
+// AddTap adds a tap receiving the raw messages through a channel with the
+// given capacity.
+func (cp *CollectingProcess) AddTap(chanSize int) *Tap {
+	tap := &Tap{
+		messageChan: make(chan *RawMessage, chanSize),
+	}
+	cp.tapsMutex.Lock()
+	defer cp.tapsMutex.Unlock()
+	cp.taps = append(cp.taps, tap)
+	return tap
+}
+
+// RemoveTap removes the tap and closes its channel.
+func (cp *CollectingProcess) RemoveTap(tap *Tap) {
+	cp.tapsMutex.Lock()
+	defer cp.tapsMutex.Unlock()
+	for i, t := range cp.taps {
+		if t == tap {
+			cp.taps = append(cp.taps[:i], cp.taps[i+1:]...)
+			close(tap.messageChan)
+			return
+		}
+	}
+}
+
+// publishRawMessage delivers a copy of the message received from the exporter
+// to every tap.
+func (cp *CollectingProcess) publishRawMessage(data []byte, address string, truncated bool) {
+	cp.tapsMutex.RLock()
+	defer cp.tapsMutex.RUnlock()
+	if len(cp.taps) == 0 {
+		return
+	}
+	// The bytes are copied once, as the buffers of the collector are reused.
+	message := &RawMessage{
+		Data:            append([]byte(nil), data...),
+		ExporterAddress: address,
+		Transport:       cp.protocol,
+		ReceiveTime:     time.Now(),
+		Truncated:       truncated,
+	}
+	for _, tap := range cp.taps {
+		select {
+		case tap.messageChan <- message:
+		default:
+			atomic.AddUint64(&tap.droppedMessages, 1)
+			klog.V(4).Infof("Tap channel is full, dropping raw message from exporter %s", address)
+		}
+	}
+}
@@ -1,12 +1,14 @@
 package collector
 
 import (
-	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"io"
 	"net"
+	"sync/atomic"
+	"time"
 
 	"k8s.io/klog/v2"
 )
@@ -14,21 +16,38 @@ import (
 func (cp *CollectingProcess) startTCPServer() {
 	var listener net.Listener
 	var err error
-	if cp.isEncrypted { // use TLS
+	// sniffConfig is used to serve TLS and plain TCP connections on the same
+	// listener.
+	var sniffConfig *tls.Config
+	if cp.isEncrypted && cp.allowPlainTCP {
+		sniffConfig, err = cp.createServerConfig()
+		if err != nil {
+			klog.Error(err)
+			return
+		}
+		listener, err = cp.listenConfig(false).Listen(context.Background(), cp.listenNetwork("tcp"), cp.address)
+		if err != nil {
+			klog.Errorf("Cannot start collecting process on %s: %v", cp.address, err)
+			return
+		}
+		cp.updateAddress(listener.Addr())
+		klog.Infof("Started TLS and TCP collecting process on %s", cp.address)
+	} else if cp.isEncrypted { // use TLS
 		config, err := cp.createServerConfig()
 		if err != nil {
 			klog.Error(err)
 			return
 		}
-		listener, err = tls.Listen("tcp", cp.address, config)
+		listener, err = cp.listenConfig(false).Listen(context.Background(), cp.listenNetwork("tcp"), cp.address)
 		if err != nil {
 			klog.Errorf("Cannot start tls collecting process on %s: %v", cp.address, err)
 			return
 		}
+		listener = tls.NewListener(listener, config)
 		cp.updateAddress(listener.Addr())
 		klog.Infof("Started TLS collecting process on %s", cp.address)
 	} else {
-		listener, err = net.Listen("tcp", cp.address)
+		listener, err = cp.listenConfig(false).Listen(context.Background(), cp.listenNetwork("tcp"), cp.address)
 		if err != nil {
 			klog.Errorf("Cannot start collecting process on %s: %v", cp.address, err)
 			return
@@ -45,7 +64,26 @@ func (cp *CollectingProcess) startTCPServer() {
 				klog.Errorf("Cannot start collecting process on %s: %v", cp.address, err)
 				return
 			}
-			go cp.handleTCPClient(conn)
+			if !cp.allowConnection(conn) || !cp.acquireConnection(conn) {
+				continue
+			}
+			if sniffConfig == nil {
+				go func() {
+					defer cp.releaseConnection()
+					cp.handleTCPClient(conn)
+				}()
+				continue
+			}
+			go func() {
+				defer cp.releaseConnection()
+				sniffed, err := sniffTLS(conn, sniffConfig)
+				if err != nil {
+					klog.Errorf("Error when accepting connection from %s: %v", conn.RemoteAddr(), err)
+					conn.Close()
+					return
+				}
+				cp.handleTCPClient(sniffed)
+			}()
 		}
 	}()
 	<-cp.stopChan
@@ -55,46 +93,54 @@ func (cp *CollectingProcess) startTCPServer() {
 
 func (cp *CollectingProcess) handleTCPClient(conn net.Conn) {
 	address := conn.RemoteAddr().String()
-	client := cp.createClient()
+	client := cp.createClient(cp.protocol)
 	cp.addClient(address, client)
 	go func() {
-		defer conn.Close()
-	out:
+		sessionConn, err := cp.negotiateCompression(conn)
+		if err != nil {
+			if err != io.EOF {
+				klog.Errorf("Error when negotiating compression with %s: %v", address, err)
+			}
+			conn.Close()
+			client.errChan <- true
+			return
+		}
+		defer sessionConn.Close()
+		stream := &tcpStream{}
+		bufferSize := cp.connectionBufferSize
+		if bufferSize == 0 {
+			bufferSize = int(cp.maxBufferSize)
+		}
+		buff := make([]byte, bufferSize)
 		for {
-			buff := make([]byte, cp.maxBufferSize)
-			size, err := conn.Read(buff)
+			if cp.connectionIdleTimeout > 0 {
+				sessionConn.SetReadDeadline(time.Now().Add(cp.connectionIdleTimeout))
+			}
+			size, err := sessionConn.Read(buff)
+			if size > 0 {
+				klog.V(2).Infof("Receiving %d bytes from %s", size, address)
+				stream.data = append(stream.data, buff[:size]...)
+				if decodeErr := cp.processStream(stream, address); decodeErr != nil {
+					klog.Error(decodeErr)
+					client.errChan <- true
+					return
+				}
+			}
 			if err != nil {
+				if len(stream.data) > 0 {
+					// The last message was not received completely.
+					cp.decodeTruncatedMessage(stream.data, address)
+				}
 				if err == io.EOF {
 					klog.Infof("Connection from %s has been closed.", address)
+				} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() && cp.connectionIdleTimeout > 0 {
+					atomic.AddUint64(&cp.stats.closedIdleConnections, 1)
+					klog.Infof("Closing connection from %s, which has been idle for %v", address, cp.connectionIdleTimeout)
 				} else {
 					klog.Errorf("Error in collecting process: %v", err)
 				}
 				client.errChan <- true
-				break out
-			}
-			klog.V(2).Infof("Receiving %d bytes from %s", size, address)
-			for size > 0 {
-				length, err := getMessageLength(bytes.NewBuffer(buff))
-				if err != nil {
-					klog.Error(err)
-					client.errChan <- true
-					break out
-				}
-				if size < length {
-					klog.Errorf("Message length %v is larger than size read from buffer %v", length, size)
-					break out
-				}
-				size = size - length
-				// get the message here
-				message, err := cp.decodePacket(bytes.NewBuffer(buff[0:length]), address)
-				if err != nil {
-					klog.Error(err)
-					client.errChan <- true
-					break out
-				}
-				klog.V(4).Infof("Processed message from exporter %v, number of records: %v, observation domain ID: %v",
-					message.GetExportAddress(), message.GetSet().GetNumberOfRecords(), message.GetObsDomainID())
-				buff = buff[length:]
+				return
 			}
 		}
 	}()
@@ -102,26 +148,76 @@ func (cp *CollectingProcess) handleTCPClient(conn net.Conn) {
 	cp.deleteClient(address)
 }
 
+// acquireConnection counts the accepted connection, and closes it if the
+// maximum number of connections is reached.
+func (cp *CollectingProcess) acquireConnection(conn net.Conn) bool {
+	if cp.maxConnections == 0 {
+		return true
+	}
+	if atomic.AddInt32(&cp.connections, 1) <= int32(cp.maxConnections) {
+		return true
+	}
+	atomic.AddInt32(&cp.connections, -1)
+	atomic.AddUint64(&cp.stats.rejectedConnections, 1)
+	klog.Warningf("Rejecting connection from %s: the maximum number of connections (%d) is reached", conn.RemoteAddr(), cp.maxConnections)
+	conn.Close()
+	return false
+}
+
+// releaseConnection stops counting a connection once it is closed.
+func (cp *CollectingProcess) releaseConnection() {
+	if cp.maxConnections != 0 {
+		atomic.AddInt32(&cp.connections, -1)
+	}
+}
+
 func (cp *CollectingProcess) createServerConfig() (*tls.Config, error) {
-	cert, err := tls.X509KeyPair(cp.serverCert, cp.serverKey)
-	if err != nil {
-		return nil, err
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: cp.alpnProtocols,
+	}
+	if cp.getServerCertificate != nil {
+		// The certificate is requested for every handshake, so that rotated
+		// certificates are used without restarting.
+		config.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return cp.getServerCertificate()
+		}
+	} else {
+		cert, err := tls.X509KeyPair(cp.serverCert, cp.serverKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
 	}
 	if cp.caCert == nil {
-		return &tls.Config{
-			Certificates: []tls.Certificate{cert},
-			MinVersion:   tls.VersionTLS12,
-		}, nil
+		return config, nil
 	}
 	roots := x509.NewCertPool()
 	ok := roots.AppendCertsFromPEM(cp.caCert)
 	if !ok {
 		return nil, fmt.Errorf("failed to parse root certificate")
 	}
-	return &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		ClientCAs:    roots,
-		MinVersion:   tls.VersionTLS12,
-	}, nil
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	config.ClientCAs = roots
+	if cp.allowedClientNames != nil {
+		config.VerifyPeerCertificate = verifyAllowedClientNames(cp.allowedClientNames)
+	}
+	return config, nil
+}
+
+// getServerCertificates returns the certificate of the collecting process,
+// from the certificate getter if any.
+func (cp *CollectingProcess) getServerCertificates() ([]tls.Certificate, error) {
+	if cp.getServerCertificate != nil {
+		cert, err := cp.getServerCertificate()
+		if err != nil {
+			return nil, err
+		}
+		return []tls.Certificate{*cert}, nil
+	}
+	cert, err := tls.X509KeyPair(cp.serverCert, cp.serverKey)
+	if err != nil {
+		return nil, err
+	}
+	return []tls.Certificate{cert}, nil
 }
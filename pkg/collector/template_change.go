@@ -0,0 +1,93 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// TemplateChangeType is the kind of change of a template.
+type TemplateChangeType uint8
+
+const (
+	// TemplateAdded is a template received with a new template ID.
+	TemplateAdded TemplateChangeType = iota
+	// TemplateReplaced is a template received with the ID of an existing
+	// template, and different elements. Templates sent again with the same
+	// elements, e.g. refreshed over UDP, are not reported.
+	TemplateReplaced
+	// TemplateWithdrawn is a template withdrawn by the exporter with a
+	// template withdrawal (RFC7011 section 8.1).
+	TemplateWithdrawn
+	// TemplateExpired is a template removed by the collecting process, because
+	// its lifetime expired over UDP or it was unused for TemplateIdleTimeout.
+	TemplateExpired
+)
+
+func (t TemplateChangeType) String() string {
+	switch t {
+	case TemplateAdded:
+		return "added"
+	case TemplateReplaced:
+		return "replaced"
+	case TemplateWithdrawn:
+		return "withdrawn"
+	case TemplateExpired:
+		return "expired"
+	}
+	return "unknown"
+}
+
+// TemplateChange describes a change of a template of the collecting process.
+type TemplateChange struct {
+	Type TemplateChangeType
+	// ExporterAddress is the address of the transport session which sent or
+	// withdrew the template. It is empty for expired templates.
+	ExporterAddress string
+	ObsDomainID     uint32
+	TemplateID      uint16
+	// Elements are the elements of the added or replaced template, in order.
+	// They are nil for removed templates.
+	Elements []*entities.InfoElement
+	// ScopeFieldCount is non-zero for added or replaced options templates.
+	ScopeFieldCount uint16
+}
+
+// TemplateChangeCallback is called by the collecting process when a template
+// changes, e.g. so that sinks with a schema can update it before receiving
+// data records with the new template. It is called from the goroutine
+// decoding the messages of the exporter, before the message with the template
+// is delivered, so it should return quickly.
+type TemplateChangeCallback func(change TemplateChange)
+
+func (cp *CollectingProcess) notifyTemplateChange(change TemplateChange) {
+	if cp.templateChangeCallback != nil {
+		cp.templateChangeCallback(change)
+	}
+}
+
+// sameTemplateElements returns true if the templates have the same field
+// specifiers.
+func sameTemplateElements(elements1, elements2 []*entities.InfoElement) bool {
+	if len(elements1) != len(elements2) {
+		return false
+	}
+	for i := range elements1 {
+		if elements1[i].ElementId != elements2[i].ElementId || elements1[i].EnterpriseId != elements2[i].EnterpriseId || elements1[i].Len != elements2[i].Len {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// minTemplatePruneInterval is the minimum interval between two pruning passes.
+const minTemplatePruneInterval = time.Second
+
+// templateUsageKey identifies the usage of a template by an exporter session.
+type templateUsageKey struct {
+	sessionAddress string
+	obsDomainID    uint32
+	templateID     uint16
+}
+
+type templateUsage struct {
+	// lastUsed is the last time a data record was decoded with the template,
+	// or the time the template was received if it has not been used yet.
+	lastUsed    time.Time
+	dataRecords uint64
+	// received is the last time the template was received, from which
+	// templates expire over UDP.
+	received time.Time
+	// scopeFieldCount is the number of scope fields of options templates, and
+	// 0 for templates.
+	scopeFieldCount uint16
+}
+
+// resetTemplateUsage starts tracking the usage of a template received in the
+// session. If the template did not change, e.g. because the exporter refreshes
+// it over UDP, the data records decoded with it are still counted. Caller must
+// hold the mutex.
+func (cp *CollectingProcess) resetTemplateUsage(sessionAddress string, obsDomainID uint32, templateID uint16, scopeFieldCount uint16, changed bool) {
+	if cp.templateUsageMap == nil {
+		cp.templateUsageMap = make(map[templateUsageKey]*templateUsage)
+	}
+	now := time.Now()
+	key := templateUsageKey{sessionAddress, obsDomainID, templateID}
+	if usage, exists := cp.templateUsageMap[key]; exists && !changed {
+		usage.received = now
+		if usage.dataRecords == 0 {
+			usage.lastUsed = now
+		}
+		return
+	}
+	cp.templateUsageMap[key] = &templateUsage{lastUsed: now, received: now, scopeFieldCount: scopeFieldCount}
+}
+
+// updateTemplateUsage counts the data records decoded with the template in the
+// session.
+func (cp *CollectingProcess) updateTemplateUsage(sessionAddress string, obsDomainID uint32, templateID uint16, numRecords uint32) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	if _, exists := cp.templatesMap[templateDomain{sessionAddress, obsDomainID}][templateID]; !exists {
+		return
+	}
+	key := templateUsageKey{sessionAddress, obsDomainID, templateID}
+	usage, exists := cp.templateUsageMap[key]
+	if !exists {
+		if cp.templateUsageMap == nil {
+			cp.templateUsageMap = make(map[templateUsageKey]*templateUsage)
+		}
+		usage = &templateUsage{}
+		cp.templateUsageMap[key] = usage
+	}
+	usage.lastUsed = time.Now()
+	usage.dataRecords += uint64(numRecords)
+}
+
+// getScopeFieldCount returns the number of scope fields of the template
+// received in the session, which is 0 if it is not an options template.
+func (cp *CollectingProcess) getScopeFieldCount(sessionAddress string, obsDomainID uint32, templateID uint16) uint16 {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	if usage, exists := cp.templateUsageMap[templateUsageKey{sessionAddress, obsDomainID, templateID}]; exists {
+		return usage.scopeFieldCount
+	}
+	return 0
+}
+
+// deleteTemplateUsage stops tracking the usage of the template, or of all the
+// templates of the observation domain of the session. Caller must hold the
+// mutex.
+func (cp *CollectingProcess) deleteTemplateUsage(domain templateDomain, templateID uint16, allTemplates bool) {
+	for key := range cp.templateUsageMap {
+		if key.sessionAddress == domain.sessionAddress && key.obsDomainID == domain.obsDomainID && (allTemplates || key.templateID == templateID) {
+			delete(cp.templateUsageMap, key)
+		}
+	}
+}
+
+// pruneTemplates removes the templates that have not been used by their
+// session since the idle timeout before now, and returns their number.
+func (cp *CollectingProcess) pruneTemplates(now time.Time) int {
+	cp.mutex.Lock()
+	changes := make([]TemplateChange, 0)
+	for key, usage := range cp.templateUsageMap {
+		if now.Sub(usage.lastUsed) < cp.templateIdleTimeout {
+			continue
+		}
+		klog.V(2).Infof("Template with id %d, and obsDomainID %d is unused by session %s since %v and is pruned.", key.templateID, key.obsDomainID, key.sessionAddress, usage.lastUsed)
+		delete(cp.templateUsageMap, key)
+		domain := templateDomain{key.sessionAddress, key.obsDomainID}
+		delete(cp.templatesMap[domain], key.templateID)
+		if len(cp.templatesMap[domain]) == 0 {
+			delete(cp.templatesMap, domain)
+		}
+		changes = append(changes, TemplateChange{Type: TemplateExpired, ExporterAddress: key.sessionAddress, ObsDomainID: key.obsDomainID, TemplateID: key.templateID})
+	}
+	cp.mutex.Unlock()
+	atomic.AddUint64(&cp.stats.prunedTemplates, uint64(len(changes)))
+	for _, change := range changes {
+		cp.notifyTemplateChange(change)
+	}
+	return len(changes)
+}
+
+func (cp *CollectingProcess) runTemplatePruning(stopCh <-chan struct{}) {
+	interval := cp.templateIdleTimeout / 2
+	if interval < minTemplatePruneInterval {
+		interval = minTemplatePruneInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case now := <-ticker.C:
+			cp.pruneTemplates(now)
+		}
+	}
+}
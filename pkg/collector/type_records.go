@@ -0,0 +1,92 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// registerTypeRecords registers the elements described by Information Element
+// Type Records (RFC5610) in the data set into the session registry, so that
+// later templates from the same session can use them. Data records that are
+// not type records are ignored.
+func (cp *CollectingProcess) registerTypeRecords(dataSet entities.Set, sessionRegistry *registry.SessionRegistry) {
+	for _, record := range dataSet.GetRecords() {
+		element, semantics, ok := getTypeRecordElement(record)
+		if !ok {
+			continue
+		}
+		if element.EnterpriseId == registry.IANAEnterpriseID {
+			// IANA elements are defined by the global registry.
+			continue
+		}
+		klog.V(2).Infof("Registering Information Element %s (elementID %d, enterpriseID %d) from type record", element.Name, element.ElementId, element.EnterpriseId)
+		cp.registerSessionElement(sessionRegistry, element, semantics)
+	}
+}
+
+// getTypeRecordElement returns the element described by a type record. It
+// returns false if the record is not a valid type record.
+func getTypeRecordElement(record entities.Record) (*entities.InfoElement, uint8, bool) {
+	elementID, exist := record.GetInfoElementWithValue("informationElementId")
+	if !exist {
+		return nil, 0, false
+	}
+	enterpriseID, exist := record.GetInfoElementWithValue("privateEnterpriseNumber")
+	if !exist {
+		return nil, 0, false
+	}
+	dataType, exist := record.GetInfoElementWithValue("informationElementDataType")
+	if !exist {
+		return nil, 0, false
+	}
+	name, exist := record.GetInfoElementWithValue("informationElementName")
+	if !exist {
+		return nil, 0, false
+	}
+	var semantics uint8
+	if semanticsIE, exist := record.GetInfoElementWithValue("informationElementSemantics"); exist {
+		semantics = semanticsIE.Value.(uint8)
+	}
+	ieDataType := entities.IEDataType(dataType.Value.(uint8))
+	length, exist := entities.InfoElementLength[ieDataType]
+	if !exist || !entities.IsValidDataType(ieDataType) || name.Value.(string) == "" {
+		klog.Warningf("Ignoring invalid type record for element %v with data type %d", name.Value, ieDataType)
+		return nil, 0, false
+	}
+	element := entities.NewInfoElement(name.Value.(string), elementID.Value.(uint16), ieDataType, enterpriseID.Value.(uint32), length)
+	return element, semantics, true
+}
+
+// isTypeRecordTemplate returns true if the data records of the template are
+// type records.
+func isTypeRecordTemplate(template []*entities.InfoElement) bool {
+	hasElementID, hasName := false, false
+	for _, element := range template {
+		if element.EnterpriseId != registry.IANAEnterpriseID {
+			continue
+		}
+		switch element.Name {
+		case "informationElementId":
+			hasElementID = true
+		case "informationElementName":
+			hasName = true
+		}
+	}
+	return hasElementID && hasName
+}
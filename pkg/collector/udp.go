@@ -16,8 +16,9 @@ package collector
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"crypto/x509"
+	"fmt"
 	"net"
 	"sync"
 	"time"
@@ -31,85 +32,82 @@ import (
 func (cp *CollectingProcess) startUDPServer() {
 	var listener net.Listener
 	var err error
-	var conn net.Conn
 	var wg sync.WaitGroup
-	address, err := net.ResolveUDPAddr(cp.protocol, cp.address)
+	address, err := net.ResolveUDPAddr(cp.listenNetwork("udp"), cp.address)
 	if err != nil {
 		klog.Error(err)
 		return
 	}
+	if cp.decodeWorkers > 0 {
+		cp.startDecodePool()
+		defer cp.stopDecodePool()
+	}
 	if cp.isEncrypted { // use DTLS
-		cert, err := tls.X509KeyPair(cp.serverCert, cp.serverKey)
+		config, err := cp.createDTLSServerConfig()
 		if err != nil {
 			klog.Error(err)
 			return
 		}
-		certPool := x509.NewCertPool()
-		certPool.AppendCertsFromPEM(cp.serverCert)
-		config := &dtls.Config{
-			Certificates:         []tls.Certificate{cert},
-			ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
-			ClientCAs:            certPool,
-		}
-		listener, err = dtls.Listen("udp", address, config)
+		listener, err = dtls.Listen(cp.listenNetwork("udp"), address, config)
 		if err != nil {
 			klog.Error(err)
 			return
 		}
 		cp.updateAddress(listener.Addr())
 		klog.Infof("Start dtls collecting process on %s", cp.address)
-		conn, err = listener.Accept()
-		if err != nil {
-			klog.Error(err)
-			return
-		}
-		defer conn.Close()
+		// Every exporter has its own DTLS association, which is closed when
+		// the collecting process is stopped.
+		var connsMutex sync.Mutex
+		conns := make(map[net.Conn]bool)
+		stopped := make(chan struct{})
+		defer func() {
+			close(stopped)
+			listener.Close()
+			connsMutex.Lock()
+			defer connsMutex.Unlock()
+			for conn := range conns {
+				conn.Close()
+			}
+		}()
 		go func() {
 			for {
-				buff := make([]byte, cp.maxBufferSize)
-				size, err := conn.Read(buff)
+				// The handshake is done by Accept, which fails if it fails.
+				conn, err := listener.Accept()
 				if err != nil {
-					if size == 0 { // received stop collector message
+					select {
+					case <-stopped:
 						return
+					default:
+						klog.Errorf("Error in dtls collecting process: %v", err)
+						continue
 					}
-					klog.Errorf("Error in collecting process: %v", err)
-					return
 				}
-				address, err = net.ResolveUDPAddr(conn.LocalAddr().Network(), conn.LocalAddr().String())
-				if err != nil {
-					klog.Errorf("Error in dtls collecting process: %v", err)
-					return
+				if !cp.allowConnection(conn) {
+					continue
 				}
-				klog.V(2).Infof("Receiving %d bytes from %s", size, address.String())
-				cp.handleUDPClient(address, &wg)
-				cp.clients[address.String()].packetChan <- bytes.NewBuffer(buff[0:size])
+				connsMutex.Lock()
+				conns[conn] = true
+				connsMutex.Unlock()
+				go func() {
+					cp.handleDTLSConn(conn, &wg)
+					connsMutex.Lock()
+					delete(conns, conn)
+					connsMutex.Unlock()
+				}()
 			}
 		}()
 	} else { // use udp
-		conn, err := net.ListenUDP("udp", address)
+		conns, err := cp.listenUDP(address)
 		if err != nil {
 			klog.Error(err)
 			return
 		}
-		cp.updateAddress(conn.LocalAddr())
-		klog.Infof("Start UDP collecting process on %s", cp.address)
-		defer conn.Close()
-		go func() {
-			for {
-				buff := make([]byte, cp.maxBufferSize)
-				size, address, err := conn.ReadFromUDP(buff)
-				if err != nil {
-					if size == 0 { // received stop collector message
-						return
-					}
-					klog.Errorf("Error in udp collecting process: %v", err)
-					return
-				}
-				klog.V(2).Infof("Receiving %d bytes from %s", size, address.String())
-				cp.handleUDPClient(address, &wg)
-				cp.clients[address.String()].packetChan <- bytes.NewBuffer(buff[0:size])
-			}
-		}()
+		cp.updateAddress(conns[0].LocalAddr())
+		klog.Infof("Start UDP collecting process on %s with %d listeners", cp.address, len(conns))
+		for _, conn := range conns {
+			defer conn.Close()
+			go cp.readUDPDatagrams(conn, &wg)
+		}
 	}
 	<-cp.stopChan
 	// stop all the workers before closing collector
@@ -117,10 +115,119 @@ func (cp *CollectingProcess) startUDPServer() {
 	wg.Wait()
 }
 
-func (cp *CollectingProcess) handleUDPClient(address net.Addr, wg *sync.WaitGroup) {
-	if _, exist := cp.clients[address.String()]; !exist {
-		client := cp.createClient()
-		cp.addClient(address.String(), client)
+// listenUDP returns the sockets of the UDP collecting process: a single socket,
+// or udpListeners sockets bound to the same address with SO_REUSEPORT.
+func (cp *CollectingProcess) listenUDP(address *net.UDPAddr) ([]*net.UDPConn, error) {
+	numListeners := cp.udpListeners
+	if numListeners < 1 {
+		numListeners = 1
+	}
+	config := cp.listenConfig(numListeners > 1)
+	conns := make([]*net.UDPConn, 0, numListeners)
+	listenAddress := address.String()
+	for i := 0; i < numListeners; i++ {
+		conn, err := config.ListenPacket(context.Background(), cp.listenNetwork("udp"), listenAddress)
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return nil, err
+		}
+		// If the port is 0, the other sockets are bound to the port picked
+		// for the first one.
+		listenAddress = conn.LocalAddr().String()
+		conns = append(conns, conn.(*net.UDPConn))
+	}
+	return conns, nil
+}
+
+// readUDPDatagrams reads the datagrams received on the socket, which are
+// processed by the client of every exporter, until the socket is closed.
+func (cp *CollectingProcess) readUDPDatagrams(conn *net.UDPConn, wg *sync.WaitGroup) {
+	for {
+		buff := make([]byte, cp.maxBufferSize)
+		size, address, err := conn.ReadFromUDP(buff)
+		if err != nil {
+			if size == 0 { // received stop collector message
+				return
+			}
+			klog.Errorf("Error in udp collecting process: %v", err)
+			return
+		}
+		if !cp.allowDatagram(address) {
+			continue
+		}
+		klog.V(2).Infof("Receiving %d bytes from %s", size, address.String())
+		cp.handleUDPClient(address, wg).packetChan <- bytes.NewBuffer(buff[0:size])
+	}
+}
+
+// handleDTLSConn reads the messages of the DTLS association of an exporter,
+// which are processed like the datagrams of a UDP client.
+func (cp *CollectingProcess) handleDTLSConn(conn net.Conn, wg *sync.WaitGroup) {
+	defer conn.Close()
+	address := conn.RemoteAddr()
+	for {
+		buff := make([]byte, cp.maxBufferSize)
+		size, err := conn.Read(buff)
+		if err != nil {
+			if size == 0 { // received stop collector message
+				return
+			}
+			klog.Errorf("Error in dtls collecting process: %v", err)
+			return
+		}
+		klog.V(2).Infof("Receiving %d bytes from %s", size, address.String())
+		cp.handleUDPClient(address, wg).packetChan <- bytes.NewBuffer(buff[0:size])
+	}
+}
+
+// createDTLSServerConfig returns the DTLS configuration of the collecting
+// process. Like with TLS, exporters must present a certificate signed by
+// caCert if it is given.
+func (cp *CollectingProcess) createDTLSServerConfig() (*dtls.Config, error) {
+	// The DTLS listener uses the same configuration for all the handshakes,
+	// so the certificate from the certificate getter is only requested once.
+	certs, err := cp.getServerCertificates()
+	if err != nil {
+		return nil, err
+	}
+	if cp.caCert == nil {
+		return &dtls.Config{
+			Certificates:         certs,
+			ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+		}, nil
+	}
+	roots := x509.NewCertPool()
+	ok := roots.AppendCertsFromPEM(cp.caCert)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse root certificate")
+	}
+	config := &dtls.Config{
+		Certificates:         certs,
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+		ClientAuth:           dtls.RequireAndVerifyClientCert,
+		ClientCAs:            roots,
+	}
+	if cp.allowedClientNames != nil {
+		config.VerifyPeerCertificate = verifyAllowedClientNames(cp.allowedClientNames)
+	}
+	return config, nil
+}
+
+// handleUDPClient returns the client of the exporter, which is created with
+// the goroutine processing its packets if it does not exist. Clients are
+// created atomically, as several UDP listeners may receive datagrams from the
+// same exporter.
+func (cp *CollectingProcess) handleUDPClient(address net.Addr, wg *sync.WaitGroup) *clientHandler {
+	cp.mutex.Lock()
+	client, exist := cp.clients[address.String()]
+	if !exist {
+		client = cp.createClient(cp.protocol)
+		cp.clients[address.String()] = client
+	}
+	cp.mutex.Unlock()
+	if !exist {
 		wg.Add(1)
 		defer wg.Done()
 		go func() {
@@ -135,18 +242,28 @@ func (cp *CollectingProcess) handleUDPClient(address net.Addr, wg *sync.WaitGrou
 					cp.deleteClient(address.String())
 					return
 				case packet := <-client.packetChan:
+					if pool := cp.getDecodePool(); pool != nil {
+						pool.queuePacket(cp, packet, address.String())
+						ticker.Stop()
+						ticker = time.NewTicker(time.Duration(entities.TemplateRefreshTimeOut) * time.Second)
+						continue
+					}
 					// get the message here
+					cp.publishRawMessage(packet.Bytes(), address.String(), false)
 					message, err := cp.decodePacket(packet, address.String())
 					if err != nil {
 						klog.Error(err)
 						return
 					}
-					klog.V(4).Infof("Processed message from exporter %v, number of records: %v, observation domain ID: %v",
-						message.GetExportAddress(), message.GetSet().GetNumberOfRecords(), message.GetObsDomainID())
+					if message != nil {
+						klog.V(4).Infof("Processed message from exporter %v, number of records: %v, observation domain ID: %v",
+							message.GetExportAddress(), message.GetSet().GetNumberOfRecords(), message.GetObsDomainID())
+					}
 					ticker.Stop()
 					ticker = time.NewTicker(time.Duration(entities.TemplateRefreshTimeOut) * time.Second)
 				}
 			}
 		}()
 	}
+	return client
 }
@@ -0,0 +1,134 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"k8s.io/klog/v2"
+)
+
+// unixConn is a connection accepted on a Unix domain socket. The peers of
+// such connections have no address, so every connection is identified by the
+// path of the socket and a connection number, as "path:number".
+type unixConn struct {
+	net.Conn
+	remoteAddr *net.UnixAddr
+}
+
+func (c *unixConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// getUnixDatagramAddress returns the address identifying the exporter of a
+// datagram received on the Unix domain socket at the given path, as
+// "path:sender". Exporters sending from unnamed sockets have the same
+// address, "path:", and therefore share their transport session.
+func getUnixDatagramAddress(path string, sender *net.UnixAddr) *net.UnixAddr {
+	name := ""
+	if sender != nil {
+		name = sender.Name
+	}
+	return &net.UnixAddr{Name: fmt.Sprintf("%s:%s", path, name), Net: "unixgram"}
+}
+
+// startUnixServer listens on the Unix domain socket whose path is the address
+// of the collecting process. Connections of "unix" sockets are processed like
+// TCP connections, and datagrams of "unixgram" sockets like UDP datagrams.
+func (cp *CollectingProcess) startUnixServer() {
+	if cp.protocol == "unixgram" {
+		cp.startUnixgramServer()
+		return
+	}
+	listener, err := net.Listen("unix", cp.address)
+	if err != nil {
+		klog.Errorf("Cannot start collecting process on %s: %v", cp.address, err)
+		return
+	}
+	cp.updateAddress(listener.Addr())
+	klog.Infof("Start Unix collecting process on %s", cp.address)
+
+	go func() {
+		var connections uint64
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				klog.Errorf("Cannot start collecting process on %s: %v", cp.address, err)
+				return
+			}
+			id := atomic.AddUint64(&connections, 1)
+			conn = &unixConn{conn, &net.UnixAddr{Name: fmt.Sprintf("%s:%d", cp.address, id), Net: "unix"}}
+			if !cp.acquireConnection(conn) {
+				continue
+			}
+			go func() {
+				defer cp.releaseConnection()
+				cp.handleTCPClient(conn)
+			}()
+		}
+	}()
+	<-cp.stopChan
+	// The socket file is removed when the listener is closed, so that the
+	// collecting process can be started again on the same path.
+	listener.Close()
+	// close all connections
+	cp.closeAllClients()
+}
+
+func (cp *CollectingProcess) startUnixgramServer() {
+	var wg sync.WaitGroup
+	if cp.decodeWorkers > 0 {
+		cp.startDecodePool()
+		defer cp.stopDecodePool()
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: cp.address, Net: "unixgram"})
+	if err != nil {
+		klog.Errorf("Cannot start collecting process on %s: %v", cp.address, err)
+		return
+	}
+	cp.updateAddress(conn.LocalAddr())
+	klog.Infof("Start Unix datagram collecting process on %s", cp.address)
+	defer func() {
+		conn.Close()
+		if err := os.Remove(cp.address); err != nil {
+			klog.Errorf("Cannot remove socket %s: %v", cp.address, err)
+		}
+	}()
+	go func() {
+		for {
+			buff := make([]byte, cp.maxBufferSize)
+			size, sender, err := conn.ReadFromUnix(buff)
+			if err != nil {
+				if size == 0 { // received stop collector message
+					return
+				}
+				klog.Errorf("Error in unixgram collecting process: %v", err)
+				return
+			}
+			address := getUnixDatagramAddress(cp.address, sender)
+			klog.V(2).Infof("Receiving %d bytes from %s", size, address)
+			cp.handleUDPClient(address, &wg).packetChan <- bytes.NewBuffer(buff[0:size])
+		}
+	}()
+	<-cp.stopChan
+	// stop all the workers before closing collector
+	cp.closeAllClients()
+	wg.Wait()
+}
@@ -0,0 +1,132 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entities
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// ElementCodec encodes and decodes the values of an enterprise-specific
+// element whose wire format is not one of the data types of RFC7011, e.g.
+// packed bitfields or a protobuf message carried in an octetArray. The codec
+// replaces the encoding of the data type of the element everywhere records
+// are encoded or decoded.
+type ElementCodec struct {
+	// Encode returns the bytes of the value, without the length prefix of
+	// variable-length elements. The bytes of fixed-length elements must have
+	// the length of the element.
+	Encode func(element *InfoElement, value interface{}) ([]byte, error)
+	// Decode returns the value of the bytes, which are not retained by the
+	// caller.
+	Decode func(element *InfoElement, data []byte) (interface{}, error)
+}
+
+type elementCodecKey struct {
+	enterpriseID uint32
+	elementID    uint16
+}
+
+var (
+	elementCodecs      = make(map[elementCodecKey]ElementCodec)
+	elementCodecsMutex sync.RWMutex
+)
+
+// RegisterElementCodec registers the codec of the element with the given
+// enterprise and element IDs, replacing any codec registered before. The
+// codecs of IANA elements cannot be replaced. Codecs should be registered
+// before records with the element are encoded or decoded.
+func RegisterElementCodec(enterpriseID uint32, elementID uint16, codec ElementCodec) error {
+	if enterpriseID == 0 {
+		return fmt.Errorf("cannot register codec for IANA element %d", elementID)
+	}
+	if codec.Encode == nil || codec.Decode == nil {
+		return fmt.Errorf("codec of element %d with enterprise ID %d must encode and decode values", elementID, enterpriseID)
+	}
+	elementCodecsMutex.Lock()
+	defer elementCodecsMutex.Unlock()
+	elementCodecs[elementCodecKey{enterpriseID, elementID}] = codec
+	return nil
+}
+
+// UnregisterElementCodec removes the codec of the element, whose values are
+// then encoded according to its data type again.
+func UnregisterElementCodec(enterpriseID uint32, elementID uint16) {
+	elementCodecsMutex.Lock()
+	defer elementCodecsMutex.Unlock()
+	delete(elementCodecs, elementCodecKey{enterpriseID, elementID})
+}
+
+func getElementCodec(element *InfoElement) (ElementCodec, bool) {
+	if element.EnterpriseId == 0 {
+		return ElementCodec{}, false
+	}
+	elementCodecsMutex.RLock()
+	defer elementCodecsMutex.RUnlock()
+	codec, exist := elementCodecs[elementCodecKey{element.EnterpriseId, element.ElementId}]
+	return codec, exist
+}
+
+// EncodeElementValue encodes the value of the element to the buff, with the
+// codec registered for the element if any, or else according to its data type
+// like EncodeToIEDataTypeWithPolicy. Values encoded by a codec are returned
+// unchanged, and are never truncated.
+func EncodeElementValue(element *InfoElement, val interface{}, buff *bytes.Buffer, policy VariableLengthOverflowPolicy) (interface{}, error) {
+	codec, exist := getElementCodec(element)
+	if !exist {
+		return EncodeToIEDataTypeWithPolicy(element.DataType, val, buff, policy)
+	}
+	data, err := codec.Encode(element, val)
+	if err != nil {
+		return nil, fmt.Errorf("error when encoding value of element %s with its codec: %v", element.Name, err)
+	}
+	if element.Len != VariableLength {
+		if len(data) != int(element.Len) {
+			return nil, fmt.Errorf("codec of element %s encoded %d bytes instead of %d", element.Name, len(data), element.Len)
+		}
+		buff.Write(data)
+		return val, nil
+	}
+	if len(data) > MaxVariableLengthValueLen {
+		return nil, &VariableLengthOverflowError{Length: len(data), MaxLength: MaxVariableLengthValueLen}
+	}
+	if err := encodeVariableLength(buff, data); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+// DecodeElementValue decodes the value of the element, given as a
+// *bytes.Buffer without the length prefix of variable-length elements, with
+// the codec registered for the element if any, or else according to its data
+// type like DecodeToIEDataType.
+func DecodeElementValue(element *InfoElement, val interface{}) (interface{}, error) {
+	codec, exist := getElementCodec(element)
+	if !exist {
+		return DecodeToIEDataType(element.DataType, val)
+	}
+	value, ok := val.(*bytes.Buffer)
+	if !ok {
+		return nil, fmt.Errorf("error when converting value to bytes.Buffer for decoding")
+	}
+	data := make([]byte, value.Len())
+	copy(data, value.Bytes())
+	decoded, err := codec.Decode(element, data)
+	if err != nil {
+		return nil, fmt.Errorf("error when decoding value of element %s with its codec: %v", element.Name, err)
+	}
+	return decoded, nil
+}
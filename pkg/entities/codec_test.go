@@ -0,0 +1,113 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entities
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testFlags is a value packed in a bitfield of 2 octets.
+type testFlags struct {
+	class    uint8
+	priority uint8
+	urgent   bool
+}
+
+var testFlagsCodec = ElementCodec{
+	Encode: func(element *InfoElement, value interface{}) ([]byte, error) {
+		flags, ok := value.(testFlags)
+		if !ok {
+			return nil, fmt.Errorf("value %v is not of type testFlags", value)
+		}
+		packed := uint16(flags.class)<<8 | uint16(flags.priority&0x7f)<<1
+		if flags.urgent {
+			packed |= 1
+		}
+		return []byte{byte(packed >> 8), byte(packed)}, nil
+	},
+	Decode: func(element *InfoElement, data []byte) (interface{}, error) {
+		if len(data) != 2 {
+			return nil, fmt.Errorf("bitfield has %d octets", len(data))
+		}
+		return testFlags{data[0], data[1] >> 1, data[1]&1 == 1}, nil
+	},
+}
+
+// testLabelsCodec encodes a list of labels as a comma-separated octetArray.
+var testLabelsCodec = ElementCodec{
+	Encode: func(element *InfoElement, value interface{}) ([]byte, error) {
+		labels, ok := value.([]string)
+		if !ok {
+			return nil, fmt.Errorf("value %v is not of type []string", value)
+		}
+		return []byte(strings.Join(labels, ",")), nil
+	},
+	Decode: func(element *InfoElement, data []byte) (interface{}, error) {
+		return strings.Split(string(data), ","), nil
+	},
+}
+
+func TestElementCodec(t *testing.T) {
+	flagsElement := NewInfoElement("testFlags", 1, Unsigned16, 12345, 2)
+	labelsElement := NewInfoElement("testLabels", 2, OctetArray, 12345, VariableLength)
+	assert.Error(t, RegisterElementCodec(0, 1, testFlagsCodec))
+	assert.Error(t, RegisterElementCodec(12345, 1, ElementCodec{}))
+	assert.NoError(t, RegisterElementCodec(12345, 1, testFlagsCodec))
+	assert.NoError(t, RegisterElementCodec(12345, 2, testLabelsCodec))
+	defer UnregisterElementCodec(12345, 1)
+	defer UnregisterElementCodec(12345, 2)
+
+	flags := testFlags{class: 3, priority: 5, urgent: true}
+	labels := []string{"gold", "eu-west"}
+	record := NewDataRecord(uniqueTemplateID)
+	_, err := record.AddInfoElement(NewInfoElementWithValue(flagsElement, flags), false)
+	assert.NoError(t, err)
+	_, err = record.AddInfoElement(NewInfoElementWithValue(labelsElement, labels), false)
+	assert.NoError(t, err)
+	assert.Equal(t, append([]byte{3, 11, 12}, "gold,eu-west"...), record.GetBuffer().Bytes())
+	ie, _ := record.GetInfoElementWithValue("testFlags")
+	assert.Equal(t, flags, ie.Value)
+	// Values rejected by the codec are not encoded.
+	_, err = record.AddInfoElement(NewInfoElementWithValue(flagsElement, uint16(1)), false)
+	assert.Error(t, err)
+
+	decoded := NewDataRecord(uniqueTemplateID)
+	_, err = decoded.AddInfoElement(NewInfoElementWithValue(flagsElement, bytes.NewBuffer([]byte{3, 11})), true)
+	assert.NoError(t, err)
+	_, err = decoded.AddInfoElement(NewInfoElementWithValue(labelsElement, bytes.NewBufferString("gold,eu-west")), true)
+	assert.NoError(t, err)
+	ie, _ = decoded.GetInfoElementWithValue("testFlags")
+	assert.Equal(t, flags, ie.Value)
+	ie, _ = decoded.GetInfoElementWithValue("testLabels")
+	assert.Equal(t, labels, ie.Value)
+
+	// Fixed-length values must have the length of the element.
+	shortElement := NewInfoElement("testShortFlags", 1, Unsigned8, 12345, 1)
+	_, err = EncodeElementValue(shortElement, flags, new(bytes.Buffer), OverflowPolicyError)
+	assert.Error(t, err)
+
+	// Values are encoded according to the data type once the codec is
+	// unregistered.
+	UnregisterElementCodec(12345, 1)
+	buff := new(bytes.Buffer)
+	_, err = EncodeElementValue(flagsElement, uint16(0x030b), buff, OverflowPolicyError)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{3, 11}, buff.Bytes())
+}
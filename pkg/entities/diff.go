@@ -0,0 +1,113 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entities
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"reflect"
+)
+
+type DiffType uint8
+
+const (
+	// DiffMissingInFirst means the element is only present in the second record.
+	DiffMissingInFirst DiffType = iota
+	// DiffMissingInSecond means the element is only present in the first record.
+	DiffMissingInSecond
+	// DiffTypeMismatch means the element has different data types in the records.
+	DiffTypeMismatch
+	// DiffValueMismatch means the element has different values in the records.
+	DiffValueMismatch
+)
+
+func (d DiffType) String() string {
+	switch d {
+	case DiffMissingInFirst:
+		return "MissingInFirst"
+	case DiffMissingInSecond:
+		return "MissingInSecond"
+	case DiffTypeMismatch:
+		return "TypeMismatch"
+	case DiffValueMismatch:
+		return "ValueMismatch"
+	default:
+		return "Unknown"
+	}
+}
+
+// RecordDiff is a difference between two records for a single Information
+// Element. First or Second is nil if the element is missing in that record.
+type RecordDiff struct {
+	Name   string
+	Type   DiffType
+	First  *InfoElementWithValue
+	Second *InfoElementWithValue
+}
+
+func (d RecordDiff) String() string {
+	switch d.Type {
+	case DiffMissingInFirst, DiffMissingInSecond:
+		return fmt.Sprintf("%s: %s", d.Name, d.Type)
+	case DiffTypeMismatch:
+		return fmt.Sprintf("%s: %s (%d != %d)", d.Name, d.Type, d.First.Element.DataType, d.Second.Element.DataType)
+	default:
+		return fmt.Sprintf("%s: %s (%v != %v)", d.Name, d.Type, d.First.Value, d.Second.Value)
+	}
+}
+
+// DiffRecords compares the Information Elements of two records by name and
+// returns their differences. Differences are ordered as the elements of the
+// first record, followed by the elements only present in the second record.
+// An empty result means the records carry the same elements and values, though
+// possibly in a different order.
+func DiffRecords(a, b Record) []RecordDiff {
+	diffs := make([]RecordDiff, 0)
+	for _, first := range a.GetOrderedElementList() {
+		name := first.Element.Name
+		second, exist := b.GetInfoElementWithValue(name)
+		if !exist {
+			diffs = append(diffs, RecordDiff{name, DiffMissingInSecond, first, nil})
+			continue
+		}
+		if first.Element.DataType != second.Element.DataType {
+			diffs = append(diffs, RecordDiff{name, DiffTypeMismatch, first, second})
+			continue
+		}
+		if !isEqualValue(first.Value, second.Value) {
+			diffs = append(diffs, RecordDiff{name, DiffValueMismatch, first, second})
+		}
+	}
+	for _, second := range b.GetOrderedElementList() {
+		if _, exist := a.GetInfoElementWithValue(second.Element.Name); !exist {
+			diffs = append(diffs, RecordDiff{second.Element.Name, DiffMissingInFirst, nil, second})
+		}
+	}
+	return diffs
+}
+
+func isEqualValue(v1, v2 interface{}) bool {
+	switch val1 := v1.(type) {
+	case net.IP:
+		val2, ok := v2.(net.IP)
+		return ok && val1.Equal(val2)
+	case []byte:
+		val2, ok := v2.([]byte)
+		return ok && bytes.Equal(val1, val2)
+	default:
+		return reflect.DeepEqual(v1, v2)
+	}
+}
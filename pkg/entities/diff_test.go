@@ -0,0 +1,77 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entities
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createDataRecordForDiff(t *testing.T, elements []*InfoElementWithValue) Record {
+	record := NewDataRecord(uniqueTemplateID)
+	record.PrepareRecord()
+	for _, element := range elements {
+		_, err := record.AddInfoElement(element, false)
+		assert.NoError(t, err)
+	}
+	return record
+}
+
+func TestDiffRecords(t *testing.T) {
+	srcIP := NewInfoElement("sourceIPv4Address", 8, Ipv4Address, 0, 4)
+	dstIP := NewInfoElement("destinationIPv4Address", 12, Ipv4Address, 0, 4)
+	srcPort := NewInfoElement("sourceTransportPort", 7, Unsigned16, 0, 2)
+	srcPortAsUint32 := NewInfoElement("sourceTransportPort", 7, Unsigned32, 0, 4)
+	packets := NewInfoElement("packetDeltaCount", 2, Unsigned64, 0, 8)
+
+	a := createDataRecordForDiff(t, []*InfoElementWithValue{
+		NewInfoElementWithValue(srcIP, net.ParseIP("10.0.0.1")),
+		NewInfoElementWithValue(srcPort, uint16(1234)),
+		NewInfoElementWithValue(packets, uint64(100)),
+	})
+	b := createDataRecordForDiff(t, []*InfoElementWithValue{
+		NewInfoElementWithValue(srcIP, net.ParseIP("10.0.0.1")),
+		NewInfoElementWithValue(srcPort, uint16(1234)),
+		NewInfoElementWithValue(packets, uint64(100)),
+	})
+	assert.Empty(t, DiffRecords(a, b))
+
+	c := createDataRecordForDiff(t, []*InfoElementWithValue{
+		NewInfoElementWithValue(srcIP, net.ParseIP("10.0.0.1")),
+		NewInfoElementWithValue(srcPortAsUint32, uint32(1234)),
+		NewInfoElementWithValue(packets, uint64(200)),
+		NewInfoElementWithValue(dstIP, net.ParseIP("10.0.0.2")),
+	})
+	diffs := DiffRecords(a, c)
+	assert.Len(t, diffs, 3)
+	assert.Equal(t, "sourceTransportPort", diffs[0].Name)
+	assert.Equal(t, DiffTypeMismatch, diffs[0].Type)
+	assert.Equal(t, "packetDeltaCount", diffs[1].Name)
+	assert.Equal(t, DiffValueMismatch, diffs[1].Type)
+	assert.Equal(t, uint64(100), diffs[1].First.Value)
+	assert.Equal(t, uint64(200), diffs[1].Second.Value)
+	assert.Equal(t, "destinationIPv4Address", diffs[2].Name)
+	assert.Equal(t, DiffMissingInFirst, diffs[2].Type)
+	assert.Nil(t, diffs[2].First)
+
+	diffs = DiffRecords(c, a)
+	assert.Len(t, diffs, 3)
+	assert.Equal(t, "destinationIPv4Address", diffs[2].Name)
+	assert.Equal(t, DiffMissingInSecond, diffs[2].Type)
+	assert.Nil(t, diffs[2].Second)
+	assert.Equal(t, "packetDeltaCount: ValueMismatch (200 != 100)", diffs[1].String())
+}
@@ -0,0 +1,247 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entities
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const dumpBytesPerLine = 16
+
+// dumpField is a field of a header or record with its encoded bytes.
+type dumpField struct {
+	data        []byte
+	description string
+}
+
+// Dump writes an annotated hexdump of the message to w. Every line starts with
+// the offset in the message and the bytes of a header or field, followed by a
+// description: the values of the message and set headers, the fields of the
+// template records, and the elements of the data records with their decoded
+// values.
+//
+// Decoded messages do not keep the received bytes, so their records are
+// encoded again from the decoded values. The bytes are the same as the ones
+// received, unless the exporter used an unusual encoding, such as a 3-byte
+// length prefix for a short variable-length value.
+func (m *Message) Dump(w io.Writer) error {
+	var records [][]dumpField
+	setLen := SetHeaderLen
+	var setID uint16
+	if m.set != nil {
+		for _, record := range m.set.GetRecords() {
+			fields := dumpRecord(m.set.GetSetType(), record)
+			for _, field := range fields {
+				setLen += len(field.data)
+			}
+			records = append(records, fields)
+		}
+		setID = dumpSetID(m.set)
+	}
+
+	header := make([]byte, MsgHeaderLength)
+	binary.BigEndian.PutUint16(header[0:2], m.version)
+	binary.BigEndian.PutUint16(header[2:4], m.length)
+	binary.BigEndian.PutUint32(header[4:8], m.exportTime)
+	binary.BigEndian.PutUint32(header[8:12], m.seqNumber)
+	binary.BigEndian.PutUint32(header[12:16], m.obsDomainID)
+	d := &dumper{w: w}
+	d.section("Message header")
+	d.field(header, fmt.Sprintf("version=%d length=%d exportTime=%d sequenceNumber=%d observationDomainID=%d",
+		m.version, m.length, m.exportTime, m.seqNumber, m.obsDomainID))
+	if m.set == nil {
+		return d.err
+	}
+
+	setHeader := make([]byte, SetHeaderLen)
+	binary.BigEndian.PutUint16(setHeader[0:2], setID)
+	binary.BigEndian.PutUint16(setHeader[2:4], uint16(setLen))
+	d.section(fmt.Sprintf("%s set", dumpSetType(m.set.GetSetType())))
+	d.field(setHeader, fmt.Sprintf("setID=%d length=%d records=%d", setID, setLen, len(records)))
+	for i, fields := range records {
+		d.section(fmt.Sprintf("Record %d", i+1))
+		for _, field := range fields {
+			d.field(field.data, field.description)
+		}
+	}
+	return d.err
+}
+
+type dumper struct {
+	w      io.Writer
+	offset int
+	err    error
+}
+
+func (d *dumper) section(title string) {
+	d.printf("%s (offset %d)\n", title, d.offset)
+}
+
+// field writes the bytes of the field, wrapping them every 16 bytes, with the
+// description on the first line.
+func (d *dumper) field(data []byte, description string) {
+	for start := 0; start == 0 || start < len(data); start += dumpBytesPerLine {
+		end := start + dumpBytesPerLine
+		if end > len(data) {
+			end = len(data)
+		}
+		hexBytes := make([]string, 0, dumpBytesPerLine)
+		for _, b := range data[start:end] {
+			hexBytes = append(hexBytes, fmt.Sprintf("%02x", b))
+		}
+		if start == 0 {
+			d.printf("  %04x  %-47s  %s\n", d.offset+start, strings.Join(hexBytes, " "), description)
+		} else {
+			d.printf("  %04x  %s\n", d.offset+start, strings.Join(hexBytes, " "))
+		}
+	}
+	d.offset += len(data)
+}
+
+func (d *dumper) printf(format string, a ...interface{}) {
+	if d.err == nil {
+		_, d.err = fmt.Fprintf(d.w, format, a...)
+	}
+}
+
+func dumpSetType(setType ContentType) string {
+	switch setType {
+	case Template:
+		return "Template"
+	case OptionsTemplate:
+		return "Options template"
+	case Data:
+		return "Data"
+	}
+	return "Undefined"
+}
+
+func dumpSetID(set Set) uint16 {
+	switch set.GetSetType() {
+	case Template:
+		return TemplateSetID
+	case OptionsTemplate:
+		return OptionsTemplateSetID
+	}
+	if buffer := set.GetBuffer().Bytes(); len(buffer) >= SetHeaderLen {
+		return binary.BigEndian.Uint16(buffer[0:2])
+	}
+	if records := set.GetRecords(); len(records) > 0 {
+		return records[0].GetTemplateID()
+	}
+	return 0
+}
+
+func dumpRecord(setType ContentType, record Record) []dumpField {
+	elements := record.GetOrderedElementList()
+	fields := make([]dumpField, 0, len(elements)+1)
+	if setType == Template || setType == OptionsTemplate {
+		header := make([]byte, 4, 6)
+		binary.BigEndian.PutUint16(header[0:2], record.GetTemplateID())
+		binary.BigEndian.PutUint16(header[2:4], uint16(len(elements)))
+		description := fmt.Sprintf("templateID=%d fieldCount=%d", record.GetTemplateID(), len(elements))
+		if setType == OptionsTemplate {
+			header = header[:6]
+			binary.BigEndian.PutUint16(header[4:6], record.GetScopeFieldCount())
+			description += fmt.Sprintf(" scopeFieldCount=%d", record.GetScopeFieldCount())
+		}
+		fields = append(fields, dumpField{header, description})
+		for _, element := range elements {
+			fields = append(fields, dumpFieldSpecifier(element.Element))
+		}
+		return fields
+	}
+	// Records which were encoded keep their bytes. Records which were decoded,
+	// or to which elements were added after decoding, are encoded again.
+	if encoded, ok := splitEncodedRecord(record); ok {
+		return encoded
+	}
+	for _, element := range elements {
+		buff := new(bytes.Buffer)
+		description := fmt.Sprintf("%s = %s", element.Element.Name, dumpValue(element.Value))
+		if _, err := EncodeElementValue(element.Element, element.Value, buff, OverflowPolicyError); err != nil {
+			description = fmt.Sprintf("%s (cannot be encoded: %v)", description, err)
+		}
+		fields = append(fields, dumpField{buff.Bytes(), description})
+	}
+	return fields
+}
+
+func dumpFieldSpecifier(element *InfoElement) dumpField {
+	data := make([]byte, 4, 8)
+	elementID := element.ElementId
+	if element.EnterpriseId != 0 {
+		elementID |= 0x8000
+	}
+	binary.BigEndian.PutUint16(data[0:2], elementID)
+	binary.BigEndian.PutUint16(data[2:4], element.Len)
+	description := fmt.Sprintf("%s: elementID=%d length=%d", element.Name, element.ElementId, element.Len)
+	if element.EnterpriseId != 0 {
+		data = data[:8]
+		binary.BigEndian.PutUint32(data[4:8], element.EnterpriseId)
+		description += fmt.Sprintf(" enterpriseID=%d", element.EnterpriseId)
+	}
+	return dumpField{data, description}
+}
+
+// splitEncodedRecord splits the buffer of the record into its fields, and
+// returns false if the buffer does not contain exactly the fields.
+func splitEncodedRecord(record Record) ([]dumpField, bool) {
+	buffer := record.GetBuffer().Bytes()
+	if len(buffer) == 0 {
+		return nil, false
+	}
+	elements := record.GetOrderedElementList()
+	fields := make([]dumpField, 0, len(elements))
+	offset := 0
+	for _, element := range elements {
+		length, prefixLen := int(element.Element.Len), 0
+		if element.Element.Len == VariableLength {
+			if offset >= len(buffer) {
+				return nil, false
+			}
+			length, prefixLen = int(buffer[offset]), 1
+			if length == 255 {
+				if offset+3 > len(buffer) {
+					return nil, false
+				}
+				length, prefixLen = int(binary.BigEndian.Uint16(buffer[offset+1:offset+3])), 3
+			}
+		}
+		end := offset + prefixLen + length
+		if end > len(buffer) {
+			return nil, false
+		}
+		value, err := DecodeElementValue(element.Element, bytes.NewBuffer(buffer[offset+prefixLen:end]))
+		description := fmt.Sprintf("%s = %s", element.Element.Name, dumpValue(value))
+		if err != nil {
+			description = fmt.Sprintf("%s (cannot be decoded: %v)", element.Element.Name, err)
+		}
+		fields = append(fields, dumpField{buffer[offset:end], description})
+		offset = end
+	}
+	return fields, offset == len(buffer)
+}
+
+func dumpValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprintf("%v", value)
+}
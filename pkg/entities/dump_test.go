@@ -0,0 +1,84 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entities
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageDump(t *testing.T) {
+	srcElement := NewInfoElement("sourceIPv4Address", 8, 18, 0, 4)
+	podElement := NewInfoElement("sourcePodName", 101, 13, 56506, 65535)
+	templateSet := NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(Template, TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*InfoElementWithValue{
+		NewInfoElementWithValue(srcElement, nil),
+		NewInfoElementWithValue(podElement, nil),
+	}, testTemplateID))
+	templateMsg := NewMessage(true)
+	templateMsg.SetVersion(10)
+	templateMsg.SetMessageLen(36)
+	templateMsg.SetSequenceNum(1)
+	templateMsg.SetObsDomainID(2)
+	templateMsg.AddSet(templateSet)
+	out := new(bytes.Buffer)
+	assert.NoError(t, templateMsg.Dump(out))
+	assert.Equal(t, `Message header (offset 0)
+  0000  00 0a 00 24 00 00 00 00 00 00 00 01 00 00 00 02  version=10 length=36 exportTime=0 sequenceNumber=1 observationDomainID=2
+Template set (offset 16)
+  0010  00 02 00 14                                      setID=2 length=20 records=1
+Record 1 (offset 20)
+  0014  01 00 00 02                                      templateID=256 fieldCount=2
+  0018  00 08 00 04                                      sourceIPv4Address: elementID=8 length=4
+  001c  80 65 ff ff 00 00 dc ba                          sourcePodName: elementID=101 length=65535 enterpriseID=56506
+`, out.String())
+
+	// Encoded and decoded data records give the same dump.
+	encodingSet := NewSet(false)
+	assert.NoError(t, encodingSet.PrepareSet(Data, testTemplateID))
+	assert.NoError(t, encodingSet.AddRecord([]*InfoElementWithValue{
+		NewInfoElementWithValue(srcElement, net.ParseIP("10.0.0.1")),
+		NewInfoElementWithValue(podElement, "pod1"),
+	}, testTemplateID))
+	decodingSet := NewSet(true)
+	assert.NoError(t, decodingSet.PrepareSet(Data, testTemplateID))
+	assert.NoError(t, decodingSet.AddRecord([]*InfoElementWithValue{
+		NewInfoElementWithValue(srcElement, bytes.NewBuffer([]byte{10, 0, 0, 1})),
+		NewInfoElementWithValue(podElement, bytes.NewBufferString("pod1")),
+	}, testTemplateID))
+	expected := `Message header (offset 0)
+  0000  00 0a 00 1d 00 00 00 00 00 00 00 02 00 00 00 02  version=10 length=29 exportTime=0 sequenceNumber=2 observationDomainID=2
+Data set (offset 16)
+  0010  01 00 00 0d                                      setID=256 length=13 records=1
+Record 1 (offset 20)
+  0014  0a 00 00 01                                      sourceIPv4Address = 10.0.0.1
+  0018  04 70 6f 64 31                                   sourcePodName = "pod1"
+`
+	for _, set := range []Set{encodingSet, decodingSet} {
+		dataMsg := NewMessage(true)
+		dataMsg.SetVersion(10)
+		dataMsg.SetMessageLen(29)
+		dataMsg.SetSequenceNum(2)
+		dataMsg.SetObsDomainID(2)
+		dataMsg.AddSet(set)
+		out.Reset()
+		assert.NoError(t, dataMsg.Dump(out))
+		assert.Equal(t, expected, out.String())
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/vmware/go-ipfix/pkg/util"
 )
@@ -55,6 +57,40 @@ const (
 
 const VariableLength uint16 = 65535
 
+// MaxVariableLengthValueLen is the largest value of a variable-length element
+// that can be carried in a single IPFIX message: the message length is limited
+// to 65535 octets, out of which the message header, the set header and the
+// 3-octet length prefix of the element are consumed.
+const MaxVariableLengthValueLen = MaxTcpSocketMsgSize - MsgHeaderLength - SetHeaderLen - 3
+
+// VariableLengthOverflowPolicy decides what happens when a value of a
+// variable-length element exceeds MaxVariableLengthValueLen while encoding.
+type VariableLengthOverflowPolicy uint8
+
+const (
+	// OverflowPolicyError rejects the value with a VariableLengthOverflowError.
+	OverflowPolicyError VariableLengthOverflowPolicy = iota
+	// OverflowPolicyTruncate truncates the value to MaxVariableLengthValueLen
+	// octets. Strings are truncated at a UTF-8 character boundary.
+	OverflowPolicyTruncate
+)
+
+// VariableLengthOverflowError is returned when the value of a variable-length
+// element is too large to be carried in an IPFIX message.
+type VariableLengthOverflowError struct {
+	// Name of the element; empty when the error is returned by EncodeToIEDataType.
+	Name      string
+	Length    int
+	MaxLength int
+}
+
+func (e *VariableLengthOverflowError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("variable-length value of %d octets exceeds the maximum of %d octets", e.Length, e.MaxLength)
+	}
+	return fmt.Sprintf("variable-length value of element %s (%d octets) exceeds the maximum of %d octets", e.Name, e.Length, e.MaxLength)
+}
+
 var InfoElementLength = map[IEDataType]uint16{
 	OctetArray:           VariableLength,
 	Unsigned8:            1,
@@ -284,13 +320,32 @@ func DecodeToIEDataType(dataType IEDataType, val interface{}) (interface{}, erro
 		return net.IP(value.Bytes()), nil
 	case String:
 		return value.String(), nil
+	case OctetArray:
+		v := make([]byte, value.Len())
+		copy(v, value.Bytes())
+		return v, nil
+	case BasicList, SubTemplateList, SubTemplateMultiList:
+		// The content of structured data types refers to elements and
+		// templates, so it is returned undecoded, to be decoded with
+		// DecodeListValue.
+		v := make([]byte, value.Len())
+		copy(v, value.Bytes())
+		return v, nil
 	default:
 		return nil, fmt.Errorf("API supports only valid information elements with datatypes given in RFC7011")
 	}
 }
 
-// EncodeToIEDataType is to encode data to specific type to the buff
+// EncodeToIEDataType is to encode data to specific type to the buff. Values of
+// variable-length elements that are too large to be exported are rejected with
+// a VariableLengthOverflowError.
 func EncodeToIEDataType(dataType IEDataType, val interface{}, buff *bytes.Buffer) (interface{}, error) {
+	return EncodeToIEDataTypeWithPolicy(dataType, val, buff, OverflowPolicyError)
+}
+
+// EncodeToIEDataTypeWithPolicy is the same as EncodeToIEDataType, but the handling
+// of oversized variable-length values follows the given policy.
+func EncodeToIEDataTypeWithPolicy(dataType IEDataType, val interface{}, buff *bytes.Buffer, policy VariableLengthOverflowPolicy) (interface{}, error) {
 	switch dataType {
 	case Unsigned8:
 		v, ok := val.(uint8)
@@ -430,13 +485,113 @@ func EncodeToIEDataType(dataType IEDataType, val interface{}, buff *bytes.Buffer
 		if !ok {
 			return 0, fmt.Errorf("val argument %v is not of type string for this element", val)
 		}
-		if len(v) < 255 {
-			err := util.Encode(buff, binary.BigEndian, uint8(len(v)), []byte(v))
-			return []byte(v), err
-		} else if len(v) < 65535 {
-			err := util.Encode(buff, binary.BigEndian, byte(255), uint16(len(v)), []byte(v))
-			return []byte(v), err
+		if len(v) > MaxVariableLengthValueLen {
+			if policy != OverflowPolicyTruncate {
+				return nil, &VariableLengthOverflowError{Length: len(v), MaxLength: MaxVariableLengthValueLen}
+			}
+			v = truncateString(v, MaxVariableLengthValueLen)
+		}
+		err := encodeVariableLength(buff, []byte(v))
+		return []byte(v), err
+	case OctetArray:
+		v, ok := val.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("val argument %v is not of type []byte for this element", val)
+		}
+		if len(v) > MaxVariableLengthValueLen {
+			if policy != OverflowPolicyTruncate {
+				return nil, &VariableLengthOverflowError{Length: len(v), MaxLength: MaxVariableLengthValueLen}
+			}
+			v = v[:MaxVariableLengthValueLen]
+		}
+		err := encodeVariableLength(buff, v)
+		return v, err
+	case BasicList, SubTemplateList, SubTemplateMultiList:
+		content := new(bytes.Buffer)
+		if err := encodeListValue(dataType, val, content); err != nil {
+			return nil, err
+		}
+		// Lists cannot be truncated without corrupting their content.
+		if content.Len() > MaxVariableLengthValueLen {
+			return nil, &VariableLengthOverflowError{Length: content.Len(), MaxLength: MaxVariableLengthValueLen}
 		}
+		err := encodeVariableLength(buff, content.Bytes())
+		return val, err
 	}
 	return nil, fmt.Errorf("API supports only valid information elements with datatypes given in RFC7011")
 }
+
+// encodeVariableLength writes the value with the length prefix of variable-length
+// elements (encoding reference: https://tools.ietf.org/html/rfc7011#section-7).
+func encodeVariableLength(buff *bytes.Buffer, v []byte) error {
+	if len(v) < 255 {
+		return util.Encode(buff, binary.BigEndian, uint8(len(v)), v)
+	}
+	return util.Encode(buff, binary.BigEndian, byte(255), uint16(len(v)), v)
+}
+
+// DecodeVariableLength decodes the length prefix of a value of a
+// variable-length element from the buff: one byte for lengths below 255, or
+// 255 followed by the length on two bytes (RFC7011 section 7). It returns an
+// error if the prefix or the value is truncated.
+func DecodeVariableLength(buff *bytes.Buffer) (int, error) {
+	lengthOneByte, err := buff.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("error when decoding length of variable-length value: %v", err)
+	}
+	length := int(lengthOneByte)
+	if lengthOneByte == 255 {
+		if buff.Len() < 2 {
+			return 0, fmt.Errorf("error when decoding length of variable-length value: truncated length")
+		}
+		length = int(binary.BigEndian.Uint16(buff.Next(2)))
+	}
+	if length > buff.Len() {
+		return 0, fmt.Errorf("variable-length value of %d bytes exceeds the remaining %d bytes", length, buff.Len())
+	}
+	return length, nil
+}
+
+// ReadElementValue reads the bytes of the value of the element from the buff,
+// without the length prefix of variable-length elements. The bytes are not
+// copied. It returns an error if the value is truncated.
+func ReadElementValue(element *InfoElement, buff *bytes.Buffer) ([]byte, error) {
+	length := int(element.Len)
+	if element.Len == VariableLength {
+		var err error
+		if length, err = DecodeVariableLength(buff); err != nil {
+			return nil, fmt.Errorf("error when reading value of element %s: %v", element.Name, err)
+		}
+	} else if length > buff.Len() {
+		return nil, fmt.Errorf("error when reading value of element %s: %d bytes expected, %d bytes remaining", element.Name, length, buff.Len())
+	}
+	return buff.Next(length), nil
+}
+
+// ReverseInfoElementName returns the name of the reverse element of the
+// element with the given name, e.g. reversePacketTotalCount for
+// packetTotalCount. Reverse IANA elements have the enterprise ID 29305
+// (RFC5103 section 6.1), and enterprise registries like the Antrea registry
+// name their reverse elements the same way.
+func ReverseInfoElementName(name string) string {
+	return "reverse" + strings.Title(name)
+}
+
+// IsVariableLengthDataType returns whether the elements of the data type can
+// have variable lengths, in which case their length is VariableLength in
+// templates.
+func IsVariableLengthDataType(dataType IEDataType) bool {
+	return InfoElementLength[dataType] == VariableLength
+}
+
+// truncateString truncates the string to at most maxLen bytes without splitting
+// a multi-byte UTF-8 character.
+func truncateString(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	for maxLen > 0 && !utf8.RuneStart(s[maxLen]) {
+		maxLen--
+	}
+	return s[:maxLen]
+}
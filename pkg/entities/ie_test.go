@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -75,3 +76,82 @@ func TestNewInfoElementWithValue(t *testing.T) {
 	assert.Equal(t, element.Element.Name, "sourceIPv4Address")
 	assert.Equal(t, element.Value, ip)
 }
+
+func TestEncodeOctetArray(t *testing.T) {
+	buff := new(bytes.Buffer)
+	v, err := EncodeToIEDataType(OctetArray, []byte{0x1, 0x2, 0x3}, buff)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x1, 0x2, 0x3}, v)
+	assert.Equal(t, []byte{0x3, 0x1, 0x2, 0x3}, buff.Bytes())
+	decoded, err := DecodeToIEDataType(OctetArray, bytes.NewBuffer([]byte{0x1, 0x2, 0x3}))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x1, 0x2, 0x3}, decoded)
+}
+
+func TestReadElementValue(t *testing.T) {
+	element := NewInfoElement("interfaceName", 82, String, 0, VariableLength)
+	long := strings.Repeat("a", 300)
+	buff := new(bytes.Buffer)
+	for _, s := range []string{"eth0", "", long} {
+		_, err := EncodeToIEDataType(String, s, buff)
+		assert.NoError(t, err)
+	}
+	// Values below 255 bytes have a 1 byte length prefix, and others a 3
+	// bytes prefix.
+	for _, s := range []string{"eth0", "", long} {
+		val, err := ReadElementValue(element, buff)
+		assert.NoError(t, err)
+		assert.Equal(t, s, string(val))
+	}
+	assert.Equal(t, 0, buff.Len())
+
+	fixedElement := NewInfoElement("sourceIPv4Address", 8, Ipv4Address, 0, 4)
+	val, err := ReadElementValue(fixedElement, bytes.NewBuffer([]byte{10, 0, 0, 1, 2}))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{10, 0, 0, 1}, val)
+
+	for _, data := range []struct {
+		element *InfoElement
+		bytes   []byte
+	}{
+		{fixedElement, []byte{10, 0, 0}},
+		{element, []byte{}},
+		{element, []byte{255, 1}},
+		{element, []byte{3, 'a', 'b'}},
+		{element, []byte{255, 1, 0, 'a'}},
+	} {
+		_, err := ReadElementValue(data.element, bytes.NewBuffer(data.bytes))
+		assert.Errorf(t, err, "value %v of element %s should be truncated", data.bytes, data.element.Name)
+	}
+	assert.True(t, IsVariableLengthDataType(OctetArray))
+	assert.False(t, IsVariableLengthDataType(Unsigned32))
+}
+
+func TestEncodeOversizedVariableLengthValue(t *testing.T) {
+	s := strings.Repeat("a", MaxVariableLengthValueLen+10)
+	buff := new(bytes.Buffer)
+	_, err := EncodeToIEDataType(String, s, buff)
+	assert.Error(t, err)
+	overflowErr, ok := err.(*VariableLengthOverflowError)
+	assert.True(t, ok)
+	assert.Equal(t, MaxVariableLengthValueLen+10, overflowErr.Length)
+	assert.Equal(t, 0, buff.Len(), "nothing should be written to the buffer")
+
+	v, err := EncodeToIEDataTypeWithPolicy(String, s, buff, OverflowPolicyTruncate)
+	assert.NoError(t, err)
+	assert.Equal(t, MaxVariableLengthValueLen, len(v.([]byte)))
+	assert.Equal(t, MaxVariableLengthValueLen+3, buff.Len())
+	assert.Equal(t, uint16(MaxVariableLengthValueLen), binary.BigEndian.Uint16(buff.Bytes()[1:3]))
+
+	// Multi-byte characters should not be split when truncating.
+	s = strings.Repeat("a", MaxVariableLengthValueLen-1) + "é"
+	buff.Reset()
+	v, err = EncodeToIEDataTypeWithPolicy(String, s, buff, OverflowPolicyTruncate)
+	assert.NoError(t, err)
+	assert.Equal(t, MaxVariableLengthValueLen-1, len(v.([]byte)))
+
+	buff.Reset()
+	v, err = EncodeToIEDataTypeWithPolicy(OctetArray, make([]byte, MaxVariableLengthValueLen+1), buff, OverflowPolicyTruncate)
+	assert.NoError(t, err)
+	assert.Equal(t, MaxVariableLengthValueLen, len(v.([]byte)))
+}
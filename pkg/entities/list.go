@@ -0,0 +1,253 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entities
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/vmware/go-ipfix/pkg/util"
+)
+
+// ListSemantic is the relationship among the values of a structured data
+// type (RFC6313 section 4.4).
+type ListSemantic uint8
+
+const (
+	NoneOf                ListSemantic = 0x00
+	ExactlyOneOf          ListSemantic = 0x01
+	OneOrMoreOf           ListSemantic = 0x02
+	AllOf                 ListSemantic = 0x03
+	Ordered               ListSemantic = 0x04
+	UndefinedListSemantic ListSemantic = 0xFF
+)
+
+// BasicListValue is the value of basicList elements: a list of values of the
+// same element (RFC6313 section 4.5.1). The element has the length of the
+// values in the list, which may be VariableLength.
+type BasicListValue struct {
+	Semantic ListSemantic
+	Element  *InfoElement
+	Values   []interface{}
+}
+
+// SubTemplateListValue is the value of subTemplateList elements: a list of
+// records of the same template (RFC6313 section 4.5.2).
+type SubTemplateListValue struct {
+	Semantic   ListSemantic
+	TemplateID uint16
+	Records    [][]*InfoElementWithValue
+}
+
+// SubTemplateMultiListValue is the value of subTemplateMultiList elements:
+// lists of records of several templates (RFC6313 section 4.5.3).
+type SubTemplateMultiListValue struct {
+	Semantic ListSemantic
+	Lists    []SubTemplateMultiListEntry
+}
+
+// SubTemplateMultiListEntry are the records of a template in a
+// SubTemplateMultiListValue.
+type SubTemplateMultiListEntry struct {
+	TemplateID uint16
+	Records    [][]*InfoElementWithValue
+}
+
+// ListResolver resolves the elements and the templates referred to by the
+// values of structured data types, which depend on the transport session and
+// the observation domain of the records.
+type ListResolver interface {
+	GetInfoElementFromID(elementID uint16, enterpriseID uint32) (*InfoElement, error)
+	GetTemplate(templateID uint16) ([]*InfoElement, error)
+}
+
+// IsListDataType returns whether the data type is a structured data type.
+func IsListDataType(dataType IEDataType) bool {
+	return dataType == BasicList || dataType == SubTemplateList || dataType == SubTemplateMultiList
+}
+
+// DecodeListValue decodes the content of a value of a structured data type,
+// without its length prefix, into a *BasicListValue, *SubTemplateListValue or
+// *SubTemplateMultiListValue. The values of structured data types nested in
+// the list are decoded too, while DecodeElementValue returns their content
+// undecoded as it can only be decoded with a resolver.
+func DecodeListValue(dataType IEDataType, data []byte, resolver ListResolver) (interface{}, error) {
+	buff := bytes.NewBuffer(data)
+	var semantic ListSemantic
+	if err := util.Decode(buff, binary.BigEndian, &semantic); err != nil {
+		return nil, fmt.Errorf("error when decoding list semantic: %v", err)
+	}
+	switch dataType {
+	case BasicList:
+		var elementID, elementLength uint16
+		var enterpriseID uint32
+		if err := util.Decode(buff, binary.BigEndian, &elementID, &elementLength); err != nil {
+			return nil, fmt.Errorf("error when decoding basicList header: %v", err)
+		}
+		if elementID&0x8000 != 0 {
+			if err := util.Decode(buff, binary.BigEndian, &enterpriseID); err != nil {
+				return nil, fmt.Errorf("error when decoding basicList header: %v", err)
+			}
+			elementID &^= 0x8000
+		}
+		element, err := resolver.GetInfoElementFromID(elementID, enterpriseID)
+		if err != nil {
+			return nil, err
+		}
+		if element.Len != elementLength {
+			listElement := *element
+			listElement.Len = elementLength
+			element = &listElement
+		}
+		list := &BasicListValue{Semantic: semantic, Element: element, Values: make([]interface{}, 0)}
+		for buff.Len() > 0 {
+			value, err := readListElementValue(element, buff, resolver)
+			if err != nil {
+				return nil, err
+			}
+			list.Values = append(list.Values, value)
+		}
+		return list, nil
+	case SubTemplateList:
+		var templateID uint16
+		if err := util.Decode(buff, binary.BigEndian, &templateID); err != nil {
+			return nil, fmt.Errorf("error when decoding subTemplateList header: %v", err)
+		}
+		records, err := decodeListRecords(templateID, buff, resolver)
+		if err != nil {
+			return nil, err
+		}
+		return &SubTemplateListValue{Semantic: semantic, TemplateID: templateID, Records: records}, nil
+	case SubTemplateMultiList:
+		list := &SubTemplateMultiListValue{Semantic: semantic, Lists: make([]SubTemplateMultiListEntry, 0)}
+		for buff.Len() > 0 {
+			var templateID, length uint16
+			if err := util.Decode(buff, binary.BigEndian, &templateID, &length); err != nil {
+				return nil, fmt.Errorf("error when decoding subTemplateMultiList header: %v", err)
+			}
+			if length < 4 || int(length)-4 > buff.Len() {
+				return nil, fmt.Errorf("invalid length %d of records of template %d in subTemplateMultiList", length, templateID)
+			}
+			records, err := decodeListRecords(templateID, bytes.NewBuffer(buff.Next(int(length)-4)), resolver)
+			if err != nil {
+				return nil, err
+			}
+			list.Lists = append(list.Lists, SubTemplateMultiListEntry{TemplateID: templateID, Records: records})
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("data type %v is not a structured data type", dataType)
+}
+
+// decodeListRecords decodes all the records of the template in the buff.
+func decodeListRecords(templateID uint16, buff *bytes.Buffer, resolver ListResolver) ([][]*InfoElementWithValue, error) {
+	template, err := resolver.GetTemplate(templateID)
+	if err != nil {
+		return nil, err
+	}
+	if len(template) == 0 {
+		return nil, fmt.Errorf("template %d of list records has no elements", templateID)
+	}
+	records := make([][]*InfoElementWithValue, 0)
+	for buff.Len() > 0 {
+		record := make([]*InfoElementWithValue, 0, len(template))
+		for _, element := range template {
+			value, err := readListElementValue(element, buff, resolver)
+			if err != nil {
+				return nil, err
+			}
+			record = append(record, NewInfoElementWithValue(element, value))
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// readListElementValue reads and decodes the value of the element in a list.
+func readListElementValue(element *InfoElement, buff *bytes.Buffer, resolver ListResolver) (interface{}, error) {
+	data, err := ReadElementValue(element, buff)
+	if err != nil {
+		return nil, err
+	}
+	if IsListDataType(element.DataType) {
+		return DecodeListValue(element.DataType, data, resolver)
+	}
+	return DecodeElementValue(element, bytes.NewBuffer(data))
+}
+
+// encodeListValue encodes the content of a value of a structured data type,
+// without its length prefix. The value is either decoded, or the undecoded
+// content as a []byte.
+func encodeListValue(dataType IEDataType, val interface{}, buff *bytes.Buffer) error {
+	if content, ok := val.([]byte); ok {
+		buff.Write(content)
+		return nil
+	}
+	switch dataType {
+	case BasicList:
+		list, ok := val.(*BasicListValue)
+		if !ok {
+			return fmt.Errorf("val argument %v is not of type *BasicListValue for this element", val)
+		}
+		elementID := list.Element.ElementId
+		if list.Element.EnterpriseId != 0 {
+			util.Encode(buff, binary.BigEndian, uint8(list.Semantic), elementID|0x8000, list.Element.Len, list.Element.EnterpriseId)
+		} else {
+			util.Encode(buff, binary.BigEndian, uint8(list.Semantic), elementID, list.Element.Len)
+		}
+		for _, value := range list.Values {
+			if _, err := EncodeElementValue(list.Element, value, buff, OverflowPolicyError); err != nil {
+				return err
+			}
+		}
+	case SubTemplateList:
+		list, ok := val.(*SubTemplateListValue)
+		if !ok {
+			return fmt.Errorf("val argument %v is not of type *SubTemplateListValue for this element", val)
+		}
+		util.Encode(buff, binary.BigEndian, uint8(list.Semantic), list.TemplateID)
+		return encodeListRecords(list.Records, buff)
+	case SubTemplateMultiList:
+		list, ok := val.(*SubTemplateMultiListValue)
+		if !ok {
+			return fmt.Errorf("val argument %v is not of type *SubTemplateMultiListValue for this element", val)
+		}
+		buff.WriteByte(uint8(list.Semantic))
+		for _, entry := range list.Lists {
+			records := new(bytes.Buffer)
+			if err := encodeListRecords(entry.Records, records); err != nil {
+				return err
+			}
+			if records.Len()+4 > 0xffff {
+				return fmt.Errorf("records of template %d in subTemplateMultiList are too long", entry.TemplateID)
+			}
+			util.Encode(buff, binary.BigEndian, entry.TemplateID, uint16(records.Len()+4))
+			buff.Write(records.Bytes())
+		}
+	}
+	return nil
+}
+
+func encodeListRecords(records [][]*InfoElementWithValue, buff *bytes.Buffer) error {
+	for _, record := range records {
+		for _, ie := range record {
+			if _, err := EncodeElementValue(ie.Element, ie.Value, buff, OverflowPolicyError); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
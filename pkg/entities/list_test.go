@@ -0,0 +1,124 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entities
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testListResolver struct {
+	elements  []*InfoElement
+	templates map[uint16][]*InfoElement
+}
+
+func (r *testListResolver) GetInfoElementFromID(elementID uint16, enterpriseID uint32) (*InfoElement, error) {
+	for _, element := range r.elements {
+		if element.ElementId == elementID && element.EnterpriseId == enterpriseID {
+			return element, nil
+		}
+	}
+	return nil, fmt.Errorf("element %d of enterprise %d is unknown", elementID, enterpriseID)
+}
+
+func (r *testListResolver) GetTemplate(templateID uint16) ([]*InfoElement, error) {
+	template, exist := r.templates[templateID]
+	if !exist {
+		return nil, fmt.Errorf("template %d is unknown", templateID)
+	}
+	return template, nil
+}
+
+func TestListValues(t *testing.T) {
+	ruleName := NewInfoElement("ingressNetworkPolicyRuleName", 141, String, 56506, VariableLength)
+	ruleAction := NewInfoElement("ingressNetworkPolicyRuleAction", 139, Unsigned8, 56506, 1)
+	port := NewInfoElement("destinationTransportPort", 11, Unsigned16, 0, 2)
+	rules := NewInfoElement("subTemplateList", 292, SubTemplateList, 0, VariableLength)
+	resolver := &testListResolver{
+		elements: []*InfoElement{ruleName, ruleAction, port},
+		templates: map[uint16][]*InfoElement{
+			256: {ruleName, ruleAction},
+			257: {port, rules},
+		},
+	}
+	basicListElement := NewInfoElement("basicList", 291, BasicList, 0, VariableLength)
+	multiListElement := NewInfoElement("subTemplateMultiList", 293, SubTemplateMultiList, 0, VariableLength)
+
+	// A basicList of an enterprise element carries the enterprise number.
+	basicList := &BasicListValue{
+		Semantic: AllOf,
+		Element:  ruleName,
+		Values:   []interface{}{"allow-dns", "deny-all"},
+	}
+	buff := new(bytes.Buffer)
+	_, err := EncodeToIEDataType(BasicList, basicList, buff)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{28, 0x03, 0x80, 141, 0xff, 0xff, 0, 0, 0xdc, 0xba,
+		9, 'a', 'l', 'l', 'o', 'w', '-', 'd', 'n', 's', 8, 'd', 'e', 'n', 'y', '-', 'a', 'l', 'l'}, buff.Bytes())
+	content, err := ReadElementValue(basicListElement, buff)
+	assert.NoError(t, err)
+	value, err := DecodeListValue(BasicList, content, resolver)
+	assert.NoError(t, err)
+	assert.Equal(t, basicList, value)
+
+	// Lists nested in a subTemplateMultiList are decoded too.
+	multiList := &SubTemplateMultiListValue{
+		Semantic: OneOrMoreOf,
+		Lists: []SubTemplateMultiListEntry{
+			{TemplateID: 256, Records: [][]*InfoElementWithValue{
+				{NewInfoElementWithValue(ruleName, "allow-dns"), NewInfoElementWithValue(ruleAction, uint8(1))},
+				{NewInfoElementWithValue(ruleName, "deny-all"), NewInfoElementWithValue(ruleAction, uint8(2))},
+			}},
+			{TemplateID: 257, Records: [][]*InfoElementWithValue{
+				{NewInfoElementWithValue(port, uint16(53)), NewInfoElementWithValue(rules, &SubTemplateListValue{
+					Semantic:   Ordered,
+					TemplateID: 256,
+					Records: [][]*InfoElementWithValue{
+						{NewInfoElementWithValue(ruleName, "allow-dns"), NewInfoElementWithValue(ruleAction, uint8(1))},
+					},
+				})},
+			}},
+		},
+	}
+	buff.Reset()
+	_, err = EncodeToIEDataType(SubTemplateMultiList, multiList, buff)
+	assert.NoError(t, err)
+	content, err = ReadElementValue(multiListElement, buff)
+	assert.NoError(t, err)
+	value, err = DecodeListValue(SubTemplateMultiList, content, resolver)
+	assert.NoError(t, err)
+	assert.Equal(t, multiList, value)
+
+	// Undecoded content is encoded as is.
+	buff.Reset()
+	_, err = EncodeToIEDataType(SubTemplateMultiList, content, buff)
+	assert.NoError(t, err)
+	decoded, err := DecodeToIEDataType(SubTemplateMultiList, bytes.NewBuffer(buff.Bytes()[1:]))
+	assert.NoError(t, err)
+	assert.Equal(t, content, decoded)
+
+	// Lists of unknown templates and truncated lists cannot be decoded.
+	_, err = DecodeListValue(SubTemplateList, []byte{0x03, 0x01, 0x02, 0x00}, resolver)
+	assert.Error(t, err)
+	_, err = DecodeListValue(SubTemplateMultiList, []byte{0x03, 0x01, 0x00, 0x00, 0x10, 0x00}, resolver)
+	assert.Error(t, err)
+	_, err = DecodeListValue(BasicList, []byte{0x03, 0x00, 0x0b, 0x00, 0x02, 0x00}, resolver)
+	assert.Error(t, err)
+	_, err = EncodeToIEDataType(BasicList, multiList, buff)
+	assert.Error(t, err)
+}
@@ -17,12 +17,15 @@ package entities
 import (
 	"bytes"
 	"encoding/binary"
+	"time"
 )
 
 const (
 	MaxTcpSocketMsgSize int = 65535
 	DefaultUDPMsgSize   int = 512
 	MaxUDPMsgSize       int = 1500
+	// MsgHeaderLength is the length of the IPFIX message header.
+	MsgHeaderLength int = 16
 )
 
 // Message represents IPFIX message.
@@ -36,6 +39,8 @@ type Message struct {
 	obsDomainID   uint32
 	exportTime    uint32
 	exportAddress string
+	obsDomainName string
+	clockSkew     time.Duration
 	isDecoding    bool
 	set           Set
 }
@@ -110,6 +115,27 @@ func (m *Message) SetExportAddress(ipAddr string) {
 	m.exportAddress = ipAddr
 }
 
+// GetObsDomainName returns the name of the observation domain of a decoded
+// message, if the collector resolves names of observation domains.
+func (m *Message) GetObsDomainName() string {
+	return m.obsDomainName
+}
+
+func (m *Message) SetObsDomainName(name string) {
+	m.obsDomainName = name
+}
+
+// GetClockSkew returns the estimated difference between the clock of the
+// exporter of a decoded message and the clock of the collector, if the
+// collector estimates clock skews. It is positive if the exporter is ahead.
+func (m *Message) GetClockSkew() time.Duration {
+	return m.clockSkew
+}
+
+func (m *Message) SetClockSkew(skew time.Duration) {
+	m.clockSkew = skew
+}
+
 func (m *Message) GetSet() Set {
 	return m.set
 }
@@ -131,7 +157,7 @@ func (m *Message) WriteToMsgBuffer(bytesToWrite []byte) (int, error) {
 }
 
 func (m *Message) CreateHeader() (int, error) {
-	header := make([]byte, 16)
+	header := make([]byte, MsgHeaderLength)
 	return m.WriteToMsgBuffer(header)
 }
 
@@ -0,0 +1,149 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entities
+
+import (
+	"sync"
+	"time"
+)
+
+// Latency probes are data records sent periodically by exporting processes,
+// whose template only contains latencyProbeSequence and latencyProbeSendTime.
+// Wherever they are received, the latency of the pipeline is the time elapsed
+// since they were sent, and gaps in their sequence numbers are probes lost.
+const (
+	LatencyProbeSequenceElement = "latencyProbeSequence"
+	LatencyProbeSendTimeElement = "latencyProbeSendTime"
+)
+
+// IsLatencyProbe returns true if the record is a latency probe.
+func IsLatencyProbe(record Record) bool {
+	_, exist := record.GetInfoElementWithValue(LatencyProbeSequenceElement)
+	return exist
+}
+
+// LatencyProbeSource identifies the exporting process which sent latency
+// probes.
+type LatencyProbeSource struct {
+	ExporterAddress string
+	ObsDomainID     uint32
+}
+
+// LatencyProbeStats are the stats of the latency probes received from an
+// exporting process.
+type LatencyProbeStats struct {
+	// Received is the number of probes received.
+	Received uint64
+	// Lost is the number of probes missing from the sequence of the received
+	// probes.
+	Lost uint64
+	// LastSequence is the sequence number of the last probe received.
+	LastSequence uint64
+	// LastLatency, MinLatency and MaxLatency are the latencies of the last,
+	// fastest and slowest probes. Latencies include the difference between
+	// the clocks of the exporter and of the receiver.
+	LastLatency time.Duration
+	MinLatency  time.Duration
+	MaxLatency  time.Duration
+	// AverageLatency is the average latency of the received probes.
+	AverageLatency time.Duration
+	// LastReceiveTime is the time at which the last probe was received.
+	LastReceiveTime time.Time
+}
+
+// LatencyProbeTracker measures the latency and the loss of the latency probes
+// received from every exporting process. It is safe for concurrent use.
+type LatencyProbeTracker struct {
+	mutex        sync.Mutex
+	stats        map[LatencyProbeSource]*LatencyProbeStats
+	totalLatency map[LatencyProbeSource]time.Duration
+}
+
+func NewLatencyProbeTracker() *LatencyProbeTracker {
+	return &LatencyProbeTracker{
+		stats:        make(map[LatencyProbeSource]*LatencyProbeStats),
+		totalLatency: make(map[LatencyProbeSource]time.Duration),
+	}
+}
+
+// Observe records the latency probes of the data set of the message, received
+// at receiveTime, and returns their number. A sequence number lower than or
+// equal to the last one is taken as a restart of the exporting process.
+func (t *LatencyProbeTracker) Observe(message *Message, receiveTime time.Time) int {
+	set := message.GetSet()
+	if set == nil || set.GetSetType() != Data {
+		return 0
+	}
+	source := LatencyProbeSource{message.GetExportAddress(), message.GetObsDomainID()}
+	count := 0
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, record := range set.GetRecords() {
+		sequenceIE, exist := record.GetInfoElementWithValue(LatencyProbeSequenceElement)
+		if !exist {
+			continue
+		}
+		sendTimeIE, exist := record.GetInfoElementWithValue(LatencyProbeSendTimeElement)
+		if !exist {
+			continue
+		}
+		sequence, ok := sequenceIE.Value.(uint64)
+		if !ok {
+			continue
+		}
+		sendTime, ok := sendTimeIE.Value.(uint64)
+		if !ok {
+			continue
+		}
+		count++
+		t.observeProbe(source, sequence, time.Unix(0, int64(sendTime)), receiveTime)
+	}
+	return count
+}
+
+func (t *LatencyProbeTracker) observeProbe(source LatencyProbeSource, sequence uint64, sendTime, receiveTime time.Time) {
+	latency := receiveTime.Sub(sendTime)
+	stats, exist := t.stats[source]
+	if !exist {
+		stats = &LatencyProbeStats{MinLatency: latency, MaxLatency: latency}
+		t.stats[source] = stats
+	}
+	if exist && sequence > stats.LastSequence+1 {
+		stats.Lost += sequence - stats.LastSequence - 1
+	}
+	stats.Received++
+	stats.LastSequence = sequence
+	stats.LastLatency = latency
+	stats.LastReceiveTime = receiveTime
+	if latency < stats.MinLatency {
+		stats.MinLatency = latency
+	}
+	if latency > stats.MaxLatency {
+		stats.MaxLatency = latency
+	}
+	t.totalLatency[source] += latency
+	stats.AverageLatency = t.totalLatency[source] / time.Duration(stats.Received)
+}
+
+// GetStats returns the stats of the latency probes of every exporting process.
+func (t *LatencyProbeTracker) GetStats() map[LatencyProbeSource]LatencyProbeStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	stats := make(map[LatencyProbeSource]LatencyProbeStats, len(t.stats))
+	for source, s := range t.stats {
+		stats[source] = *s
+	}
+	return stats
+}
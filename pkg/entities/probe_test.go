@@ -0,0 +1,76 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entities
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createLatencyProbeMessage(t *testing.T, obsDomainID uint32, sendTime time.Time, sequences ...uint64) *Message {
+	set := NewSet(false)
+	assert.NoError(t, set.PrepareSet(Data, testTemplateID))
+	for _, sequence := range sequences {
+		assert.NoError(t, set.AddRecord([]*InfoElementWithValue{
+			NewInfoElementWithValue(NewInfoElement(LatencyProbeSequenceElement, 152, Unsigned64, 56506, 8), sequence),
+			NewInfoElementWithValue(NewInfoElement(LatencyProbeSendTimeElement, 153, Unsigned64, 56506, 8), uint64(sendTime.UnixNano())),
+		}, testTemplateID))
+	}
+	message := NewMessage(true)
+	message.SetExportAddress("127.0.0.1")
+	message.SetObsDomainID(obsDomainID)
+	message.AddSet(set)
+	return message
+}
+
+func TestLatencyProbeTracker(t *testing.T) {
+	tracker := NewLatencyProbeTracker()
+	now := time.Now()
+	assert.Equal(t, 1, tracker.Observe(createLatencyProbeMessage(t, 1, now.Add(-10*time.Millisecond), 3), now))
+	assert.True(t, IsLatencyProbe(createLatencyProbeMessage(t, 1, now, 1).GetSet().GetRecords()[0]))
+	// Probes 4 and 5 are lost.
+	assert.Equal(t, 2, tracker.Observe(createLatencyProbeMessage(t, 1, now.Add(-30*time.Millisecond), 6, 7), now))
+	// The exporting process restarted.
+	assert.Equal(t, 1, tracker.Observe(createLatencyProbeMessage(t, 1, now.Add(-20*time.Millisecond), 1), now))
+	assert.Equal(t, 1, tracker.Observe(createLatencyProbeMessage(t, 2, now.Add(-5*time.Millisecond), 1), now))
+
+	source := LatencyProbeSource{ExporterAddress: "127.0.0.1", ObsDomainID: 1}
+	stats := tracker.GetStats()
+	assert.Len(t, stats, 2)
+	assert.Equal(t, LatencyProbeStats{
+		Received:        4,
+		Lost:            2,
+		LastSequence:    1,
+		LastLatency:     20 * time.Millisecond,
+		MinLatency:      10 * time.Millisecond,
+		MaxLatency:      30 * time.Millisecond,
+		AverageLatency:  22500 * time.Microsecond,
+		LastReceiveTime: now,
+	}, stats[source])
+	assert.Equal(t, uint64(1), stats[LatencyProbeSource{ExporterAddress: "127.0.0.1", ObsDomainID: 2}].Received)
+
+	// Other records are not probes.
+	set := NewSet(false)
+	assert.NoError(t, set.PrepareSet(Data, testTemplateID))
+	assert.NoError(t, set.AddRecord([]*InfoElementWithValue{
+		NewInfoElementWithValue(NewInfoElement("protocolIdentifier", 4, Unsigned8, 0, 1), uint8(6)),
+	}, testTemplateID))
+	message := NewMessage(true)
+	message.AddSet(set)
+	assert.Equal(t, 0, tracker.Observe(message, now))
+	assert.False(t, IsLatencyProbe(set.GetRecords()[0]))
+}
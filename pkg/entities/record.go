@@ -39,7 +39,21 @@ type Record interface {
 	GetFieldCount() uint16
 	GetOrderedElementList() []*InfoElementWithValue
 	GetInfoElementWithValue(name string) (*InfoElementWithValue, bool)
+	// GetReverseInfoElementWithValue returns the reverse element of the
+	// element with the given name, e.g. reversePacketTotalCount for
+	// packetTotalCount.
+	GetReverseInfoElementWithValue(name string) (*InfoElementWithValue, bool)
 	GetMinDataRecordLen() uint16
+	GetScopeFieldCount() uint16
+	// GetScopeElements returns the scope elements of options template records
+	// and of the data records of options templates, e.g. the metering process
+	// or exporting process of statistics records (RFC7011 section 4), and nil
+	// for other records.
+	GetScopeElements() []*InfoElementWithValue
+	// Materialize decodes the ValueView values of data records decoded in
+	// zero-copy mode, so that they no longer share the memory of the received
+	// message.
+	Materialize()
 }
 
 type baseRecord struct {
@@ -49,11 +63,16 @@ type baseRecord struct {
 	templateID         uint16
 	orderedElementList []*InfoElementWithValue
 	elementsMap        map[string]*InfoElementWithValue
+	// scopeFieldCount is the number of scope fields for options template
+	// records and their data records, and 0 for other records.
+	scopeFieldCount uint16
 	Record
 }
 
 type dataRecord struct {
 	*baseRecord
+	// overflowPolicy is applied to oversized values of variable-length elements.
+	overflowPolicy VariableLengthOverflowPolicy
 }
 
 func NewDataRecord(id uint16) *dataRecord {
@@ -66,6 +85,7 @@ func NewDataRecord(id uint16) *dataRecord {
 			orderedElementList: make([]*InfoElementWithValue, 0),
 			elementsMap:        make(map[string]*InfoElementWithValue),
 		},
+		OverflowPolicyError,
 	}
 }
 
@@ -90,6 +110,44 @@ func NewTemplateRecord(count uint16, id uint16) *templateRecord {
 	}
 }
 
+// NewOptionsTemplateRecord creates an options template record whose first
+// scopeCount fields are the scope fields (RFC7011 section 3.4.2.2).
+func NewOptionsTemplateRecord(count uint16, scopeCount uint16, id uint16) *templateRecord {
+	record := NewTemplateRecord(count, id)
+	record.scopeFieldCount = scopeCount
+	return record
+}
+
+// CopyRecord returns a copy of the record with copies of its elements, so that
+// adding elements to the copy or replacing their values does not affect the
+// record. Records of other implementations of Record are returned as is.
+func CopyRecord(r Record) Record {
+	switch record := r.(type) {
+	case *dataRecord:
+		return &dataRecord{record.baseRecord.copy(), record.overflowPolicy}
+	case *templateRecord:
+		return &templateRecord{record.baseRecord.copy(), record.minDataRecLength}
+	}
+	return r
+}
+
+func (b *baseRecord) copy() *baseRecord {
+	c := &baseRecord{
+		len:                b.len,
+		fieldCount:         b.fieldCount,
+		templateID:         b.templateID,
+		orderedElementList: make([]*InfoElementWithValue, len(b.orderedElementList)),
+		elementsMap:        make(map[string]*InfoElementWithValue, len(b.elementsMap)),
+		scopeFieldCount:    b.scopeFieldCount,
+	}
+	c.buff.Write(b.buff.Bytes())
+	for i, ie := range b.orderedElementList {
+		c.orderedElementList[i] = NewInfoElementWithValue(ie.Element, ie.Value)
+		c.elementsMap[ie.Element.Name] = c.orderedElementList[i]
+	}
+	return c
+}
+
 func (b *baseRecord) GetBuffer() *bytes.Buffer {
 	return &b.buff
 }
@@ -106,6 +164,17 @@ func (d *baseRecord) GetOrderedElementList() []*InfoElementWithValue {
 	return d.orderedElementList
 }
 
+func (b *baseRecord) GetScopeFieldCount() uint16 {
+	return b.scopeFieldCount
+}
+
+func (b *baseRecord) GetScopeElements() []*InfoElementWithValue {
+	if b.scopeFieldCount == 0 || int(b.scopeFieldCount) > len(b.orderedElementList) {
+		return nil
+	}
+	return b.orderedElementList[:b.scopeFieldCount]
+}
+
 func (b *baseRecord) GetInfoElementWithValue(name string) (*InfoElementWithValue, bool) {
 	if element, exist := b.elementsMap[name]; exist {
 		return element, exist
@@ -114,25 +183,35 @@ func (b *baseRecord) GetInfoElementWithValue(name string) (*InfoElementWithValue
 	}
 }
 
+func (b *baseRecord) GetReverseInfoElementWithValue(name string) (*InfoElementWithValue, bool) {
+	return b.GetInfoElementWithValue(ReverseInfoElementName(name))
+}
+
 func (d *dataRecord) PrepareRecord() (uint16, error) {
 	// We do not have to do anything if it is data record
 	return 0, nil
 }
 
 func (d *dataRecord) AddInfoElement(element *InfoElementWithValue, isDecoding bool) (uint16, error) {
-	d.fieldCount++
 	initialLength := d.buff.Len()
 	var value interface{}
 	var err error
-	if isDecoding {
-		value, err = DecodeToIEDataType(element.Element.DataType, element.Value)
+	if view, ok := element.Value.(ValueView); isDecoding && ok {
+		// Views are decoded by Materialize.
+		value = view
+	} else if isDecoding {
+		value, err = DecodeElementValue(element.Element, element.Value)
 	} else {
-		value, err = EncodeToIEDataType(element.Element.DataType, element.Value, &d.buff)
+		value, err = EncodeElementValue(element.Element, element.Value, &d.buff, d.overflowPolicy)
 	}
 
 	if err != nil {
+		if overflowErr, ok := err.(*VariableLengthOverflowError); ok {
+			overflowErr.Name = element.Element.Name
+		}
 		return 0, err
 	}
+	d.fieldCount++
 	ie := NewInfoElementWithValue(element.Element, value)
 	d.orderedElementList = append(d.orderedElementList, ie)
 	d.elementsMap[element.Element.Name] = ie
@@ -145,7 +224,13 @@ func (d *dataRecord) AddInfoElement(element *InfoElementWithValue, isDecoding bo
 func (t *templateRecord) PrepareRecord() (uint16, error) {
 	// Add Template Record Header
 	initialLength := t.buff.Len()
-	err := util.Encode(&t.buff, binary.BigEndian, t.templateID, t.fieldCount)
+	var err error
+	if t.scopeFieldCount == 0 {
+		err = util.Encode(&t.buff, binary.BigEndian, t.templateID, t.fieldCount)
+	} else {
+		// Options Template Record Header includes the scope field count.
+		err = util.Encode(&t.buff, binary.BigEndian, t.templateID, t.fieldCount, t.scopeFieldCount)
+	}
 	if err != nil {
 		return 0, fmt.Errorf("AddInfoElement(templateRecord) error in writing template header: %v", err)
 	}
@@ -147,3 +147,44 @@ func TestGetInfoElementWithValue(t *testing.T) {
 	infoElementWithValue, _ = dataRec.GetInfoElementWithValue("destinationIPv4Address")
 	assert.Nil(t, infoElementWithValue)
 }
+
+func TestGetReverseInfoElementWithValue(t *testing.T) {
+	dataRec := NewDataRecord(256)
+	dataRec.elementsMap = make(map[string]*InfoElementWithValue)
+	ie := NewInfoElementWithValue(NewInfoElement("reversePacketTotalCount", 86, 4, 29305, 8), uint64(10))
+	dataRec.elementsMap["reversePacketTotalCount"] = ie
+	infoElementWithValue, exist := dataRec.GetReverseInfoElementWithValue("packetTotalCount")
+	assert.True(t, exist)
+	assert.Equal(t, uint64(10), infoElementWithValue.Value)
+	_, exist = dataRec.GetReverseInfoElementWithValue("octetTotalCount")
+	assert.False(t, exist)
+}
+
+func TestCopyRecord(t *testing.T) {
+	dataRec := NewDataRecord(256)
+	element := NewInfoElement("sourceIPv4Address", 8, 18, 0, 4)
+	_, err := dataRec.AddInfoElement(NewInfoElementWithValue(element, net.ParseIP("10.0.0.1").To4()), false)
+	assert.NoError(t, err)
+	copied := CopyRecord(dataRec)
+	assert.Equal(t, dataRec.GetBuffer().Bytes(), copied.GetBuffer().Bytes())
+	ieWithValue, _ := copied.GetInfoElementWithValue("sourceIPv4Address")
+	assert.Equal(t, net.ParseIP("10.0.0.1").To4(), ieWithValue.Value)
+	// Changes to the copy do not affect the record.
+	ieWithValue.Value = net.ParseIP("10.0.0.2").To4()
+	_, err = copied.AddInfoElement(NewInfoElementWithValue(NewInfoElement("destinationIPv4Address", 12, 18, 0, 4), net.ParseIP("10.0.0.3").To4()), false)
+	assert.NoError(t, err)
+	ieWithValue, _ = dataRec.GetInfoElementWithValue("sourceIPv4Address")
+	assert.Equal(t, net.ParseIP("10.0.0.1").To4(), ieWithValue.Value)
+	_, exist := dataRec.GetInfoElementWithValue("destinationIPv4Address")
+	assert.False(t, exist)
+	assert.Len(t, dataRec.GetOrderedElementList(), 1)
+	assert.Len(t, copied.GetOrderedElementList(), 2)
+
+	templateRec := NewOptionsTemplateRecord(1, 1, 256)
+	_, err = templateRec.AddInfoElement(NewInfoElementWithValue(element, nil), false)
+	assert.NoError(t, err)
+	copied = CopyRecord(templateRec)
+	assert.Equal(t, uint16(1), copied.GetScopeFieldCount())
+	assert.Equal(t, templateRec.GetMinDataRecordLen(), copied.GetMinDataRecordLen())
+	assert.NotSame(t, templateRec.GetOrderedElementList()[0], copied.GetOrderedElementList()[0])
+}
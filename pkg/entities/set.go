@@ -29,6 +29,10 @@ const (
 	TemplateTTL = TemplateRefreshTimeOut * 3
 	// TemplateSetID is the setID for template record
 	TemplateSetID uint16 = 2
+	// OptionsTemplateSetID is the setID for options template record
+	OptionsTemplateSetID uint16 = 3
+	// SetHeaderLen is the length of the set header
+	SetHeaderLen int = 4
 )
 
 type ContentType uint8
@@ -36,7 +40,7 @@ type ContentType uint8
 const (
 	Template ContentType = iota
 	Data
-	// Add OptionsTemplate too when it is supported
+	OptionsTemplate
 	Undefined = 255
 )
 
@@ -47,6 +51,12 @@ type Set interface {
 	GetSetType() ContentType
 	UpdateLenInHeader()
 	AddRecord(elements []*InfoElementWithValue, templateID uint16) error
+	// AddOptionsTemplateRecord adds an options template record to a set of type
+	// OptionsTemplate. The first scopeFieldCount elements are the scope fields.
+	AddOptionsTemplateRecord(elements []*InfoElementWithValue, scopeFieldCount uint16, templateID uint16) error
+	// AddOptionsDataRecord adds a data record of an options template to a set
+	// of type Data. The first scopeFieldCount elements are the scope fields.
+	AddOptionsDataRecord(elements []*InfoElementWithValue, scopeFieldCount uint16, templateID uint16) error
 	GetRecords() []Record
 	GetNumberOfRecords() uint32
 }
@@ -57,13 +67,35 @@ type set struct {
 	setType    ContentType
 	records    []Record
 	isDecoding bool
+	// overflowPolicy is applied to values of variable-length elements that do
+	// not fit in an IPFIX message when encoding data records.
+	overflowPolicy VariableLengthOverflowPolicy
 }
 
 func NewSet(isDecoding bool) Set {
+	return NewSetWithOverflowPolicy(isDecoding, OverflowPolicyError)
+}
+
+// NewSetWithOverflowPolicy creates a set whose data records handle oversized
+// variable-length values according to the given policy. With the default
+// policy (OverflowPolicyError), AddRecord returns a VariableLengthOverflowError.
+func NewSetWithOverflowPolicy(isDecoding bool, policy VariableLengthOverflowPolicy) Set {
+	return &set{
+		buffer:         &bytes.Buffer{},
+		records:        make([]Record, 0),
+		isDecoding:     isDecoding,
+		overflowPolicy: policy,
+	}
+}
+
+// CopySet returns a copy of the encoded set, which is not affected when the set
+// is reset and reused afterwards. The records are shared with the set, as they
+// are not modified once added.
+func CopySet(s Set) Set {
 	return &set{
-		buffer:     &bytes.Buffer{},
-		records:    make([]Record, 0),
-		isDecoding: isDecoding,
+		buffer:  bytes.NewBuffer(append([]byte{}, s.GetBuffer().Bytes()...)),
+		setType: s.GetSetType(),
+		records: append([]Record{}, s.GetRecords()...),
 	}
 }
 
@@ -106,15 +138,61 @@ func (s *set) UpdateLenInHeader() {
 func (s *set) AddRecord(elements []*InfoElementWithValue, templateID uint16) error {
 	var record Record
 	if s.setType == Data {
-		record = NewDataRecord(templateID)
-	} else if s.setType == Template {
+		dataRecord := NewDataRecord(templateID)
+		dataRecord.overflowPolicy = s.overflowPolicy
+		record = dataRecord
+	} else if s.setType == Template || (s.setType == OptionsTemplate && len(elements) == 0) {
+		// Template records without fields are template withdrawals, which
+		// have no scope field count in options template sets either.
 		record = NewTemplateRecord(uint16(len(elements)), templateID)
 	} else {
 		return fmt.Errorf("set type is not supported")
 	}
-	record.PrepareRecord()
-	for _, element := range elements {
-		record.AddInfoElement(element, s.isDecoding)
+	return s.addRecord(record, elements)
+}
+
+func (s *set) AddOptionsTemplateRecord(elements []*InfoElementWithValue, scopeFieldCount uint16, templateID uint16) error {
+	if s.setType != OptionsTemplate {
+		return fmt.Errorf("options template record cannot be added to set of type %d", s.setType)
+	}
+	if scopeFieldCount == 0 || int(scopeFieldCount) > len(elements) {
+		return fmt.Errorf("invalid scope field count %d for options template record with %d fields", scopeFieldCount, len(elements))
+	}
+	return s.addRecord(NewOptionsTemplateRecord(uint16(len(elements)), scopeFieldCount, templateID), elements)
+}
+
+func (s *set) AddOptionsDataRecord(elements []*InfoElementWithValue, scopeFieldCount uint16, templateID uint16) error {
+	if s.setType != Data {
+		return fmt.Errorf("options data record cannot be added to set of type %d", s.setType)
+	}
+	if scopeFieldCount == 0 || int(scopeFieldCount) > len(elements) {
+		return fmt.Errorf("invalid scope field count %d for options data record with %d fields", scopeFieldCount, len(elements))
+	}
+	dataRecord := NewDataRecord(templateID)
+	dataRecord.overflowPolicy = s.overflowPolicy
+	dataRecord.scopeFieldCount = scopeFieldCount
+	return s.addRecord(dataRecord, elements)
+}
+
+func (s *set) addRecord(record Record, elements []*InfoElementWithValue) error {
+	if _, err := record.PrepareRecord(); err != nil {
+		return err
+	}
+	scopeFieldCount := record.GetScopeFieldCount()
+	for i, element := range elements {
+		if _, err := record.AddInfoElement(element, s.isDecoding); err != nil {
+			// When decoding, elements whose data type is not supported, e.g.
+			// dateTimeMicroseconds, are left out of the record instead of
+			// dropping it.
+			if _, isOverflow := err.(*VariableLengthOverflowError); s.isDecoding && s.setType == Data && !isOverflow {
+				// The scope elements left out are not counted.
+				if dataRecord, ok := record.(*dataRecord); ok && i < int(scopeFieldCount) {
+					dataRecord.scopeFieldCount--
+				}
+				continue
+			}
+			return err
+		}
 	}
 	s.records = append(s.records, record)
 	// write record to set when encoding
@@ -140,9 +218,11 @@ func (s *set) GetNumberOfRecords() uint32 {
 }
 
 func (s *set) createHeader(setType ContentType, templateID uint16) error {
-	header := make([]byte, 4)
+	header := make([]byte, SetHeaderLen)
 	if setType == Template {
 		binary.BigEndian.PutUint16(header[0:2], TemplateSetID)
+	} else if setType == OptionsTemplate {
+		binary.BigEndian.PutUint16(header[0:2], OptionsTemplateSetID)
 	} else if setType == Data {
 		binary.BigEndian.PutUint16(header[0:2], templateID)
 	}
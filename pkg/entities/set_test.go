@@ -1,8 +1,10 @@
 package entities
 
 import (
+	"bytes"
 	"encoding/binary"
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -145,3 +147,106 @@ func TestSet_UpdateLenInHeader(t *testing.T) {
 	// Check the bytes in the header for set length
 	assert.Equal(t, uint16(setForEncoding.GetBuffer().Len()), binary.BigEndian.Uint16(setForEncoding.GetBuffer().Bytes()[2:4]))
 }
+
+func TestAddRecordWithOversizedValue(t *testing.T) {
+	element := NewInfoElement("interfaceDescription", 83, 13, 0, 65535)
+	value := strings.Repeat("a", MaxVariableLengthValueLen+1)
+
+	encodingSet := NewSet(false)
+	err := encodingSet.PrepareSet(Data, testTemplateID)
+	assert.NoError(t, err)
+	err = encodingSet.AddRecord([]*InfoElementWithValue{NewInfoElementWithValue(element, value)}, testTemplateID)
+	assert.Error(t, err)
+	overflowErr, ok := err.(*VariableLengthOverflowError)
+	assert.True(t, ok)
+	assert.Equal(t, "interfaceDescription", overflowErr.Name)
+	assert.Equal(t, uint32(0), encodingSet.GetNumberOfRecords())
+	assert.Equal(t, SetHeaderLen, encodingSet.GetBuffer().Len(), "invalid record should not be written to the set")
+
+	truncatingSet := NewSetWithOverflowPolicy(false, OverflowPolicyTruncate)
+	err = truncatingSet.PrepareSet(Data, testTemplateID)
+	assert.NoError(t, err)
+	err = truncatingSet.AddRecord([]*InfoElementWithValue{NewInfoElementWithValue(element, value)}, testTemplateID)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), truncatingSet.GetNumberOfRecords())
+	assert.Equal(t, SetHeaderLen+MaxVariableLengthValueLen+3, truncatingSet.GetBuffer().Len())
+}
+
+func TestAddOptionsTemplateRecord(t *testing.T) {
+	elements := make([]*InfoElementWithValue, 0)
+	ie1 := NewInfoElementWithValue(NewInfoElement("informationElementId", 303, 2, 0, 2), nil)
+	ie2 := NewInfoElementWithValue(NewInfoElement("informationElementName", 341, 13, 0, 65535), nil)
+	elements = append(elements, ie1, ie2)
+	newSet := NewSet(false)
+	err := newSet.PrepareSet(OptionsTemplate, OptionsTemplateSetID)
+	assert.NoError(t, err)
+	// Scope field count must be between 1 and the number of fields.
+	assert.Error(t, newSet.AddOptionsTemplateRecord(elements, 0, testTemplateID))
+	assert.Error(t, newSet.AddOptionsTemplateRecord(elements, 3, testTemplateID))
+	assert.NoError(t, newSet.AddOptionsTemplateRecord(elements, 1, testTemplateID))
+	assert.Equal(t, uint16(1), newSet.GetRecords()[0].GetScopeFieldCount())
+	newSet.UpdateLenInHeader()
+	// Set header, options template record header and two field specifiers.
+	buff := newSet.GetBuffer().Bytes()
+	assert.Equal(t, 4+6+8, len(buff))
+	assert.Equal(t, OptionsTemplateSetID, binary.BigEndian.Uint16(buff[0:2]))
+	assert.Equal(t, testTemplateID, binary.BigEndian.Uint16(buff[4:6]))
+	assert.Equal(t, uint16(2), binary.BigEndian.Uint16(buff[6:8]))
+	assert.Equal(t, uint16(1), binary.BigEndian.Uint16(buff[8:10]))
+	// Options template records cannot be added to template sets.
+	newSet.ResetSet()
+	_ = newSet.PrepareSet(Template, testTemplateID)
+	assert.Error(t, newSet.AddOptionsTemplateRecord(elements, 1, testTemplateID))
+}
+
+func TestAddOptionsDataRecord(t *testing.T) {
+	createElements := func() []*InfoElementWithValue {
+		return []*InfoElementWithValue{
+			NewInfoElementWithValue(NewInfoElement("exportingProcessId", 144, 3, 0, 4), bytes.NewBuffer([]byte{0, 0, 0, 7})),
+			NewInfoElementWithValue(NewInfoElement("unsupportedElement", 1, 16, 99999, 8), bytes.NewBuffer([]byte{0, 0, 0, 0, 0, 0, 0, 0})),
+			NewInfoElementWithValue(NewInfoElement("exportedMessageTotalCount", 41, 4, 0, 8), bytes.NewBuffer([]byte{0, 0, 0, 0, 0, 0, 0, 100})),
+		}
+	}
+	elements := createElements()
+	newSet := NewSet(true)
+	_ = newSet.PrepareSet(Template, testTemplateID)
+	assert.Error(t, newSet.AddOptionsDataRecord(elements, 1, testTemplateID))
+	newSet.ResetSet()
+	_ = newSet.PrepareSet(Data, testTemplateID)
+	assert.Error(t, newSet.AddOptionsDataRecord(elements, 0, testTemplateID))
+	assert.NoError(t, newSet.AddOptionsDataRecord(elements[:1], 1, testTemplateID))
+	record := newSet.GetRecords()[0]
+	assert.Equal(t, uint16(1), record.GetScopeFieldCount())
+	scopeElements := record.GetScopeElements()
+	assert.Len(t, scopeElements, 1)
+	assert.Equal(t, "exportingProcessId", scopeElements[0].Element.Name)
+	assert.Equal(t, uint32(7), scopeElements[0].Value)
+	// The scope elements left out when decoding are not counted.
+	assert.NoError(t, newSet.AddOptionsDataRecord(createElements(), 2, testTemplateID))
+	record = newSet.GetRecords()[1]
+	assert.Equal(t, uint16(1), record.GetScopeFieldCount())
+	assert.Len(t, record.GetScopeElements(), 1)
+	assert.Len(t, record.GetOrderedElementList(), 2)
+	// Records of templates have no scope elements.
+	assert.NoError(t, newSet.AddRecord(createElements()[:1], testTemplateID))
+	assert.Nil(t, newSet.GetRecords()[2].GetScopeElements())
+}
+
+func TestCopySet(t *testing.T) {
+	ie := NewInfoElementWithValue(NewInfoElement("sourceIPv4Address", 8, 18, 0, 4), net.ParseIP("10.0.0.1"))
+	encodingSet := NewSet(false)
+	assert.NoError(t, encodingSet.PrepareSet(Data, testTemplateID))
+	assert.NoError(t, encodingSet.AddRecord([]*InfoElementWithValue{ie}, testTemplateID))
+	encodingSet.UpdateLenInHeader()
+	setCopy := CopySet(encodingSet)
+	expectedBytes := append([]byte{}, encodingSet.GetBuffer().Bytes()...)
+
+	// Reusing the set does not change the copy.
+	encodingSet.ResetSet()
+	ie.Value = net.ParseIP("10.0.0.2")
+	assert.NoError(t, encodingSet.PrepareSet(Data, testTemplateID))
+	assert.NoError(t, encodingSet.AddRecord([]*InfoElementWithValue{ie}, testTemplateID))
+	assert.Equal(t, expectedBytes, setCopy.GetBuffer().Bytes())
+	assert.Equal(t, Data, setCopy.GetSetType())
+	assert.Equal(t, uint32(1), setCopy.GetNumberOfRecords())
+}
@@ -134,6 +134,34 @@ func (mr *MockRecordMockRecorder) GetOrderedElementList() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderedElementList", reflect.TypeOf((*MockRecord)(nil).GetOrderedElementList))
 }
 
+// GetScopeElements mocks base method
+func (m *MockRecord) GetScopeElements() []*entities.InfoElementWithValue {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetScopeElements")
+	ret0, _ := ret[0].([]*entities.InfoElementWithValue)
+	return ret0
+}
+
+// GetScopeElements indicates an expected call of GetScopeElements
+func (mr *MockRecordMockRecorder) GetScopeElements() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetScopeElements", reflect.TypeOf((*MockRecord)(nil).GetScopeElements))
+}
+
+// GetScopeFieldCount mocks base method
+func (m *MockRecord) GetScopeFieldCount() uint16 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetScopeFieldCount")
+	ret0, _ := ret[0].(uint16)
+	return ret0
+}
+
+// GetScopeFieldCount indicates an expected call of GetScopeFieldCount
+func (mr *MockRecordMockRecorder) GetScopeFieldCount() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetScopeFieldCount", reflect.TypeOf((*MockRecord)(nil).GetScopeFieldCount))
+}
+
 // GetTemplateID mocks base method
 func (m *MockRecord) GetTemplateID() uint16 {
 	m.ctrl.T.Helper()
@@ -148,6 +176,33 @@ func (mr *MockRecordMockRecorder) GetTemplateID() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTemplateID", reflect.TypeOf((*MockRecord)(nil).GetTemplateID))
 }
 
+// Materialize mocks base method
+func (m *MockRecord) Materialize() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Materialize")
+}
+
+// Materialize indicates an expected call of Materialize
+func (mr *MockRecordMockRecorder) Materialize() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Materialize", reflect.TypeOf((*MockRecord)(nil).Materialize))
+}
+
+// GetReverseInfoElementWithValue mocks base method
+func (m *MockRecord) GetReverseInfoElementWithValue(arg0 string) (*entities.InfoElementWithValue, bool) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetReverseInfoElementWithValue", arg0)
+	ret0, _ := ret[0].(*entities.InfoElementWithValue)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// GetReverseInfoElementWithValue indicates an expected call of GetReverseInfoElementWithValue
+func (mr *MockRecordMockRecorder) GetReverseInfoElementWithValue(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetReverseInfoElementWithValue", reflect.TypeOf((*MockRecord)(nil).GetReverseInfoElementWithValue), arg0)
+}
+
 // PrepareRecord mocks base method
 func (m *MockRecord) PrepareRecord() (uint16, error) {
 	m.ctrl.T.Helper()
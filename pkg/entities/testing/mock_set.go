@@ -48,6 +48,34 @@ func (m *MockSet) EXPECT() *MockSetMockRecorder {
 	return m.recorder
 }
 
+// AddOptionsDataRecord mocks base method
+func (m *MockSet) AddOptionsDataRecord(arg0 []*entities.InfoElementWithValue, arg1, arg2 uint16) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddOptionsDataRecord", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddOptionsDataRecord indicates an expected call of AddOptionsDataRecord
+func (mr *MockSetMockRecorder) AddOptionsDataRecord(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddOptionsDataRecord", reflect.TypeOf((*MockSet)(nil).AddOptionsDataRecord), arg0, arg1, arg2)
+}
+
+// AddOptionsTemplateRecord mocks base method
+func (m *MockSet) AddOptionsTemplateRecord(arg0 []*entities.InfoElementWithValue, arg1, arg2 uint16) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddOptionsTemplateRecord", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddOptionsTemplateRecord indicates an expected call of AddOptionsTemplateRecord
+func (mr *MockSetMockRecorder) AddOptionsTemplateRecord(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddOptionsTemplateRecord", reflect.TypeOf((*MockSet)(nil).AddOptionsTemplateRecord), arg0, arg1, arg2)
+}
+
 // AddRecord mocks base method
 func (m *MockSet) AddRecord(arg0 []*entities.InfoElementWithValue, arg1 uint16) error {
 	m.ctrl.T.Helper()
@@ -0,0 +1,70 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entities
+
+import (
+	"bytes"
+)
+
+// ValueView is the undecoded value of an element in a data record decoded in
+// zero-copy mode: the bytes of the value in the received message, without the
+// length prefix of variable-length elements. It shares the memory of the
+// message, so it must not be modified, and it is only valid as long as the
+// message buffer is not reused. Records are added with ValueView values when
+// decoding, and Materialize replaces them with decoded values.
+type ValueView []byte
+
+// Materialize decodes the ValueView values of the record into values of the
+// data types of their elements, which no longer share the memory of the
+// received message. Elements whose data type is not supported, e.g.
+// dateTimeMicroseconds, are removed from the record, as when decoding records
+// without zero-copy.
+func (d *dataRecord) Materialize() {
+	elements := d.orderedElementList[:0]
+	scopeFieldCount := d.scopeFieldCount
+	for i, ie := range d.orderedElementList {
+		view, ok := ie.Value.(ValueView)
+		if !ok {
+			elements = append(elements, ie)
+			continue
+		}
+		value, err := DecodeElementValue(ie.Element, bytes.NewBuffer(append([]byte(nil), view...)))
+		if err != nil {
+			delete(d.elementsMap, ie.Element.Name)
+			d.fieldCount--
+			if i < int(d.scopeFieldCount) {
+				scopeFieldCount--
+			}
+			continue
+		}
+		ie.Value = value
+		elements = append(elements, ie)
+	}
+	d.orderedElementList = elements
+	d.scopeFieldCount = scopeFieldCount
+}
+
+// Materialize does nothing, as template records have no values.
+func (t *templateRecord) Materialize() {}
+
+// Materialize decodes the ValueView values of all the records of the message.
+func (m *Message) Materialize() {
+	if m.set == nil {
+		return
+	}
+	for _, record := range m.set.GetRecords() {
+		record.Materialize()
+	}
+}
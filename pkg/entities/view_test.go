@@ -0,0 +1,58 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entities
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaterialize(t *testing.T) {
+	packet := []byte{0, 0, 0, 0, 0, 0, 0, 1, 10, 0, 0, 1, 'w', 'a', 'n', '0', 0, 0, 0, 7}
+	elements := []*InfoElementWithValue{
+		NewInfoElementWithValue(NewInfoElement("flowStartMicroseconds", 154, DateTimeMicroseconds, 0, 8), ValueView(packet[0:8])),
+		NewInfoElementWithValue(NewInfoElement("sourceIPv4Address", 8, Ipv4Address, 0, 4), ValueView(packet[8:12])),
+		NewInfoElementWithValue(NewInfoElement("interfaceDescription", 83, String, 0, VariableLength), ValueView(packet[12:16])),
+		NewInfoElementWithValue(NewInfoElement("exportingProcessId", 144, Unsigned32, 0, 4), ValueView(packet[16:20])),
+	}
+	set := NewSet(true)
+	_ = set.PrepareSet(Data, 256)
+	assert.NoError(t, set.AddOptionsDataRecord(elements, 2, 256))
+	message := NewMessage(true)
+	message.AddSet(set)
+	record := set.GetRecords()[0]
+	// Values are views of the message until they are materialized.
+	ie, _ := record.GetInfoElementWithValue("sourceIPv4Address")
+	assert.Equal(t, ValueView{10, 0, 0, 1}, ie.Value)
+	assert.Equal(t, uint16(4), record.GetFieldCount())
+
+	message.Materialize()
+	packet[8] = 192
+	ie, _ = record.GetInfoElementWithValue("sourceIPv4Address")
+	assert.Equal(t, net.IP{10, 0, 0, 1}, ie.Value)
+	ie, _ = record.GetInfoElementWithValue("interfaceDescription")
+	assert.Equal(t, "wan0", ie.Value)
+	ie, _ = record.GetInfoElementWithValue("exportingProcessId")
+	assert.Equal(t, uint32(7), ie.Value)
+	// flowStartMicroseconds is not supported, so it is removed.
+	_, exist := record.GetInfoElementWithValue("flowStartMicroseconds")
+	assert.False(t, exist)
+	assert.Equal(t, 3, len(record.GetOrderedElementList()))
+	assert.Equal(t, uint16(3), record.GetFieldCount())
+	assert.Equal(t, uint16(1), record.GetScopeFieldCount())
+	assert.Equal(t, "sourceIPv4Address", record.GetScopeElements()[0].Element.Name)
+}
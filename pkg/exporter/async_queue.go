@@ -0,0 +1,173 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+const defaultAsyncFlushInterval = time.Second
+
+// AsyncQueueStats contains the state of the asynchronous send queue of the
+// exporting process.
+type AsyncQueueStats struct {
+	// QueuedSets is the number of sets waiting in the queue.
+	QueuedSets int
+	// DroppedSets is the number of data sets dropped because the queue was
+	// full.
+	DroppedSets uint64
+	// SendErrors is the number of queued sets which could not be sent.
+	SendErrors uint64
+}
+
+// asyncQueue is a bounded queue of sets sent by a background goroutine.
+type asyncQueue struct {
+	mutex sync.Mutex
+	sets  []entities.Set
+	// maxSets is the capacity of the queue for data sets. Template sets are
+	// queued even when it is full, as the data sets following them cannot be
+	// decoded without them.
+	maxSets       int
+	flushSize     int
+	flushInterval time.Duration
+	// sendMutex serializes the flushes, so that the sets are sent in order.
+	sendMutex  sync.Mutex
+	wakeCh     chan struct{}
+	dropped    uint64
+	sendErrors uint64
+}
+
+func newAsyncQueue(maxSets, flushSize int, flushInterval time.Duration) *asyncQueue {
+	if flushSize <= 0 || flushSize > maxSets {
+		flushSize = 1
+	}
+	if flushInterval <= 0 {
+		flushInterval = defaultAsyncFlushInterval
+	}
+	return &asyncQueue{
+		maxSets:       maxSets,
+		flushSize:     flushSize,
+		flushInterval: flushInterval,
+		wakeCh:        make(chan struct{}, 1),
+	}
+}
+
+// push adds a copy of the set at the end of the queue, and returns an error if
+// the queue is full.
+func (q *asyncQueue) push(set entities.Set) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if set.GetSetType() == entities.Data && len(q.sets) >= q.maxSets {
+		q.dropped++
+		return fmt.Errorf("send queue is full (%d sets), dropping data set", len(q.sets))
+	}
+	q.sets = append(q.sets, entities.CopySet(set))
+	if len(q.sets) >= q.flushSize {
+		select {
+		case q.wakeCh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// popAll removes all the sets from the queue and returns them in order.
+func (q *asyncQueue) popAll() []entities.Set {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	sets := q.sets
+	q.sets = nil
+	return sets
+}
+
+func (q *asyncQueue) getStats() AsyncQueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return AsyncQueueStats{
+		QueuedSets:  len(q.sets),
+		DroppedSets: q.dropped,
+		SendErrors:  atomic.LoadUint64(&q.sendErrors),
+	}
+}
+
+// GetAsyncQueueStats returns the state of the asynchronous send queue. It is
+// empty if asynchronous sending is not enabled.
+func (ep *ExportingProcess) GetAsyncQueueStats() AsyncQueueStats {
+	if ep.asyncQueue == nil {
+		return AsyncQueueStats{}
+	}
+	return ep.asyncQueue.getStats()
+}
+
+// queueSet adds the set to the asynchronous send queue, and returns the length
+// of the message in which it will be sent.
+func (ep *ExportingProcess) queueSet(set entities.Set) (int, error) {
+	if set.GetSetType() == entities.Undefined {
+		return 0, fmt.Errorf("set type is not properly defined")
+	}
+	set.UpdateLenInHeader()
+	if err := ep.asyncQueue.push(set); err != nil {
+		return 0, err
+	}
+	return entities.MsgHeaderLength + set.GetBuffer().Len(), nil
+}
+
+// Flush sends the sets waiting in the asynchronous send queue, and returns
+// once they are sent. It returns the last error when sending them, if any. It
+// does nothing if asynchronous sending is not enabled.
+func (ep *ExportingProcess) Flush() error {
+	if ep.asyncQueue == nil {
+		return nil
+	}
+	return ep.flushAsyncQueue()
+}
+
+func (ep *ExportingProcess) flushAsyncQueue() error {
+	q := ep.asyncQueue
+	q.sendMutex.Lock()
+	defer q.sendMutex.Unlock()
+	var lastErr error
+	for _, set := range q.popAll() {
+		if _, err := ep.sendSet(set, nil); err != nil {
+			atomic.AddUint64(&q.sendErrors, 1)
+			klog.V(2).Infof("Error when sending queued set: %v", err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// runAsyncQueue sends the queued sets when the queue reaches the flush size,
+// and every flush interval, until the exporting process is closed.
+func (ep *ExportingProcess) runAsyncQueue() {
+	ticker := time.NewTicker(ep.asyncQueue.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ep.templateRefCh:
+			return
+		case <-ep.asyncQueue.wakeCh:
+		case <-ticker.C:
+		}
+		ep.flushAsyncQueue()
+	}
+}
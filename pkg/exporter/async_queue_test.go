@@ -0,0 +1,131 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestExportingProcess_AsyncQueue(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	defer listener.Close()
+	// The template message (28 bytes) and two data messages (24 bytes).
+	buffCh := make(chan []byte, 1)
+	go acceptMessages(listener, 76, buffCh)
+
+	exporter, err := InitExportingProcess(ExporterInput{
+		CollectorAddress:    listener.Addr().String(),
+		CollectorProtocol:   listener.Addr().Network(),
+		ObservationDomainID: 1,
+		AsyncQueueSize:      3,
+		AsyncFlushSize:      3,
+		AsyncFlushInterval:  time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", listener.Addr().String(), err)
+	}
+	defer exporter.CloseConnToCollector()
+
+	// The queued sets are not sent while the queue is being flushed.
+	exporter.asyncQueue.sendMutex.Lock()
+	templateID := exporter.NewTemplateID()
+	element, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	set := entities.NewSet(false)
+	assert.NoError(t, set.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, set.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, nil)}, templateID))
+	bytesQueued, err := exporter.SendSet(set)
+	assert.NoError(t, err)
+	assert.Equal(t, 28, bytesQueued)
+	// The set can be reused once it is queued.
+	for _, srcIP := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		set.ResetSet()
+		assert.NoError(t, set.PrepareSet(entities.Data, templateID))
+		assert.NoError(t, set.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, net.ParseIP(srcIP))}, templateID))
+		bytesQueued, err = exporter.SendSet(set)
+		if srcIP == "10.0.0.3" {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, 24, bytesQueued)
+		}
+	}
+	assert.Equal(t, AsyncQueueStats{QueuedSets: 3, DroppedSets: 1}, exporter.GetAsyncQueueStats())
+	exporter.asyncQueue.sendMutex.Unlock()
+
+	// The queue reached the flush size, so the sets are sent without waiting
+	// for the flush interval.
+	var buff []byte
+	select {
+	case buff = <-buffCh:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Queued sets were not sent")
+	}
+	assert.Equal(t, entities.TemplateSetID, binary.BigEndian.Uint16(buff[16:18]))
+	assert.Equal(t, []byte{10, 0, 0, 1}, buff[48:52])
+	assert.Equal(t, []byte{10, 0, 0, 2}, buff[72:76])
+	assert.Equal(t, AsyncQueueStats{DroppedSets: 1}, exporter.GetAsyncQueueStats())
+	assert.NoError(t, exporter.Flush())
+}
+
+func TestExportingProcess_AsyncQueueFlushOnClose(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	defer listener.Close()
+	buffCh := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// The template message is received before the connection is closed.
+		buff, _ := ioutil.ReadAll(conn)
+		buffCh <- buff
+	}()
+
+	exporter, err := InitExportingProcess(ExporterInput{
+		CollectorAddress:    listener.Addr().String(),
+		CollectorProtocol:   listener.Addr().Network(),
+		ObservationDomainID: 1,
+		AsyncQueueSize:      10,
+		AsyncFlushSize:      10,
+		AsyncFlushInterval:  time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", listener.Addr().String(), err)
+	}
+	element, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	set := entities.NewSet(false)
+	assert.NoError(t, set.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, set.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, nil)}, exporter.NewTemplateID()))
+	_, err = exporter.SendSet(set)
+	assert.NoError(t, err)
+	exporter.CloseConnToCollector()
+	assert.Equal(t, 28, len(<-buffCh))
+}
@@ -0,0 +1,208 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// BatchRecord is a data record sent by SendBatch.
+type BatchRecord struct {
+	TemplateID uint16
+	Elements   []*entities.InfoElementWithValue
+}
+
+// BatchFailure describes records of a batch which were not sent.
+type BatchFailure struct {
+	// Start and End are the indexes of the first record which was not sent
+	// and of the record following the last one.
+	Start int
+	End   int
+	Err   error
+}
+
+// BatchResult summarizes the export of a batch by SendBatch.
+type BatchResult struct {
+	MessagesSent int
+	RecordsSent  int
+	BytesSent    int
+	// RecordsSkipped is the number of records in sets dropped by pre-send
+	// hooks.
+	RecordsSkipped int
+	// Failures are the records which were not sent, in order.
+	Failures []BatchFailure
+}
+
+// SendBatch sends the data records in as few messages as possible, every
+// message containing a single data set of consecutive records with the same
+// template, or several sets with ExporterInput.PackMessages. All messages are sent in order on the same connection, so that the
+// collector receives the records in the order of the batch.
+//
+// A record which cannot be encoded, or does not match its template, is
+// reported as a failure and the following records are still sent. If sending
+// a message fails, the remaining records are reported as a single failure and
+// not sent, so that the records received by the collector never have gaps
+// other than the reported records. An error is returned if any record was not
+// sent; the result tells which ones.
+func (ep *ExportingProcess) SendBatch(records []BatchRecord) (BatchResult, error) {
+	result := BatchResult{}
+	if len(records) == 0 {
+		return result, nil
+	}
+	conn, err := ep.nextConn()
+	if err != nil {
+		result.Failures = append(result.Failures, BatchFailure{0, len(records), err})
+		return result, batchError(result, len(records))
+	}
+	packer := ep.newMessagePacker(conn)
+	// indexes are the indexes in the batch of the records in the current set,
+	// and msgIndexes those of the records in the current message.
+	indexes := make([]int, 0)
+	msgIndexes := make([]int, 0)
+	var dataSet entities.Set
+	setLen := 0
+	sendMessage := func() error {
+		bytesSent, err := packer.flush()
+		if err != nil {
+			return err
+		}
+		if bytesSent > 0 {
+			result.MessagesSent++
+			result.RecordsSent += len(msgIndexes)
+			result.BytesSent += bytesSent
+		}
+		msgIndexes = msgIndexes[:0]
+		return nil
+	}
+	// addDataSet adds the current set to the current message, which is sent
+	// right away unless messages are packed.
+	addDataSet := func() error {
+		added, err := packer.addSet(dataSet)
+		if err != nil {
+			return err
+		}
+		if added {
+			msgIndexes = append(msgIndexes, indexes...)
+		} else {
+			result.RecordsSkipped += len(indexes)
+		}
+		indexes = indexes[:0]
+		if !ep.packMessages {
+			return sendMessage()
+		}
+		return nil
+	}
+	// pendingStart returns the index of the first record which was not sent.
+	pendingStart := func() int {
+		if len(msgIndexes) > 0 {
+			return msgIndexes[0]
+		}
+		return indexes[0]
+	}
+	// abort reports the records from start as not sent. Failures already
+	// reported for some of them are replaced.
+	abort := func(start int, err error) (BatchResult, error) {
+		for len(result.Failures) > 0 && result.Failures[len(result.Failures)-1].Start >= start {
+			result.Failures = result.Failures[:len(result.Failures)-1]
+		}
+		result.Failures = append(result.Failures, BatchFailure{start, len(records), err})
+		return result, batchError(result, len(records))
+	}
+	for i, record := range records {
+		recordLen, err := ep.checkBatchRecord(record)
+		if err != nil {
+			result.Failures = append(result.Failures, BatchFailure{i, i + 1, err})
+			continue
+		}
+		// The set is split when the record does not fit in the message.
+		if len(indexes) > 0 && (record.TemplateID != records[indexes[0]].TemplateID || !packer.fits(setLen+recordLen)) {
+			if err := addDataSet(); err != nil {
+				return abort(pendingStart(), err)
+			}
+		}
+		if len(indexes) == 0 && !packer.fits(entities.SetHeaderLen+recordLen) {
+			if err := sendMessage(); err != nil {
+				return abort(pendingStart(), err)
+			}
+		}
+		if len(indexes) == 0 {
+			dataSet = entities.NewSet(false)
+			if err := dataSet.PrepareSet(entities.Data, record.TemplateID); err != nil {
+				return abort(i, err)
+			}
+			setLen = entities.SetHeaderLen
+		}
+		if err := dataSet.AddRecord(record.Elements, record.TemplateID); err != nil {
+			result.Failures = append(result.Failures, BatchFailure{i, i + 1, err})
+			continue
+		}
+		indexes = append(indexes, i)
+		setLen += recordLen
+	}
+	if len(indexes) > 0 {
+		if err := addDataSet(); err != nil {
+			return abort(pendingStart(), err)
+		}
+	}
+	if err := sendMessage(); err != nil {
+		return abort(pendingStart(), err)
+	}
+	return result, batchError(result, len(records))
+}
+
+// checkBatchRecord encodes the record to check it against its template, and
+// returns its encoded length.
+func (ep *ExportingProcess) checkBatchRecord(record BatchRecord) (int, error) {
+	dataRecord := entities.NewDataRecord(record.TemplateID)
+	if _, err := dataRecord.PrepareRecord(); err != nil {
+		return 0, err
+	}
+	for _, element := range record.Elements {
+		if _, err := dataRecord.AddInfoElement(element, false); err != nil {
+			return 0, fmt.Errorf("error when encoding element %s: %v", element.Element.Name, err)
+		}
+	}
+	if err := ep.dataRecSanityCheck(dataRecord); err != nil {
+		return 0, err
+	}
+	recordLen := dataRecord.GetBuffer().Len()
+	if entities.SetHeaderLen+recordLen > ep.getMaxSetLen() {
+		return 0, fmt.Errorf("record length %d exceeds the message size limit", recordLen)
+	}
+	return recordLen, nil
+}
+
+// getMaxSetLen returns the maximum length of a set sent in a message.
+func (ep *ExportingProcess) getMaxSetLen() int {
+	maxSetLen := ep.GetMsgSizeLimit() - entities.MsgHeaderLength
+	if ep.padSets {
+		// The padded set has to fit in the message too.
+		maxSetLen -= maxSetLen % setAlignment
+	}
+	return maxSetLen
+}
+
+func batchError(result BatchResult, numRecords int) error {
+	if len(result.Failures) == 0 {
+		return nil
+	}
+	failed := 0
+	for _, failure := range result.Failures {
+		failed += failure.End - failure.Start
+	}
+	return fmt.Errorf("%d of %d records were not sent, first error: %v", failed, numRecords, result.Failures[0].Err)
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestExportingProcess_SendBatch(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	defer listener.Close()
+	// messagesCh receives the data sets of the data messages.
+	messagesCh := make(chan []byte, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			header := make([]byte, entities.MsgHeaderLength)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				close(messagesCh)
+				return
+			}
+			body := make([]byte, int(binary.BigEndian.Uint16(header[2:4]))-entities.MsgHeaderLength)
+			if _, err := io.ReadFull(conn, body); err != nil {
+				close(messagesCh)
+				return
+			}
+			if binary.BigEndian.Uint16(body[0:2]) != entities.TemplateSetID {
+				messagesCh <- append(header, body...)
+			}
+		}
+	}()
+
+	input := ExporterInput{
+		CollectorAddress:    listener.Addr().String(),
+		CollectorProtocol:   listener.Addr().Network(),
+		ObservationDomainID: 1,
+	}
+	exporter, err := InitExportingProcess(input)
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", listener.Addr().String(), err)
+	}
+
+	templateID := exporter.NewTemplateID()
+	element, _ := registry.GetInfoElement("packetDeltaCount", registry.IANAEnterpriseID)
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, nil)}, templateID))
+	_, err = exporter.SendSet(templateSet)
+	assert.NoError(t, err)
+
+	// 10000 records of 8 bytes do not fit in a single TCP message.
+	numRecords := 10000
+	records := make([]BatchRecord, numRecords)
+	for i := range records {
+		records[i] = BatchRecord{templateID, []*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, uint64(i))}}
+	}
+	// Records with an unknown template or invalid values are reported.
+	records[5].TemplateID = templateID + 1
+	records[6].Elements[0].Value = "invalid"
+	result, err := exporter.SendBatch(records)
+	assert.Error(t, err)
+	assert.Equal(t, 2, result.MessagesSent)
+	assert.Equal(t, numRecords-2, result.RecordsSent)
+	assert.Equal(t, []BatchFailure{{5, 6, result.Failures[0].Err}, {6, 7, result.Failures[1].Err}}, result.Failures)
+
+	next := uint64(0)
+	seqNumber := uint32(0)
+	for i := 0; i < result.MessagesSent; i++ {
+		message := <-messagesCh
+		assert.LessOrEqual(t, len(message), entities.MaxTcpSocketMsgSize)
+		numRecordsInMsg := uint32((len(message) - entities.MsgHeaderLength - entities.SetHeaderLen) / 8)
+		seqNumber += numRecordsInMsg
+		assert.Equal(t, seqNumber, binary.BigEndian.Uint32(message[8:12]))
+		for offset := entities.MsgHeaderLength + entities.SetHeaderLen; offset < len(message); offset += 8 {
+			if next == 5 {
+				next = 7
+			}
+			assert.Equal(t, next, binary.BigEndian.Uint64(message[offset:offset+8]))
+			next++
+		}
+	}
+	assert.Equal(t, uint64(numRecords), next)
+
+	// The remaining records are reported as a single failure when a message
+	// cannot be sent.
+	exporter.CloseConnToCollector()
+	result, err = exporter.SendBatch(records[7:])
+	assert.Error(t, err)
+	assert.Equal(t, 0, result.RecordsSent)
+	assert.Equal(t, 1, len(result.Failures))
+	assert.Equal(t, 0, result.Failures[0].Start)
+	assert.Equal(t, numRecords-7, result.Failures[0].End)
+}
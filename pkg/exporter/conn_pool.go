@@ -0,0 +1,212 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+const (
+	connHealthy uint32 = iota
+	connUnhealthy
+	// connRestoring is the state of a restored connection until the templates
+	// have been sent again on it. Only templates and type records are sent on
+	// it.
+	connRestoring
+)
+
+// collectorConn is one connection to the collector. Every connection is a
+// separate transport session, so it has its own sequence number and needs its
+// own copy of the templates.
+type collectorConn struct {
+	conn net.Conn
+	// mutex serializes the messages sent on the connection, so that they are
+	// sent in the order of their sequence numbers.
+	mutex sync.Mutex
+	// seqNumbers are the sequence numbers of the observation domains.
+	seqNumbers map[uint32]uint32
+	// connMutex protects conn when it is read without mutex, so that reading
+	// it does not wait for a message being sent. Both are locked to replace
+	// the connection.
+	connMutex sync.RWMutex
+	// state is set to connUnhealthy after an error when sending on the
+	// connection. The connection is not used anymore afterwards, unless it is
+	// restored (see ExporterInput.SpoolDir and ExporterInput.Reconnect).
+	state        uint32
+	messagesSent uint64
+	sendErrors   uint64
+}
+
+// ConnStats contains the state of a connection of the exporting process to
+// the collector.
+type ConnStats struct {
+	LocalAddress string
+	Healthy      bool
+	MessagesSent uint64
+	SendErrors   uint64
+}
+
+func newCollectorConn(conn net.Conn) *collectorConn {
+	return &collectorConn{
+		conn:       conn,
+		seqNumbers: make(map[uint32]uint32),
+	}
+}
+
+func (c *collectorConn) isHealthy() bool {
+	return atomic.LoadUint32(&c.state) == connHealthy
+}
+
+// getConn returns the current connection, which is replaced when the
+// connection is restored.
+func (c *collectorConn) getConn() net.Conn {
+	c.connMutex.RLock()
+	defer c.connMutex.RUnlock()
+	return c.conn
+}
+
+// restore replaces the failed connection with a new one. It is marked as
+// healthy by completeRestore, once the templates have been sent on it.
+func (c *collectorConn) restore(conn net.Conn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	c.conn.Close()
+	c.conn = conn
+	atomic.StoreUint32(&c.state, connRestoring)
+}
+
+// completeRestore marks the restored connection as healthy, and returns false
+// if sending the templates on it failed.
+func (c *collectorConn) completeRestore() bool {
+	return atomic.CompareAndSwapUint32(&c.state, connRestoring, connHealthy)
+}
+
+// send sets the sequence number of the message, which is the one of its
+// observation domain incremented by the given number of data records, and
+// sends the message followed by the encoded set.
+func (c *collectorConn) send(msg *entities.Message, setBytes []byte, numRecords uint32) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// The connection may have failed or be restored since it was selected,
+	// and data must not be sent before the templates on a new connection.
+	if numRecords > 0 && !c.isHealthy() {
+		return 0, fmt.Errorf("connection %s to the collector is not healthy", c.conn.LocalAddr())
+	}
+	// The observation domain is read from the header, as spooled messages
+	// are restored from their bytes.
+	obsDomainID := binary.BigEndian.Uint32(msg.GetMsgBuffer().Bytes()[12:16])
+	seqNumber := c.seqNumbers[obsDomainID] + numRecords
+	c.seqNumbers[obsDomainID] = seqNumber
+	msg.SetSequenceNum(seqNumber)
+
+	// Append the byte slices together to send on the exporter connection rather
+	// than copying the set buffer to message buffer again.
+	bytesSlice := append(msg.GetMsgBuffer().Bytes(), setBytes...)
+	bytesSent, err := c.conn.Write(bytesSlice)
+	if err == nil && bytesSent != int(msg.GetMessageLen()) {
+		err = fmt.Errorf("could not send the complete message on the connection")
+	} else if err != nil {
+		err = fmt.Errorf("error when sending message on the connection: %v", err)
+	}
+	if err != nil {
+		atomic.AddUint64(&c.sendErrors, 1)
+		if atomic.SwapUint32(&c.state, connUnhealthy) != connUnhealthy {
+			klog.Errorf("Marking connection %s to the collector as unhealthy: %v", c.conn.LocalAddr(), err)
+		}
+		return bytesSent, err
+	}
+	atomic.AddUint64(&c.messagesSent, 1)
+	return bytesSent, nil
+}
+
+// nextConn returns the next healthy connection in round-robin order.
+func (ep *ExportingProcess) nextConn() (*collectorConn, error) {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	for i := 0; i < len(ep.conns); i++ {
+		c := ep.conns[ep.nextConnIndex]
+		ep.nextConnIndex = (ep.nextConnIndex + 1) % len(ep.conns)
+		if c.isHealthy() {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("no healthy connection to the collector")
+}
+
+// sendOnAllConns sends the message on every healthy or restoring connection.
+// It is used for templates and type records, which have to be known in every
+// transport session. It succeeds if the message is sent on at least one
+// connection.
+func (ep *ExportingProcess) sendOnAllConns(msg *entities.Message, setBytes []byte) (int, error) {
+	var bytesSent int
+	var lastErr error
+	sent := false
+	for _, c := range ep.conns {
+		if atomic.LoadUint32(&c.state) == connUnhealthy {
+			continue
+		}
+		n, err := c.send(msg, setBytes, 0)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		bytesSent, sent = n, true
+	}
+	if !sent {
+		if lastErr != nil {
+			return 0, lastErr
+		}
+		return 0, fmt.Errorf("no healthy connection to the collector")
+	}
+	return bytesSent, nil
+}
+
+// GetConnStats returns the state of every connection to the collector.
+func (ep *ExportingProcess) GetConnStats() []ConnStats {
+	stats := make([]ConnStats, len(ep.conns))
+	for i, c := range ep.conns {
+		stats[i] = ConnStats{
+			LocalAddress: c.getConn().LocalAddr().String(),
+			Healthy:      c.isHealthy(),
+			MessagesSent: atomic.LoadUint64(&c.messagesSent),
+			SendErrors:   atomic.LoadUint64(&c.sendErrors),
+		}
+	}
+	return stats
+}
+
+// GetSeqNumber returns the sequence number of the observation domain in the
+// transport session, i.e. the number of data records sent to the collector.
+// With multiple connections, it is the sum of the sequence numbers of their
+// sessions.
+func (ep *ExportingProcess) GetSeqNumber() uint32 {
+	var seqNumber uint32
+	for _, c := range ep.conns {
+		c.mutex.Lock()
+		seqNumber += c.seqNumbers[ep.obsDomainID]
+		c.mutex.Unlock()
+	}
+	return seqNumber
+}
@@ -0,0 +1,136 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// NewObservationDomain returns an exporting process for another observation
+// domain, which shares the connections to the collector of this exporting
+// process, e.g. to export flows on behalf of several observation points. It
+// has its own sequence numbers and templates, whose IDs can be the same as in
+// other observation domains, and uses the options of this exporting process
+// except StateFile and LatencyProbeInterval. The templates of all the
+// observation domains are sent again when connections are restored.
+//
+// Closing the returned exporting process does not close the connections. They
+// are closed by CloseConnToCollector of this exporting process, which closes
+// all its observation domains.
+func (ep *ExportingProcess) NewObservationDomain(obsDomainID uint32) (*ExportingProcess, error) {
+	if ep.parent != nil {
+		return ep.parent.NewObservationDomain(obsDomainID)
+	}
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	if _, exist := ep.domains[obsDomainID]; exist || obsDomainID == ep.obsDomainID {
+		return nil, fmt.Errorf("observation domain %d already exists", obsDomainID)
+	}
+	domain := &ExportingProcess{
+		conns:              ep.conns,
+		obsDomainID:        obsDomainID,
+		templateID:         startTemplateID,
+		pathMTU:            ep.pathMTU,
+		templatesMap:       make(map[uint16]templateValue),
+		templateRefCh:      make(chan struct{}),
+		typeRecordsEnabled: ep.typeRecordsEnabled,
+		announcedElements:  make(map[typeRecordKey]*entities.InfoElement),
+		preSendHooks:       append([]PreSendHook{}, ep.preSendHooks...),
+		stableTemplateIDs:  make(map[string]uint16),
+		padSets:            ep.padSets,
+		spool:              ep.spool,
+		dial:               ep.dial,
+		refreshTemplates:   ep.refreshTemplates,
+		refreshInterval:    ep.refreshInterval,
+		refreshPackets:     ep.refreshPackets,
+		templateRefreshes:  make(map[uint16]*templateRefresh),
+		refreshWakeCh:      make(chan struct{}, 1),
+		failover:           ep.failover,
+		maxMessageSize:     ep.maxMessageSize,
+		packMessages:       ep.packMessages,
+		rateLimiter:        ep.rateLimiter,
+		parent:             ep,
+	}
+	if ep.asyncQueue != nil {
+		domain.asyncQueue = newAsyncQueue(ep.asyncQueue.maxSets, ep.asyncQueue.flushSize, ep.asyncQueue.flushInterval)
+		go domain.runAsyncQueue()
+	}
+	if domain.refreshTemplates {
+		go domain.runTemplateRefresh()
+	}
+	ep.domains[obsDomainID] = domain
+	return domain, nil
+}
+
+// getObservationDomains returns the additional observation domains, ordered
+// by ID.
+func (ep *ExportingProcess) getObservationDomains() []*ExportingProcess {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	domains := make([]*ExportingProcess, 0, len(ep.domains))
+	for _, domain := range ep.domains {
+		domains = append(domains, domain)
+	}
+	sort.Slice(domains, func(i, j int) bool {
+		return domains[i].obsDomainID < domains[j].obsDomainID
+	})
+	return domains
+}
+
+// getObservationDomain returns the exporting process of the observation
+// domain, or nil if it does not exist.
+func (ep *ExportingProcess) getObservationDomain(obsDomainID uint32) *ExportingProcess {
+	if obsDomainID == ep.obsDomainID {
+		return ep
+	}
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	return ep.domains[obsDomainID]
+}
+
+// closeObservationDomain sends the queued sets of the additional observation
+// domain and stops its background tasks, without closing the connections.
+func (ep *ExportingProcess) closeObservationDomain() {
+	if isChanClosed(ep.templateRefCh) {
+		return
+	}
+	if ep.asyncQueue != nil {
+		if err := ep.flushAsyncQueue(); err != nil {
+			klog.Errorf("Error when sending queued sets of observation domain %d to collector: %v", ep.obsDomainID, err)
+		}
+	}
+	close(ep.templateRefCh)
+	ep.parent.mutex.Lock()
+	defer ep.parent.mutex.Unlock()
+	delete(ep.parent.domains, ep.obsDomainID)
+}
+
+// sendAllTemplates sends the templates of all the observation domains again.
+func (ep *ExportingProcess) sendAllTemplates() error {
+	if err := ep.sendRefreshedTemplates(); err != nil {
+		return err
+	}
+	for _, domain := range ep.getObservationDomains() {
+		if err := domain.sendRefreshedTemplates(); err != nil {
+			return fmt.Errorf("observation domain %d: %v", domain.obsDomainID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestExportingProcess_ObservationDomains(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	defer listener.Close()
+	// messagesCh receives the messages of all the connections.
+	messagesCh := make(chan []byte, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					header := make([]byte, entities.MsgHeaderLength)
+					if _, err := io.ReadFull(conn, header); err != nil {
+						return
+					}
+					body := make([]byte, int(binary.BigEndian.Uint16(header[2:4]))-entities.MsgHeaderLength)
+					if _, err := io.ReadFull(conn, body); err != nil {
+						return
+					}
+					messagesCh <- append(header, body...)
+				}
+			}()
+		}
+	}()
+
+	exporter, err := InitExportingProcess(ExporterInput{
+		CollectorAddress:    listener.Addr().String(),
+		CollectorProtocol:   listener.Addr().Network(),
+		ObservationDomainID: 1,
+	})
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", listener.Addr().String(), err)
+	}
+	defer exporter.CloseConnToCollector()
+	domain, err := exporter.NewObservationDomain(2)
+	assert.NoError(t, err)
+	_, err = exporter.NewObservationDomain(1)
+	assert.Error(t, err)
+	_, err = domain.NewObservationDomain(2)
+	assert.Error(t, err)
+
+	// Both observation domains use the same template ID for different
+	// templates.
+	countElement, _ := registry.GetInfoElement("packetDeltaCount", registry.IANAEnterpriseID)
+	addressElement, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	templateID := exporter.NewTemplateID()
+	assert.Equal(t, templateID, domain.NewTemplateID())
+	sendTemplate := func(ep *ExportingProcess, element *entities.InfoElement) {
+		templateSet := entities.NewSet(false)
+		assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+		assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, nil)}, templateID))
+		_, err := ep.SendSet(templateSet)
+		assert.NoError(t, err)
+	}
+	trySendData := func(ep *ExportingProcess, elements ...*entities.InfoElementWithValue) error {
+		dataSet := entities.NewSet(false)
+		assert.NoError(t, dataSet.PrepareSet(entities.Data, templateID))
+		assert.NoError(t, dataSet.AddRecord(elements, templateID))
+		_, err := ep.SendSet(dataSet)
+		return err
+	}
+	sendData := func(ep *ExportingProcess, element *entities.InfoElement, value interface{}) {
+		assert.NoError(t, trySendData(ep, entities.NewInfoElementWithValue(element, value)))
+	}
+	sendTemplate(exporter, countElement)
+	sendTemplate(domain, addressElement)
+	sendData(exporter, countElement, uint64(1))
+	sendData(domain, addressElement, net.ParseIP("10.0.0.1"))
+	sendData(domain, addressElement, net.ParseIP("10.0.0.2"))
+	// Records are checked against the template of their domain.
+	assert.Error(t, trySendData(domain, entities.NewInfoElementWithValue(addressElement, net.ParseIP("10.0.0.3")), entities.NewInfoElementWithValue(countElement, uint64(1))))
+	assert.Equal(t, uint32(1), exporter.GetSeqNumber())
+	assert.Equal(t, uint32(2), domain.GetSeqNumber())
+
+	obsDomainIDs := make([]uint32, 0)
+	seqNumbers := make([]uint32, 0)
+	for i := 0; i < 5; i++ {
+		msg := <-messagesCh
+		obsDomainIDs = append(obsDomainIDs, binary.BigEndian.Uint32(msg[12:16]))
+		seqNumbers = append(seqNumbers, binary.BigEndian.Uint32(msg[8:12]))
+	}
+	assert.Equal(t, []uint32{1, 2, 1, 2, 2}, obsDomainIDs)
+	assert.Equal(t, []uint32{0, 0, 1, 1, 2}, seqNumbers)
+
+	// The templates of both domains are sent on restored connections.
+	exporter.conns[0].getConn().Close()
+	assert.Error(t, trySendData(exporter, entities.NewInfoElementWithValue(countElement, uint64(2))))
+	assert.True(t, exporter.restoreConns())
+	obsDomainIDs = obsDomainIDs[:0]
+	for i := 0; i < 2; i++ {
+		msg := <-messagesCh
+		assert.Equal(t, entities.TemplateSetID, binary.BigEndian.Uint16(msg[16:18]))
+		obsDomainIDs = append(obsDomainIDs, binary.BigEndian.Uint32(msg[12:16]))
+	}
+	assert.Equal(t, []uint32{1, 2}, obsDomainIDs)
+
+	// Closing the observation domain does not close the connections.
+	domain.CloseConnToCollector()
+	assert.Empty(t, exporter.getObservationDomains())
+	sendData(exporter, countElement, uint64(2))
+	msg := <-messagesCh
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(msg[12:16]))
+}
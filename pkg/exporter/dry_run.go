@@ -0,0 +1,111 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+// dryRunSink is the writer shared by the connections of an exporting process
+// in dry-run mode.
+type dryRunSink struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func newDryRunSink(writer io.Writer) *dryRunSink {
+	if writer == nil {
+		writer = ioutil.Discard
+	}
+	return &dryRunSink{writer: writer}
+}
+
+// dryRunAddr is the address of a connection in dry-run mode. Its network is
+// the collector protocol, so that message size limits are the same as with a
+// real connection.
+type dryRunAddr struct {
+	network string
+	address string
+}
+
+func (a dryRunAddr) Network() string {
+	return a.network
+}
+
+func (a dryRunAddr) String() string {
+	return a.address
+}
+
+// dryRunConn is a net.Conn writing every message to the sink instead of
+// sending it to the collector.
+type dryRunConn struct {
+	sink       *dryRunSink
+	localAddr  dryRunAddr
+	remoteAddr dryRunAddr
+	closed     bool
+}
+
+func newDryRunConn(input ExporterInput, sink *dryRunSink, index int) *dryRunConn {
+	return &dryRunConn{
+		sink:       sink,
+		localAddr:  dryRunAddr{input.CollectorProtocol, fmt.Sprintf("dry-run-%d", index)},
+		remoteAddr: dryRunAddr{input.CollectorProtocol, input.CollectorAddress},
+	}
+}
+
+func (c *dryRunConn) Write(b []byte) (int, error) {
+	c.sink.mutex.Lock()
+	defer c.sink.mutex.Unlock()
+	if c.closed {
+		return 0, fmt.Errorf("dry-run connection %s is closed", c.localAddr)
+	}
+	return c.sink.writer.Write(b)
+}
+
+func (c *dryRunConn) Read(b []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (c *dryRunConn) Close() error {
+	c.sink.mutex.Lock()
+	defer c.sink.mutex.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *dryRunConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *dryRunConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *dryRunConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *dryRunConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *dryRunConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
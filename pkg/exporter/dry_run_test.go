@@ -0,0 +1,82 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestExportingProcess_DryRun(t *testing.T) {
+	capture := new(bytes.Buffer)
+	input := ExporterInput{
+		// Nothing listens on the collector address in dry-run mode.
+		CollectorAddress:    "127.0.0.1:1",
+		CollectorProtocol:   "udp",
+		ObservationDomainID: 1,
+		ValidateDataRecords: true,
+		DryRun:              true,
+		DryRunWriter:        capture,
+	}
+	exporter, err := InitExportingProcess(input)
+	assert.NoError(t, err)
+	defer exporter.CloseConnToCollector()
+	assert.Equal(t, entities.DefaultUDPMsgSize, exporter.GetMsgSizeLimit())
+
+	templateID := exporter.NewTemplateID()
+	srcElement, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	dstElement, _ := registry.GetInfoElement("destinationIPv4Address", registry.IANAEnterpriseID)
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{
+		entities.NewInfoElementWithValue(srcElement, nil),
+		entities.NewInfoElementWithValue(dstElement, nil),
+	}, templateID))
+	bytesSent, err := exporter.SendSet(templateSet)
+	assert.NoError(t, err)
+	assert.Equal(t, 32, bytesSent)
+
+	dataSet := entities.NewSet(false)
+	assert.NoError(t, dataSet.PrepareSet(entities.Data, templateID))
+	assert.NoError(t, dataSet.AddRecord([]*entities.InfoElementWithValue{
+		entities.NewInfoElementWithValue(srcElement, net.ParseIP("10.0.0.1")),
+		entities.NewInfoElementWithValue(dstElement, net.ParseIP("10.0.0.2")),
+	}, templateID))
+	bytesSent, err = exporter.SendSet(dataSet)
+	assert.NoError(t, err)
+	assert.Equal(t, 28, bytesSent)
+	assert.Equal(t, 60, capture.Len())
+	data := capture.Bytes()[32:]
+	assert.Equal(t, uint16(28), binary.BigEndian.Uint16(data[2:4]))
+	assert.Equal(t, templateID, binary.BigEndian.Uint16(data[16:18]))
+	assert.Equal(t, []byte{10, 0, 0, 1, 10, 0, 0, 2}, data[20:28])
+
+	// Records are still validated.
+	invalidSet := entities.NewSet(false)
+	assert.NoError(t, invalidSet.PrepareSet(entities.Data, templateID))
+	assert.NoError(t, invalidSet.AddRecord([]*entities.InfoElementWithValue{
+		entities.NewInfoElementWithValue(srcElement, net.ParseIP("10.0.0.1")),
+	}, templateID))
+	_, err = exporter.SendSet(invalidSet)
+	assert.Error(t, err)
+	assert.Equal(t, 60, capture.Len())
+}
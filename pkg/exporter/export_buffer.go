@@ -0,0 +1,175 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// ExportBuffer groups data records added with different templates, in any
+// order, into one data set per template, so that records of several templates
+// (e.g. IPv4, IPv6 and options records) can be exported together without
+// staging them separately. Every set is sent in its own message, when it is
+// full or when the buffer is flushed. The order of the records is kept among
+// the records of a template, but not across templates.
+//
+// ExportBuffer is safe for concurrent use.
+type ExportBuffer struct {
+	ep    *ExportingProcess
+	mutex sync.Mutex
+	// sets are the data sets being filled, by template ID.
+	sets map[uint16]*bufferedSet
+	// templateIDs are the templates of the sets, in the order of their first
+	// record, which is the order in which the sets are flushed.
+	templateIDs []uint16
+}
+
+type bufferedSet struct {
+	set        entities.Set
+	length     int
+	numRecords int
+}
+
+// NewExportBuffer returns an empty buffer of data records sent by the
+// exporting process.
+func (ep *ExportingProcess) NewExportBuffer() *ExportBuffer {
+	return &ExportBuffer{
+		ep:   ep,
+		sets: make(map[uint16]*bufferedSet),
+	}
+}
+
+// AddRecord adds a data record of the template to the buffer. The record is
+// encoded and checked against its template, so the elements can be reused
+// afterwards. If the set of the template is full, it is sent first, and the
+// record is not added if sending fails.
+func (b *ExportBuffer) AddRecord(templateID uint16, elements []*entities.InfoElementWithValue) error {
+	recordLen, err := b.ep.checkBatchRecord(BatchRecord{templateID, elements})
+	if err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	buffered, exist := b.sets[templateID]
+	if exist && buffered.length+recordLen > b.ep.getMaxSetLen() {
+		if _, err := b.ep.SendSet(buffered.set); err != nil {
+			return fmt.Errorf("error when sending full set of template %d: %v", templateID, err)
+		}
+		b.removeSet(templateID)
+		exist = false
+	}
+	if !exist {
+		buffered = &bufferedSet{
+			set:    entities.NewSet(false),
+			length: entities.SetHeaderLen,
+		}
+		if err := buffered.set.PrepareSet(entities.Data, templateID); err != nil {
+			return err
+		}
+		b.sets[templateID] = buffered
+		b.templateIDs = append(b.templateIDs, templateID)
+	}
+	if err := buffered.set.AddRecord(elements, templateID); err != nil {
+		return err
+	}
+	buffered.length += recordLen
+	buffered.numRecords++
+	return nil
+}
+
+// Flush sends the sets of all templates, in the order in which their first
+// record was added, and returns the number of bytes sent. If sending a set
+// fails, it and the following sets are kept in the buffer. With
+// ExporterInput.PackMessages, the sets are packed into as few messages as
+// possible, and the sets of a message which cannot be sent are kept too.
+func (b *ExportBuffer) Flush() (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.ep.packMessages {
+		return b.flushPacked()
+	}
+	bytesSent := 0
+	for len(b.templateIDs) > 0 {
+		templateID := b.templateIDs[0]
+		n, err := b.ep.SendSet(b.sets[templateID].set)
+		if err != nil {
+			return bytesSent, fmt.Errorf("error when sending set of template %d: %v", templateID, err)
+		}
+		bytesSent += n
+		b.removeSet(templateID)
+	}
+	return bytesSent, nil
+}
+
+// flushPacked sends the sets in as few messages as possible. Every set fits in
+// an empty message, as sets are sent by AddRecord when they are full.
+func (b *ExportBuffer) flushPacked() (int, error) {
+	packer := b.ep.newMessagePacker(nil)
+	bytesSent := 0
+	// pendingIDs are the templates of the sets in the current message.
+	pendingIDs := make([]uint16, 0)
+	sendMessage := func() error {
+		n, err := packer.flush()
+		if err != nil {
+			return fmt.Errorf("error when sending sets of templates %v: %v", pendingIDs, err)
+		}
+		bytesSent += n
+		for _, templateID := range pendingIDs {
+			b.removeSet(templateID)
+		}
+		pendingIDs = pendingIDs[:0]
+		return nil
+	}
+	for _, templateID := range append([]uint16{}, b.templateIDs...) {
+		buffered := b.sets[templateID]
+		if !packer.fits(buffered.length) {
+			if err := sendMessage(); err != nil {
+				return bytesSent, err
+			}
+		}
+		if _, err := packer.addSet(buffered.set); err != nil {
+			return bytesSent, fmt.Errorf("error when sending set of template %d: %v", templateID, err)
+		}
+		pendingIDs = append(pendingIDs, templateID)
+	}
+	if err := sendMessage(); err != nil {
+		return bytesSent, err
+	}
+	return bytesSent, nil
+}
+
+// Len returns the number of records in the buffer.
+func (b *ExportBuffer) Len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	numRecords := 0
+	for _, buffered := range b.sets {
+		numRecords += buffered.numRecords
+	}
+	return numRecords
+}
+
+func (b *ExportBuffer) removeSet(templateID uint16) {
+	delete(b.sets, templateID)
+	for i, id := range b.templateIDs {
+		if id == templateID {
+			b.templateIDs = append(b.templateIDs[:i], b.templateIDs[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestExportBuffer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	defer listener.Close()
+	// messagesCh receives the data messages.
+	messagesCh := make(chan []byte, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			header := make([]byte, entities.MsgHeaderLength)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				close(messagesCh)
+				return
+			}
+			body := make([]byte, int(binary.BigEndian.Uint16(header[2:4]))-entities.MsgHeaderLength)
+			if _, err := io.ReadFull(conn, body); err != nil {
+				close(messagesCh)
+				return
+			}
+			if binary.BigEndian.Uint16(body[0:2]) != entities.TemplateSetID {
+				messagesCh <- append(header, body...)
+			}
+		}
+	}()
+
+	input := ExporterInput{
+		CollectorAddress:    listener.Addr().String(),
+		CollectorProtocol:   listener.Addr().Network(),
+		ObservationDomainID: 1,
+	}
+	exporter, err := InitExportingProcess(input)
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", listener.Addr().String(), err)
+	}
+	defer exporter.CloseConnToCollector()
+
+	countElement, _ := registry.GetInfoElement("packetDeltaCount", registry.IANAEnterpriseID)
+	addressElement, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	countTemplateID := exporter.NewTemplateID()
+	addressTemplateID := exporter.NewTemplateID()
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(countElement, nil)}, countTemplateID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(addressElement, nil)}, addressTemplateID))
+	_, err = exporter.SendSet(templateSet)
+	assert.NoError(t, err)
+
+	buffer := exporter.NewExportBuffer()
+	// Records with an unknown template are rejected.
+	assert.Error(t, buffer.AddRecord(addressTemplateID+1, []*entities.InfoElementWithValue{entities.NewInfoElementWithValue(countElement, uint64(0))}))
+	// The set of 9000 records of 8 bytes does not fit in a single TCP message,
+	// so it is sent when full, before the buffer is flushed.
+	numRecords := 9000
+	for i := 0; i < numRecords; i++ {
+		assert.NoError(t, buffer.AddRecord(countTemplateID, []*entities.InfoElementWithValue{entities.NewInfoElementWithValue(countElement, uint64(i))}))
+		assert.NoError(t, buffer.AddRecord(addressTemplateID, []*entities.InfoElementWithValue{entities.NewInfoElementWithValue(addressElement, net.IPv4(10, 0, 0, byte(i)))}))
+	}
+	fullSet := <-messagesCh
+	fullSetRecords := (len(fullSet) - entities.MsgHeaderLength - entities.SetHeaderLen) / 8
+	assert.Equal(t, countTemplateID, binary.BigEndian.Uint16(fullSet[16:18]))
+	assert.Equal(t, 2*numRecords-fullSetRecords, buffer.Len())
+
+	// The address set, which got its first record before the remaining count
+	// records, is sent first.
+	bytesSent, err := buffer.Flush()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, buffer.Len())
+	addressSet := <-messagesCh
+	countSet := <-messagesCh
+	assert.Equal(t, len(addressSet)+len(countSet), bytesSent)
+	assert.Equal(t, addressTemplateID, binary.BigEndian.Uint16(addressSet[16:18]))
+	assert.Equal(t, entities.MsgHeaderLength+entities.SetHeaderLen+4*numRecords, len(addressSet))
+	for i := 0; i < numRecords; i++ {
+		offset := entities.MsgHeaderLength + entities.SetHeaderLen + 4*i
+		assert.Equal(t, []byte{10, 0, 0, byte(i)}, addressSet[offset:offset+4])
+	}
+	assert.Equal(t, countTemplateID, binary.BigEndian.Uint16(countSet[16:18]))
+	next := uint64(0)
+	for _, set := range [][]byte{fullSet, countSet} {
+		for offset := entities.MsgHeaderLength + entities.SetHeaderLen; offset < len(set); offset += 8 {
+			assert.Equal(t, next, binary.BigEndian.Uint64(set[offset:offset+8]))
+			next++
+		}
+	}
+	assert.Equal(t, uint64(numRecords), next)
+
+	// Flushing an empty buffer sends nothing.
+	bytesSent, err = buffer.Flush()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, bytesSent)
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultFailoverTimeout = 10 * time.Second
+	// failoverCheckInterval is the maximum interval between checks of the
+	// health of the connections, and between attempts to go back to the
+	// primary collector.
+	failoverCheckInterval = time.Second
+)
+
+// collectorFailover is the state of the failover between the primary and the
+// secondary collectors.
+type collectorFailover struct {
+	primaryAddress   string
+	secondaryAddress string
+	timeout          time.Duration
+	// dial opens a new connection to the collector with the given address.
+	dial func(address string) (net.Conn, error)
+	// onSecondary is set to 1 while the secondary collector is used.
+	onSecondary int32
+	// failingSince is the time at which the connections to the primary
+	// collector were first seen failing, or zero if they are healthy.
+	failingSince time.Time
+}
+
+func (f *collectorFailover) isOnSecondary() bool {
+	return atomic.LoadInt32(&f.onSecondary) == 1
+}
+
+// getAddress returns the address of the collector in use.
+func (f *collectorFailover) getAddress() string {
+	if f.isOnSecondary() {
+		return f.secondaryAddress
+	}
+	return f.primaryAddress
+}
+
+// IsOnSecondaryCollector returns whether the exporting process switched to
+// ExporterInput.SecondaryCollectorAddress because sending to the primary
+// collector failed.
+func (ep *ExportingProcess) IsOnSecondaryCollector() bool {
+	return ep.failover != nil && ep.failover.isOnSecondary()
+}
+
+// runFailover checks the connections to the collector every check interval,
+// until the exporting process is closed.
+func (ep *ExportingProcess) runFailover() {
+	checkInterval := failoverCheckInterval
+	if ep.failover.timeout < checkInterval {
+		checkInterval = ep.failover.timeout
+	}
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ep.templateRefCh:
+			return
+		case <-ticker.C:
+			ep.checkFailover(time.Now())
+		}
+	}
+}
+
+// checkFailover restores the failed connections to the collector in use, and
+// switches all the connections to the secondary collector once the primary
+// collector has been failing for the failover timeout. When the secondary
+// collector is in use, all the connections are switched back to the primary
+// collector as soon as it accepts a connection again.
+func (ep *ExportingProcess) checkFailover(now time.Time) {
+	ep.restoreMutex.Lock()
+	defer ep.restoreMutex.Unlock()
+	f := ep.failover
+	if f.isOnSecondary() {
+		conn, err := f.dial(f.primaryAddress)
+		if err != nil {
+			klog.V(2).Infof("Primary collector %s is still unreachable: %v", f.primaryAddress, err)
+			ep.replaceConns(false)
+			return
+		}
+		conn.Close()
+		klog.Infof("Primary collector %s recovered, switching back to it", f.primaryAddress)
+		atomic.StoreInt32(&f.onSecondary, 0)
+		ep.replaceConns(true)
+		return
+	}
+	if ep.replaceConns(false) {
+		f.failingSince = time.Time{}
+		return
+	}
+	if f.failingSince.IsZero() {
+		f.failingSince = now
+	}
+	if now.Sub(f.failingSince) < f.timeout {
+		return
+	}
+	klog.Warningf("Sending to primary collector %s failed for %v, switching to secondary collector %s", f.primaryAddress, now.Sub(f.failingSince), f.secondaryAddress)
+	atomic.StoreInt32(&f.onSecondary, 1)
+	f.failingSince = time.Time{}
+	ep.replaceConns(true)
+}
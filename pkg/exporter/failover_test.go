@@ -0,0 +1,135 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// acceptMessages reads the given number of bytes from every connection
+// accepted by the listener.
+func acceptMessages(listener net.Listener, length int, buffCh chan<- []byte) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			buff := make([]byte, length)
+			if _, err := io.ReadFull(conn, buff); err != nil {
+				return
+			}
+			buffCh <- buff
+		}()
+	}
+}
+
+func TestExportingProcess_Failover(t *testing.T) {
+	primary, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	secondary, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	defer secondary.Close()
+	// Every connection receives the template message (28 bytes) and one data
+	// message (24 bytes).
+	primaryCh := make(chan []byte, 2)
+	secondaryCh := make(chan []byte, 1)
+	go acceptMessages(primary, 52, primaryCh)
+	go acceptMessages(secondary, 52, secondaryCh)
+
+	_, err = InitExportingProcess(ExporterInput{
+		CollectorAddress:          primary.Addr().String(),
+		CollectorProtocol:         "udp",
+		SecondaryCollectorAddress: secondary.Addr().String(),
+	})
+	assert.Error(t, err)
+
+	exporter, err := InitExportingProcess(ExporterInput{
+		CollectorAddress:          primary.Addr().String(),
+		CollectorProtocol:         primary.Addr().Network(),
+		ObservationDomainID:       1,
+		SecondaryCollectorAddress: secondary.Addr().String(),
+		FailoverTimeout:           50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", primary.Addr().String(), err)
+	}
+	defer exporter.CloseConnToCollector()
+
+	templateID := exporter.NewTemplateID()
+	element, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, nil)}, templateID))
+	_, err = exporter.SendSet(templateSet)
+	assert.NoError(t, err)
+	sendDataSet := func() error {
+		dataSet := entities.NewSet(false)
+		assert.NoError(t, dataSet.PrepareSet(entities.Data, templateID))
+		assert.NoError(t, dataSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, net.ParseIP("10.0.0.1"))}, templateID))
+		_, err := exporter.SendSet(dataSet)
+		return err
+	}
+	isConnectedTo := func(address string) bool {
+		return exporter.conns[0].isHealthy() && exporter.conns[0].getConn().RemoteAddr().String() == address
+	}
+	checkMessages := func(buff []byte) {
+		assert.Equal(t, entities.TemplateSetID, binary.BigEndian.Uint16(buff[16:18]))
+		assert.Equal(t, templateID, binary.BigEndian.Uint16(buff[44:46]))
+	}
+	assert.NoError(t, sendDataSet())
+	checkMessages(<-primaryCh)
+	assert.False(t, exporter.IsOnSecondaryCollector())
+
+	// The primary collector becomes unreachable.
+	primaryAddress := primary.Addr().String()
+	primary.Close()
+	exporter.conns[0].getConn().Close()
+	assert.Error(t, sendDataSet())
+	assert.Eventually(t, func() bool {
+		return isConnectedTo(secondary.Addr().String())
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.True(t, exporter.IsOnSecondaryCollector())
+	assert.NoError(t, sendDataSet())
+	checkMessages(<-secondaryCh)
+
+	// The exporting process switches back once the primary collector recovers.
+	primary, err = net.Listen("tcp", primaryAddress)
+	if err != nil {
+		t.Fatalf("Got error when restarting the local server: %v", err)
+	}
+	defer primary.Close()
+	go acceptMessages(primary, 52, primaryCh)
+	assert.Eventually(t, func() bool {
+		return isConnectedTo(primaryAddress)
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.False(t, exporter.IsOnSecondaryCollector())
+	assert.NoError(t, sendDataSet())
+	checkMessages(<-primaryCh)
+}
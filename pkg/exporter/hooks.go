@@ -0,0 +1,81 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// PreSendHook is called by SendSet before a set is sent. It can inspect the set
+// and return it as is, return a different set to be sent instead (e.g. with some
+// fields stripped), return nil to skip sending the set, or return an error to
+// abort sending.
+type PreSendHook func(set entities.Set) (entities.Set, error)
+
+// AddPreSendHook appends a hook to the chain of hooks called before sending a
+// set. Hooks are called in the order they are added, and each hook receives
+// the set returned by the previous one.
+func (ep *ExportingProcess) AddPreSendHook(hook PreSendHook) {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	ep.preSendHooks = append(ep.preSendHooks, hook)
+}
+
+func (ep *ExportingProcess) runPreSendHooks(set entities.Set) (entities.Set, error) {
+	ep.mutex.Lock()
+	hooks := ep.preSendHooks
+	ep.mutex.Unlock()
+	var err error
+	for _, hook := range hooks {
+		set, err = hook(set)
+		if err != nil {
+			return nil, err
+		}
+		if set == nil {
+			return nil, nil
+		}
+	}
+	return set, nil
+}
+
+// ValidateDataSet is a PreSendHook which checks that every data record in the
+// set has the elements of its template, in the same order. Template sets are
+// returned without any check.
+func (ep *ExportingProcess) ValidateDataSet(set entities.Set) (entities.Set, error) {
+	if set.GetSetType() != entities.Data {
+		return set, nil
+	}
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	for _, record := range set.GetRecords() {
+		templateID := record.GetTemplateID()
+		template, exist := ep.templatesMap[templateID]
+		if !exist {
+			return nil, fmt.Errorf("validation: templateID %d does not exist in exporting process", templateID)
+		}
+		elements := record.GetOrderedElementList()
+		if len(elements) != len(template.elements) {
+			return nil, fmt.Errorf("validation: data record has %d fields while template %d has %d fields", len(elements), templateID, len(template.elements))
+		}
+		for i, element := range elements {
+			if element.Element.ElementId != template.elements[i].ElementId || element.Element.EnterpriseId != template.elements[i].EnterpriseId {
+				return nil, fmt.Errorf("validation: field %d of data record is %s while it is %s in template %d", i, element.Element.Name, template.elements[i].Name, templateID)
+			}
+		}
+	}
+	return set, nil
+}
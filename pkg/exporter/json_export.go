@@ -0,0 +1,167 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+const jsonHTTPTimeout = 10 * time.Second
+
+// jsonMessageWriter is the writer of the connections in JSON export mode. It
+// decodes the data records of every message with the templates registered in
+// the exporting process, and writes them as JSON objects separated by
+// newlines to a writer, or posts them to an HTTP endpoint.
+type jsonMessageWriter struct {
+	// ep is the exporting process of the connections, set once it is
+	// created.
+	ep       *ExportingProcess
+	writer   io.Writer
+	endpoint string
+	client   *http.Client
+}
+
+func newJSONMessageWriter(writer io.Writer, endpoint string) *jsonMessageWriter {
+	return &jsonMessageWriter{
+		writer:   writer,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: jsonHTTPTimeout},
+	}
+}
+
+// Write writes the data records of the message, which is given whole.
+func (w *jsonMessageWriter) Write(msg []byte) (int, error) {
+	records, err := w.encodeMessage(msg)
+	if err != nil {
+		return 0, err
+	}
+	if len(records) == 0 {
+		return len(msg), nil
+	}
+	if w.endpoint == "" {
+		if _, err := w.writer.Write(records); err != nil {
+			return 0, err
+		}
+		return len(msg), nil
+	}
+	resp, err := w.client.Post(w.endpoint, "application/x-ndjson", bytes.NewReader(records))
+	if err != nil {
+		return 0, fmt.Errorf("error when posting records to %s: %v", w.endpoint, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("error when posting records to %s: %s", w.endpoint, resp.Status)
+	}
+	return len(msg), nil
+}
+
+// encodeMessage returns the data records of the message as JSON objects
+// separated by newlines. Template sets and type records are left out.
+func (w *jsonMessageWriter) encodeMessage(msg []byte) ([]byte, error) {
+	if len(msg) < entities.MsgHeaderLength {
+		return nil, fmt.Errorf("message of length %d is too short", len(msg))
+	}
+	obsDomainID := binary.BigEndian.Uint32(msg[12:16])
+	ep := w.ep.getObservationDomain(obsDomainID)
+	if ep == nil {
+		return nil, fmt.Errorf("observation domain %d does not exist in exporting process", obsDomainID)
+	}
+	var records bytes.Buffer
+	encoder := json.NewEncoder(&records)
+	sets := msg[entities.MsgHeaderLength:]
+	for len(sets) >= entities.SetHeaderLen {
+		setID := binary.BigEndian.Uint16(sets[0:2])
+		setLen := int(binary.BigEndian.Uint16(sets[2:4]))
+		if setLen < entities.SetHeaderLen || setLen > len(sets) {
+			return nil, fmt.Errorf("invalid length %d of set %d", setLen, setID)
+		}
+		setBytes := sets[entities.SetHeaderLen:setLen]
+		sets = sets[setLen:]
+		if setID == entities.TemplateSetID || setID == entities.OptionsTemplateSetID {
+			continue
+		}
+		ep.mutex.Lock()
+		template, exists := ep.templatesMap[setID]
+		isTypeRecordSet := setID == ep.typeRecordTemplateID
+		ep.mutex.Unlock()
+		if !exists {
+			return nil, fmt.Errorf("process: templateID %d does not exist in exporting process", setID)
+		}
+		if isTypeRecordSet {
+			continue
+		}
+		buff := bytes.NewBuffer(setBytes)
+		for buff.Len() > 0 && buff.Len() >= int(template.minDataRecLen) && !(ep.padSets && isSetPadding(buff.Bytes())) {
+			values, err := decodeJSONValues(template.elements, buff)
+			if err != nil {
+				return nil, fmt.Errorf("error when decoding record of template %d: %v", setID, err)
+			}
+			if err := encoder.Encode(values); err != nil {
+				return nil, fmt.Errorf("error when encoding record of template %d: %v", setID, err)
+			}
+		}
+	}
+	return records.Bytes(), nil
+}
+
+// decodeJSONValues decodes the values of the elements of a record by name.
+// Addresses are formatted as strings.
+func decodeJSONValues(elements []*entities.InfoElement, buff *bytes.Buffer) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(elements))
+	for _, element := range elements {
+		val, err := entities.ReadElementValue(element, buff)
+		if err != nil {
+			return nil, err
+		}
+		value, err := entities.DecodeElementValue(element, bytes.NewBuffer(val))
+		if err != nil {
+			return nil, err
+		}
+		switch v := value.(type) {
+		case net.IP:
+			values[element.Name] = v.String()
+		case net.HardwareAddr:
+			values[element.Name] = v.String()
+		default:
+			values[element.Name] = v
+		}
+	}
+	return values, nil
+}
+
+// isSetPadding returns whether the remaining bytes of a set are the padding
+// added by ExporterInput.PadSets.
+func isSetPadding(remaining []byte) bool {
+	if len(remaining) >= setAlignment {
+		return false
+	}
+	for _, b := range remaining {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
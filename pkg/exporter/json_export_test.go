@@ -0,0 +1,123 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// sendJSONTestRecords sends a template with sourceIPv4Address,
+// destinationIPv4Address and packetDeltaCount, and a data set of two records.
+func sendJSONTestRecords(t *testing.T, exporter *ExportingProcess) {
+	srcElement, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	dstElement, _ := registry.GetInfoElement("destinationIPv4Address", registry.IANAEnterpriseID)
+	countElement, _ := registry.GetInfoElement("packetDeltaCount", registry.IANAEnterpriseID)
+	templateID := exporter.NewTemplateID()
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{
+		entities.NewInfoElementWithValue(srcElement, nil),
+		entities.NewInfoElementWithValue(dstElement, nil),
+		entities.NewInfoElementWithValue(countElement, nil),
+	}, templateID))
+	_, err := exporter.SendSet(templateSet)
+	assert.NoError(t, err)
+
+	dataSet := entities.NewSet(false)
+	assert.NoError(t, dataSet.PrepareSet(entities.Data, templateID))
+	for i, count := range []uint64{10, 20} {
+		assert.NoError(t, dataSet.AddRecord([]*entities.InfoElementWithValue{
+			entities.NewInfoElementWithValue(srcElement, net.IPv4(10, 0, 0, byte(i+1))),
+			entities.NewInfoElementWithValue(dstElement, net.ParseIP("10.0.1.1")),
+			entities.NewInfoElementWithValue(countElement, count),
+		}, templateID))
+	}
+	_, err = exporter.SendSet(dataSet)
+	assert.NoError(t, err)
+}
+
+const jsonTestRecords = `{"destinationIPv4Address":"10.0.1.1","packetDeltaCount":10,"sourceIPv4Address":"10.0.0.1"}
+{"destinationIPv4Address":"10.0.1.1","packetDeltaCount":20,"sourceIPv4Address":"10.0.0.2"}
+`
+
+func TestExportingProcess_JSONWriter(t *testing.T) {
+	output := new(bytes.Buffer)
+	exporter, err := InitExportingProcess(ExporterInput{
+		ObservationDomainID: 1,
+		PadSets:             true,
+		JSONWriter:          output,
+	})
+	assert.NoError(t, err)
+	defer exporter.CloseConnToCollector()
+	assert.Equal(t, entities.MaxTcpSocketMsgSize, exporter.GetMsgSizeLimit())
+
+	sendJSONTestRecords(t, exporter)
+	assert.Equal(t, jsonTestRecords, output.String())
+
+	_, err = InitExportingProcess(ExporterInput{
+		JSONWriter: output,
+		DryRun:     true,
+	})
+	assert.Error(t, err)
+}
+
+func TestExportingProcess_JSONEndpoint(t *testing.T) {
+	bodies := make(chan string, 1)
+	status := int32(http.StatusOK)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(body)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer server.Close()
+	exporter, err := InitExportingProcess(ExporterInput{
+		ObservationDomainID: 1,
+		JSONEndpoint:        server.URL,
+	})
+	assert.NoError(t, err)
+	defer exporter.CloseConnToCollector()
+
+	// Only messages with data records are posted.
+	sendJSONTestRecords(t, exporter)
+	assert.Equal(t, jsonTestRecords, <-bodies)
+	assert.Len(t, bodies, 0)
+
+	atomic.StoreInt32(&status, http.StatusInternalServerError)
+	dataSet := entities.NewSet(false)
+	element, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	templateID := exporter.NewTemplateID()
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, nil)}, templateID))
+	_, err = exporter.SendSet(templateSet)
+	assert.NoError(t, err)
+	assert.NoError(t, dataSet.PrepareSet(entities.Data, templateID))
+	assert.NoError(t, dataSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, net.ParseIP("10.0.0.1"))}, templateID))
+	_, err = exporter.SendSet(dataSet)
+	assert.Error(t, err)
+	<-bodies
+}
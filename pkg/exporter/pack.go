@@ -0,0 +1,93 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// messagePacker fills a message with data sets, up to the message size limit.
+// Unless sets are packed (see ExporterInput.PackMessages), callers send the
+// message after every set.
+type messagePacker struct {
+	ep *ExportingProcess
+	// conn is the connection on which messages are sent, or nil to send them
+	// on the next connection.
+	conn *collectorConn
+	// maxSetsLen is the space for sets in a message.
+	maxSetsLen int
+	// setsBytes are the encoded sets of the current message, and numRecords
+	// the number of data records in them.
+	setsBytes  []byte
+	numRecords uint32
+}
+
+func (ep *ExportingProcess) newMessagePacker(conn *collectorConn) *messagePacker {
+	return &messagePacker{
+		ep:         ep,
+		conn:       conn,
+		maxSetsLen: ep.GetMsgSizeLimit() - entities.MsgHeaderLength,
+	}
+}
+
+// fits returns whether a set of the given length, padded if needed, fits in
+// the current message.
+func (p *messagePacker) fits(setLen int) bool {
+	if p.ep.padSets {
+		setLen += (setAlignment - setLen%setAlignment) % setAlignment
+	}
+	return len(p.setsBytes)+setLen <= p.maxSetsLen
+}
+
+// addSet runs the pre-send hooks on the data set and adds it to the current
+// message. It returns false if the set was dropped by a hook.
+func (p *messagePacker) addSet(set entities.Set) (bool, error) {
+	set, err := p.ep.runPreSendHooks(set)
+	if err != nil {
+		return false, fmt.Errorf("error when running pre-send hooks: %v", err)
+	} else if set == nil {
+		return false, nil
+	}
+	set.UpdateLenInHeader()
+	setBytes := set.GetBuffer().Bytes()
+	if p.ep.padSets {
+		setBytes = padSet(setBytes)
+	}
+	p.setsBytes = append(p.setsBytes, setBytes...)
+	p.numRecords += set.GetNumberOfRecords()
+	return true, nil
+}
+
+// flush sends the current message if it is not empty, and returns its length.
+// The message is kept if sending fails.
+func (p *messagePacker) flush() (int, error) {
+	if len(p.setsBytes) == 0 {
+		return 0, nil
+	}
+	msg, err := p.ep.createMsg(len(p.setsBytes))
+	if err != nil {
+		return 0, err
+	}
+	bytesSent, err := p.ep.sendDataMsg(msg, p.setsBytes, p.numRecords, p.conn)
+	if err != nil {
+		return bytesSent, err
+	}
+	p.setsBytes = nil
+	p.numRecords = 0
+	p.ep.dataMessageSent()
+	return bytesSent, nil
+}
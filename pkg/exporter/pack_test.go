@@ -0,0 +1,126 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestExportingProcess_PackMessages(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	defer listener.Close()
+	// messagesCh receives the data messages.
+	messagesCh := make(chan []byte, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			header := make([]byte, entities.MsgHeaderLength)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				return
+			}
+			body := make([]byte, int(binary.BigEndian.Uint16(header[2:4]))-entities.MsgHeaderLength)
+			if _, err := io.ReadFull(conn, body); err != nil {
+				return
+			}
+			if binary.BigEndian.Uint16(body[0:2]) != entities.TemplateSetID {
+				messagesCh <- append(header, body...)
+			}
+		}
+	}()
+
+	input := ExporterInput{
+		CollectorAddress:    listener.Addr().String(),
+		CollectorProtocol:   listener.Addr().Network(),
+		ObservationDomainID: 1,
+		MaxMessageSize:      10,
+	}
+	_, err = InitExportingProcess(input)
+	assert.Error(t, err)
+	input.MaxMessageSize = 100
+	input.PackMessages = true
+	exporter, err := InitExportingProcess(input)
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", listener.Addr().String(), err)
+	}
+	defer exporter.CloseConnToCollector()
+	assert.Equal(t, 100, exporter.GetMsgSizeLimit())
+
+	countElement, _ := registry.GetInfoElement("packetDeltaCount", registry.IANAEnterpriseID)
+	addressElement, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	countTemplateID := exporter.NewTemplateID()
+	addressTemplateID := exporter.NewTemplateID()
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(countElement, nil)}, countTemplateID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(addressElement, nil)}, addressTemplateID))
+	_, err = exporter.SendSet(templateSet)
+	assert.NoError(t, err)
+
+	// The address set (16 bytes) and 8 count records (68 bytes) fill the
+	// first message, and the 4 remaining count records are split into a
+	// second message.
+	records := make([]BatchRecord, 0)
+	for i := 0; i < 3; i++ {
+		records = append(records, BatchRecord{addressTemplateID, []*entities.InfoElementWithValue{entities.NewInfoElementWithValue(addressElement, net.IPv4(10, 0, 0, byte(i)))}})
+	}
+	for i := 0; i < 12; i++ {
+		records = append(records, BatchRecord{countTemplateID, []*entities.InfoElementWithValue{entities.NewInfoElementWithValue(countElement, uint64(i))}})
+	}
+	result, err := exporter.SendBatch(records)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.MessagesSent)
+	assert.Equal(t, 15, result.RecordsSent)
+	assert.Equal(t, 152, result.BytesSent)
+	msg := <-messagesCh
+	assert.Equal(t, 100, len(msg))
+	assert.Equal(t, addressTemplateID, binary.BigEndian.Uint16(msg[16:18]))
+	assert.Equal(t, uint16(16), binary.BigEndian.Uint16(msg[18:20]))
+	assert.Equal(t, countTemplateID, binary.BigEndian.Uint16(msg[32:34]))
+	assert.Equal(t, uint16(68), binary.BigEndian.Uint16(msg[34:36]))
+	msg = <-messagesCh
+	assert.Equal(t, 52, len(msg))
+	assert.Equal(t, countTemplateID, binary.BigEndian.Uint16(msg[16:18]))
+	assert.Equal(t, uint64(8), binary.BigEndian.Uint64(msg[20:28]))
+	// Sequence numbers count the records of all the sets.
+	assert.Equal(t, uint32(15), binary.BigEndian.Uint32(msg[8:12]))
+
+	// The sets of an export buffer are sent in one message.
+	buffer := exporter.NewExportBuffer()
+	assert.NoError(t, buffer.AddRecord(addressTemplateID, []*entities.InfoElementWithValue{entities.NewInfoElementWithValue(addressElement, net.IPv4(10, 0, 0, 1))}))
+	assert.NoError(t, buffer.AddRecord(countTemplateID, []*entities.InfoElementWithValue{entities.NewInfoElementWithValue(countElement, uint64(1))}))
+	bytesSent, err := buffer.Flush()
+	assert.NoError(t, err)
+	assert.Equal(t, 36, bytesSent)
+	assert.Equal(t, 0, buffer.Len())
+	msg = <-messagesCh
+	assert.Equal(t, 36, len(msg))
+	assert.Equal(t, addressTemplateID, binary.BigEndian.Uint16(msg[16:18]))
+	assert.Equal(t, countTemplateID, binary.BigEndian.Uint16(msg[24:26]))
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// latencyProbeTemplateElements returns the fields of the template of latency
+// probes. They are built here so that the registry does not need to be loaded
+// by the exporter.
+func latencyProbeTemplateElements() []*entities.InfoElement {
+	return []*entities.InfoElement{
+		entities.NewInfoElement(entities.LatencyProbeSequenceElement, 152, entities.Unsigned64, registry.AntreaEnterpriseID, 8),
+		entities.NewInfoElement(entities.LatencyProbeSendTimeElement, 153, entities.Unsigned64, registry.AntreaEnterpriseID, 8),
+	}
+}
+
+// runLatencyProbes sends a latency probe every interval, until the exporting
+// process is closed. The template of the probes is sent before the first
+// probe, and again after a failure.
+func (ep *ExportingProcess) runLatencyProbes(templateID uint16, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	templateSent := false
+	sequence := uint64(0)
+	for {
+		select {
+		case <-ep.templateRefCh:
+			return
+		case <-ticker.C:
+			if !templateSent {
+				if err := ep.sendLatencyProbeTemplate(templateID); err != nil {
+					klog.Errorf("Error when sending the template of latency probes: %v", err)
+					continue
+				}
+				templateSent = true
+			}
+			sequence++
+			if err := ep.sendLatencyProbe(templateID, sequence); err != nil {
+				klog.Errorf("Error when sending latency probe: %v", err)
+				templateSent = false
+			}
+		}
+	}
+}
+
+func (ep *ExportingProcess) sendLatencyProbeTemplate(templateID uint16) error {
+	templateSet := entities.NewSet(false)
+	if err := templateSet.PrepareSet(entities.Template, entities.TemplateSetID); err != nil {
+		return err
+	}
+	elements := make([]*entities.InfoElementWithValue, 0)
+	for _, element := range latencyProbeTemplateElements() {
+		elements = append(elements, entities.NewInfoElementWithValue(element, nil))
+	}
+	if err := templateSet.AddRecord(elements, templateID); err != nil {
+		return fmt.Errorf("error when creating template of latency probes: %v", err)
+	}
+	_, err := ep.sendSet(templateSet, nil)
+	return err
+}
+
+// sendLatencyProbe sends a probe with the sequence number, stamped with the
+// current time.
+func (ep *ExportingProcess) sendLatencyProbe(templateID uint16, sequence uint64) error {
+	dataSet := entities.NewSet(false)
+	if err := dataSet.PrepareSet(entities.Data, templateID); err != nil {
+		return err
+	}
+	templateElements := latencyProbeTemplateElements()
+	values := []interface{}{sequence, uint64(time.Now().UnixNano())}
+	record := make([]*entities.InfoElementWithValue, len(templateElements))
+	for i, templateElement := range templateElements {
+		record[i] = entities.NewInfoElementWithValue(templateElement, values[i])
+	}
+	if err := dataSet.AddRecord(record, templateID); err != nil {
+		return fmt.Errorf("error when creating latency probe: %v", err)
+	}
+	_, err := ep.sendSet(dataSet, nil)
+	return err
+}
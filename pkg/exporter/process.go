@@ -17,7 +17,9 @@ package exporter
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"time"
@@ -26,46 +28,269 @@ import (
 	"k8s.io/klog/v2"
 
 	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/util"
 )
 
-const startTemplateID uint16 = 255
+const (
+	startTemplateID uint16 = 255
+	// setAlignment is the alignment of sets when ExporterInput.PadSets is set.
+	setAlignment = 4
+	// compressionReplyTimeout is the time given to the collector to reply to
+	// a compression request.
+	compressionReplyTimeout = 10 * time.Second
+)
 
 type templateValue struct {
 	elements      []*entities.InfoElement
 	minDataRecLen uint16
+	// scopeFieldCount is non-zero for options templates.
+	scopeFieldCount uint16
 }
 
 // 1. Tested one exportingProcess process per exporter. Can support multiple collector scenario by
 //    creating different instances of exporting process. Need to be tested
 // 2. Only one observation point per observation domain is supported,
 //    so observation point ID not defined.
-// 3. Supports only TCP and UDP; one session at a time. SCTP is not supported.
+// 3. Supports only TCP and UDP. SCTP is not supported. Data messages can be
+//    striped across multiple sessions to the same collector.
 // TODO:UDP needs to send MTU size packets as per RFC7011
 type ExportingProcess struct {
-	connToCollector net.Conn
-	obsDomainID     uint32
-	seqNumber       uint32
-	templateID      uint16
+	// conns are the connections to the collector. Templates are sent on all
+	// of them and data messages are striped across them.
+	conns         []*collectorConn
+	nextConnIndex int
+	obsDomainID   uint32
+	templateID    uint16
+	// freeTemplateIDs are the IDs of withdrawn templates, which are reused
+	// first by NewTemplateID.
+	freeTemplateIDs []uint16
 	pathMTU         int
 	templatesMap    map[uint16]templateValue
 	templateRefCh   chan struct{}
 	mutex           sync.Mutex
+	// typeRecordsEnabled enables export of RFC5610 type records for
+	// enterprise-specific elements used in templates.
+	typeRecordsEnabled   bool
+	typeRecordTemplateID uint16
+	announcedElements    map[typeRecordKey]*entities.InfoElement
+	// preSendHooks are called in order before a set is sent.
+	preSendHooks []PreSendHook
+	// stateFile keeps the template IDs and sequence numbers across restarts.
+	stateFile string
+	// stableTemplateIDs maps the elements of templates to their IDs.
+	stableTemplateIDs map[string]uint16
+	// padSets pads sets to a multiple of setAlignment bytes.
+	padSets bool
+	// spool keeps the data messages while the collector is unreachable.
+	spool *spool
+	// dial opens a new connection to the collector.
+	dial func() (net.Conn, error)
+	// restoreMutex serializes the attempts to restore failed connections.
+	restoreMutex sync.Mutex
+	// refreshTemplates is set for datagram transports, over which templates
+	// are sent again according to templateRefreshes, by default every
+	// refreshInterval and refreshPackets data messages.
+	refreshTemplates  bool
+	refreshInterval   time.Duration
+	refreshPackets    uint32
+	templateRefreshes map[uint16]*templateRefresh
+	refreshWakeCh     chan struct{}
+	// dataMessages is the number of data messages sent.
+	dataMessages uint64
+	// failover is set when a secondary collector is configured.
+	failover *collectorFailover
+	// asyncQueue keeps the sets sent asynchronously.
+	asyncQueue *asyncQueue
+	// maxMessageSize limits the size of the messages further than the
+	// transport, if not 0.
+	maxMessageSize int
+	// packMessages packs the data sets of batches and export buffers into
+	// messages.
+	packMessages bool
+	// rateLimiter limits the rate of data messages, if set.
+	rateLimiter *rateLimiter
+	// parent is the exporting process whose connections are shared by this
+	// additional observation domain, and domains are the additional
+	// observation domains of this exporting process, by ID.
+	parent  *ExportingProcess
+	domains map[uint32]*ExportingProcess
 }
 
 type ExporterInput struct {
-	// CollectorAddress needs to be provided in hostIP:port format.
+	// CollectorAddress needs to be provided in hostIP:port format, or is the
+	// path of the socket with Unix domain sockets.
 	CollectorAddress string
 	// CollectorProtocol needs to be provided in lower case format.
-	// We support "tcp" and "udp" protocols.
+	// We support "tcp" and "udp" protocols, and "unix" (stream) and
+	// "unixgram" (datagram) Unix domain sockets for collectors running on the
+	// same host. Templates are refreshed over "udp" and "unixgram".
 	CollectorProtocol   string
 	ObservationDomainID uint32
 	TempRefTimeout      uint32
-	PathMTU             int
-	IsEncrypted         bool
-	CACert              []byte
-	ClientCert          []byte
-	ClientKey           []byte
-	IsIPv6              bool
+	// TempRefPackets enables sending the templates again over "udp" and
+	// "unixgram" every given number of data messages, in addition to every
+	// TempRefTimeout (RFC7011 section 8.4). The refresh of every template can
+	// be changed with ExportingProcess.SetTemplateRefresh.
+	TempRefPackets uint32
+	PathMTU        int
+	// IsEncrypted enables TLS with "tcp" and DTLS with "udp". With both,
+	// ClientCert and ClientKey are optional, and are presented to collectors
+	// requiring client authentication.
+	IsEncrypted bool
+	CACert      []byte
+	ClientCert  []byte
+	ClientKey   []byte
+	IsIPv6      bool
+	// SendTypeRecords enables sending Information Element Type Records (RFC5610)
+	// for enterprise-specific elements used in templates, so that collectors
+	// can decode them without sharing the registry.
+	SendTypeRecords bool
+	// PreSendHooks are called in order before every set is sent. See PreSendHook.
+	PreSendHooks []PreSendHook
+	// ValidateDataRecords adds the built-in ValidateDataSet hook ahead of
+	// PreSendHooks.
+	ValidateDataRecords bool
+	// NumConnections is the number of parallel connections opened to the
+	// collector. Data messages are sent on them in round-robin order, and
+	// templates are sent on all of them. If 0, one connection is opened.
+	NumConnections int
+	// ALPNProtocols are the application protocols offered to the collector
+	// with ALPN when using TLS, in order of preference.
+	ALPNProtocols []string
+	// DryRun disables the connection to the collector. Messages are created
+	// and validated as usual, but are written to DryRunWriter instead of
+	// being sent, and SendSet returns their length. It can be used to check
+	// templates and records without a collector.
+	DryRun bool
+	// DryRunWriter receives every message in a single Write call in dry-run
+	// mode. If nil, messages are discarded.
+	DryRunWriter io.Writer
+	// StateFile is the path of a file where the last assigned template ID,
+	// the IDs assigned by GetStableTemplateID and the sequence numbers are
+	// saved, and restored from when the exporting process is restarted. This
+	// avoids sequence number resets, which collectors report as loss. See
+	// SaveState.
+	StateFile string
+	// PadSets pads every set with zeros to a multiple of 4 bytes, as permitted
+	// by RFC7011 section 3.3.1, for collectors which require aligned sets. The
+	// padding is included in the set length and counts towards the message
+	// size limit.
+	PadSets bool
+	// SpoolDir enables spooling data messages to files in this directory
+	// while the collector is unreachable, instead of losing them. Failed
+	// connections are restored every SpoolRetryInterval, and the spooled
+	// messages are then sent in order, after the templates. New data messages
+	// are spooled as long as the spool is not empty, to keep their order.
+	// Messages spooled by a previous run are sent too, so template IDs should
+	// be kept across restarts, e.g. with StateFile and GetStableTemplateID.
+	SpoolDir string
+	// SpoolMaxBytes is the maximum size of the spooled messages. Messages are
+	// dropped when the spool is full. If 0, 64 MiB.
+	SpoolMaxBytes int64
+	// SpoolRetryInterval is the interval between attempts to restore the
+	// connections and send the spooled messages. If 0, 10 seconds.
+	SpoolRetryInterval time.Duration
+	// Compression requests framed zstd compression of the TCP connections
+	// from the collector, which must be a collecting process of this library.
+	// Messages are sent uncompressed if the collector does not allow it. See
+	// CollectorInput.AllowCompression.
+	Compression bool
+	// LatencyProbeInterval enables sending a latency probe every interval:
+	// a data record with a sequence number and the time at which it is sent,
+	// from which collectors and aggregators measure the latency and the loss
+	// of the pipeline with entities.LatencyProbeTracker. Probes use their own
+	// template, whose ID is assigned when the exporting process starts.
+	LatencyProbeInterval time.Duration
+	// GetClientCertificate returns the certificate presented to collectors
+	// requiring client authentication, instead of ClientCert and ClientKey,
+	// e.g. the GetCertificate method of a util.CertificateReloader watching
+	// the certificate files. With TLS, it is called for every handshake, and
+	// with DTLS, for every connection, so that connections opened after a
+	// rotation use the new certificate without restarting the exporting
+	// process.
+	GetClientCertificate util.CertificateGetter
+	// Reconnect restores failed TCP, TLS and Unix stream connections to the
+	// collector in the background, with an exponential backoff between
+	// attempts from ReconnectInitialBackoff to ReconnectMaxBackoff. All the
+	// templates and type records are sent again on a restored connection
+	// before it is used for data messages. SendSet returns errors while no
+	// connection is healthy.
+	Reconnect bool
+	// ReconnectInitialBackoff is the delay before the first attempt to restore
+	// a failed connection, doubled after every failed attempt. If 0, 1 second.
+	ReconnectInitialBackoff time.Duration
+	// ReconnectMaxBackoff is the maximum delay between attempts to restore a
+	// failed connection. If 0, 1 minute.
+	ReconnectMaxBackoff time.Duration
+	// SecondaryCollectorAddress enables failover to a secondary collector with
+	// protocols "tcp" and "unix". When sending to CollectorAddress has been
+	// failing for FailoverTimeout, all the connections are switched to the
+	// secondary collector, and the templates are sent again on them. They are
+	// switched back to the primary collector, and the templates are sent
+	// again, as soon as it accepts connections again. Failed connections are
+	// restored in the meantime. The primary collector must be reachable when
+	// the exporting process starts.
+	SecondaryCollectorAddress string
+	// FailoverTimeout is the time for which sending to the primary collector
+	// has to fail before switching to the secondary collector. If 0, 10
+	// seconds.
+	FailoverTimeout time.Duration
+	// AsyncQueueSize enables asynchronous sending: SendSet adds a copy of the
+	// set to a queue of up to AsyncQueueSize sets and returns the length of
+	// its message, and the sets are sent in order by a background goroutine,
+	// so that callers are never blocked by the collector. Data sets are
+	// dropped when the queue is full, while template sets are always queued.
+	// Errors when sending queued sets are counted in AsyncQueueStats. Flush
+	// sends the queued sets synchronously, and CloseConnToCollector sends
+	// them before closing the connections. SendBatch is not queued.
+	AsyncQueueSize int
+	// AsyncFlushSize is the number of queued sets from which they are sent,
+	// without waiting for AsyncFlushInterval. If 0, the sets are sent as soon
+	// as they are queued.
+	AsyncFlushSize int
+	// AsyncFlushInterval is the interval at which the queued sets are sent.
+	// If 0, 1 second.
+	AsyncFlushInterval time.Duration
+	// MaxMessageSize is the maximum size of the messages, if lower than the
+	// limit of the transport (PathMTU over "udp"). It is the size up to which
+	// messages are filled with PackMessages. Data sets exceeding the message
+	// size limit (see GetMsgSizeLimit) are split by SendSet into several
+	// messages of consecutive records, and SendSetMessages returns how many
+	// messages were sent.
+	MaxMessageSize int
+	// PackMessages packs the data sets of SendBatch and ExportBuffer.Flush
+	// into as few messages as possible, up to the message size limit (see
+	// GetMsgSizeLimit), instead of sending every data set in its own message.
+	// Records of a template are split into several sets when they do not fit
+	// in the remaining space of a message.
+	PackMessages bool
+	// JSONWriter enables JSON export: like in dry-run mode, no connection is
+	// opened to the collector, and the data records are written to
+	// JSONWriter instead, as JSON objects mapping the names of the elements
+	// of their template to their values, separated by newlines, e.g.
+	//
+	//	{"packetDeltaCount":10,"sourceIPv4Address":"10.0.0.1"}
+	//
+	// Templates are registered as usual, to decode the records, but are not
+	// written, and neither are type records. CollectorProtocol still sets the
+	// message size limits, and is "tcp" if empty.
+	JSONWriter io.Writer
+	// JSONEndpoint enables JSON export to an HTTP endpoint: the records of
+	// every message are posted to this URL, in the format of JSONWriter, with
+	// content type "application/x-ndjson". SendSet returns an error if the
+	// endpoint does not reply with a 2xx status.
+	JSONEndpoint string
+	// MaxMessagesPerSecond and MaxBytesPerSecond limit the rate of the data
+	// messages sent to the collector, with token buckets allowing bursts of up
+	// to one second of messages or bytes, to protect shared collectors from
+	// export storms. Templates and type records are not limited. The limits
+	// are shared by all the observation domains of the exporting process. If
+	// 0, the rate is not limited.
+	MaxMessagesPerSecond int
+	MaxBytesPerSecond    int
+	// RateLimitPolicy decides what happens to data messages exceeding the
+	// rate limits. See RateLimitPolicy.
+	RateLimitPolicy RateLimitPolicy
 }
 
 // InitExportingProcess takes in collector address(net.Addr format), obsID(observation ID)
@@ -78,6 +303,174 @@ type ExporterInput struct {
 // PathMTU is optional for TCP as we use max socket buffer size of 65535. It can
 // be provided as 0.
 func InitExportingProcess(input ExporterInput) (*ExportingProcess, error) {
+	if input.Reconnect && !isStreamNetwork(input.CollectorProtocol) {
+		return nil, fmt.Errorf("reconnection is only supported with protocols tcp and unix")
+	}
+	if input.MaxMessageSize < 0 || (input.MaxMessageSize > 0 && input.MaxMessageSize < entities.MsgHeaderLength+entities.SetHeaderLen) {
+		return nil, fmt.Errorf("invalid maximum message size %d", input.MaxMessageSize)
+	}
+	if input.SecondaryCollectorAddress != "" && !isStreamNetwork(input.CollectorProtocol) {
+		return nil, fmt.Errorf("failover is only supported with protocols tcp and unix")
+	}
+	rateLimiter, err := newRateLimiter(input.MaxMessagesPerSecond, input.MaxBytesPerSecond, input.RateLimitPolicy)
+	if err != nil {
+		return nil, err
+	}
+	jsonExport := input.JSONWriter != nil || input.JSONEndpoint != ""
+	if jsonExport {
+		if input.DryRun {
+			return nil, fmt.Errorf("JSON export cannot be combined with dry-run mode")
+		}
+		if input.JSONWriter != nil && input.JSONEndpoint != "" {
+			return nil, fmt.Errorf("JSON export needs either a writer or an HTTP endpoint")
+		}
+		if input.CollectorProtocol == "" {
+			input.CollectorProtocol = "tcp"
+		}
+	}
+	numConns := input.NumConnections
+	if numConns <= 0 {
+		numConns = 1
+	}
+	conns := make([]*collectorConn, 0, numConns)
+	var sink *dryRunSink
+	var jsonWriter *jsonMessageWriter
+	if input.DryRun {
+		sink = newDryRunSink(input.DryRunWriter)
+	} else if jsonExport {
+		jsonWriter = newJSONMessageWriter(input.JSONWriter, input.JSONEndpoint)
+		sink = newDryRunSink(jsonWriter)
+	}
+	for i := 0; i < numConns; i++ {
+		if sink != nil {
+			conns = append(conns, newCollectorConn(newDryRunConn(input, sink, i)))
+			continue
+		}
+		conn, err := dialCollector(input)
+		if err != nil {
+			for _, c := range conns {
+				c.conn.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, newCollectorConn(conn))
+	}
+	expProc := &ExportingProcess{
+		conns:              conns,
+		obsDomainID:        input.ObservationDomainID,
+		templateID:         startTemplateID,
+		pathMTU:            input.PathMTU,
+		templatesMap:       make(map[uint16]templateValue),
+		templateRefCh:      make(chan struct{}),
+		typeRecordsEnabled: input.SendTypeRecords,
+		announcedElements:  make(map[typeRecordKey]*entities.InfoElement),
+		stateFile:          input.StateFile,
+		padSets:            input.PadSets,
+		maxMessageSize:     input.MaxMessageSize,
+		packMessages:       input.PackMessages,
+		rateLimiter:        rateLimiter,
+		stableTemplateIDs:  make(map[string]uint16),
+		domains:            make(map[uint32]*ExportingProcess),
+		templateRefreshes:  make(map[uint16]*templateRefresh),
+		refreshWakeCh:      make(chan struct{}, 1),
+		dial: func() (net.Conn, error) {
+			return dialCollector(input)
+		},
+	}
+	if jsonWriter != nil {
+		jsonWriter.ep = expProc
+	}
+	if expProc.stateFile != "" {
+		if err := expProc.loadState(); err != nil {
+			for _, c := range conns {
+				c.conn.Close()
+			}
+			return nil, err
+		}
+	}
+	if input.SpoolDir != "" && sink == nil {
+		spool, err := newSpool(input.SpoolDir, input.SpoolMaxBytes)
+		if err != nil {
+			for _, c := range conns {
+				c.conn.Close()
+			}
+			return nil, err
+		}
+		expProc.spool = spool
+		retryInterval := input.SpoolRetryInterval
+		if retryInterval <= 0 {
+			retryInterval = defaultSpoolRetryInterval
+		}
+		go expProc.runSpool(retryInterval)
+	}
+	if input.SecondaryCollectorAddress != "" && sink == nil {
+		timeout := input.FailoverTimeout
+		if timeout <= 0 {
+			timeout = defaultFailoverTimeout
+		}
+		expProc.failover = &collectorFailover{
+			primaryAddress:   input.CollectorAddress,
+			secondaryAddress: input.SecondaryCollectorAddress,
+			timeout:          timeout,
+			dial: func(address string) (net.Conn, error) {
+				addressInput := input
+				addressInput.CollectorAddress = address
+				return dialCollector(addressInput)
+			},
+		}
+		expProc.dial = func() (net.Conn, error) {
+			return expProc.failover.dial(expProc.failover.getAddress())
+		}
+		go expProc.runFailover()
+	}
+	if input.Reconnect && sink == nil {
+		initialBackoff, maxBackoff := input.ReconnectInitialBackoff, input.ReconnectMaxBackoff
+		if initialBackoff <= 0 {
+			initialBackoff = defaultReconnectInitialBackoff
+		}
+		if maxBackoff <= 0 {
+			maxBackoff = defaultReconnectMaxBackoff
+		}
+		if maxBackoff < initialBackoff {
+			maxBackoff = initialBackoff
+		}
+		go expProc.runReconnect(initialBackoff, maxBackoff)
+	}
+	if input.AsyncQueueSize > 0 {
+		expProc.asyncQueue = newAsyncQueue(input.AsyncQueueSize, input.AsyncFlushSize, input.AsyncFlushInterval)
+		go expProc.runAsyncQueue()
+	}
+	if input.LatencyProbeInterval > 0 {
+		go expProc.runLatencyProbes(expProc.NewTemplateID(), input.LatencyProbeInterval)
+	}
+	if input.ValidateDataRecords {
+		expProc.preSendHooks = append(expProc.preSendHooks, expProc.ValidateDataSet)
+	}
+	expProc.preSendHooks = append(expProc.preSendHooks, input.PreSendHooks...)
+
+	// Template refresh logic is only for datagram transports.
+	if input.CollectorProtocol == "udp" || input.CollectorProtocol == "unixgram" {
+		if input.CollectorProtocol == "unixgram" {
+			// Unix datagrams are not limited by the MTU of a network path.
+			if expProc.pathMTU == 0 || expProc.pathMTU > entities.MaxTcpSocketMsgSize {
+				expProc.pathMTU = entities.MaxTcpSocketMsgSize
+			}
+		} else if expProc.pathMTU == 0 || expProc.pathMTU > entities.MaxUDPMsgSize {
+			expProc.pathMTU = entities.DefaultUDPMsgSize
+		}
+		if input.TempRefTimeout == 0 {
+			// Default value
+			input.TempRefTimeout = entities.TemplateRefreshTimeOut
+		}
+		expProc.refreshTemplates = true
+		expProc.refreshInterval = time.Duration(input.TempRefTimeout) * time.Second
+		expProc.refreshPackets = input.TempRefPackets
+		go expProc.runTemplateRefresh()
+	}
+	return expProc, nil
+}
+
+func dialCollector(input ExporterInput) (net.Conn, error) {
 	var conn net.Conn
 	var err error
 
@@ -87,19 +480,29 @@ func InitExportingProcess(input ExporterInput) (*ExportingProcess, error) {
 			if configErr != nil {
 				return nil, configErr
 			}
+			config.NextProtos = input.ALPNProtocols
+			if input.GetClientCertificate != nil {
+				config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					return input.GetClientCertificate()
+				}
+			}
 			conn, err = tls.Dial(input.CollectorProtocol, input.CollectorAddress, config)
 			if err != nil {
 				klog.Errorf("Cannot the create the tls connection to the Collector %s: %v", input.CollectorAddress, err)
 				return nil, err
 			}
 		} else if input.CollectorProtocol == "udp" { // use DTLS
-			roots := x509.NewCertPool()
-			ok := roots.AppendCertsFromPEM(input.CACert)
-			if !ok {
-				return nil, fmt.Errorf("failed to parse root certificate")
+			config, configErr := createDTLSClientConfig(input.CACert, input.ClientCert, input.ClientKey)
+			if configErr != nil {
+				return nil, configErr
+			}
+			if input.GetClientCertificate != nil {
+				cert, err := input.GetClientCertificate()
+				if err != nil {
+					return nil, err
+				}
+				config.Certificates = []tls.Certificate{*cert}
 			}
-			config := &dtls.Config{RootCAs: roots,
-				ExtendedMasterSecret: dtls.RequireExtendedMasterSecret}
 			udpAddr, err := net.ResolveUDPAddr(input.CollectorProtocol, input.CollectorAddress)
 			if err != nil {
 				return nil, err
@@ -109,6 +512,8 @@ func InitExportingProcess(input ExporterInput) (*ExportingProcess, error) {
 				klog.Errorf("Cannot the create the dtls connection to the Collector %s: %v", udpAddr.String(), err)
 				return nil, err
 			}
+		} else {
+			return nil, fmt.Errorf("encryption is not supported with protocol %s", input.CollectorProtocol)
 		}
 	} else {
 		conn, err = net.Dial(input.CollectorProtocol, input.CollectorAddress)
@@ -117,122 +522,298 @@ func InitExportingProcess(input ExporterInput) (*ExportingProcess, error) {
 			return nil, err
 		}
 	}
-	expProc := &ExportingProcess{
-		connToCollector: conn,
-		obsDomainID:     input.ObservationDomainID,
-		seqNumber:       0,
-		templateID:      startTemplateID,
-		pathMTU:         input.PathMTU,
-		templatesMap:    make(map[uint16]templateValue),
-		templateRefCh:   make(chan struct{}),
-	}
-
-	// Template refresh logic is only for UDP transport.
-	if input.CollectorProtocol == "udp" {
-		if expProc.pathMTU == 0 || expProc.pathMTU > entities.MaxUDPMsgSize {
-			expProc.pathMTU = entities.DefaultUDPMsgSize
-		}
-		if input.TempRefTimeout == 0 {
-			// Default value
-			input.TempRefTimeout = entities.TemplateRefreshTimeOut
+	if input.Compression && input.CollectorProtocol == "tcp" {
+		compressedConn, err := negotiateCompression(conn)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error when negotiating compression with the collector %s: %v", input.CollectorAddress, err)
 		}
-		go func() {
-			ticker := time.NewTicker(time.Duration(input.TempRefTimeout) * time.Second)
-			defer ticker.Stop()
-			for {
-				select {
-				case <-expProc.templateRefCh:
-					break
-				case <-ticker.C:
-					err := expProc.sendRefreshedTemplates()
-					if err != nil {
-						// Other option is sending messages through channel to library consumers
-						klog.Errorf("Error when sending refreshed templates: %v. Closing the connection to IPFIX controller", err)
-						expProc.CloseConnToCollector()
-					}
-				}
-			}
-		}()
+		return compressedConn, nil
 	}
-	return expProc, nil
+	return conn, nil
+}
+
+// negotiateCompression requests compression from the collector, and returns
+// the connection with compression if the collector accepted it, or the plain
+// connection otherwise.
+func negotiateCompression(conn net.Conn) (net.Conn, error) {
+	if _, err := conn.Write(util.CompressionHello); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, 1)
+	conn.SetReadDeadline(time.Now().Add(compressionReplyTimeout))
+	_, err := io.ReadFull(conn, reply)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("no reply from the collector: %v", err)
+	}
+	if reply[0] != util.CompressionAccepted {
+		klog.Infof("Collector %s rejected compression, messages are sent uncompressed", conn.RemoteAddr())
+		return conn, nil
+	}
+	return util.NewCompressedConn(conn, nil)
 }
 
 func (ep *ExportingProcess) SendSet(set entities.Set) (int, error) {
+	if ep.asyncQueue != nil {
+		return ep.queueSet(set)
+	}
+	return ep.sendSet(set, nil)
+}
+
+// SendSetMessages sends the set like SendSet, and also returns the number of
+// messages sent, which is more than 1 when a data set exceeding the message
+// size limit is split, and 0 when the set is dropped, e.g. by a pre-send hook.
+// With asynchronous sending, the set is only queued, and 0 is returned.
+func (ep *ExportingProcess) SendSetMessages(set entities.Set) (int, int, error) {
+	if ep.asyncQueue != nil {
+		bytesQueued, err := ep.queueSet(set)
+		return bytesQueued, 0, err
+	}
+	return ep.sendSetMessages(set, nil)
+}
+
+// sendSet sends the set on the given connection, or on the next connection if
+// it is nil. Templates and type records are always sent on all connections.
+func (ep *ExportingProcess) sendSet(set entities.Set, conn *collectorConn) (int, error) {
+	bytesSent, _, err := ep.sendSetMessages(set, conn)
+	return bytesSent, err
+}
+
+// sendSetMessages is sendSet, also returning the number of messages sent.
+func (ep *ExportingProcess) sendSetMessages(set entities.Set, conn *collectorConn) (int, int, error) {
+	set, err := ep.runPreSendHooks(set)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error when running pre-send hooks: %v", err)
+	} else if set == nil {
+		return 0, 0, nil
+	}
 	// Iterate over all records in the set.
 	setType := set.GetSetType()
 	if setType == entities.Undefined {
-		return 0, fmt.Errorf("set type is not properly defined")
+		return 0, 0, fmt.Errorf("set type is not properly defined")
 	}
 	for _, record := range set.GetRecords() {
 		if setType == entities.Template {
-			ep.updateTemplate(record.GetTemplateID(), record.GetOrderedElementList(), record.GetMinDataRecordLen())
+			ep.updateTemplate(record.GetTemplateID(), record.GetOrderedElementList(), record.GetMinDataRecordLen(), 0)
+		} else if setType == entities.OptionsTemplate {
+			ep.updateTemplate(record.GetTemplateID(), record.GetOrderedElementList(), record.GetMinDataRecordLen(), record.GetScopeFieldCount())
 		} else if setType == entities.Data {
 			err := ep.dataRecSanityCheck(record)
 			if err != nil {
-				return 0, fmt.Errorf("error when doing sanity check:%v", err)
+				return 0, 0, fmt.Errorf("error when doing sanity check:%v", err)
 			}
 		}
 	}
+	// Type records are sent ahead of the template so that collectors can
+	// decode the enterprise-specific elements in it.
+	if setType == entities.Template && ep.typeRecordsEnabled {
+		if err := ep.sendTypeRecords(set); err != nil {
+			return 0, 0, err
+		}
+	}
 	// Update the length in set header before sending the message.
 	set.UpdateLenInHeader()
-	bytesSent, err := ep.createAndSendMsg(set)
+	if setType == entities.Data && set.GetBuffer().Len() > ep.getMaxSetLen() && !ep.isTypeRecordSet(set) {
+		return ep.sendSplitDataSet(set, conn)
+	}
+	bytesSent, err := ep.createAndSendMsg(set, conn)
 	if err != nil {
-		return bytesSent, err
+		return bytesSent, 0, err
+	}
+	messagesSent := 0
+	if bytesSent > 0 {
+		messagesSent = 1
+	}
+	if ep.refreshTemplates {
+		if setType == entities.Template || setType == entities.OptionsTemplate {
+			now := time.Now()
+			for _, record := range set.GetRecords() {
+				ep.markTemplateSent(record.GetTemplateID(), now)
+			}
+		} else if !ep.isTypeRecordSet(set) {
+			ep.dataMessageSent()
+		}
 	}
 
-	return bytesSent, nil
+	return bytesSent, messagesSent, nil
+}
+
+// sendSplitDataSet sends the records of a data set exceeding the message size
+// limit in as many messages as needed, every message containing a set of
+// consecutive records. It returns the bytes and the number of messages sent,
+// including when sending a message fails, in which case the following
+// records are not sent.
+func (ep *ExportingProcess) sendSplitDataSet(set entities.Set, conn *collectorConn) (int, int, error) {
+	maxSetLen := ep.getMaxSetLen()
+	setHeader := set.GetBuffer().Bytes()[:entities.SetHeaderLen]
+	bytesSent, messagesSent := 0, 0
+	var setBytes []byte
+	var numRecords uint32
+	sendMessage := func() error {
+		binary.BigEndian.PutUint16(setBytes[2:4], uint16(len(setBytes)))
+		if ep.padSets {
+			setBytes = padSet(setBytes)
+		}
+		msg, err := ep.createMsg(len(setBytes))
+		if err != nil {
+			return err
+		}
+		n, err := ep.sendDataMsg(msg, setBytes, numRecords, conn)
+		bytesSent += n
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			messagesSent++
+			ep.dataMessageSent()
+		}
+		setBytes = nil
+		numRecords = 0
+		return nil
+	}
+	// Nothing is sent if a record cannot be sent.
+	for _, record := range set.GetRecords() {
+		if recordLen := record.GetBuffer().Len(); entities.SetHeaderLen+recordLen > maxSetLen {
+			return 0, 0, fmt.Errorf("record length %d exceeds the message size limit", recordLen)
+		}
+	}
+	for _, record := range set.GetRecords() {
+		recordBytes := record.GetBuffer().Bytes()
+		if setBytes != nil && len(setBytes)+len(recordBytes) > maxSetLen {
+			if err := sendMessage(); err != nil {
+				return bytesSent, messagesSent, err
+			}
+		}
+		if setBytes == nil {
+			setBytes = append([]byte{}, setHeader...)
+		}
+		setBytes = append(setBytes, recordBytes...)
+		numRecords++
+	}
+	if setBytes != nil {
+		if err := sendMessage(); err != nil {
+			return bytesSent, messagesSent, err
+		}
+	}
+	return bytesSent, messagesSent, nil
+}
+
+// isStreamNetwork returns whether messages are sent over a stream, where they
+// are only limited by the maximum message length.
+func isStreamNetwork(network string) bool {
+	return network == "tcp" || network == "unix"
 }
 
 func (ep *ExportingProcess) GetMsgSizeLimit() int {
-	if ep.connToCollector.LocalAddr().Network() == "tcp" {
-		return entities.MaxTcpSocketMsgSize
-	} else {
-		return ep.pathMTU
+	limit := ep.pathMTU
+	if isStreamNetwork(ep.conns[0].getConn().LocalAddr().Network()) {
+		limit = entities.MaxTcpSocketMsgSize
+	}
+	if ep.maxMessageSize > 0 && ep.maxMessageSize < limit {
+		return ep.maxMessageSize
 	}
+	return limit
 }
 
 func (ep *ExportingProcess) CloseConnToCollector() {
+	if ep.parent != nil {
+		ep.closeObservationDomain()
+		return
+	}
+	for _, domain := range ep.getObservationDomains() {
+		domain.closeObservationDomain()
+	}
+	if ep.asyncQueue != nil && !isChanClosed(ep.templateRefCh) {
+		if err := ep.flushAsyncQueue(); err != nil {
+			klog.Errorf("Error when sending queued sets to collector: %v", err)
+		}
+	}
 	if !isChanClosed(ep.templateRefCh) {
 		close(ep.templateRefCh) // Close template refresh channel
 	}
+	if err := ep.SaveState(); err != nil {
+		klog.Errorf("Error when saving state of exporting process: %v", err)
+	}
 
-	err := ep.connToCollector.Close()
-	// Just log the error that happened when closing the connection. Not returning error as we do not expect library
-	// consumers to exit their programs with this error.
-	if err != nil {
-		klog.Errorf("Error when closing connection to collector: %v", err)
+	for _, c := range ep.conns {
+		err := c.getConn().Close()
+		// Just log the error that happened when closing the connection. Not returning error as we do not expect library
+		// consumers to exit their programs with this error.
+		if err != nil {
+			klog.Errorf("Error when closing connection to collector: %v", err)
+		}
 	}
 }
 
 // NewTemplateID is called to get ID when creating new template record.
 func (ep *ExportingProcess) NewTemplateID() uint16 {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	return ep.allocateTemplateID()
+}
+
+// allocateTemplateID returns the ID of a withdrawn template if any, or a new
+// ID. ep.mutex must be held.
+func (ep *ExportingProcess) allocateTemplateID() uint16 {
+	if len(ep.freeTemplateIDs) > 0 {
+		templateID := ep.freeTemplateIDs[0]
+		ep.freeTemplateIDs = ep.freeTemplateIDs[1:]
+		return templateID
+	}
 	ep.templateID++
 	return ep.templateID
 }
 
 // createAndSendMsg takes in a set as input, creates the message, and sends it out.
 // TODO: This method will change when we support sending multiple sets.
-func (ep *ExportingProcess) createAndSendMsg(set entities.Set) (int, error) {
+func (ep *ExportingProcess) createAndSendMsg(set entities.Set, conn *collectorConn) (int, error) {
+	setBytes := set.GetBuffer().Bytes()
+	if ep.padSets {
+		setBytes = padSet(setBytes)
+	}
 	// Create a new message and use it to send the set.
+	msg, err := ep.createMsg(len(setBytes))
+	if err != nil {
+		return 0, err
+	}
+
+	// Templates and type records are needed in every transport session.
+	if set.GetSetType() != entities.Data || ep.isTypeRecordSet(set) {
+		bytesSent, err := ep.sendOnAllConns(msg, setBytes)
+		if err != nil && ep.spool != nil {
+			// They are sent again when the connections are restored.
+			return int(msg.GetMessageLen()), nil
+		}
+		return bytesSent, err
+	}
+	return ep.sendDataMsg(msg, setBytes, set.GetNumberOfRecords(), conn)
+}
+
+// createMsg creates a message with its header filled in, for sets of the given
+// total length.
+func (ep *ExportingProcess) createMsg(setsLen int) (*entities.Message, error) {
 	msg := entities.NewMessage(false)
 	// Create the header in the IPFIX message.
 	_, err := msg.CreateHeader()
 	if err != nil {
-		return 0, fmt.Errorf("error when creating header: %v", err)
+		return nil, fmt.Errorf("error when creating header: %v", err)
 	}
-
-	// Check if message is exceeding the limit after adding the set. Include message
-	// header length too.
-	msgLen := msg.GetMsgBufferLen() + set.GetBuffer().Len()
-	if ep.connToCollector.LocalAddr().Network() == "tcp" {
+	// Check if message is exceeding the limit after adding the sets. Include
+	// message header length too.
+	msgLen := msg.GetMsgBufferLen() + setsLen
+	if isStreamNetwork(ep.conns[0].getConn().LocalAddr().Network()) {
 		if msgLen > entities.MaxTcpSocketMsgSize {
-			return 0, fmt.Errorf("TCP transport: message size exceeds max socket buffer size")
+			return nil, fmt.Errorf("TCP transport: message size exceeds max socket buffer size")
 		}
 	} else {
 		if msgLen > ep.pathMTU {
-			return 0, fmt.Errorf("UDP transport: message size exceeds max pathMTU (set as %v)", ep.pathMTU)
+			return nil, fmt.Errorf("UDP transport: message size exceeds max pathMTU (set as %v)", ep.pathMTU)
 		}
 	}
+	if ep.maxMessageSize > 0 && msgLen > ep.maxMessageSize {
+		return nil, fmt.Errorf("message size %d exceeds the maximum message size %d", msgLen, ep.maxMessageSize)
+	}
 
 	// Set the fields in the message header.
 	// IPFIX version number is 10.
@@ -241,26 +822,54 @@ func (ep *ExportingProcess) createAndSendMsg(set entities.Set) (int, error) {
 	msg.SetObsDomainID(ep.obsDomainID)
 	msg.SetMessageLen(uint16(msgLen))
 	msg.SetExportTime(uint32(time.Now().Unix()))
-	if set.GetSetType() == entities.Data {
-		ep.seqNumber = ep.seqNumber + set.GetNumberOfRecords()
-	}
-	msg.SetSequenceNum(ep.seqNumber)
+	return msg, nil
+}
 
-	// Append the byte slices together to send on the exporter connection rather
-	// than copying the set buffer to message buffer again.
-	bytesSlice := append(msg.GetMsgBuffer().Bytes(), set.GetBuffer().Bytes()...)
-	// Send the message on the exporter connection.
-	bytesSent, err := ep.connToCollector.Write(bytesSlice)
-	if err != nil {
-		return bytesSent, fmt.Errorf("error when sending message on the connection: %v", err)
-	} else if bytesSent != int(msg.GetMessageLen()) {
-		return bytesSent, fmt.Errorf("could not send the complete message on the connection")
+// sendDataMsg sends the message followed by the encoded data sets on the given
+// connection, or on the next connection if it is nil, or spools it.
+func (ep *ExportingProcess) sendDataMsg(msg *entities.Message, setBytes []byte, numRecords uint32, conn *collectorConn) (int, error) {
+	if ep.rateLimiter != nil && !ep.rateLimiter.allow(int(msg.GetMessageLen()), numRecords) {
+		return 0, nil
+	}
+	var err error
+	if ep.spool != nil {
+		// Messages are spooled until the spool is empty, to keep their order.
+		if bytesSpooled, spooled, err := ep.spoolMessage(msg, setBytes, numRecords, true); spooled {
+			return bytesSpooled, err
+		}
+	}
+	if conn == nil {
+		if conn, err = ep.nextConn(); err != nil {
+			if ep.spool != nil {
+				bytesSpooled, _, err := ep.spoolMessage(msg, setBytes, numRecords, false)
+				return bytesSpooled, err
+			}
+			return 0, err
+		}
+	}
+	bytesSent, err := conn.send(msg, setBytes, numRecords)
+	if err != nil && ep.spool != nil {
+		bytesSpooled, _, err := ep.spoolMessage(msg, setBytes, numRecords, false)
+		return bytesSpooled, err
 	}
+	return bytesSent, err
+}
 
-	return bytesSent, nil
+// padSet returns a copy of the encoded set padded with zeros to a multiple of
+// setAlignment bytes, with the padding included in the length in the set
+// header. The set is returned as is if it is already aligned.
+func padSet(setBytes []byte) []byte {
+	paddingLen := (setAlignment - len(setBytes)%setAlignment) % setAlignment
+	if paddingLen == 0 {
+		return setBytes
+	}
+	padded := make([]byte, len(setBytes)+paddingLen)
+	copy(padded, setBytes)
+	binary.BigEndian.PutUint16(padded[2:4], uint16(len(padded)))
+	return padded
 }
 
-func (ep *ExportingProcess) updateTemplate(id uint16, elements []*entities.InfoElementWithValue, minDataRecLen uint16) {
+func (ep *ExportingProcess) updateTemplate(id uint16, elements []*entities.InfoElementWithValue, minDataRecLen uint16, scopeFieldCount uint16) {
 	ep.mutex.Lock()
 	defer ep.mutex.Unlock()
 
@@ -270,6 +879,7 @@ func (ep *ExportingProcess) updateTemplate(id uint16, elements []*entities.InfoE
 	ep.templatesMap[id] = templateValue{
 		make([]*entities.InfoElement, len(elements)),
 		minDataRecLen,
+		scopeFieldCount,
 	}
 	for i, elem := range elements {
 		ep.templatesMap[id].elements[i] = elem.Element
@@ -290,26 +900,55 @@ func (ep *ExportingProcess) deleteTemplate(id uint16) error {
 
 func (ep *ExportingProcess) sendRefreshedTemplates() error {
 	// Send refreshed template for every template in template map
+	ep.mutex.Lock()
+	templateIDs := make([]uint16, 0, len(ep.templatesMap))
+	for templateID := range ep.templatesMap {
+		templateIDs = append(templateIDs, templateID)
+	}
+	ep.mutex.Unlock()
+	return ep.sendTemplates(templateIDs)
+}
+
+// sendTemplates sends the given templates again, after the type records.
+func (ep *ExportingProcess) sendTemplates(templateIDs []uint16) error {
+	// Type records are refreshed first, as templates may depend on them.
+	if err := ep.sendRefreshedTypeRecords(); err != nil {
+		return err
+	}
 	templateSets := make([]entities.Set, 0)
 
 	ep.mutex.Lock()
-	for templateID, tempValue := range ep.templatesMap {
-		tempSet := entities.NewSet(false)
-		if err := tempSet.PrepareSet(entities.Template, templateID); err != nil {
-			return err
+	for _, templateID := range templateIDs {
+		tempValue, exists := ep.templatesMap[templateID]
+		if !exists || templateID == ep.typeRecordTemplateID {
+			continue
 		}
+		tempSet := entities.NewSet(false)
 		elements := make([]*entities.InfoElementWithValue, 0)
 		for _, element := range tempValue.elements {
 			ie := entities.NewInfoElementWithValue(element, nil)
 			elements = append(elements, ie)
 		}
-		tempSet.AddRecord(elements, templateID)
+		var err error
+		if tempValue.scopeFieldCount == 0 {
+			if err = tempSet.PrepareSet(entities.Template, entities.TemplateSetID); err == nil {
+				err = tempSet.AddRecord(elements, templateID)
+			}
+		} else {
+			if err = tempSet.PrepareSet(entities.OptionsTemplate, entities.OptionsTemplateSetID); err == nil {
+				err = tempSet.AddOptionsTemplateRecord(elements, tempValue.scopeFieldCount, templateID)
+			}
+		}
+		if err != nil {
+			ep.mutex.Unlock()
+			return err
+		}
 		templateSets = append(templateSets, tempSet)
 	}
 	ep.mutex.Unlock()
 
 	for _, templateSet := range templateSets {
-		if _, err := ep.SendSet(templateSet); err != nil {
+		if _, err := ep.sendSet(templateSet, nil); err != nil {
 			return err
 		}
 	}
@@ -343,6 +982,32 @@ func isChanClosed(ch <-chan struct{}) bool {
 	return false
 }
 
+// createDTLSClientConfig returns the DTLS configuration of the exporting
+// process. The client certificate is presented to collectors which require
+// client authentication, if it is given.
+func createDTLSClientConfig(caCert, clientCert, clientKey []byte) (*dtls.Config, error) {
+	roots := x509.NewCertPool()
+	ok := roots.AppendCertsFromPEM(caCert)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse root certificate")
+	}
+	if clientCert == nil {
+		return &dtls.Config{
+			RootCAs:              roots,
+			ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+		}, nil
+	}
+	cert, err := tls.X509KeyPair(clientCert, clientKey)
+	if err != nil {
+		return nil, err
+	}
+	return &dtls.Config{
+		Certificates:         []tls.Certificate{cert},
+		RootCAs:              roots,
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+	}, nil
+}
+
 func createClientConfig(caCert, clientCert, clientKey []byte) (*tls.Config, error) {
 	roots := x509.NewCertPool()
 	ok := roots.AppendCertsFromPEM(caCert)
@@ -15,8 +15,13 @@
 package exporter
 
 import (
+	"bytes"
 	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
 	"net"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -25,6 +30,7 @@ import (
 
 	"github.com/vmware/go-ipfix/pkg/entities"
 	"github.com/vmware/go-ipfix/pkg/registry"
+	"github.com/vmware/go-ipfix/pkg/util"
 )
 
 const (
@@ -188,7 +194,7 @@ func TestExportingProcess_SendingTemplateRecordToLocalTCPServer(t *testing.T) {
 	}
 	// 32 is the size of the IPFIX message including all headers
 	assert.Equal(t, 32, bytesSent)
-	assert.Equal(t, uint32(0), exporter.seqNumber)
+	assert.Equal(t, uint32(0), exporter.GetSeqNumber())
 	exporter.CloseConnToCollector()
 }
 
@@ -274,7 +280,7 @@ func TestExportingProcess_SendingTemplateRecordToLocalUDPServer(t *testing.T) {
 	assert.Equal(t, bytesAtServer[20:32], bytesAtServer[52:], "both template messages should be same")
 	// 32 is the size of the IPFIX message including all headers
 	assert.Equal(t, 32, bytesSent)
-	assert.Equal(t, uint32(0), exporter.seqNumber)
+	assert.Equal(t, uint32(0), exporter.GetSeqNumber())
 
 	exporter.CloseConnToCollector()
 
@@ -338,7 +344,7 @@ func TestExportingProcess_SendingDataRecordToLocalTCPServer(t *testing.T) {
 	}
 	element2 := entities.NewInfoElementWithValue(element, nil)
 	// Hardcoding 8-bytes min data record length for testing purposes instead of creating template record
-	exporter.updateTemplate(templateID, []*entities.InfoElementWithValue{element1, element2}, 8)
+	exporter.updateTemplate(templateID, []*entities.InfoElementWithValue{element1, element2}, 8, 0)
 
 	// Create data set with 1 data record
 	dataSet := entities.NewSet(false)
@@ -368,7 +374,7 @@ func TestExportingProcess_SendingDataRecordToLocalTCPServer(t *testing.T) {
 	// 28 is the size of the IPFIX message including all headers (20 bytes)
 	assert.Equal(t, 28, bytesSent)
 	assert.Equal(t, dataRecBytes, <-buffCh)
-	assert.Equal(t, uint32(1), exporter.seqNumber)
+	assert.Equal(t, uint32(1), exporter.GetSeqNumber())
 
 	// Create data set with multiple data records to test invalid message length
 	// logic for TCP transport.
@@ -439,7 +445,7 @@ func TestExportingProcess_SendingDataRecordToLocalUDPServer(t *testing.T) {
 	}
 	element2 := entities.NewInfoElementWithValue(element, nil)
 	// Hardcoding 8-bytes min data record length for testing purposes instead of creating template record
-	exporter.updateTemplate(templateID, []*entities.InfoElementWithValue{element1, element2}, 8)
+	exporter.updateTemplate(templateID, []*entities.InfoElementWithValue{element1, element2}, 8, 0)
 
 	// Create data set with 1 data record
 	dataSet := entities.NewSet(false)
@@ -469,7 +475,7 @@ func TestExportingProcess_SendingDataRecordToLocalUDPServer(t *testing.T) {
 	// 28 is the size of the IPFIX message including all headers (20 bytes)
 	assert.Equal(t, 28, bytesSent)
 	assert.Equal(t, dataRecBytes, <-buffCh)
-	assert.Equal(t, uint32(1), exporter.seqNumber)
+	assert.Equal(t, uint32(1), exporter.GetSeqNumber())
 
 	// Create data set with multiple data records to test invalid message length
 	// logic for UDP transport.
@@ -563,7 +569,7 @@ func TestExportingProcessWithTLS(t *testing.T) {
 	}
 	// 32 is the size of the IPFIX message including all headers
 	assert.Equal(t, 32, bytesSent)
-	assert.Equal(t, uint32(0), exporter.seqNumber)
+	assert.Equal(t, uint32(0), exporter.GetSeqNumber())
 	exporter.CloseConnToCollector()
 }
 
@@ -648,10 +654,60 @@ func TestExportingProcessWithDTLS(t *testing.T) {
 	}
 	// 32 is the size of the IPFIX message including all headers
 	assert.Equal(t, 32, bytesSent)
-	assert.Equal(t, uint32(0), exporter.seqNumber)
+	assert.Equal(t, uint32(0), exporter.GetSeqNumber())
 	exporter.CloseConnToCollector()
 }
 
+func TestCreateDTLSClientConfig(t *testing.T) {
+	config, err := createDTLSClientConfig([]byte(fakeCACert), nil, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, config.Certificates)
+	assert.Equal(t, dtls.RequireExtendedMasterSecret, config.ExtendedMasterSecret)
+	config, err = createDTLSClientConfig([]byte(fakeCACert), []byte(fakeCert2), []byte(fakeKey2))
+	assert.NoError(t, err)
+	assert.Len(t, config.Certificates, 1)
+	_, err = createDTLSClientConfig([]byte("invalid"), nil, nil)
+	assert.Error(t, err)
+}
+
+func TestExportingProcess_LatencyProbes(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	defer conn.Close()
+	input := ExporterInput{
+		CollectorAddress:     conn.LocalAddr().String(),
+		CollectorProtocol:    conn.LocalAddr().Network(),
+		ObservationDomainID:  1,
+		LatencyProbeInterval: 10 * time.Millisecond,
+	}
+	startTime := time.Now()
+	exporter, err := InitExportingProcess(input)
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", conn.LocalAddr().String(), err)
+	}
+	defer exporter.CloseConnToCollector()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buff := make([]byte, 512)
+	// The template is sent before the first probe.
+	n, err := conn.Read(buff)
+	assert.NoError(t, err)
+	msg := buff[:n]
+	assert.Equal(t, entities.TemplateSetID, binary.BigEndian.Uint16(msg[16:18]))
+	templateID := binary.BigEndian.Uint16(msg[20:22])
+	assert.Equal(t, uint16(2), binary.BigEndian.Uint16(msg[22:24]))
+	for i := uint64(1); i <= 2; i++ {
+		n, err = conn.Read(buff)
+		assert.NoError(t, err)
+		msg = buff[:n]
+		assert.Equal(t, templateID, binary.BigEndian.Uint16(msg[16:18]))
+		assert.Equal(t, i, binary.BigEndian.Uint64(msg[20:28]))
+		sendTime := time.Unix(0, int64(binary.BigEndian.Uint64(msg[28:36])))
+		assert.True(t, sendTime.After(startTime) && sendTime.Before(time.Now()))
+	}
+}
+
 func TestExportingProcess_GetMsgSizeLimit(t *testing.T) {
 	// Create local server for testing
 	udpAddr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
@@ -679,3 +735,583 @@ func TestExportingProcess_GetMsgSizeLimit(t *testing.T) {
 	t.Logf("Created exporter connecting to local server with address: %s", conn.LocalAddr().String())
 	assert.Equal(t, entities.DefaultUDPMsgSize, exporter.GetMsgSizeLimit())
 }
+
+func TestExportingProcess_SendingTypeRecords(t *testing.T) {
+	// Create local server for testing
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	t.Log("Created local server on random available port for testing")
+
+	msgCh := make(chan []byte, 4)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			header := make([]byte, entities.MsgHeaderLength)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				close(msgCh)
+				return
+			}
+			msg := make([]byte, binary.BigEndian.Uint16(header[2:4]))
+			copy(msg, header)
+			if _, err := io.ReadFull(conn, msg[entities.MsgHeaderLength:]); err != nil {
+				close(msgCh)
+				return
+			}
+			msgCh <- msg
+		}
+	}()
+
+	input := ExporterInput{
+		CollectorAddress:    listener.Addr().String(),
+		CollectorProtocol:   listener.Addr().Network(),
+		ObservationDomainID: 1,
+		SendTypeRecords:     true,
+	}
+	exporter, err := InitExportingProcess(input)
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", listener.Addr().String(), err)
+	}
+
+	// Create template record with one IANA field and one Antrea field.
+	templateID := exporter.NewTemplateID()
+	elements := make([]*entities.InfoElementWithValue, 0)
+	element, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	elements = append(elements, entities.NewInfoElementWithValue(element, nil))
+	element, _ = registry.GetInfoElement("sourcePodName", registry.AntreaEnterpriseID)
+	elements = append(elements, entities.NewInfoElementWithValue(element, nil))
+	for i := 0; i < 2; i++ {
+		templateSet := entities.NewSet(false)
+		assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+		assert.NoError(t, templateSet.AddRecord(elements, templateID))
+		_, err = exporter.SendSet(templateSet)
+		assert.NoError(t, err)
+	}
+	exporter.CloseConnToCollector()
+
+	msgs := make([][]byte, 0)
+	for msg := range msgCh {
+		msgs = append(msgs, msg)
+	}
+	// Options template and type records are sent once ahead of the first
+	// template; the second template does not introduce new elements.
+	assert.Len(t, msgs, 4)
+	assert.NotEqual(t, uint16(0), exporter.typeRecordTemplateID)
+	assert.Equal(t, typeRecordScopeFieldCount, exporter.templatesMap[exporter.typeRecordTemplateID].scopeFieldCount)
+	// Options template set header and record header.
+	optionsTemplate := msgs[0][entities.MsgHeaderLength:]
+	assert.Equal(t, entities.OptionsTemplateSetID, binary.BigEndian.Uint16(optionsTemplate[0:2]))
+	assert.Equal(t, exporter.typeRecordTemplateID, binary.BigEndian.Uint16(optionsTemplate[4:6]))
+	assert.Equal(t, uint16(4), binary.BigEndian.Uint16(optionsTemplate[6:8]))
+	assert.Equal(t, typeRecordScopeFieldCount, binary.BigEndian.Uint16(optionsTemplate[8:10]))
+	// Type record for sourcePodName.
+	typeRecord := msgs[1][entities.MsgHeaderLength:]
+	assert.Equal(t, exporter.typeRecordTemplateID, binary.BigEndian.Uint16(typeRecord[0:2]))
+	assert.Equal(t, element.ElementId, binary.BigEndian.Uint16(typeRecord[4:6]))
+	assert.Equal(t, registry.AntreaEnterpriseID, binary.BigEndian.Uint32(typeRecord[6:10]))
+	assert.Equal(t, uint8(entities.String), typeRecord[10])
+	assert.Equal(t, uint8(len(element.Name)), typeRecord[11])
+	assert.Equal(t, element.Name, string(typeRecord[12:12+len(element.Name)]))
+	assert.Equal(t, entities.TemplateSetID, binary.BigEndian.Uint16(msgs[2][16:18]))
+}
+
+func TestExportingProcess_TypeRecordsSendFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(ioutil.Discard, conn)
+	}()
+	input := ExporterInput{
+		CollectorAddress:    listener.Addr().String(),
+		CollectorProtocol:   listener.Addr().Network(),
+		ObservationDomainID: 1,
+		SendTypeRecords:     true,
+	}
+	exporter, err := InitExportingProcess(input)
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", listener.Addr().String(), err)
+	}
+	element, _ := registry.GetInfoElement("sourcePodName", registry.AntreaEnterpriseID)
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, nil)}, exporter.NewTemplateID()))
+	exporter.CloseConnToCollector()
+
+	// Elements are announced only once their type records are sent.
+	assert.Error(t, exporter.sendTypeRecords(templateSet))
+	assert.Empty(t, exporter.announcedElements)
+}
+
+func TestExportingProcess_PreSendHooks(t *testing.T) {
+	// Create local server for testing
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	setTypes := make([]entities.ContentType, 0)
+	recordHook := func(set entities.Set) (entities.Set, error) {
+		setTypes = append(setTypes, set.GetSetType())
+		return set, nil
+	}
+	input := ExporterInput{
+		CollectorAddress:    listener.Addr().String(),
+		CollectorProtocol:   listener.Addr().Network(),
+		ObservationDomainID: 1,
+		PreSendHooks:        []PreSendHook{recordHook},
+		ValidateDataRecords: true,
+	}
+	exporter, err := InitExportingProcess(input)
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", listener.Addr().String(), err)
+	}
+	defer exporter.CloseConnToCollector()
+
+	templateID := exporter.NewTemplateID()
+	srcElement, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	dstElement, _ := registry.GetInfoElement("destinationIPv4Address", registry.IANAEnterpriseID)
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{
+		entities.NewInfoElementWithValue(srcElement, nil),
+		entities.NewInfoElementWithValue(dstElement, nil),
+	}, templateID))
+	_, err = exporter.SendSet(templateSet)
+	assert.NoError(t, err)
+
+	// Data record with fields in a different order than the template.
+	dataSet := entities.NewSet(false)
+	assert.NoError(t, dataSet.PrepareSet(entities.Data, templateID))
+	assert.NoError(t, dataSet.AddRecord([]*entities.InfoElementWithValue{
+		entities.NewInfoElementWithValue(dstElement, net.ParseIP("10.0.0.2")),
+		entities.NewInfoElementWithValue(srcElement, net.ParseIP("10.0.0.1")),
+	}, templateID))
+	_, err = exporter.SendSet(dataSet)
+	assert.Error(t, err)
+
+	dataSet = entities.NewSet(false)
+	assert.NoError(t, dataSet.PrepareSet(entities.Data, templateID))
+	assert.NoError(t, dataSet.AddRecord([]*entities.InfoElementWithValue{
+		entities.NewInfoElementWithValue(srcElement, net.ParseIP("10.0.0.1")),
+		entities.NewInfoElementWithValue(dstElement, net.ParseIP("10.0.0.2")),
+	}, templateID))
+	bytesSent, err := exporter.SendSet(dataSet)
+	assert.NoError(t, err)
+	assert.Equal(t, 28, bytesSent)
+	// The invalid data set does not reach the hooks after the validator.
+	assert.Equal(t, []entities.ContentType{entities.Template, entities.Data}, setTypes)
+
+	// Sets dropped by a hook are not sent.
+	exporter.AddPreSendHook(func(set entities.Set) (entities.Set, error) {
+		return nil, nil
+	})
+	bytesSent, err = exporter.SendSet(dataSet)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, bytesSent)
+	assert.Equal(t, uint32(1), exporter.GetSeqNumber())
+}
+
+func TestExportingProcess_TemplatePair(t *testing.T) {
+	// Create local server for testing
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(ioutil.Discard, conn)
+	}()
+	input := ExporterInput{
+		CollectorAddress:    listener.Addr().String(),
+		CollectorProtocol:   listener.Addr().Network(),
+		ObservationDomainID: 1,
+	}
+	exporter, err := InitExportingProcess(input)
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", listener.Addr().String(), err)
+	}
+	defer exporter.CloseConnToCollector()
+
+	elements := make([]*entities.InfoElement, 0)
+	for _, name := range []string{"sourceIPv4Address", "destinationIPv4Address", "packetDeltaCount"} {
+		element, _ := registry.GetInfoElement(name, registry.IANAEnterpriseID)
+		elements = append(elements, element)
+	}
+	pair, err := exporter.NewTemplatePair(elements)
+	assert.NoError(t, err)
+	assert.Equal(t, "sourceIPv6Address", pair.GetElements(true)[0].Name)
+	assert.Equal(t, "destinationIPv6Address", pair.GetElements(true)[1].Name)
+	assert.Equal(t, "packetDeltaCount", pair.GetElements(true)[2].Name)
+	assert.Len(t, exporter.templatesMap, 2)
+	assert.Equal(t, uint16(16), exporter.templatesMap[pair.GetTemplateID(false)].minDataRecLen)
+	assert.Equal(t, uint16(40), exporter.templatesMap[pair.GetTemplateID(true)].minDataRecLen)
+
+	// 16 + 4 + 4 + 4 + 8 bytes
+	bytesSent, err := exporter.SendTemplatePairRecord(pair, []interface{}{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), uint64(1)})
+	assert.NoError(t, err)
+	assert.Equal(t, 36, bytesSent)
+	// 16 + 4 + 16 + 16 + 8 bytes
+	bytesSent, err = exporter.SendTemplatePairRecord(pair, []interface{}{net.ParseIP("2001::1"), net.ParseIP("2001::2"), uint64(1)})
+	assert.NoError(t, err)
+	assert.Equal(t, 60, bytesSent)
+	_, err = exporter.SendTemplatePairRecord(pair, []interface{}{net.ParseIP("10.0.0.1"), net.ParseIP("2001::2"), uint64(1)})
+	assert.Error(t, err)
+	_, err = exporter.SendTemplatePairRecord(pair, []interface{}{net.ParseIP("10.0.0.1")})
+	assert.Error(t, err)
+}
+
+func TestExportingProcess_MultipleConnections(t *testing.T) {
+	// Create local server for testing
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	defer listener.Close()
+	numConns := 3
+	// Every connection receives the template message (32 bytes) and one data
+	// message (28 bytes).
+	buffCh := make(chan []byte, numConns)
+	go func() {
+		for i := 0; i < numConns; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buff := make([]byte, 60)
+				if _, err := io.ReadFull(conn, buff); err != nil {
+					t.Error(err)
+				}
+				buffCh <- buff
+			}()
+		}
+	}()
+
+	input := ExporterInput{
+		CollectorAddress:    listener.Addr().String(),
+		CollectorProtocol:   listener.Addr().Network(),
+		ObservationDomainID: 1,
+		NumConnections:      numConns,
+	}
+	exporter, err := InitExportingProcess(input)
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", listener.Addr().String(), err)
+	}
+	defer exporter.CloseConnToCollector()
+
+	templateID := exporter.NewTemplateID()
+	srcElement, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	dstElement, _ := registry.GetInfoElement("destinationIPv4Address", registry.IANAEnterpriseID)
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{
+		entities.NewInfoElementWithValue(srcElement, nil),
+		entities.NewInfoElementWithValue(dstElement, nil),
+	}, templateID))
+	bytesSent, err := exporter.SendSet(templateSet)
+	assert.NoError(t, err)
+	assert.Equal(t, 32, bytesSent)
+
+	for i := 0; i < numConns; i++ {
+		dataSet := entities.NewSet(false)
+		assert.NoError(t, dataSet.PrepareSet(entities.Data, templateID))
+		assert.NoError(t, dataSet.AddRecord([]*entities.InfoElementWithValue{
+			entities.NewInfoElementWithValue(srcElement, net.ParseIP("10.0.0.1")),
+			entities.NewInfoElementWithValue(dstElement, net.ParseIP("10.0.0.2")),
+		}, templateID))
+		bytesSent, err = exporter.SendSet(dataSet)
+		assert.NoError(t, err)
+		assert.Equal(t, 28, bytesSent)
+	}
+	for i := 0; i < numConns; i++ {
+		buff := <-buffCh
+		// Template set ID of the first message, and data set ID and sequence
+		// number of the second message, which is the first data record of the
+		// transport session.
+		assert.Equal(t, entities.TemplateSetID, binary.BigEndian.Uint16(buff[16:18]))
+		assert.Equal(t, uint32(1), binary.BigEndian.Uint32(buff[40:44]))
+		assert.Equal(t, templateID, binary.BigEndian.Uint16(buff[48:50]))
+	}
+	for i, stats := range exporter.GetConnStats() {
+		assert.True(t, stats.Healthy)
+		assert.Equal(t, uint64(2), stats.MessagesSent)
+		assert.Equal(t, uint64(0), stats.SendErrors)
+		assert.Equal(t, uint32(1), exporter.conns[i].seqNumbers[1])
+	}
+	assert.Equal(t, uint32(numConns), exporter.GetSeqNumber())
+
+	// Data messages are only sent on the healthy connections.
+	exporter.conns[0].conn.Close()
+	dataSet := entities.NewSet(false)
+	assert.NoError(t, dataSet.PrepareSet(entities.Data, templateID))
+	assert.NoError(t, dataSet.AddRecord([]*entities.InfoElementWithValue{
+		entities.NewInfoElementWithValue(srcElement, net.ParseIP("10.0.0.1")),
+		entities.NewInfoElementWithValue(dstElement, net.ParseIP("10.0.0.2")),
+	}, templateID))
+	_, err = exporter.SendSet(dataSet)
+	assert.Error(t, err)
+	_, err = exporter.SendSet(dataSet)
+	assert.NoError(t, err)
+	stats := exporter.GetConnStats()
+	assert.False(t, stats[0].Healthy)
+	assert.Equal(t, uint64(1), stats[0].SendErrors)
+	assert.Equal(t, uint64(3), stats[1].MessagesSent)
+}
+
+func TestExportingProcess_PadSets(t *testing.T) {
+	capture := new(bytes.Buffer)
+	input := ExporterInput{
+		CollectorAddress:    "127.0.0.1:1",
+		CollectorProtocol:   "tcp",
+		ObservationDomainID: 1,
+		DryRun:              true,
+		DryRunWriter:        capture,
+		PadSets:             true,
+	}
+	exporter, err := InitExportingProcess(input)
+	assert.NoError(t, err)
+	defer exporter.CloseConnToCollector()
+
+	templateID := exporter.NewTemplateID()
+	portElement, _ := registry.GetInfoElement("sourceTransportPort", registry.IANAEnterpriseID)
+	podElement, _ := registry.GetInfoElement("sourcePodName", registry.AntreaEnterpriseID)
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{
+		entities.NewInfoElementWithValue(portElement, nil),
+		entities.NewInfoElementWithValue(podElement, nil),
+	}, templateID))
+	// The template set is already aligned.
+	bytesSent, err := exporter.SendSet(templateSet)
+	assert.NoError(t, err)
+	assert.Equal(t, 36, bytesSent)
+
+	dataSet := entities.NewSet(false)
+	assert.NoError(t, dataSet.PrepareSet(entities.Data, templateID))
+	assert.NoError(t, dataSet.AddRecord([]*entities.InfoElementWithValue{
+		entities.NewInfoElementWithValue(portElement, uint16(80)),
+		entities.NewInfoElementWithValue(podElement, "pod"),
+	}, templateID))
+	capture.Reset()
+	bytesSent, err = exporter.SendSet(dataSet)
+	assert.NoError(t, err)
+	assert.Equal(t, 28, bytesSent)
+	data := capture.Bytes()
+	assert.Equal(t, 28, len(data))
+	assert.Equal(t, uint16(28), binary.BigEndian.Uint16(data[2:4]))
+	assert.Equal(t, uint16(12), binary.BigEndian.Uint16(data[18:20]))
+	assert.Equal(t, []byte{0, 80, 3, 'p', 'o', 'd', 0, 0}, data[20:28])
+	// The set of the caller is not modified.
+	assert.Equal(t, 10, dataSet.GetBuffer().Len())
+}
+
+func TestExportingProcess_Compression(t *testing.T) {
+	for _, accept := range []bool{true, false} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Got error when creating a local server: %v", err)
+		}
+		buffCh := make(chan []byte)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			hello := make([]byte, len(util.CompressionHello))
+			if _, err := io.ReadFull(conn, hello); err != nil {
+				t.Error(err)
+			}
+			reply := util.CompressionRejected
+			if accept {
+				reply = util.CompressionAccepted
+			}
+			conn.Write([]byte{reply})
+			if accept {
+				if conn, err = util.NewCompressedConn(conn, nil); err != nil {
+					t.Error(err)
+				}
+			}
+			buff := make([]byte, 32)
+			if _, err := io.ReadFull(conn, buff); err != nil {
+				t.Error(err)
+			}
+			buffCh <- buff
+		}()
+
+		input := ExporterInput{
+			CollectorAddress:    listener.Addr().String(),
+			CollectorProtocol:   listener.Addr().Network(),
+			ObservationDomainID: 1,
+			Compression:         true,
+		}
+		exporter, err := InitExportingProcess(input)
+		if err != nil {
+			t.Fatalf("Got error when connecting to local server %s: %v", listener.Addr().String(), err)
+		}
+		srcElement, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+		dstElement, _ := registry.GetInfoElement("destinationIPv4Address", registry.IANAEnterpriseID)
+		templateSet := entities.NewSet(false)
+		assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+		assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{
+			entities.NewInfoElementWithValue(srcElement, nil),
+			entities.NewInfoElementWithValue(dstElement, nil),
+		}, exporter.NewTemplateID()))
+		bytesSent, err := exporter.SendSet(templateSet)
+		assert.NoError(t, err)
+		assert.Equal(t, 32, bytesSent)
+		buff := <-buffCh
+		assert.Equal(t, entities.TemplateSetID, binary.BigEndian.Uint16(buff[16:18]))
+		exporter.CloseConnToCollector()
+		listener.Close()
+	}
+}
+
+func TestExportingProcess_UnixSockets(t *testing.T) {
+	for _, protocol := range []string{"unix", "unixgram"} {
+		t.Run(protocol, func(t *testing.T) {
+			address := filepath.Join(t.TempDir(), "ipfix.sock")
+			buffCh := make(chan []byte, 1)
+			if protocol == "unix" {
+				listener, err := net.Listen(protocol, address)
+				if err != nil {
+					t.Fatalf("Cannot listen on %s: %v", address, err)
+				}
+				defer listener.Close()
+				go func() {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					defer conn.Close()
+					buff := make([]byte, 32)
+					if _, err := io.ReadFull(conn, buff); err != nil {
+						t.Error(err)
+					}
+					buffCh <- buff
+				}()
+			} else {
+				conn, err := net.ListenUnixgram(protocol, &net.UnixAddr{Name: address, Net: protocol})
+				if err != nil {
+					t.Fatalf("Cannot listen on %s: %v", address, err)
+				}
+				defer conn.Close()
+				go func() {
+					buff := make([]byte, 64)
+					n, _, err := conn.ReadFromUnix(buff)
+					if err != nil {
+						t.Error(err)
+					}
+					buffCh <- buff[:n]
+				}()
+			}
+
+			input := ExporterInput{
+				CollectorAddress:    address,
+				CollectorProtocol:   protocol,
+				ObservationDomainID: 1,
+			}
+			exporter, err := InitExportingProcess(input)
+			if err != nil {
+				t.Fatalf("Got error when connecting to %s: %v", address, err)
+			}
+			defer exporter.CloseConnToCollector()
+			assert.Equal(t, entities.MaxTcpSocketMsgSize, exporter.GetMsgSizeLimit())
+			srcElement, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+			dstElement, _ := registry.GetInfoElement("destinationIPv4Address", registry.IANAEnterpriseID)
+			templateSet := entities.NewSet(false)
+			assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+			assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{
+				entities.NewInfoElementWithValue(srcElement, nil),
+				entities.NewInfoElementWithValue(dstElement, nil),
+			}, exporter.NewTemplateID()))
+			bytesSent, err := exporter.SendSet(templateSet)
+			assert.NoError(t, err)
+			assert.Equal(t, 32, bytesSent)
+			buff := <-buffCh
+			assert.Len(t, buff, 32)
+			assert.Equal(t, entities.TemplateSetID, binary.BigEndian.Uint16(buff[16:18]))
+
+			input.IsEncrypted = true
+			_, err = InitExportingProcess(input)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestExportingProcessWithTLS_GetClientCertificate(t *testing.T) {
+	serverCert, err := tls.X509KeyPair([]byte(fakeCert), []byte(fakeKey))
+	assert.NoError(t, err)
+	clientCert, err := tls.X509KeyPair([]byte(fakeCert2), []byte(fakeKey2))
+	assert.NoError(t, err)
+	config := &tls.Config{Certificates: []tls.Certificate{serverCert}, ClientAuth: tls.RequireAnyClientCert}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("Cannot start tls server: %v", err)
+	}
+	defer listener.Close()
+	peerCertCh := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tlsConn := conn.(*tls.Conn)
+		if err := tlsConn.Handshake(); err != nil {
+			t.Error(err)
+			peerCertCh <- nil
+			return
+		}
+		peerCertCh <- tlsConn.ConnectionState().PeerCertificates[0].Raw
+	}()
+
+	calls := 0
+	input := ExporterInput{
+		CollectorAddress:    listener.Addr().String(),
+		CollectorProtocol:   listener.Addr().Network(),
+		ObservationDomainID: 1,
+		IsEncrypted:         true,
+		CACert:              []byte(fakeCACert),
+		GetClientCertificate: func() (*tls.Certificate, error) {
+			calls++
+			return &clientCert, nil
+		},
+	}
+	exporter, err := InitExportingProcess(input)
+	if err != nil {
+		t.Fatalf("Got error when connecting to local tls server %s: %v", listener.Addr(), err)
+	}
+	defer exporter.CloseConnToCollector()
+	assert.Equal(t, clientCert.Certificate[0], <-peerCertCh)
+	assert.Equal(t, 1, calls)
+}
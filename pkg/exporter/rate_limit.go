@@ -0,0 +1,175 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimitPolicy decides what the exporting process does with data messages
+// exceeding the rate limits. See ExporterInput.MaxMessagesPerSecond.
+type RateLimitPolicy uint8
+
+const (
+	// RateLimitPolicyBlock delays the message until it is within the rate
+	// limits, blocking the caller.
+	RateLimitPolicyBlock RateLimitPolicy = iota
+	// RateLimitPolicyDrop drops the message and counts it in RateLimitStats,
+	// so that sending never blocks. The sending function returns 0 bytes
+	// sent and no error, as when a pre-send hook drops a set.
+	RateLimitPolicyDrop
+)
+
+// RateLimitStats contains the counters of the rate limits of the exporting
+// process.
+type RateLimitStats struct {
+	// DroppedMessages and DroppedRecords are the numbers of data messages,
+	// and of data records in them, dropped with RateLimitPolicyDrop.
+	DroppedMessages uint64
+	DroppedRecords  uint64
+	// DelayedMessages is the number of data messages delayed with
+	// RateLimitPolicyBlock.
+	DelayedMessages uint64
+}
+
+// tokenBucket is a token bucket filled with rate tokens per second, up to
+// rate tokens, so that bursts of up to one second are allowed.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
+
+// delay returns the time until n tokens are available. As the bucket never
+// holds more than rate tokens, a full bucket is enough for a larger n, and
+// the tokens missing are taken from the following second.
+func (b *tokenBucket) delay(n float64) time.Duration {
+	if n > b.rate {
+		n = b.rate
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// rateLimiter limits the rate of data messages, in messages and in bytes per
+// second. It is shared by all the observation domains of an exporting
+// process, as they share the connections.
+type rateLimiter struct {
+	// mutex is held while waiting for tokens, so that blocked messages are
+	// sent in turn.
+	mutex  sync.Mutex
+	policy RateLimitPolicy
+	// messages and bytes are nil when not limited.
+	messages        *tokenBucket
+	bytes           *tokenBucket
+	droppedMessages uint64
+	droppedRecords  uint64
+	delayedMessages uint64
+}
+
+func newRateLimiter(maxMessagesPerSecond, maxBytesPerSecond int, policy RateLimitPolicy) (*rateLimiter, error) {
+	if maxMessagesPerSecond < 0 || maxBytesPerSecond < 0 {
+		return nil, fmt.Errorf("invalid rate limits of %d messages and %d bytes per second", maxMessagesPerSecond, maxBytesPerSecond)
+	}
+	if policy > RateLimitPolicyDrop {
+		return nil, fmt.Errorf("unknown rate limit policy %d", policy)
+	}
+	if maxMessagesPerSecond == 0 && maxBytesPerSecond == 0 {
+		return nil, nil
+	}
+	now := time.Now()
+	l := &rateLimiter{policy: policy}
+	if maxMessagesPerSecond > 0 {
+		l.messages = newTokenBucket(maxMessagesPerSecond, now)
+	}
+	if maxBytesPerSecond > 0 {
+		l.bytes = newTokenBucket(maxBytesPerSecond, now)
+	}
+	return l, nil
+}
+
+// allow takes the tokens for a data message of the given length, after
+// waiting for them with RateLimitPolicyBlock. It returns false if the message
+// is dropped.
+func (l *rateLimiter) allow(msgLen int, numRecords uint32) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delayed := false
+	for {
+		now := time.Now()
+		var delay time.Duration
+		if l.messages != nil {
+			l.messages.refill(now)
+			delay = l.messages.delay(1)
+		}
+		if l.bytes != nil {
+			l.bytes.refill(now)
+			if bytesDelay := l.bytes.delay(float64(msgLen)); bytesDelay > delay {
+				delay = bytesDelay
+			}
+		}
+		if delay == 0 {
+			break
+		}
+		if l.policy == RateLimitPolicyDrop {
+			atomic.AddUint64(&l.droppedMessages, 1)
+			atomic.AddUint64(&l.droppedRecords, uint64(numRecords))
+			return false
+		}
+		if !delayed {
+			atomic.AddUint64(&l.delayedMessages, 1)
+			delayed = true
+		}
+		time.Sleep(delay)
+	}
+	if l.messages != nil {
+		l.messages.tokens--
+	}
+	if l.bytes != nil {
+		l.bytes.tokens -= float64(msgLen)
+	}
+	return true
+}
+
+// GetRateLimitStats returns the counters of the rate limits. They are all 0
+// if no rate limit is set.
+func (ep *ExportingProcess) GetRateLimitStats() RateLimitStats {
+	l := ep.rateLimiter
+	if l == nil {
+		return RateLimitStats{}
+	}
+	return RateLimitStats{
+		DroppedMessages: atomic.LoadUint64(&l.droppedMessages),
+		DroppedRecords:  atomic.LoadUint64(&l.droppedRecords),
+		DelayedMessages: atomic.LoadUint64(&l.delayedMessages),
+	}
+}
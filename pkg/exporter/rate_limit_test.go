@@ -0,0 +1,97 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// sendRateLimitTestMessages sends a template, and count data messages of 24
+// bytes, and returns the bytes sent by each.
+func sendRateLimitTestMessages(t *testing.T, exporter *ExportingProcess, count int) []int {
+	element, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	templateID := exporter.NewTemplateID()
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, nil)}, templateID))
+	_, err := exporter.SendSet(templateSet)
+	assert.NoError(t, err)
+	bytesSent := make([]int, count)
+	for i := range bytesSent {
+		dataSet := entities.NewSet(false)
+		assert.NoError(t, dataSet.PrepareSet(entities.Data, templateID))
+		assert.NoError(t, dataSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, net.ParseIP("10.0.0.1"))}, templateID))
+		bytesSent[i], err = exporter.SendSet(dataSet)
+		assert.NoError(t, err)
+	}
+	return bytesSent
+}
+
+func TestExportingProcess_RateLimitDrop(t *testing.T) {
+	capture := new(bytes.Buffer)
+	exporter, err := InitExportingProcess(ExporterInput{
+		CollectorAddress:     "127.0.0.1:1",
+		CollectorProtocol:    "tcp",
+		ObservationDomainID:  1,
+		DryRun:               true,
+		DryRunWriter:         capture,
+		MaxMessagesPerSecond: 2,
+		RateLimitPolicy:      RateLimitPolicyDrop,
+	})
+	assert.NoError(t, err)
+	defer exporter.CloseConnToCollector()
+
+	// The template is not limited, and the burst is of 2 data messages.
+	assert.Equal(t, []int{24, 24, 0, 0}, sendRateLimitTestMessages(t, exporter, 4))
+	assert.Equal(t, 28+2*24, capture.Len())
+	assert.Equal(t, RateLimitStats{DroppedMessages: 2, DroppedRecords: 2}, exporter.GetRateLimitStats())
+	assert.Equal(t, uint32(2), exporter.GetSeqNumber())
+}
+
+func TestExportingProcess_RateLimitBlock(t *testing.T) {
+	capture := new(bytes.Buffer)
+	exporter, err := InitExportingProcess(ExporterInput{
+		CollectorAddress:    "127.0.0.1:1",
+		CollectorProtocol:   "tcp",
+		ObservationDomainID: 1,
+		DryRun:              true,
+		DryRunWriter:        capture,
+		// 4 messages of 24 bytes are sent right away, and the fifth one
+		// once 24 bytes are available again, 200ms later.
+		MaxBytesPerSecond: 100,
+	})
+	assert.NoError(t, err)
+	defer exporter.CloseConnToCollector()
+
+	start := time.Now()
+	assert.Equal(t, []int{24, 24, 24, 24, 24}, sendRateLimitTestMessages(t, exporter, 5))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(100*time.Millisecond))
+	assert.Equal(t, 28+5*24, capture.Len())
+	assert.Equal(t, RateLimitStats{DelayedMessages: 1}, exporter.GetRateLimitStats())
+
+	_, err = InitExportingProcess(ExporterInput{
+		DryRun:            true,
+		MaxBytesPerSecond: -1,
+	})
+	assert.Error(t, err)
+}
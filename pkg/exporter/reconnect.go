@@ -0,0 +1,50 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"time"
+)
+
+const (
+	defaultReconnectInitialBackoff = time.Second
+	defaultReconnectMaxBackoff     = time.Minute
+)
+
+// runReconnect restores the failed connections to the collector, until the
+// exporting process is closed. The delay between failed attempts is doubled
+// every time, up to maxBackoff, and is reset once all the connections are
+// healthy again.
+func (ep *ExportingProcess) runReconnect(initialBackoff, maxBackoff time.Duration) {
+	backoff := initialBackoff
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ep.templateRefCh:
+			return
+		case <-timer.C:
+			if ep.restoreConns() {
+				backoff = initialBackoff
+			} else {
+				backoff *= 2
+				if backoff > maxBackoff {
+					backoff = maxBackoff
+				}
+			}
+			timer.Reset(backoff)
+		}
+	}
+}
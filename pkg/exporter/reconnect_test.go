@@ -0,0 +1,114 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestExportingProcess_Reconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	defer listener.Close()
+	// The first connection receives the template message (32 bytes) and one
+	// data message (28 bytes). The restored connection receives the template
+	// message again before the next data message.
+	buffCh := make(chan []byte, 2)
+	go func() {
+		for _, length := range []int{60, 60} {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(length int) {
+				defer conn.Close()
+				buff := make([]byte, length)
+				if _, err := io.ReadFull(conn, buff); err != nil {
+					t.Error(err)
+				}
+				buffCh <- buff
+			}(length)
+		}
+	}()
+
+	_, err = InitExportingProcess(ExporterInput{
+		CollectorAddress:  listener.Addr().String(),
+		CollectorProtocol: "udp",
+		Reconnect:         true,
+	})
+	assert.Error(t, err)
+
+	exporter, err := InitExportingProcess(ExporterInput{
+		CollectorAddress:        listener.Addr().String(),
+		CollectorProtocol:       listener.Addr().Network(),
+		ObservationDomainID:     1,
+		Reconnect:               true,
+		ReconnectInitialBackoff: 10 * time.Millisecond,
+		ReconnectMaxBackoff:     40 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", listener.Addr().String(), err)
+	}
+	defer exporter.CloseConnToCollector()
+
+	templateID := exporter.NewTemplateID()
+	srcElement, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	dstElement, _ := registry.GetInfoElement("destinationIPv4Address", registry.IANAEnterpriseID)
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{
+		entities.NewInfoElementWithValue(srcElement, nil),
+		entities.NewInfoElementWithValue(dstElement, nil),
+	}, templateID))
+	_, err = exporter.SendSet(templateSet)
+	assert.NoError(t, err)
+	sendDataSet := func(srcIP string) error {
+		dataSet := entities.NewSet(false)
+		assert.NoError(t, dataSet.PrepareSet(entities.Data, templateID))
+		assert.NoError(t, dataSet.AddRecord([]*entities.InfoElementWithValue{
+			entities.NewInfoElementWithValue(srcElement, net.ParseIP(srcIP)),
+			entities.NewInfoElementWithValue(dstElement, net.ParseIP("10.0.0.100")),
+		}, templateID))
+		_, err := exporter.SendSet(dataSet)
+		return err
+	}
+	assert.NoError(t, sendDataSet("10.0.0.1"))
+	<-buffCh
+
+	// Sending fails until the connection is restored in the background.
+	exporter.conns[0].getConn().Close()
+	assert.Error(t, sendDataSet("10.0.0.2"))
+	assert.Eventually(t, func() bool {
+		return exporter.conns[0].isHealthy()
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.NoError(t, sendDataSet("10.0.0.3"))
+	buff := <-buffCh
+	assert.Equal(t, entities.TemplateSetID, binary.BigEndian.Uint16(buff[16:18]))
+	assert.Equal(t, templateID, binary.BigEndian.Uint16(buff[48:50]))
+	assert.Equal(t, []byte{10, 0, 0, 3}, buff[52:56])
+	// The record which could not be sent is reported as lost.
+	assert.Equal(t, uint32(3), binary.BigEndian.Uint32(buff[40:44]))
+}
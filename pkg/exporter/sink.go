@@ -0,0 +1,120 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/sink"
+)
+
+// ExporterSink is a sink.Sink exporting the data records of the messages to a
+// collector, e.g. to forward the messages of a collecting process. A template
+// is exported for every distinct list of elements of the records, as the
+// template IDs of the messages belong to their exporters. Template sets of
+// the messages are not exported.
+type ExporterSink struct {
+	input  ExporterInput
+	ep     *ExportingProcess
+	buffer *ExportBuffer
+	// templateIDs maps the elements of the records to the ID of the template
+	// exported for them.
+	templateIDs map[string]uint16
+}
+
+var _ sink.Sink = &ExporterSink{}
+
+func NewExporterSink(input ExporterInput) *ExporterSink {
+	return &ExporterSink{input: input}
+}
+
+// Open connects to the collector.
+func (s *ExporterSink) Open() error {
+	ep, err := InitExportingProcess(s.input)
+	if err != nil {
+		return err
+	}
+	s.ep = ep
+	s.buffer = ep.NewExportBuffer()
+	s.templateIDs = make(map[string]uint16)
+	return nil
+}
+
+// WriteBatch adds the data records to the sets of their templates, which are
+// sent when full or flushed.
+func (s *ExporterSink) WriteBatch(messages []*entities.Message) error {
+	for _, message := range messages {
+		set := message.GetSet()
+		if set == nil || set.GetSetType() != entities.Data {
+			continue
+		}
+		for _, record := range set.GetRecords() {
+			elements := record.GetOrderedElementList()
+			templateID, err := s.getTemplateID(elements)
+			if err != nil {
+				return err
+			}
+			if err := s.buffer.AddRecord(templateID, elements); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Flush sends the sets of all templates.
+func (s *ExporterSink) Flush() error {
+	_, err := s.buffer.Flush()
+	return err
+}
+
+// Close sends the sets of all templates and closes the connection to the
+// collector.
+func (s *ExporterSink) Close() error {
+	err := s.Flush()
+	s.ep.CloseConnToCollector()
+	return err
+}
+
+// getTemplateID returns the ID of the template of the elements, and exports
+// the template first if it is new.
+func (s *ExporterSink) getTemplateID(elements []*entities.InfoElementWithValue) (uint16, error) {
+	var key strings.Builder
+	for _, element := range elements {
+		fmt.Fprintf(&key, "%d:%d:%d,", element.Element.EnterpriseId, element.Element.ElementId, element.Element.Len)
+	}
+	if templateID, exist := s.templateIDs[key.String()]; exist {
+		return templateID, nil
+	}
+	templateID := s.ep.NewTemplateID()
+	templateElements := make([]*entities.InfoElementWithValue, len(elements))
+	for i, element := range elements {
+		templateElements[i] = entities.NewInfoElementWithValue(element.Element, nil)
+	}
+	templateSet := entities.NewSet(false)
+	if err := templateSet.PrepareSet(entities.Template, entities.TemplateSetID); err != nil {
+		return 0, err
+	}
+	if err := templateSet.AddRecord(templateElements, templateID); err != nil {
+		return 0, err
+	}
+	if _, err := s.ep.SendSet(templateSet); err != nil {
+		return 0, fmt.Errorf("error when sending template for sink records: %v", err)
+	}
+	s.templateIDs[key.String()] = templateID
+	return templateID, nil
+}
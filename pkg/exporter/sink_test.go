@@ -0,0 +1,89 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestExporterSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	defer listener.Close()
+	messagesCh := make(chan []byte, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			header := make([]byte, entities.MsgHeaderLength)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				close(messagesCh)
+				return
+			}
+			body := make([]byte, int(binary.BigEndian.Uint16(header[2:4]))-entities.MsgHeaderLength)
+			if _, err := io.ReadFull(conn, body); err != nil {
+				close(messagesCh)
+				return
+			}
+			messagesCh <- append(header, body...)
+		}
+	}()
+
+	s := NewExporterSink(ExporterInput{
+		CollectorAddress:    listener.Addr().String(),
+		CollectorProtocol:   listener.Addr().Network(),
+		ObservationDomainID: 1,
+	})
+	assert.NoError(t, s.Open())
+	srcElement, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	countElement, _ := registry.GetInfoElement("packetDeltaCount", registry.IANAEnterpriseID)
+	// The messages were decoded by a collector, with template ID 300.
+	createMessage := func(srcIP byte, count byte) *entities.Message {
+		set := entities.NewSet(true)
+		assert.NoError(t, set.PrepareSet(entities.Data, 300))
+		assert.NoError(t, set.AddRecord([]*entities.InfoElementWithValue{
+			entities.NewInfoElementWithValue(srcElement, bytes.NewBuffer([]byte{10, 0, 0, srcIP})),
+			entities.NewInfoElementWithValue(countElement, bytes.NewBuffer([]byte{0, 0, 0, 0, 0, 0, 0, count})),
+		}, 300))
+		message := entities.NewMessage(true)
+		message.AddSet(set)
+		return message
+	}
+	assert.NoError(t, s.WriteBatch([]*entities.Message{createMessage(1, 10), createMessage(2, 20)}))
+	assert.NoError(t, s.Close())
+
+	// The template is exported with an ID of the exporter.
+	templateMessage := <-messagesCh
+	assert.Equal(t, entities.TemplateSetID, binary.BigEndian.Uint16(templateMessage[16:18]))
+	templateID := binary.BigEndian.Uint16(templateMessage[20:22])
+	assert.Equal(t, uint16(256), templateID)
+	dataMessage := <-messagesCh
+	assert.Equal(t, templateID, binary.BigEndian.Uint16(dataMessage[16:18]))
+	assert.Equal(t, []byte{10, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 10, 10, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 20}, dataMessage[20:])
+}
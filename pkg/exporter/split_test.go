@@ -0,0 +1,119 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestExportingProcess_SendSetSplit(t *testing.T) {
+	capture := new(bytes.Buffer)
+	exporter, err := InitExportingProcess(ExporterInput{
+		CollectorAddress:    "127.0.0.1:1",
+		CollectorProtocol:   "tcp",
+		ObservationDomainID: 1,
+		DryRun:              true,
+		DryRunWriter:        capture,
+		// Messages hold up to 10 records of 4 bytes.
+		MaxMessageSize: 60,
+	})
+	assert.NoError(t, err)
+	defer exporter.CloseConnToCollector()
+
+	element, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	templateID := exporter.NewTemplateID()
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, nil)}, templateID))
+	bytesSent, messagesSent, err := exporter.SendSetMessages(templateSet)
+	assert.NoError(t, err)
+	assert.Equal(t, 28, bytesSent)
+	assert.Equal(t, 1, messagesSent)
+	capture.Reset()
+
+	dataSet := entities.NewSet(false)
+	assert.NoError(t, dataSet.PrepareSet(entities.Data, templateID))
+	for i := 0; i < 25; i++ {
+		assert.NoError(t, dataSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, net.IPv4(10, 0, 0, byte(i)))}, templateID))
+	}
+	bytesSent, messagesSent, err = exporter.SendSetMessages(dataSet)
+	assert.NoError(t, err)
+	assert.Equal(t, 160, bytesSent)
+	assert.Equal(t, 3, messagesSent)
+	assert.Equal(t, uint32(25), exporter.GetSeqNumber())
+	// The records are sent in order, in sets of the template.
+	messages := capture.Bytes()
+	for i, msgLen := range []int{60, 60, 40} {
+		assert.Equal(t, uint16(msgLen), binary.BigEndian.Uint16(messages[2:4]))
+		assert.Equal(t, []uint32{10, 20, 25}[i], binary.BigEndian.Uint32(messages[8:12]))
+		assert.Equal(t, templateID, binary.BigEndian.Uint16(messages[16:18]))
+		assert.Equal(t, uint16(msgLen-entities.MsgHeaderLength), binary.BigEndian.Uint16(messages[18:20]))
+		assert.Equal(t, []byte{10, 0, 0, byte(i * 10)}, messages[20:24])
+		messages = messages[msgLen:]
+	}
+	assert.Len(t, messages, 0)
+}
+
+func TestExportingProcess_SendSetSplitRecordTooLarge(t *testing.T) {
+	capture := new(bytes.Buffer)
+	exporter, err := InitExportingProcess(ExporterInput{
+		CollectorAddress:    "127.0.0.1:1",
+		CollectorProtocol:   "tcp",
+		ObservationDomainID: 1,
+		DryRun:              true,
+		DryRunWriter:        capture,
+		// Records can be up to 12 bytes long.
+		MaxMessageSize: 32,
+	})
+	assert.NoError(t, err)
+	defer exporter.CloseConnToCollector()
+
+	countElement, _ := registry.GetInfoElement("packetDeltaCount", registry.IANAEnterpriseID)
+	nameElement, _ := registry.GetInfoElement("interfaceName", registry.IANAEnterpriseID)
+	templateID := exporter.NewTemplateID()
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{
+		entities.NewInfoElementWithValue(countElement, nil),
+		entities.NewInfoElementWithValue(nameElement, nil),
+	}, templateID))
+	_, err = exporter.SendSet(templateSet)
+	assert.NoError(t, err)
+	capture.Reset()
+
+	// The second record does not fit in a message, so the first one is not
+	// sent either.
+	dataSet := entities.NewSet(false)
+	assert.NoError(t, dataSet.PrepareSet(entities.Data, templateID))
+	for _, name := range []string{"", "eth0"} {
+		assert.NoError(t, dataSet.AddRecord([]*entities.InfoElementWithValue{
+			entities.NewInfoElementWithValue(countElement, uint64(1)),
+			entities.NewInfoElementWithValue(nameElement, name),
+		}, templateID))
+	}
+	bytesSent, messagesSent, err := exporter.SendSetMessages(dataSet)
+	assert.Error(t, err)
+	assert.Equal(t, 0, bytesSent)
+	assert.Equal(t, 0, messagesSent)
+	assert.Equal(t, 0, capture.Len())
+}
@@ -0,0 +1,304 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+const (
+	defaultSpoolMaxBytes      int64 = 64 * 1024 * 1024
+	defaultSpoolRetryInterval       = 10 * time.Second
+	spoolFileSuffix                 = ".ipfix"
+	// spoolRecordCountLen is the length of the number of data records stored
+	// ahead of the message in spool files.
+	spoolRecordCountLen = 4
+)
+
+// SpoolStats contains the state of the spool of the exporting process.
+type SpoolStats struct {
+	// SpooledMessages is the number of messages waiting in the spool.
+	SpooledMessages int
+	// SpooledBytes is the size of the messages waiting in the spool.
+	SpooledBytes int64
+	// DroppedMessages is the number of messages dropped because the spool
+	// was full.
+	DroppedMessages uint64
+}
+
+// spool is a bounded queue of messages on disk, with one file per message,
+// named after its position in the queue.
+type spool struct {
+	dir      string
+	maxBytes int64
+	mutex    sync.Mutex
+	// files are the names of the spooled messages, oldest first.
+	files     []string
+	sizes     map[string]int64
+	size      int64
+	nextIndex uint64
+	dropped   uint64
+}
+
+// newSpool creates the spool directory if needed, and loads the messages
+// spooled by a previous run.
+func newSpool(dir string, maxBytes int64) (*spool, error) {
+	if maxBytes <= 0 {
+		maxBytes = defaultSpoolMaxBytes
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("error when creating spool directory %s: %v", dir, err)
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error when reading spool directory %s: %v", dir, err)
+	}
+	s := &spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		sizes:    make(map[string]int64),
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasSuffix(name, spoolFileSuffix) {
+			continue
+		}
+		index, err := strconv.ParseUint(strings.TrimSuffix(name, spoolFileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.files = append(s.files, name)
+		s.sizes[name] = info.Size()
+		s.size += info.Size()
+		if index >= s.nextIndex {
+			s.nextIndex = index + 1
+		}
+	}
+	// Names have a fixed width, so they sort in queue order.
+	sort.Strings(s.files)
+	return s, nil
+}
+
+// push adds the message at the end of the queue. If onlyIfNotEmpty is true,
+// the message is only added if the queue is not empty, and push returns
+// whether it was added.
+func (s *spool) push(message []byte, numRecords uint32, onlyIfNotEmpty bool) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if onlyIfNotEmpty && len(s.files) == 0 {
+		return false, nil
+	}
+	size := int64(spoolRecordCountLen + len(message))
+	if s.size+size > s.maxBytes {
+		s.dropped++
+		return true, fmt.Errorf("spool is full (%d bytes), dropping message", s.size)
+	}
+	data := make([]byte, size)
+	binary.BigEndian.PutUint32(data[0:spoolRecordCountLen], numRecords)
+	copy(data[spoolRecordCountLen:], message)
+	name := fmt.Sprintf("%020d%s", s.nextIndex, spoolFileSuffix)
+	// The file is written under a temporary name, so that a partial file is
+	// not loaded after a crash.
+	tmpPath := filepath.Join(s.dir, name+".tmp")
+	err := ioutil.WriteFile(tmpPath, data, 0640)
+	if err == nil {
+		err = os.Rename(tmpPath, filepath.Join(s.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return true, fmt.Errorf("error when spooling message: %v", err)
+	}
+	s.nextIndex++
+	s.files = append(s.files, name)
+	s.sizes[name] = size
+	s.size += size
+	return true, nil
+}
+
+// peek returns the oldest message of the queue with its number of data
+// records, and false if the queue is empty.
+func (s *spool) peek() (string, []byte, uint32, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.files) == 0 {
+		return "", nil, 0, false, nil
+	}
+	name := s.files[0]
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if err == nil && len(data) < spoolRecordCountLen+entities.MsgHeaderLength {
+		err = fmt.Errorf("file is too short")
+	}
+	if err != nil {
+		return name, nil, 0, true, fmt.Errorf("invalid spool file %s: %v", name, err)
+	}
+	return name, data[spoolRecordCountLen:], binary.BigEndian.Uint32(data[0:spoolRecordCountLen]), true, nil
+}
+
+// remove removes the oldest message of the queue, which has the given name.
+func (s *spool) remove(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.files) == 0 || s.files[0] != name {
+		return
+	}
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+		klog.Errorf("Error when removing spool file %s: %v", name, err)
+	}
+	s.files = s.files[1:]
+	s.size -= s.sizes[name]
+	delete(s.sizes, name)
+}
+
+func (s *spool) getStats() SpoolStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return SpoolStats{
+		SpooledMessages: len(s.files),
+		SpooledBytes:    s.size,
+		DroppedMessages: s.dropped,
+	}
+}
+
+// GetSpoolStats returns the state of the spool. It is empty if spooling is
+// not enabled.
+func (ep *ExportingProcess) GetSpoolStats() SpoolStats {
+	if ep.spool == nil {
+		return SpoolStats{}
+	}
+	return ep.spool.getStats()
+}
+
+// spoolMessage adds the data message to the spool, to be sent when the
+// connection to the collector is restored, and returns the length of the
+// message, as the message is not lost. If onlyIfNotEmpty is true, the message
+// is only spooled if the spool is not empty, and spoolMessage returns whether
+// it was spooled.
+func (ep *ExportingProcess) spoolMessage(msg *entities.Message, setBytes []byte, numRecords uint32, onlyIfNotEmpty bool) (int, bool, error) {
+	message := append(append([]byte{}, msg.GetMsgBuffer().Bytes()...), setBytes...)
+	spooled, err := ep.spool.push(message, numRecords, onlyIfNotEmpty)
+	if !spooled || err != nil {
+		return 0, spooled, err
+	}
+	return len(message), true, nil
+}
+
+// runSpool restores the failed connections to the collector and replays the
+// spooled messages every retry interval, until the exporting process is
+// closed.
+func (ep *ExportingProcess) runSpool(retryInterval time.Duration) {
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ep.templateRefCh:
+			return
+		case <-ticker.C:
+			ep.restoreConns()
+			ep.replaySpool()
+		}
+	}
+}
+
+// restoreConns reconnects the failed connections to the collector, and returns
+// whether all the connections are healthy. The templates are sent again on all
+// connections afterwards, as new transport sessions need them before data
+// messages, and restored connections are only used for data messages once
+// the templates have been sent on them.
+func (ep *ExportingProcess) restoreConns() bool {
+	ep.restoreMutex.Lock()
+	defer ep.restoreMutex.Unlock()
+	return ep.replaceConns(false)
+}
+
+// replaceConns replaces the failed connections to the collector, or all of
+// them if all is set, with new connections, on which the templates are sent
+// again before data messages. It returns whether all the connections are
+// healthy. It must be called with restoreMutex held.
+func (ep *ExportingProcess) replaceConns(all bool) bool {
+	var restoredConns []*collectorConn
+	healthy := true
+	for _, c := range ep.conns {
+		if !all && c.isHealthy() {
+			continue
+		}
+		conn, err := ep.dial()
+		if err != nil {
+			klog.V(2).Infof("Cannot restore the connection to the collector: %v", err)
+			healthy = false
+			continue
+		}
+		c.restore(conn)
+		klog.Infof("Restored connection %s to the collector", conn.LocalAddr())
+		restoredConns = append(restoredConns, c)
+	}
+	if len(restoredConns) == 0 {
+		return healthy
+	}
+	if err := ep.sendAllTemplates(); err != nil {
+		klog.Errorf("Error when sending templates on restored connections: %v", err)
+	}
+	for _, c := range restoredConns {
+		if !c.completeRestore() {
+			healthy = false
+		}
+	}
+	return healthy
+}
+
+// replaySpool sends the spooled messages in order, with new sequence numbers,
+// until the spool is empty or sending fails. The export time of the messages
+// is kept.
+func (ep *ExportingProcess) replaySpool() {
+	replayed := 0
+	for {
+		name, message, numRecords, ok, err := ep.spool.peek()
+		if !ok {
+			break
+		}
+		if err != nil {
+			klog.Errorf("Dropping spooled message: %v", err)
+			ep.spool.remove(name)
+			continue
+		}
+		conn, err := ep.nextConn()
+		if err != nil {
+			break
+		}
+		msg := entities.NewMessage(false)
+		msg.WriteToMsgBuffer(message[:entities.MsgHeaderLength])
+		msg.SetMessageLen(uint16(len(message)))
+		if _, err := conn.send(msg, message[entities.MsgHeaderLength:], numRecords); err != nil {
+			break
+		}
+		ep.spool.remove(name)
+		replayed++
+	}
+	if replayed > 0 {
+		klog.Infof("Sent %d spooled messages to the collector", replayed)
+	}
+}
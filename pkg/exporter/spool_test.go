@@ -0,0 +1,179 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestExportingProcess_Spool(t *testing.T) {
+	spoolDir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(spoolDir)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	defer listener.Close()
+	// The first connection receives the template message (32 bytes) and one
+	// data message (28 bytes). The restored connection receives the template
+	// message and the two spooled data messages.
+	buffCh := make(chan []byte, 2)
+	go func() {
+		for _, length := range []int{60, 88} {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(length int) {
+				defer conn.Close()
+				buff := make([]byte, length)
+				if _, err := io.ReadFull(conn, buff); err != nil {
+					t.Error(err)
+				}
+				buffCh <- buff
+			}(length)
+		}
+	}()
+
+	input := ExporterInput{
+		CollectorAddress:    listener.Addr().String(),
+		CollectorProtocol:   listener.Addr().Network(),
+		ObservationDomainID: 1,
+		SpoolDir:            spoolDir,
+		// The connection is restored by the test.
+		SpoolRetryInterval: time.Hour,
+	}
+	exporter, err := InitExportingProcess(input)
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", listener.Addr().String(), err)
+	}
+	defer exporter.CloseConnToCollector()
+
+	templateID := exporter.NewTemplateID()
+	srcElement, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	dstElement, _ := registry.GetInfoElement("destinationIPv4Address", registry.IANAEnterpriseID)
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{
+		entities.NewInfoElementWithValue(srcElement, nil),
+		entities.NewInfoElementWithValue(dstElement, nil),
+	}, templateID))
+	_, err = exporter.SendSet(templateSet)
+	assert.NoError(t, err)
+	sendDataSet := func(srcIP string) {
+		dataSet := entities.NewSet(false)
+		assert.NoError(t, dataSet.PrepareSet(entities.Data, templateID))
+		assert.NoError(t, dataSet.AddRecord([]*entities.InfoElementWithValue{
+			entities.NewInfoElementWithValue(srcElement, net.ParseIP(srcIP)),
+			entities.NewInfoElementWithValue(dstElement, net.ParseIP("10.0.0.100")),
+		}, templateID))
+		bytesSent, err := exporter.SendSet(dataSet)
+		assert.NoError(t, err)
+		assert.Equal(t, 28, bytesSent)
+	}
+	sendDataSet("10.0.0.1")
+	<-buffCh
+
+	// The data messages are spooled while the collector is unreachable.
+	exporter.conns[0].getConn().Close()
+	exporter.dial = func() (net.Conn, error) {
+		return nil, fmt.Errorf("collector is unreachable")
+	}
+	sendDataSet("10.0.0.2")
+	sendDataSet("10.0.0.3")
+	exporter.restoreConns()
+	exporter.replaySpool()
+	assert.Equal(t, 2, exporter.GetSpoolStats().SpooledMessages)
+	assert.Equal(t, int64(64), exporter.GetSpoolStats().SpooledBytes)
+	files, err := ioutil.ReadDir(spoolDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(files))
+
+	// The templates are sent before the spooled messages once the connection
+	// is restored.
+	exporter.dial = func() (net.Conn, error) {
+		return dialCollector(input)
+	}
+	exporter.restoreConns()
+	exporter.replaySpool()
+	assert.Equal(t, SpoolStats{}, exporter.GetSpoolStats())
+	buff := <-buffCh
+	assert.Equal(t, entities.TemplateSetID, binary.BigEndian.Uint16(buff[16:18]))
+	assert.Equal(t, templateID, binary.BigEndian.Uint16(buff[48:50]))
+	assert.Equal(t, []byte{10, 0, 0, 2}, buff[52:56])
+	assert.Equal(t, templateID, binary.BigEndian.Uint16(buff[76:78]))
+	assert.Equal(t, []byte{10, 0, 0, 3}, buff[80:84])
+	// Sequence numbers continue after the spooled records.
+	assert.Equal(t, uint32(4), binary.BigEndian.Uint32(buff[68:72]))
+	files, err = ioutil.ReadDir(spoolDir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestSpool(t *testing.T) {
+	spoolDir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(spoolDir)
+	s, err := newSpool(spoolDir, 50)
+	assert.NoError(t, err)
+	message := make([]byte, 20)
+	spooled, err := s.push(message, 1, true)
+	assert.False(t, spooled)
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		message[0] = byte(i)
+		spooled, err = s.push(message, uint32(i+1), false)
+		assert.True(t, spooled)
+		assert.NoError(t, err)
+	}
+	// The spool is full.
+	_, err = s.push(message, 3, true)
+	assert.Error(t, err)
+	assert.Equal(t, SpoolStats{2, 48, 1}, s.getStats())
+
+	// The spooled messages are loaded again.
+	s, err = newSpool(spoolDir, 50)
+	assert.NoError(t, err)
+	assert.Equal(t, SpoolStats{2, 48, 0}, s.getStats())
+	for i := 0; i < 2; i++ {
+		name, data, numRecords, ok, err := s.peek()
+		assert.True(t, ok)
+		assert.NoError(t, err)
+		assert.Equal(t, byte(i), data[0])
+		assert.Equal(t, uint32(i+1), numRecords)
+		s.remove(name)
+	}
+	_, _, _, ok, _ := s.peek()
+	assert.False(t, ok)
+	spooled, err = s.push(message, 1, false)
+	assert.True(t, spooled)
+	assert.NoError(t, err)
+	files, err := ioutil.ReadDir(spoolDir)
+	assert.NoError(t, err)
+	assert.Equal(t, "00000000000000000002.ipfix", files[0].Name())
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// exporterState is the state of the exporting process kept in the state file.
+type exporterState struct {
+	// TemplateID is the last assigned template ID.
+	TemplateID uint16 `json:"templateID"`
+	// Templates maps the elements of templates to their stable template IDs.
+	Templates map[string]uint16 `json:"templates,omitempty"`
+	// SequenceNumbers are the sequence numbers of the connections, for the
+	// observation domain of the exporting process.
+	SequenceNumbers []uint32 `json:"sequenceNumbers,omitempty"`
+}
+
+// loadState restores the template IDs and sequence numbers from the state
+// file, if it exists.
+func (ep *ExportingProcess) loadState() error {
+	data, err := ioutil.ReadFile(ep.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error when reading exporter state file %s: %v", ep.stateFile, err)
+	}
+	var state exporterState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("invalid exporter state file %s: %v", ep.stateFile, err)
+	}
+	if state.TemplateID > ep.templateID {
+		ep.templateID = state.TemplateID
+	}
+	for key, templateID := range state.Templates {
+		ep.stableTemplateIDs[key] = templateID
+	}
+	for i, seqNumber := range state.SequenceNumbers {
+		if i < len(ep.conns) {
+			ep.conns[i].mutex.Lock()
+			ep.conns[i].seqNumbers[ep.obsDomainID] = seqNumber
+			ep.conns[i].mutex.Unlock()
+		}
+	}
+	return nil
+}
+
+// SaveState writes the template IDs and the sequence numbers of the
+// connections to the state file, if ExporterInput.StateFile was given, so
+// that they are restored when the exporting process is restarted. It is called
+// when a stable template ID is assigned and when the connections are closed,
+// and can be called periodically, e.g. after every export cycle, so that
+// sequence numbers are recent after a crash.
+func (ep *ExportingProcess) SaveState() error {
+	if ep.stateFile == "" {
+		return nil
+	}
+	ep.mutex.Lock()
+	state := exporterState{
+		TemplateID: ep.templateID,
+		Templates:  make(map[string]uint16, len(ep.stableTemplateIDs)),
+	}
+	for key, templateID := range ep.stableTemplateIDs {
+		state.Templates[key] = templateID
+	}
+	ep.mutex.Unlock()
+	for _, c := range ep.conns {
+		c.mutex.Lock()
+		state.SequenceNumbers = append(state.SequenceNumbers, c.seqNumbers[ep.obsDomainID])
+		c.mutex.Unlock()
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	// The file is replaced atomically, so that a crash does not leave a
+	// partial state.
+	f, err := ioutil.TempFile(filepath.Dir(ep.stateFile), filepath.Base(ep.stateFile)+".tmp")
+	if err != nil {
+		return fmt.Errorf("error when writing exporter state file %s: %v", ep.stateFile, err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), ep.stateFile)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("error when writing exporter state file %s: %v", ep.stateFile, err)
+	}
+	return nil
+}
+
+// GetStableTemplateID returns the template ID assigned to the template with
+// the given elements, in order. A new ID is assigned the first time, and the
+// same ID is returned afterwards, including after restarts if
+// ExporterInput.StateFile was given, so that caches of templates by ID in
+// downstream consumers stay valid.
+func (ep *ExportingProcess) GetStableTemplateID(elements []*entities.InfoElement) (uint16, error) {
+	key := templateKey(elements)
+	ep.mutex.Lock()
+	templateID, exist := ep.stableTemplateIDs[key]
+	if exist {
+		ep.mutex.Unlock()
+		return templateID, nil
+	}
+	templateID = ep.allocateTemplateID()
+	ep.stableTemplateIDs[key] = templateID
+	ep.mutex.Unlock()
+	return templateID, ep.SaveState()
+}
+
+// templateKey identifies a template by its field specifiers.
+func templateKey(elements []*entities.InfoElement) string {
+	fields := make([]string, len(elements))
+	for i, element := range elements {
+		fields[i] = fmt.Sprintf("%d/%d/%d", element.EnterpriseId, element.ElementId, element.Len)
+	}
+	return strings.Join(fields, ",")
+}
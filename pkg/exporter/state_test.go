@@ -0,0 +1,100 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestExportingProcess_StateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exporter-state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	capture := new(bytes.Buffer)
+	input := ExporterInput{
+		CollectorAddress:    "127.0.0.1:4739",
+		CollectorProtocol:   "tcp",
+		ObservationDomainID: 1,
+		DryRun:              true,
+		DryRunWriter:        capture,
+		StateFile:           filepath.Join(dir, "state"),
+	}
+	srcElement, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	dstElement, _ := registry.GetInfoElement("destinationIPv4Address", registry.IANAEnterpriseID)
+	elements := []*entities.InfoElement{srcElement, dstElement}
+	// sendRecords sends the template and data records, and returns the
+	// sequence number of the last data message.
+	sendRecords := func(exporter *ExportingProcess, templateID uint16, numRecords int) uint32 {
+		templateSet := entities.NewSet(false)
+		assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+		assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{
+			entities.NewInfoElementWithValue(srcElement, nil),
+			entities.NewInfoElementWithValue(dstElement, nil),
+		}, templateID))
+		_, err := exporter.SendSet(templateSet)
+		assert.NoError(t, err)
+		for i := 0; i < numRecords; i++ {
+			dataSet := entities.NewSet(false)
+			assert.NoError(t, dataSet.PrepareSet(entities.Data, templateID))
+			assert.NoError(t, dataSet.AddRecord([]*entities.InfoElementWithValue{
+				entities.NewInfoElementWithValue(srcElement, net.ParseIP("10.0.0.1")),
+				entities.NewInfoElementWithValue(dstElement, net.ParseIP("10.0.0.2")),
+			}, templateID))
+			capture.Reset()
+			_, err = exporter.SendSet(dataSet)
+			assert.NoError(t, err)
+		}
+		return binary.BigEndian.Uint32(capture.Bytes()[8:12])
+	}
+
+	exporter, err := InitExportingProcess(input)
+	assert.NoError(t, err)
+	templateID, err := exporter.GetStableTemplateID(elements)
+	assert.NoError(t, err)
+	sameTemplateID, err := exporter.GetStableTemplateID(elements)
+	assert.NoError(t, err)
+	assert.Equal(t, templateID, sameTemplateID)
+	otherTemplateID, err := exporter.GetStableTemplateID([]*entities.InfoElement{dstElement, srcElement})
+	assert.NoError(t, err)
+	assert.NotEqual(t, templateID, otherTemplateID)
+	assert.Equal(t, uint32(3), sendRecords(exporter, templateID, 3))
+	exporter.CloseConnToCollector()
+
+	// Template IDs and sequence numbers are restored after a restart.
+	exporter, err = InitExportingProcess(input)
+	assert.NoError(t, err)
+	defer exporter.CloseConnToCollector()
+	restoredTemplateID, err := exporter.GetStableTemplateID(elements)
+	assert.NoError(t, err)
+	assert.Equal(t, templateID, restoredTemplateID)
+	assert.Greater(t, exporter.NewTemplateID(), otherTemplateID)
+	assert.Equal(t, uint32(5), sendRecords(exporter, templateID, 2))
+
+	// An invalid state file is reported.
+	assert.NoError(t, ioutil.WriteFile(input.StateFile, []byte("invalid"), 0644))
+	_, err = InitExportingProcess(input)
+	assert.Error(t, err)
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// TemplatePair is a pair of templates with the same logical elements, one for
+// IPv4 flows and one for IPv6 flows. Both templates are registered in the same
+// exporting process, so they are refreshed together.
+type TemplatePair struct {
+	ipv4TemplateID uint16
+	ipv6TemplateID uint16
+	ipv4Elements   []*entities.InfoElement
+	ipv6Elements   []*entities.InfoElement
+}
+
+// GetTemplateID returns the ID of the IPv4 or IPv6 template of the pair.
+func (tp *TemplatePair) GetTemplateID(isIPv6 bool) uint16 {
+	if isIPv6 {
+		return tp.ipv6TemplateID
+	}
+	return tp.ipv4TemplateID
+}
+
+// GetElements returns the elements of the IPv4 or IPv6 template of the pair.
+func (tp *TemplatePair) GetElements(isIPv6 bool) []*entities.InfoElement {
+	if isIPv6 {
+		return tp.ipv6Elements
+	}
+	return tp.ipv4Elements
+}
+
+// NewTemplatePair creates the IPv4 and IPv6 templates for the given elements
+// and sends them. Elements are given with their IPv4 variant; for the IPv6
+// template, every element with "IPv4" in its name is replaced by the element
+// with "IPv6" in its name from the registry, e.g. sourceIPv4Address by
+// sourceIPv6Address.
+func (ep *ExportingProcess) NewTemplatePair(elements []*entities.InfoElement) (*TemplatePair, error) {
+	ipv6Elements := make([]*entities.InfoElement, len(elements))
+	for i, element := range elements {
+		if !strings.Contains(element.Name, "IPv4") {
+			ipv6Elements[i] = element
+			continue
+		}
+		ipv6Name := strings.Replace(element.Name, "IPv4", "IPv6", 1)
+		ipv6Element, err := registry.GetInfoElement(ipv6Name, element.EnterpriseId)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find IPv6 variant of element %s: %v", element.Name, err)
+		}
+		ipv6Elements[i] = ipv6Element
+	}
+	pair := &TemplatePair{
+		ipv4Elements: elements,
+		ipv6Elements: ipv6Elements,
+	}
+	pair.ipv4TemplateID = ep.NewTemplateID()
+	pair.ipv6TemplateID = ep.NewTemplateID()
+	for _, isIPv6 := range []bool{false, true} {
+		templateSet := entities.NewSet(false)
+		if err := templateSet.PrepareSet(entities.Template, entities.TemplateSetID); err != nil {
+			return nil, err
+		}
+		templateElements := make([]*entities.InfoElementWithValue, 0)
+		for _, element := range pair.GetElements(isIPv6) {
+			templateElements = append(templateElements, entities.NewInfoElementWithValue(element, nil))
+		}
+		if err := templateSet.AddRecord(templateElements, pair.GetTemplateID(isIPv6)); err != nil {
+			return nil, err
+		}
+		if _, err := ep.SendSet(templateSet); err != nil {
+			return nil, err
+		}
+	}
+	return pair, nil
+}
+
+// SendTemplatePairRecord sends a data record with the given values, in the
+// order of the elements of the pair. The record is sent with the IPv6 template
+// if the values of IP address elements are IPv6 addresses, and with the IPv4
+// template otherwise.
+func (ep *ExportingProcess) SendTemplatePairRecord(pair *TemplatePair, values []interface{}) (int, error) {
+	if len(values) != len(pair.ipv4Elements) {
+		return 0, fmt.Errorf("number of values %d does not match number of elements %d in template pair", len(values), len(pair.ipv4Elements))
+	}
+	isIPv6, err := isIPv6Record(pair.ipv4Elements, values)
+	if err != nil {
+		return 0, err
+	}
+	dataSet := entities.NewSet(false)
+	templateID := pair.GetTemplateID(isIPv6)
+	if err := dataSet.PrepareSet(entities.Data, templateID); err != nil {
+		return 0, err
+	}
+	elements := make([]*entities.InfoElementWithValue, len(values))
+	for i, element := range pair.GetElements(isIPv6) {
+		elements[i] = entities.NewInfoElementWithValue(element, values[i])
+	}
+	if err := dataSet.AddRecord(elements, templateID); err != nil {
+		return 0, err
+	}
+	return ep.SendSet(dataSet)
+}
+
+func isIPv6Record(ipv4Elements []*entities.InfoElement, values []interface{}) (bool, error) {
+	var isIPv6, found bool
+	for i, element := range ipv4Elements {
+		if element.DataType != entities.Ipv4Address {
+			continue
+		}
+		ip, ok := values[i].(net.IP)
+		if !ok {
+			return false, fmt.Errorf("value of element %s is not net.IP", element.Name)
+		}
+		ipIsIPv6 := ip.To4() == nil
+		if found && ipIsIPv6 != isIPv6 {
+			return false, fmt.Errorf("values of IP address elements have different address families")
+		}
+		isIPv6, found = ipIsIPv6, true
+	}
+	return isIPv6, nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// templateRefresh is the refresh schedule of a template over datagram
+// transports.
+type templateRefresh struct {
+	// interval is the time after which the template is sent again, and packets
+	// is the number of data messages after which it is sent again. They are
+	// disabled if 0.
+	interval time.Duration
+	packets  uint32
+	// lastSent and lastSentMessage are the time and the number of data
+	// messages sent when the template was last sent.
+	lastSent        time.Time
+	lastSentMessage uint64
+}
+
+// SetTemplateRefresh sets when the template is sent again over datagram
+// transports, instead of ExporterInput.TempRefTimeout and
+// ExporterInput.TempRefPackets: every interval, and every given number of data
+// messages (RFC7011 section 8.4). Either of them can be 0 to disable it. It can
+// be called before the template is sent for the first time.
+func (ep *ExportingProcess) SetTemplateRefresh(templateID uint16, interval time.Duration, packets uint32) error {
+	if !ep.refreshTemplates {
+		return fmt.Errorf("templates are only refreshed over datagram transports")
+	}
+	if interval <= 0 && packets == 0 {
+		return fmt.Errorf("template %d needs a refresh interval or a number of packets", templateID)
+	}
+	ep.mutex.Lock()
+	refresh, exists := ep.templateRefreshes[templateID]
+	if !exists {
+		refresh = &templateRefresh{lastSent: time.Now(), lastSentMessage: ep.dataMessages}
+		ep.templateRefreshes[templateID] = refresh
+	}
+	refresh.interval = interval
+	refresh.packets = packets
+	ep.mutex.Unlock()
+	// The refresh goroutine may be waiting for a later template.
+	select {
+	case ep.refreshWakeCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// markTemplateSent resets the refresh schedule of the template, which was just
+// sent.
+func (ep *ExportingProcess) markTemplateSent(templateID uint16, now time.Time) {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	refresh, exists := ep.templateRefreshes[templateID]
+	if !exists {
+		refresh = &templateRefresh{interval: ep.refreshInterval, packets: ep.refreshPackets}
+		ep.templateRefreshes[templateID] = refresh
+	}
+	refresh.lastSent = now
+	refresh.lastSentMessage = ep.dataMessages
+}
+
+// countDataMessage counts a data message sent to the collector, and returns
+// whether a template is due for refresh by number of packets.
+func (ep *ExportingProcess) countDataMessage() bool {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	ep.dataMessages++
+	for templateID, refresh := range ep.templateRefreshes {
+		if _, exists := ep.templatesMap[templateID]; !exists {
+			continue
+		}
+		if refresh.packets > 0 && ep.dataMessages-refresh.lastSentMessage >= uint64(refresh.packets) {
+			return true
+		}
+	}
+	return false
+}
+
+// dataMessageSent counts a data message sent to the collector, and sends the
+// templates due for refresh by number of packets.
+func (ep *ExportingProcess) dataMessageSent() {
+	if !ep.refreshTemplates || !ep.countDataMessage() {
+		return
+	}
+	if _, err := ep.refreshDueTemplates(time.Now()); err != nil {
+		klog.Errorf("Error when sending refreshed templates: %v", err)
+	}
+}
+
+// getDueTemplates returns the templates due for refresh, and the time at which
+// the next template is due by interval, or zero if none is.
+func (ep *ExportingProcess) getDueTemplates(now time.Time) ([]uint16, time.Time) {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	var dueIDs []uint16
+	var next time.Time
+	for templateID, refresh := range ep.templateRefreshes {
+		// Type records are sent again with every refresh of templates.
+		if _, exists := ep.templatesMap[templateID]; !exists || templateID == ep.typeRecordTemplateID {
+			continue
+		}
+		if refresh.packets > 0 && ep.dataMessages-refresh.lastSentMessage >= uint64(refresh.packets) {
+			dueIDs = append(dueIDs, templateID)
+			continue
+		}
+		if refresh.interval <= 0 {
+			continue
+		}
+		due := refresh.lastSent.Add(refresh.interval)
+		if !due.After(now) {
+			dueIDs = append(dueIDs, templateID)
+		} else if next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	return dueIDs, next
+}
+
+// refreshDueTemplates sends the templates due for refresh, and returns the
+// time until the next template is due.
+func (ep *ExportingProcess) refreshDueTemplates(now time.Time) (time.Duration, error) {
+	dueIDs, next := ep.getDueTemplates(now)
+	if len(dueIDs) > 0 {
+		if err := ep.sendTemplates(dueIDs); err != nil {
+			return 0, err
+		}
+		// The next template may be due after the refreshed ones.
+		_, next = ep.getDueTemplates(now)
+	}
+	if next.IsZero() {
+		return ep.refreshInterval, nil
+	}
+	return next.Sub(now), nil
+}
+
+// runTemplateRefresh sends the templates again when they are due, until the
+// exporting process is closed.
+func (ep *ExportingProcess) runTemplateRefresh() {
+	timer := time.NewTimer(ep.refreshInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ep.templateRefCh:
+			return
+		case <-ep.refreshWakeCh:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+		}
+		delay, err := ep.refreshDueTemplates(time.Now())
+		if err != nil {
+			// Other option is sending messages through channel to library consumers
+			klog.Errorf("Error when sending refreshed templates: %v. Closing the connection to IPFIX controller", err)
+			ep.CloseConnToCollector()
+			return
+		}
+		timer.Reset(delay)
+	}
+}
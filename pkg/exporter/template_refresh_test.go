@@ -0,0 +1,106 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestExportingProcess_SetTemplateRefresh(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	defer conn.Close()
+	input := ExporterInput{
+		CollectorAddress:    conn.LocalAddr().String(),
+		CollectorProtocol:   conn.LocalAddr().Network(),
+		ObservationDomainID: 1,
+		TempRefTimeout:      3600,
+	}
+	exporter, err := InitExportingProcess(input)
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", conn.LocalAddr().String(), err)
+	}
+	defer exporter.CloseConnToCollector()
+
+	// The first template is refreshed every 2 data messages, and the second
+	// one every 100ms.
+	element, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	packetsTemplateID := exporter.NewTemplateID()
+	intervalTemplateID := exporter.NewTemplateID()
+	assert.NoError(t, exporter.SetTemplateRefresh(packetsTemplateID, 0, 2))
+	assert.NoError(t, exporter.SetTemplateRefresh(intervalTemplateID, 100*time.Millisecond, 0))
+	assert.Error(t, exporter.SetTemplateRefresh(intervalTemplateID, 0, 0))
+	for _, templateID := range []uint16{packetsTemplateID, intervalTemplateID} {
+		templateSet := entities.NewSet(false)
+		assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+		assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, nil)}, templateID))
+		_, err = exporter.SendSet(templateSet)
+		assert.NoError(t, err)
+	}
+	for i := 0; i < 2; i++ {
+		dataSet := entities.NewSet(false)
+		assert.NoError(t, dataSet.PrepareSet(entities.Data, packetsTemplateID))
+		assert.NoError(t, dataSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, net.ParseIP("10.0.0.1"))}, packetsTemplateID))
+		_, err = exporter.SendSet(dataSet)
+		assert.NoError(t, err)
+	}
+
+	// templateIDs are the template IDs of the received template messages,
+	// and 0 for data messages.
+	var templateIDs []uint16
+	buff := make([]byte, entities.DefaultUDPMsgSize)
+	conn.SetReadDeadline(time.Now().Add(350 * time.Millisecond))
+	for {
+		n, err := conn.Read(buff)
+		if err != nil {
+			break
+		}
+		var templateID uint16
+		if binary.BigEndian.Uint16(buff[16:18]) == entities.TemplateSetID && n >= 22 {
+			templateID = binary.BigEndian.Uint16(buff[20:22])
+		}
+		templateIDs = append(templateIDs, templateID)
+	}
+	count := func(id uint16) int {
+		n := 0
+		for _, templateID := range templateIDs {
+			if templateID == id {
+				n++
+			}
+		}
+		return n
+	}
+	// The first template is sent again right after the second data message,
+	// and not afterwards as no more data messages are sent.
+	assert.Equal(t, 2, count(packetsTemplateID))
+	assert.GreaterOrEqual(t, count(intervalTemplateID), 3)
+	assert.Equal(t, 2, count(0))
+	for i := len(templateIDs) - 1; i > 0; i-- {
+		if templateIDs[i] == packetsTemplateID {
+			assert.Equal(t, uint16(0), templateIDs[i-1], "template should be refreshed after the data messages")
+			break
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// WithdrawTemplate withdraws the template from the collector with a template
+// withdrawal (RFC7011 section 8.1), after the data records of the template
+// waiting in the asynchronous send queue, and frees its ID, which is returned
+// again by NewTemplateID and GetStableTemplateID. Templates can only be
+// withdrawn over stream transports; over datagram transports, they expire at
+// the collector once they are not refreshed anymore.
+func (ep *ExportingProcess) WithdrawTemplate(templateID uint16) error {
+	if ep.refreshTemplates {
+		return fmt.Errorf("templates can only be withdrawn over stream transports")
+	}
+	if err := ep.Flush(); err != nil {
+		return fmt.Errorf("error when sending queued sets before withdrawing template %d: %v", templateID, err)
+	}
+	ep.mutex.Lock()
+	tempValue, exists := ep.templatesMap[templateID]
+	isTypeRecordTemplate := templateID == ep.typeRecordTemplateID
+	ep.mutex.Unlock()
+	if !exists {
+		return fmt.Errorf("process: template %d does not exist in exporting process", templateID)
+	}
+	if isTypeRecordTemplate {
+		return fmt.Errorf("template %d of type records cannot be withdrawn", templateID)
+	}
+
+	withdrawalSet := entities.NewSet(false)
+	var err error
+	if tempValue.scopeFieldCount == 0 {
+		err = withdrawalSet.PrepareSet(entities.Template, entities.TemplateSetID)
+	} else {
+		err = withdrawalSet.PrepareSet(entities.OptionsTemplate, entities.OptionsTemplateSetID)
+	}
+	if err != nil {
+		return err
+	}
+	// A template record without fields withdraws the template.
+	if err := withdrawalSet.AddRecord(nil, templateID); err != nil {
+		return err
+	}
+	withdrawalSet.UpdateLenInHeader()
+	if _, err := ep.createAndSendMsg(withdrawalSet, nil); err != nil {
+		return fmt.Errorf("error when sending withdrawal of template %d: %v", templateID, err)
+	}
+	return ep.releaseTemplate(templateID)
+}
+
+// releaseTemplate deletes the withdrawn template, so that it is not sent
+// again, and frees its ID.
+func (ep *ExportingProcess) releaseTemplate(templateID uint16) error {
+	if err := ep.deleteTemplate(templateID); err != nil {
+		return err
+	}
+	ep.mutex.Lock()
+	delete(ep.templateRefreshes, templateID)
+	stableTemplate := false
+	for key, id := range ep.stableTemplateIDs {
+		if id == templateID {
+			delete(ep.stableTemplateIDs, key)
+			stableTemplate = true
+		}
+	}
+	ep.freeTemplateIDs = append(ep.freeTemplateIDs, templateID)
+	ep.mutex.Unlock()
+	if stableTemplate {
+		return ep.SaveState()
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestExportingProcess_WithdrawTemplate(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	defer listener.Close()
+	messagesCh := make(chan []byte, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			header := make([]byte, entities.MsgHeaderLength)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				return
+			}
+			body := make([]byte, int(binary.BigEndian.Uint16(header[2:4]))-entities.MsgHeaderLength)
+			if _, err := io.ReadFull(conn, body); err != nil {
+				return
+			}
+			messagesCh <- body
+		}
+	}()
+
+	exporter, err := InitExportingProcess(ExporterInput{
+		CollectorAddress:    listener.Addr().String(),
+		CollectorProtocol:   listener.Addr().Network(),
+		ObservationDomainID: 1,
+	})
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", listener.Addr().String(), err)
+	}
+	defer exporter.CloseConnToCollector()
+
+	addressElement, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	countElement, _ := registry.GetInfoElement("packetDeltaCount", registry.IANAEnterpriseID)
+	templateID := exporter.NewTemplateID()
+	optionsTemplateID := exporter.NewTemplateID()
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(addressElement, nil)}, templateID))
+	_, err = exporter.SendSet(templateSet)
+	assert.NoError(t, err)
+	optionsSet := entities.NewSet(false)
+	assert.NoError(t, optionsSet.PrepareSet(entities.OptionsTemplate, entities.OptionsTemplateSetID))
+	assert.NoError(t, optionsSet.AddOptionsTemplateRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(addressElement, nil), entities.NewInfoElementWithValue(countElement, nil)}, 1, optionsTemplateID))
+	_, err = exporter.SendSet(optionsSet)
+	assert.NoError(t, err)
+	<-messagesCh
+	<-messagesCh
+
+	assert.NoError(t, exporter.WithdrawTemplate(templateID))
+	assert.Equal(t, []byte{0, 2, 0, 8, byte(templateID >> 8), byte(templateID), 0, 0}, <-messagesCh)
+	assert.NoError(t, exporter.WithdrawTemplate(optionsTemplateID))
+	assert.Equal(t, []byte{0, 3, 0, 8, byte(optionsTemplateID >> 8), byte(optionsTemplateID), 0, 0}, <-messagesCh)
+	assert.Error(t, exporter.WithdrawTemplate(templateID))
+
+	// Data records of withdrawn templates are rejected.
+	dataSet := entities.NewSet(false)
+	assert.NoError(t, dataSet.PrepareSet(entities.Data, templateID))
+	assert.NoError(t, dataSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(addressElement, net.ParseIP("10.0.0.1"))}, templateID))
+	_, err = exporter.SendSet(dataSet)
+	assert.Error(t, err)
+
+	// The IDs of withdrawn templates are reused.
+	assert.Equal(t, templateID, exporter.NewTemplateID())
+	assert.Equal(t, optionsTemplateID, exporter.NewTemplateID())
+	assert.Equal(t, optionsTemplateID+1, exporter.NewTemplateID())
+}
+
+func TestExportingProcess_WithdrawTemplateOverUDP(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("Got error when creating a local server: %v", err)
+	}
+	defer conn.Close()
+	exporter, err := InitExportingProcess(ExporterInput{
+		CollectorAddress:    conn.LocalAddr().String(),
+		CollectorProtocol:   conn.LocalAddr().Network(),
+		ObservationDomainID: 1,
+	})
+	if err != nil {
+		t.Fatalf("Got error when connecting to local server %s: %v", conn.LocalAddr().String(), err)
+	}
+	defer exporter.CloseConnToCollector()
+	element, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	templateID := exporter.NewTemplateID()
+	templateSet := entities.NewSet(false)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	assert.NoError(t, templateSet.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, nil)}, templateID))
+	_, err = exporter.SendSet(templateSet)
+	assert.NoError(t, err)
+	assert.Error(t, exporter.WithdrawTemplate(templateID))
+}
@@ -0,0 +1,203 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// Information Element Type Records are defined in RFC5610. They are data records
+// of an options template scoped by (informationElementId, privateEnterpriseNumber),
+// which let collectors decode enterprise-specific elements without sharing the
+// registry out of band.
+const (
+	// typeRecordScopeFieldCount is the number of scope fields in the options
+	// template used for type records.
+	typeRecordScopeFieldCount uint16 = 2
+)
+
+type typeRecordKey struct {
+	enterpriseID uint32
+	elementID    uint16
+}
+
+// typeRecordTemplateElements returns the fields of the options template used
+// for type records. IANA elements are built here so that the registry does not
+// need to be loaded by the exporter. informationElementSemantics is optional in
+// RFC5610 and is not sent, as elements do not carry their semantics.
+func typeRecordTemplateElements() []*entities.InfoElement {
+	return []*entities.InfoElement{
+		entities.NewInfoElement("informationElementId", 303, entities.Unsigned16, registry.IANAEnterpriseID, 2),
+		entities.NewInfoElement("privateEnterpriseNumber", 346, entities.Unsigned32, registry.IANAEnterpriseID, 4),
+		entities.NewInfoElement("informationElementDataType", 339, entities.Unsigned8, registry.IANAEnterpriseID, 1),
+		entities.NewInfoElement("informationElementName", 341, entities.String, registry.IANAEnterpriseID, entities.VariableLength),
+	}
+}
+
+// isEnterpriseSpecific returns true for elements that standard collectors cannot
+// decode without type records. Reverse elements are defined by RFC5103 and do
+// not need them.
+func isEnterpriseSpecific(element *entities.InfoElement) bool {
+	return element.EnterpriseId != registry.IANAEnterpriseID && element.EnterpriseId != registry.IANAReversedEnterpriseID
+}
+
+// sendTypeRecords sends type records for enterprise-specific elements of the given
+// template set that have not been announced yet. The options template for type
+// records is sent before the first type record.
+func (ep *ExportingProcess) sendTypeRecords(templateSet entities.Set) error {
+	newElements := make([]*entities.InfoElement, 0)
+	pending := make(map[typeRecordKey]bool)
+	ep.mutex.Lock()
+	for _, record := range templateSet.GetRecords() {
+		for _, ie := range record.GetOrderedElementList() {
+			key := typeRecordKey{ie.Element.EnterpriseId, ie.Element.ElementId}
+			if !isEnterpriseSpecific(ie.Element) || ep.announcedElements[key] != nil || pending[key] {
+				continue
+			}
+			pending[key] = true
+			newElements = append(newElements, ie.Element)
+		}
+	}
+	ep.mutex.Unlock()
+	if len(newElements) == 0 {
+		return nil
+	}
+	if ep.getTypeRecordTemplateID() == 0 {
+		if err := ep.sendTypeRecordTemplate(ep.NewTemplateID()); err != nil {
+			return err
+		}
+	}
+	return ep.sendTypeRecordData(newElements)
+}
+
+// sendTypeRecordTemplate sends the options template for type records.
+func (ep *ExportingProcess) sendTypeRecordTemplate(templateID uint16) error {
+	optionsSet := entities.NewSet(false)
+	if err := optionsSet.PrepareSet(entities.OptionsTemplate, entities.OptionsTemplateSetID); err != nil {
+		return err
+	}
+	elements := make([]*entities.InfoElementWithValue, 0)
+	for _, element := range typeRecordTemplateElements() {
+		elements = append(elements, entities.NewInfoElementWithValue(element, nil))
+	}
+	if err := optionsSet.AddOptionsTemplateRecord(elements, typeRecordScopeFieldCount, templateID); err != nil {
+		return fmt.Errorf("error when creating options template for type records: %v", err)
+	}
+	if _, err := ep.sendSet(optionsSet, nil); err != nil {
+		return fmt.Errorf("error when sending options template for type records: %v", err)
+	}
+	ep.mutex.Lock()
+	ep.typeRecordTemplateID = templateID
+	ep.mutex.Unlock()
+	return nil
+}
+
+func (ep *ExportingProcess) getTypeRecordTemplateID() uint16 {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	return ep.typeRecordTemplateID
+}
+
+// sendTypeRecordData sends one type record per element. Records are split across
+// data sets so that every message fits in the message size limit.
+func (ep *ExportingProcess) sendTypeRecordData(elements []*entities.InfoElement) error {
+	maxSetLen := ep.GetMsgSizeLimit() - entities.MsgHeaderLength
+	start, setLen := 0, entities.SetHeaderLen
+	for i, element := range elements {
+		recordLen := typeRecordLen(element)
+		if i > start && setLen+recordLen > maxSetLen {
+			if err := ep.sendTypeRecordSet(elements[start:i]); err != nil {
+				return err
+			}
+			start, setLen = i, entities.SetHeaderLen
+		}
+		setLen += recordLen
+	}
+	return ep.sendTypeRecordSet(elements[start:])
+}
+
+// typeRecordLen returns the encoded length of the type record for the element.
+func typeRecordLen(element *entities.InfoElement) int {
+	// informationElementId, privateEnterpriseNumber and informationElementDataType
+	// are fixed length.
+	length := 2 + 4 + 1 + len(element.Name)
+	if len(element.Name) < 255 {
+		return length + 1
+	}
+	return length + 3
+}
+
+// sendTypeRecordSet sends the type records of the elements in one data set. The
+// elements are announced once the set is sent, so that they are sent again with
+// the next template if sending fails.
+func (ep *ExportingProcess) sendTypeRecordSet(elements []*entities.InfoElement) error {
+	typeRecordTemplateID := ep.getTypeRecordTemplateID()
+	dataSet := entities.NewSet(false)
+	if err := dataSet.PrepareSet(entities.Data, typeRecordTemplateID); err != nil {
+		return err
+	}
+	templateElements := typeRecordTemplateElements()
+	for _, element := range elements {
+		values := []interface{}{element.ElementId, element.EnterpriseId, uint8(element.DataType), element.Name}
+		record := make([]*entities.InfoElementWithValue, len(templateElements))
+		for i, templateElement := range templateElements {
+			record[i] = entities.NewInfoElementWithValue(templateElement, values[i])
+		}
+		if err := dataSet.AddRecord(record, typeRecordTemplateID); err != nil {
+			return fmt.Errorf("error when creating type record for element %s: %v", element.Name, err)
+		}
+	}
+	if _, err := ep.sendSet(dataSet, nil); err != nil {
+		return fmt.Errorf("error when sending type records: %v", err)
+	}
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	for _, element := range elements {
+		ep.announcedElements[typeRecordKey{element.EnterpriseId, element.ElementId}] = element
+	}
+	return nil
+}
+
+// sendRefreshedTypeRecords resends the options template for type records and
+// type records for all announced elements. It is called when templates are
+// refreshed over UDP.
+func (ep *ExportingProcess) sendRefreshedTypeRecords() error {
+	ep.mutex.Lock()
+	typeRecordTemplateID := ep.typeRecordTemplateID
+	if typeRecordTemplateID == 0 {
+		ep.mutex.Unlock()
+		return nil
+	}
+	elements := make([]*entities.InfoElement, 0, len(ep.announcedElements))
+	for _, element := range ep.announcedElements {
+		elements = append(elements, element)
+	}
+	ep.mutex.Unlock()
+	if err := ep.sendTypeRecordTemplate(typeRecordTemplateID); err != nil {
+		return err
+	}
+	return ep.sendTypeRecordData(elements)
+}
+
+// isTypeRecordSet returns true if the data set contains type records.
+func (ep *ExportingProcess) isTypeRecordSet(dataSet entities.Set) bool {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	records := dataSet.GetRecords()
+	return ep.typeRecordTemplateID != 0 && len(records) > 0 && records[0].GetTemplateID() == ep.typeRecordTemplateID
+}
@@ -0,0 +1,446 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// Expression is a predicate over the Information Element values of a record,
+// written in a small expression language:
+//
+//	protocolIdentifier == 6 && destinationTransportPort in (80, 443)
+//	sourceIPv4Address in "10.0.0.0/8" && !(sourcePodNamespace == "kube-system")
+//	has(reversePacketTotalCount) || packetTotalCount >= 1000
+//
+// Comparisons have an element name on the left and a literal on the right:
+// a number, a string in double quotes, or true/false. They follow the rules of
+// Condition, e.g. IP addresses are compared with strings such as "10.0.0.1".
+// The "in" operator matches any of the literals in parentheses; for IP
+// addresses, literals may be CIDRs. has(name) is true if the record contains
+// the element, and a bare element name is true if the element is a boolean
+// with value true. Comparisons can be combined with ||, && and !, in
+// increasing order of precedence, and grouped with parentheses.
+//
+// Expressions are limited to 64 KiB and to 64 levels of nested parentheses and
+// negations. Evaluating an expression has no side effects and always
+// terminates, so expressions from configuration files can be parsed and
+// evaluated safely.
+type Expression struct {
+	source string
+	root   node
+}
+
+const (
+	maxExpressionLength = 64 * 1024
+	maxExpressionDepth  = 64
+)
+
+// ParseExpression parses the expression, and returns an error describing the
+// position of the first syntax error.
+func ParseExpression(source string) (*Expression, error) {
+	if len(source) > maxExpressionLength {
+		return nil, fmt.Errorf("invalid expression: length %d exceeds the maximum of %d bytes", len(source), maxExpressionLength)
+	}
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", source, err)
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = fmt.Errorf("unexpected %s", p.peek())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", source, err)
+	}
+	return &Expression{source, root}, nil
+}
+
+// Match returns whether the record satisfies the expression.
+func (e *Expression) Match(record entities.Record) bool {
+	return e.root.evaluate(record)
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+type node interface {
+	evaluate(record entities.Record) bool
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (n *andNode) evaluate(record entities.Record) bool {
+	return n.left.evaluate(record) && n.right.evaluate(record)
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (n *orNode) evaluate(record entities.Record) bool {
+	return n.left.evaluate(record) || n.right.evaluate(record)
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) evaluate(record entities.Record) bool {
+	return !n.operand.evaluate(record)
+}
+
+type conditionNode struct {
+	condition Condition
+}
+
+func (n *conditionNode) evaluate(record entities.Record) bool {
+	return matchCondition(record, n.condition)
+}
+
+type inNode struct {
+	name   string
+	values []interface{}
+	// ipNets are the values which are CIDRs
+	ipNets []*net.IPNet
+}
+
+func (n *inNode) evaluate(record entities.Record) bool {
+	ie, exist := record.GetInfoElementWithValue(n.name)
+	if !exist || ie.Value == nil {
+		return false
+	}
+	if ip, ok := ie.Value.(net.IP); ok {
+		for _, ipNet := range n.ipNets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+	}
+	for _, value := range n.values {
+		if result, ok := compare(ie.Value, value); ok && result == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type hasNode struct {
+	name string
+}
+
+func (n *hasNode) evaluate(record entities.Record) bool {
+	_, exist := record.GetInfoElementWithValue(n.name)
+	return exist
+}
+
+type boolNode struct {
+	name string
+}
+
+func (n *boolNode) evaluate(record entities.Record) bool {
+	value, ok := getValue(record, n.name).(bool)
+	return ok && value
+}
+
+type tokenKind uint8
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenNumber
+	tokenString
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// pos is the offset of the token in the source
+	pos int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q at position %d", t.text, t.pos)
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", ","}
+
+func tokenize(source string) ([]token, error) {
+	tokens := make([]token, 0)
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(source) && (source[i] == '_' || unicode.IsLetter(rune(source[i])) || unicode.IsDigit(rune(source[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdentifier, source[start:i], start})
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			start := i
+			i++
+			for i < len(source) && (source[i] == '.' || source[i] == 'e' || source[i] == 'E' || unicode.IsDigit(rune(source[i])) ||
+				((source[i] == '-' || source[i] == '+') && (source[i-1] == 'e' || source[i-1] == 'E'))) {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, source[start:i], start})
+		case c == '"':
+			start := i
+			for i++; i < len(source) && source[i] != '"'; i++ {
+				if source[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(source) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			text, err := strconv.Unquote(source[start:i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %v", start, err)
+			}
+			tokens = append(tokens, token{tokenString, text, start})
+		default:
+			found := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, token{tokenOperator, op, i})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(source)}), nil
+}
+
+var comparisonOperators = map[string]Operator{
+	"==": OpEqual,
+	"!=": OpNotEqual,
+	"<":  OpLess,
+	"<=": OpLessOrEqual,
+	">":  OpGreater,
+	">=": OpGreaterOrEqual,
+}
+
+type parser struct {
+	tokens []token
+	next   int
+	// depth is the number of nested parentheses and negations being parsed.
+	depth int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+func (p *parser) isOperator(op string) bool {
+	t := p.peek()
+	return t.kind == tokenOperator && t.text == op
+}
+
+// enter increases the nesting depth, which is decreased by leave, and fails if
+// it exceeds maxExpressionDepth.
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > maxExpressionDepth {
+		return fmt.Errorf("expression is nested more than %d levels deep at %s", maxExpressionDepth, p.peek())
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) expectOperator(op string) error {
+	if !p.isOperator(op) {
+		return fmt.Errorf("expected %q instead of %s", op, p.peek())
+	}
+	p.advance()
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("||") {
+		p.advance()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("&&") {
+		p.advance()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.isOperator("!") {
+		p.advance()
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	if p.isOperator("(") {
+		p.advance()
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expectOperator(")")
+	}
+	t := p.advance()
+	if t.kind != tokenIdentifier {
+		return nil, fmt.Errorf("expected element name instead of %s", t)
+	}
+	if t.text == "has" && p.isOperator("(") {
+		p.advance()
+		name := p.advance()
+		if name.kind != tokenIdentifier {
+			return nil, fmt.Errorf("expected element name instead of %s", name)
+		}
+		return &hasNode{name.text}, p.expectOperator(")")
+	}
+	next := p.peek()
+	if next.kind == tokenIdentifier && next.text == "in" {
+		p.advance()
+		return p.parseIn(t.text)
+	}
+	if op, ok := comparisonOperators[next.text]; ok && next.kind == tokenOperator {
+		p.advance()
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		return &conditionNode{Condition{t.text, op, value}}, nil
+	}
+	return &boolNode{t.text}, nil
+}
+
+func (p *parser) parseIn(name string) (node, error) {
+	n := &inNode{name: name}
+	list := p.isOperator("(")
+	if list {
+		p.advance()
+	}
+	for {
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		n.values = append(n.values, value)
+		if s, ok := value.(string); ok && strings.Contains(s, "/") {
+			_, ipNet, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %s: %v", s, err)
+			}
+			n.ipNets = append(n.ipNets, ipNet)
+		}
+		if !list || !p.isOperator(",") {
+			break
+		}
+		p.advance()
+	}
+	if list {
+		return n, p.expectOperator(")")
+	}
+	return n, nil
+}
+
+func (p *parser) parseLiteral() (interface{}, error) {
+	t := p.advance()
+	switch t.kind {
+	case tokenString:
+		return t.text, nil
+	case tokenNumber:
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return i, nil
+		}
+		if u, err := strconv.ParseUint(t.text, 10, 64); err == nil {
+			return u, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", t)
+		}
+		return f, nil
+	case tokenIdentifier:
+		if t.text == "true" || t.text == "false" {
+			return t.text == "true", nil
+		}
+	}
+	return nil, fmt.Errorf("expected value instead of %s", t)
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpression_Match(t *testing.T) {
+	record := createRecord(t)
+	testCases := []struct {
+		expression string
+		match      bool
+	}{
+		{`protocolIdentifier == 6`, true},
+		{`protocolIdentifier != 6`, false},
+		{`packetTotalCount > 99 && packetTotalCount <= 100`, true},
+		{`packetTotalCount >= 1e3`, false},
+		{`packetTotalCount > -5`, true},
+		{`destinationTransportPort in (80, 443)`, true},
+		{`destinationTransportPort in (80, 8080)`, false},
+		{`sourceIPv4Address in "10.0.0.0/24"`, true},
+		{`destinationIPv4Address in ("10.0.0.0/24", "fd00::/8")`, false},
+		{`destinationIPv4Address in ("10.0.0.0/24", "10.0.1.2")`, true},
+		{`sourceIPv4Address == "10.0.0.1"`, true},
+		{`sourcePodNamespace == "kube-system" || protocolIdentifier == 17`, true},
+		{`!(sourcePodNamespace == "kube-system")`, false},
+		{`protocolIdentifier == 17 || protocolIdentifier == 6 && packetTotalCount < 10`, false},
+		{`(protocolIdentifier == 17 || protocolIdentifier == 6) && packetTotalCount < 1000`, true},
+		{`has(packetTotalCount) && !has(octetTotalCount)`, true},
+		{`octetTotalCount < 10`, false},
+		{`isIPv4`, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.expression, func(t *testing.T) {
+			expression, err := ParseExpression(tc.expression)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.match, expression.Match(record))
+			assert.Equal(t, tc.expression, expression.String())
+		})
+	}
+}
+
+func TestParseExpression_Invalid(t *testing.T) {
+	for _, expression := range []string{
+		``,
+		`protocolIdentifier ==`,
+		`protocolIdentifier == 6 &&`,
+		`(protocolIdentifier == 6`,
+		`protocolIdentifier == 6)`,
+		`6 == protocolIdentifier`,
+		`sourcePodName == "pod`,
+		`sourceIPv4Address in ("10.0.0.0/33")`,
+		`destinationTransportPort in (80 443)`,
+		`packetTotalCount > 1.2.3`,
+		`protocolIdentifier = 6`,
+		`has(6)`,
+	} {
+		_, err := ParseExpression(expression)
+		assert.Error(t, err, expression)
+	}
+}
+
+func TestParseExpression_Limits(t *testing.T) {
+	nested := strings.Repeat("(", maxExpressionDepth) + "isIPv4" + strings.Repeat(")", maxExpressionDepth)
+	_, err := ParseExpression(nested)
+	assert.NoError(t, err)
+	_, err = ParseExpression("(" + nested + ")")
+	assert.Error(t, err)
+	_, err = ParseExpression(strings.Repeat("!", maxExpressionDepth+1) + "isIPv4")
+	assert.Error(t, err)
+	// Deeply nested expressions are rejected without exhausting the stack.
+	_, err = ParseExpression(strings.Repeat("(", 1000000))
+	assert.Error(t, err)
+	_, err = ParseExpression(strings.Repeat("isIPv4 || ", maxExpressionLength/10+1) + "isIPv4")
+	assert.Error(t, err)
+}
+
+func TestFilter_Expression(t *testing.T) {
+	record := createRecord(t)
+	f, err := NewFilter(RecordFilter{Protocols: []uint8{6}, Expression: `destinationTransportPort == 443`})
+	assert.NoError(t, err)
+	assert.True(t, f.Match(record))
+	f, err = NewFilter(RecordFilter{Protocols: []uint8{6}, Expression: `destinationTransportPort == 80`})
+	assert.NoError(t, err)
+	assert.False(t, f.Match(record))
+	_, err = NewFilter(RecordFilter{Expression: `destinationTransportPort ==`})
+	assert.Error(t, err)
+}
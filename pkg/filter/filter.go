@@ -0,0 +1,407 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// Predicate selects records. It is implemented by Filter and Expression, so
+// that features selecting records (subscriptions, routing, alerting) can be
+// configured with either.
+type Predicate interface {
+	Match(record entities.Record) bool
+}
+
+// Operator is the comparison applied by a Condition.
+type Operator uint8
+
+const (
+	OpEqual Operator = iota
+	OpNotEqual
+	OpLess
+	OpLessOrEqual
+	OpGreater
+	OpGreaterOrEqual
+)
+
+func (op Operator) String() string {
+	switch op {
+	case OpEqual:
+		return "=="
+	case OpNotEqual:
+		return "!="
+	case OpLess:
+		return "<"
+	case OpLessOrEqual:
+		return "<="
+	case OpGreater:
+		return ">"
+	case OpGreaterOrEqual:
+		return ">="
+	default:
+		return fmt.Sprintf("Operator(%d)", uint8(op))
+	}
+}
+
+// Condition compares the value of the Information Element with the given name
+// in a record with Value. Value may be any Go numeric type, a string, a bool,
+// a net.IP or a net.HardwareAddr; IP and MAC addresses may also be given as
+// strings. A record without the element does not match the condition.
+type Condition struct {
+	Name  string
+	Op    Operator
+	Value interface{}
+}
+
+// RecordFilter selects data records by flow key and by Information Element
+// values. Every non-empty field must match for a record to be selected; within
+// a field, any of the values may match. An empty RecordFilter selects every
+// record.
+type RecordFilter struct {
+	// SourceCIDRs match sourceIPv4Address or sourceIPv6Address.
+	SourceCIDRs []string
+	// DestinationCIDRs match destinationIPv4Address or destinationIPv6Address.
+	DestinationCIDRs []string
+	// SourcePorts match sourceTransportPort.
+	SourcePorts []uint16
+	// DestinationPorts match destinationTransportPort.
+	DestinationPorts []uint16
+	// Protocols match protocolIdentifier.
+	Protocols  []uint8
+	Conditions []Condition
+	// Expression is an additional predicate in the syntax of ParseExpression.
+	Expression string
+}
+
+// Filter is a validated RecordFilter, ready to be evaluated against records.
+// The fields of the RecordFilter are compiled into the nodes of an Expression,
+// so that both are evaluated the same way.
+type Filter struct {
+	// root is nil for an empty RecordFilter.
+	root node
+}
+
+// NewFilter validates the record filter and returns the corresponding Filter.
+func NewFilter(input RecordFilter) (*Filter, error) {
+	nodes := make([]node, 0)
+	for _, selector := range []struct {
+		prefix string
+		cidrs  []string
+	}{{"source", input.SourceCIDRs}, {"destination", input.DestinationCIDRs}} {
+		if len(selector.cidrs) == 0 {
+			continue
+		}
+		ipNets, err := parseCIDRs(selector.cidrs)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, newIPNode(selector.prefix, ipNets))
+	}
+	if len(input.SourcePorts) > 0 {
+		nodes = append(nodes, newInNode("sourceTransportPort", input.SourcePorts))
+	}
+	if len(input.DestinationPorts) > 0 {
+		nodes = append(nodes, newInNode("destinationTransportPort", input.DestinationPorts))
+	}
+	if len(input.Protocols) > 0 {
+		nodes = append(nodes, newInNode("protocolIdentifier", input.Protocols))
+	}
+	for _, condition := range input.Conditions {
+		if condition.Op > OpGreaterOrEqual {
+			return nil, fmt.Errorf("invalid operator %v in condition on %s", condition.Op, condition.Name)
+		}
+		if !isSupportedValue(condition.Value) {
+			return nil, fmt.Errorf("unsupported value %v of type %T in condition on %s", condition.Value, condition.Value, condition.Name)
+		}
+		nodes = append(nodes, &conditionNode{condition})
+	}
+	if input.Expression != "" {
+		expression, err := ParseExpression(input.Expression)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, expression.root)
+	}
+	f := &Filter{}
+	for _, n := range nodes {
+		if f.root == nil {
+			f.root = n
+		} else {
+			f.root = &andNode{f.root, n}
+		}
+	}
+	return f, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	ipNets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %s in filter: %v", cidr, err)
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets, nil
+}
+
+// newIPNode returns the node matching the IPv4 address with the given prefix,
+// e.g. sourceIPv4Address, or the IPv6 address if the record has no IPv4
+// address, against the CIDRs. It is equivalent to the expression
+//
+//	sourceIPv4Address in (cidrs) || (!has(sourceIPv4Address) && sourceIPv6Address in (cidrs))
+func newIPNode(prefix string, ipNets []*net.IPNet) node {
+	ipv4Name := prefix + "IPv4Address"
+	return &orNode{
+		&inNode{name: ipv4Name, ipNets: ipNets},
+		&andNode{&notNode{&hasNode{ipv4Name}}, &inNode{name: prefix + "IPv6Address", ipNets: ipNets}},
+	}
+}
+
+// newInNode returns the node matching the element against any of the values.
+func newInNode(name string, values interface{}) node {
+	n := &inNode{name: name}
+	switch v := values.(type) {
+	case []uint16:
+		for _, value := range v {
+			n.values = append(n.values, value)
+		}
+	case []uint8:
+		for _, value := range v {
+			n.values = append(n.values, value)
+		}
+	}
+	return n
+}
+
+// Match returns whether the record is selected by the filter. Only data
+// records can match a filter with selectors or conditions.
+func (f *Filter) Match(record entities.Record) bool {
+	return f.root == nil || f.root.evaluate(record)
+}
+
+func getValue(record entities.Record, name string) interface{} {
+	if ie, exist := record.GetInfoElementWithValue(name); exist {
+		return ie.Value
+	}
+	return nil
+}
+
+func matchCondition(record entities.Record, condition Condition) bool {
+	ie, exist := record.GetInfoElementWithValue(condition.Name)
+	if !exist || ie.Value == nil {
+		return false
+	}
+	result, ok := compare(ie.Value, condition.Value)
+	if !ok {
+		return false
+	}
+	switch condition.Op {
+	case OpEqual:
+		return result == 0
+	case OpNotEqual:
+		return result != 0
+	case OpLess:
+		return result < 0
+	case OpLessOrEqual:
+		return result <= 0
+	case OpGreater:
+		return result > 0
+	case OpGreaterOrEqual:
+		return result >= 0
+	}
+	return false
+}
+
+type numberKind uint8
+
+const (
+	notNumber numberKind = iota
+	unsignedNumber
+	signedNumber
+	floatNumber
+)
+
+// number holds a numeric value in the widest type of its kind.
+type number struct {
+	kind numberKind
+	u    uint64
+	i    int64
+	f    float64
+}
+
+func toNumber(v interface{}) number {
+	switch n := v.(type) {
+	case uint8:
+		return number{kind: unsignedNumber, u: uint64(n)}
+	case uint16:
+		return number{kind: unsignedNumber, u: uint64(n)}
+	case uint32:
+		return number{kind: unsignedNumber, u: uint64(n)}
+	case uint64:
+		return number{kind: unsignedNumber, u: n}
+	case uint:
+		return number{kind: unsignedNumber, u: uint64(n)}
+	case int8:
+		return number{kind: signedNumber, i: int64(n)}
+	case int16:
+		return number{kind: signedNumber, i: int64(n)}
+	case int32:
+		return number{kind: signedNumber, i: int64(n)}
+	case int64:
+		return number{kind: signedNumber, i: n}
+	case int:
+		return number{kind: signedNumber, i: int64(n)}
+	case float32:
+		return number{kind: floatNumber, f: float64(n)}
+	case float64:
+		return number{kind: floatNumber, f: n}
+	}
+	return number{kind: notNumber}
+}
+
+func (n number) toFloat() float64 {
+	switch n.kind {
+	case unsignedNumber:
+		return float64(n.u)
+	case signedNumber:
+		return float64(n.i)
+	}
+	return n.f
+}
+
+func compareNumbers(a, b number) int {
+	if a.kind == floatNumber || b.kind == floatNumber {
+		return compareFloats(a.toFloat(), b.toFloat())
+	}
+	if a.kind == signedNumber && b.kind == signedNumber {
+		return compareInts(a.i, b.i)
+	}
+	// At least one of them is unsigned: negative values are smaller than any
+	// unsigned value, and non-negative values can be compared as unsigned.
+	if a.kind == signedNumber {
+		if a.i < 0 {
+			return -1
+		}
+		a.u = uint64(a.i)
+	}
+	if b.kind == signedNumber {
+		if b.i < 0 {
+			return 1
+		}
+		b.u = uint64(b.i)
+	}
+	switch {
+	case a.u < b.u:
+		return -1
+	case a.u > b.u:
+		return 1
+	}
+	return 0
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareBools(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case !a:
+		return -1
+	}
+	return 1
+}
+
+func isSupportedValue(v interface{}) bool {
+	if toNumber(v).kind != notNumber {
+		return true
+	}
+	switch v.(type) {
+	case string, bool, net.IP, net.HardwareAddr:
+		return true
+	}
+	return false
+}
+
+// compare compares the value of an element with the value of a condition. It
+// returns false if the values cannot be compared.
+func compare(value interface{}, conditionValue interface{}) (int, bool) {
+	if a := toNumber(value); a.kind != notNumber {
+		b := toNumber(conditionValue)
+		if b.kind == notNumber {
+			return 0, false
+		}
+		return compareNumbers(a, b), true
+	}
+	switch v := value.(type) {
+	case string:
+		s, ok := conditionValue.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(v, s), true
+	case bool:
+		b, ok := conditionValue.(bool)
+		if !ok {
+			return 0, false
+		}
+		return compareBools(v, b), true
+	case net.IP:
+		ip, ok := conditionValue.(net.IP)
+		if s, isString := conditionValue.(string); isString {
+			ip, ok = net.ParseIP(s), true
+		}
+		if !ok || ip == nil {
+			return 0, false
+		}
+		return bytes.Compare(v.To16(), ip.To16()), true
+	case net.HardwareAddr:
+		mac, ok := conditionValue.(net.HardwareAddr)
+		if s, isString := conditionValue.(string); isString {
+			var err error
+			mac, err = net.ParseMAC(s)
+			ok = err == nil
+		}
+		if !ok {
+			return 0, false
+		}
+		return bytes.Compare(v, mac), true
+	}
+	return 0, false
+}
@@ -0,0 +1,160 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func init() {
+	registry.LoadRegistry()
+}
+
+func createRecord(t *testing.T) entities.Record {
+	record := entities.NewDataRecord(256)
+	values := []struct {
+		name         string
+		enterpriseID uint32
+		value        interface{}
+	}{
+		{"sourceIPv4Address", registry.IANAEnterpriseID, net.ParseIP("10.0.0.1").To4()},
+		{"destinationIPv4Address", registry.IANAEnterpriseID, net.ParseIP("10.0.1.2").To4()},
+		{"sourceTransportPort", registry.IANAEnterpriseID, uint16(43210)},
+		{"destinationTransportPort", registry.IANAEnterpriseID, uint16(443)},
+		{"protocolIdentifier", registry.IANAEnterpriseID, uint8(6)},
+		{"packetTotalCount", registry.IANAEnterpriseID, uint64(100)},
+		{"sourcePodNamespace", registry.AntreaEnterpriseID, "kube-system"},
+	}
+	for _, v := range values {
+		element, err := registry.GetInfoElement(v.name, v.enterpriseID)
+		assert.NoError(t, err)
+		// Encode the value and decode it, so that it has the type of decoded values.
+		buff := new(bytes.Buffer)
+		_, err = entities.EncodeToIEDataType(element.DataType, v.value, buff)
+		assert.NoError(t, err)
+		if element.Len == entities.VariableLength {
+			buff.Next(1)
+		}
+		_, err = record.AddInfoElement(entities.NewInfoElementWithValue(element, buff), true)
+		assert.NoError(t, err)
+	}
+	return record
+}
+
+func TestFilter_Match(t *testing.T) {
+	record := createRecord(t)
+	testCases := []struct {
+		name   string
+		filter RecordFilter
+		match  bool
+	}{
+		{"empty", RecordFilter{}, true},
+		{"source CIDR", RecordFilter{SourceCIDRs: []string{"192.168.0.0/16", "10.0.0.0/24"}}, true},
+		{"destination CIDR", RecordFilter{DestinationCIDRs: []string{"10.0.0.0/24"}}, false},
+		{"IPv6 CIDR", RecordFilter{SourceCIDRs: []string{"fd00::/8"}}, false},
+		{"ports", RecordFilter{SourcePorts: []uint16{43210}, DestinationPorts: []uint16{80, 443}}, true},
+		{"destination port", RecordFilter{DestinationPorts: []uint16{80}}, false},
+		{"protocol", RecordFilter{Protocols: []uint8{17}}, false},
+		{"numeric condition", RecordFilter{Conditions: []Condition{{"packetTotalCount", OpGreaterOrEqual, 100}}}, true},
+		{"negative value", RecordFilter{Conditions: []Condition{{"packetTotalCount", OpGreater, -1}}}, true},
+		{"float value", RecordFilter{Conditions: []Condition{{"packetTotalCount", OpLess, 99.5}}}, false},
+		{"string condition", RecordFilter{Conditions: []Condition{{"sourcePodNamespace", OpNotEqual, "default"}}}, true},
+		{"IP condition", RecordFilter{Conditions: []Condition{{"sourceIPv4Address", OpEqual, "10.0.0.1"}}}, true},
+		{"type mismatch", RecordFilter{Conditions: []Condition{{"packetTotalCount", OpNotEqual, "100"}}}, false},
+		{"missing element", RecordFilter{Conditions: []Condition{{"octetTotalCount", OpGreater, 0}}}, false},
+		{"all selectors", RecordFilter{
+			SourceCIDRs:      []string{"10.0.0.0/24"},
+			DestinationCIDRs: []string{"10.0.1.0/24"},
+			Protocols:        []uint8{6},
+			Conditions: []Condition{
+				{"packetTotalCount", OpLess, uint64(1000)},
+				{"sourcePodNamespace", OpEqual, "kube-system"},
+			},
+		}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := NewFilter(tc.filter)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.match, f.Match(record))
+		})
+	}
+}
+
+func TestNewFilter_Nodes(t *testing.T) {
+	f, err := NewFilter(RecordFilter{})
+	assert.NoError(t, err)
+	assert.Nil(t, f.root)
+
+	// Every field is compiled into a node, and the nodes are combined with &&.
+	f, err = NewFilter(RecordFilter{
+		SourceCIDRs:      []string{"10.0.0.0/24"},
+		DestinationPorts: []uint16{80, 443},
+		Protocols:        []uint8{6},
+		Conditions:       []Condition{{"packetTotalCount", OpLess, 1000}},
+		Expression:       `has(packetTotalCount)`,
+	})
+	assert.NoError(t, err)
+	_, ipNet, _ := net.ParseCIDR("10.0.0.0/24")
+	ipNets := []*net.IPNet{ipNet}
+	expected := &andNode{
+		&andNode{
+			&andNode{
+				&andNode{
+					&orNode{
+						&inNode{name: "sourceIPv4Address", ipNets: ipNets},
+						&andNode{&notNode{&hasNode{"sourceIPv4Address"}}, &inNode{name: "sourceIPv6Address", ipNets: ipNets}},
+					},
+					&inNode{name: "destinationTransportPort", values: []interface{}{uint16(80), uint16(443)}},
+				},
+				&inNode{name: "protocolIdentifier", values: []interface{}{uint8(6)}},
+			},
+			&conditionNode{Condition{"packetTotalCount", OpLess, 1000}},
+		},
+		&hasNode{"packetTotalCount"},
+	}
+	assert.Equal(t, expected, f.root)
+}
+
+func TestFilter_MatchIPv6(t *testing.T) {
+	record := entities.NewDataRecord(256)
+	element, _ := registry.GetInfoElement("sourceIPv6Address", registry.IANAEnterpriseID)
+	_, err := record.AddInfoElement(entities.NewInfoElementWithValue(element, bytes.NewBuffer(net.ParseIP("fd00::1"))), true)
+	assert.NoError(t, err)
+	// IPv6 addresses are matched if the record has no IPv4 address.
+	f, err := NewFilter(RecordFilter{SourceCIDRs: []string{"fd00::/8"}})
+	assert.NoError(t, err)
+	assert.True(t, f.Match(record))
+	element, _ = registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	_, err = record.AddInfoElement(entities.NewInfoElementWithValue(element, bytes.NewBuffer(net.ParseIP("10.0.0.1").To4())), true)
+	assert.NoError(t, err)
+	assert.False(t, f.Match(record))
+}
+
+func TestNewFilter_Invalid(t *testing.T) {
+	_, err := NewFilter(RecordFilter{SourceCIDRs: []string{"10.0.0.1"}})
+	assert.Error(t, err)
+	_, err = NewFilter(RecordFilter{Conditions: []Condition{{"packetTotalCount", Operator(10), 1}}})
+	assert.Error(t, err)
+	_, err = NewFilter(RecordFilter{Conditions: []Condition{{"packetTotalCount", OpEqual, []int{1}}}})
+	assert.Error(t, err)
+}
@@ -19,6 +19,7 @@ import (
 	"container/heap"
 	"encoding/binary"
 	"fmt"
+	"hash/fnv"
 	"net"
 	"strings"
 	"sync"
@@ -36,9 +37,24 @@ var (
 	MinExpiryTime = 100 * time.Millisecond
 )
 
+// The exporter chain elements list the exporting processes which a record went
+// through, from its original exporter, as parallel basicLists of
+// originalExporterIPv6Address and originalObservationDomainId (RFC6183). IPv4
+// addresses are listed as IPv4-mapped IPv6 addresses.
+const (
+	exporterChainAddressesElement            = "exporterChainAddresses"
+	exporterChainObservationDomainIdsElement = "exporterChainObservationDomainIds"
+)
+
+// exporterHop is an exporting process in the exporter chain of a record.
+type exporterHop struct {
+	address     net.IP
+	obsDomainID uint32
+}
+
 type AggregationProcess struct {
 	// flowKeyRecordMap maps each connection (5-tuple) with its records
-	flowKeyRecordMap map[FlowKey]AggregationFlowRecord
+	flowKeyRecordMap map[aggregationKey]AggregationFlowRecord
 	// expirePriorityQueue helps to maintain a priority queue for the records given
 	// active expiry and inactive expiry timeouts.
 	expirePriorityQueue TimeToExpirePriorityQueue
@@ -70,6 +86,75 @@ type AggregationProcess struct {
 	inactiveExpiryTimeout time.Duration
 	// stopChan is the channel to receive stop message
 	stopChan chan bool
+	// correlationTimeout is the maximum time to wait for the record from the
+	// peer node of an inter-node flow. Once elapsed, the flow record is exported
+	// uncorrelated. If it is zero, records are instead deleted after waiting for
+	// MaxRetries active expiry intervals.
+	correlationTimeout time.Duration
+	// uncorrelatedExports maps the uncorrelatedReason to the number of flow
+	// records exported uncorrelated for this reason.
+	uncorrelatedExports map[uint8]uint64
+	// directionNormalizer normalizes the direction of records before they are
+	// aggregated, if it is not nil.
+	directionNormalizer *DirectionNormalizer
+	// sampler selects the expired records passed to the callback when the rate
+	// of expired records exceeds the sampling threshold. It is nil if
+	// sampling is disabled.
+	sampler *adaptiveSampler
+	// instanceID is the aggregatorInstanceId of the exported records. If it
+	// is empty, records are not watermarked.
+	instanceID string
+	// exportedRecordSequence is the exportedRecordSequence of the last
+	// exported record.
+	exportedRecordSequence uint64
+	// replicator replicates the flow state to a standby aggregator, if it is
+	// not nil.
+	replicator *Replicator
+	// flowKeyElements are the elements added to the 5-tuple of the flow key.
+	flowKeyElements []string
+	// checkQueueInvariants enables the validation of expirePriorityQueue
+	// after every mutation.
+	checkQueueInvariants bool
+	// queueInvariantViolations is the number of mutations after which
+	// expirePriorityQueue was found inconsistent.
+	queueInvariantViolations uint64
+	// cardinalityGuard enforces the cardinality limits. It is nil if there
+	// are none.
+	cardinalityGuard *cardinalityGuard
+	// labelElements maps the label elements to the way their values are
+	// merged.
+	labelElements map[string]LabelMergePolicy
+	// flowAffinity is true if the records of a flow are always aggregated by
+	// the same worker.
+	flowAffinity bool
+	// flowRecordChans are the channels of the records to aggregate by each
+	// worker, if flowAffinity is true.
+	flowRecordChans []chan flowRecordUpdate
+	// warmUpPeriod is the duration of the warm-up window.
+	warmUpPeriod time.Duration
+	// warmUpEndTime is the end of the current warm-up window.
+	warmUpEndTime time.Time
+	// warmUpSuppressedExports is the number of exports postponed to the end
+	// of a warm-up window.
+	warmUpSuppressedExports uint64
+	// latencyProbes measures the latency probes received, if not nil.
+	latencyProbes *entities.LatencyProbeTracker
+	// templateVersions maps the records of the template versions to their
+	// common schema, if not nil.
+	templateVersions *templateVersionMapper
+	// correlateEmptyValues maps correlate fields to when they are considered
+	// empty, if not when they have zero values.
+	correlateEmptyValues map[string]EmptyValue
+	// maxExpiredRecordsPerCall is the maximum number of expired records passed
+	// to the callback by a call of ForAllExpiredFlowRecordsDo. 0 means no
+	// limit.
+	maxExpiredRecordsPerCall int
+	// expiredRecordsSpread is the duration over which the expiry of the
+	// records above maxExpiredRecordsPerCall is spread.
+	expiredRecordsSpread time.Duration
+	// deferredExpiredRecords is the number of times the expiry of a record
+	// was postponed because of maxExpiredRecordsPerCall.
+	deferredExpiredRecords uint64
 }
 
 type AggregationInput struct {
@@ -79,6 +164,91 @@ type AggregationInput struct {
 	AggregateElements     *AggregationElements
 	ActiveExpiryTimeout   time.Duration
 	InactiveExpiryTimeout time.Duration
+	CorrelationTimeout    time.Duration
+	// DirectionNormalizer is optional. If given, the direction of every data
+	// record is normalized before computing its flow key, so that both
+	// orderings of a conversation are aggregated together.
+	DirectionNormalizer *DirectionNormalizer
+	// SamplingThreshold is the maximum number of expired records per second
+	// passed to the callback of ForAllExpiredFlowRecordsDo. Above it, expired
+	// records are sampled 1:N, and the rate is recorded in every record with
+	// samplingFlowInterval and samplingFlowSpacing. Stats of the records which
+	// are not selected are reset. If 0, all records are passed.
+	SamplingThreshold uint64
+	// InstanceID identifies the aggregator, e.g. among redundant aggregators
+	// receiving the same flows. If given, every record passed to the callback
+	// of ForAllExpiredFlowRecordsDo is stamped with aggregatorInstanceId and
+	// with an exportedRecordSequence incremented for every record, so that
+	// downstream consumers can deduplicate records. As the sequence starts
+	// from 1 with every aggregation process, the ID should be unique per run
+	// if consumers rely on it across restarts.
+	InstanceID string
+	// Replicator is optional. If given, the aggregation process is a member of
+	// an active/standby pair; see Replicator. Its Start method must be called
+	// along with the Start method of the aggregation process.
+	Replicator *Replicator
+	// FlowKeyElements are elements added to the 5-tuple of the flow key, e.g.
+	// ipClassOfService and flowLabelIPv6, so that records with different
+	// values for them are aggregated separately. A record without one of the
+	// elements is aggregated as if it had an empty value.
+	FlowKeyElements []string
+	// CheckQueueInvariants enables a debug mode in which the priority queue
+	// of the flows to expire is validated against the map of flow records
+	// after every mutation, so that a corruption, which otherwise shows as
+	// flows that never expire, is logged as soon as it happens. Validation
+	// is linear in the number of flows, so this should not be enabled in
+	// production.
+	CheckQueueInvariants bool
+	// CardinalityLimits are optional. If given, they bound the number of
+	// flows per source Pod namespace and per source node; see
+	// CardinalityLimits.
+	CardinalityLimits *CardinalityLimits
+	// LabelElements are optional. They map string elements carrying labels,
+	// e.g. sourcePodLabels and destinationPodLabels, to the way their values
+	// are merged when the records of a flow are aggregated or correlated.
+	// Without it, the value of a string element is the one of the first
+	// record of the flow, or the one of the peer node record if the element
+	// is in CorrelateFields. Label elements of catch-all records of
+	// cardinality limits are not merged.
+	LabelElements map[string]LabelMergePolicy
+	// FlowAffinity makes all the records of a flow be aggregated by the same
+	// worker, chosen by hashing the flow key, so that they are aggregated in
+	// the order of the messages received from MessageChan. Without it, the
+	// messages are processed concurrently by all workers, and two records of
+	// a flow in different messages can be aggregated in any order. Messages
+	// are then read from MessageChan by a single goroutine, which computes the
+	// flow keys and dispatches the records to the workers.
+	FlowAffinity bool
+	// WarmUpPeriod is the duration of the warm-up window which starts with
+	// the aggregation process, and when the aggregator becomes active if
+	// Replicator is given. During the window, flows waiting for the record
+	// from the peer node are not exported uncorrelated nor deleted, as the
+	// peer record may still be in flight from exporters reconnecting to the
+	// aggregator; their inactive expiry and correlation timeout are postponed
+	// to the end of the window. See GetWarmUpStatus.
+	WarmUpPeriod time.Duration
+	// MeasureLatencyProbes enables measuring the latency and the loss of the
+	// latency probes sent by exporters, from the time they were sent to the
+	// time they are received by the aggregation process. See
+	// GetLatencyProbeStats. Probes are never aggregated.
+	MeasureLatencyProbes bool
+	// TemplateVersions are optional. If given, the records of several
+	// versions of exporters are mapped to a common schema before being
+	// aggregated; see TemplateVersions.
+	TemplateVersions *TemplateVersions
+	// CorrelateEmptyValues are optional. They map correlate fields to when
+	// they are considered empty; see EmptyValueKind. Other correlate fields
+	// are empty if they have zero values.
+	CorrelateEmptyValues map[string]EmptyValue
+	// MaxExpiredRecordsPerCall is the maximum number of expired records passed
+	// to the callback by a call of ForAllExpiredFlowRecordsDo, so that sinks
+	// receive a steady stream of records when many flows expire at once, e.g.
+	// after a pause of the collector, instead of a burst. The expiry of the
+	// other expired records is spread over ExpiredRecordsSpread, or postponed
+	// to the next call if it is 0. See GetDeferredExpiredRecords. If 0, all
+	// the expired records are passed.
+	MaxExpiredRecordsPerCall int
+	ExpiredRecordsSpread     time.Duration
 }
 
 // InitAggregationProcess takes in message channel (e.g. from collector) as input
@@ -95,8 +265,29 @@ func InitAggregationProcess(input AggregationInput) (*AggregationProcess, error)
 			return nil, fmt.Errorf("stats elements, source stats elements and destination stats elemenst length should be equal")
 		}
 	}
-	return &AggregationProcess{
-		make(map[FlowKey]AggregationFlowRecord),
+	var sampler *adaptiveSampler
+	if input.SamplingThreshold > 0 {
+		sampler = newAdaptiveSampler(input.SamplingThreshold)
+	}
+	var guard *cardinalityGuard
+	if input.CardinalityLimits != nil {
+		guard = newCardinalityGuard(*input.CardinalityLimits)
+	}
+	if input.MaxExpiredRecordsPerCall < 0 {
+		return nil, fmt.Errorf("max expired records per call cannot be < 0")
+	}
+	if err := validateEmptyValues(input.CorrelateEmptyValues, input.CorrelateFields); err != nil {
+		return nil, err
+	}
+	var versionMapper *templateVersionMapper
+	if input.TemplateVersions != nil {
+		var err error
+		if versionMapper, err = newTemplateVersionMapper(*input.TemplateVersions); err != nil {
+			return nil, err
+		}
+	}
+	aggregationProcess := &AggregationProcess{
+		make(map[aggregationKey]AggregationFlowRecord),
 		make(TimeToExpirePriorityQueue, 0),
 		sync.RWMutex{},
 		input.MessageChan,
@@ -107,15 +298,58 @@ func InitAggregationProcess(input AggregationInput) (*AggregationProcess, error)
 		input.ActiveExpiryTimeout,
 		input.InactiveExpiryTimeout,
 		make(chan bool),
-	}, nil
+		input.CorrelationTimeout,
+		make(map[uint8]uint64),
+		input.DirectionNormalizer,
+		sampler,
+		input.InstanceID,
+		0,
+		input.Replicator,
+		input.FlowKeyElements,
+		input.CheckQueueInvariants,
+		0,
+		guard,
+		input.LabelElements,
+		input.FlowAffinity,
+		nil,
+		input.WarmUpPeriod,
+		time.Now().Add(input.WarmUpPeriod),
+		0,
+		nil,
+		versionMapper,
+		input.CorrelateEmptyValues,
+		input.MaxExpiredRecordsPerCall,
+		input.ExpiredRecordsSpread,
+		0,
+	}
+	if input.MeasureLatencyProbes {
+		aggregationProcess.latencyProbes = entities.NewLatencyProbeTracker()
+	}
+	if input.Replicator != nil {
+		input.Replicator.aggregationProcess = aggregationProcess
+	}
+	return aggregationProcess, nil
 }
 
 func (a *AggregationProcess) Start() {
 	a.mutex.Lock()
-	for i := 0; i < a.workerNum; i++ {
-		w := createWorker(i, a.messageChan, a.AggregateMsgByFlowKey)
+	if a.flowAffinity {
+		// A single worker dispatches the records of the messages to the
+		// workers aggregating them.
+		for i := 0; i < a.workerNum; i++ {
+			recordChan := make(chan flowRecordUpdate, cap(a.messageChan))
+			a.flowRecordChans = append(a.flowRecordChans, recordChan)
+			go a.aggregateFlowRecords(recordChan)
+		}
+		w := createWorker(0, a.messageChan, a.dispatchMsgByFlowKey)
 		w.start()
 		a.workerList = append(a.workerList, w)
+	} else {
+		for i := 0; i < a.workerNum; i++ {
+			w := createWorker(i, a.messageChan, a.AggregateMsgByFlowKey)
+			w.start()
+			a.workerList = append(a.workerList, w)
+		}
 	}
 	a.mutex.Unlock()
 	<-a.stopChan
@@ -126,12 +360,56 @@ func (a *AggregationProcess) Stop() {
 	for _, worker := range a.workerList {
 		worker.stop()
 	}
+	// The dispatching worker is stopped, so no more records are sent.
+	for _, recordChan := range a.flowRecordChans {
+		close(recordChan)
+	}
+	a.flowRecordChans = nil
 	a.mutex.Unlock()
 	a.stopChan <- true
 }
 
 // AggregateMsgByFlowKey gets flow key from records in message and stores in cache
 func (a *AggregationProcess) AggregateMsgByFlowKey(message *entities.Message) error {
+	return a.forAllFlowRecordsDo(message, a.addOrUpdateRecordInMap)
+}
+
+// dispatchMsgByFlowKey gets flow key from records in message and sends them to
+// the worker of the flow, which stores them in cache.
+func (a *AggregationProcess) dispatchMsgByFlowKey(message *entities.Message) error {
+	return a.forAllFlowRecordsDo(message, func(flowKey *FlowKey, record entities.Record) error {
+		a.flowRecordChans[getFlowWorkerIndex(flowKey, len(a.flowRecordChans))] <- flowRecordUpdate{flowKey, record}
+		return nil
+	})
+}
+
+// aggregateFlowRecords stores the records received from recordChan in cache,
+// until recordChan is closed.
+func (a *AggregationProcess) aggregateFlowRecords(recordChan <-chan flowRecordUpdate) {
+	for update := range recordChan {
+		if err := a.addOrUpdateRecordInMap(update.flowKey, update.record); err != nil {
+			klog.Error(err)
+		}
+	}
+}
+
+// getFlowWorkerIndex returns the index of the worker aggregating the records of
+// the flow, among workerNum workers.
+func getFlowWorkerIndex(flowKey *FlowKey, workerNum int) int {
+	h := fnv.New32a()
+	h.Write([]byte(flowKey.SourceAddress))
+	h.Write([]byte(flowKey.DestinationAddress))
+	h.Write([]byte{flowKey.Protocol, byte(flowKey.SourcePort >> 8), byte(flowKey.SourcePort), byte(flowKey.DestinationPort >> 8), byte(flowKey.DestinationPort)})
+	return int(h.Sum32() % uint32(workerNum))
+}
+
+// forAllFlowRecordsDo validates the data records of the message, normalizes
+// their direction, and calls f with the flow key of each valid record. Latency
+// probes are measured and skipped.
+func (a *AggregationProcess) forAllFlowRecordsDo(message *entities.Message, f func(flowKey *FlowKey, record entities.Record) error) error {
+	if a.latencyProbes != nil {
+		a.latencyProbes.Observe(message, time.Now())
+	}
 	if err := addOriginalExporterInfo(message); err != nil {
 		return err
 	}
@@ -142,17 +420,31 @@ func (a *AggregationProcess) AggregateMsgByFlowKey(message *entities.Message) er
 	records := set.GetRecords()
 	invalidRecs := 0
 	for _, record := range records {
+		if entities.IsLatencyProbe(record) {
+			continue
+		}
+		if a.templateVersions != nil {
+			var err error
+			if record, err = a.templateVersions.mapRecord(record); err != nil {
+				return err
+			}
+		}
 		// Validate the data record. If invalid, we log the error and move to the next
 		// record.
 		if !validateDataRecord(record) {
 			klog.Errorf("Invalid data record because decoded values of elements are not valid.")
 			invalidRecs = invalidRecs + 1
 		} else {
+			if a.directionNormalizer != nil {
+				if _, err := a.directionNormalizer.NormalizeRecord(record); err != nil {
+					return err
+				}
+			}
 			flowKey, err := getFlowKeyFromRecord(record)
 			if err != nil {
 				return err
 			}
-			if err = a.addOrUpdateRecordInMap(flowKey, record); err != nil {
+			if err = f(flowKey, record); err != nil {
 				return err
 			}
 		}
@@ -168,7 +460,7 @@ func (a *AggregationProcess) ForAllRecordsDo(callback FlowKeyRecordMapCallBack)
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
 	for k, v := range a.flowKeyRecordMap {
-		err := callback(k, v)
+		err := callback(k.FlowKey, v)
 		if err != nil {
 			klog.Errorf("Callback execution failed for flow with key: %v, records: %v, error: %v", k, v, err)
 			return err
@@ -177,18 +469,25 @@ func (a *AggregationProcess) ForAllRecordsDo(callback FlowKeyRecordMapCallBack)
 	return nil
 }
 
-func (a *AggregationProcess) deleteFlowKeyFromMap(flowKey FlowKey) error {
+func (a *AggregationProcess) deleteFlowKeyFromMap(flowKey aggregationKey) error {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
+	defer a.verifyExpirePriorityQueue("deleting a flow")
 	return a.deleteFlowKeyFromMapWithoutLock(flowKey)
 }
 
-func (a *AggregationProcess) deleteFlowKeyFromMapWithoutLock(flowKey FlowKey) error {
-	_, exists := a.flowKeyRecordMap[flowKey]
+func (a *AggregationProcess) deleteFlowKeyFromMapWithoutLock(flowKey aggregationKey) error {
+	flowRecord, exists := a.flowKeyRecordMap[flowKey]
 	if !exists {
 		return fmt.Errorf("flow key %v is not present in the map", flowKey)
 	}
 	delete(a.flowKeyRecordMap, flowKey)
+	if a.cardinalityGuard != nil {
+		a.cardinalityGuard.removeFlow(flowRecord.cardinalityKeys)
+	}
+	if a.replicator != nil {
+		a.replicator.markFlowDeleted(flowKey)
+	}
 	return nil
 }
 
@@ -216,26 +515,58 @@ func (a *AggregationProcess) GetExpiryFromExpirePriorityQueue() time.Duration {
 func (a *AggregationProcess) ForAllExpiredFlowRecordsDo(callback FlowKeyRecordMapCallBack) error {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
+	defer a.verifyExpirePriorityQueue("expiring flows")
 
 	if a.expirePriorityQueue.Len() == 0 {
 		return nil
 	}
+	if a.replicator != nil && !a.replicator.IsActive() {
+		// The flow state is maintained by the active aggregator.
+		return nil
+	}
 	currTime := time.Now()
+	expiredRecords := 0
 	for a.expirePriorityQueue.Len() > 0 {
-		topItem := a.expirePriorityQueue.Peek()
-		if topItem.activeExpireTime.After(currTime) && topItem.inactiveExpireTime.After(currTime) {
+		if a.expirePriorityQueue.minExpireTime(0).After(currTime) {
 			// We do not have to check other items anymore.
 			break
 		}
+		if a.maxExpiredRecordsPerCall > 0 && expiredRecords >= a.maxExpiredRecordsPerCall {
+			a.deferExpiredRecords(currTime)
+			break
+		}
 		// Pop the record item from the priority queue
 		pqItem := heap.Pop(&a.expirePriorityQueue).(*ItemToExpire)
-		if !pqItem.flowRecord.ReadyToSend {
+		if a.replicator != nil {
+			a.replicator.markFlowUpdated(pqItem.key())
+		}
+		if !pqItem.flowRecord.ReadyToSend && a.correlationTimeout > 0 {
+			reason := getUncorrelatedReason(pqItem, currTime)
+			if reason != registry.UncorrelatedReasonNone && a.isWarmingUp(currTime) {
+				a.postponeExpiryForWarmUp(pqItem, currTime)
+				heap.Push(&a.expirePriorityQueue, pqItem)
+				continue
+			}
+			if reason == registry.UncorrelatedReasonNone {
+				// Only the active expiry timeout elapsed, keep waiting for the
+				// record from the peer node.
+				pqItem.activeExpireTime = currTime.Add(a.activeExpiryTimeout)
+				heap.Push(&a.expirePriorityQueue, pqItem)
+				continue
+			}
+			if err := a.markRecordUncorrelated(pqItem, reason); err != nil {
+				return err
+			}
+		} else if !pqItem.flowRecord.ReadyToSend {
 			// Reset the timeouts and add the record to priority queue.
-			// Delete the record after max retries.
-			pqItem.flowRecord.waitForReadyToSendRetries = pqItem.flowRecord.waitForReadyToSendRetries + 1
+			// Delete the record after max retries, which are not counted
+			// during the warm-up window.
+			if !a.isWarmingUp(currTime) {
+				pqItem.flowRecord.waitForReadyToSendRetries = pqItem.flowRecord.waitForReadyToSendRetries + 1
+			}
 			if pqItem.flowRecord.waitForReadyToSendRetries > MaxRetries {
 				klog.V(2).Infof("Deleting the record after waiting for ready to send with key: %v record: %v", pqItem.flowKey, pqItem.flowRecord)
-				if err := a.deleteFlowKeyFromMapWithoutLock(*pqItem.flowKey); err != nil {
+				if err := a.deleteFlowKeyFromMapWithoutLock(pqItem.key()); err != nil {
 					return fmt.Errorf("error while deleting flow record after max retries: %v", err)
 				}
 			} else {
@@ -245,13 +576,38 @@ func (a *AggregationProcess) ForAllExpiredFlowRecordsDo(callback FlowKeyRecordMa
 			}
 			continue
 		}
-		err := callback(*pqItem.flowKey, *pqItem.flowRecord)
-		if err != nil {
-			return fmt.Errorf("callback execution failed for popped flow record with key: %v, record: %v, error: %v", pqItem.flowKey, pqItem.flowRecord, err)
+		isSampled := true
+		if a.sampler != nil {
+			var rate uint64
+			isSampled, rate = a.sampler.sample(currTime)
+			if isSampled {
+				if err := setSamplingFields(pqItem.flowRecord.Record, rate); err != nil {
+					return err
+				}
+			} else if a.aggregateElements != nil {
+				// The stats are dropped as if the record was exported, so that
+				// the sampled records remain statistically usable.
+				if err := a.ResetStatElementsInRecord(pqItem.flowRecord.Record); err != nil {
+					return err
+				}
+			}
+		}
+		a.setThroughputs(pqItem, currTime)
+		if isSampled {
+			if a.instanceID != "" {
+				if err := a.stampExportedRecord(pqItem.flowRecord.Record); err != nil {
+					return err
+				}
+			}
+			expiredRecords++
+			err := callback(*pqItem.flowKey, *pqItem.flowRecord)
+			if err != nil {
+				return fmt.Errorf("callback execution failed for popped flow record with key: %v, record: %v, error: %v", pqItem.flowKey, pqItem.flowRecord, err)
+			}
 		}
 		// Delete the flow record if it is expired because of inactive expiry timeout.
 		if pqItem.inactiveExpireTime.Before(currTime) {
-			if err = a.deleteFlowKeyFromMapWithoutLock(*pqItem.flowKey); err != nil {
+			if err := a.deleteFlowKeyFromMapWithoutLock(pqItem.key()); err != nil {
 				return fmt.Errorf("error while deleting flow record after inactive expiry: %v", err)
 			}
 			continue
@@ -272,44 +628,99 @@ func (a *AggregationProcess) ForAllExpiredFlowRecordsDo(callback FlowKeyRecordMa
 func (a *AggregationProcess) addOrUpdateRecordInMap(flowKey *FlowKey, record entities.Record) error {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
+	defer a.verifyExpirePriorityQueue("adding a record")
 
 	correlationRequired := isCorrelationRequired(record)
 
 	currTime := time.Now()
-	aggregationRecord, exist := a.flowKeyRecordMap[*flowKey]
+	key := &aggregationKey{FlowKey: *flowKey, ExtraKey: getExtraFlowKey(record, a.flowKeyElements)}
+	aggregationRecord, exist := a.flowKeyRecordMap[*key]
+	// overflow is true if the record is aggregated into the catch-all record
+	// of a cardinality limit.
+	overflow := false
+	var keys cardinalityKeys
+	if !exist && a.cardinalityGuard != nil {
+		var overflowKey *aggregationKey
+		if overflowKey, keys = a.cardinalityGuard.admitFlow(record); overflowKey != nil {
+			key = overflowKey
+			overflow = true
+			correlationRequired = false
+			aggregationRecord, exist = a.flowKeyRecordMap[*key]
+		}
+	}
 	if exist {
-		if correlationRequired {
-			// Do correlation of records if record belongs to inter-node flow and
-			// records from source and destination node are not received.
-			if !aggregationRecord.ReadyToSend && !areRecordsFromSameNode(record, aggregationRecord.Record) {
-				a.correlateRecords(record, aggregationRecord.Record)
-				aggregationRecord.ReadyToSend = true
-			}
-			// Aggregation of incoming flow record with existing by updating stats
-			// and flow timestamps.
-			if isRecordFromSrc(record) {
-				if err := a.aggregateRecords(record, aggregationRecord.Record, true, false); err != nil {
-					return err
+		if overflow {
+			if err := a.aggregateRecords(record, aggregationRecord.Record, !isRecordFromDst(record), !isRecordFromSrc(record)); err != nil {
+				return err
+			}
+		} else {
+			a.mergeLabelElements(record, aggregationRecord.Record)
+			if correlationRequired {
+				// Do correlation of records if record belongs to inter-node flow and
+				// records from source and destination node are not received.
+				if !aggregationRecord.ReadyToSend && !areRecordsFromSameNode(record, aggregationRecord.Record) {
+					a.correlateRecords(record, aggregationRecord.Record)
+					if err := setFlowAggregationStatus(aggregationRecord.Record, registry.FlowAggregationStatusCorrelated); err != nil {
+						return err
+					}
+					aggregationRecord.ReadyToSend = true
+					aggregationRecord.PriorityQueueItem.correlationExpireTime = time.Time{}
+				}
+				// Aggregation of incoming flow record with existing by updating stats
+				// and flow timestamps.
+				if isRecordFromSrc(record) {
+					if err := a.aggregateRecords(record, aggregationRecord.Record, true, false); err != nil {
+						return err
+					}
+				} else {
+					if err := a.aggregateRecords(record, aggregationRecord.Record, false, true); err != nil {
+						return err
+					}
 				}
 			} else {
-				if err := a.aggregateRecords(record, aggregationRecord.Record, false, true); err != nil {
+				// For flows that do not need correlation, just do aggregation of the
+				// flow record with existing record by updating the stats and flow timestamps.
+				if err := a.aggregateRecords(record, aggregationRecord.Record, true, true); err != nil {
 					return err
 				}
 			}
-		} else {
-			// For flows that do not need correlation, just do aggregation of the
-			// flow record with existing record by updating the stats and flow timestamps.
-			if err := a.aggregateRecords(record, aggregationRecord.Record, true, true); err != nil {
-				return err
-			}
 		}
 		// Reset the inactive expiry time in the queue item with updated aggregate
 		// record.
 		a.expirePriorityQueue.Update(aggregationRecord.PriorityQueueItem,
-			flowKey, &aggregationRecord, aggregationRecord.PriorityQueueItem.activeExpireTime, currTime.Add(a.inactiveExpiryTimeout))
+			&key.FlowKey, &aggregationRecord, aggregationRecord.PriorityQueueItem.activeExpireTime, currTime.Add(a.inactiveExpiryTimeout))
 	} else {
+		if err := addFlowAggregationStatusField(record, correlationRequired); err != nil {
+			return err
+		}
+		if overflow {
+			clearFlowKeyFields(record)
+			a.setOverflowTotalStats(record)
+			if err := setFlowAggregationStatus(record, registry.FlowAggregationStatusCardinalityOverflow); err != nil {
+				return err
+			}
+		}
+		if a.sampler != nil {
+			if err := addSamplingFields(record); err != nil {
+				return err
+			}
+		}
+		if a.instanceID != "" {
+			if err := addWatermarkFields(record, a.instanceID); err != nil {
+				return err
+			}
+		}
 		// Add all the new stat fields and initialize them.
-		if correlationRequired {
+		if correlationRequired && a.correlationTimeout > 0 {
+			if err := addUncorrelatedReasonField(record); err != nil {
+				return err
+			}
+		}
+		if overflow {
+			if err := a.addFieldsForStatsAggregation(record, !isRecordFromDst(record), !isRecordFromSrc(record)); err != nil {
+				return err
+			}
+		} else if correlationRequired {
 			if isRecordFromSrc(record) {
 				if err := a.addFieldsForStatsAggregation(record, true, false); err != nil {
 					return err
@@ -328,22 +739,175 @@ func (a *AggregationProcess) addOrUpdateRecordInMap(flowKey *FlowKey, record ent
 			Record:                    record,
 			ReadyToSend:               false,
 			waitForReadyToSendRetries: 0,
+			cardinalityKeys:           keys,
 		}
 		if !correlationRequired {
 			aggregationRecord.ReadyToSend = true
 		}
 		// Push the record to the priority queue.
 		pqItem := &ItemToExpire{
-			flowKey: flowKey,
+			flowKey:   &key.FlowKey,
+			extraKey:  key.ExtraKey,
+			addedTime: currTime,
 		}
 		aggregationRecord.PriorityQueueItem = pqItem
 
 		pqItem.flowRecord = &aggregationRecord
 		pqItem.activeExpireTime = currTime.Add(a.activeExpiryTimeout)
 		pqItem.inactiveExpireTime = currTime.Add(a.inactiveExpiryTimeout)
+		if correlationRequired && a.correlationTimeout > 0 {
+			pqItem.correlationExpireTime = currTime.Add(a.correlationTimeout)
+		}
 		heap.Push(&a.expirePriorityQueue, pqItem)
 	}
-	a.flowKeyRecordMap[*flowKey] = aggregationRecord
+	a.flowKeyRecordMap[*key] = aggregationRecord
+	if a.replicator != nil {
+		a.replicator.markFlowUpdated(*key)
+	}
+	return nil
+}
+
+// ExpirePriorityQueueStats describes the priority queue of the flows to
+// expire.
+type ExpirePriorityQueueStats struct {
+	// Depth is the number of flows in the queue.
+	Depth int
+	// OldestItemAge is the time since the oldest flow of the queue was added.
+	// It keeps growing for flows which are not expired anymore.
+	OldestItemAge time.Duration
+	// InvariantViolations is the number of mutations after which the queue
+	// was found inconsistent. It is only counted if
+	// AggregationInput.CheckQueueInvariants is set.
+	InvariantViolations uint64
+}
+
+// GetExpirePriorityQueueStats returns the stats of the priority queue of the
+// flows to expire. Finding the oldest flow is linear in the number of flows.
+func (a *AggregationProcess) GetExpirePriorityQueueStats() ExpirePriorityQueueStats {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	stats := ExpirePriorityQueueStats{
+		Depth:               a.expirePriorityQueue.Len(),
+		InvariantViolations: a.queueInvariantViolations,
+	}
+	currTime := time.Now()
+	for _, item := range a.expirePriorityQueue {
+		if item == nil || item.addedTime.IsZero() {
+			continue
+		}
+		if age := currTime.Sub(item.addedTime); age > stats.OldestItemAge {
+			stats.OldestItemAge = age
+		}
+	}
+	return stats
+}
+
+// verifyExpirePriorityQueue checks the invariants of expirePriorityQueue if
+// checkQueueInvariants is set, and logs the first violation found after the
+// operation. This should be called after acquiring the mutex.
+func (a *AggregationProcess) verifyExpirePriorityQueue(operation string) {
+	if !a.checkQueueInvariants {
+		return
+	}
+	if err := a.expirePriorityQueue.checkInvariants(a.flowKeyRecordMap); err != nil {
+		a.queueInvariantViolations++
+		klog.Errorf("Expire priority queue is inconsistent after %s: %v", operation, err)
+	}
+}
+
+// GetLatencyProbeStats returns the stats of the latency probes received from
+// every exporting process, if AggregationInput.MeasureLatencyProbes is set.
+func (a *AggregationProcess) GetLatencyProbeStats() map[entities.LatencyProbeSource]entities.LatencyProbeStats {
+	if a.latencyProbes == nil {
+		return nil
+	}
+	return a.latencyProbes.GetStats()
+}
+
+// GetUncorrelatedExports returns the number of inter-node flow records exported
+// without the record from the peer node, per uncorrelatedReason.
+func (a *AggregationProcess) GetUncorrelatedExports() map[uint8]uint64 {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	exports := make(map[uint8]uint64, len(a.uncorrelatedExports))
+	for reason, count := range a.uncorrelatedExports {
+		exports[reason] = count
+	}
+	return exports
+}
+
+// getUncorrelatedReason returns the reason to export the record of the popped
+// item uncorrelated, or UncorrelatedReasonNone if it should wait for the record
+// from the peer node. A flow that became inactive is not expected to receive
+// the peer record anymore.
+func getUncorrelatedReason(pqItem *ItemToExpire, currTime time.Time) uint8 {
+	if !pqItem.correlationExpireTime.After(currTime) {
+		return registry.UncorrelatedReasonCorrelationTimeout
+	}
+	if !pqItem.inactiveExpireTime.After(currTime) {
+		return registry.UncorrelatedReasonInactiveTimeout
+	}
+	return registry.UncorrelatedReasonNone
+}
+
+// markRecordUncorrelated sets the uncorrelatedReason field of the record and
+// makes it ready to send. The record will not be correlated anymore if the
+// record from the peer node arrives later. This should be called after
+// acquiring the mutex.
+func (a *AggregationProcess) markRecordUncorrelated(pqItem *ItemToExpire, reason uint8) error {
+	ieWithValue, exist := pqItem.flowRecord.Record.GetInfoElementWithValue("uncorrelatedReason")
+	if !exist {
+		return fmt.Errorf("uncorrelatedReason is not present in the record with key: %v", pqItem.flowKey)
+	}
+	ieWithValue.Value = reason
+	if reason == registry.UncorrelatedReasonCorrelationTimeout {
+		if err := setFlowAggregationStatus(pqItem.flowRecord.Record, registry.FlowAggregationStatusTimedOut); err != nil {
+			return err
+		}
+	}
+	klog.V(2).Infof("Exporting the record uncorrelated with key: %v reason: %d", pqItem.flowKey, reason)
+	pqItem.flowRecord.ReadyToSend = true
+	pqItem.correlationExpireTime = time.Time{}
+	a.flowKeyRecordMap[pqItem.key()] = *pqItem.flowRecord
+	a.uncorrelatedExports[reason] = a.uncorrelatedExports[reason] + 1
+	return nil
+}
+
+func addUncorrelatedReasonField(record entities.Record) error {
+	ie, err := registry.GetInfoElement("uncorrelatedReason", registry.AntreaEnterpriseID)
+	if err != nil {
+		return err
+	}
+	_, err = record.AddInfoElement(entities.NewInfoElementWithValue(ie, registry.UncorrelatedReasonNone), false)
+	return err
+}
+
+// addFlowAggregationStatusField adds the flowAggregationStatus field to a new
+// record in the map. Records that need correlation start as source-only or
+// destination-only until the record from the peer node is received.
+func addFlowAggregationStatusField(record entities.Record, correlationRequired bool) error {
+	ie, err := registry.GetInfoElement("flowAggregationStatus", registry.AntreaEnterpriseID)
+	if err != nil {
+		return err
+	}
+	status := registry.FlowAggregationStatusCorrelated
+	if correlationRequired {
+		if isRecordFromSrc(record) {
+			status = registry.FlowAggregationStatusSourceOnly
+		} else {
+			status = registry.FlowAggregationStatusDestinationOnly
+		}
+	}
+	_, err = record.AddInfoElement(entities.NewInfoElementWithValue(ie, status), false)
+	return err
+}
+
+func setFlowAggregationStatus(record entities.Record, status uint8) error {
+	ieWithValue, exist := record.GetInfoElementWithValue("flowAggregationStatus")
+	if !exist {
+		return fmt.Errorf("flowAggregationStatus is not present in the record")
+	}
+	ieWithValue.Value = status
 	return nil
 }
 
@@ -351,62 +915,33 @@ func (a *AggregationProcess) addOrUpdateRecordInMap(flowKey *FlowKey, record ent
 // fields. This is called for records whose flowType is InterNode(pkg/registry/registry.go).
 func (a *AggregationProcess) correlateRecords(incomingRecord, existingRecord entities.Record) {
 	for _, field := range a.correlateFields {
-		if ieWithValue, exist := incomingRecord.GetInfoElementWithValue(field); exist {
-			switch ieWithValue.Element.DataType {
-			case entities.String:
-				if ieWithValue.Value != "" {
-					existingIeWithValue, _ := existingRecord.GetInfoElementWithValue(field)
-					if existingIeWithValue.Value != "" {
-						klog.Warningf("%v field should not have been filled in the existing record; existing value: %v and current value: %v", field, existingIeWithValue.Value, ieWithValue.Value)
-					}
-					existingIeWithValue.Value = ieWithValue.Value
-				}
-			case entities.Unsigned8:
-				if ieWithValue.Value != uint8(0) {
-					existingIeWithValue, _ := existingRecord.GetInfoElementWithValue(field)
-					if existingIeWithValue.Value != uint8(0) {
-						klog.Warningf("%v field should not have been filled in the existing record; existing value: %v and current value: %v", field, existingIeWithValue.Value, ieWithValue.Value)
-					}
-					existingIeWithValue.Value = ieWithValue.Value
-				}
-			case entities.Unsigned16:
-				if ieWithValue.Value != uint16(0) {
-					existingIeWithValue, _ := existingRecord.GetInfoElementWithValue(field)
-					if existingIeWithValue.Value != uint16(0) {
-						klog.Warningf("%v field should not have been filled in the existing record; existing value: %v and current value: %v", field, existingIeWithValue.Value, ieWithValue.Value)
-					}
-					existingIeWithValue.Value = ieWithValue.Value
-				}
-			case entities.Signed32:
-				if ieWithValue.Value != int32(0) {
-					existingIeWithValue, _ := existingRecord.GetInfoElementWithValue(field)
-					if existingIeWithValue.Value != int32(0) {
-						klog.Warningf("%v field should not have been filled in the existing record; existing value: %v and current value: %v", field, existingIeWithValue.Value, ieWithValue.Value)
-					}
-					existingIeWithValue.Value = ieWithValue.Value
-				}
-			case entities.Ipv4Address:
-				ipInString := ieWithValue.Value.(net.IP).To4().String()
-				if ipInString != "0.0.0.0" {
-					existingIeWithValue, _ := existingRecord.GetInfoElementWithValue(field)
-					ipInString = existingIeWithValue.Value.(net.IP).To4().String()
-					if ipInString != "0.0.0.0" {
-						klog.Warningf("%v field should not have been filled in the existing record; existing value: %v and current value: %v", field, existingIeWithValue.Value, ieWithValue.Value)
-					}
-					existingIeWithValue.Value = ieWithValue.Value
-				}
-			case entities.Ipv6Address:
-				ipInString := ieWithValue.Value.(net.IP).To16().String()
-				if ipInString != net.ParseIP("::0").To16().String() {
-					existingIeWithValue, _ := existingRecord.GetInfoElementWithValue(field)
-					ipInString = existingIeWithValue.Value.(net.IP).To16().String()
-					if ipInString != net.ParseIP("::0").To16().String() {
-						klog.Warningf("%v field should not have been filled in the existing record; existing value: %v and current value: %v", field, existingIeWithValue.Value, ieWithValue.Value)
-					}
-					existingIeWithValue.Value = ieWithValue.Value
-				}
-			default:
-				klog.Errorf("Fields with dataType %v is not supported in correlation fields list.", ieWithValue.Element.DataType)
+		// Label elements are merged instead.
+		if _, isLabel := a.labelElements[field]; isLabel {
+			continue
+		}
+		isEmpty, err := a.isEmptyField(incomingRecord, field)
+		if err != nil {
+			klog.Errorf("Cannot correlate field %s: %v", field, err)
+			continue
+		}
+		if isEmpty {
+			continue
+		}
+		ieWithValue, _ := incomingRecord.GetInfoElementWithValue(field)
+		existingIeWithValue, exist := existingRecord.GetInfoElementWithValue(field)
+		if !exist {
+			continue
+		}
+		if existingIsEmpty, _ := a.isEmptyField(existingRecord, field); !existingIsEmpty {
+			klog.Warningf("%v field should not have been filled in the existing record; existing value: %v and current value: %v", field, existingIeWithValue.Value, ieWithValue.Value)
+		}
+		existingIeWithValue.Value = ieWithValue.Value
+	}
+	// The correlated record went through the exporters of both records.
+	if incomingChain, exist := getExporterChain(incomingRecord); exist && len(incomingChain) > 0 {
+		if existingChain, exist := getExporterChain(existingRecord); exist {
+			if err := setExporterChain(existingRecord, appendExporterHops(existingChain, incomingChain...)); err != nil {
+				klog.Errorf("Error when merging exporter chains of correlated records: %v", err)
 			}
 		}
 	}
@@ -457,6 +992,7 @@ func (a *AggregationProcess) aggregateRecords(incomingRecord, existingRecord ent
 	statsElementList := a.aggregateElements.StatsElements
 	antreaSourceStatsElements := a.aggregateElements.AggregatedSourceStatsElements
 	antreaDestinationStatsElements := a.aggregateElements.AggregatedDestinationStatsElements
+	overflow := isCardinalityOverflowRecord(existingRecord)
 	for i, element := range statsElementList {
 		isDelta := false
 		if strings.Contains(element, "Delta") {
@@ -464,6 +1000,16 @@ func (a *AggregationProcess) aggregateRecords(incomingRecord, existingRecord ent
 		}
 		if ieWithValue, exist := incomingRecord.GetInfoElementWithValue(element); exist {
 			existingIeWithValue, _ := existingRecord.GetInfoElementWithValue(element)
+			// The total stats of the different flows of a catch-all record
+			// cannot be combined, so the matching delta stats are added up
+			// instead.
+			addedValue := ieWithValue.Value
+			if !isDelta && overflow {
+				if deltaIeWithValue, exist := incomingRecord.GetInfoElementWithValue(strings.Replace(element, "Total", "Delta", 1)); exist {
+					addedValue = deltaIeWithValue.Value
+					isDelta = true
+				}
+			}
 			// Update the corresponding element in existing record.
 			if !isDelta {
 				if existingIeWithValue.Value.(uint64) < ieWithValue.Value.(uint64) {
@@ -476,7 +1022,7 @@ func (a *AggregationProcess) aggregateRecords(incomingRecord, existingRecord ent
 				// two times the stats approximately.
 				// For delta stats, it is better to use source and destination specific
 				// stats.
-				existingIeWithValue.Value = existingIeWithValue.Value.(uint64) + ieWithValue.Value.(uint64)
+				existingIeWithValue.Value = existingIeWithValue.Value.(uint64) + addedValue.(uint64)
 			}
 			// Update the corresponding source element in antreaStatsElement list.
 			if fillSrcStats {
@@ -484,7 +1030,7 @@ func (a *AggregationProcess) aggregateRecords(incomingRecord, existingRecord ent
 				if !isDelta {
 					existingIeWithValue.Value = ieWithValue.Value
 				} else {
-					existingIeWithValue.Value = existingIeWithValue.Value.(uint64) + ieWithValue.Value.(uint64)
+					existingIeWithValue.Value = existingIeWithValue.Value.(uint64) + addedValue.(uint64)
 				}
 			}
 			// Update the corresponding destination element in antreaStatsElement list.
@@ -493,7 +1039,7 @@ func (a *AggregationProcess) aggregateRecords(incomingRecord, existingRecord ent
 				if !isDelta {
 					existingIeWithValue.Value = ieWithValue.Value
 				} else {
-					existingIeWithValue.Value = existingIeWithValue.Value.(uint64) + ieWithValue.Value.(uint64)
+					existingIeWithValue.Value = existingIeWithValue.Value.(uint64) + addedValue.(uint64)
 				}
 			}
 		} else {
@@ -569,7 +1115,7 @@ func (a *AggregationProcess) addFieldsForStatsAggregation(record entities.Record
 			}
 		}
 	}
-	return nil
+	return a.addThroughputElements(record)
 }
 
 // isRecordFromSrc returns true if record belongs to inter-node flow and from source node.
@@ -691,7 +1237,31 @@ func getFlowKeyFromRecord(record entities.Record) (*FlowKey, error) {
 	return flowKey, nil
 }
 
-// addOriginalExporterInfo adds originalExporterIP and originalObservationDomainId to records in message set
+// getExtraFlowKey returns the values of the given elements in the record, as
+// "name=value" pairs separated by commas.
+func getExtraFlowKey(record entities.Record, elementNames []string) string {
+	if len(elementNames) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(elementNames))
+	for i, name := range elementNames {
+		var value interface{}
+		if element, exist := record.GetInfoElementWithValue(name); exist && element.Value != nil {
+			value = element.Value
+		} else {
+			value = ""
+		}
+		pairs[i] = fmt.Sprintf("%s=%v", name, value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// addOriginalExporterInfo adds originalExporterIP and originalObservationDomainId to records in message set.
+// Records which already have them, e.g. records re-exported by another
+// aggregation process, keep those of their original exporter. The exporting
+// process of the message is appended to the exporter chain elements of the
+// records, so that the hops of a record through multi-tier aggregation are
+// preserved along with its origin.
 func addOriginalExporterInfo(message *entities.Message) error {
 	isIPv4 := false
 	exporterIP := net.ParseIP(message.GetExportAddress())
@@ -699,53 +1269,247 @@ func addOriginalExporterInfo(message *entities.Message) error {
 		isIPv4 = true
 	}
 	set := message.GetSet()
+	if set.GetSetType() != entities.Template && set.GetSetType() != entities.Data {
+		return fmt.Errorf("set type %d is not supported", set.GetSetType())
+	}
+	isData := set.GetSetType() == entities.Data
+	hop := exporterHop{exporterIP.To16(), message.GetObsDomainID()}
 	records := set.GetRecords()
 	for _, record := range records {
 		var originalExporterIP, originalObservationDomainId *entities.InfoElementWithValue
 		var ie *entities.InfoElement
 		var err error
-		// Add originalExporterIP. Supports both IPv4 and IPv6.
-		if isIPv4 {
-			ie, err = registry.GetInfoElement("originalExporterIPv4Address", registry.IANAEnterpriseID)
-		} else {
-			ie, err = registry.GetInfoElement("originalExporterIPv6Address", registry.IANAEnterpriseID)
+		origin, hasOrigin := getOriginalExporter(record)
+		if !hasOrigin {
+			// Add originalExporterIP. Supports both IPv4 and IPv6.
+			if isIPv4 {
+				ie, err = registry.GetInfoElement("originalExporterIPv4Address", registry.IANAEnterpriseID)
+			} else {
+				ie, err = registry.GetInfoElement("originalExporterIPv6Address", registry.IANAEnterpriseID)
+			}
+			if err != nil {
+				return err
+			}
+			if isData {
+				originalExporterIP = entities.NewInfoElementWithValue(ie, net.ParseIP(message.GetExportAddress()))
+			} else {
+				originalExporterIP = entities.NewInfoElementWithValue(ie, nil)
+			}
+			_, err = record.AddInfoElement(originalExporterIP, false)
+			if err != nil {
+				return err
+			}
+
+			// Add originalObservationDomainId
+			ie, err = registry.GetInfoElement("originalObservationDomainId", registry.IANAEnterpriseID)
+			if err != nil {
+				return fmt.Errorf("IANA Registry is not loaded correctly with originalObservationDomainId")
+			}
+			if isData {
+				originalObservationDomainId = entities.NewInfoElementWithValue(ie, message.GetObsDomainID())
+			} else {
+				originalObservationDomainId = entities.NewInfoElementWithValue(ie, nil)
+			}
+			_, err = record.AddInfoElement(originalObservationDomainId, false)
+			if err != nil {
+				return err
+			}
 		}
-		if err != nil {
+
+		// Append the exporting process to the exporter chain. The chain of
+		// records re-exported by aggregation processes without it starts
+		// from their original exporter.
+		if chain, exist := getExporterChain(record); exist {
+			if isData {
+				if len(chain) == 0 && origin != nil {
+					chain = append(chain, *origin)
+				}
+				if err = setExporterChain(record, appendExporterHops(chain, hop)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		var chain []exporterHop
+		if isData {
+			chain = []exporterHop{hop}
+			if origin != nil {
+				chain = appendExporterHops([]exporterHop{*origin}, hop)
+			}
+		}
+		if err = addExporterChain(record, chain, isData); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		if set.GetSetType() == entities.Template {
-			originalExporterIP = entities.NewInfoElementWithValue(ie, nil)
-		} else if set.GetSetType() == entities.Data {
-			originalExporterIP = entities.NewInfoElementWithValue(ie, net.ParseIP(message.GetExportAddress()))
-		} else {
-			return fmt.Errorf("set type %d is not supported", set.GetSetType())
+// getOriginalExporter returns the original exporter of the record as an
+// exporter chain hop, if the record already has one. The hop is nil for
+// template records.
+func getOriginalExporter(record entities.Record) (*exporterHop, bool) {
+	var address interface{}
+	if ie, exist := record.GetInfoElementWithValue("originalExporterIPv4Address"); exist {
+		address = ie.Value
+	} else if ie, exist := record.GetInfoElementWithValue("originalExporterIPv6Address"); exist {
+		address = ie.Value
+	} else {
+		return nil, false
+	}
+	var obsDomainID interface{}
+	if ie, exist := record.GetInfoElementWithValue("originalObservationDomainId"); exist {
+		obsDomainID = ie.Value
+	}
+	// Template records have no values.
+	if ip, ok := address.(net.IP); ok {
+		id, _ := obsDomainID.(uint32)
+		return &exporterHop{ip.To16(), id}, true
+	}
+	return nil, true
+}
+
+// getExporterChain returns the hops of the exporter chain of the record, if
+// the record has the exporter chain elements.
+func getExporterChain(record entities.Record) ([]exporterHop, bool) {
+	addressesIE, exist := record.GetInfoElementWithValue(exporterChainAddressesElement)
+	if !exist {
+		return nil, false
+	}
+	obsDomainIDsIE, exist := record.GetInfoElementWithValue(exporterChainObservationDomainIdsElement)
+	if !exist {
+		return nil, false
+	}
+	addresses := getBasicListValues(addressesIE)
+	obsDomainIDs := getBasicListValues(obsDomainIDsIE)
+	hops := make([]exporterHop, 0, len(addresses))
+	for i, value := range addresses {
+		address, ok := value.(net.IP)
+		if !ok {
+			continue
 		}
-		_, err = record.AddInfoElement(originalExporterIP, false)
+		var obsDomainID uint32
+		if i < len(obsDomainIDs) {
+			obsDomainID, _ = obsDomainIDs[i].(uint32)
+		}
+		hops = append(hops, exporterHop{address.To16(), obsDomainID})
+	}
+	return hops, true
+}
+
+// getBasicListValues returns the values of a basicList element. Lists left
+// undecoded by the collecting process are decoded with the registry.
+func getBasicListValues(ie *entities.InfoElementWithValue) []interface{} {
+	value := ie.Value
+	if content, ok := value.([]byte); ok {
+		list, err := entities.DecodeListValue(entities.BasicList, content, registryListResolver{})
 		if err != nil {
-			return err
+			klog.V(4).Infof("Cannot decode value of element %s: %v", ie.Element.Name, err)
+			return nil
 		}
+		value = list
+	}
+	if list, ok := value.(*entities.BasicListValue); ok {
+		return list.Values
+	}
+	return nil
+}
 
-		// Add originalObservationDomainId
-		ie, err = registry.GetInfoElement("originalObservationDomainId", registry.IANAEnterpriseID)
+// registryListResolver resolves the elements of basicLists with the registry.
+type registryListResolver struct{}
+
+func (registryListResolver) GetInfoElementFromID(elementID uint16, enterpriseID uint32) (*entities.InfoElement, error) {
+	return registry.GetInfoElementFromID(elementID, enterpriseID)
+}
+
+func (registryListResolver) GetTemplate(templateID uint16) ([]*entities.InfoElement, error) {
+	return nil, fmt.Errorf("template %d of list records is unknown", templateID)
+}
+
+// newExporterChainLists returns the basicLists of the addresses and the
+// observation domain IDs of the hops.
+func newExporterChainLists(hops []exporterHop) (*entities.BasicListValue, *entities.BasicListValue, error) {
+	addressElement, err := registry.GetInfoElement("originalExporterIPv6Address", registry.IANAEnterpriseID)
+	if err != nil {
+		return nil, nil, err
+	}
+	obsDomainIDElement, err := registry.GetInfoElement("originalObservationDomainId", registry.IANAEnterpriseID)
+	if err != nil {
+		return nil, nil, err
+	}
+	addresses := &entities.BasicListValue{Semantic: entities.Ordered, Element: addressElement, Values: make([]interface{}, len(hops))}
+	obsDomainIDs := &entities.BasicListValue{Semantic: entities.Ordered, Element: obsDomainIDElement, Values: make([]interface{}, len(hops))}
+	for i, hop := range hops {
+		addresses.Values[i] = hop.address
+		obsDomainIDs.Values[i] = hop.obsDomainID
+	}
+	return addresses, obsDomainIDs, nil
+}
+
+// setExporterChain replaces the values of the exporter chain elements of the
+// record with the hops.
+func setExporterChain(record entities.Record, hops []exporterHop) error {
+	addresses, obsDomainIDs, err := newExporterChainLists(hops)
+	if err != nil {
+		return err
+	}
+	if ie, exist := record.GetInfoElementWithValue(exporterChainAddressesElement); exist {
+		ie.Value = addresses
+	}
+	if ie, exist := record.GetInfoElementWithValue(exporterChainObservationDomainIdsElement); exist {
+		ie.Value = obsDomainIDs
+	}
+	return nil
+}
+
+// addExporterChain adds the exporter chain elements with the hops to the
+// record.
+func addExporterChain(record entities.Record, hops []exporterHop, isData bool) error {
+	addresses, obsDomainIDs, err := newExporterChainLists(hops)
+	if err != nil {
+		return err
+	}
+	for _, chainElement := range []struct {
+		name  string
+		value *entities.BasicListValue
+	}{
+		{exporterChainAddressesElement, addresses},
+		{exporterChainObservationDomainIdsElement, obsDomainIDs},
+	} {
+		ie, err := registry.GetInfoElement(chainElement.name, registry.AntreaEnterpriseID)
 		if err != nil {
-			return fmt.Errorf("IANA Registry is not loaded correctly with originalObservationDomainId")
+			return fmt.Errorf("Antrea Registry is not loaded correctly with %s", chainElement.name)
 		}
-		if set.GetSetType() == entities.Template {
-			originalObservationDomainId = entities.NewInfoElementWithValue(ie, nil)
-		} else if set.GetSetType() == entities.Data {
-			originalObservationDomainId = entities.NewInfoElementWithValue(ie, message.GetObsDomainID())
-		} else {
-			return fmt.Errorf("set type %d is not supported", set.GetSetType())
+		var value interface{}
+		if isData {
+			value = chainElement.value
 		}
-		_, err = record.AddInfoElement(originalObservationDomainId, false)
-		if err != nil {
+		if _, err = record.AddInfoElement(entities.NewInfoElementWithValue(ie, value), false); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// appendExporterHops appends the hops to the exporter chain, skipping the hops
+// already in it.
+func appendExporterHops(chain []exporterHop, hops ...exporterHop) []exporterHop {
+	entries := make([]exporterHop, 0, len(chain)+len(hops))
+	entries = append(entries, chain...)
+	for _, hop := range hops {
+		found := false
+		for _, entry := range entries {
+			if entry.address.Equal(hop.address) && entry.obsDomainID == hop.obsDomainID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			entries = append(entries, hop)
+		}
+	}
+	return entries
+}
+
 func validateDataRecord(record entities.Record) bool {
 	for _, element := range record.GetOrderedElementList() {
 		if element.Value == nil {
@@ -18,12 +18,14 @@ import (
 	"bytes"
 	"container/heap"
 	"encoding/binary"
+	"fmt"
 	"net"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/wait"
 
 	"github.com/vmware/go-ipfix/pkg/entities"
 	"github.com/vmware/go-ipfix/pkg/registry"
@@ -55,18 +57,30 @@ var (
 		"packetDeltaCount",
 		"reversePacketTotalCount",
 		"reversePacketDeltaCount",
+		"octetTotalCount",
+		"octetDeltaCount",
+		"reverseOctetTotalCount",
+		"reverseOctetDeltaCount",
 	}
 	antreaSourceStatsElementList = []string{
 		"packetTotalCountFromSourceNode",
 		"packetDeltaCountFromSourceNode",
 		"reversePacketTotalCountFromSourceNode",
 		"reversePacketDeltaCountFromSourceNode",
+		"octetTotalCountFromSourceNode",
+		"octetDeltaCountFromSourceNode",
+		"reverseOctetTotalCountFromSourceNode",
+		"reverseOctetDeltaCountFromSourceNode",
 	}
 	antreaDestinationStatsElementList = []string{
 		"packetTotalCountFromDestinationNode",
 		"packetDeltaCountFromDestinationNode",
 		"reversePacketTotalCountFromDestinationNode",
 		"reversePacketDeltaCountFromDestinationNode",
+		"octetTotalCountFromDestinationNode",
+		"octetDeltaCountFromDestinationNode",
+		"reverseOctetTotalCountFromDestinationNode",
+		"reverseOctetDeltaCountFromDestinationNode",
 	}
 )
 
@@ -243,6 +257,18 @@ func createDataMsgForSrc(t *testing.T, isIPv6 bool, isIntraNode bool, isUpdatedR
 			} else {
 				util.Encode(value, binary.BigEndian, uint64(500))
 			}
+		case "octetTotalCount", "reverseOctetTotalCount":
+			if !isUpdatedRecord {
+				util.Encode(value, binary.BigEndian, uint64(50000))
+			} else {
+				util.Encode(value, binary.BigEndian, uint64(100000))
+			}
+		case "octetDeltaCount", "reverseOctetDeltaCount":
+			if !isUpdatedRecord {
+				util.Encode(value, binary.BigEndian, uint64(0))
+			} else {
+				util.Encode(value, binary.BigEndian, uint64(50000))
+			}
 		}
 		ieWithValue.Value = value
 		elements = append(elements, ieWithValue)
@@ -384,6 +410,18 @@ func createDataMsgForDst(t *testing.T, isIPv6 bool, isIntraNode bool, isUpdatedR
 			} else {
 				util.Encode(value, binary.BigEndian, uint64(503))
 			}
+		case "octetTotalCount", "reverseOctetTotalCount":
+			if !isUpdatedRecord {
+				util.Encode(value, binary.BigEndian, uint64(50200))
+			} else {
+				util.Encode(value, binary.BigEndian, uint64(100500))
+			}
+		case "octetDeltaCount", "reverseOctetDeltaCount":
+			if !isUpdatedRecord {
+				util.Encode(value, binary.BigEndian, uint64(0))
+			} else {
+				util.Encode(value, binary.BigEndian, uint64(50300))
+			}
 		}
 		ieWithValue.Value = value
 		elements = append(elements, ieWithValue)
@@ -457,8 +495,8 @@ func TestAggregateMsgByFlowKey(t *testing.T) {
 	assert.NotZero(t, len(aggregationProcess.flowKeyRecordMap))
 	assert.NotZero(t, aggregationProcess.expirePriorityQueue.Len())
 	flowKey := FlowKey{"10.0.0.1", "10.0.0.2", 6, 1234, 5678}
-	aggRecord := aggregationProcess.flowKeyRecordMap[flowKey]
-	assert.NotNil(t, aggregationProcess.flowKeyRecordMap[flowKey])
+	aggRecord := aggregationProcess.flowKeyRecordMap[aggregationKey{FlowKey: flowKey}]
+	assert.NotNil(t, aggregationProcess.flowKeyRecordMap[aggregationKey{FlowKey: flowKey}])
 	item := aggregationProcess.expirePriorityQueue.Peek()
 	assert.NotNil(t, item)
 	ieWithValue, exist := aggRecord.Record.GetInfoElementWithValue("sourceIPv4Address")
@@ -480,8 +518,8 @@ func TestAggregateMsgByFlowKey(t *testing.T) {
 	assert.Equal(t, 2, len(aggregationProcess.flowKeyRecordMap))
 	assert.Equal(t, 2, aggregationProcess.expirePriorityQueue.Len())
 	flowKey = FlowKey{"2001:0:3238:dfe1:63::fefb", "2001:0:3238:dfe1:63::fefc", 6, 1234, 5678}
-	assert.NotNil(t, aggregationProcess.flowKeyRecordMap[flowKey])
-	aggRecord = aggregationProcess.flowKeyRecordMap[flowKey]
+	assert.NotNil(t, aggregationProcess.flowKeyRecordMap[aggregationKey{FlowKey: flowKey}])
+	aggRecord = aggregationProcess.flowKeyRecordMap[aggregationKey{FlowKey: flowKey}]
 	ieWithValue, exist = aggRecord.Record.GetInfoElementWithValue("sourceIPv6Address")
 	assert.Equal(t, true, exist)
 	assert.Equal(t, net.IP{0x20, 0x1, 0x0, 0x0, 0x32, 0x38, 0xdf, 0xe1, 0x0, 0x63, 0x0, 0x0, 0x0, 0x0, 0xfe, 0xfb}, ieWithValue.Value)
@@ -517,10 +555,94 @@ func TestAggregationProcess(t *testing.T) {
 	flowKey := FlowKey{
 		"10.0.0.1", "10.0.0.2", 6, 1234, 5678,
 	}
-	aggRecord := aggregationProcess.flowKeyRecordMap[flowKey]
+	aggRecord := aggregationProcess.flowKeyRecordMap[aggregationKey{FlowKey: flowKey}]
 	assert.Equalf(t, aggRecord.Record, dataMsg.GetSet().GetRecords()[0], "records should be equal")
 }
 
+func TestAggregationProcessWithFlowAffinity(t *testing.T) {
+	messageChan := make(chan *entities.Message)
+	input := AggregationInput{
+		MessageChan:     messageChan,
+		WorkerNum:       4,
+		CorrelateFields: fields,
+		FlowAffinity:    true,
+	}
+	aggregationProcess, _ := InitAggregationProcess(input)
+	go func() {
+		messageChan <- createMsgwithTemplateSet(false)
+		messageChan <- createDataMsgForSrc(t, false, false, false, false, false)
+		messageChan <- createDataMsgForSrc(t, true, false, false, false, false)
+		messageChan <- createDataMsgForDst(t, false, false, false, false, false)
+		err := wait.Poll(10*time.Millisecond, time.Second, func() (bool, error) {
+			aggregationProcess.mutex.RLock()
+			defer aggregationProcess.mutex.RUnlock()
+			return aggregationProcess.flowKeyRecordMap[aggregationKey{FlowKey: FlowKey{"10.0.0.1", "10.0.0.2", 6, 1234, 5678}}].ReadyToSend, nil
+		})
+		assert.NoError(t, err)
+		aggregationProcess.Stop()
+	}()
+	aggregationProcess.Start()
+	assert.Equal(t, 2, len(aggregationProcess.flowKeyRecordMap))
+}
+
+func TestDispatchMsgByFlowKey(t *testing.T) {
+	ap, _ := InitAggregationProcess(AggregationInput{
+		MessageChan:     make(chan *entities.Message),
+		WorkerNum:       4,
+		CorrelateFields: fields,
+		FlowAffinity:    true,
+	})
+	for i := 0; i < 4; i++ {
+		ap.flowRecordChans = append(ap.flowRecordChans, make(chan flowRecordUpdate, 4))
+	}
+	srcRecord := createDataMsgForSrc(t, false, false, false, false, false)
+	dstRecord := createDataMsgForDst(t, false, false, false, false, false)
+	assert.NoError(t, ap.dispatchMsgByFlowKey(srcRecord))
+	assert.NoError(t, ap.dispatchMsgByFlowKey(dstRecord))
+	// Both records of the flow are sent to the same worker, in order.
+	flowKey := FlowKey{"10.0.0.1", "10.0.0.2", 6, 1234, 5678}
+	recordChan := ap.flowRecordChans[getFlowWorkerIndex(&flowKey, 4)]
+	assert.Equal(t, 2, len(recordChan))
+	update := <-recordChan
+	assert.Equal(t, flowKey, *update.flowKey)
+	assert.Equal(t, srcRecord.GetSet().GetRecords()[0], update.record)
+	update = <-recordChan
+	assert.Equal(t, dstRecord.GetSet().GetRecords()[0], update.record)
+	assert.Empty(t, ap.flowKeyRecordMap)
+}
+
+func TestAggregateMsgByFlowKeyWithLatencyProbes(t *testing.T) {
+	ap, _ := InitAggregationProcess(AggregationInput{
+		MessageChan:          make(chan *entities.Message),
+		WorkerNum:            1,
+		CorrelateFields:      fields,
+		MeasureLatencyProbes: true,
+	})
+	set := entities.NewSet(true)
+	set.PrepareSet(entities.Data, testTemplateID)
+	sequence := new(bytes.Buffer)
+	sendTime := new(bytes.Buffer)
+	util.Encode(sequence, binary.BigEndian, uint64(1))
+	util.Encode(sendTime, binary.BigEndian, uint64(time.Now().Add(-time.Second).UnixNano()))
+	sequenceElement, _ := registry.GetInfoElement("latencyProbeSequence", registry.AntreaEnterpriseID)
+	sendTimeElement, _ := registry.GetInfoElement("latencyProbeSendTime", registry.AntreaEnterpriseID)
+	err := set.AddRecord([]*entities.InfoElementWithValue{
+		entities.NewInfoElementWithValue(sequenceElement, sequence),
+		entities.NewInfoElementWithValue(sendTimeElement, sendTime),
+	}, testTemplateID)
+	assert.NoError(t, err)
+	message := entities.NewMessage(true)
+	message.SetExportAddress("127.0.0.1")
+	message.AddSet(set)
+
+	// Probes are measured but not aggregated.
+	assert.NoError(t, ap.AggregateMsgByFlowKey(message))
+	assert.Empty(t, ap.flowKeyRecordMap)
+	stats := ap.GetLatencyProbeStats()[entities.LatencyProbeSource{ExporterAddress: "127.0.0.1"}]
+	assert.Equal(t, uint64(1), stats.Received)
+	assert.True(t, stats.LastLatency >= time.Second)
+}
+
 func TestAddOriginalExporterInfo(t *testing.T) {
 	// Test message with template set
 	message := createMsgwithTemplateSet(false)
@@ -531,17 +653,126 @@ func TestAddOriginalExporterInfo(t *testing.T) {
 	assert.Equal(t, true, exist)
 	_, exist = record.GetInfoElementWithValue("originalObservationDomainId")
 	assert.Equal(t, true, exist)
+	ieWithValue, exist := record.GetInfoElementWithValue("exporterChainAddresses")
+	assert.Equal(t, true, exist)
+	assert.Equal(t, entities.BasicList, ieWithValue.Element.DataType)
+	_, exist = record.GetInfoElementWithValue("exporterChainObservationDomainIds")
+	assert.Equal(t, true, exist)
 	// Test message with data set
 	message = createDataMsgForSrc(t, false, false, false, false, false)
 	err = addOriginalExporterInfo(message)
 	assert.NoError(t, err)
 	record = message.GetSet().GetRecords()[0]
-	ieWithValue, exist := record.GetInfoElementWithValue("originalExporterIPv4Address")
+	ieWithValue, exist = record.GetInfoElementWithValue("originalExporterIPv4Address")
 	assert.Equal(t, true, exist)
 	assert.Equal(t, net.IP{0x7f, 0x0, 0x0, 0x1}, ieWithValue.Value)
 	ieWithValue, exist = record.GetInfoElementWithValue("originalObservationDomainId")
 	assert.Equal(t, true, exist)
 	assert.Equal(t, uint32(1234), ieWithValue.Value)
+	ieWithValue, exist = record.GetInfoElementWithValue("exporterChainAddresses")
+	assert.Equal(t, true, exist)
+	addresses := ieWithValue.Value.(*entities.BasicListValue)
+	assert.Equal(t, "originalExporterIPv6Address", addresses.Element.Name)
+	assert.Equal(t, []interface{}{net.ParseIP("127.0.0.1")}, addresses.Values)
+	ieWithValue, exist = record.GetInfoElementWithValue("exporterChainObservationDomainIds")
+	assert.Equal(t, true, exist)
+	obsDomainIDs := ieWithValue.Value.(*entities.BasicListValue)
+	assert.Equal(t, "originalObservationDomainId", obsDomainIDs.Element.Name)
+	assert.Equal(t, []interface{}{uint32(1234)}, obsDomainIDs.Values)
+}
+
+// getExporterChainHops returns the hops of the exporter chain of the record as
+// address/observationDomainId strings.
+func getExporterChainHops(t *testing.T, record entities.Record) []string {
+	chain, exist := getExporterChain(record)
+	assert.True(t, exist)
+	hops := make([]string, len(chain))
+	for i, hop := range chain {
+		hops[i] = fmt.Sprintf("%s/%d", hop.address, hop.obsDomainID)
+	}
+	return hops
+}
+
+func TestAddOriginalExporterInfoMultipleHops(t *testing.T) {
+	// The template re-exported by the first tier is not extended again.
+	message := createMsgwithTemplateSet(false)
+	assert.NoError(t, addOriginalExporterInfo(message))
+	fieldCount := len(message.GetSet().GetRecords()[0].GetOrderedElementList())
+	message.SetExportAddress("10.0.0.2")
+	assert.NoError(t, addOriginalExporterInfo(message))
+	assert.Len(t, message.GetSet().GetRecords()[0].GetOrderedElementList(), fieldCount)
+
+	// The record is received by the first tier from its original exporter,
+	// then re-exported to the second and third tiers.
+	message = createDataMsgForSrc(t, false, false, false, false, false)
+	assert.NoError(t, addOriginalExporterInfo(message))
+	record := message.GetSet().GetRecords()[0]
+	fieldCount = len(record.GetOrderedElementList())
+	message.SetExportAddress("10.0.0.2")
+	message.SetObsDomainID(1)
+	assert.NoError(t, addOriginalExporterInfo(message))
+	message.SetExportAddress("2001:db8::3")
+	message.SetObsDomainID(2)
+	assert.NoError(t, addOriginalExporterInfo(message))
+	assert.Len(t, record.GetOrderedElementList(), fieldCount)
+	ieWithValue, _ := record.GetInfoElementWithValue("originalExporterIPv4Address")
+	assert.Equal(t, net.IP{0x7f, 0x0, 0x0, 0x1}, ieWithValue.Value)
+	ieWithValue, _ = record.GetInfoElementWithValue("originalObservationDomainId")
+	assert.Equal(t, uint32(1234), ieWithValue.Value)
+	_, exist := record.GetInfoElementWithValue("originalExporterIPv6Address")
+	assert.False(t, exist)
+	assert.Equal(t, []string{"127.0.0.1/1234", "10.0.0.2/1", "2001:db8::3/2"}, getExporterChainHops(t, record))
+
+	// The chain of records from aggregation processes without it starts from
+	// their original exporter.
+	message = createDataMsgForSrc(t, false, false, false, false, false)
+	record = message.GetSet().GetRecords()[0]
+	ie, _ := registry.GetInfoElement("originalExporterIPv4Address", registry.IANAEnterpriseID)
+	_, err := record.AddInfoElement(entities.NewInfoElementWithValue(ie, net.ParseIP("10.0.0.1")), false)
+	assert.NoError(t, err)
+	message.SetExportAddress("10.0.0.2")
+	assert.NoError(t, addOriginalExporterInfo(message))
+	assert.Equal(t, []string{"10.0.0.1/0", "10.0.0.2/1234"}, getExporterChainHops(t, record))
+}
+
+func TestGetExporterChainUndecoded(t *testing.T) {
+	message := createDataMsgForSrc(t, false, false, false, false, false)
+	message.SetExportAddress("10.0.0.1")
+	assert.NoError(t, addOriginalExporterInfo(message))
+	record := message.GetSet().GetRecords()[0]
+	// Lists left undecoded by the collecting process are decoded with the
+	// registry.
+	for _, name := range []string{"exporterChainAddresses", "exporterChainObservationDomainIds"} {
+		ieWithValue, _ := record.GetInfoElementWithValue(name)
+		buff := new(bytes.Buffer)
+		_, err := entities.EncodeElementValue(ieWithValue.Element, ieWithValue.Value, buff, entities.OverflowPolicyError)
+		assert.NoError(t, err)
+		content, err := entities.ReadElementValue(ieWithValue.Element, buff)
+		assert.NoError(t, err)
+		ieWithValue.Value = content
+	}
+	assert.Equal(t, []string{"10.0.0.1/1234"}, getExporterChainHops(t, record))
+}
+
+func TestCorrelateRecordsExporterChain(t *testing.T) {
+	ap, err := InitAggregationProcess(AggregationInput{
+		MessageChan:     make(chan *entities.Message),
+		WorkerNum:       1,
+		CorrelateFields: fields,
+	})
+	assert.NoError(t, err)
+	message1 := createDataMsgForSrc(t, false, false, false, false, false)
+	message1.SetExportAddress("10.0.0.1")
+	assert.NoError(t, addOriginalExporterInfo(message1))
+	message2 := createDataMsgForDst(t, false, false, false, false, false)
+	message2.SetExportAddress("10.0.0.2")
+	assert.NoError(t, addOriginalExporterInfo(message2))
+	record1 := message1.GetSet().GetRecords()[0]
+	ap.correlateRecords(message2.GetSet().GetRecords()[0], record1)
+	assert.Equal(t, []string{"10.0.0.1/1234", "10.0.0.2/1234"}, getExporterChainHops(t, record1))
+	// The origin of the existing record is kept.
+	ieWithValue, _ := record1.GetInfoElementWithValue("originalExporterIPv4Address")
+	assert.Equal(t, net.ParseIP("10.0.0.1").To4(), ieWithValue.Value)
 }
 
 func TestAddOriginalExporterInfoIPv6(t *testing.T) {
@@ -584,7 +815,7 @@ func TestCorrelateRecordsForInterNodeFlow(t *testing.T) {
 	runCorrelationAndCheckResult(t, ap, record1, record2, false, false, true)
 	// Cleanup the flowKeyMap in aggregation process.
 	flowKey1, _ := getFlowKeyFromRecord(record1)
-	err := ap.deleteFlowKeyFromMap(*flowKey1)
+	err := ap.deleteFlowKeyFromMap(aggregationKey{FlowKey: *flowKey1})
 	assert.NoError(t, err)
 	heap.Pop(&ap.expirePriorityQueue)
 	// Test the scenario, where record2 is added first and then record1.
@@ -592,7 +823,7 @@ func TestCorrelateRecordsForInterNodeFlow(t *testing.T) {
 	record2 = createDataMsgForDst(t, false, false, false, false, false).GetSet().GetRecords()[0]
 	runCorrelationAndCheckResult(t, ap, record2, record1, false, false, true)
 	// Cleanup the flowKeyMap in aggregation process.
-	err = ap.deleteFlowKeyFromMap(*flowKey1)
+	err = ap.deleteFlowKeyFromMap(aggregationKey{FlowKey: *flowKey1})
 	assert.NoError(t, err)
 	heap.Pop(&ap.expirePriorityQueue)
 	// Test IPv6 fields.
@@ -602,7 +833,7 @@ func TestCorrelateRecordsForInterNodeFlow(t *testing.T) {
 	runCorrelationAndCheckResult(t, ap, record1, record2, true, false, true)
 	// Cleanup the flowKeyMap in aggregation process.
 	flowKey1, _ = getFlowKeyFromRecord(record1)
-	err = ap.deleteFlowKeyFromMap(*flowKey1)
+	err = ap.deleteFlowKeyFromMap(aggregationKey{FlowKey: *flowKey1})
 	assert.NoError(t, err)
 	heap.Pop(&ap.expirePriorityQueue)
 	// Test the scenario, where record2 is added first and then record1.
@@ -624,20 +855,20 @@ func TestCorrelateRecordsForInterNodeDenyFlow(t *testing.T) {
 	runCorrelationAndCheckResult(t, ap, record1, nil, false, false, false)
 	// Cleanup the flowKeyMap in aggregation process.
 	flowKey1, _ := getFlowKeyFromRecord(record1)
-	ap.deleteFlowKeyFromMap(*flowKey1)
+	ap.deleteFlowKeyFromMap(aggregationKey{FlowKey: *flowKey1})
 	heap.Pop(&ap.expirePriorityQueue)
 	// Test the scenario, where dst record has ingress reject rule
 	record2 := createDataMsgForDst(t, false, false, false, true, false).GetSet().GetRecords()[0]
 	runCorrelationAndCheckResult(t, ap, record2, nil, false, false, false)
 	// Cleanup the flowKeyMap in aggregation process.
-	ap.deleteFlowKeyFromMap(*flowKey1)
+	ap.deleteFlowKeyFromMap(aggregationKey{FlowKey: *flowKey1})
 	heap.Pop(&ap.expirePriorityQueue)
 	// Test the scenario, where dst record has ingress drop rule
 	record1 = createDataMsgForSrc(t, false, false, false, false, false).GetSet().GetRecords()[0]
 	record2 = createDataMsgForDst(t, false, false, false, false, true).GetSet().GetRecords()[0]
 	runCorrelationAndCheckResult(t, ap, record1, record2, false, false, true)
 	// Cleanup the flowKeyMap in aggregation process.
-	ap.deleteFlowKeyFromMap(*flowKey1)
+	ap.deleteFlowKeyFromMap(aggregationKey{FlowKey: *flowKey1})
 
 }
 
@@ -656,7 +887,7 @@ func TestCorrelateRecordsForIntraNodeFlow(t *testing.T) {
 	runCorrelationAndCheckResult(t, ap, record1, nil, false, true, false)
 	// Cleanup the flowKeyMap in aggregation process.
 	flowKey1, _ := getFlowKeyFromRecord(record1)
-	err := ap.deleteFlowKeyFromMap(*flowKey1)
+	err := ap.deleteFlowKeyFromMap(aggregationKey{FlowKey: *flowKey1})
 	assert.NoError(t, err)
 	heap.Pop(&ap.expirePriorityQueue)
 	// Test IPv6 fields.
@@ -679,7 +910,7 @@ func TestCorrelateRecordsForToExternalFlow(t *testing.T) {
 	runCorrelationAndCheckResult(t, ap, record1, nil, false, true, false)
 	// Cleanup the flowKeyMap in aggregation process.
 	flowKey1, _ := getFlowKeyFromRecord(record1)
-	err := ap.deleteFlowKeyFromMap(*flowKey1)
+	err := ap.deleteFlowKeyFromMap(aggregationKey{FlowKey: *flowKey1})
 	assert.NoError(t, err)
 	heap.Pop(&ap.expirePriorityQueue)
 	// Test IPv6 fields.
@@ -729,13 +960,14 @@ func TestDeleteFlowKeyFromMapWithLock(t *testing.T) {
 		&ItemToExpire{},
 		true,
 		0,
+		cardinalityKeys{},
 	}
-	aggregationProcess.flowKeyRecordMap[flowKey1] = aggFlowRecord
+	aggregationProcess.flowKeyRecordMap[aggregationKey{FlowKey: flowKey1}] = aggFlowRecord
 	assert.Equal(t, 1, len(aggregationProcess.flowKeyRecordMap))
-	err := aggregationProcess.deleteFlowKeyFromMap(flowKey2)
+	err := aggregationProcess.deleteFlowKeyFromMap(aggregationKey{FlowKey: flowKey2})
 	assert.Error(t, err)
 	assert.Equal(t, 1, len(aggregationProcess.flowKeyRecordMap))
-	err = aggregationProcess.deleteFlowKeyFromMap(flowKey1)
+	err = aggregationProcess.deleteFlowKeyFromMap(aggregationKey{FlowKey: flowKey1})
 	assert.NoError(t, err)
 	assert.Empty(t, aggregationProcess.flowKeyRecordMap)
 }
@@ -795,6 +1027,7 @@ func TestForAllExpiredFlowRecordsDo(t *testing.T) {
 		CorrelateFields:       fields,
 		ActiveExpiryTimeout:   testActiveExpiry,
 		InactiveExpiryTimeout: testInactiveExpiry,
+		CheckQueueInvariants:  true,
 	}
 	ap, _ := InitAggregationProcess(input)
 	// Add records with IPv4 fields.
@@ -888,10 +1121,43 @@ func TestForAllExpiredFlowRecordsDo(t *testing.T) {
 			}
 			assert.Equalf(t, tc.expectedExecutions, numExecutions, "number of callback executions are incorrect")
 			assert.Equalf(t, tc.expectedPQLen, ap.expirePriorityQueue.Len(), "expected pq length not correct")
+			assert.Equal(t, uint64(0), ap.GetExpirePriorityQueueStats().InvariantViolations)
 		})
 	}
 }
 
+func TestGetExpirePriorityQueueStats(t *testing.T) {
+	messageChan := make(chan *entities.Message)
+	input := AggregationInput{
+		MessageChan:           messageChan,
+		WorkerNum:             2,
+		CorrelateFields:       fields,
+		ActiveExpiryTimeout:   testActiveExpiry,
+		InactiveExpiryTimeout: testInactiveExpiry,
+		CheckQueueInvariants:  true,
+	}
+	ap, _ := InitAggregationProcess(input)
+	assert.Equal(t, ExpirePriorityQueueStats{}, ap.GetExpirePriorityQueueStats())
+	recordIPv4Src := createDataMsgForSrc(t, false, false, false, false, false).GetSet().GetRecords()[0]
+	recordIPv6Src := createDataMsgForSrc(t, true, false, false, false, false).GetSet().GetRecords()[0]
+	flowKey, _ := getFlowKeyFromRecord(recordIPv4Src)
+	assert.NoError(t, ap.addOrUpdateRecordInMap(flowKey, recordIPv4Src))
+	time.Sleep(10 * time.Millisecond)
+	flowKey, _ = getFlowKeyFromRecord(recordIPv6Src)
+	assert.NoError(t, ap.addOrUpdateRecordInMap(flowKey, recordIPv6Src))
+	stats := ap.GetExpirePriorityQueueStats()
+	assert.Equal(t, 2, stats.Depth)
+	assert.GreaterOrEqual(t, int64(stats.OldestItemAge), int64(10*time.Millisecond))
+	assert.Equal(t, uint64(0), stats.InvariantViolations)
+
+	// A flow record missing from the queue is detected on the next mutation.
+	heap.Pop(&ap.expirePriorityQueue)
+	assert.Error(t, ap.deleteFlowKeyFromMap(aggregationKey{}))
+	stats = ap.GetExpirePriorityQueueStats()
+	assert.Equal(t, 1, stats.Depth)
+	assert.Equal(t, uint64(1), stats.InvariantViolations)
+}
+
 func runCorrelationAndCheckResult(t *testing.T, ap *AggregationProcess, record1, record2 entities.Record, isIPv6, isIntraNode, needsCorrleation bool) {
 	flowKey1, _ := getFlowKeyFromRecord(record1)
 	err := ap.addOrUpdateRecordInMap(flowKey1, record1)
@@ -907,10 +1173,12 @@ func runCorrelationAndCheckResult(t *testing.T, ap *AggregationProcess, record1,
 	}
 	assert.Equal(t, 1, len(ap.flowKeyRecordMap))
 	assert.Equal(t, 1, ap.expirePriorityQueue.Len())
-	aggRecord, _ := ap.flowKeyRecordMap[*flowKey1]
+	aggRecord, _ := ap.flowKeyRecordMap[aggregationKey{FlowKey: *flowKey1}]
 	item = ap.expirePriorityQueue.Peek()
 	assert.Equal(t, aggRecord, *item.flowRecord)
 	assert.Equal(t, oldActiveExpiryTime, item.activeExpireTime)
+	status, _ := aggRecord.Record.GetInfoElementWithValue("flowAggregationStatus")
+	assert.Equal(t, registry.FlowAggregationStatusCorrelated, status.Value)
 	if !isIntraNode && needsCorrleation {
 		assert.NotEqual(t, oldInactiveExpiryTime, item.inactiveExpireTime)
 	}
@@ -961,7 +1229,7 @@ func runAggregationAndCheckResult(t *testing.T, ap *AggregationProcess, srcRecor
 	}
 	assert.Equal(t, 1, len(ap.flowKeyRecordMap))
 	assert.Equal(t, 1, ap.expirePriorityQueue.Len())
-	aggRecord, _ := ap.flowKeyRecordMap[*flowKey]
+	aggRecord, _ := ap.flowKeyRecordMap[aggregationKey{FlowKey: *flowKey}]
 	item = ap.expirePriorityQueue.Peek()
 	assert.Equal(t, aggRecord, *item.flowRecord)
 	assert.Equal(t, oldActiveExpiryTime, item.activeExpireTime)
@@ -1004,3 +1272,206 @@ func runAggregationAndCheckResult(t *testing.T, ap *AggregationProcess, srcRecor
 		assert.Equalf(t, latestRecord.Value, ieWithValue.Value, "values should be equal for element %v", e)
 	}
 }
+
+func TestCorrelationTimeout(t *testing.T) {
+	messageChan := make(chan *entities.Message)
+	input := AggregationInput{
+		MessageChan:           messageChan,
+		WorkerNum:             2,
+		CorrelateFields:       fields,
+		ActiveExpiryTimeout:   testActiveExpiry,
+		InactiveExpiryTimeout: 10 * testInactiveExpiry,
+		CorrelationTimeout:    2 * testActiveExpiry,
+	}
+	ap, _ := InitAggregationProcess(input)
+	record := createDataMsgForSrc(t, false, false, false, false, false).GetSet().GetRecords()[0]
+	flowKey, _ := getFlowKeyFromRecord(record)
+	err := ap.addOrUpdateRecordInMap(flowKey, record)
+	assert.NoError(t, err)
+	ieWithValue, exist := record.GetInfoElementWithValue("uncorrelatedReason")
+	assert.True(t, exist)
+	assert.Equal(t, registry.UncorrelatedReasonNone, ieWithValue.Value)
+	ieWithValue, _ = record.GetInfoElementWithValue("flowAggregationStatus")
+	assert.Equal(t, registry.FlowAggregationStatusSourceOnly, ieWithValue.Value)
+
+	var exported []AggregationFlowRecord
+	testCallback := func(key FlowKey, record AggregationFlowRecord) error {
+		exported = append(exported, record)
+		return nil
+	}
+	// Only the active expiry timeout elapsed, so the record keeps waiting for
+	// the record from the destination node.
+	time.Sleep(testActiveExpiry)
+	err = ap.ForAllExpiredFlowRecordsDo(testCallback)
+	assert.NoError(t, err)
+	assert.Empty(t, exported)
+	assert.Equal(t, 1, ap.expirePriorityQueue.Len())
+
+	time.Sleep(testActiveExpiry)
+	err = ap.ForAllExpiredFlowRecordsDo(testCallback)
+	assert.NoError(t, err)
+	assert.Len(t, exported, 1)
+	assert.True(t, exported[0].ReadyToSend)
+	ieWithValue, _ = exported[0].Record.GetInfoElementWithValue("uncorrelatedReason")
+	assert.Equal(t, registry.UncorrelatedReasonCorrelationTimeout, ieWithValue.Value)
+	ieWithValue, _ = exported[0].Record.GetInfoElementWithValue("flowAggregationStatus")
+	assert.Equal(t, registry.FlowAggregationStatusTimedOut, ieWithValue.Value)
+	assert.True(t, ap.flowKeyRecordMap[aggregationKey{FlowKey: *flowKey}].ReadyToSend)
+	assert.Equal(t, 1, ap.expirePriorityQueue.Len())
+	assert.Equal(t, map[uint8]uint64{registry.UncorrelatedReasonCorrelationTimeout: 1}, ap.GetUncorrelatedExports())
+
+	// Records which are correlated in time do not use the reason field.
+	recordIPv6Src := createDataMsgForSrc(t, true, false, false, false, false).GetSet().GetRecords()[0]
+	recordIPv6Dst := createDataMsgForDst(t, true, false, false, false, false).GetSet().GetRecords()[0]
+	for _, record := range []entities.Record{recordIPv6Dst, recordIPv6Src} {
+		flowKey, _ := getFlowKeyFromRecord(record)
+		err = ap.addOrUpdateRecordInMap(flowKey, record)
+		assert.NoError(t, err)
+		if record == recordIPv6Dst {
+			ieWithValue, _ = record.GetInfoElementWithValue("flowAggregationStatus")
+			assert.Equal(t, registry.FlowAggregationStatusDestinationOnly, ieWithValue.Value)
+		}
+	}
+	ieWithValue, _ = recordIPv6Dst.GetInfoElementWithValue("flowAggregationStatus")
+	assert.Equal(t, registry.FlowAggregationStatusCorrelated, ieWithValue.Value)
+	ieWithValue, _ = recordIPv6Dst.GetInfoElementWithValue("uncorrelatedReason")
+	assert.Equal(t, registry.UncorrelatedReasonNone, ieWithValue.Value)
+	assert.True(t, ap.expirePriorityQueue[ap.expirePriorityQueue.Len()-1].correlationExpireTime.IsZero())
+}
+
+func TestAggregateMsgByFlowKeyWithExtraElements(t *testing.T) {
+	messageChan := make(chan *entities.Message)
+	input := AggregationInput{
+		MessageChan:     messageChan,
+		WorkerNum:       2,
+		CorrelateFields: fields,
+		FlowKeyElements: []string{"ipClassOfService", "flowLabelIPv6"},
+	}
+	aggregationProcess, _ := InitAggregationProcess(input)
+	addExtraElements := func(message *entities.Message, classOfService uint8, flowLabel uint32) {
+		record := message.GetSet().GetRecords()[0]
+		for _, ie := range []struct {
+			name  string
+			value interface{}
+		}{{"ipClassOfService", classOfService}, {"flowLabelIPv6", flowLabel}} {
+			element, err := registry.GetInfoElement(ie.name, registry.IANAEnterpriseID)
+			assert.NoError(t, err)
+			buff := new(bytes.Buffer)
+			util.Encode(buff, binary.BigEndian, ie.value)
+			_, err = record.AddInfoElement(entities.NewInfoElementWithValue(element, buff), true)
+			assert.NoError(t, err)
+		}
+	}
+	// Records differing only by DSCP are aggregated separately.
+	message1 := createDataMsgForSrc(t, true, false, false, false, false)
+	addExtraElements(message1, 46<<2, 0x12345)
+	message2 := createDataMsgForSrc(t, true, false, false, false, false)
+	addExtraElements(message2, 0, 0x12345)
+	assert.NoError(t, aggregationProcess.AggregateMsgByFlowKey(message1))
+	assert.NoError(t, aggregationProcess.AggregateMsgByFlowKey(message2))
+	assert.Equal(t, 2, len(aggregationProcess.flowKeyRecordMap))
+	flowKey := aggregationKey{FlowKey{"2001:0:3238:dfe1:63::fefb", "2001:0:3238:dfe1:63::fefc", 6, 1234, 5678}, "ipClassOfService=184,flowLabelIPv6=74565"}
+	aggRecord := aggregationProcess.flowKeyRecordMap[flowKey]
+	assert.NotNil(t, aggRecord.Record)
+	// A record without the elements has empty values in the flow key.
+	message3 := createDataMsgForSrc(t, true, false, false, false, false)
+	assert.NoError(t, aggregationProcess.AggregateMsgByFlowKey(message3))
+	assert.Equal(t, 3, len(aggregationProcess.flowKeyRecordMap))
+	flowKey.ExtraKey = "ipClassOfService=,flowLabelIPv6="
+	aggRecord = aggregationProcess.flowKeyRecordMap[flowKey]
+	assert.NotNil(t, aggRecord.Record)
+}
+
+func TestEncodeDecodeExtraFlowKeyElements(t *testing.T) {
+	classOfService, err := registry.GetInfoElement("ipClassOfService", registry.IANAEnterpriseID)
+	assert.NoError(t, err)
+	flowLabel, err := registry.GetInfoElement("flowLabelIPv6", registry.IANAEnterpriseID)
+	assert.NoError(t, err)
+	record := entities.NewDataRecord(testTemplateID)
+	_, err = record.AddInfoElement(entities.NewInfoElementWithValue(classOfService, uint8(46<<2)), false)
+	assert.NoError(t, err)
+	_, err = record.AddInfoElement(entities.NewInfoElementWithValue(flowLabel, uint32(0xfffff)), false)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xb8, 0x00, 0x0f, 0xff, 0xff}, record.GetBuffer().Bytes())
+
+	buff := bytes.NewBuffer(record.GetBuffer().Bytes())
+	decoded := entities.NewDataRecord(testTemplateID)
+	_, err = decoded.AddInfoElement(entities.NewInfoElementWithValue(classOfService, bytes.NewBuffer(buff.Next(1))), true)
+	assert.NoError(t, err)
+	_, err = decoded.AddInfoElement(entities.NewInfoElementWithValue(flowLabel, bytes.NewBuffer(buff.Next(4))), true)
+	assert.NoError(t, err)
+	assert.Equal(t, "ipClassOfService=184,flowLabelIPv6=1048575", getExtraFlowKey(decoded, []string{"ipClassOfService", "flowLabelIPv6"}))
+}
+
+func TestCorrelateRecordsWithMacAndVlanFields(t *testing.T) {
+	messageChan := make(chan *entities.Message)
+	input := AggregationInput{
+		MessageChan:     messageChan,
+		WorkerNum:       2,
+		CorrelateFields: []string{"destinationMacAddress", "vlanId", "dot1qVlanId"},
+		FlowKeyElements: []string{"sourceMacAddress"},
+	}
+	ap, _ := InitAggregationProcess(input)
+	addMacAndVlanFields := func(record entities.Record, srcMac, dstMac net.HardwareAddr, vlanID, dot1qVlanID uint16) {
+		for _, ie := range []struct {
+			name  string
+			value interface{}
+		}{{"sourceMacAddress", srcMac}, {"destinationMacAddress", dstMac}, {"vlanId", vlanID}, {"dot1qVlanId", dot1qVlanID}} {
+			element, err := registry.GetInfoElement(ie.name, registry.IANAEnterpriseID)
+			assert.NoError(t, err)
+			buff := new(bytes.Buffer)
+			util.Encode(buff, binary.BigEndian, ie.value)
+			_, err = record.AddInfoElement(entities.NewInfoElementWithValue(element, buff), true)
+			assert.NoError(t, err)
+		}
+	}
+	srcMac, _ := net.ParseMAC("aa:bb:cc:dd:ee:01")
+	dstMac, _ := net.ParseMAC("aa:bb:cc:dd:ee:02")
+	zeroMac := make(net.HardwareAddr, 6)
+	srcRecord := createDataMsgForSrc(t, false, false, false, false, false).GetSet().GetRecords()[0]
+	addMacAndVlanFields(srcRecord, srcMac, zeroMac, 0, 0)
+	dstRecord := createDataMsgForDst(t, false, false, false, false, false).GetSet().GetRecords()[0]
+	addMacAndVlanFields(dstRecord, srcMac, dstMac, 100, 200)
+
+	flowKey := FlowKey{"10.0.0.1", "10.0.0.2", 6, 1234, 5678}
+	assert.NoError(t, ap.addOrUpdateRecordInMap(&flowKey, srcRecord))
+	assert.Equal(t, "sourceMacAddress=aa:bb:cc:dd:ee:01", getExtraFlowKey(dstRecord, input.FlowKeyElements))
+	assert.NoError(t, ap.addOrUpdateRecordInMap(&flowKey, dstRecord))
+	assert.Equal(t, 1, len(ap.flowKeyRecordMap))
+	aggRecord := ap.flowKeyRecordMap[aggregationKey{flowKey, "sourceMacAddress=aa:bb:cc:dd:ee:01"}]
+	assert.True(t, aggRecord.ReadyToSend)
+	ieWithValue, _ := aggRecord.Record.GetInfoElementWithValue("sourceMacAddress")
+	assert.Equal(t, srcMac, ieWithValue.Value)
+	ieWithValue, _ = aggRecord.Record.GetInfoElementWithValue("destinationMacAddress")
+	assert.Equal(t, dstMac, ieWithValue.Value)
+	ieWithValue, _ = aggRecord.Record.GetInfoElementWithValue("vlanId")
+	assert.Equal(t, uint16(100), ieWithValue.Value)
+	ieWithValue, _ = aggRecord.Record.GetInfoElementWithValue("dot1qVlanId")
+	assert.Equal(t, uint16(200), ieWithValue.Value)
+}
+
+func TestFlowAggregationStatusField(t *testing.T) {
+	// flowAggregationStatus is the only element added to the records of new
+	// flows when no aggregate elements are configured.
+	for _, tc := range []struct {
+		record   entities.Record
+		expected uint8
+	}{
+		{createDataMsgForSrc(t, false, true, false, false, false).GetSet().GetRecords()[0], registry.FlowAggregationStatusCorrelated},
+		{createDataMsgForSrc(t, true, false, false, false, false).GetSet().GetRecords()[0], registry.FlowAggregationStatusSourceOnly},
+		{createDataMsgForDst(t, false, false, false, false, false).GetSet().GetRecords()[0], registry.FlowAggregationStatusDestinationOnly},
+	} {
+		ap, err := InitAggregationProcess(AggregationInput{
+			MessageChan:     make(chan *entities.Message),
+			WorkerNum:       1,
+			CorrelateFields: fields,
+		})
+		assert.NoError(t, err)
+		numElements := len(tc.record.GetOrderedElementList())
+		flowKey, err := getFlowKeyFromRecord(tc.record)
+		assert.NoError(t, err)
+		assert.NoError(t, ap.addOrUpdateRecordInMap(flowKey, tc.record))
+		assert.Len(t, tc.record.GetOrderedElementList(), numElements+1)
+		assert.Equal(t, tc.expected, getValue(tc.record, "flowAggregationStatus"))
+	}
+}
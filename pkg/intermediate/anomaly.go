@@ -0,0 +1,207 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+const (
+	defaultAnomalySmoothingFactor = 0.3
+	defaultAnomalyFactor          = 3
+	defaultAnomalyMinIntervals    = 3
+	defaultAnomalyStateTimeout    = 10 * time.Minute
+)
+
+var defaultAnomalyElements = []string{"octetDeltaCount", "packetDeltaCount"}
+
+type rateState struct {
+	// averages is the EWMA of every element
+	averages []float64
+	// intervals is the number of export intervals observed
+	intervals int
+	lastSeen  time.Time
+}
+
+// RateAnomalyDetector keeps, for every flow key, an exponentially weighted
+// moving average (EWMA) of the bytes and packets of the flow per export
+// interval, and scores every exported record by how much it deviates from the
+// average. The score is added to the record as anomalyScore: 1 means the flow
+// is at its average rate, and a score of Factor or more flags the record.
+//
+// ProcessRecord is meant to be called from the callback of
+// ForAllExpiredFlowRecordsDo, before the record is exported and its delta
+// counts are reset.
+type RateAnomalyDetector struct {
+	elements        []string
+	smoothingFactor float64
+	factor          float64
+	minIntervals    int
+	stateTimeout    time.Duration
+	mutex           sync.Mutex
+	states          map[FlowKey]*rateState
+	lastSweep       time.Time
+	flaggedRecords  uint64
+}
+
+type RateAnomalyDetectorInput struct {
+	// Elements are the unsigned64 delta count elements whose rates are
+	// monitored. If empty, they are octetDeltaCount and packetDeltaCount.
+	Elements []string
+	// SmoothingFactor is the weight of the last interval in the EWMA, between
+	// 0 and 1. If 0, it is 0.3.
+	SmoothingFactor float64
+	// Factor is the ratio between the rate of an interval and the average (or
+	// its inverse) from which a record is flagged. If 0, it is 3.
+	Factor float64
+	// MinIntervals is the number of intervals observed for a flow before its
+	// records are scored. If 0, it is 3.
+	MinIntervals int
+	// StateTimeout is the time after which the averages of a flow that is not
+	// exported anymore are forgotten. If 0, it is 10 minutes.
+	StateTimeout time.Duration
+}
+
+func InitRateAnomalyDetector(input RateAnomalyDetectorInput) (*RateAnomalyDetector, error) {
+	if input.SmoothingFactor < 0 || input.SmoothingFactor > 1 {
+		return nil, fmt.Errorf("smoothing factor %v should be between 0 and 1", input.SmoothingFactor)
+	}
+	if input.Factor != 0 && input.Factor <= 1 {
+		return nil, fmt.Errorf("anomaly factor %v should be greater than 1", input.Factor)
+	}
+	if len(input.Elements) == 0 {
+		input.Elements = defaultAnomalyElements
+	}
+	if input.SmoothingFactor == 0 {
+		input.SmoothingFactor = defaultAnomalySmoothingFactor
+	}
+	if input.Factor == 0 {
+		input.Factor = defaultAnomalyFactor
+	}
+	if input.MinIntervals <= 0 {
+		input.MinIntervals = defaultAnomalyMinIntervals
+	}
+	if input.StateTimeout == 0 {
+		input.StateTimeout = defaultAnomalyStateTimeout
+	}
+	return &RateAnomalyDetector{
+		elements:        input.Elements,
+		smoothingFactor: input.SmoothingFactor,
+		factor:          input.Factor,
+		minIntervals:    input.MinIntervals,
+		stateTimeout:    input.StateTimeout,
+		states:          make(map[FlowKey]*rateState),
+	}, nil
+}
+
+// ProcessRecord scores the record against the averages of the flow, sets its
+// anomalyScore, and updates the averages with the record. It returns the
+// score, which is 0 until MinIntervals intervals have been observed.
+func (d *RateAnomalyDetector) ProcessRecord(flowKey FlowKey, record entities.Record) (float64, error) {
+	values := make([]float64, len(d.elements))
+	for i, name := range d.elements {
+		ie, exist := record.GetInfoElementWithValue(name)
+		if !exist {
+			return 0, fmt.Errorf("%s is not present in the record", name)
+		}
+		value, ok := ie.Value.(uint64)
+		if !ok {
+			return 0, fmt.Errorf("value of %s is not unsigned64", name)
+		}
+		values[i] = float64(value)
+	}
+	now := time.Now()
+	d.mutex.Lock()
+	d.sweep(now)
+	state, exist := d.states[flowKey]
+	if !exist {
+		state = &rateState{averages: make([]float64, len(values))}
+		d.states[flowKey] = state
+	}
+	score := 0.0
+	if state.intervals >= d.minIntervals {
+		for i, value := range values {
+			score = math.Max(score, deviation(value, state.averages[i]))
+		}
+		if score >= d.factor {
+			d.flaggedRecords++
+		}
+	}
+	for i, value := range values {
+		if state.intervals == 0 {
+			state.averages[i] = value
+		} else {
+			state.averages[i] = d.smoothingFactor*value + (1-d.smoothingFactor)*state.averages[i]
+		}
+	}
+	state.intervals++
+	state.lastSeen = now
+	d.mutex.Unlock()
+	return score, setAnomalyScore(record, score)
+}
+
+// deviation returns the ratio between the value and the average, or its
+// inverse, whichever is greater. Values under 1 are counted as 1, so that idle
+// intervals and flows give finite scores.
+func deviation(value, average float64) float64 {
+	value = math.Max(value, 1)
+	average = math.Max(average, 1)
+	return math.Max(value/average, average/value)
+}
+
+// sweep forgets the flows that were not seen for the state timeout. It only
+// scans the flows once per state timeout.
+func (d *RateAnomalyDetector) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < d.stateTimeout {
+		return
+	}
+	for flowKey, state := range d.states {
+		if now.Sub(state.lastSeen) >= d.stateTimeout {
+			delete(d.states, flowKey)
+		}
+	}
+	d.lastSweep = now
+}
+
+// GetFlaggedRecords returns the number of records with a score of Factor or
+// more.
+func (d *RateAnomalyDetector) GetFlaggedRecords() uint64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.flaggedRecords
+}
+
+func setAnomalyScore(record entities.Record, score float64) error {
+	if _, exist := record.GetInfoElementWithValue("anomalyScore"); !exist {
+		element, err := registry.GetInfoElement("anomalyScore", registry.AntreaEnterpriseID)
+		if err != nil {
+			return err
+		}
+		if _, err = record.AddInfoElement(entities.NewInfoElementWithValue(element, score), false); err != nil {
+			return err
+		}
+	}
+	// The value is set after adding the element, as encoding a float64 value
+	// stores its bits.
+	ie, _ := record.GetInfoElementWithValue("anomalyScore")
+	ie.Value = score
+	return nil
+}
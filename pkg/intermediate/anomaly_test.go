@@ -0,0 +1,94 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func createDeltaRecord(t *testing.T, octets, packets uint64) entities.Record {
+	record := entities.NewDataRecord(testTemplateID)
+	for name, value := range map[string]uint64{"octetDeltaCount": octets, "packetDeltaCount": packets} {
+		element, err := registry.GetInfoElement(name, registry.IANAEnterpriseID)
+		assert.NoError(t, err)
+		_, err = record.AddInfoElement(entities.NewInfoElementWithValue(element, value), false)
+		assert.NoError(t, err)
+	}
+	return record
+}
+
+func TestRateAnomalyDetector(t *testing.T) {
+	detector, err := InitRateAnomalyDetector(RateAnomalyDetectorInput{})
+	assert.NoError(t, err)
+	flowKey := FlowKey{"10.0.0.1", "10.0.0.2", 6, 1234, 80}
+	otherFlowKey := FlowKey{"10.0.0.1", "10.0.0.3", 6, 1234, 80}
+
+	// No score until the minimum number of intervals is observed.
+	for i := 0; i < defaultAnomalyMinIntervals; i++ {
+		record := createDeltaRecord(t, 1000, 10)
+		score, err := detector.ProcessRecord(flowKey, record)
+		assert.NoError(t, err)
+		assert.Equal(t, 0.0, score)
+		assert.Equal(t, 0.0, getValue(record, "anomalyScore"))
+	}
+	record := createDeltaRecord(t, 1000, 10)
+	score, err := detector.ProcessRecord(flowKey, record)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, score)
+	assert.Equal(t, uint64(0), detector.GetFlaggedRecords())
+
+	// A burst of packets is flagged, even if the bytes do not change much.
+	record = createDeltaRecord(t, 1500, 50)
+	score, err = detector.ProcessRecord(flowKey, record)
+	assert.NoError(t, err)
+	assert.Equal(t, 5.0, score)
+	assert.Equal(t, 5.0, getValue(record, "anomalyScore"))
+	assert.Equal(t, uint64(1), detector.GetFlaggedRecords())
+	// A flow going idle is flagged too.
+	score, err = detector.ProcessRecord(flowKey, createDeltaRecord(t, 0, 0))
+	assert.NoError(t, err)
+	assert.Greater(t, score, 3.0)
+	assert.Equal(t, uint64(2), detector.GetFlaggedRecords())
+
+	// Flows have separate averages.
+	score, err = detector.ProcessRecord(otherFlowKey, createDeltaRecord(t, 1000, 10))
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, score)
+
+	_, err = detector.ProcessRecord(flowKey, entities.NewDataRecord(testTemplateID))
+	assert.Error(t, err)
+	_, err = InitRateAnomalyDetector(RateAnomalyDetectorInput{Factor: 0.5})
+	assert.Error(t, err)
+	_, err = InitRateAnomalyDetector(RateAnomalyDetectorInput{SmoothingFactor: 2})
+	assert.Error(t, err)
+}
+
+func TestRateAnomalyDetector_StateTimeout(t *testing.T) {
+	detector, err := InitRateAnomalyDetector(RateAnomalyDetectorInput{MinIntervals: 1, StateTimeout: time.Millisecond})
+	assert.NoError(t, err)
+	flowKey := FlowKey{"10.0.0.1", "10.0.0.2", 6, 1234, 80}
+	_, err = detector.ProcessRecord(flowKey, createDeltaRecord(t, 1000, 10))
+	assert.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	score, err := detector.ProcessRecord(flowKey, createDeltaRecord(t, 1000, 10))
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, score)
+}
@@ -0,0 +1,60 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"container/heap"
+	"time"
+)
+
+// deferExpiredRecords postpones the expiry of the flows which expired at the
+// current time but were not passed to the callback, as the maximum number of
+// expired records per call was reached. Their expiry is spread evenly over
+// the spread duration, in the order they expired. This should be called after
+// acquiring the mutex.
+func (a *AggregationProcess) deferExpiredRecords(currTime time.Time) {
+	deferred := make([]*ItemToExpire, 0)
+	for a.expirePriorityQueue.Len() > 0 && !a.expirePriorityQueue.minExpireTime(0).After(currTime) {
+		deferred = append(deferred, heap.Pop(&a.expirePriorityQueue).(*ItemToExpire))
+	}
+	for i, pqItem := range deferred {
+		expireTime := currTime
+		if a.expiredRecordsSpread > 0 {
+			expireTime = currTime.Add(a.expiredRecordsSpread * time.Duration(i+1) / time.Duration(len(deferred)))
+		}
+		if !pqItem.activeExpireTime.After(currTime) {
+			pqItem.activeExpireTime = expireTime
+		}
+		// The flow is still deleted after its inactive expiry, which is
+		// checked against the time it is processed.
+		if !pqItem.inactiveExpireTime.After(currTime) {
+			pqItem.inactiveExpireTime = expireTime.Add(-time.Nanosecond)
+		}
+		if !pqItem.correlationExpireTime.IsZero() && !pqItem.correlationExpireTime.After(currTime) {
+			pqItem.correlationExpireTime = expireTime
+		}
+		heap.Push(&a.expirePriorityQueue, pqItem)
+	}
+	a.deferredExpiredRecords += uint64(len(deferred))
+}
+
+// GetDeferredExpiredRecords returns the number of times the expiry of a flow
+// record was postponed because the maximum number of expired records per call
+// of ForAllExpiredFlowRecordsDo was reached.
+func (a *AggregationProcess) GetDeferredExpiredRecords() uint64 {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.deferredExpiredRecords
+}
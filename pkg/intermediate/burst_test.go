@@ -0,0 +1,72 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+func TestForAllExpiredFlowRecordsDoWithMaxExpiredRecords(t *testing.T) {
+	_, err := InitAggregationProcess(AggregationInput{
+		MessageChan:              make(chan *entities.Message),
+		WorkerNum:                1,
+		MaxExpiredRecordsPerCall: -1,
+	})
+	assert.Error(t, err)
+
+	spread := 50 * time.Millisecond
+	ap, err := InitAggregationProcess(AggregationInput{
+		MessageChan:              make(chan *entities.Message),
+		WorkerNum:                1,
+		CorrelateFields:          fields,
+		ActiveExpiryTimeout:      testActiveExpiry,
+		InactiveExpiryTimeout:    testInactiveExpiry,
+		MaxExpiredRecordsPerCall: 1,
+		ExpiredRecordsSpread:     spread,
+	})
+	assert.NoError(t, err)
+	for _, isIPv6 := range []bool{false, true} {
+		record := createDataMsgForSrc(t, isIPv6, true, false, false, false).GetSet().GetRecords()[0]
+		flowKey, _ := getFlowKeyFromRecord(record)
+		assert.NoError(t, ap.addOrUpdateRecordInMap(flowKey, record))
+	}
+	var exported []AggregationFlowRecord
+	testCallback := func(key FlowKey, record AggregationFlowRecord) error {
+		exported = append(exported, record)
+		return nil
+	}
+	time.Sleep(testInactiveExpiry)
+	assert.NoError(t, ap.ForAllExpiredFlowRecordsDo(testCallback))
+	// The expiry of the second flow is postponed within the spread.
+	assert.Len(t, exported, 1)
+	assert.Equal(t, 1, ap.expirePriorityQueue.Len())
+	assert.True(t, ap.expirePriorityQueue.minExpireTime(0).After(time.Now()))
+	assert.True(t, ap.GetExpiryFromExpirePriorityQueue() <= MinExpiryTime+spread)
+	assert.Equal(t, uint64(1), ap.GetDeferredExpiredRecords())
+	assert.NoError(t, ap.ForAllExpiredFlowRecordsDo(testCallback))
+	assert.Len(t, exported, 1)
+
+	// The flow is then exported and deleted after its inactive expiry.
+	time.Sleep(spread)
+	assert.NoError(t, ap.ForAllExpiredFlowRecordsDo(testCallback))
+	assert.Len(t, exported, 2)
+	assert.Equal(t, 0, ap.expirePriorityQueue.Len())
+	assert.Empty(t, ap.flowKeyRecordMap)
+}
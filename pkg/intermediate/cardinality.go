@@ -0,0 +1,198 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"net"
+	"strings"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// CardinalityLimits bound the number of flows aggregated separately per source
+// Pod namespace (sourcePodNamespace) and per source node (sourceNodeName), so
+// that e.g. a port scan from a Pod does not fill the aggregation process with
+// flows. The records of the new flows exceeding a limit are aggregated into a
+// catch-all record of the namespace or node instead, whose flowAggregationStatus
+// is FlowAggregationStatusCardinalityOverflow, and whose flow key elements are
+// zero. Its other elements are those of the first record aggregated into it,
+// except for stats: delta stats are added up as for any flow, and as the total
+// stats of different flows cannot be combined, total stats are the sums of the
+// matching delta stats (e.g. packetTotalCount of packetDeltaCount), so that
+// the bytes and packets of the excess flows are accounted for accurately.
+// Records without namespace or node, e.g. records from the destination node of
+// inter-node flows, are not limited.
+type CardinalityLimits struct {
+	// MaxFlowsPerNamespace is the maximum number of flows per source Pod
+	// namespace. 0 means no limit.
+	MaxFlowsPerNamespace int
+	// MaxFlowsPerNode is the maximum number of flows per source node. 0
+	// means no limit.
+	MaxFlowsPerNode int
+}
+
+// CardinalityStats contains the number of flows counted against the
+// cardinality limits.
+type CardinalityStats struct {
+	// FlowsPerNamespace is the number of flows per source Pod namespace,
+	// without catch-all records.
+	FlowsPerNamespace map[string]int
+	// FlowsPerNode is the number of flows per source node, without catch-all
+	// records.
+	FlowsPerNode map[string]int
+	// OverflowRecords is the number of records aggregated into catch-all
+	// records.
+	OverflowRecords uint64
+}
+
+// cardinalityKeys are the namespace and node against which a flow is counted.
+type cardinalityKeys struct {
+	namespace string
+	node      string
+}
+
+type cardinalityGuard struct {
+	limits          CardinalityLimits
+	namespaceFlows  map[string]int
+	nodeFlows       map[string]int
+	overflowRecords uint64
+}
+
+func newCardinalityGuard(limits CardinalityLimits) *cardinalityGuard {
+	return &cardinalityGuard{
+		limits:         limits,
+		namespaceFlows: make(map[string]int),
+		nodeFlows:      make(map[string]int),
+	}
+}
+
+// admitFlow counts the new flow of the record and returns the keys it is
+// counted against, or returns the flow key of the catch-all record into which
+// the record must be aggregated if the flow exceeds a limit.
+func (g *cardinalityGuard) admitFlow(record entities.Record) (*aggregationKey, cardinalityKeys) {
+	keys := cardinalityKeys{
+		namespace: getStringValue(record, "sourcePodNamespace"),
+		node:      getStringValue(record, "sourceNodeName"),
+	}
+	if keys.namespace != "" && g.limits.MaxFlowsPerNamespace > 0 && g.namespaceFlows[keys.namespace] >= g.limits.MaxFlowsPerNamespace {
+		g.overflowRecords++
+		return &aggregationKey{ExtraKey: "cardinalityOverflow,sourcePodNamespace=" + keys.namespace}, cardinalityKeys{}
+	}
+	if keys.node != "" && g.limits.MaxFlowsPerNode > 0 && g.nodeFlows[keys.node] >= g.limits.MaxFlowsPerNode {
+		g.overflowRecords++
+		return &aggregationKey{ExtraKey: "cardinalityOverflow,sourceNodeName=" + keys.node}, cardinalityKeys{}
+	}
+	if keys.namespace != "" {
+		g.namespaceFlows[keys.namespace]++
+	}
+	if keys.node != "" {
+		g.nodeFlows[keys.node]++
+	}
+	return nil, keys
+}
+
+// removeFlow uncounts a deleted flow.
+func (g *cardinalityGuard) removeFlow(keys cardinalityKeys) {
+	if g.namespaceFlows[keys.namespace] > 0 {
+		if g.namespaceFlows[keys.namespace]--; g.namespaceFlows[keys.namespace] == 0 {
+			delete(g.namespaceFlows, keys.namespace)
+		}
+	}
+	if g.nodeFlows[keys.node] > 0 {
+		if g.nodeFlows[keys.node]--; g.nodeFlows[keys.node] == 0 {
+			delete(g.nodeFlows, keys.node)
+		}
+	}
+}
+
+func (g *cardinalityGuard) getStats() CardinalityStats {
+	stats := CardinalityStats{
+		FlowsPerNamespace: make(map[string]int, len(g.namespaceFlows)),
+		FlowsPerNode:      make(map[string]int, len(g.nodeFlows)),
+		OverflowRecords:   g.overflowRecords,
+	}
+	for namespace, count := range g.namespaceFlows {
+		stats.FlowsPerNamespace[namespace] = count
+	}
+	for node, count := range g.nodeFlows {
+		stats.FlowsPerNode[node] = count
+	}
+	return stats
+}
+
+// GetCardinalityStats returns the number of flows counted against the
+// cardinality limits. It is empty if there are no limits.
+func (a *AggregationProcess) GetCardinalityStats() CardinalityStats {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.cardinalityGuard == nil {
+		return CardinalityStats{}
+	}
+	return a.cardinalityGuard.getStats()
+}
+
+func getStringValue(record entities.Record, name string) string {
+	if ieWithValue, exist := record.GetInfoElementWithValue(name); exist {
+		if value, ok := ieWithValue.Value.(string); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// clearFlowKeyFields zeroes the elements of the 5-tuple of the first record of
+// a catch-all flow.
+func clearFlowKeyFields(record entities.Record) {
+	for _, ieWithValue := range record.GetOrderedElementList() {
+		switch ieWithValue.Element.Name {
+		case "sourceIPv4Address", "destinationIPv4Address":
+			ieWithValue.Value = net.IP(make([]byte, net.IPv4len))
+		case "sourceIPv6Address", "destinationIPv6Address":
+			ieWithValue.Value = net.IP(make([]byte, net.IPv6len))
+		case "sourceTransportPort", "destinationTransportPort":
+			ieWithValue.Value = uint16(0)
+		case "protocolIdentifier":
+			ieWithValue.Value = uint8(0)
+		}
+	}
+}
+
+// setOverflowTotalStats sets the total stats of the first record of a
+// catch-all flow to the matching delta stats.
+func (a *AggregationProcess) setOverflowTotalStats(record entities.Record) {
+	if a.aggregateElements == nil {
+		return
+	}
+	for _, element := range a.aggregateElements.StatsElements {
+		if strings.Contains(element, "Delta") {
+			continue
+		}
+		ieWithValue, exist := record.GetInfoElementWithValue(element)
+		if !exist {
+			continue
+		}
+		if deltaIeWithValue, exist := record.GetInfoElementWithValue(strings.Replace(element, "Total", "Delta", 1)); exist {
+			ieWithValue.Value = deltaIeWithValue.Value
+		}
+	}
+}
+
+// isCardinalityOverflowRecord returns true if the record is the catch-all
+// record of the flows exceeding a cardinality limit.
+func isCardinalityOverflowRecord(record entities.Record) bool {
+	ieWithValue, exist := record.GetInfoElementWithValue("flowAggregationStatus")
+	return exist && ieWithValue.Value == registry.FlowAggregationStatusCardinalityOverflow
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// createNamespacedRecord returns an intra-node record from the namespace, with
+// a total of 1000 packets and a delta of 500 packets.
+func createNamespacedRecord(t *testing.T, namespace string, srcPort uint16) entities.Record {
+	record := createDataMsgForSrc(t, false, true, true, false, false).GetSet().GetRecords()[0]
+	element, err := registry.GetInfoElement("sourcePodNamespace", registry.AntreaEnterpriseID)
+	assert.NoError(t, err)
+	_, err = record.AddInfoElement(entities.NewInfoElementWithValue(element, bytes.NewBufferString(namespace)), true)
+	assert.NoError(t, err)
+	ieWithValue, _ := record.GetInfoElementWithValue("sourceTransportPort")
+	ieWithValue.Value = srcPort
+	return record
+}
+
+func TestCardinalityLimits(t *testing.T) {
+	messageChan := make(chan *entities.Message)
+	input := AggregationInput{
+		MessageChan:     messageChan,
+		WorkerNum:       2,
+		CorrelateFields: fields,
+		AggregateElements: &AggregationElements{
+			NonStatsElements:                   nonStatsElementList,
+			StatsElements:                      statsElementList,
+			AggregatedSourceStatsElements:      antreaSourceStatsElementList,
+			AggregatedDestinationStatsElements: antreaDestinationStatsElementList,
+		},
+		ActiveExpiryTimeout:   testActiveExpiry,
+		InactiveExpiryTimeout: testInactiveExpiry,
+		CardinalityLimits:     &CardinalityLimits{MaxFlowsPerNamespace: 2},
+	}
+	ap, err := InitAggregationProcess(input)
+	assert.NoError(t, err)
+	addRecord := func(namespace string, srcPort uint16) {
+		record := createNamespacedRecord(t, namespace, srcPort)
+		flowKey, err := getFlowKeyFromRecord(record)
+		assert.NoError(t, err)
+		assert.NoError(t, ap.addOrUpdateRecordInMap(flowKey, record))
+	}
+	for port := uint16(1); port <= 4; port++ {
+		addRecord("ns1", port)
+	}
+	// Updates of the admitted flows and flows of other namespaces are not
+	// limited.
+	addRecord("ns1", 1)
+	addRecord("ns2", 10)
+	assert.Equal(t, 4, len(ap.flowKeyRecordMap))
+	assert.Equal(t, CardinalityStats{
+		FlowsPerNamespace: map[string]int{"ns1": 2, "ns2": 1},
+		FlowsPerNode:      map[string]int{},
+		OverflowRecords:   2,
+	}, ap.GetCardinalityStats())
+
+	overflowRecord, exist := ap.flowKeyRecordMap[aggregationKey{ExtraKey: "cardinalityOverflow,sourcePodNamespace=ns1"}]
+	assert.True(t, exist)
+	assert.True(t, overflowRecord.ReadyToSend)
+	record := overflowRecord.Record
+	assert.Equal(t, registry.FlowAggregationStatusCardinalityOverflow, getValue(record, "flowAggregationStatus"))
+	assert.Equal(t, net.IP{0, 0, 0, 0}, getValue(record, "sourceIPv4Address"))
+	assert.Equal(t, uint16(0), getValue(record, "sourceTransportPort"))
+	assert.Equal(t, "ns1", getValue(record, "sourcePodNamespace"))
+	// The stats are the sums of the deltas of the two excess flows.
+	assert.Equal(t, uint64(1000), getValue(record, "packetDeltaCount"))
+	assert.Equal(t, uint64(1000), getValue(record, "packetTotalCount"))
+	assert.Equal(t, uint64(1000), getValue(record, "packetTotalCountFromSourceNode"))
+	assert.Equal(t, uint64(1000), getValue(record, "packetDeltaCountFromDestinationNode"))
+
+	// Flows are admitted again once the flows of the namespace are deleted.
+	time.Sleep(testInactiveExpiry)
+	assert.NoError(t, ap.ForAllExpiredFlowRecordsDo(func(key FlowKey, record AggregationFlowRecord) error {
+		return nil
+	}))
+	assert.Empty(t, ap.flowKeyRecordMap)
+	assert.Empty(t, ap.GetCardinalityStats().FlowsPerNamespace)
+	addRecord("ns1", 5)
+	_, exist = ap.flowKeyRecordMap[aggregationKey{FlowKey: FlowKey{"10.0.0.1", "10.0.0.2", 6, 5, 5678}}]
+	assert.True(t, exist)
+}
@@ -0,0 +1,301 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+const defaultReloadInterval = 30 * time.Second
+
+// cidrTrieNode is a node of a binary trie of CIDRs, in which the children of
+// a node are the prefixes one bit longer. Looking up an address takes at most
+// one step per bit of the address, whatever the number of CIDRs.
+type cidrTrieNode struct {
+	children [2]*cidrTrieNode
+	// group is the group of the CIDR ending at this node, if hasGroup is true.
+	group    string
+	hasGroup bool
+}
+
+// cidrTable contains one trie for IPv4 CIDRs and one for IPv6 CIDRs.
+type cidrTable struct {
+	ipv4 cidrTrieNode
+	ipv6 cidrTrieNode
+	size int
+}
+
+// insert adds the CIDR to the table. If the CIDR is already in the table, the
+// first group is kept. IPv4-mapped IPv6 CIDRs are added as IPv4 CIDRs, as
+// addresses are looked up in the IPv4 trie.
+func (t *cidrTable) insert(ipNet *net.IPNet, group string) {
+	node := &t.ipv6
+	ip := ipNet.IP.To16()
+	ones, bits := ipNet.Mask.Size()
+	if ip4 := ipNet.IP.To4(); ip4 != nil {
+		if bits == net.IPv4len*8 {
+			node, ip = &t.ipv4, ip4
+		} else if ones >= (net.IPv6len-net.IPv4len)*8 {
+			node, ip = &t.ipv4, ip4
+			ones -= (net.IPv6len - net.IPv4len) * 8
+		}
+	}
+	for i := 0; i < ones; i++ {
+		bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &cidrTrieNode{}
+		}
+		node = node.children[bit]
+	}
+	if node.hasGroup {
+		klog.V(2).Infof("Ignoring duplicate CIDR %s of group %s; it is in group %s", ipNet, group, node.group)
+		return
+	}
+	node.group, node.hasGroup = group, true
+	t.size++
+}
+
+// lookup returns the group of the most specific CIDR containing the address.
+func (t *cidrTable) lookup(ip net.IP) (string, bool) {
+	node := &t.ipv6
+	if ip4 := ip.To4(); ip4 != nil {
+		node, ip = &t.ipv4, ip4
+	} else if ip = ip.To16(); ip == nil {
+		return "", false
+	}
+	group, found := node.group, node.hasGroup
+	for i := 0; i < len(ip)*8; i++ {
+		node = node.children[(ip[i/8]>>(7-uint(i%8)))&1]
+		if node == nil {
+			break
+		}
+		if node.hasGroup {
+			group, found = node.group, true
+		}
+	}
+	return group, found
+}
+
+// CIDRClassifier maps IP addresses to user-defined groups (e.g. "corp",
+// "internet" or "partner-X") and adds the groups of the source and destination
+// addresses to records as sourceGroupName and destinationGroupName.
+//
+// The groups are loaded from a CSV file with one "CIDR,group" entry per line;
+// lines starting with # are ignored. The most specific CIDR containing an
+// address gives its group. The file is reloaded when it is modified.
+type CIDRClassifier struct {
+	path           string
+	defaultGroup   string
+	reloadInterval time.Duration
+	// mutex protects the table and the modification time of the file.
+	mutex   sync.RWMutex
+	table   *cidrTable
+	modTime time.Time
+	// stopChan is the channel to receive stop message
+	stopChan chan bool
+}
+
+type CIDRClassifierInput struct {
+	// Path is the path of the CSV file with the CIDR table.
+	Path string
+	// DefaultGroup is the group of addresses which are not in any CIDR. It
+	// can be empty.
+	DefaultGroup string
+	// ReloadInterval is the interval to check the file for modifications. If
+	// 0, it is 30s.
+	ReloadInterval time.Duration
+}
+
+func InitCIDRClassifier(input CIDRClassifierInput) (*CIDRClassifier, error) {
+	if input.ReloadInterval == 0 {
+		input.ReloadInterval = defaultReloadInterval
+	}
+	c := &CIDRClassifier{
+		path:           input.Path,
+		defaultGroup:   input.DefaultGroup,
+		reloadInterval: input.ReloadInterval,
+		stopChan:       make(chan bool),
+	}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Start checks the file for modifications until Stop is called. If the
+// modified file cannot be loaded, the error is logged and the previous table
+// is kept.
+func (c *CIDRClassifier) Start() {
+	ticker := time.NewTicker(c.reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stopChan:
+			return
+		case <-ticker.C:
+			if err := c.reloadIfModified(); err != nil {
+				klog.Errorf("Error when reloading CIDR table from %s: %v", c.path, err)
+			}
+		}
+	}
+}
+
+func (c *CIDRClassifier) Stop() {
+	c.stopChan <- true
+}
+
+// Reload loads the CIDR table from the file.
+func (c *CIDRClassifier) Reload() error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return err
+	}
+	return c.load(info.ModTime())
+}
+
+func (c *CIDRClassifier) reloadIfModified() error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return err
+	}
+	c.mutex.RLock()
+	modTime := c.modTime
+	c.mutex.RUnlock()
+	if info.ModTime().Equal(modTime) {
+		return nil
+	}
+	return c.load(info.ModTime())
+}
+
+func (c *CIDRClassifier) load(modTime time.Time) error {
+	file, err := os.Open(c.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	table, err := parseCIDRTable(file)
+	if err != nil {
+		return fmt.Errorf("error when parsing CIDR table from %s: %v", c.path, err)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.table = table
+	c.modTime = modTime
+	klog.V(2).Infof("Loaded %d CIDRs from %s", table.size, c.path)
+	return nil
+}
+
+func parseCIDRTable(r io.Reader) (*cidrTable, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	lines, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	table := &cidrTable{}
+	for _, line := range lines {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(line[0]))
+		if err != nil {
+			return nil, err
+		}
+		table.insert(ipNet, strings.TrimSpace(line[1]))
+	}
+	return table, nil
+}
+
+// GetGroup returns the group of the address.
+func (c *CIDRClassifier) GetGroup(ip net.IP) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if ip != nil {
+		if group, found := c.table.lookup(ip); found {
+			return group
+		}
+	}
+	return c.defaultGroup
+}
+
+// EnrichMessage adds sourceGroupName and destinationGroupName to every record
+// in the message. Template records get the elements without values. If the
+// elements are already present in a data record, their values are updated.
+func (c *CIDRClassifier) EnrichMessage(message *entities.Message) error {
+	set := message.GetSet()
+	for _, record := range set.GetRecords() {
+		var err error
+		if set.GetSetType() == entities.Data {
+			err = c.EnrichRecord(record)
+		} else {
+			err = addGroupNameFields(record)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnrichRecord sets the sourceGroupName and destinationGroupName of the data
+// record, adding the elements if needed.
+func (c *CIDRClassifier) EnrichRecord(record entities.Record) error {
+	srcIP, dstIP := getIPAddresses(record)
+	if err := setGroupName(record, "sourceGroupName", c.GetGroup(srcIP)); err != nil {
+		return err
+	}
+	return setGroupName(record, "destinationGroupName", c.GetGroup(dstIP))
+}
+
+func setGroupName(record entities.Record, name string, group string) error {
+	if ieWithValue, exist := record.GetInfoElementWithValue(name); exist {
+		ieWithValue.Value = group
+		return nil
+	}
+	ie, err := registry.GetInfoElement(name, registry.AntreaEnterpriseID)
+	if err != nil {
+		return err
+	}
+	_, err = record.AddInfoElement(entities.NewInfoElementWithValue(ie, bytes.NewBufferString(group)), true)
+	return err
+}
+
+func addGroupNameFields(record entities.Record) error {
+	for _, name := range []string{"sourceGroupName", "destinationGroupName"} {
+		if _, exist := record.GetInfoElementWithValue(name); exist {
+			continue
+		}
+		ie, err := registry.GetInfoElement(name, registry.AntreaEnterpriseID)
+		if err != nil {
+			return err
+		}
+		if _, err = record.AddInfoElement(entities.NewInfoElementWithValue(ie, nil), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
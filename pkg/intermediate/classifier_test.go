@@ -0,0 +1,149 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testCIDRTable = `# CIDR,group
+10.0.0.0/8,corp
+10.1.0.0/16, partner-X
+2001:db8::/32,corp
+`
+
+func writeCIDRTable(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "cidr-table")
+	assert.NoError(t, err)
+	_, err = file.WriteString(content)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	return file.Name()
+}
+
+func TestCIDRClassifier_GetGroup(t *testing.T) {
+	path := writeCIDRTable(t, testCIDRTable)
+	defer os.Remove(path)
+	c, err := InitCIDRClassifier(CIDRClassifierInput{Path: path, DefaultGroup: "internet"})
+	assert.NoError(t, err)
+	assert.Equal(t, "corp", c.GetGroup(net.ParseIP("10.2.0.1")))
+	assert.Equal(t, "partner-X", c.GetGroup(net.ParseIP("10.1.0.1")))
+	assert.Equal(t, "corp", c.GetGroup(net.ParseIP("2001:db8::1")))
+	assert.Equal(t, "internet", c.GetGroup(net.ParseIP("8.8.8.8")))
+	assert.Equal(t, "internet", c.GetGroup(nil))
+
+	// The table is reloaded when the file is modified.
+	assert.NoError(t, ioutil.WriteFile(path, []byte("10.0.0.0/8,lab\n"), 0644))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	assert.NoError(t, c.reloadIfModified())
+	assert.Equal(t, "lab", c.GetGroup(net.ParseIP("10.1.0.1")))
+
+	// Invalid tables are not loaded.
+	assert.NoError(t, ioutil.WriteFile(path, []byte("10.0.0.0,lab\n"), 0644))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Minute)))
+	assert.Error(t, c.reloadIfModified())
+	assert.Equal(t, "lab", c.GetGroup(net.ParseIP("10.1.0.1")))
+
+	_, err = InitCIDRClassifier(CIDRClassifierInput{Path: path + "-missing"})
+	assert.Error(t, err)
+}
+
+func TestCIDRTable(t *testing.T) {
+	table, err := parseCIDRTable(strings.NewReader(`0.0.0.0/0,any
+192.168.0.0/16,lan
+192.168.1.0/24,office
+192.168.1.1/32,gateway
+192.168.1.0/24,duplicate
+2001:db8::/32,corp
+::ffff:10.0.0.0/104,mapped
+`))
+	assert.NoError(t, err)
+	// The duplicate CIDR is not counted.
+	assert.Equal(t, 6, table.size)
+	for ip, expected := range map[string]string{
+		"8.8.8.8":          "any",
+		"192.168.2.1":      "lan",
+		"192.168.1.2":      "office",
+		"192.168.1.1":      "gateway",
+		"::ffff:192.0.2.1": "any",
+		"2001:db8::1":      "corp",
+		"10.1.2.3":         "mapped",
+		"::ffff:10.1.2.3":  "mapped",
+	} {
+		group, found := table.lookup(net.ParseIP(ip))
+		assert.True(t, found, ip)
+		assert.Equal(t, expected, group, ip)
+	}
+	_, found := table.lookup(net.ParseIP("2001:db9::1"))
+	assert.False(t, found)
+}
+
+func BenchmarkCIDRClassifier_GetGroup(b *testing.B) {
+	// 10000 /24 CIDRs in 10.0.0.0/8, and a few less specific ones.
+	var content strings.Builder
+	content.WriteString("10.0.0.0/8,corp\n172.16.0.0/12,partner\n")
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&content, "10.%d.%d.0/24,group-%d\n", i/256, i%256, i)
+	}
+	file, err := ioutil.TempFile("", "cidr-table")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(content.String())
+	file.Close()
+	c, err := InitCIDRClassifier(CIDRClassifierInput{Path: file.Name(), DefaultGroup: "internet"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	ips := []net.IP{net.ParseIP("10.39.15.1"), net.ParseIP("172.16.0.1"), net.ParseIP("8.8.8.8"), net.ParseIP("2001:db8::1")}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.GetGroup(ips[i%len(ips)])
+	}
+}
+
+func TestCIDRClassifier_EnrichMessage(t *testing.T) {
+	path := writeCIDRTable(t, testCIDRTable)
+	defer os.Remove(path)
+	c, err := InitCIDRClassifier(CIDRClassifierInput{Path: path, DefaultGroup: "internet"})
+	assert.NoError(t, err)
+
+	message := createMsgwithTemplateSet(false)
+	assert.NoError(t, c.EnrichMessage(message))
+	ieWithValue, exist := message.GetSet().GetRecords()[0].GetInfoElementWithValue("sourceGroupName")
+	assert.True(t, exist)
+	assert.Nil(t, ieWithValue.Value)
+
+	message = createDataMsgForSrc(t, false, false, false, false, false)
+	assert.NoError(t, c.EnrichMessage(message))
+	record := message.GetSet().GetRecords()[0]
+	assert.Equal(t, "corp", getValue(record, "sourceGroupName"))
+	assert.Equal(t, "corp", getValue(record, "destinationGroupName"))
+
+	record = createBiflowRecord(t, "10.1.0.1", "8.8.8.8", 1234, 53, 0, 0)
+	assert.NoError(t, c.EnrichRecord(record))
+	assert.NoError(t, c.EnrichRecord(record))
+	assert.Equal(t, "partner-X", getValue(record, "sourceGroupName"))
+	assert.Equal(t, "internet", getValue(record, "destinationGroupName"))
+}
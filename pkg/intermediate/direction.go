@@ -0,0 +1,184 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// DirectionPolicy decides which endpoint of a biflow is the initiator, which is
+// exported as the source after normalization.
+type DirectionPolicy uint8
+
+const (
+	// DirectionByPort takes the endpoint with the lower transport port as the
+	// initiator. If the ports are equal, the endpoint with the lower address is
+	// taken.
+	DirectionByPort DirectionPolicy = iota
+	// DirectionByTCPFlags takes the endpoint which sent the SYN without ACK of
+	// the TCP handshake as the initiator, using the tcpControlBits and
+	// reverseTcpControlBits elements. Records in which the handshake is not
+	// visible are normalized with DirectionByPort.
+	DirectionByTCPFlags
+)
+
+const (
+	tcpFlagSYN = uint16(0x02)
+	tcpFlagACK = uint16(0x10)
+)
+
+// DirectionNormalizer canonicalizes the direction of biflow records, so that
+// both orderings of the same conversation are exported the same way. When a
+// record is reversed, the values of source and destination elements are
+// swapped (e.g. sourceIPv4Address and destinationIPv4Address, or
+// packetTotalCountFromSourceNode and packetTotalCountFromDestinationNode), and
+// then the values of forward and reverse elements are swapped (e.g.
+// packetTotalCount and reversePacketTotalCount, or
+// packetTotalCountFromSourceNode and reversePacketTotalCountFromSourceNode).
+//
+// Only the values of the elements are updated, so it is meant for decoded
+// records, e.g. from the collecting process.
+type DirectionNormalizer struct {
+	policy DirectionPolicy
+}
+
+func NewDirectionNormalizer(policy DirectionPolicy) *DirectionNormalizer {
+	return &DirectionNormalizer{policy}
+}
+
+// NormalizeMessage normalizes every data record in the message.
+func (n *DirectionNormalizer) NormalizeMessage(message *entities.Message) error {
+	set := message.GetSet()
+	if set.GetSetType() != entities.Data {
+		return nil
+	}
+	for _, record := range set.GetRecords() {
+		if _, err := n.NormalizeRecord(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NormalizeRecord reverses the record if its source is not the initiator, and
+// returns whether the record was reversed.
+func (n *DirectionNormalizer) NormalizeRecord(record entities.Record) (bool, error) {
+	isReversed, err := n.isReversed(record)
+	if err != nil || !isReversed {
+		return false, err
+	}
+	reverseRecord(record)
+	return true, nil
+}
+
+func (n *DirectionNormalizer) isReversed(record entities.Record) (bool, error) {
+	if n.policy == DirectionByTCPFlags {
+		if isReversed, ok := isReversedByTCPFlags(record); ok {
+			return isReversed, nil
+		}
+	}
+	return isReversedByPort(record)
+}
+
+// isReversedByTCPFlags returns false if the handshake is not visible in the
+// record.
+func isReversedByTCPFlags(record entities.Record) (bool, bool) {
+	isInitiator := func(name string) bool {
+		ieWithValue, exist := record.GetInfoElementWithValue(name)
+		if !exist {
+			return false
+		}
+		flags, ok := ieWithValue.Value.(uint16)
+		return ok && flags&tcpFlagSYN != 0 && flags&tcpFlagACK == 0
+	}
+	if isInitiator("tcpControlBits") {
+		return false, true
+	}
+	if isInitiator("reverseTcpControlBits") {
+		return true, true
+	}
+	return false, false
+}
+
+func isReversedByPort(record entities.Record) (bool, error) {
+	srcPort, exist := record.GetInfoElementWithValue("sourceTransportPort")
+	if !exist {
+		return false, fmt.Errorf("sourceTransportPort does not exist")
+	}
+	dstPort, exist := record.GetInfoElementWithValue("destinationTransportPort")
+	if !exist {
+		return false, fmt.Errorf("destinationTransportPort does not exist")
+	}
+	src, ok := srcPort.Value.(uint16)
+	if !ok {
+		return false, fmt.Errorf("sourceTransportPort is not in correct format")
+	}
+	dst, ok := dstPort.Value.(uint16)
+	if !ok {
+		return false, fmt.Errorf("destinationTransportPort is not in correct format")
+	}
+	if src != dst {
+		return src > dst, nil
+	}
+	srcIP, dstIP := getIPAddresses(record)
+	return bytes.Compare(srcIP.To16(), dstIP.To16()) > 0, nil
+}
+
+func getIPAddresses(record entities.Record) (net.IP, net.IP) {
+	var srcIP, dstIP net.IP
+	for _, version := range []string{"IPv4", "IPv6"} {
+		if ieWithValue, exist := record.GetInfoElementWithValue("source" + version + "Address"); exist && srcIP == nil {
+			srcIP, _ = ieWithValue.Value.(net.IP)
+		}
+		if ieWithValue, exist := record.GetInfoElementWithValue("destination" + version + "Address"); exist && dstIP == nil {
+			dstIP, _ = ieWithValue.Value.(net.IP)
+		}
+	}
+	return srcIP, dstIP
+}
+
+// reverseRecord swaps the values of source and destination elements, and then
+// the values of forward and reverse elements.
+func reverseRecord(record entities.Record) {
+	elements := record.GetOrderedElementList()
+	for _, ieWithValue := range elements {
+		name := ieWithValue.Element.Name
+		var counterpart string
+		if strings.HasPrefix(name, "source") {
+			counterpart = strings.Replace(name, "source", "destination", 1)
+		} else if strings.Contains(name, "Source") {
+			counterpart = strings.Replace(name, "Source", "Destination", 1)
+		} else {
+			continue
+		}
+		swapValues(record, ieWithValue, counterpart)
+	}
+	for _, ieWithValue := range elements {
+		swapValues(record, ieWithValue, entities.ReverseInfoElementName(ieWithValue.Element.Name))
+	}
+}
+
+func swapValues(record entities.Record, ieWithValue *entities.InfoElementWithValue, counterpart string) {
+	counterpartIE, exist := record.GetInfoElementWithValue(counterpart)
+	if !exist || counterpartIE.Element.DataType != ieWithValue.Element.DataType {
+		return
+	}
+	ieWithValue.Value, counterpartIE.Value = counterpartIE.Value, ieWithValue.Value
+}
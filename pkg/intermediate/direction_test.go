@@ -0,0 +1,116 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func createBiflowRecord(t *testing.T, srcIP, dstIP string, srcPort, dstPort uint16, tcpFlags, reverseTCPFlags uint16) entities.Record {
+	record := entities.NewDataRecord(testTemplateID)
+	values := []struct {
+		name         string
+		enterpriseID uint32
+		value        interface{}
+	}{
+		{"sourceIPv4Address", registry.IANAEnterpriseID, net.ParseIP(srcIP)},
+		{"destinationIPv4Address", registry.IANAEnterpriseID, net.ParseIP(dstIP)},
+		{"sourceTransportPort", registry.IANAEnterpriseID, srcPort},
+		{"destinationTransportPort", registry.IANAEnterpriseID, dstPort},
+		{"tcpControlBits", registry.IANAEnterpriseID, tcpFlags},
+		{"reverseTcpControlBits", registry.IANAReversedEnterpriseID, reverseTCPFlags},
+		{"packetTotalCount", registry.IANAEnterpriseID, uint64(10)},
+		{"reversePacketTotalCount", registry.IANAReversedEnterpriseID, uint64(20)},
+		{"packetTotalCountFromSourceNode", registry.AntreaEnterpriseID, uint64(1)},
+		{"packetTotalCountFromDestinationNode", registry.AntreaEnterpriseID, uint64(2)},
+		{"reversePacketTotalCountFromSourceNode", registry.AntreaEnterpriseID, uint64(3)},
+		{"reversePacketTotalCountFromDestinationNode", registry.AntreaEnterpriseID, uint64(4)},
+	}
+	for _, v := range values {
+		element, err := registry.GetInfoElement(v.name, v.enterpriseID)
+		assert.NoError(t, err)
+		_, err = record.AddInfoElement(entities.NewInfoElementWithValue(element, v.value), false)
+		assert.NoError(t, err)
+	}
+	return record
+}
+
+func getValue(record entities.Record, name string) interface{} {
+	ieWithValue, _ := record.GetInfoElementWithValue(name)
+	return ieWithValue.Value
+}
+
+func TestDirectionNormalizer_ByPort(t *testing.T) {
+	normalizer := NewDirectionNormalizer(DirectionByPort)
+	record := createBiflowRecord(t, "10.0.0.2", "10.0.0.1", 5678, 80, 0, 0)
+	isReversed, err := normalizer.NormalizeRecord(record)
+	assert.NoError(t, err)
+	assert.True(t, isReversed)
+	assert.Equal(t, net.ParseIP("10.0.0.1").To4(), getValue(record, "sourceIPv4Address"))
+	assert.Equal(t, net.ParseIP("10.0.0.2").To4(), getValue(record, "destinationIPv4Address"))
+	assert.Equal(t, uint16(80), getValue(record, "sourceTransportPort"))
+	assert.Equal(t, uint16(5678), getValue(record, "destinationTransportPort"))
+	assert.Equal(t, uint64(20), getValue(record, "packetTotalCount"))
+	assert.Equal(t, uint64(10), getValue(record, "reversePacketTotalCount"))
+	// Forward packets of the new source node are the reverse packets counted
+	// at the old destination node.
+	assert.Equal(t, uint64(4), getValue(record, "packetTotalCountFromSourceNode"))
+	assert.Equal(t, uint64(3), getValue(record, "packetTotalCountFromDestinationNode"))
+	assert.Equal(t, uint64(2), getValue(record, "reversePacketTotalCountFromSourceNode"))
+	assert.Equal(t, uint64(1), getValue(record, "reversePacketTotalCountFromDestinationNode"))
+
+	// The normalized record is not reversed again.
+	isReversed, err = normalizer.NormalizeRecord(record)
+	assert.NoError(t, err)
+	assert.False(t, isReversed)
+
+	// Equal ports are ordered by address.
+	record = createBiflowRecord(t, "10.0.0.2", "10.0.0.1", 4739, 4739, 0, 0)
+	isReversed, err = normalizer.NormalizeRecord(record)
+	assert.NoError(t, err)
+	assert.True(t, isReversed)
+	assert.Equal(t, net.ParseIP("10.0.0.1").To4(), getValue(record, "sourceIPv4Address"))
+
+	_, err = normalizer.NormalizeRecord(entities.NewDataRecord(testTemplateID))
+	assert.Error(t, err)
+}
+
+func TestDirectionNormalizer_ByTCPFlags(t *testing.T) {
+	normalizer := NewDirectionNormalizer(DirectionByTCPFlags)
+	// The source sent the SYN, so it is the initiator even with the higher port.
+	record := createBiflowRecord(t, "10.0.0.2", "10.0.0.1", 5678, 80, tcpFlagSYN, tcpFlagSYN|tcpFlagACK)
+	isReversed, err := normalizer.NormalizeRecord(record)
+	assert.NoError(t, err)
+	assert.False(t, isReversed)
+
+	record = createBiflowRecord(t, "10.0.0.1", "10.0.0.2", 80, 5678, tcpFlagSYN|tcpFlagACK, tcpFlagSYN)
+	isReversed, err = normalizer.NormalizeRecord(record)
+	assert.NoError(t, err)
+	assert.True(t, isReversed)
+	assert.Equal(t, net.ParseIP("10.0.0.2").To4(), getValue(record, "sourceIPv4Address"))
+	assert.Equal(t, tcpFlagSYN, getValue(record, "tcpControlBits"))
+
+	// Without the handshake, the port is used.
+	record = createBiflowRecord(t, "10.0.0.2", "10.0.0.1", 5678, 80, tcpFlagACK, tcpFlagACK)
+	isReversed, err = normalizer.NormalizeRecord(record)
+	assert.NoError(t, err)
+	assert.True(t, isReversed)
+}
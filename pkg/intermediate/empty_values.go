@@ -0,0 +1,108 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// EmptyValueKind defines when a correlate field, e.g. destinationClusterIPv4
+// or destinationServicePort, is considered empty in a record. When correlating
+// the records of a flow, the field of the existing record is filled with the
+// value of the peer node record if it is not empty there.
+type EmptyValueKind uint8
+
+const (
+	// EmptyIfZero considers the field empty if it has the zero value of its
+	// data type, e.g. 0.0.0.0, 0 or "".
+	EmptyIfZero EmptyValueKind = iota
+	// EmptyIfAbsent considers the field empty only if it is not in the record,
+	// for exporters using zero values, e.g. 0.0.0.0/0, as meaningful values
+	// and leaving the field out of the records without one.
+	EmptyIfAbsent
+	// EmptyIfSentinel considers the field empty if it has the sentinel value
+	// of the field, e.g. 255.255.255.255, or if it is not in the record.
+	EmptyIfSentinel
+)
+
+// EmptyValue defines when a correlate field is considered empty.
+type EmptyValue struct {
+	Kind EmptyValueKind
+	// Sentinel is the value of the empty field if Kind is EmptyIfSentinel, as
+	// formatted with fmt, e.g. "255.255.255.255" or "65535".
+	Sentinel string
+}
+
+func validateEmptyValues(emptyValues map[string]EmptyValue, correlateFields []string) error {
+	for field, emptyValue := range emptyValues {
+		isCorrelateField := false
+		for _, correlateField := range correlateFields {
+			if correlateField == field {
+				isCorrelateField = true
+				break
+			}
+		}
+		if !isCorrelateField {
+			return fmt.Errorf("empty value is given for %s, which is not a correlate field", field)
+		}
+		if emptyValue.Kind > EmptyIfSentinel {
+			return fmt.Errorf("empty value of %s has unknown kind %d", field, emptyValue.Kind)
+		}
+		if emptyValue.Kind == EmptyIfSentinel && emptyValue.Sentinel == "" {
+			return fmt.Errorf("empty value of %s has no sentinel", field)
+		}
+	}
+	return nil
+}
+
+// isEmptyField returns whether the correlate field of the record is empty, or
+// an error if its data type is not supported in correlate fields.
+func (a *AggregationProcess) isEmptyField(record entities.Record, field string) (bool, error) {
+	ieWithValue, exist := record.GetInfoElementWithValue(field)
+	if !exist {
+		return true, nil
+	}
+	var isZero bool
+	switch ieWithValue.Element.DataType {
+	case entities.String:
+		isZero = ieWithValue.Value == ""
+	case entities.Unsigned8:
+		isZero = ieWithValue.Value == uint8(0)
+	case entities.Unsigned16:
+		isZero = ieWithValue.Value == uint16(0)
+	case entities.Signed32:
+		isZero = ieWithValue.Value == int32(0)
+	case entities.Ipv4Address:
+		isZero = ieWithValue.Value.(net.IP).To4().String() == "0.0.0.0"
+	case entities.Ipv6Address:
+		isZero = ieWithValue.Value.(net.IP).To16().String() == net.ParseIP("::0").To16().String()
+	case entities.MacAddress:
+		isZero = ieWithValue.Value.(net.HardwareAddr).String() == "00:00:00:00:00:00"
+	default:
+		return false, fmt.Errorf("fields with dataType %v is not supported in correlation fields list", ieWithValue.Element.DataType)
+	}
+	emptyValue := a.correlateEmptyValues[field]
+	switch emptyValue.Kind {
+	case EmptyIfAbsent:
+		return false, nil
+	case EmptyIfSentinel:
+		return fmt.Sprint(ieWithValue.Value) == emptyValue.Sentinel, nil
+	default:
+		return isZero, nil
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+func TestCorrelateEmptyValues(t *testing.T) {
+	for _, emptyValues := range []map[string]EmptyValue{
+		{"sourceIPv4Address": {Kind: EmptyIfAbsent}},
+		{"destinationServicePort": {Kind: EmptyIfSentinel}},
+		{"destinationServicePort": {Kind: EmptyIfSentinel + 1}},
+	} {
+		_, err := InitAggregationProcess(AggregationInput{
+			MessageChan:          make(chan *entities.Message),
+			WorkerNum:            1,
+			CorrelateFields:      fields,
+			CorrelateEmptyValues: emptyValues,
+		})
+		assert.Error(t, err)
+	}
+
+	// The source node record has destinationClusterIPv4 192.168.0.1 and
+	// destinationServicePort 4739, and the destination node record has
+	// 0.0.0.0 and 0.
+	ap, err := InitAggregationProcess(AggregationInput{
+		MessageChan:     make(chan *entities.Message),
+		WorkerNum:       1,
+		CorrelateFields: fields,
+	})
+	assert.NoError(t, err)
+	srcRecord := createDataMsgForSrc(t, false, false, false, false, false).GetSet().GetRecords()[0]
+	dstRecord := createDataMsgForDst(t, false, false, false, false, false).GetSet().GetRecords()[0]
+	// Zero values are empty by default.
+	ap.correlateRecords(dstRecord, srcRecord)
+	ieWithValue, _ := srcRecord.GetInfoElementWithValue("destinationClusterIPv4")
+	assert.Equal(t, net.ParseIP("192.168.0.1").To4(), ieWithValue.Value)
+	ieWithValue, _ = srcRecord.GetInfoElementWithValue("destinationServicePort")
+	assert.Equal(t, uint16(4739), ieWithValue.Value)
+
+	ap, err = InitAggregationProcess(AggregationInput{
+		MessageChan:     make(chan *entities.Message),
+		WorkerNum:       1,
+		CorrelateFields: fields,
+		CorrelateEmptyValues: map[string]EmptyValue{
+			"destinationClusterIPv4": {Kind: EmptyIfAbsent},
+			"destinationServicePort": {Kind: EmptyIfSentinel, Sentinel: "4739"},
+		},
+	})
+	assert.NoError(t, err)
+	srcRecord = createDataMsgForSrc(t, false, false, false, false, false).GetSet().GetRecords()[0]
+	dstRecord = createDataMsgForDst(t, false, false, false, false, false).GetSet().GetRecords()[0]
+	// 0.0.0.0 is a value of destinationClusterIPv4, and 4739 is the empty
+	// value of destinationServicePort.
+	ap.correlateRecords(srcRecord, dstRecord)
+	ieWithValue, _ = dstRecord.GetInfoElementWithValue("destinationClusterIPv4")
+	assert.Equal(t, net.ParseIP("192.168.0.1").To4(), ieWithValue.Value)
+	ieWithValue, _ = dstRecord.GetInfoElementWithValue("destinationServicePort")
+	assert.Equal(t, uint16(0), ieWithValue.Value)
+	srcRecord = createDataMsgForSrc(t, false, false, false, false, false).GetSet().GetRecords()[0]
+	dstRecord = createDataMsgForDst(t, false, false, false, false, false).GetSet().GetRecords()[0]
+	ap.correlateRecords(dstRecord, srcRecord)
+	ieWithValue, _ = srcRecord.GetInfoElementWithValue("destinationClusterIPv4")
+	assert.Equal(t, net.ParseIP("0.0.0.0").To4(), ieWithValue.Value)
+	ieWithValue, _ = srcRecord.GetInfoElementWithValue("destinationServicePort")
+	assert.Equal(t, uint16(0), ieWithValue.Value)
+}
@@ -0,0 +1,93 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"encoding/json"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// LabelMergePolicy defines how the values of a label element, i.e. a string
+// element such as sourcePodLabels or a custom tag, are combined when the
+// records of a flow are aggregated or correlated.
+type LabelMergePolicy uint8
+
+const (
+	// LabelMergeNonEmpty keeps the value of the latest record in which the
+	// element is not empty, so that records without the label, e.g. records
+	// from the peer node, do not blank it.
+	LabelMergeNonEmpty LabelMergePolicy = iota
+	// LabelMergeJSON parses the values as JSON objects, e.g. Pod labels
+	// {"app":"web"}, and merges their keys. The values of the latest record
+	// win for the keys present in both. Values which are not JSON objects are
+	// combined as with LabelMergeNonEmpty.
+	LabelMergeJSON
+)
+
+// mergeLabelElements merges the label elements of the incoming record into the
+// existing record.
+func (a *AggregationProcess) mergeLabelElements(incomingRecord, existingRecord entities.Record) {
+	for element, policy := range a.labelElements {
+		ieWithValue, exist := incomingRecord.GetInfoElementWithValue(element)
+		if !exist {
+			continue
+		}
+		existingIeWithValue, exist := existingRecord.GetInfoElementWithValue(element)
+		if !exist {
+			continue
+		}
+		incomingValue, ok := ieWithValue.Value.(string)
+		if !ok {
+			continue
+		}
+		existingValue, ok := existingIeWithValue.Value.(string)
+		if !ok {
+			continue
+		}
+		existingIeWithValue.Value = mergeLabels(existingValue, incomingValue, policy)
+	}
+}
+
+// mergeLabels returns the value of a label element after merging the incoming
+// value into the existing one.
+func mergeLabels(existingValue, incomingValue string, policy LabelMergePolicy) string {
+	if incomingValue == "" {
+		return existingValue
+	}
+	if existingValue == "" || policy != LabelMergeJSON {
+		return incomingValue
+	}
+	var existingLabels, incomingLabels map[string]interface{}
+	if err := json.Unmarshal([]byte(existingValue), &existingLabels); err != nil {
+		return incomingValue
+	}
+	if err := json.Unmarshal([]byte(incomingValue), &incomingLabels); err != nil {
+		return incomingValue
+	}
+	if existingLabels == nil {
+		existingLabels = make(map[string]interface{})
+	}
+	for key, value := range incomingLabels {
+		existingLabels[key] = value
+	}
+	// Keys are sorted when marshalling maps, so that the merged value does
+	// not depend on the order of the keys of the records.
+	merged, err := json.Marshal(existingLabels)
+	if err != nil {
+		return incomingValue
+	}
+	return string(merged)
+}
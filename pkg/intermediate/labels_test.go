@@ -0,0 +1,96 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestMergeLabels(t *testing.T) {
+	for _, tc := range []struct {
+		existing string
+		incoming string
+		policy   LabelMergePolicy
+		expected string
+	}{
+		{`{"app":"web"}`, "", LabelMergeNonEmpty, `{"app":"web"}`},
+		{"", `{"app":"web"}`, LabelMergeNonEmpty, `{"app":"web"}`},
+		{`{"app":"web"}`, `{"tier":"db"}`, LabelMergeNonEmpty, `{"tier":"db"}`},
+		{`{"app":"web"}`, "", LabelMergeJSON, `{"app":"web"}`},
+		{`{"tier":"frontend","app":"web"}`, `{"tier":"db","env":"prod"}`, LabelMergeJSON, `{"app":"web","env":"prod","tier":"db"}`},
+		{"team-a", `{"app":"web"}`, LabelMergeJSON, `{"app":"web"}`},
+		{`{"app":"web"}`, "team-a", LabelMergeJSON, "team-a"},
+	} {
+		assert.Equal(t, tc.expected, mergeLabels(tc.existing, tc.incoming, tc.policy), "existing: %s, incoming: %s", tc.existing, tc.incoming)
+	}
+}
+
+func addLabelElements(t *testing.T, record entities.Record, sourceLabels, destinationLabels string) {
+	for name, value := range map[string]string{"sourcePodLabels": sourceLabels, "destinationPodLabels": destinationLabels} {
+		element, err := registry.GetInfoElement(name, registry.AntreaEnterpriseID)
+		assert.NoError(t, err)
+		_, err = record.AddInfoElement(entities.NewInfoElementWithValue(element, bytes.NewBufferString(value)), true)
+		assert.NoError(t, err)
+	}
+}
+
+func TestLabelElements(t *testing.T) {
+	messageChan := make(chan *entities.Message)
+	input := AggregationInput{
+		MessageChan:     messageChan,
+		WorkerNum:       2,
+		CorrelateFields: append(fields, "destinationPodLabels"),
+		AggregateElements: &AggregationElements{
+			NonStatsElements:                   nonStatsElementList,
+			StatsElements:                      statsElementList,
+			AggregatedSourceStatsElements:      antreaSourceStatsElementList,
+			AggregatedDestinationStatsElements: antreaDestinationStatsElementList,
+		},
+		ActiveExpiryTimeout:   testActiveExpiry,
+		InactiveExpiryTimeout: testInactiveExpiry,
+		LabelElements: map[string]LabelMergePolicy{
+			"sourcePodLabels":      LabelMergeNonEmpty,
+			"destinationPodLabels": LabelMergeJSON,
+		},
+	}
+	ap, err := InitAggregationProcess(input)
+	assert.NoError(t, err)
+	srcRecord := createDataMsgForSrc(t, false, false, false, false, false).GetSet().GetRecords()[0]
+	addLabelElements(t, srcRecord, `{"app":"web"}`, `{"tier":"db"}`)
+	dstRecord := createDataMsgForDst(t, false, false, false, false, false).GetSet().GetRecords()[0]
+	addLabelElements(t, dstRecord, "", `{"app":"db","tier":"backend"}`)
+	flowKey, err := getFlowKeyFromRecord(srcRecord)
+	assert.NoError(t, err)
+	assert.NoError(t, ap.addOrUpdateRecordInMap(flowKey, srcRecord))
+	assert.NoError(t, ap.addOrUpdateRecordInMap(flowKey, dstRecord))
+
+	aggRecord := ap.flowKeyRecordMap[aggregationKey{FlowKey: *flowKey}]
+	assert.True(t, aggRecord.ReadyToSend)
+	assert.Equal(t, `{"app":"web"}`, getValue(aggRecord.Record, "sourcePodLabels"))
+	assert.Equal(t, `{"app":"db","tier":"backend"}`, getValue(aggRecord.Record, "destinationPodLabels"))
+
+	// An update from the destination node without labels keeps them.
+	dstRecord = createDataMsgForDst(t, false, false, true, false, false).GetSet().GetRecords()[0]
+	addLabelElements(t, dstRecord, "", "")
+	assert.NoError(t, ap.addOrUpdateRecordInMap(flowKey, dstRecord))
+	assert.Equal(t, `{"app":"web"}`, getValue(aggRecord.Record, "sourcePodLabels"))
+	assert.Equal(t, `{"app":"db","tier":"backend"}`, getValue(aggRecord.Record, "destinationPodLabels"))
+}
@@ -0,0 +1,136 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	leaseLockRetries      = 10
+	leaseLockRetryDelay   = 10 * time.Millisecond
+	leaseLockStaleTimeout = 5 * time.Second
+)
+
+// LeaseStore grants a lease to at most one holder at a time. It is used by the
+// Replicator to decide which aggregator of a pair is active, so that both are
+// never active at the same time even if they cannot reach each other. It can be
+// implemented with any store shared by the aggregators which supports atomic
+// updates, e.g. a Kubernetes Lease.
+type LeaseStore interface {
+	// TryAcquire acquires or renews the lease for the holder for the given
+	// duration. It returns false if the lease is held by another holder and
+	// has not expired.
+	TryAcquire(holder string, duration time.Duration) (bool, error)
+}
+
+// FileLeaseStore is a LeaseStore keeping the lease in a file, which must be on
+// a file system shared by the aggregators, e.g. a volume mounted by both.
+type FileLeaseStore struct {
+	path string
+}
+
+func NewFileLeaseStore(path string) *FileLeaseStore {
+	return &FileLeaseStore{path}
+}
+
+func (s *FileLeaseStore) TryAcquire(holder string, duration time.Duration) (bool, error) {
+	if strings.ContainsAny(holder, " \n") {
+		return false, fmt.Errorf("lease holder %q should not contain spaces", holder)
+	}
+	if err := s.lock(); err != nil {
+		return false, err
+	}
+	defer os.Remove(s.lockPath())
+	currentHolder, expiry, err := s.read()
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if currentHolder != "" && currentHolder != holder && now.Before(expiry) {
+		return false, nil
+	}
+	return true, s.write(holder, now.Add(duration))
+}
+
+func (s *FileLeaseStore) lockPath() string {
+	return s.path + ".lock"
+}
+
+// lock creates the lock file, which is removed by the holder once it has
+// updated the lease. A lock file older than leaseLockStaleTimeout is left by a
+// holder which crashed, and is removed.
+func (s *FileLeaseStore) lock() error {
+	for i := 0; i < leaseLockRetries; i++ {
+		f, err := os.OpenFile(s.lockPath(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			return f.Close()
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("error when locking lease file %s: %v", s.path, err)
+		}
+		if info, err := os.Stat(s.lockPath()); err == nil && time.Since(info.ModTime()) > leaseLockStaleTimeout {
+			os.Remove(s.lockPath())
+			continue
+		}
+		time.Sleep(leaseLockRetryDelay)
+	}
+	return fmt.Errorf("lease file %s is locked", s.path)
+}
+
+func (s *FileLeaseStore) read() (string, time.Time, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return "", time.Time{}, nil
+	} else if err != nil {
+		return "", time.Time{}, fmt.Errorf("error when reading lease file %s: %v", s.path, err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return "", time.Time{}, fmt.Errorf("invalid lease file %s", s.path)
+	}
+	expiry, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid expiry in lease file %s: %v", s.path, err)
+	}
+	return fields[0], time.Unix(0, expiry), nil
+}
+
+// write replaces the lease file atomically, so that a crash does not leave a
+// partial lease.
+func (s *FileLeaseStore) write(holder string, expiry time.Time) error {
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("error when writing lease file %s: %v", s.path, err)
+	}
+	_, err = fmt.Fprintf(f, "%s %d\n", holder, expiry.UnixNano())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("error when writing lease file %s: %v", s.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileLeaseStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lease")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store := NewFileLeaseStore(filepath.Join(dir, "lease"))
+
+	acquired, err := store.TryAcquire("a", 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	acquired, err = store.TryAcquire("b", 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+	// The holder renews the lease.
+	acquired, err = store.TryAcquire("a", 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	// Another holder acquires the lease once it has expired.
+	time.Sleep(60 * time.Millisecond)
+	acquired, err = store.TryAcquire("b", 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	acquired, err = store.TryAcquire("a", 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+
+	_, err = store.TryAcquire("a b", 50*time.Millisecond)
+	assert.Error(t, err)
+	// A stale lock is removed.
+	assert.NoError(t, ioutil.WriteFile(store.lockPath(), nil, 0644))
+	staleTime := time.Now().Add(-2 * leaseLockStaleTimeout)
+	assert.NoError(t, os.Chtimes(store.lockPath(), staleTime, staleTime))
+	acquired, err = store.TryAcquire("b", 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+}
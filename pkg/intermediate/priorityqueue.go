@@ -16,15 +16,26 @@ package intermediate
 
 import (
 	"container/heap"
+	"fmt"
 	"time"
 )
 
 type ItemToExpire struct {
 	// Flow related info
-	flowKey            *FlowKey
+	flowKey *FlowKey
+	// extraKey is the ExtraKey of the aggregationKey of the flow.
+	extraKey           string
 	flowRecord         *AggregationFlowRecord
 	activeExpireTime   time.Time
 	inactiveExpireTime time.Time
+	// correlationExpireTime is zero unless the flow is waiting for the record
+	// from the peer node with a correlation timeout.
+	correlationExpireTime time.Time
+	// addedTime is the time at which the flow was added to the queue.
+	addedTime time.Time
+	// lastExportTime is the last time the flow was passed to the callback of
+	// ForAllExpiredFlowRecordsDo, or zero.
+	lastExportTime time.Time
 	// Index in the priority queue (heap)
 	index int
 }
@@ -36,11 +47,14 @@ func (pq TimeToExpirePriorityQueue) Len() int {
 }
 
 func (pq TimeToExpirePriorityQueue) minExpireTime(i int) time.Time {
-	if pq[i].activeExpireTime.Before(pq[i].inactiveExpireTime) {
-		return pq[i].activeExpireTime
-	} else {
-		return pq[i].inactiveExpireTime
+	minTime := pq[i].inactiveExpireTime
+	if pq[i].activeExpireTime.Before(minTime) {
+		minTime = pq[i].activeExpireTime
 	}
+	if !pq[i].correlationExpireTime.IsZero() && pq[i].correlationExpireTime.Before(minTime) {
+		minTime = pq[i].correlationExpireTime
+	}
+	return minTime
 }
 
 func (pq TimeToExpirePriorityQueue) Less(i, j int) bool {
@@ -74,6 +88,11 @@ func (pq TimeToExpirePriorityQueue) Peek() *ItemToExpire {
 	return pq[0]
 }
 
+// key returns the key of the flow of the item in the map of flow records.
+func (item *ItemToExpire) key() aggregationKey {
+	return aggregationKey{FlowKey: *item.flowKey, ExtraKey: item.extraKey}
+}
+
 // update modifies the priority and flow record of an Item in the queue.
 func (pq *TimeToExpirePriorityQueue) Update(item *ItemToExpire, flowKey *FlowKey, flowRecord *AggregationFlowRecord, activeExpireTime time.Time, inactiveExpireTime time.Time) {
 	item.flowKey = flowKey
@@ -82,3 +101,43 @@ func (pq *TimeToExpirePriorityQueue) Update(item *ItemToExpire, flowKey *FlowKey
 	item.inactiveExpireTime = inactiveExpireTime
 	heap.Fix(pq, item.index)
 }
+
+// checkInvariants returns an error describing the first inconsistency found
+// between the queue and the map of flow records: the heap property, the
+// indexes of the items, and the items of the map entries must all hold for
+// the flows to expire.
+func (pq TimeToExpirePriorityQueue) checkInvariants(flowKeyRecordMap map[aggregationKey]AggregationFlowRecord) error {
+	for i, item := range pq {
+		if item == nil {
+			return fmt.Errorf("item %d is nil", i)
+		}
+		if item.index != i {
+			return fmt.Errorf("item %d has index %d", i, item.index)
+		}
+		if i > 0 && pq.Less(i, (i-1)/2) {
+			return fmt.Errorf("item %d expires at %v, before its parent item %d expiring at %v", i, pq.minExpireTime(i), (i-1)/2, pq.minExpireTime((i-1)/2))
+		}
+		if item.flowKey == nil {
+			return fmt.Errorf("item %d has no flow key", i)
+		}
+		flowRecord, exist := flowKeyRecordMap[item.key()]
+		if !exist {
+			return fmt.Errorf("item %d with flow key %v is not in the map", i, item.key())
+		}
+		if flowRecord.PriorityQueueItem != item {
+			return fmt.Errorf("item %d with flow key %v is not the item of the flow record", i, item.key())
+		}
+	}
+	// As every item is the item of a distinct flow record, the queue contains
+	// all the flow records if their numbers are equal.
+	if len(pq) != len(flowKeyRecordMap) {
+		for flowKey, flowRecord := range flowKeyRecordMap {
+			item := flowRecord.PriorityQueueItem
+			if item == nil || item.index < 0 || item.index >= len(pq) || pq[item.index] != item {
+				return fmt.Errorf("flow record with key %v is not in the queue", flowKey)
+			}
+		}
+		return fmt.Errorf("queue has %d items for %d flow records", len(pq), len(flowKeyRecordMap))
+	}
+	return nil
+}
@@ -96,3 +96,41 @@ func TestTimeToExpirePriorityQueue(t *testing.T) {
 		}
 	}
 }
+
+func TestTimeToExpirePriorityQueue_CheckInvariants(t *testing.T) {
+	startTime := time.Now()
+	flowKeyRecordMap := make(map[aggregationKey]AggregationFlowRecord)
+	pq := make(TimeToExpirePriorityQueue, 0)
+	for i := 0; i < 4; i++ {
+		flowKey := makeFlowKey("10.0.0.1", "10.0.0.2", uint16(1000+i), 80, 6)
+		item := &ItemToExpire{
+			flowKey:            &flowKey,
+			activeExpireTime:   startTime.Add(time.Duration(4-i) * time.Second),
+			inactiveExpireTime: startTime.Add(10 * time.Second),
+		}
+		flowKeyRecordMap[item.key()] = AggregationFlowRecord{PriorityQueueItem: item}
+		heap.Push(&pq, item)
+	}
+	assert.NoError(t, pq.checkInvariants(flowKeyRecordMap))
+
+	// Heap property.
+	pq[0].activeExpireTime = startTime.Add(5 * time.Second)
+	assert.Error(t, pq.checkInvariants(flowKeyRecordMap))
+	heap.Fix(&pq, 0)
+	assert.NoError(t, pq.checkInvariants(flowKeyRecordMap))
+	// Item indexes.
+	pq[1].index = 2
+	assert.Error(t, pq.checkInvariants(flowKeyRecordMap))
+	pq[1].index = 1
+	// Items of the flow records.
+	flowKey := pq[2].key()
+	flowRecord := flowKeyRecordMap[flowKey]
+	flowKeyRecordMap[flowKey] = AggregationFlowRecord{PriorityQueueItem: &ItemToExpire{}}
+	assert.Error(t, pq.checkInvariants(flowKeyRecordMap))
+	flowKeyRecordMap[flowKey] = flowRecord
+	// Flow records missing from the queue.
+	item := heap.Pop(&pq).(*ItemToExpire)
+	assert.Error(t, pq.checkInvariants(flowKeyRecordMap))
+	delete(flowKeyRecordMap, item.key())
+	assert.NoError(t, pq.checkInvariants(flowKeyRecordMap))
+}
@@ -0,0 +1,483 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/gob"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+const (
+	defaultSyncInterval  = time.Second
+	defaultLeaseDuration = 10 * time.Second
+)
+
+func init() {
+	// Values of elements are sent as interfaces; the basic types are
+	// registered by gob.
+	gob.Register(net.IP{})
+	gob.Register(net.HardwareAddr{})
+}
+
+// replicatedElement is an element of a replicated record with its value. The
+// value is sent as it is stored in the record, instead of being encoded, so
+// that the record of the standby is identical.
+type replicatedElement struct {
+	Element entities.InfoElement
+	Value   interface{}
+}
+
+type replicatedFlow struct {
+	Key                       aggregationKey
+	TemplateID                uint16
+	Elements                  []replicatedElement
+	ReadyToSend               bool
+	WaitForReadyToSendRetries int
+	ActiveExpireTime          time.Time
+	InactiveExpireTime        time.Time
+	CorrelationExpireTime     time.Time
+}
+
+// replicationMessage is a message of the state-transfer protocol: a stream of
+// gob-encoded messages from the active aggregator to the standby over TLS. The
+// first message of a connection is a full snapshot of the flow state, and the
+// next ones contain the flows updated and deleted since the previous message.
+type replicationMessage struct {
+	Full    bool
+	Flows   []replicatedFlow
+	Deleted []aggregationKey
+}
+
+// Replicator runs an aggregation process as a member of an active/standby
+// pair. The active aggregator streams its flow state to the standby, which
+// applies it to its own aggregation process, so that the standby can take over
+// the correlation of long-lived flows if the active aggregator crashes.
+//
+// The active aggregator is the holder of a lease in a LeaseStore shared by the
+// pair, which it renews every sync interval. The standby tries to acquire the
+// lease at the same interval, and becomes active once the lease has expired.
+// An aggregator which cannot renew its lease before it expires becomes
+// standby, so that both aggregators are never active at the same time.
+// ForAllExpiredFlowRecordsDo only exports records on the active aggregator.
+type Replicator struct {
+	instanceID    string
+	listenAddress string
+	peerAddress   string
+	leaseStore    LeaseStore
+	leaseDuration time.Duration
+	syncInterval  time.Duration
+	// tlsConfig is used both to accept connections from the peer, which must
+	// present a client certificate, and to connect to the peer.
+	tlsConfig *tls.Config
+	// isActive is 1 if the aggregator is active. It is read while holding the
+	// mutex of the aggregation process, so it is accessed atomically.
+	isActive    uint32
+	leaseExpiry time.Time
+	// aggregationProcess is set by InitAggregationProcess.
+	aggregationProcess *AggregationProcess
+	// updatedFlows and deletedFlows are the flows changed since the last sync.
+	// They are protected by the mutex of the aggregation process.
+	updatedFlows map[aggregationKey]bool
+	deletedFlows map[aggregationKey]bool
+	// mutex protects the listener and the connections.
+	mutex    sync.Mutex
+	listener net.Listener
+	peerConn net.Conn
+	// encoder encodes the messages to peerConn
+	encoder  *gob.Encoder
+	stopChan chan bool
+}
+
+type ReplicatorInput struct {
+	// InstanceID identifies the aggregator in the lease. It should be unique
+	// in the pair.
+	InstanceID string
+	// ListenAddress is the address, in hostIP:port format, on which the flow
+	// state from the active aggregator is received while standby.
+	ListenAddress string
+	// PeerAddress is the ListenAddress of the other aggregator of the pair.
+	PeerAddress string
+	LeaseStore  LeaseStore
+	// LeaseDuration is the duration of the lease. The standby becomes active
+	// at most LeaseDuration after the active aggregator stops renewing the
+	// lease. If 0, it is 10s.
+	LeaseDuration time.Duration
+	// SyncInterval is the interval to renew the lease and to send the updated
+	// flows to the standby. It should be much smaller than LeaseDuration. If
+	// 0, it is 1s.
+	SyncInterval time.Duration
+	// CACert is the certificate of the CA which signs the certificates of
+	// both aggregators. The peers authenticate each other with it, and flow
+	// state is only accepted from a peer presenting a certificate signed by
+	// this CA.
+	CACert []byte
+	// Cert and Key are the certificate and private key of the aggregator,
+	// used both as server and as client certificate. The certificate should
+	// be valid for the host of ListenAddress, as dialed by the peer.
+	Cert []byte
+	Key  []byte
+}
+
+func InitReplicator(input ReplicatorInput) (*Replicator, error) {
+	if input.InstanceID == "" || input.ListenAddress == "" || input.PeerAddress == "" || input.LeaseStore == nil {
+		return nil, fmt.Errorf("instance ID, listen address, peer address and lease store are required for replication")
+	}
+	if input.LeaseDuration == 0 {
+		input.LeaseDuration = defaultLeaseDuration
+	}
+	if input.SyncInterval == 0 {
+		input.SyncInterval = defaultSyncInterval
+	}
+	if input.SyncInterval*2 > input.LeaseDuration {
+		return nil, fmt.Errorf("sync interval %v should be less than half of the lease duration %v", input.SyncInterval, input.LeaseDuration)
+	}
+	tlsConfig, err := createReplicationTLSConfig(input.CACert, input.Cert, input.Key)
+	if err != nil {
+		return nil, fmt.Errorf("error when creating TLS config for replication: %v", err)
+	}
+	return &Replicator{
+		instanceID:    input.InstanceID,
+		listenAddress: input.ListenAddress,
+		peerAddress:   input.PeerAddress,
+		leaseStore:    input.LeaseStore,
+		leaseDuration: input.LeaseDuration,
+		syncInterval:  input.SyncInterval,
+		tlsConfig:     tlsConfig,
+		updatedFlows:  make(map[aggregationKey]bool),
+		deletedFlows:  make(map[aggregationKey]bool),
+		stopChan:      make(chan bool),
+	}, nil
+}
+
+// createReplicationTLSConfig returns the config for mutual TLS between the
+// aggregators of the pair.
+func createReplicationTLSConfig(caCert, cert, key []byte) (*tls.Config, error) {
+	if caCert == nil || cert == nil || key == nil {
+		return nil, fmt.Errorf("CA certificate, certificate and key are required")
+	}
+	keyPair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	ok := roots.AppendCertsFromPEM(caCert)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse root certificate")
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{keyPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    roots,
+		RootCAs:      roots,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// IsActive returns whether the aggregator is the active one of the pair.
+func (r *Replicator) IsActive() bool {
+	return atomic.LoadUint32(&r.isActive) == 1
+}
+
+// GetListenAddress returns the address on which the flow state is received.
+func (r *Replicator) GetListenAddress() net.Addr {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.listener == nil {
+		return nil
+	}
+	return r.listener.Addr()
+}
+
+// Start listens for the flow state from the peer, and renews the lease and
+// sends the flow state to the peer while active, until Stop is called.
+func (r *Replicator) Start() error {
+	if r.aggregationProcess == nil {
+		return fmt.Errorf("replicator is not used by any aggregation process")
+	}
+	listener, err := tls.Listen("tcp", r.listenAddress, r.tlsConfig)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s for replication: %v", r.listenAddress, err)
+	}
+	r.mutex.Lock()
+	r.listener = listener
+	r.mutex.Unlock()
+	go r.acceptConnections(listener)
+
+	ticker := time.NewTicker(r.syncInterval)
+	defer ticker.Stop()
+	r.sync()
+	for {
+		select {
+		case <-r.stopChan:
+			listener.Close()
+			r.closePeerConn()
+			return nil
+		case <-ticker.C:
+			r.sync()
+		}
+	}
+}
+
+func (r *Replicator) Stop() {
+	r.stopChan <- true
+}
+
+// sync renews the lease, changes the role of the aggregator if needed, and
+// sends the updated flows to the peer while active.
+func (r *Replicator) sync() {
+	now := time.Now()
+	acquired, err := r.leaseStore.TryAcquire(r.instanceID, r.leaseDuration)
+	if err != nil {
+		klog.Errorf("Error when acquiring the replication lease: %v", err)
+	} else if acquired {
+		r.leaseExpiry = now.Add(r.leaseDuration)
+	}
+	isActive := now.Before(r.leaseExpiry)
+	if isActive != r.IsActive() {
+		if isActive {
+			klog.Infof("Aggregator %s is now active", r.instanceID)
+			atomic.StoreUint32(&r.isActive, 1)
+			r.aggregationProcess.startWarmUp()
+		} else {
+			klog.Infof("Aggregator %s is now standby", r.instanceID)
+			atomic.StoreUint32(&r.isActive, 0)
+			r.closePeerConn()
+		}
+	}
+	if isActive {
+		if err := r.sendUpdates(); err != nil {
+			klog.Errorf("Error when sending flow state to %s: %v", r.peerAddress, err)
+			r.closePeerConn()
+		}
+	}
+}
+
+func (r *Replicator) closePeerConn() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.peerConn != nil {
+		r.peerConn.Close()
+		r.peerConn = nil
+		r.encoder = nil
+	}
+}
+
+// sendUpdates sends the flows updated since the last sync to the peer, or all
+// the flows when connecting to the peer.
+func (r *Replicator) sendUpdates() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	isFull := false
+	if r.peerConn == nil {
+		dialer := &net.Dialer{Timeout: r.syncInterval}
+		conn, err := tls.DialWithDialer(dialer, "tcp", r.peerAddress, r.tlsConfig)
+		if err != nil {
+			return err
+		}
+		r.peerConn = conn
+		r.encoder = gob.NewEncoder(conn)
+		isFull = true
+	}
+	message := r.aggregationProcess.getReplicationMessage(isFull)
+	if !isFull && len(message.Flows) == 0 && len(message.Deleted) == 0 {
+		return nil
+	}
+	r.peerConn.SetWriteDeadline(time.Now().Add(r.leaseDuration))
+	return r.encoder.Encode(message)
+}
+
+func (r *Replicator) acceptConnections(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			klog.V(2).Infof("Stopped accepting replication connections: %v", err)
+			return
+		}
+		go r.receiveUpdates(conn)
+	}
+}
+
+// receiveUpdates applies the flow state received from the active aggregator,
+// until the connection is closed or this aggregator becomes active. The peer
+// must complete the TLS handshake with a valid client certificate before
+// anything is decoded.
+func (r *Replicator) receiveUpdates(conn net.Conn) {
+	defer conn.Close()
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		klog.Errorf("Rejecting replication connection from %s: not a TLS connection", conn.RemoteAddr())
+		return
+	}
+	tlsConn.SetDeadline(time.Now().Add(r.leaseDuration))
+	if err := tlsConn.Handshake(); err != nil {
+		klog.Warningf("Rejecting replication connection from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	tlsConn.SetDeadline(time.Time{})
+	decoder := gob.NewDecoder(conn)
+	for {
+		var message replicationMessage
+		if err := decoder.Decode(&message); err != nil {
+			klog.V(2).Infof("Replication connection from %s is closed: %v", conn.RemoteAddr(), err)
+			return
+		}
+		if r.IsActive() {
+			klog.Warningf("Ignoring flow state from %s as aggregator %s is active", conn.RemoteAddr(), r.instanceID)
+			return
+		}
+		if err := r.aggregationProcess.applyReplicationMessage(message); err != nil {
+			klog.Errorf("Error when applying flow state from %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// markFlowUpdated records that the flow must be sent to the standby. It should
+// be called while holding the mutex of the aggregation process.
+func (r *Replicator) markFlowUpdated(flowKey aggregationKey) {
+	delete(r.deletedFlows, flowKey)
+	r.updatedFlows[flowKey] = true
+}
+
+// markFlowDeleted records that the flow must be deleted by the standby. It
+// should be called while holding the mutex of the aggregation process.
+func (r *Replicator) markFlowDeleted(flowKey aggregationKey) {
+	delete(r.updatedFlows, flowKey)
+	r.deletedFlows[flowKey] = true
+}
+
+// getReplicationMessage returns the message with the updated flows, or with
+// all the flows if isFull is true, and clears the updated flows.
+func (a *AggregationProcess) getReplicationMessage(isFull bool) replicationMessage {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	r := a.replicator
+	message := replicationMessage{Full: isFull}
+	if isFull {
+		for flowKey := range a.flowKeyRecordMap {
+			message.Flows = append(message.Flows, a.getReplicatedFlow(flowKey))
+		}
+	} else {
+		for flowKey := range r.updatedFlows {
+			if _, exist := a.flowKeyRecordMap[flowKey]; exist {
+				message.Flows = append(message.Flows, a.getReplicatedFlow(flowKey))
+			}
+		}
+		for flowKey := range r.deletedFlows {
+			message.Deleted = append(message.Deleted, flowKey)
+		}
+	}
+	r.updatedFlows = make(map[aggregationKey]bool)
+	r.deletedFlows = make(map[aggregationKey]bool)
+	return message
+}
+
+func (a *AggregationProcess) getReplicatedFlow(flowKey aggregationKey) replicatedFlow {
+	flowRecord := a.flowKeyRecordMap[flowKey]
+	flow := replicatedFlow{
+		Key:                       flowKey,
+		TemplateID:                flowRecord.Record.GetTemplateID(),
+		ReadyToSend:               flowRecord.ReadyToSend,
+		WaitForReadyToSendRetries: flowRecord.waitForReadyToSendRetries,
+	}
+	for _, ie := range flowRecord.Record.GetOrderedElementList() {
+		flow.Elements = append(flow.Elements, replicatedElement{*ie.Element, ie.Value})
+	}
+	if item := flowRecord.PriorityQueueItem; item != nil {
+		flow.ActiveExpireTime = item.activeExpireTime
+		flow.InactiveExpireTime = item.inactiveExpireTime
+		flow.CorrelationExpireTime = item.correlationExpireTime
+	}
+	return flow
+}
+
+// applyReplicationMessage updates the flow state with the message from the
+// active aggregator.
+func (a *AggregationProcess) applyReplicationMessage(message replicationMessage) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	defer a.verifyExpirePriorityQueue("applying a replication message")
+	if message.Full {
+		a.flowKeyRecordMap = make(map[aggregationKey]AggregationFlowRecord)
+		a.expirePriorityQueue = make(TimeToExpirePriorityQueue, 0)
+	}
+	for _, flowKey := range message.Deleted {
+		if flowRecord, exist := a.flowKeyRecordMap[flowKey]; exist {
+			if item := flowRecord.PriorityQueueItem; item != nil && item.index >= 0 {
+				heap.Remove(&a.expirePriorityQueue, item.index)
+			}
+			delete(a.flowKeyRecordMap, flowKey)
+		}
+	}
+	for _, flow := range message.Flows {
+		record := entities.NewDataRecord(flow.TemplateID)
+		if _, err := record.PrepareRecord(); err != nil {
+			return err
+		}
+		for i := range flow.Elements {
+			element := &flow.Elements[i].Element
+			// The element is added with a zero value, which is then replaced
+			// by the replicated value, as values are not always stored in a
+			// form that can be encoded again (e.g. strings as []byte).
+			length := 0
+			if element.Len != entities.VariableLength {
+				length = int(element.Len)
+			}
+			if _, err := record.AddInfoElement(entities.NewInfoElementWithValue(element, bytes.NewBuffer(make([]byte, length))), true); err != nil {
+				return fmt.Errorf("error when adding element %s to replicated record: %v", element.Name, err)
+			}
+			ie, _ := record.GetInfoElementWithValue(element.Name)
+			ie.Value = flow.Elements[i].Value
+		}
+		flowKey := flow.Key
+		flowRecord := AggregationFlowRecord{
+			Record:                    record,
+			ReadyToSend:               flow.ReadyToSend,
+			waitForReadyToSendRetries: flow.WaitForReadyToSendRetries,
+		}
+		if existing, exist := a.flowKeyRecordMap[flowKey]; exist && existing.PriorityQueueItem != nil && existing.PriorityQueueItem.index >= 0 {
+			item := existing.PriorityQueueItem
+			item.correlationExpireTime = flow.CorrelationExpireTime
+			flowRecord.PriorityQueueItem = item
+			a.expirePriorityQueue.Update(item, &flowKey.FlowKey, &flowRecord, flow.ActiveExpireTime, flow.InactiveExpireTime)
+		} else {
+			item := &ItemToExpire{
+				flowKey:               &flowKey.FlowKey,
+				extraKey:              flowKey.ExtraKey,
+				flowRecord:            &flowRecord,
+				activeExpireTime:      flow.ActiveExpireTime,
+				inactiveExpireTime:    flow.InactiveExpireTime,
+				correlationExpireTime: flow.CorrelationExpireTime,
+				addedTime:             time.Now(),
+			}
+			flowRecord.PriorityQueueItem = item
+			heap.Push(&a.expirePriorityQueue, item)
+		}
+		a.flowKeyRecordMap[flowKey] = flowRecord
+	}
+	return nil
+}
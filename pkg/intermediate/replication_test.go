@@ -0,0 +1,257 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/gob"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+func getFreeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+type testCerts struct {
+	caCert []byte
+	cert   []byte
+	key    []byte
+}
+
+// generateTestCerts returns a CA certificate, and a certificate for 127.0.0.1
+// signed by it, usable both as server and as client certificate.
+func generateTestCerts(t *testing.T) testCerts {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "replication-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	assert.NoError(t, err)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "aggregator"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	return testCerts{
+		caCert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		cert:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:    pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func createReplicatedAggregationProcess(t *testing.T, instanceID, listenAddress, peerAddress string, store LeaseStore, certs testCerts) (*AggregationProcess, *Replicator) {
+	replicator, err := InitReplicator(ReplicatorInput{
+		InstanceID:    instanceID,
+		ListenAddress: listenAddress,
+		PeerAddress:   peerAddress,
+		LeaseStore:    store,
+		LeaseDuration: 200 * time.Millisecond,
+		SyncInterval:  20 * time.Millisecond,
+		CACert:        certs.caCert,
+		Cert:          certs.cert,
+		Key:           certs.key,
+	})
+	assert.NoError(t, err)
+	ap, err := InitAggregationProcess(AggregationInput{
+		MessageChan:           make(chan *entities.Message),
+		WorkerNum:             2,
+		CorrelateFields:       fields,
+		ActiveExpiryTimeout:   testActiveExpiry,
+		InactiveExpiryTimeout: testInactiveExpiry,
+		Replicator:            replicator,
+	})
+	assert.NoError(t, err)
+	return ap, replicator
+}
+
+func getFlowCount(ap *AggregationProcess) int {
+	ap.mutex.RLock()
+	defer ap.mutex.RUnlock()
+	return len(ap.flowKeyRecordMap)
+}
+
+func TestReplicator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replication")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store := NewFileLeaseStore(filepath.Join(dir, "lease"))
+	addressA, addressB := getFreeAddress(t), getFreeAddress(t)
+	certs := generateTestCerts(t)
+	apA, replicatorA := createReplicatedAggregationProcess(t, "a", addressA, addressB, store, certs)
+	apB, replicatorB := createReplicatedAggregationProcess(t, "b", addressB, addressA, store, certs)
+
+	go replicatorA.Start()
+	err = wait.Poll(10*time.Millisecond, time.Second, func() (bool, error) {
+		return replicatorA.IsActive(), nil
+	})
+	assert.NoError(t, err)
+	go replicatorB.Start()
+
+	record := createDataMsgForSrc(t, false, true, false, false, false).GetSet().GetRecords()[0]
+	flowKey, _ := getFlowKeyFromRecord(record)
+	assert.NoError(t, apA.addOrUpdateRecordInMap(flowKey, record))
+	record = createDataMsgForSrc(t, true, true, false, false, false).GetSet().GetRecords()[0]
+	flowKeyIPv6, _ := getFlowKeyFromRecord(record)
+	assert.NoError(t, apA.addOrUpdateRecordInMap(flowKeyIPv6, record))
+	err = wait.Poll(10*time.Millisecond, time.Second, func() (bool, error) {
+		return getFlowCount(apB) == 2, nil
+	})
+	assert.NoError(t, err)
+	assert.False(t, replicatorB.IsActive())
+
+	apB.mutex.RLock()
+	replicated := apB.flowKeyRecordMap[aggregationKey{FlowKey: *flowKey}].Record
+	assert.Equal(t, 2, apB.expirePriorityQueue.Len())
+	apB.mutex.RUnlock()
+	apA.mutex.RLock()
+	assert.Empty(t, entities.DiffRecords(apA.flowKeyRecordMap[aggregationKey{FlowKey: *flowKey}].Record, replicated))
+	apA.mutex.RUnlock()
+
+	// The deletion of a flow is replicated.
+	assert.NoError(t, apA.deleteFlowKeyFromMap(aggregationKey{FlowKey: *flowKeyIPv6}))
+	err = wait.Poll(10*time.Millisecond, time.Second, func() (bool, error) {
+		return getFlowCount(apB) == 1, nil
+	})
+	assert.NoError(t, err)
+
+	// The standby does not export records.
+	time.Sleep(testInactiveExpiry)
+	exported := 0
+	callback := func(key FlowKey, record AggregationFlowRecord) error {
+		exported++
+		return nil
+	}
+	assert.NoError(t, apB.ForAllExpiredFlowRecordsDo(callback))
+	assert.Equal(t, 0, exported)
+
+	// The standby takes over when the active aggregator stops renewing the
+	// lease, and exports the replicated flows.
+	replicatorA.Stop()
+	err = wait.Poll(10*time.Millisecond, time.Second, func() (bool, error) {
+		return replicatorB.IsActive(), nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, apB.ForAllExpiredFlowRecordsDo(callback))
+	assert.Equal(t, 1, exported)
+	replicatorB.Stop()
+}
+
+func TestReplicatorRejectsUnauthenticatedPeers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replication")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// The lease is held by another instance, so that the aggregator stays
+	// standby and accepts flow state.
+	store := NewFileLeaseStore(filepath.Join(dir, "lease"))
+	_, err = store.TryAcquire("other", time.Minute)
+	assert.NoError(t, err)
+	certs := generateTestCerts(t)
+	ap, replicator := createReplicatedAggregationProcess(t, "a", "127.0.0.1:0", getFreeAddress(t), store, certs)
+	go replicator.Start()
+	defer replicator.Stop()
+	err = wait.Poll(10*time.Millisecond, time.Second, func() (bool, error) {
+		return replicator.GetListenAddress() != nil, nil
+	})
+	assert.NoError(t, err)
+	address := replicator.GetListenAddress().String()
+
+	record := createDataMsgForSrc(t, false, true, false, false, false).GetSet().GetRecords()[0]
+	flowKey, _ := getFlowKeyFromRecord(record)
+	message := replicationMessage{
+		Full:  true,
+		Flows: []replicatedFlow{{Key: aggregationKey{FlowKey: *flowKey}, TemplateID: record.GetTemplateID()}},
+	}
+
+	// Plaintext connection.
+	conn, err := net.Dial("tcp", address)
+	assert.NoError(t, err)
+	gob.NewEncoder(conn).Encode(message)
+	conn.Close()
+
+	// TLS connection without a client certificate.
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certs.caCert)
+	tlsConn, err := tls.Dial("tcp", address, &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12})
+	if err == nil {
+		gob.NewEncoder(tlsConn).Encode(message)
+		tlsConn.Close()
+	}
+
+	// TLS connection with a client certificate signed by another CA.
+	otherCerts := generateTestCerts(t)
+	cert, err := tls.X509KeyPair(otherCerts.cert, otherCerts.key)
+	assert.NoError(t, err)
+	tlsConn, err = tls.Dial("tcp", address, &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	if err == nil {
+		gob.NewEncoder(tlsConn).Encode(message)
+		tlsConn.Close()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, replicator.IsActive())
+	assert.Equal(t, 0, getFlowCount(ap))
+}
+
+func TestInitReplicator(t *testing.T) {
+	store := NewFileLeaseStore("lease")
+	certs := generateTestCerts(t)
+	_, err := InitReplicator(ReplicatorInput{InstanceID: "a", ListenAddress: "127.0.0.1:0", LeaseStore: store})
+	assert.Error(t, err)
+	_, err = InitReplicator(ReplicatorInput{InstanceID: "a", ListenAddress: "127.0.0.1:0", PeerAddress: "127.0.0.1:1", LeaseStore: store, SyncInterval: time.Minute, CACert: certs.caCert, Cert: certs.cert, Key: certs.key})
+	assert.Error(t, err)
+	// TLS is required.
+	_, err = InitReplicator(ReplicatorInput{InstanceID: "a", ListenAddress: "127.0.0.1:0", PeerAddress: "127.0.0.1:1", LeaseStore: store})
+	assert.Error(t, err)
+	replicator, err := InitReplicator(ReplicatorInput{InstanceID: "a", ListenAddress: "127.0.0.1:0", PeerAddress: "127.0.0.1:1", LeaseStore: store, CACert: certs.caCert, Cert: certs.cert, Key: certs.key})
+	assert.NoError(t, err)
+	assert.Error(t, replicator.Start())
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// SamplingWindow is the interval over which the rate of expired records is
+// measured to adapt the sampling rate.
+var SamplingWindow = time.Second
+
+// adaptiveSampler selects 1 out of N expired records, where N is adapted every
+// window so that the rate of selected records stays under the threshold.
+type adaptiveSampler struct {
+	// threshold is the maximum number of selected records per second.
+	threshold   uint64
+	windowStart time.Time
+	windowCount uint64
+	// rate is N, the sampling rate applied in the current window.
+	rate    uint64
+	counter uint64
+}
+
+func newAdaptiveSampler(threshold uint64) *adaptiveSampler {
+	return &adaptiveSampler{
+		threshold: threshold,
+		rate:      1,
+	}
+}
+
+// sample returns whether the next record is selected, and the sampling rate
+// that applies to it.
+func (s *adaptiveSampler) sample(now time.Time) (bool, uint64) {
+	if s.windowStart.IsZero() {
+		s.windowStart = now
+	} else if elapsed := now.Sub(s.windowStart); elapsed >= SamplingWindow {
+		// Compute the rate of records per second in the last window, and the
+		// sampling rate needed to bring it under the threshold.
+		perSecond := s.windowCount * uint64(time.Second) / uint64(elapsed)
+		s.rate = (perSecond + s.threshold - 1) / s.threshold
+		if s.rate == 0 {
+			s.rate = 1
+		}
+		s.windowStart = now
+		s.windowCount = 0
+	}
+	s.windowCount++
+	s.counter++
+	return s.counter%s.rate == 0, s.rate
+}
+
+// addSamplingFields adds samplingFlowInterval and samplingFlowSpacing (RFC7015)
+// to a new record in the map. A 1:N sampling rate is recorded as an interval of
+// 1 and a spacing of N-1.
+func addSamplingFields(record entities.Record) error {
+	for _, name := range []string{"samplingFlowInterval", "samplingFlowSpacing"} {
+		ie, err := registry.GetInfoElement(name, registry.IANAEnterpriseID)
+		if err != nil {
+			return err
+		}
+		if _, err = record.AddInfoElement(entities.NewInfoElementWithValue(ie, uint64(0)), false); err != nil {
+			return err
+		}
+	}
+	return setSamplingFields(record, 1)
+}
+
+func setSamplingFields(record entities.Record, rate uint64) error {
+	interval, exist := record.GetInfoElementWithValue("samplingFlowInterval")
+	if !exist {
+		return fmt.Errorf("samplingFlowInterval is not present in the record")
+	}
+	spacing, exist := record.GetInfoElementWithValue("samplingFlowSpacing")
+	if !exist {
+		return fmt.Errorf("samplingFlowSpacing is not present in the record")
+	}
+	interval.Value = uint64(1)
+	spacing.Value = rate - 1
+	return nil
+}
+
+// GetSamplingRate returns N, the current 1:N sampling rate of expired records.
+// It is 1 when sampling is disabled or the rate of expired records is under
+// the threshold.
+func (a *AggregationProcess) GetSamplingRate() uint64 {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.sampler == nil {
+		return 1
+	}
+	return a.sampler.rate
+}
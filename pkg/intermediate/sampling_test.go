@@ -0,0 +1,84 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+func TestAdaptiveSampler(t *testing.T) {
+	sampler := newAdaptiveSampler(10)
+	now := time.Now()
+	countSampled := func(now time.Time, count int) int {
+		sampled := 0
+		for i := 0; i < count; i++ {
+			if isSampled, _ := sampler.sample(now); isSampled {
+				sampled++
+			}
+		}
+		return sampled
+	}
+	// All records are selected in the first window.
+	assert.Equal(t, 30, countSampled(now, 30))
+	assert.Equal(t, uint64(1), sampler.rate)
+	// 30 records per second were seen, so 1 out of 3 records is selected.
+	assert.Equal(t, 10, countSampled(now.Add(SamplingWindow), 30))
+	assert.Equal(t, uint64(3), sampler.rate)
+	assert.Equal(t, 1, countSampled(now.Add(2*SamplingWindow), 5))
+	assert.Equal(t, uint64(3), sampler.rate)
+	// Back under the threshold.
+	assert.Equal(t, 5, countSampled(now.Add(3*SamplingWindow), 5))
+	assert.Equal(t, uint64(1), sampler.rate)
+}
+
+func TestForAllExpiredFlowRecordsDoWithSampling(t *testing.T) {
+	messageChan := make(chan *entities.Message)
+	input := AggregationInput{
+		MessageChan:           messageChan,
+		WorkerNum:             2,
+		CorrelateFields:       fields,
+		ActiveExpiryTimeout:   testActiveExpiry,
+		InactiveExpiryTimeout: testInactiveExpiry,
+		SamplingThreshold:     1,
+	}
+	ap, _ := InitAggregationProcess(input)
+	for _, isIPv6 := range []bool{false, true} {
+		record := createDataMsgForSrc(t, isIPv6, true, false, false, false).GetSet().GetRecords()[0]
+		flowKey, _ := getFlowKeyFromRecord(record)
+		assert.NoError(t, ap.addOrUpdateRecordInMap(flowKey, record))
+		assert.Equal(t, uint64(1), getValue(record, "samplingFlowInterval"))
+		assert.Equal(t, uint64(0), getValue(record, "samplingFlowSpacing"))
+	}
+	// Sample 1 out of 2 records.
+	ap.sampler.rate = 2
+	ap.sampler.windowStart = time.Now()
+
+	var exported []AggregationFlowRecord
+	testCallback := func(key FlowKey, record AggregationFlowRecord) error {
+		exported = append(exported, record)
+		return nil
+	}
+	time.Sleep(testInactiveExpiry)
+	assert.NoError(t, ap.ForAllExpiredFlowRecordsDo(testCallback))
+	assert.Len(t, exported, 1)
+	assert.Equal(t, uint64(1), getValue(exported[0].Record, "samplingFlowSpacing"))
+	assert.Equal(t, 0, ap.expirePriorityQueue.Len())
+	assert.Equal(t, uint64(2), ap.GetSamplingRate())
+}
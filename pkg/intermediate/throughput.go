@@ -0,0 +1,137 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// DefaultAggregationElements returns the aggregation elements of Antrea flow
+// records, for bandwidth as well as packet accounting: the packet and octet
+// counters of both directions are aggregated along with their values from the
+// source and destination nodes, and the throughput of the flows is derived
+// from the octet delta counters.
+func DefaultAggregationElements() *AggregationElements {
+	return &AggregationElements{
+		NonStatsElements: []string{
+			"flowEndSeconds",
+			"flowEndReason",
+			"tcpState",
+		},
+		StatsElements: []string{
+			"packetTotalCount",
+			"packetDeltaCount",
+			"reversePacketTotalCount",
+			"reversePacketDeltaCount",
+			"octetTotalCount",
+			"octetDeltaCount",
+			"reverseOctetTotalCount",
+			"reverseOctetDeltaCount",
+		},
+		AggregatedSourceStatsElements: []string{
+			"packetTotalCountFromSourceNode",
+			"packetDeltaCountFromSourceNode",
+			"reversePacketTotalCountFromSourceNode",
+			"reversePacketDeltaCountFromSourceNode",
+			"octetTotalCountFromSourceNode",
+			"octetDeltaCountFromSourceNode",
+			"reverseOctetTotalCountFromSourceNode",
+			"reverseOctetDeltaCountFromSourceNode",
+		},
+		AggregatedDestinationStatsElements: []string{
+			"packetTotalCountFromDestinationNode",
+			"packetDeltaCountFromDestinationNode",
+			"reversePacketTotalCountFromDestinationNode",
+			"reversePacketDeltaCountFromDestinationNode",
+			"octetTotalCountFromDestinationNode",
+			"octetDeltaCountFromDestinationNode",
+			"reverseOctetTotalCountFromDestinationNode",
+			"reverseOctetDeltaCountFromDestinationNode",
+		},
+		ThroughputElements: map[string]string{
+			"octetDeltaCount":                           "throughput",
+			"reverseOctetDeltaCount":                    "reverseThroughput",
+			"octetDeltaCountFromSourceNode":             "throughputFromSourceNode",
+			"octetDeltaCountFromDestinationNode":        "throughputFromDestinationNode",
+			"reverseOctetDeltaCountFromSourceNode":      "reverseThroughputFromSourceNode",
+			"reverseOctetDeltaCountFromDestinationNode": "reverseThroughputFromDestinationNode",
+		},
+	}
+}
+
+// addThroughputElements adds the throughput elements of the delta elements in
+// the record, with zero values. They are added in the order of the names of the
+// delta elements, so that the records of the same template have the same
+// elements in the same order.
+func (a *AggregationProcess) addThroughputElements(record entities.Record) error {
+	deltaElements := make([]string, 0, len(a.aggregateElements.ThroughputElements))
+	for deltaElement := range a.aggregateElements.ThroughputElements {
+		deltaElements = append(deltaElements, deltaElement)
+	}
+	sort.Strings(deltaElements)
+	for _, deltaElement := range deltaElements {
+		throughputElement := a.aggregateElements.ThroughputElements[deltaElement]
+		if _, exist := record.GetInfoElementWithValue(deltaElement); !exist {
+			continue
+		}
+		if _, exist := record.GetInfoElementWithValue(throughputElement); exist {
+			continue
+		}
+		ie, err := registry.GetInfoElement(throughputElement, registry.AntreaEnterpriseID)
+		if err != nil {
+			return err
+		}
+		if _, err = record.AddInfoElement(entities.NewInfoElementWithValue(ie, bytes.NewBuffer(make([]byte, ie.Len))), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setThroughputs sets the throughput elements of the flow record to the rate
+// of the delta elements since the previous export of the flow, and records the
+// export. This should be called after acquiring the mutex.
+func (a *AggregationProcess) setThroughputs(pqItem *ItemToExpire, currTime time.Time) {
+	since := pqItem.addedTime
+	if pqItem.lastExportTime.After(since) {
+		since = pqItem.lastExportTime
+	}
+	pqItem.lastExportTime = currTime
+	if a.aggregateElements == nil {
+		return
+	}
+	interval := currTime.Sub(since)
+	if interval <= 0 {
+		return
+	}
+	record := pqItem.flowRecord.Record
+	for deltaElement, throughputElement := range a.aggregateElements.ThroughputElements {
+		deltaIeWithValue, exist := record.GetInfoElementWithValue(deltaElement)
+		if !exist {
+			continue
+		}
+		throughputIeWithValue, exist := record.GetInfoElementWithValue(throughputElement)
+		if !exist {
+			continue
+		}
+		octets, _ := deltaIeWithValue.Value.(uint64)
+		throughputIeWithValue.Value = uint64(float64(octets*8) / interval.Seconds())
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestDefaultAggregationElements(t *testing.T) {
+	aggElements := DefaultAggregationElements()
+	for _, element := range aggElements.StatsElements {
+		enterpriseID := registry.IANAEnterpriseID
+		if strings.HasPrefix(element, "reverse") {
+			enterpriseID = registry.IANAReversedEnterpriseID
+		}
+		_, err := registry.GetInfoElement(element, enterpriseID)
+		assert.NoErrorf(t, err, "element %s should be in the registry", element)
+	}
+	antreaElements := append(aggElements.AggregatedSourceStatsElements, aggElements.AggregatedDestinationStatsElements...)
+	for _, throughputElement := range aggElements.ThroughputElements {
+		antreaElements = append(antreaElements, throughputElement)
+	}
+	for _, element := range antreaElements {
+		_, err := registry.GetInfoElement(element, registry.AntreaEnterpriseID)
+		assert.NoErrorf(t, err, "element %s should be in the registry", element)
+	}
+	assert.Len(t, aggElements.AggregatedSourceStatsElements, len(aggElements.StatsElements))
+	assert.Len(t, aggElements.AggregatedDestinationStatsElements, len(aggElements.StatsElements))
+}
+
+func TestThroughputElements(t *testing.T) {
+	ap, err := InitAggregationProcess(AggregationInput{
+		MessageChan:           make(chan *entities.Message),
+		WorkerNum:             1,
+		CorrelateFields:       fields,
+		AggregateElements:     DefaultAggregationElements(),
+		ActiveExpiryTimeout:   testActiveExpiry,
+		InactiveExpiryTimeout: testInactiveExpiry,
+	})
+	assert.NoError(t, err)
+	record := createDataMsgForSrc(t, false, true, true, false, false).GetSet().GetRecords()[0]
+	flowKey, _ := getFlowKeyFromRecord(record)
+	assert.NoError(t, ap.addOrUpdateRecordInMap(flowKey, record))
+	aggRecord := ap.flowKeyRecordMap[aggregationKey{FlowKey: *flowKey}].Record
+	for _, element := range []string{"throughput", "reverseThroughput", "throughputFromSourceNode", "throughputFromDestinationNode"} {
+		ieWithValue, exist := aggRecord.GetInfoElementWithValue(element)
+		assert.Truef(t, exist, "element %s should be added to the record", element)
+		assert.Equal(t, uint64(0), ieWithValue.Value)
+	}
+	// Throughput elements are added in the order of their delta elements.
+	throughputElements := make([]string, 0)
+	for _, ieWithValue := range aggRecord.GetOrderedElementList() {
+		if strings.Contains(ieWithValue.Element.Name, "hroughput") {
+			throughputElements = append(throughputElements, ieWithValue.Element.Name)
+		}
+	}
+	assert.Equal(t, []string{
+		"throughput",
+		"throughputFromDestinationNode",
+		"throughputFromSourceNode",
+		"reverseThroughput",
+		"reverseThroughputFromDestinationNode",
+		"reverseThroughputFromSourceNode",
+	}, throughputElements)
+
+	// 50000 bytes in 10 seconds are 40000 bits per second.
+	pqItem := ap.expirePriorityQueue.Peek()
+	currTime := pqItem.addedTime.Add(10 * time.Second)
+	ap.setThroughputs(pqItem, currTime)
+	for _, element := range []string{"throughput", "reverseThroughput", "throughputFromSourceNode", "throughputFromDestinationNode"} {
+		ieWithValue, _ := aggRecord.GetInfoElementWithValue(element)
+		assert.Equalf(t, uint64(40000), ieWithValue.Value, "values should be equal for element %s", element)
+	}
+	assert.Equal(t, currTime, pqItem.lastExportTime)
+
+	// The rate of the next export covers the bytes since the previous one.
+	assert.NoError(t, ap.ResetStatElementsInRecord(aggRecord))
+	ieWithValue, _ := aggRecord.GetInfoElementWithValue("octetDeltaCount")
+	ieWithValue.Value = uint64(1000)
+	ap.setThroughputs(pqItem, currTime.Add(2*time.Second))
+	ieWithValue, _ = aggRecord.GetInfoElementWithValue("throughput")
+	assert.Equal(t, uint64(4000), ieWithValue.Value)
+	ieWithValue, _ = aggRecord.GetInfoElementWithValue("reverseThroughput")
+	assert.Equal(t, uint64(0), ieWithValue.Value)
+}
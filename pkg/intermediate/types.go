@@ -24,6 +24,15 @@ type FlowKey struct {
 	DestinationPort    uint16
 }
 
+// aggregationKey is the key of a flow in the aggregation process: the flow
+// key, and the values of the additional flow key elements given by
+// AggregationInput.FlowKeyElements, e.g. "ipClassOfService=46". ExtraKey is
+// empty if there are none. The fields are exported to be replicated.
+type aggregationKey struct {
+	FlowKey  FlowKey
+	ExtraKey string
+}
+
 type AggregationFlowRecord struct {
 	Record entities.Record
 	// Flow record contains mapping to its reference in priority queue.
@@ -33,6 +42,9 @@ type AggregationFlowRecord struct {
 	// inter-node flow and record from the node for the case of intra-node flow.
 	ReadyToSend               bool
 	waitForReadyToSendRetries int
+	// cardinalityKeys are the keys the flow is counted against for the
+	// cardinality limits.
+	cardinalityKeys cardinalityKeys
 }
 
 type AggregationElements struct {
@@ -40,6 +52,21 @@ type AggregationElements struct {
 	StatsElements                      []string
 	AggregatedSourceStatsElements      []string
 	AggregatedDestinationStatsElements []string
+	// ThroughputElements are optional. They map octet delta count elements,
+	// e.g. octetDeltaCount or octetDeltaCountFromSourceNode, to Antrea
+	// elements, e.g. throughput or throughputFromSourceNode, which are added
+	// to the flow records. When a flow record is passed to the callback of
+	// ForAllExpiredFlowRecordsDo, they are set to the average rate in bits per
+	// second of the bytes counted by the delta elements since the previous
+	// export of the flow, so the delta stats must be reset after every export
+	// with ResetStatElementsInRecord. See DefaultAggregationElements.
+	ThroughputElements map[string]string
+}
+
+// flowRecordUpdate is a record to aggregate in the flow with the flow key.
+type flowRecordUpdate struct {
+	flowKey *FlowKey
+	record  entities.Record
 }
 
 type FlowKeyRecordMapCallBack func(key FlowKey, record AggregationFlowRecord) error
@@ -0,0 +1,216 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// UnknownTemplateVersion is the name under which the records matching none of
+// the template versions are counted.
+const UnknownTemplateVersion = "unknown"
+
+// TemplateVersion describes the records of one version of the exporters, e.g.
+// of the agents before or after an upgrade.
+type TemplateVersion struct {
+	// Name identifies the version in the stats, e.g. "v1.2".
+	Name string
+	// Elements identify the records of the version: a record is of the first
+	// version whose elements it all has. A version without elements matches
+	// all records, so it should be the last one.
+	Elements []string
+	// Renames maps the elements of the version to the elements of the common
+	// schema, under which they are aggregated.
+	Renames map[string]string
+}
+
+// TemplateVersions allow the aggregation process to accept the records of
+// several versions of exporters at the same time, e.g. during the upgrade of a
+// fleet of agents which changes their templates. The records of every version
+// are mapped to a common schema before being aggregated: their elements are
+// renamed, and the elements of the common schema missing from them are added
+// with zero values, so that the records of a flow from exporters with
+// different versions are aggregated and correlated together. The number of
+// records of every version is reported by GetTemplateVersionStats to track the
+// progress of the upgrade.
+type TemplateVersions struct {
+	Versions []TemplateVersion
+	// CommonElements are the elements of the common schema added to the
+	// records without them. They must be in the IANA or Antrea registries.
+	CommonElements []string
+}
+
+// templateVersionMapper maps records to the common schema of the template
+// versions, and counts them.
+type templateVersionMapper struct {
+	versions       []TemplateVersion
+	commonElements []*entities.InfoElement
+	mutex          sync.Mutex
+	records        map[string]uint64
+}
+
+func newTemplateVersionMapper(input TemplateVersions) (*templateVersionMapper, error) {
+	m := &templateVersionMapper{
+		versions: input.Versions,
+		records:  make(map[string]uint64),
+	}
+	for _, version := range input.Versions {
+		if version.Name == "" {
+			return nil, fmt.Errorf("template version without name")
+		}
+	}
+	for _, name := range input.CommonElements {
+		element, exist := getRegistryElement(name)
+		if !exist {
+			return nil, fmt.Errorf("common element %s of template versions is not in the registry", name)
+		}
+		m.commonElements = append(m.commonElements, element)
+	}
+	return m, nil
+}
+
+// getRegistryElement returns the element with the name in the IANA or Antrea
+// registries.
+func getRegistryElement(name string) (*entities.InfoElement, bool) {
+	for _, enterpriseID := range []uint32{registry.IANAEnterpriseID, registry.AntreaEnterpriseID} {
+		if element, err := registry.GetInfoElement(name, enterpriseID); err == nil {
+			return element, true
+		}
+	}
+	return nil, false
+}
+
+// getVersion returns the first version whose elements are all in the record.
+func (m *templateVersionMapper) getVersion(record entities.Record) *TemplateVersion {
+	for i := range m.versions {
+		matched := true
+		for _, name := range m.versions[i].Elements {
+			if _, exist := record.GetInfoElementWithValue(name); !exist {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return &m.versions[i]
+		}
+	}
+	return nil
+}
+
+// mapRecord counts the record under its version, and returns it in the common
+// schema. The record is returned as is if it is already in the common schema.
+func (m *templateVersionMapper) mapRecord(record entities.Record) (entities.Record, error) {
+	version := m.getVersion(record)
+	name := UnknownTemplateVersion
+	if version != nil {
+		name = version.Name
+	}
+	m.mutex.Lock()
+	m.records[name]++
+	m.mutex.Unlock()
+
+	renamed := false
+	if version != nil {
+		for from := range version.Renames {
+			if _, exist := record.GetInfoElementWithValue(from); exist {
+				renamed = true
+				break
+			}
+		}
+	}
+	missing := make([]*entities.InfoElement, 0)
+	for _, element := range m.commonElements {
+		if _, exist := record.GetInfoElementWithValue(element.Name); !exist {
+			missing = append(missing, element)
+		}
+	}
+	if !renamed && len(missing) == 0 {
+		return record, nil
+	}
+	mapped := entities.NewDataRecord(record.GetTemplateID())
+	if _, err := mapped.PrepareRecord(); err != nil {
+		return nil, err
+	}
+	addElement := func(element *entities.InfoElement, value interface{}) error {
+		// The element is added with a zero value, which is then replaced by
+		// the decoded value, as in replicated records.
+		length := 0
+		if element.Len != entities.VariableLength {
+			length = int(element.Len)
+		}
+		if _, err := mapped.AddInfoElement(entities.NewInfoElementWithValue(element, bytes.NewBuffer(make([]byte, length))), true); err != nil {
+			return fmt.Errorf("error when adding element %s to record of the common schema: %v", element.Name, err)
+		}
+		if value != nil {
+			ie, _ := mapped.GetInfoElementWithValue(element.Name)
+			ie.Value = value
+		}
+		return nil
+	}
+	for _, ie := range record.GetOrderedElementList() {
+		element := ie.Element
+		if version != nil {
+			if to, exist := version.Renames[element.Name]; exist {
+				// The element of the common schema is taken from the
+				// registry if it has the same data type.
+				if commonElement, exist := getRegistryElement(to); exist && commonElement.DataType == element.DataType {
+					element = commonElement
+				} else {
+					renamedElement := *element
+					renamedElement.Name = to
+					element = &renamedElement
+				}
+			}
+		}
+		if err := addElement(element, ie.Value); err != nil {
+			return nil, err
+		}
+	}
+	for _, element := range missing {
+		if _, exist := mapped.GetInfoElementWithValue(element.Name); exist {
+			// The element was renamed from an element of the version.
+			continue
+		}
+		if err := addElement(element, nil); err != nil {
+			return nil, err
+		}
+	}
+	return mapped, nil
+}
+
+func (m *templateVersionMapper) getStats() map[string]uint64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stats := make(map[string]uint64, len(m.records))
+	for name, count := range m.records {
+		stats[name] = count
+	}
+	return stats
+}
+
+// GetTemplateVersionStats returns the number of records received from every
+// template version, if AggregationInput.TemplateVersions is given. Records
+// matching no version are counted under UnknownTemplateVersion.
+func (a *AggregationProcess) GetTemplateVersionStats() map[string]uint64 {
+	if a.templateVersions == nil {
+		return nil
+	}
+	return a.templateVersions.getStats()
+}
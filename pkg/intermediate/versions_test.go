@@ -0,0 +1,105 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestTemplateVersions(t *testing.T) {
+	_, err := InitAggregationProcess(AggregationInput{
+		MessageChan:      make(chan *entities.Message),
+		WorkerNum:        1,
+		TemplateVersions: &TemplateVersions{CommonElements: []string{"unknownElement"}},
+	})
+	assert.Error(t, err)
+
+	ap, err := InitAggregationProcess(AggregationInput{
+		MessageChan:     make(chan *entities.Message),
+		WorkerNum:       1,
+		CorrelateFields: fields,
+		TemplateVersions: &TemplateVersions{
+			Versions: []TemplateVersion{
+				{Name: "v2", Elements: []string{"egressNetworkPolicyRuleAction"}},
+				{Name: "v1", Renames: map[string]string{"podName": "sourcePodName"}},
+			},
+			CommonElements: []string{"egressNetworkPolicyRuleAction"},
+		},
+	})
+	assert.NoError(t, err)
+
+	// Records of the new version are aggregated as is.
+	message := createDataMsgForSrc(t, false, false, false, false, false)
+	assert.NoError(t, ap.AggregateMsgByFlowKey(message))
+	assert.Len(t, ap.flowKeyRecordMap, 1)
+	for _, flowRecord := range ap.flowKeyRecordMap {
+		assert.Equal(t, message.GetSet().GetRecords()[0], flowRecord.Record)
+	}
+
+	// Records of the old version are mapped to the common schema.
+	set := entities.NewSet(true)
+	assert.NoError(t, set.PrepareSet(entities.Data, testTemplateID))
+	sourceAddress, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	podName := entities.NewInfoElement("podName", 200, entities.String, registry.AntreaEnterpriseID, entities.VariableLength)
+	assert.NoError(t, set.AddRecord([]*entities.InfoElementWithValue{
+		entities.NewInfoElementWithValue(sourceAddress, bytes.NewBuffer([]byte{10, 0, 0, 1})),
+		entities.NewInfoElementWithValue(podName, bytes.NewBufferString("pod1")),
+	}, testTemplateID))
+	record := set.GetRecords()[0]
+	mapped, err := ap.templateVersions.mapRecord(record)
+	assert.NoError(t, err)
+	names := make([]string, 0)
+	for _, ie := range mapped.GetOrderedElementList() {
+		names = append(names, ie.Element.Name)
+	}
+	assert.Equal(t, []string{"sourceIPv4Address", "sourcePodName", "egressNetworkPolicyRuleAction"}, names)
+	ie, _ := mapped.GetInfoElementWithValue("sourcePodName")
+	assert.Equal(t, "pod1", ie.Value)
+	assert.Equal(t, uint16(101), ie.Element.ElementId)
+	ie, _ = mapped.GetInfoElementWithValue("egressNetworkPolicyRuleAction")
+	assert.Equal(t, uint8(0), ie.Value)
+	// The original record is unchanged.
+	_, exist := record.GetInfoElementWithValue("podName")
+	assert.True(t, exist)
+
+	assert.Equal(t, map[string]uint64{"v2": 1, "v1": 1}, ap.GetTemplateVersionStats())
+}
+
+func TestTemplateVersionsUnknown(t *testing.T) {
+	mapper, err := newTemplateVersionMapper(TemplateVersions{
+		Versions: []TemplateVersion{{Name: "v2", Elements: []string{"egressNetworkPolicyRuleAction"}}},
+	})
+	assert.NoError(t, err)
+	set := entities.NewSet(true)
+	assert.NoError(t, set.PrepareSet(entities.Data, testTemplateID))
+	sourceAddress, _ := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	assert.NoError(t, set.AddRecord([]*entities.InfoElementWithValue{
+		entities.NewInfoElementWithValue(sourceAddress, bytes.NewBuffer([]byte{10, 0, 0, 1})),
+	}, testTemplateID))
+	record := set.GetRecords()[0]
+	mapped, err := mapper.mapRecord(record)
+	assert.NoError(t, err)
+	assert.Equal(t, record, mapped)
+	assert.Equal(t, map[string]uint64{UnknownTemplateVersion: 1}, mapper.getStats())
+
+	_, err = newTemplateVersionMapper(TemplateVersions{Versions: []TemplateVersion{{}}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// WarmUpStatus is the state of the warm-up window of the aggregation process,
+// e.g. to be reported by a health endpoint.
+type WarmUpStatus struct {
+	// InProgress is true until the end of the warm-up window.
+	InProgress bool
+	// Remaining is the time left until the end of the warm-up window.
+	Remaining time.Duration
+	// SuppressedExports is the number of times the export of a flow waiting
+	// for the record from the peer node was postponed to the end of a warm-up
+	// window.
+	SuppressedExports uint64
+}
+
+// GetWarmUpStatus returns the state of the warm-up window.
+func (a *AggregationProcess) GetWarmUpStatus() WarmUpStatus {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	status := WarmUpStatus{
+		SuppressedExports: a.warmUpSuppressedExports,
+	}
+	if remaining := time.Until(a.warmUpEndTime); remaining > 0 {
+		status.InProgress = true
+		status.Remaining = remaining
+	}
+	return status
+}
+
+// startWarmUp starts a new warm-up window, e.g. when a standby aggregator
+// restored from the replicated flow state becomes active.
+func (a *AggregationProcess) startWarmUp() {
+	if a.warmUpPeriod == 0 {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.warmUpEndTime = time.Now().Add(a.warmUpPeriod)
+	klog.Infof("Aggregation process is warming up until %v", a.warmUpEndTime)
+}
+
+// isWarmingUp returns true during the warm-up window. This should be called
+// after acquiring the mutex.
+func (a *AggregationProcess) isWarmingUp(currTime time.Time) bool {
+	return currTime.Before(a.warmUpEndTime)
+}
+
+// postponeExpiryForWarmUp postpones the inactive expiry and the correlation
+// timeout of a flow waiting for the record from the peer node to the end of
+// the warm-up window, as the peer record may still be in flight from an
+// exporter reconnecting to the aggregator. This should be called after
+// acquiring the mutex.
+func (a *AggregationProcess) postponeExpiryForWarmUp(pqItem *ItemToExpire, currTime time.Time) {
+	if pqItem.inactiveExpireTime.Before(a.warmUpEndTime) {
+		pqItem.inactiveExpireTime = a.warmUpEndTime
+	}
+	if !pqItem.correlationExpireTime.IsZero() && pqItem.correlationExpireTime.Before(a.warmUpEndTime) {
+		pqItem.correlationExpireTime = a.warmUpEndTime
+	}
+	pqItem.activeExpireTime = currTime.Add(a.activeExpiryTimeout)
+	a.warmUpSuppressedExports++
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestWarmUpPostponesUncorrelatedExports(t *testing.T) {
+	messageChan := make(chan *entities.Message)
+	input := AggregationInput{
+		MessageChan:           messageChan,
+		WorkerNum:             2,
+		CorrelateFields:       fields,
+		ActiveExpiryTimeout:   testActiveExpiry,
+		InactiveExpiryTimeout: 10 * testInactiveExpiry,
+		CorrelationTimeout:    testActiveExpiry,
+		WarmUpPeriod:          4 * testActiveExpiry,
+	}
+	ap, _ := InitAggregationProcess(input)
+	status := ap.GetWarmUpStatus()
+	assert.True(t, status.InProgress)
+	assert.True(t, status.Remaining > 0 && status.Remaining <= 4*testActiveExpiry)
+
+	record := createDataMsgForSrc(t, false, false, false, false, false).GetSet().GetRecords()[0]
+	flowKey, _ := getFlowKeyFromRecord(record)
+	assert.NoError(t, ap.addOrUpdateRecordInMap(flowKey, record))
+	var exported []AggregationFlowRecord
+	testCallback := func(key FlowKey, record AggregationFlowRecord) error {
+		exported = append(exported, record)
+		return nil
+	}
+	// The correlation timeout elapsed during the warm-up window, so the
+	// record keeps waiting for the record from the destination node.
+	time.Sleep(2 * testActiveExpiry)
+	assert.NoError(t, ap.ForAllExpiredFlowRecordsDo(testCallback))
+	assert.Empty(t, exported)
+	assert.Equal(t, 1, ap.expirePriorityQueue.Len())
+	assert.Equal(t, ap.warmUpEndTime, ap.expirePriorityQueue[0].correlationExpireTime)
+	assert.Equal(t, uint64(1), ap.GetWarmUpStatus().SuppressedExports)
+
+	// The record is exported uncorrelated once the window has ended.
+	time.Sleep(3 * testActiveExpiry)
+	assert.False(t, ap.GetWarmUpStatus().InProgress)
+	assert.NoError(t, ap.ForAllExpiredFlowRecordsDo(testCallback))
+	assert.Len(t, exported, 1)
+	ieWithValue, _ := exported[0].Record.GetInfoElementWithValue("uncorrelatedReason")
+	assert.Equal(t, registry.UncorrelatedReasonCorrelationTimeout, ieWithValue.Value)
+}
+
+func TestWarmUpRestartsOnActivation(t *testing.T) {
+	ap, _ := InitAggregationProcess(AggregationInput{
+		MessageChan:  make(chan *entities.Message),
+		WorkerNum:    1,
+		WarmUpPeriod: time.Minute,
+	})
+	ap.warmUpEndTime = time.Now()
+	assert.False(t, ap.GetWarmUpStatus().InProgress)
+	ap.startWarmUp()
+	assert.True(t, ap.GetWarmUpStatus().InProgress)
+
+	// Without a warm-up period, there is no window.
+	ap, _ = InitAggregationProcess(AggregationInput{
+		MessageChan: make(chan *entities.Message),
+		WorkerNum:   1,
+	})
+	ap.startWarmUp()
+	assert.Equal(t, WarmUpStatus{}, ap.GetWarmUpStatus())
+}
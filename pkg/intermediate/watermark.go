@@ -0,0 +1,54 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// addWatermarkFields adds aggregatorInstanceId and exportedRecordSequence to a
+// new record in the map. The sequence is set when the record is exported.
+func addWatermarkFields(record entities.Record, instanceID string) error {
+	ie, err := registry.GetInfoElement("aggregatorInstanceId", registry.AntreaEnterpriseID)
+	if err != nil {
+		return err
+	}
+	if _, err = record.AddInfoElement(entities.NewInfoElementWithValue(ie, bytes.NewBufferString(instanceID)), true); err != nil {
+		return err
+	}
+	ie, err = registry.GetInfoElement("exportedRecordSequence", registry.AntreaEnterpriseID)
+	if err != nil {
+		return err
+	}
+	_, err = record.AddInfoElement(entities.NewInfoElementWithValue(ie, uint64(0)), false)
+	return err
+}
+
+// stampExportedRecord sets the exportedRecordSequence of a record passed to the
+// callback of ForAllExpiredFlowRecordsDo to the next sequence number. This
+// should be called after acquiring the mutex.
+func (a *AggregationProcess) stampExportedRecord(record entities.Record) error {
+	ie, exist := record.GetInfoElementWithValue("exportedRecordSequence")
+	if !exist {
+		return fmt.Errorf("exportedRecordSequence is not present in the record")
+	}
+	a.exportedRecordSequence++
+	ie.Value = a.exportedRecordSequence
+	return nil
+}
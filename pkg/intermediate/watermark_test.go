@@ -0,0 +1,67 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intermediate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+func TestForAllExpiredFlowRecordsDoWithWatermark(t *testing.T) {
+	messageChan := make(chan *entities.Message)
+	input := AggregationInput{
+		MessageChan:           messageChan,
+		WorkerNum:             2,
+		CorrelateFields:       fields,
+		ActiveExpiryTimeout:   testActiveExpiry,
+		InactiveExpiryTimeout: testInactiveExpiry,
+		InstanceID:            "aggregator-1",
+	}
+	ap, _ := InitAggregationProcess(input)
+	for _, isIPv6 := range []bool{false, true} {
+		record := createDataMsgForSrc(t, isIPv6, true, false, false, false).GetSet().GetRecords()[0]
+		flowKey, _ := getFlowKeyFromRecord(record)
+		assert.NoError(t, ap.addOrUpdateRecordInMap(flowKey, record))
+		assert.Equal(t, "aggregator-1", getValue(record, "aggregatorInstanceId"))
+		assert.Equal(t, uint64(0), getValue(record, "exportedRecordSequence"))
+	}
+
+	var sequences []uint64
+	testCallback := func(key FlowKey, record AggregationFlowRecord) error {
+		sequences = append(sequences, getValue(record.Record, "exportedRecordSequence").(uint64))
+		return nil
+	}
+	time.Sleep(testInactiveExpiry)
+	assert.NoError(t, ap.ForAllExpiredFlowRecordsDo(testCallback))
+	assert.Equal(t, []uint64{1, 2}, sequences)
+}
+
+func TestAddOrUpdateRecordInMapWithoutWatermark(t *testing.T) {
+	input := AggregationInput{
+		MessageChan:     make(chan *entities.Message),
+		WorkerNum:       2,
+		CorrelateFields: fields,
+	}
+	ap, _ := InitAggregationProcess(input)
+	record := createDataMsgForSrc(t, false, true, false, false, false).GetSet().GetRecords()[0]
+	flowKey, _ := getFlowKeyFromRecord(record)
+	assert.NoError(t, ap.addOrUpdateRecordInMap(flowKey, record))
+	_, exist := record.GetInfoElementWithValue("exportedRecordSequence")
+	assert.False(t, exist)
+}
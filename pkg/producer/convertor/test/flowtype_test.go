@@ -294,3 +294,36 @@ func TestKafkaProducer_Publish(t *testing.T) {
 		})
 	}
 }
+
+func TestKafkaProducer_WriteAckedBatch(t *testing.T) {
+	kafkaConfig := sarama.NewConfig()
+	kafkaConfig.Version = producer.KafkaConfigVersion
+	kafkaConfig.Producer.Return.Successes = true
+	kafkaConfig.Producer.Return.Errors = true
+
+	mockProducer := saramamock.NewAsyncProducer(t, kafkaConfig)
+	kafkaProducer := producer.NewKafkaProducer(mockProducer, "test-flow-msgs", FlowType1)
+	assert.Error(t, kafkaProducer.WriteAckedBatch([]*entities.Message{createMsgwithDataSet(t, false)}, []uint64{1}, func(uint64, error) {}))
+	assert.NoError(t, mockProducer.Close())
+
+	mockProducer = saramamock.NewAsyncProducer(t, kafkaConfig)
+	kafkaProducer = producer.NewKafkaProducerWithAcks(mockProducer, "test-flow-msgs", FlowType1)
+	mockProducer.ExpectInputAndSucceed()
+	mockProducer.ExpectInputAndFail(sarama.ErrOutOfBrokers)
+	type ack struct {
+		id  uint64
+		err error
+	}
+	ackCh := make(chan ack, 2)
+	err := kafkaProducer.WriteAckedBatch([]*entities.Message{createMsgwithDataSet(t, false), createMsgwithDataSet(t, true)}, []uint64{1, 2}, func(id uint64, err error) {
+		ackCh <- ack{id, err}
+	})
+	assert.NoError(t, err)
+	acks := map[uint64]error{}
+	for i := 0; i < 2; i++ {
+		a := <-ackCh
+		acks[a.id] = a.err
+	}
+	assert.Equal(t, map[uint64]error{1: nil, 2: sarama.ErrOutOfBrokers}, acks)
+	assert.NoError(t, kafkaProducer.Close())
+}
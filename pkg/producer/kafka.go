@@ -16,6 +16,8 @@ package producer
 
 import (
 	"encoding/binary"
+	"fmt"
+	"sync"
 
 	"github.com/Shopify/sarama"
 	"google.golang.org/protobuf/proto"
@@ -24,18 +26,25 @@ import (
 	"github.com/vmware/go-ipfix/pkg/entities"
 	"github.com/vmware/go-ipfix/pkg/producer/convertor"
 	"github.com/vmware/go-ipfix/pkg/producer/protobuf"
+	"github.com/vmware/go-ipfix/pkg/sink"
 )
 
 var (
 	KafkaConfigVersion sarama.KafkaVersion
 )
 
+// KafkaProducer is a sink.Sink, so that it can be written by a sink.Pipeline.
 type KafkaProducer struct {
 	producer             sarama.AsyncProducer
 	topic                string
 	protoSchemaConvertor convertor.IPFIXToKafkaConvertor
+	// acks is true if the producer reports the delivery of the messages
+	// written with WriteAckedBatch.
+	acks bool
 }
 
+var _ sink.AckSink = &KafkaProducer{}
+
 func NewKafkaProducer(asyncProducer sarama.AsyncProducer, topic string, schemaType string) *KafkaProducer {
 	return &KafkaProducer{
 		producer:             asyncProducer,
@@ -69,14 +78,105 @@ func InitKafkaProducer(addrs []string, topic string, protoSchema string, logErro
 	return producer, nil
 }
 
+// NewKafkaProducerWithAcks returns a producer which reports the delivery of the
+// messages written with WriteAckedBatch, once all their Kafka messages are
+// acknowledged by the brokers. The successes and errors of asyncProducer are
+// consumed by the producer, so Producer.Return.Successes and
+// Producer.Return.Errors must be enabled in its config.
+func NewKafkaProducerWithAcks(asyncProducer sarama.AsyncProducer, topic string, schemaType string) *KafkaProducer {
+	producer := NewKafkaProducer(asyncProducer, topic, schemaType)
+	producer.acks = true
+	go producer.handleAcks()
+	return producer
+}
+
+// InitKafkaProducerWithAcks is InitKafkaProducer for a producer created with
+// NewKafkaProducerWithAcks. Messages are acknowledged by the brokers once all
+// the in-sync replicas received them.
+func InitKafkaProducerWithAcks(addrs []string, topic string, protoSchema string) (*KafkaProducer, error) {
+	kafkaConfig := sarama.NewConfig()
+	kafkaConfig.Version = KafkaConfigVersion
+	kafkaConfig.Producer.RequiredAcks = sarama.WaitForAll
+	kafkaConfig.Producer.Return.Successes = true
+	kafkaConfig.Producer.Return.Errors = true
+
+	asyncProducer, err := sarama.NewAsyncProducer(addrs, kafkaConfig)
+	if err != nil {
+		return nil, err
+	}
+	return NewKafkaProducerWithAcks(asyncProducer, topic, protoSchema), nil
+}
+
+// kafkaDelivery tracks the delivery of the Kafka messages of an IPFIX message
+// written with WriteAckedBatch. It is the metadata of the Kafka messages.
+type kafkaDelivery struct {
+	mutex   sync.Mutex
+	id      uint64
+	ack     sink.AckFunc
+	pending int
+	done    bool
+}
+
+// report acknowledges the IPFIX message once all its Kafka messages are
+// delivered, or as soon as one of them fails.
+func (d *kafkaDelivery) report(err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.done {
+		return
+	}
+	if err == nil {
+		d.pending--
+		if d.pending > 0 {
+			return
+		}
+	}
+	d.done = true
+	d.ack(d.id, err)
+}
+
+// handleAcks reports the successes and errors of the producer until it is
+// closed.
+func (kp *KafkaProducer) handleAcks() {
+	successes := kp.producer.Successes()
+	errors := kp.producer.Errors()
+	for successes != nil || errors != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			if delivery, ok := msg.Metadata.(*kafkaDelivery); ok {
+				delivery.report(nil)
+			}
+		case producerErr, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			klog.Error(producerErr)
+			if delivery, ok := producerErr.Msg.Metadata.(*kafkaDelivery); ok {
+				delivery.report(producerErr.Err)
+			}
+		}
+	}
+}
+
 // SendFlowMessage takes in the flow message in proto schema, encodes it and sends
 // it to on the producer channel. If kafkaDelimitMsgWithLen is set to true, it will
 // return  a length-prefixed encoded message.
 func (kp *KafkaProducer) SendFlowMessage(msg *protobuf.FlowMessage, kafkaDelimitMsgWithLen bool) {
+	kp.sendFlowMessage(msg, kafkaDelimitMsgWithLen, nil)
+}
+
+// sendFlowMessage sends the flow message with the metadata, and returns false
+// if the message cannot be encoded.
+func (kp *KafkaProducer) sendFlowMessage(msg *protobuf.FlowMessage, kafkaDelimitMsgWithLen bool, metadata interface{}) bool {
 	bytes, err := proto.Marshal(msg)
 	if err != nil {
 		klog.Errorf("Error when encoding flow message: %v", err)
-		return
+		return false
 	}
 	if kafkaDelimitMsgWithLen {
 		b := make([]byte, 4)
@@ -85,9 +185,11 @@ func (kp *KafkaProducer) SendFlowMessage(msg *protobuf.FlowMessage, kafkaDelimit
 	}
 
 	kp.producer.Input() <- &sarama.ProducerMessage{
-		Topic: kp.topic,
-		Value: sarama.ByteEncoder(bytes),
+		Topic:    kp.topic,
+		Value:    sarama.ByteEncoder(bytes),
+		Metadata: metadata,
 	}
+	return true
 }
 
 // Publish takes in a message channel as input and converts all the messages on
@@ -95,9 +197,58 @@ func (kp *KafkaProducer) SendFlowMessage(msg *protobuf.FlowMessage, kafkaDelimit
 // the input message channel is closed.
 func (kp *KafkaProducer) Publish(msgCh chan *entities.Message) {
 	for msg := range msgCh {
+		kp.WriteBatch([]*entities.Message{msg})
+	}
+}
+
+// Open does nothing, as the producer connects to the brokers when it is
+// created.
+func (kp *KafkaProducer) Open() error {
+	return nil
+}
+
+// WriteBatch converts the messages to flow messages in proto schema, and sends
+// them length-prefixed on the producer channel. Errors of the producer are
+// returned asynchronously by sarama, so WriteBatch never fails.
+func (kp *KafkaProducer) WriteBatch(messages []*entities.Message) error {
+	for _, msg := range messages {
 		flowMsgs := kp.protoSchemaConvertor.ConvertIPFIXMsgToFlowMsgs(msg)
 		for _, flowMsg := range flowMsgs {
 			kp.SendFlowMessage(flowMsg, true)
 		}
 	}
+	return nil
+}
+
+// WriteAckedBatch is WriteBatch for producers created with
+// NewKafkaProducerWithAcks. Flow messages which cannot be encoded are dropped
+// as by WriteBatch, as writing them again would fail too.
+func (kp *KafkaProducer) WriteAckedBatch(messages []*entities.Message, ids []uint64, ack sink.AckFunc) error {
+	if !kp.acks {
+		return fmt.Errorf("acknowledgements are not enabled for the Kafka producer")
+	}
+	for i, msg := range messages {
+		flowMsgs := kp.protoSchemaConvertor.ConvertIPFIXMsgToFlowMsgs(msg)
+		// The delivery waits for one more report until all the flow messages
+		// are sent, as they may be acknowledged right away, and so that
+		// messages without flow messages are acknowledged too.
+		delivery := &kafkaDelivery{id: ids[i], ack: ack, pending: len(flowMsgs) + 1}
+		for _, flowMsg := range flowMsgs {
+			if !kp.sendFlowMessage(flowMsg, true, delivery) {
+				delivery.report(nil)
+			}
+		}
+		delivery.report(nil)
+	}
+	return nil
+}
+
+// Flush does nothing, as the producer sends messages in the background.
+func (kp *KafkaProducer) Flush() error {
+	return nil
+}
+
+// Close sends the buffered messages and closes the producer.
+func (kp *KafkaProducer) Close() error {
+	return kp.producer.Close()
 }
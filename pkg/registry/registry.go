@@ -16,7 +16,6 @@ package registry
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/vmware/go-ipfix/pkg/entities"
 )
@@ -53,6 +52,25 @@ const (
 	PolicyTypeAntreaClusterNetworkPolicy = uint8(3)
 )
 
+// enum for uncorrelatedReason field in Antrea registry.
+const (
+	UncorrelatedReasonNone               = uint8(0)
+	UncorrelatedReasonCorrelationTimeout = uint8(1)
+	UncorrelatedReasonInactiveTimeout    = uint8(2)
+)
+
+// enum for flowAggregationStatus field in Antrea registry. Records of flows that
+// do not need correlation are reported as correlated. Catch-all records of the
+// flows exceeding the cardinality limits of the aggregation process are
+// reported as cardinality overflow.
+const (
+	FlowAggregationStatusCorrelated          = uint8(1)
+	FlowAggregationStatusSourceOnly          = uint8(2)
+	FlowAggregationStatusDestinationOnly     = uint8(3)
+	FlowAggregationStatusTimedOut            = uint8(4)
+	FlowAggregationStatusCardinalityOverflow = uint8(5)
+)
+
 // placeholder of NetworkPolicyRulePriority for K8s Network Policy.
 const (
 	K8sNetworkPolicyRulePriority = int32(-1)
@@ -140,8 +158,22 @@ func getIANAReverseInfoElement(name string) (*entities.InfoElement, error) {
 		err := fmt.Errorf("IANA Registry: The information element %s is not reverse element", name)
 		return ie, err
 	}
-	reverseName := "reverse" + strings.Title(ie.Name)
-	return entities.NewInfoElement(reverseName, ie.ElementId, ie.DataType, IANAReversedEnterpriseID, ie.Len), nil
+	return newReverseInfoElement(ie), nil
+}
+
+// newReverseInfoElement returns the reverse element of the IANA element
+// (RFC5103 section 6.1).
+func newReverseInfoElement(ie *entities.InfoElement) *entities.InfoElement {
+	return entities.NewInfoElement(entities.ReverseInfoElementName(ie.Name), ie.ElementId, ie.DataType, IANAReversedEnterpriseID, ie.Len)
+}
+
+// GetReverseInfoElement returns the reverse element of the IANA element with
+// the given name, e.g. reversePacketTotalCount for packetTotalCount.
+func GetReverseInfoElement(name string) (*entities.InfoElement, error) {
+	if !isReversible(name) {
+		return nil, fmt.Errorf("Information element %s has no reverse element", name)
+	}
+	return GetInfoElement(entities.ReverseInfoElementName(name), IANAReversedEnterpriseID)
 }
 
 // Non-reversible Information Elements follow Section 6.1 of RFC5103
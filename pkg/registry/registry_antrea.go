@@ -64,4 +64,23 @@ func loadAntreaRegistry() {
 	registerInfoElement(*entities.NewInfoElement("egressNetworkPolicyRuleAction", 140, 1, 56506, 1), 56506)
 	registerInfoElement(*entities.NewInfoElement("ingressNetworkPolicyRuleName", 141, 13, 56506, 65535), 56506)
 	registerInfoElement(*entities.NewInfoElement("egressNetworkPolicyRuleName", 142, 13, 56506, 65535), 56506)
+	registerInfoElement(*entities.NewInfoElement("uncorrelatedReason", 143, 1, 56506, 1), 56506)
+	registerInfoElement(*entities.NewInfoElement("flowAggregationStatus", 144, 1, 56506, 1), 56506)
+	registerInfoElement(*entities.NewInfoElement("sourceGroupName", 145, 13, 56506, 65535), 56506)
+	registerInfoElement(*entities.NewInfoElement("destinationGroupName", 146, 13, 56506, 65535), 56506)
+	registerInfoElement(*entities.NewInfoElement("anomalyScore", 147, 10, 56506, 8), 56506)
+	registerInfoElement(*entities.NewInfoElement("exportedRecordSequence", 148, 4, 56506, 8), 56506)
+	registerInfoElement(*entities.NewInfoElement("aggregatorInstanceId", 149, 13, 56506, 65535), 56506)
+	registerInfoElement(*entities.NewInfoElement("sourcePodLabels", 150, 13, 56506, 65535), 56506)
+	registerInfoElement(*entities.NewInfoElement("destinationPodLabels", 151, 13, 56506, 65535), 56506)
+	registerInfoElement(*entities.NewInfoElement("latencyProbeSequence", 152, 4, 56506, 8), 56506)
+	registerInfoElement(*entities.NewInfoElement("latencyProbeSendTime", 153, 4, 56506, 8), 56506)
+	registerInfoElement(*entities.NewInfoElement("exporterChainAddresses", 154, 20, 56506, 65535), 56506)
+	registerInfoElement(*entities.NewInfoElement("throughput", 155, 4, 56506, 8), 56506)
+	registerInfoElement(*entities.NewInfoElement("reverseThroughput", 156, 4, 56506, 8), 56506)
+	registerInfoElement(*entities.NewInfoElement("throughputFromSourceNode", 157, 4, 56506, 8), 56506)
+	registerInfoElement(*entities.NewInfoElement("throughputFromDestinationNode", 158, 4, 56506, 8), 56506)
+	registerInfoElement(*entities.NewInfoElement("reverseThroughputFromSourceNode", 159, 4, 56506, 8), 56506)
+	registerInfoElement(*entities.NewInfoElement("reverseThroughputFromDestinationNode", 160, 4, 56506, 8), 56506)
+	registerInfoElement(*entities.NewInfoElement("exporterChainObservationDomainIds", 161, 20, 56506, 65535), 56506)
 }
@@ -59,6 +59,29 @@ func TestGetIANAReverseIE(t *testing.T) {
 	assert.Equal(t, IANAReversedEnterpriseID, reverseIE.EnterpriseId, "GetIANAReverseIE does not return correct reverse ie.")
 }
 
+func TestGetReverseInfoElement(t *testing.T) {
+	reverseIE, err := GetReverseInfoElement("packetTotalCount")
+	assert.NoError(t, err)
+	assert.Equal(t, "reversePacketTotalCount", reverseIE.Name)
+	assert.Equal(t, IANAReversedEnterpriseID, reverseIE.EnterpriseId)
+	assert.Equal(t, uint16(86), reverseIE.ElementId)
+	_, err = GetReverseInfoElement("flowKeyIndicator")
+	assert.Error(t, err)
+	_, err = GetReverseInfoElement("sourcePodName")
+	assert.Error(t, err)
+
+	// IANA elements registered in a session redefine their reverse elements.
+	sessionRegistry := NewSessionRegistry()
+	sessionRegistry.RegisterInfoElement(entities.NewInfoElement("customCounter", 2, entities.Unsigned32, IANAEnterpriseID, 4), 0)
+	reverseIE, err = sessionRegistry.GetInfoElementFromID(2, IANAReversedEnterpriseID)
+	assert.NoError(t, err)
+	assert.Equal(t, "reverseCustomCounter", reverseIE.Name)
+	assert.Equal(t, entities.Unsigned32, reverseIE.DataType)
+	reverseIE, err = NewSessionRegistry().GetInfoElementFromID(2, IANAReversedEnterpriseID)
+	assert.NoError(t, err)
+	assert.Equal(t, "reversePacketDeltaCount", reverseIE.Name)
+}
+
 func TestGetInfoElementFromID(t *testing.T) {
 	// InfoElement does not exist
 	_, err := GetInfoElementFromID(1, 1)
@@ -0,0 +1,113 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// SessionRegistry contains Information Elements that are only known within a
+// transport session, e.g. elements learned from Information Element Type
+// Records (RFC5610). Lookups fall back to the global registry.
+type SessionRegistry struct {
+	mutex sync.RWMutex
+	// elementsByID shows mapping EnterpriseID -> Info Element ID -> Info Element
+	elementsByID map[uint32]map[uint16]*entities.InfoElement
+	// elementsByName shows mapping EnterpriseID -> Info Element name -> Info Element
+	elementsByName map[uint32]map[string]*entities.InfoElement
+	// semantics shows mapping EnterpriseID -> Info Element ID -> informationElementSemantics
+	semantics map[uint32]map[uint16]uint8
+}
+
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{
+		elementsByID:   make(map[uint32]map[uint16]*entities.InfoElement),
+		elementsByName: make(map[uint32]map[string]*entities.InfoElement),
+		semantics:      make(map[uint32]map[uint16]uint8),
+	}
+}
+
+// RegisterInfoElement adds the element to the session registry. An element
+// registered earlier with the same ID is replaced, as exporters resend type
+// records periodically and may redefine them.
+func (r *SessionRegistry) RegisterInfoElement(ie *entities.InfoElement, semantics uint8) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, exist := r.elementsByID[ie.EnterpriseId]; !exist {
+		r.elementsByID[ie.EnterpriseId] = make(map[uint16]*entities.InfoElement)
+		r.elementsByName[ie.EnterpriseId] = make(map[string]*entities.InfoElement)
+		r.semantics[ie.EnterpriseId] = make(map[uint16]uint8)
+	}
+	if old, exist := r.elementsByID[ie.EnterpriseId][ie.ElementId]; exist {
+		delete(r.elementsByName[ie.EnterpriseId], old.Name)
+	}
+	r.elementsByID[ie.EnterpriseId][ie.ElementId] = ie
+	r.elementsByName[ie.EnterpriseId][ie.Name] = ie
+	r.semantics[ie.EnterpriseId][ie.ElementId] = semantics
+}
+
+func (r *SessionRegistry) GetInfoElementFromID(elementID uint16, enterpriseID uint32) (*entities.InfoElement, error) {
+	if element, exist := r.GetSessionInfoElementFromID(elementID, enterpriseID); exist {
+		return element, nil
+	}
+	// IANA elements registered in the session redefine their reverse
+	// elements too.
+	if enterpriseID == IANAReversedEnterpriseID {
+		if element, exist := r.GetSessionInfoElementFromID(elementID, IANAEnterpriseID); exist && isReversible(element.Name) {
+			return newReverseInfoElement(element), nil
+		}
+	}
+	return GetInfoElementFromID(elementID, enterpriseID)
+}
+
+// GetSessionInfoElementFromID returns the element only if it is registered in
+// the session, without falling back to the global registry.
+func (r *SessionRegistry) GetSessionInfoElementFromID(elementID uint16, enterpriseID uint32) (*entities.InfoElement, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	element, exist := r.elementsByID[enterpriseID][elementID]
+	return element, exist
+}
+
+func (r *SessionRegistry) GetInfoElement(name string, enterpriseID uint32) (*entities.InfoElement, error) {
+	r.mutex.RLock()
+	element, exist := r.elementsByName[enterpriseID][name]
+	r.mutex.RUnlock()
+	if exist {
+		return element, nil
+	}
+	return GetInfoElement(name, enterpriseID)
+}
+
+// GetInfoElementSemantics returns the informationElementSemantics value of an
+// element registered in the session.
+func (r *SessionRegistry) GetInfoElementSemantics(elementID uint16, enterpriseID uint32) (uint8, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if semantics, exist := r.semantics[enterpriseID][elementID]; exist {
+		return semantics, nil
+	}
+	return 0, fmt.Errorf("Information Element with elementID %d and enterpriseID %d is not registered in the session.", elementID, enterpriseID)
+}
+
+// IsConflicting returns true if the two elements share the same ID but have
+// different names or data types.
+func IsConflicting(ie1, ie2 *entities.InfoElement) bool {
+	return ie1.EnterpriseId == ie2.EnterpriseId && ie1.ElementId == ie2.ElementId &&
+		(ie1.Name != ie2.Name || ie1.DataType != ie2.DataType)
+}
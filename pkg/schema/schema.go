@@ -0,0 +1,220 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package schema generates the schemas of sinks storing flow records, e.g. SQL
+// tables or Parquet files, from the elements of templates, so that sinks can
+// be set up, or follow template changes, without maintaining the schemas by
+// hand.
+package schema
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// Dialect is the SQL dialect of the generated statements.
+type Dialect uint8
+
+const (
+	ClickHouse Dialect = iota
+	PostgreSQL
+)
+
+const defaultClickHouseEngine = "MergeTree() ORDER BY tuple()"
+
+var clickHouseTypes = map[entities.IEDataType]string{
+	entities.OctetArray:           "String",
+	entities.Unsigned8:            "UInt8",
+	entities.Unsigned16:           "UInt16",
+	entities.Unsigned32:           "UInt32",
+	entities.Unsigned64:           "UInt64",
+	entities.Signed8:              "Int8",
+	entities.Signed16:             "Int16",
+	entities.Signed32:             "Int32",
+	entities.Signed64:             "Int64",
+	entities.Float32:              "Float32",
+	entities.Float64:              "Float64",
+	entities.Boolean:              "Bool",
+	entities.MacAddress:           "String",
+	entities.String:               "String",
+	entities.DateTimeSeconds:      "DateTime",
+	entities.DateTimeMilliseconds: "DateTime64(3)",
+	entities.DateTimeMicroseconds: "DateTime64(6)",
+	entities.DateTimeNanoseconds:  "DateTime64(9)",
+	entities.Ipv4Address:          "IPv4",
+	entities.Ipv6Address:          "IPv6",
+}
+
+// PostgreSQL has no unsigned types, so unsigned integers use the next larger
+// signed type.
+var postgreSQLTypes = map[entities.IEDataType]string{
+	entities.OctetArray:           "BYTEA",
+	entities.Unsigned8:            "SMALLINT",
+	entities.Unsigned16:           "INTEGER",
+	entities.Unsigned32:           "BIGINT",
+	entities.Unsigned64:           "NUMERIC(20)",
+	entities.Signed8:              "SMALLINT",
+	entities.Signed16:             "SMALLINT",
+	entities.Signed32:             "INTEGER",
+	entities.Signed64:             "BIGINT",
+	entities.Float32:              "REAL",
+	entities.Float64:              "DOUBLE PRECISION",
+	entities.Boolean:              "BOOLEAN",
+	entities.MacAddress:           "MACADDR",
+	entities.String:               "TEXT",
+	entities.DateTimeSeconds:      "TIMESTAMPTZ",
+	entities.DateTimeMilliseconds: "TIMESTAMPTZ",
+	entities.DateTimeMicroseconds: "TIMESTAMPTZ",
+	entities.DateTimeNanoseconds:  "TIMESTAMPTZ",
+	entities.Ipv4Address:          "INET",
+	entities.Ipv6Address:          "INET",
+}
+
+// parquetTypes are the primitive types and annotations of the Parquet message
+// schema. MAC and IP addresses are stored in their text form, and times as
+// timestamps, seconds being stored with millisecond precision.
+var parquetTypes = map[entities.IEDataType]string{
+	entities.OctetArray:           "binary %s",
+	entities.Unsigned8:            "int32 %s (INTEGER(8,false))",
+	entities.Unsigned16:           "int32 %s (INTEGER(16,false))",
+	entities.Unsigned32:           "int32 %s (INTEGER(32,false))",
+	entities.Unsigned64:           "int64 %s (INTEGER(64,false))",
+	entities.Signed8:              "int32 %s (INTEGER(8,true))",
+	entities.Signed16:             "int32 %s (INTEGER(16,true))",
+	entities.Signed32:             "int32 %s",
+	entities.Signed64:             "int64 %s",
+	entities.Float32:              "float %s",
+	entities.Float64:              "double %s",
+	entities.Boolean:              "boolean %s",
+	entities.MacAddress:           "binary %s (STRING)",
+	entities.String:               "binary %s (STRING)",
+	entities.DateTimeSeconds:      "int64 %s (TIMESTAMP(MILLIS,true))",
+	entities.DateTimeMilliseconds: "int64 %s (TIMESTAMP(MILLIS,true))",
+	entities.DateTimeMicroseconds: "int64 %s (TIMESTAMP(MICROS,true))",
+	entities.DateTimeNanoseconds:  "int64 %s (TIMESTAMP(NANOS,true))",
+	entities.Ipv4Address:          "binary %s (STRING)",
+	entities.Ipv6Address:          "binary %s (STRING)",
+}
+
+// Column is a column of a SQL table storing an element.
+type Column struct {
+	Name string
+	Type string
+}
+
+// TableInput describes the SQL table created by CreateTableStatement.
+type TableInput struct {
+	Dialect Dialect
+	Name    string
+	// Elements are the elements stored in the table, one per column.
+	Elements []*entities.InfoElement
+	// IfNotExists does not fail the statement if the table exists.
+	IfNotExists bool
+	// Engine is the ClickHouse table engine and its clauses. If empty, it is
+	// "MergeTree() ORDER BY tuple()".
+	Engine string
+}
+
+// GetColumns returns the SQL columns storing the elements in the dialect. It
+// returns an error for elements which cannot be stored, e.g. lists.
+func GetColumns(dialect Dialect, elements []*entities.InfoElement) ([]Column, error) {
+	types := clickHouseTypes
+	if dialect == PostgreSQL {
+		types = postgreSQLTypes
+	}
+	columns := make([]Column, len(elements))
+	for i, element := range elements {
+		columnType, ok := types[element.DataType]
+		if !ok {
+			return nil, fmt.Errorf("element %s with data type %d cannot be stored in a column", element.Name, element.DataType)
+		}
+		columns[i] = Column{element.Name, columnType}
+	}
+	return columns, nil
+}
+
+// CreateTableStatement returns the statement creating a SQL table with a
+// column for every element, named after the element.
+func CreateTableStatement(input TableInput) (string, error) {
+	columns, err := GetColumns(input.Dialect, input.Elements)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString("CREATE TABLE ")
+	if input.IfNotExists {
+		b.WriteString("IF NOT EXISTS ")
+	}
+	fmt.Fprintf(&b, "%s (\n", quoteIdentifier(input.Name))
+	for i, column := range columns {
+		fmt.Fprintf(&b, "  %s %s", quoteIdentifier(column.Name), column.Type)
+		if i < len(columns)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(")")
+	if input.Dialect == ClickHouse {
+		engine := input.Engine
+		if engine == "" {
+			engine = defaultClickHouseEngine
+		}
+		fmt.Fprintf(&b, " ENGINE = %s", engine)
+	}
+	b.WriteString(";")
+	return b.String(), nil
+}
+
+// ParquetSchema returns the Parquet message schema with a required field for
+// every element, named after the element.
+func ParquetSchema(name string, elements []*entities.InfoElement) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "message %s {\n", name)
+	for _, element := range elements {
+		fieldType, ok := parquetTypes[element.DataType]
+		if !ok {
+			return "", fmt.Errorf("element %s with data type %d cannot be stored in a Parquet field", element.Name, element.DataType)
+		}
+		fmt.Fprintf(&b, "  required "+fieldType+";\n", element.Name)
+	}
+	b.WriteString("}")
+	return b.String(), nil
+}
+
+// GetElements returns the elements with the given names from the registry, for
+// schemas of configured element lists rather than templates. Names are looked
+// up in the IANA registry, the Antrea registry and reverse IANA elements, in
+// that order.
+func GetElements(names []string) ([]*entities.InfoElement, error) {
+	elements := make([]*entities.InfoElement, len(names))
+	for i, name := range names {
+		var err error
+		for _, enterpriseID := range []uint32{registry.IANAEnterpriseID, registry.AntreaEnterpriseID, registry.IANAReversedEnterpriseID} {
+			if elements[i], err = registry.GetInfoElement(name, enterpriseID); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("element %s is not in the registry", name)
+		}
+	}
+	return elements, nil
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
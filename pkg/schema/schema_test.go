@@ -0,0 +1,100 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func init() {
+	registry.LoadRegistry()
+}
+
+var testElementNames = []string{
+	"sourceIPv4Address",
+	"sourceTransportPort",
+	"packetTotalCount",
+	"flowEndSeconds",
+	"sourcePodName",
+	"reversePacketTotalCount",
+}
+
+func TestCreateTableStatement(t *testing.T) {
+	elements, err := GetElements(testElementNames)
+	assert.NoError(t, err)
+
+	statement, err := CreateTableStatement(TableInput{
+		Dialect:  ClickHouse,
+		Name:     "flows",
+		Elements: elements,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `CREATE TABLE "flows" (
+  "sourceIPv4Address" IPv4,
+  "sourceTransportPort" UInt16,
+  "packetTotalCount" UInt64,
+  "flowEndSeconds" DateTime,
+  "sourcePodName" String,
+  "reversePacketTotalCount" UInt64
+) ENGINE = MergeTree() ORDER BY tuple();`, statement)
+
+	statement, err = CreateTableStatement(TableInput{
+		Dialect:     PostgreSQL,
+		Name:        "flows",
+		Elements:    elements,
+		IfNotExists: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `CREATE TABLE IF NOT EXISTS "flows" (
+  "sourceIPv4Address" INET,
+  "sourceTransportPort" INTEGER,
+  "packetTotalCount" NUMERIC(20),
+  "flowEndSeconds" TIMESTAMPTZ,
+  "sourcePodName" TEXT,
+  "reversePacketTotalCount" NUMERIC(20)
+);`, statement)
+}
+
+func TestParquetSchema(t *testing.T) {
+	elements, err := GetElements(testElementNames)
+	assert.NoError(t, err)
+
+	schema, err := ParquetSchema("flows", elements)
+	assert.NoError(t, err)
+	assert.Equal(t, `message flows {
+  required binary sourceIPv4Address (STRING);
+  required int32 sourceTransportPort (INTEGER(16,false));
+  required int64 packetTotalCount (INTEGER(64,false));
+  required int64 flowEndSeconds (TIMESTAMP(MILLIS,true));
+  required binary sourcePodName (STRING);
+  required int64 reversePacketTotalCount (INTEGER(64,false));
+}`, schema)
+}
+
+func TestUnsupportedElements(t *testing.T) {
+	element := entities.NewInfoElement("basicList", 291, entities.BasicList, registry.IANAEnterpriseID, entities.VariableLength)
+	_, err := CreateTableStatement(TableInput{Dialect: PostgreSQL, Name: "flows", Elements: []*entities.InfoElement{element}})
+	assert.Error(t, err)
+	_, err = ParquetSchema("flows", []*entities.InfoElement{element})
+	assert.Error(t, err)
+
+	_, err = GetElements([]string{"sourceIPv4Address", "unknownElement"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+const defaultAckTimeout = 10 * time.Second
+
+// AckFunc reports the delivery of the message written with the given ID. err
+// is nil if the message was delivered.
+type AckFunc func(id uint64, err error)
+
+// AckSink is a sink which confirms the delivery of the messages, e.g. a Kafka
+// producer waiting for the acknowledgements of the brokers. A pipeline with a
+// retransmit buffer keeps the messages written to an AckSink until their
+// delivery is confirmed, and writes them again otherwise.
+type AckSink interface {
+	Sink
+	// WriteAckedBatch writes the messages like WriteBatch, with ids[i] the ID
+	// of messages[i], and calls ack once for every message when its delivery
+	// is known, including for messages with nothing to deliver. ack may be
+	// called before WriteAckedBatch returns, and from other goroutines.
+	WriteAckedBatch(messages []*entities.Message, ids []uint64, ack AckFunc) error
+}
+
+type unackedMessage struct {
+	message *entities.Message
+	// deadline is the time after which the message is written again if its
+	// delivery is not confirmed.
+	deadline time.Time
+	// failed is true if the delivery of the message failed.
+	failed bool
+}
+
+// retransmitBuffer contains the messages written to an AckSink whose delivery
+// is not confirmed yet, by ID. Messages get a new ID when they are written
+// again, so that late acknowledgements of previous writes are ignored.
+type retransmitBuffer struct {
+	mutex    sync.Mutex
+	size     int
+	nextID   uint64
+	messages map[uint64]*unackedMessage
+	acked    uint64
+	// ackedChan is notified when messages are removed from the buffer.
+	ackedChan chan struct{}
+}
+
+func newRetransmitBuffer(size int) *retransmitBuffer {
+	return &retransmitBuffer{
+		size:      size,
+		messages:  make(map[uint64]*unackedMessage),
+		ackedChan: make(chan struct{}, 1),
+	}
+}
+
+// add adds the messages to the buffer and returns their IDs.
+func (b *retransmitBuffer) add(messages []*entities.Message, deadline time.Time) []uint64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ids := make([]uint64, len(messages))
+	for i, message := range messages {
+		ids[i] = b.nextID
+		b.messages[b.nextID] = &unackedMessage{message: message, deadline: deadline}
+		b.nextID++
+	}
+	return ids
+}
+
+// ack removes the message from the buffer if it was delivered, and marks it to
+// be written again otherwise. It is an AckFunc.
+func (b *retransmitBuffer) ack(id uint64, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	unacked, exist := b.messages[id]
+	if !exist {
+		return
+	}
+	if err != nil {
+		unacked.failed = true
+		return
+	}
+	delete(b.messages, id)
+	b.acked++
+	select {
+	case b.ackedChan <- struct{}{}:
+	default:
+	}
+}
+
+// fail marks the messages to be written again.
+func (b *retransmitBuffer) fail(ids []uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, id := range ids {
+		if unacked, exist := b.messages[id]; exist {
+			unacked.failed = true
+		}
+	}
+}
+
+// takeExpired returns the messages whose delivery failed or whose deadline
+// passed, in the order in which they were first written, with new IDs and the
+// given deadline.
+func (b *retransmitBuffer) takeExpired(now time.Time, deadline time.Time) ([]*entities.Message, []uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	expiredIDs := make([]uint64, 0)
+	for id, unacked := range b.messages {
+		if unacked.failed || !now.Before(unacked.deadline) {
+			expiredIDs = append(expiredIDs, id)
+		}
+	}
+	// IDs increase, so the retransmitted messages keep their order.
+	sort.Slice(expiredIDs, func(i, j int) bool {
+		return expiredIDs[i] < expiredIDs[j]
+	})
+	messages := make([]*entities.Message, len(expiredIDs))
+	ids := make([]uint64, len(expiredIDs))
+	for i, id := range expiredIDs {
+		unacked := b.messages[id]
+		delete(b.messages, id)
+		unacked.failed = false
+		unacked.deadline = deadline
+		messages[i] = unacked.message
+		ids[i] = b.nextID
+		b.messages[b.nextID] = unacked
+		b.nextID++
+	}
+	return messages, ids
+}
+
+// clear removes all the messages from the buffer and returns their number.
+func (b *retransmitBuffer) clear() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	n := len(b.messages)
+	b.messages = make(map[uint64]*unackedMessage)
+	return n
+}
+
+func (b *retransmitBuffer) len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.messages)
+}
+
+func (b *retransmitBuffer) getAcked() uint64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.acked
+}
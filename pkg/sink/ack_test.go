@@ -0,0 +1,137 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// fakeAckSink records the IDs of the written messages, which are acknowledged
+// by the test.
+type fakeAckSink struct {
+	fakeSink
+	ids []uint64
+	ack AckFunc
+}
+
+func (s *fakeAckSink) WriteAckedBatch(messages []*entities.Message, ids []uint64, ack AckFunc) error {
+	if err := s.WriteBatch(messages); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ids = append(s.ids, ids...)
+	s.ack = ack
+	return nil
+}
+
+func (s *fakeAckSink) getIDs() []uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]uint64{}, s.ids...)
+}
+
+func (s *fakeAckSink) acknowledge(id uint64, err error) {
+	s.mutex.Lock()
+	ack := s.ack
+	s.mutex.Unlock()
+	ack(id, err)
+}
+
+func TestPipeline_Acks(t *testing.T) {
+	_, err := InitPipeline(PipelineInput{Sink: &fakeSink{}, RetransmitBufferSize: 2})
+	assert.Error(t, err)
+
+	s := &fakeAckSink{}
+	p, err := InitPipeline(PipelineInput{
+		Sink:                 s,
+		BatchSize:            1,
+		FlushInterval:        10 * time.Millisecond,
+		RetransmitBufferSize: 2,
+		AckTimeout:           100 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	go p.Start()
+	for i := 0; i < 3; i++ {
+		assert.True(t, p.Write(createDataMessage(t, []byte{10, 0, 0, byte(i)})))
+	}
+	// The third message waits in the queue while the buffer is full.
+	assert.Eventually(t, func() bool {
+		return len(s.getIDs()) == 2
+	}, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []uint64{0, 1}, s.getIDs())
+	assert.Equal(t, 1, p.GetStats().QueueLength)
+
+	s.acknowledge(0, nil)
+	assert.Eventually(t, func() bool {
+		return len(s.getIDs()) == 3
+	}, time.Second, 5*time.Millisecond)
+	// A failed message is written again with a new ID, and the late
+	// acknowledgement of its previous write is ignored.
+	s.acknowledge(1, fmt.Errorf("broker is unavailable"))
+	assert.Eventually(t, func() bool {
+		return len(s.getIDs()) == 4
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, uint64(3), s.getIDs()[3])
+	s.acknowledge(1, nil)
+	stats := p.GetStats()
+	assert.Equal(t, uint64(1), stats.AcknowledgedMessages)
+	assert.Equal(t, 2, stats.UnacknowledgedMessages)
+	s.acknowledge(3, nil)
+	// The message which is not acknowledged is written again after the
+	// timeout.
+	assert.Eventually(t, func() bool {
+		return len(s.getIDs()) == 5
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, uint64(4), s.getIDs()[4])
+	s.mutex.Lock()
+	assert.Equal(t, "10.0.0.2", getElementValues(s.batches[4][0].GetSet().GetRecords()[0])["sourceIPv4Address"])
+	s.mutex.Unlock()
+	s.acknowledge(4, nil)
+
+	assert.NoError(t, p.Stop())
+	assert.True(t, s.closed)
+	assert.Equal(t, Stats{
+		WrittenMessages:       5,
+		WrittenBatches:        5,
+		AcknowledgedMessages:  3,
+		RetransmittedMessages: 2,
+	}, p.GetStats())
+}
+
+func TestPipeline_AcksOnStop(t *testing.T) {
+	s := &fakeAckSink{}
+	p, err := InitPipeline(PipelineInput{
+		Sink:                 s,
+		RetransmitBufferSize: 10,
+		AckTimeout:           50 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	go p.Start()
+	assert.True(t, p.Write(createDataMessage(t, []byte{10, 0, 0, 1})))
+	// The message is written when the pipeline is stopped, and dropped as it
+	// is not acknowledged before the timeout.
+	assert.NoError(t, p.Stop())
+	assert.Equal(t, []uint64{0}, s.getIDs())
+	assert.Equal(t, uint64(1), p.GetStats().DroppedMessages)
+	assert.Equal(t, 0, p.GetStats().UnacknowledgedMessages)
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+// FileSink appends the data records of the messages to a file, as JSON
+// objects separated by newlines (JSON Lines), e.g.
+//
+//	{"ExportTime":1612345678,"ExportAddress":"10.0.0.1","ObsDomainID":1,"TemplateID":256,"Elements":{"sourceIPv4Address":"10.0.0.1"}}
+//
+// Template sets are not written.
+type FileSink struct {
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+}
+
+type fileRecord struct {
+	ExportTime    uint32
+	ExportAddress string
+	ObsDomainID   uint32
+	TemplateID    uint16
+	Elements      map[string]interface{}
+}
+
+var _ Sink = &FileSink{}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+func (s *FileSink) Open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("cannot open file sink %s: %v", s.path, err)
+	}
+	s.file = file
+	s.writer = bufio.NewWriter(file)
+	return nil
+}
+
+func (s *FileSink) WriteBatch(messages []*entities.Message) error {
+	encoder := json.NewEncoder(s.writer)
+	for _, message := range messages {
+		set := message.GetSet()
+		if set == nil || set.GetSetType() != entities.Data {
+			continue
+		}
+		for _, record := range set.GetRecords() {
+			if err := encoder.Encode(fileRecord{
+				ExportTime:    message.GetExportTime(),
+				ExportAddress: message.GetExportAddress(),
+				ObsDomainID:   message.GetObsDomainID(),
+				TemplateID:    record.GetTemplateID(),
+				Elements:      getElementValues(record),
+			}); err != nil {
+				return fmt.Errorf("error when writing record to file sink %s: %v", s.path, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *FileSink) Flush() error {
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *FileSink) Close() error {
+	err := s.Flush()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// getElementValues returns the values of the elements of the record by name.
+// Addresses are formatted as strings.
+func getElementValues(record entities.Record) map[string]interface{} {
+	values := make(map[string]interface{})
+	for _, element := range record.GetOrderedElementList() {
+		switch value := element.Value.(type) {
+		case net.IP:
+			values[element.Element.Name] = value.String()
+		case net.HardwareAddr:
+			values[element.Element.Name] = value.String()
+		default:
+			values[element.Element.Name] = value
+		}
+	}
+	return values
+}
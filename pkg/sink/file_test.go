@@ -0,0 +1,60 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "records.json")
+	assert.Error(t, NewFileSink(filepath.Join(dir, "missing", "records.json")).Open())
+
+	s := NewFileSink(path)
+	assert.NoError(t, s.Open())
+	templateMessage := entities.NewMessage(true)
+	templateSet := entities.NewSet(true)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	templateMessage.AddSet(templateSet)
+	assert.NoError(t, s.WriteBatch([]*entities.Message{
+		templateMessage,
+		createDataMessage(t, []byte{10, 0, 0, 1}),
+		createDataMessage(t, []byte{10, 0, 0, 2}),
+	}))
+	assert.NoError(t, s.Close())
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"ExportTime":1612345678,"ExportAddress":"10.0.0.1","ObsDomainID":1,"TemplateID":256,"Elements":{"sourceIPv4Address":"10.0.0.1"}}
+{"ExportTime":1612345678,"ExportAddress":"10.0.0.1","ObsDomainID":1,"TemplateID":256,"Elements":{"sourceIPv4Address":"10.0.0.2"}}
+`, string(data))
+
+	// Records are appended to the file.
+	assert.NoError(t, s.Open())
+	assert.NoError(t, s.WriteBatch([]*entities.Message{createDataMessage(t, []byte{10, 0, 0, 3})}))
+	assert.NoError(t, s.Close())
+	data, err = ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"sourceIPv4Address":"10.0.0.3"`)
+}
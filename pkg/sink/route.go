@@ -0,0 +1,234 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/filter"
+	"github.com/vmware/go-ipfix/pkg/schema"
+)
+
+type RouteInput struct {
+	Sink Sink
+	// Filter is a filter expression (see filter.ParseExpression) selecting
+	// the data records written to the sink. All records are written if it
+	// is empty.
+	Filter string
+	// Elements are the names of the elements of the records written to the
+	// sink, in this order. Elements missing from a record are added with the
+	// zero value of their data type, so that sinks with a fixed schema, e.g.
+	// a database table, get the same elements even as exporters add or remove
+	// elements. The elements must be in the registry. Records with none of
+	// the elements are not written. All elements are kept if it is empty.
+	Elements []string
+}
+
+// RouteSink writes the data records of the messages selected by a filter to
+// another sink, with only some of their elements, so that one collector can
+// send different subsets of its flows to several sinks. Messages whose data
+// records are all filtered out are not written. Template records are projected
+// to the elements like data records, and other sets are written unchanged. The
+// records must be decoded records, as sent by a collecting process.
+type RouteSink struct {
+	sink   Sink
+	filter *filter.Expression
+	// elements are the projected elements, in order, and zeroValues the
+	// values of the elements missing from records.
+	elements   []*entities.InfoElement
+	zeroValues []interface{}
+}
+
+var _ Sink = &RouteSink{}
+
+func NewRouteSink(input RouteInput) (*RouteSink, error) {
+	if input.Sink == nil {
+		return nil, fmt.Errorf("cannot create route without sink")
+	}
+	routeSink := &RouteSink{sink: input.Sink}
+	if input.Filter != "" {
+		expression, err := filter.ParseExpression(input.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route filter: %v", err)
+		}
+		routeSink.filter = expression
+	}
+	if len(input.Elements) > 0 {
+		elements, err := schema.GetElements(input.Elements)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route elements: %v", err)
+		}
+		routeSink.elements = elements
+		routeSink.zeroValues = make([]interface{}, len(elements))
+		for i, element := range elements {
+			length := 0
+			if element.Len != entities.VariableLength {
+				length = int(element.Len)
+			}
+			if routeSink.zeroValues[i], err = entities.DecodeElementValue(element, bytes.NewBuffer(make([]byte, length))); err != nil {
+				return nil, fmt.Errorf("invalid route element %s: %v", element.Name, err)
+			}
+		}
+	}
+	return routeSink, nil
+}
+
+func (s *RouteSink) Open() error {
+	return s.sink.Open()
+}
+
+func (s *RouteSink) WriteBatch(messages []*entities.Message) error {
+	routed := make([]*entities.Message, 0, len(messages))
+	for _, message := range messages {
+		routedMessage, err := s.routeMessage(message)
+		if err != nil {
+			return err
+		}
+		if routedMessage != nil {
+			routed = append(routed, routedMessage)
+		}
+	}
+	if len(routed) == 0 {
+		return nil
+	}
+	return s.sink.WriteBatch(routed)
+}
+
+func (s *RouteSink) Flush() error {
+	return s.sink.Flush()
+}
+
+func (s *RouteSink) Close() error {
+	return s.sink.Close()
+}
+
+// routeMessage returns the message with the data records matching the filter,
+// projected to the elements of the route, or nil if no record matches. The
+// message is returned as is if the route neither filters nor projects.
+func (s *RouteSink) routeMessage(message *entities.Message) (*entities.Message, error) {
+	set := message.GetSet()
+	if set != nil && set.GetSetType() == entities.Template && s.elements != nil {
+		return s.projectTemplates(message)
+	}
+	if set == nil || set.GetSetType() != entities.Data || (s.filter == nil && s.elements == nil) {
+		return message, nil
+	}
+	var routedSet entities.Set
+	for _, record := range set.GetRecords() {
+		if s.filter != nil && !s.filter.Match(record) {
+			continue
+		}
+		elements := record.GetOrderedElementList()
+		if s.elements != nil {
+			if elements = s.project(record, true); elements == nil {
+				continue
+			}
+		}
+		if routedSet == nil {
+			routedSet = entities.NewSet(true)
+			if err := routedSet.PrepareSet(entities.Data, record.GetTemplateID()); err != nil {
+				return nil, err
+			}
+		}
+		if err := addDecodedRecord(routedSet, record.GetTemplateID(), elements); err != nil {
+			return nil, err
+		}
+	}
+	if routedSet == nil {
+		return nil, nil
+	}
+	return newMessageWithSet(message, routedSet), nil
+}
+
+// project returns the elements of the route, with the values of the record or
+// zero values for data records, or nil if the record has none of them.
+func (s *RouteSink) project(record entities.Record, isData bool) []*entities.InfoElementWithValue {
+	elements := make([]*entities.InfoElementWithValue, len(s.elements))
+	found := false
+	for i, element := range s.elements {
+		if recordElement, exist := record.GetInfoElementWithValue(element.Name); exist {
+			elements[i] = recordElement
+			found = true
+			continue
+		}
+		var value interface{}
+		if isData {
+			value = s.zeroValues[i]
+		}
+		elements[i] = entities.NewInfoElementWithValue(element, value)
+	}
+	if !found {
+		return nil
+	}
+	return elements
+}
+
+// projectTemplates returns the message with the template records projected to
+// the elements of the route, or nil if no template has any of them.
+func (s *RouteSink) projectTemplates(message *entities.Message) (*entities.Message, error) {
+	routedSet := entities.NewSet(true)
+	if err := routedSet.PrepareSet(entities.Template, entities.TemplateSetID); err != nil {
+		return nil, err
+	}
+	for _, record := range message.GetSet().GetRecords() {
+		if elements := s.project(record, false); elements != nil {
+			if err := routedSet.AddRecord(elements, record.GetTemplateID()); err != nil {
+				return nil, fmt.Errorf("error when adding routed template: %v", err)
+			}
+		}
+	}
+	if routedSet.GetNumberOfRecords() == 0 {
+		return nil, nil
+	}
+	return newMessageWithSet(message, routedSet), nil
+}
+
+// newMessageWithSet returns a message with the header of the message and the
+// set.
+func newMessageWithSet(message *entities.Message, set entities.Set) *entities.Message {
+	newMessage := entities.NewMessage(true)
+	newMessage.SetVersion(message.GetVersion())
+	newMessage.SetMessageLen(message.GetMessageLen())
+	newMessage.SetSequenceNum(message.GetSequenceNum())
+	newMessage.SetObsDomainID(message.GetObsDomainID())
+	newMessage.SetExportTime(message.GetExportTime())
+	newMessage.SetExportAddress(message.GetExportAddress())
+	newMessage.AddSet(set)
+	return newMessage
+}
+
+// addDecodedRecord adds a record with the decoded values of the elements to
+// the set. The record is decoded from zero values, as in the collecting
+// process, and the values are set afterwards.
+func addDecodedRecord(set entities.Set, templateID uint16, elements []*entities.InfoElementWithValue) error {
+	zeroElements := make([]*entities.InfoElementWithValue, len(elements))
+	for i, element := range elements {
+		length := 0
+		if element.Element.Len != entities.VariableLength {
+			length = int(element.Element.Len)
+		}
+		zeroElements[i] = entities.NewInfoElementWithValue(element.Element, bytes.NewBuffer(make([]byte, length)))
+	}
+	if err := set.AddRecord(zeroElements, templateID); err != nil {
+		return fmt.Errorf("error when adding routed record: %v", err)
+	}
+	records := set.GetRecords()
+	for i, element := range records[len(records)-1].GetOrderedElementList() {
+		element.Value = elements[i].Value
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func createFlowMessage(t *testing.T, srcIPs []string, dstPort uint16) *entities.Message {
+	srcElement, err := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	assert.NoError(t, err)
+	portElement, err := registry.GetInfoElement("destinationTransportPort", registry.IANAEnterpriseID)
+	assert.NoError(t, err)
+	set := entities.NewSet(true)
+	assert.NoError(t, set.PrepareSet(entities.Data, 256))
+	for _, srcIP := range srcIPs {
+		port := make([]byte, 2)
+		binary.BigEndian.PutUint16(port, dstPort)
+		assert.NoError(t, set.AddRecord([]*entities.InfoElementWithValue{
+			entities.NewInfoElementWithValue(srcElement, bytes.NewBuffer(net.ParseIP(srcIP).To4())),
+			entities.NewInfoElementWithValue(portElement, bytes.NewBuffer(port)),
+		}, 256))
+	}
+	message := entities.NewMessage(true)
+	message.SetExportTime(1612345678)
+	message.SetExportAddress("10.0.0.1")
+	message.SetObsDomainID(1)
+	message.AddSet(set)
+	return message
+}
+
+func TestRouteSink(t *testing.T) {
+	_, err := NewRouteSink(RouteInput{})
+	assert.Error(t, err)
+	_, err = NewRouteSink(RouteInput{Sink: &fakeSink{}, Filter: "sourceIPv4Address =="})
+	assert.Error(t, err)
+
+	// Without filter and elements, messages are written as is.
+	s := &fakeSink{}
+	route, err := NewRouteSink(RouteInput{Sink: s})
+	assert.NoError(t, err)
+	message := createFlowMessage(t, []string{"10.0.0.1"}, 80)
+	assert.NoError(t, route.WriteBatch([]*entities.Message{message}))
+	assert.Equal(t, message, s.batches[0][0])
+
+	s = &fakeSink{}
+	route, err = NewRouteSink(RouteInput{
+		Sink:     s,
+		Filter:   `sourceIPv4Address in ("10.0.0.0/24")`,
+		Elements: []string{"destinationTransportPort"},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, route.WriteBatch([]*entities.Message{
+		createFlowMessage(t, []string{"10.0.0.1", "10.0.1.1", "10.0.0.2"}, 443),
+		createFlowMessage(t, []string{"10.0.1.2"}, 80),
+	}))
+	// The second message has no matching record.
+	assert.Equal(t, []int{1}, s.getBatchSizes())
+	routed := s.batches[0][0]
+	assert.Equal(t, uint32(1612345678), routed.GetExportTime())
+	assert.Equal(t, "10.0.0.1", routed.GetExportAddress())
+	assert.Equal(t, uint32(1), routed.GetObsDomainID())
+	records := routed.GetSet().GetRecords()
+	assert.Equal(t, 2, len(records))
+	for _, record := range records {
+		assert.Equal(t, uint16(256), record.GetTemplateID())
+		assert.Equal(t, 1, len(record.GetOrderedElementList()))
+		port, exist := record.GetInfoElementWithValue("destinationTransportPort")
+		assert.True(t, exist)
+		assert.Equal(t, uint16(443), port.Value)
+		_, exist = record.GetInfoElementWithValue("sourceIPv4Address")
+		assert.False(t, exist)
+	}
+
+	// Nothing is written if no record matches.
+	assert.NoError(t, route.WriteBatch([]*entities.Message{createFlowMessage(t, []string{"10.0.1.1"}, 80)}))
+	assert.Equal(t, []int{1}, s.getBatchSizes())
+	assert.NoError(t, route.Close())
+	assert.True(t, s.closed)
+}
+
+func TestRouteSinkElementOrder(t *testing.T) {
+	_, err := NewRouteSink(RouteInput{Sink: &fakeSink{}, Elements: []string{"unknownElement"}})
+	assert.Error(t, err)
+
+	s := &fakeSink{}
+	route, err := NewRouteSink(RouteInput{
+		Sink:     s,
+		Elements: []string{"destinationTransportPort", "octetDeltaCount", "sourceIPv4Address", "sourcePodName"},
+	})
+	assert.NoError(t, err)
+	templateSet := entities.NewSet(true)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	elements := make([]*entities.InfoElementWithValue, 0)
+	for _, name := range []string{"sourceIPv4Address", "destinationTransportPort"} {
+		element, err := registry.GetInfoElement(name, registry.IANAEnterpriseID)
+		assert.NoError(t, err)
+		elements = append(elements, entities.NewInfoElementWithValue(element, nil))
+	}
+	assert.NoError(t, templateSet.AddRecord(elements, 256))
+	templateMessage := entities.NewMessage(true)
+	templateMessage.AddSet(templateSet)
+	assert.NoError(t, route.WriteBatch([]*entities.Message{templateMessage, createFlowMessage(t, []string{"10.0.0.1"}, 443)}))
+	assert.Equal(t, []int{2}, s.getBatchSizes())
+
+	expectedNames := []string{"destinationTransportPort", "octetDeltaCount", "sourceIPv4Address", "sourcePodName"}
+	for _, message := range s.batches[0] {
+		record := message.GetSet().GetRecords()[0]
+		assert.Equal(t, uint16(256), record.GetTemplateID())
+		names := make([]string, 0)
+		for _, element := range record.GetOrderedElementList() {
+			names = append(names, element.Element.Name)
+		}
+		assert.Equal(t, expectedNames, names)
+	}
+	// Missing elements have the zero value of their data type.
+	values := s.batches[0][1].GetSet().GetRecords()[0].GetOrderedElementList()
+	assert.Equal(t, uint16(443), values[0].Value)
+	assert.Equal(t, uint64(0), values[1].Value)
+	assert.Equal(t, net.ParseIP("10.0.0.1").To4(), values[2].Value)
+	assert.Equal(t, "", values[3].Value)
+}
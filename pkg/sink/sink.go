@@ -0,0 +1,418 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+	defaultRetryInterval = 100 * time.Millisecond
+	defaultQueueSize     = 1000
+)
+
+// Sink is an output of the messages of a collecting or aggregation process,
+// e.g. an IPFIX exporter (exporter.ExporterSink), a Kafka producer
+// (producer.KafkaProducer) or a file (FileSink). Sinks are written by a
+// Pipeline, which provides the batching, retries and backpressure, so that
+// they behave consistently. The methods of a sink are never called
+// concurrently by the pipeline. Sinks which confirm the delivery of messages
+// can implement AckSink.
+type Sink interface {
+	// Open prepares the sink, e.g. connects to its destination.
+	Open() error
+	// WriteBatch writes the messages. The pipeline retries the whole batch if
+	// an error is returned, so messages written before the error may be
+	// written again: delivery is at least once.
+	WriteBatch(messages []*entities.Message) error
+	// Flush makes the messages written so far durable or sent, e.g. flushes
+	// buffers.
+	Flush() error
+	// Close flushes and closes the sink.
+	Close() error
+}
+
+// OverloadPolicy decides what happens to messages written to a pipeline
+// whose queue is full.
+type OverloadPolicy uint8
+
+const (
+	// OverloadPolicyBlock blocks the writer until the message can be queued.
+	OverloadPolicyBlock OverloadPolicy = iota
+	// OverloadPolicyDrop drops the message and counts it in the
+	// DroppedMessages stat.
+	OverloadPolicyDrop
+)
+
+type PipelineInput struct {
+	Sink Sink
+	// BatchSize is the maximum number of messages per WriteBatch call. The
+	// default is 100.
+	BatchSize int
+	// FlushInterval is the maximum time messages wait in a partial batch.
+	// The sink is flushed after every interval in which messages were
+	// written. The default is 1s.
+	FlushInterval time.Duration
+	// MaxRetries is the number of times a failed WriteBatch or Flush is
+	// retried. A batch which still cannot be written is dropped and counted
+	// in the DroppedMessages stat. 0 disables retries.
+	MaxRetries int
+	// RetryInterval is the wait before the first retry, which is doubled
+	// for every following retry. The default is 100ms.
+	RetryInterval time.Duration
+	// QueueSize is the number of messages waiting to be written, beyond
+	// which OverloadPolicy applies. The default is 1000.
+	QueueSize      int
+	OverloadPolicy OverloadPolicy
+	// RetransmitBufferSize enables acknowledgements if the sink is an
+	// AckSink: the written messages are kept in a retransmit buffer of this
+	// many messages until the sink confirms their delivery, and written
+	// again if their delivery fails or is not confirmed within AckTimeout.
+	// The pipeline stops writing new messages while the buffer is full, so
+	// the queue fills up and OverloadPolicy applies, which bounds memory.
+	// Messages are never dropped after their retries then, except those
+	// still not delivered AckTimeout after the pipeline is stopped. Delivery
+	// is at least once, as messages whose acknowledgement is lost are
+	// written again. 0 disables acknowledgements.
+	RetransmitBufferSize int
+	// AckTimeout is the time to wait for the acknowledgement of a message
+	// before writing it again. The default is 10s.
+	AckTimeout time.Duration
+}
+
+// Stats contains the counters of a pipeline.
+type Stats struct {
+	// WrittenMessages is the number of messages of successful batches.
+	WrittenMessages uint64
+	// WrittenBatches is the number of successful WriteBatch calls.
+	WrittenBatches uint64
+	// Retries is the number of retried WriteBatch and Flush calls.
+	Retries uint64
+	// DroppedMessages is the number of messages dropped because the queue
+	// was full with OverloadPolicyDrop, or because their batch could not be
+	// written.
+	DroppedMessages uint64
+	// FailedFlushes is the number of Flush calls which failed after all
+	// retries.
+	FailedFlushes uint64
+	// QueueLength is the number of messages waiting to be written.
+	QueueLength int
+	// AcknowledgedMessages is the number of messages whose delivery was
+	// confirmed by the sink, with acknowledgements enabled.
+	AcknowledgedMessages uint64
+	// RetransmittedMessages is the number of messages written again because
+	// their delivery failed or was not confirmed in time.
+	RetransmittedMessages uint64
+	// UnacknowledgedMessages is the number of messages in the retransmit
+	// buffer.
+	UnacknowledgedMessages int
+}
+
+type pipelineStats struct {
+	writtenMessages uint64
+	writtenBatches  uint64
+	retries         uint64
+	droppedMessages uint64
+	failedFlushes   uint64
+	retransmitted   uint64
+}
+
+// Pipeline writes the messages queued with Write to its sink in batches.
+type Pipeline struct {
+	sink           Sink
+	batchSize      int
+	flushInterval  time.Duration
+	maxRetries     int
+	retryInterval  time.Duration
+	queue          chan *entities.Message
+	overloadPolicy OverloadPolicy
+	stats          pipelineStats
+	stopChan       chan struct{}
+	stopOnce       sync.Once
+	doneChan       chan struct{}
+	closeErr       error
+	// ackSink is the sink if acknowledgements are enabled.
+	ackSink    AckSink
+	retransmit *retransmitBuffer
+	ackTimeout time.Duration
+}
+
+// InitPipeline opens the sink. The pipeline writes messages once started.
+func InitPipeline(input PipelineInput) (*Pipeline, error) {
+	if input.Sink == nil {
+		return nil, fmt.Errorf("cannot create pipeline without sink")
+	}
+	p := &Pipeline{
+		sink:           input.Sink,
+		batchSize:      input.BatchSize,
+		flushInterval:  input.FlushInterval,
+		maxRetries:     input.MaxRetries,
+		retryInterval:  input.RetryInterval,
+		overloadPolicy: input.OverloadPolicy,
+		stopChan:       make(chan struct{}),
+		doneChan:       make(chan struct{}),
+	}
+	if p.batchSize <= 0 {
+		p.batchSize = defaultBatchSize
+	}
+	if p.flushInterval <= 0 {
+		p.flushInterval = defaultFlushInterval
+	}
+	if p.retryInterval <= 0 {
+		p.retryInterval = defaultRetryInterval
+	}
+	queueSize := input.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	p.queue = make(chan *entities.Message, queueSize)
+	if input.RetransmitBufferSize > 0 {
+		ackSink, ok := input.Sink.(AckSink)
+		if !ok {
+			return nil, fmt.Errorf("cannot enable acknowledgements: sink does not support them")
+		}
+		p.ackSink = ackSink
+		p.retransmit = newRetransmitBuffer(input.RetransmitBufferSize)
+		p.ackTimeout = input.AckTimeout
+		if p.ackTimeout <= 0 {
+			p.ackTimeout = defaultAckTimeout
+		}
+	}
+	if err := p.sink.Open(); err != nil {
+		return nil, fmt.Errorf("error when opening sink: %v", err)
+	}
+	return p, nil
+}
+
+// Write queues the message, and returns false if it was dropped, because the
+// queue is full with OverloadPolicyDrop or because the pipeline is stopped.
+func (p *Pipeline) Write(message *entities.Message) bool {
+	select {
+	case <-p.stopChan:
+		atomic.AddUint64(&p.stats.droppedMessages, 1)
+		return false
+	default:
+	}
+	if p.overloadPolicy == OverloadPolicyDrop {
+		select {
+		case p.queue <- message:
+			return true
+		default:
+			atomic.AddUint64(&p.stats.droppedMessages, 1)
+			return false
+		}
+	}
+	select {
+	case p.queue <- message:
+		return true
+	case <-p.stopChan:
+		atomic.AddUint64(&p.stats.droppedMessages, 1)
+		return false
+	}
+}
+
+// Publish writes the messages of the channel until it is closed.
+func (p *Pipeline) Publish(msgCh chan *entities.Message) {
+	for message := range msgCh {
+		p.Write(message)
+	}
+}
+
+// Start writes the queued messages until the pipeline is stopped.
+func (p *Pipeline) Start() {
+	defer close(p.doneChan)
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+	batch := make([]*entities.Message, 0, p.batchSize)
+	// dirty is true if messages were written since the last flush.
+	dirty := false
+	var ackedChan chan struct{}
+	if p.retransmit != nil {
+		ackedChan = p.retransmit.ackedChan
+	}
+	for {
+		// Messages are not taken from the queue while the retransmit buffer
+		// is full.
+		queue := p.queue
+		if p.retransmit != nil && p.retransmit.len()+len(batch) >= p.retransmit.size {
+			queue = nil
+		}
+		select {
+		case message := <-queue:
+			batch = append(batch, message)
+			if len(batch) == p.batchSize {
+				p.writeBatch(batch)
+				batch = batch[:0]
+				dirty = true
+			}
+		case <-ackedChan:
+		case <-ticker.C:
+			if len(batch) > 0 {
+				p.writeBatch(batch)
+				batch = batch[:0]
+				dirty = true
+			}
+			if p.retransmit != nil && p.retransmitExpired() {
+				dirty = true
+			}
+			if dirty {
+				p.flush()
+				dirty = false
+			}
+		case <-p.stopChan:
+			// Write the queued messages before closing the sink.
+			for len(p.queue) > 0 {
+				batch = append(batch, <-p.queue)
+				if len(batch) == p.batchSize {
+					p.writeBatch(batch)
+					batch = batch[:0]
+				}
+			}
+			if len(batch) > 0 {
+				p.writeBatch(batch)
+			}
+			if p.retransmit != nil {
+				p.flush()
+				p.waitForAcks()
+			}
+			p.closeErr = p.sink.Close()
+			return
+		}
+	}
+}
+
+// Stop writes the queued messages, closes the sink and returns the error of
+// closing it. It must be called after Start.
+func (p *Pipeline) Stop() error {
+	p.stopOnce.Do(func() {
+		close(p.stopChan)
+	})
+	<-p.doneChan
+	return p.closeErr
+}
+
+func (p *Pipeline) GetStats() Stats {
+	stats := Stats{
+		WrittenMessages:       atomic.LoadUint64(&p.stats.writtenMessages),
+		WrittenBatches:        atomic.LoadUint64(&p.stats.writtenBatches),
+		Retries:               atomic.LoadUint64(&p.stats.retries),
+		DroppedMessages:       atomic.LoadUint64(&p.stats.droppedMessages),
+		FailedFlushes:         atomic.LoadUint64(&p.stats.failedFlushes),
+		QueueLength:           len(p.queue),
+		RetransmittedMessages: atomic.LoadUint64(&p.stats.retransmitted),
+	}
+	if p.retransmit != nil {
+		stats.AcknowledgedMessages = p.retransmit.getAcked()
+		stats.UnacknowledgedMessages = p.retransmit.len()
+	}
+	return stats
+}
+
+func (p *Pipeline) writeBatch(batch []*entities.Message) {
+	if p.retransmit != nil {
+		p.writeAckedBatch(batch, p.retransmit.add(batch, time.Now().Add(p.ackTimeout)))
+		return
+	}
+	err := p.retry(func() error {
+		return p.sink.WriteBatch(batch)
+	})
+	if err != nil {
+		klog.Errorf("Dropping %d messages which cannot be written to sink: %v", len(batch), err)
+		atomic.AddUint64(&p.stats.droppedMessages, uint64(len(batch)))
+		return
+	}
+	atomic.AddUint64(&p.stats.writtenMessages, uint64(len(batch)))
+	atomic.AddUint64(&p.stats.writtenBatches, 1)
+}
+
+// writeAckedBatch writes the messages of the retransmit buffer with the IDs.
+// Messages which cannot be written are kept in the buffer, to be written again.
+func (p *Pipeline) writeAckedBatch(batch []*entities.Message, ids []uint64) {
+	err := p.retry(func() error {
+		return p.ackSink.WriteAckedBatch(batch, ids, p.retransmit.ack)
+	})
+	if err != nil {
+		klog.Errorf("Error when writing %d messages to sink, they will be written again: %v", len(batch), err)
+		p.retransmit.fail(ids)
+		return
+	}
+	atomic.AddUint64(&p.stats.writtenMessages, uint64(len(batch)))
+	atomic.AddUint64(&p.stats.writtenBatches, 1)
+}
+
+// retransmitExpired writes again the messages whose delivery failed or was
+// not confirmed in time, and returns whether messages were written.
+func (p *Pipeline) retransmitExpired() bool {
+	now := time.Now()
+	messages, ids := p.retransmit.takeExpired(now, now.Add(p.ackTimeout))
+	for start := 0; start < len(messages); start += p.batchSize {
+		end := start + p.batchSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+		p.writeAckedBatch(messages[start:end], ids[start:end])
+	}
+	atomic.AddUint64(&p.stats.retransmitted, uint64(len(messages)))
+	return len(messages) > 0
+}
+
+// waitForAcks waits for the acknowledgements of the messages of the retransmit
+// buffer for at most the ack timeout, and drops the messages which are still
+// not delivered.
+func (p *Pipeline) waitForAcks() {
+	timer := time.NewTimer(p.ackTimeout)
+	defer timer.Stop()
+	for p.retransmit.len() > 0 {
+		select {
+		case <-p.retransmit.ackedChan:
+		case <-timer.C:
+			dropped := p.retransmit.clear()
+			klog.Errorf("Dropping %d messages whose delivery was not confirmed", dropped)
+			atomic.AddUint64(&p.stats.droppedMessages, uint64(dropped))
+			return
+		}
+	}
+}
+
+func (p *Pipeline) flush() {
+	if err := p.retry(p.sink.Flush); err != nil {
+		klog.Errorf("Error when flushing sink: %v", err)
+		atomic.AddUint64(&p.stats.failedFlushes, 1)
+	}
+}
+
+// retry calls f until it succeeds or maxRetries retries failed, with an
+// exponential backoff, and returns the last error.
+func (p *Pipeline) retry(f func() error) error {
+	interval := p.retryInterval
+	err := f()
+	for i := 0; err != nil && i < p.maxRetries; i++ {
+		klog.V(2).Infof("Retrying in %v after sink error: %v", interval, err)
+		time.Sleep(interval)
+		interval *= 2
+		atomic.AddUint64(&p.stats.retries, 1)
+		err = f()
+	}
+	return err
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func init() {
+	registry.LoadRegistry()
+}
+
+// fakeSink records the batches, and fails the number of writes given by
+// failures.
+type fakeSink struct {
+	mutex    sync.Mutex
+	batches  [][]*entities.Message
+	flushes  int
+	failures int
+	closed   bool
+}
+
+func (s *fakeSink) Open() error {
+	return nil
+}
+
+func (s *fakeSink) WriteBatch(messages []*entities.Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return fmt.Errorf("sink is unavailable")
+	}
+	s.batches = append(s.batches, append([]*entities.Message{}, messages...))
+	return nil
+}
+
+func (s *fakeSink) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.flushes++
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *fakeSink) getBatchSizes() []int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sizes := make([]int, len(s.batches))
+	for i, batch := range s.batches {
+		sizes[i] = len(batch)
+	}
+	return sizes
+}
+
+func createDataMessage(t *testing.T, srcIP []byte) *entities.Message {
+	element, err := registry.GetInfoElement("sourceIPv4Address", registry.IANAEnterpriseID)
+	assert.NoError(t, err)
+	set := entities.NewSet(true)
+	assert.NoError(t, set.PrepareSet(entities.Data, 256))
+	assert.NoError(t, set.AddRecord([]*entities.InfoElementWithValue{entities.NewInfoElementWithValue(element, bytes.NewBuffer(srcIP))}, 256))
+	message := entities.NewMessage(true)
+	message.SetExportTime(1612345678)
+	message.SetExportAddress("10.0.0.1")
+	message.SetObsDomainID(1)
+	message.AddSet(set)
+	return message
+}
+
+func TestPipeline(t *testing.T) {
+	_, err := InitPipeline(PipelineInput{})
+	assert.Error(t, err)
+
+	s := &fakeSink{failures: 1}
+	p, err := InitPipeline(PipelineInput{
+		Sink:          s,
+		BatchSize:     2,
+		FlushInterval: 50 * time.Millisecond,
+		MaxRetries:    1,
+		RetryInterval: time.Millisecond,
+	})
+	assert.NoError(t, err)
+	go p.Start()
+	// The first batch is retried once.
+	for i := 0; i < 3; i++ {
+		assert.True(t, p.Write(createDataMessage(t, []byte{10, 0, 0, byte(i)})))
+	}
+	// The partial batch is written and the sink is flushed after the flush
+	// interval.
+	assert.Eventually(t, func() bool {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return s.flushes > 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int{2, 1}, s.getBatchSizes())
+	assert.Equal(t, Stats{WrittenMessages: 3, WrittenBatches: 2, Retries: 1}, p.GetStats())
+
+	// Batches are dropped after the retries.
+	s.mutex.Lock()
+	s.failures = 2
+	s.mutex.Unlock()
+	p.Write(createDataMessage(t, []byte{10, 0, 0, 3}))
+	p.Write(createDataMessage(t, []byte{10, 0, 0, 4}))
+	assert.Eventually(t, func() bool {
+		return p.GetStats().DroppedMessages == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// The queued messages are written when the pipeline is stopped.
+	p.Write(createDataMessage(t, []byte{10, 0, 0, 5}))
+	assert.NoError(t, p.Stop())
+	assert.True(t, s.closed)
+	assert.Equal(t, []int{2, 1, 1}, s.getBatchSizes())
+	assert.False(t, p.Write(createDataMessage(t, []byte{10, 0, 0, 6})))
+}
+
+func TestPipeline_OverloadPolicyDrop(t *testing.T) {
+	s := &fakeSink{}
+	p, err := InitPipeline(PipelineInput{
+		Sink:           s,
+		QueueSize:      1,
+		OverloadPolicy: OverloadPolicyDrop,
+	})
+	assert.NoError(t, err)
+	// The pipeline is not started, so the queue is full after one message.
+	assert.True(t, p.Write(createDataMessage(t, []byte{10, 0, 0, 1})))
+	assert.False(t, p.Write(createDataMessage(t, []byte{10, 0, 0, 2})))
+	assert.Equal(t, Stats{DroppedMessages: 1, QueueLength: 1}, p.GetStats())
+	go p.Start()
+	assert.NoError(t, p.Stop())
+	assert.Equal(t, []int{1}, s.getBatchSizes())
+}
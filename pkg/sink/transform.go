@@ -0,0 +1,111 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"fmt"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/transform"
+)
+
+type TransformInput struct {
+	Sink Sink
+	// Transform are the rules (see transform.ParseTransform) applied to the
+	// records written to the sink.
+	Transform string
+}
+
+// TransformSink writes the messages to another sink after renaming, computing
+// and setting elements of their records with a transform, so that differences
+// between the schemas of producers and consumers can be bridged by
+// configuration. Template records are transformed like data records, so that
+// sinks exporting IPFIX send templates matching the data records. Options
+// template sets are written unchanged. The records must be decoded records, as
+// sent by a collecting process.
+type TransformSink struct {
+	sink      Sink
+	transform *transform.Transform
+}
+
+var _ Sink = &TransformSink{}
+
+func NewTransformSink(input TransformInput) (*TransformSink, error) {
+	if input.Sink == nil {
+		return nil, fmt.Errorf("cannot create transform without sink")
+	}
+	t, err := transform.ParseTransform(input.Transform)
+	if err != nil {
+		return nil, err
+	}
+	return &TransformSink{input.Sink, t}, nil
+}
+
+func (s *TransformSink) Open() error {
+	return s.sink.Open()
+}
+
+func (s *TransformSink) WriteBatch(messages []*entities.Message) error {
+	transformed := make([]*entities.Message, 0, len(messages))
+	for _, message := range messages {
+		transformedMessage, err := s.transformMessage(message)
+		if err != nil {
+			return err
+		}
+		transformed = append(transformed, transformedMessage)
+	}
+	return s.sink.WriteBatch(transformed)
+}
+
+func (s *TransformSink) Flush() error {
+	return s.sink.Flush()
+}
+
+func (s *TransformSink) Close() error {
+	return s.sink.Close()
+}
+
+// transformMessage returns a message with the records of the message after the
+// transform.
+func (s *TransformSink) transformMessage(message *entities.Message) (*entities.Message, error) {
+	set := message.GetSet()
+	if set == nil || set.GetNumberOfRecords() == 0 || (set.GetSetType() != entities.Data && set.GetSetType() != entities.Template) {
+		return message, nil
+	}
+	isData := set.GetSetType() == entities.Data
+	setID := entities.TemplateSetID
+	if isData {
+		setID = set.GetRecords()[0].GetTemplateID()
+	}
+	transformedSet := entities.NewSet(true)
+	if err := transformedSet.PrepareSet(set.GetSetType(), setID); err != nil {
+		return nil, err
+	}
+	for _, record := range set.GetRecords() {
+		elements, err := s.transform.Apply(record.GetOrderedElementList(), isData)
+		if err != nil {
+			return nil, fmt.Errorf("error when transforming record of template %d: %v", record.GetTemplateID(), err)
+		}
+		if isData {
+			err = addDecodedRecord(transformedSet, record.GetTemplateID(), elements)
+		} else {
+			err = transformedSet.AddRecord(elements, record.GetTemplateID())
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newMessageWithSet(message, transformedSet), nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func TestTransformSink(t *testing.T) {
+	_, err := NewTransformSink(TransformInput{})
+	assert.Error(t, err)
+	_, err = NewTransformSink(TransformInput{Sink: &fakeSink{}, Transform: "rename sourceIPv4Address"})
+	assert.Error(t, err)
+
+	s := &fakeSink{}
+	transformSink, err := NewTransformSink(TransformInput{
+		Sink:      s,
+		Transform: "rename destinationTransportPort to sourceTransportPort; set observationDomainName = \"site-1\"",
+	})
+	assert.NoError(t, err)
+
+	templateSet := entities.NewSet(true)
+	assert.NoError(t, templateSet.PrepareSet(entities.Template, entities.TemplateSetID))
+	elements := make([]*entities.InfoElementWithValue, 0)
+	for _, name := range []string{"sourceIPv4Address", "destinationTransportPort"} {
+		element, err := registry.GetInfoElement(name, registry.IANAEnterpriseID)
+		assert.NoError(t, err)
+		elements = append(elements, entities.NewInfoElementWithValue(element, nil))
+	}
+	assert.NoError(t, templateSet.AddRecord(elements, 256))
+	templateMessage := entities.NewMessage(true)
+	templateMessage.AddSet(templateSet)
+	message := createFlowMessage(t, []string{"10.0.0.1", "10.0.0.2"}, 443)
+	assert.NoError(t, transformSink.WriteBatch([]*entities.Message{templateMessage, message}))
+	assert.Equal(t, []int{2}, s.getBatchSizes())
+
+	template := s.batches[0][0].GetSet().GetRecords()[0]
+	assert.Equal(t, uint16(256), template.GetTemplateID())
+	names := make([]string, 0)
+	for _, element := range template.GetOrderedElementList() {
+		names = append(names, element.Element.Name)
+	}
+	assert.Equal(t, []string{"sourceIPv4Address", "sourceTransportPort", "observationDomainName"}, names)
+
+	transformed := s.batches[0][1]
+	assert.Equal(t, uint32(1612345678), transformed.GetExportTime())
+	assert.Equal(t, "10.0.0.1", transformed.GetExportAddress())
+	records := transformed.GetSet().GetRecords()
+	assert.Equal(t, 2, len(records))
+	for _, record := range records {
+		assert.Equal(t, uint16(256), record.GetTemplateID())
+		assert.Equal(t, 3, len(record.GetOrderedElementList()))
+		port, exist := record.GetInfoElementWithValue("sourceTransportPort")
+		assert.True(t, exist)
+		assert.Equal(t, uint16(443), port.Value)
+		_, exist = record.GetInfoElementWithValue("destinationTransportPort")
+		assert.False(t, exist)
+		name, exist := record.GetInfoElementWithValue("observationDomainName")
+		assert.True(t, exist)
+		assert.Equal(t, "site-1", name.Value)
+	}
+	ip, _ := records[1].GetInfoElementWithValue("sourceIPv4Address")
+	assert.Equal(t, net.ParseIP("10.0.0.2").To4(), ip.Value)
+	// The original message is not modified.
+	_, exist := message.GetSet().GetRecords()[0].GetInfoElementWithValue("destinationTransportPort")
+	assert.True(t, exist)
+	assert.NoError(t, transformSink.Close())
+	assert.True(t, s.closed)
+}
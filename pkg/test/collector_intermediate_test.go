@@ -171,7 +171,7 @@ func testCollectorToIntermediate(t *testing.T, address net.Addr, isIPv6 bool) {
 		assert.NotNil(t, flowKeyRecordMap[flowKey1])
 		record = flowKeyRecordMap[flowKey1].Record
 	}
-	assert.Equal(t, 27, len(record.GetOrderedElementList()))
+	assert.Equal(t, 30, len(record.GetOrderedElementList()))
 	for _, element := range record.GetOrderedElementList() {
 		switch element.Element.Name {
 		case "sourcePodName":
@@ -184,6 +184,16 @@ func testCollectorToIntermediate(t *testing.T, address net.Addr, isIPv6 bool) {
 			assert.Equal(t, registry.ActiveTimeoutReason, element.Value)
 		case "tcpState":
 			assert.Equal(t, "ESTABLISHED", element.Value)
+		case "flowAggregationStatus":
+			assert.Equal(t, registry.FlowAggregationStatusCorrelated, element.Value)
+		case "exporterChainAddresses":
+			if isIPv6 {
+				assert.Equal(t, []interface{}{net.ParseIP("::1")}, element.Value.(*entities.BasicListValue).Values)
+			} else {
+				assert.Equal(t, []interface{}{net.ParseIP("127.0.0.1")}, element.Value.(*entities.BasicListValue).Values)
+			}
+		case "exporterChainObservationDomainIds":
+			assert.Equal(t, []interface{}{uint32(1)}, element.Value.(*entities.BasicListValue).Values)
 		case "packetTotalCount":
 			assert.Equal(t, uint64(1000), element.Value)
 		case "packetDeltaCount":
@@ -0,0 +1,532 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build interop
+
+package test
+
+// The interop tests check the wire compatibility of the exporting and
+// collecting processes with third-party IPFIX implementations, which are run
+// as Docker containers on the host network:
+//   - goflow2 and nfacctd (pmacct) collect the records sent by the exporting
+//     process, which include variable-length elements, biflow (RFC5103)
+//     elements and options records (RFC5610 type records).
+//   - softflowd and pmacctd (pmacct) export the flows of a generated pcap file
+//     to the collecting process, biflows in the case of softflowd.
+// They are run with "make test-interop". The images can be overridden with the
+// INTEROP_*_IMAGE environment variables.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/vmware/go-ipfix/pkg/collector"
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/exporter"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+const (
+	defaultGoflow2Image   = "netsampler/goflow2:v1.1.0"
+	defaultNfacctdImage   = "pmacct/nfacctd:v1.7.7"
+	defaultPmacctdImage   = "pmacct/pmacctd:v1.7.7"
+	defaultSoftflowdImage = "alpine:3.14"
+	// interopTimeout is the time given to the third-party implementations to
+	// start and to process the flows.
+	interopTimeout = 60 * time.Second
+)
+
+// interopFlow is a flow as decoded by an IPFIX implementation. Values are
+// compared as strings, as third-party collectors output numbers and strings
+// inconsistently.
+type interopFlow struct {
+	srcIP          string
+	dstIP          string
+	srcPort        string
+	dstPort        string
+	proto          string
+	octets         string
+	packets        string
+	reverseOctets  string
+	reversePackets string
+}
+
+var (
+	// exportedFlows are sent by the exporting process to the third-party
+	// collectors, with a variable-length interface name and Pod name.
+	exportedFlows = []struct {
+		interopFlow
+		interfaceName string
+		podName       string
+	}{
+		{
+			interopFlow:   interopFlow{"10.0.0.1", "10.0.0.2", "1234", "80", "6", "1000", "10", "500", "5"},
+			interfaceName: "eth0",
+			podName:       "pod1",
+		},
+		{
+			interopFlow:   interopFlow{"10.0.0.3", "10.0.0.4", "5353", "53", "17", "300", "3", "0", "0"},
+			interfaceName: "antrea-gw0",
+			podName:       "a-client-pod-with-a-name-longer-than-the-short-form-limit",
+		},
+	}
+	exportedElements = []struct {
+		name         string
+		enterpriseID uint32
+	}{
+		{"sourceIPv4Address", registry.IANAEnterpriseID},
+		{"destinationIPv4Address", registry.IANAEnterpriseID},
+		{"sourceTransportPort", registry.IANAEnterpriseID},
+		{"destinationTransportPort", registry.IANAEnterpriseID},
+		{"protocolIdentifier", registry.IANAEnterpriseID},
+		// Variable-length elements are followed by fixed-length elements,
+		// so that their length must be decoded correctly.
+		{"interfaceName", registry.IANAEnterpriseID},
+		{"sourcePodName", registry.AntreaEnterpriseID},
+		{"octetDeltaCount", registry.IANAEnterpriseID},
+		{"packetDeltaCount", registry.IANAEnterpriseID},
+		{"reverseOctetDeltaCount", registry.IANAReversedEnterpriseID},
+		{"reversePacketDeltaCount", registry.IANAReversedEnterpriseID},
+	}
+	// capturedFlows are written to the pcap file read by the third-party
+	// exporters. The TCP flow has packets in both directions.
+	capturedFlows = []interopFlow{
+		{"10.1.0.1", "10.1.0.2", "40000", "80", "6", "560", "4", "720", "3"},
+		{"10.1.0.3", "10.1.0.4", "5353", "53", "17", "156", "2", "0", "0"},
+	}
+)
+
+func init() {
+	registry.LoadRegistry()
+}
+
+func getInteropImage(envVar, defaultImage string) string {
+	if image := os.Getenv(envVar); image != "" {
+		return image
+	}
+	return defaultImage
+}
+
+// runContainer runs the image in the background on the host network, with dir
+// mounted at /data. The container is removed at the end of the test.
+func runContainer(t *testing.T, dir string, image string, args ...string) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker is required by interop tests")
+	}
+	name := fmt.Sprintf("go-ipfix-interop-%d", time.Now().UnixNano())
+	runArgs := append([]string{"run", "-d", "--rm", "--name", name, "--network", "host", "-v", dir + ":/data", image}, args...)
+	if output, err := exec.Command("docker", runArgs...).CombinedOutput(); err != nil {
+		t.Fatalf("Cannot run %s: %v: %s", image, err, output)
+	}
+	t.Cleanup(func() {
+		if t.Failed() {
+			logs, _ := exec.Command("docker", "logs", name).CombinedOutput()
+			t.Logf("Logs of %s:\n%s", image, logs)
+		}
+		exec.Command("docker", "rm", "-f", name).Run()
+	})
+}
+
+func getFreeUDPPort(t *testing.T) int {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot get a free port: %v", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func createDataDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "go-ipfix-interop")
+	if err != nil {
+		t.Fatalf("Cannot create data directory: %v", err)
+	}
+	// The containers may not run as the current user.
+	os.Chmod(dir, 0777)
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+// exportFlows sends exportedFlows to the collector with the exporting process,
+// until stopChan is closed, so that collectors started late get the templates.
+func exportFlows(t *testing.T, port int, stopChan chan struct{}) {
+	exportingProcess, err := exporter.InitExportingProcess(exporter.ExporterInput{
+		CollectorAddress:    fmt.Sprintf("127.0.0.1:%d", port),
+		CollectorProtocol:   "udp",
+		ObservationDomainID: 1,
+		SendTypeRecords:     true,
+	})
+	if err != nil {
+		t.Errorf("Cannot create exporting process: %v", err)
+		return
+	}
+	defer exportingProcess.CloseConnToCollector()
+	templateID := exportingProcess.NewTemplateID()
+	templateElements := make([]*entities.InfoElementWithValue, 0)
+	for _, exportedElement := range exportedElements {
+		element, err := registry.GetInfoElement(exportedElement.name, exportedElement.enterpriseID)
+		if err != nil {
+			t.Errorf("Cannot get element %s: %v", exportedElement.name, err)
+			return
+		}
+		templateElements = append(templateElements, entities.NewInfoElementWithValue(element, nil))
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		templateSet := entities.NewSet(false)
+		templateSet.PrepareSet(entities.Template, templateID)
+		templateSet.AddRecord(templateElements, templateID)
+		if _, err := exportingProcess.SendSet(templateSet); err != nil {
+			t.Errorf("Cannot send template set: %v", err)
+			return
+		}
+		dataSet := entities.NewSet(false)
+		dataSet.PrepareSet(entities.Data, templateID)
+		for _, flow := range exportedFlows {
+			values := map[string]interface{}{
+				"sourceIPv4Address":        net.ParseIP(flow.srcIP).To4(),
+				"destinationIPv4Address":   net.ParseIP(flow.dstIP).To4(),
+				"sourceTransportPort":      parseUint16(flow.srcPort),
+				"destinationTransportPort": parseUint16(flow.dstPort),
+				"protocolIdentifier":       uint8(parseUint16(flow.proto)),
+				"interfaceName":            flow.interfaceName,
+				"sourcePodName":            flow.podName,
+				"octetDeltaCount":          parseUint64(flow.octets),
+				"packetDeltaCount":         parseUint64(flow.packets),
+				"reverseOctetDeltaCount":   parseUint64(flow.reverseOctets),
+				"reversePacketDeltaCount":  parseUint64(flow.reversePackets),
+			}
+			elements := make([]*entities.InfoElementWithValue, len(templateElements))
+			for i, templateElement := range templateElements {
+				elements[i] = entities.NewInfoElementWithValue(templateElement.Element, values[templateElement.Element.Name])
+			}
+			dataSet.AddRecord(elements, templateID)
+		}
+		if _, err := exportingProcess.SendSet(dataSet); err != nil {
+			t.Errorf("Cannot send data set: %v", err)
+			return
+		}
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func parseUint16(value string) uint16 {
+	var n uint16
+	fmt.Sscan(value, &n)
+	return n
+}
+
+func parseUint64(value string) uint64 {
+	var n uint64
+	fmt.Sscan(value, &n)
+	return n
+}
+
+// waitForJSONFlows waits until the file contains JSON objects with all the
+// flows of the exporting process, using the given field names for the elements
+// of interopFlow. Reverse elements are not checked.
+func waitForJSONFlows(t *testing.T, path string, fields interopFlow) {
+	toString := func(object map[string]interface{}, field string) string {
+		if value, exist := object[field]; exist {
+			return fmt.Sprint(value)
+		}
+		return ""
+	}
+	received := make(map[string]interopFlow)
+	err := wait.PollImmediate(time.Second, interopTimeout, func() (bool, error) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return false, nil
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			var object map[string]interface{}
+			decoder := json.NewDecoder(strings.NewReader(scanner.Text()))
+			decoder.UseNumber()
+			if err := decoder.Decode(&object); err != nil {
+				continue
+			}
+			flow := interopFlow{
+				srcIP:   toString(object, fields.srcIP),
+				dstIP:   toString(object, fields.dstIP),
+				srcPort: toString(object, fields.srcPort),
+				dstPort: toString(object, fields.dstPort),
+				proto:   toString(object, fields.proto),
+				octets:  toString(object, fields.octets),
+				packets: toString(object, fields.packets),
+			}
+			received[flow.srcIP] = flow
+		}
+		for _, flow := range exportedFlows {
+			if _, exist := received[flow.srcIP]; !exist {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("Flows were not received by the collector, got %v", received)
+	}
+	for _, flow := range exportedFlows {
+		expected := flow.interopFlow
+		expected.reverseOctets, expected.reversePackets = "", ""
+		assert.Equal(t, expected, received[flow.srcIP])
+	}
+}
+
+func TestExporterToGoflow2(t *testing.T) {
+	dir := createDataDir(t)
+	port := getFreeUDPPort(t)
+	runContainer(t, dir, getInteropImage("INTEROP_GOFLOW2_IMAGE", defaultGoflow2Image),
+		fmt.Sprintf("-listen=netflow://127.0.0.1:%d", port), "-format=json", "-transport=file", "-transport.file=/data/flows.json")
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	go exportFlows(t, port, stopChan)
+	waitForJSONFlows(t, filepath.Join(dir, "flows.json"), interopFlow{
+		srcIP:   "SrcAddr",
+		dstIP:   "DstAddr",
+		srcPort: "SrcPort",
+		dstPort: "DstPort",
+		proto:   "Proto",
+		octets:  "Bytes",
+		packets: "Packets",
+	})
+}
+
+func TestExporterToNfacctd(t *testing.T) {
+	dir := createDataDir(t)
+	port := getFreeUDPPort(t)
+	config := fmt.Sprintf(`daemonize: false
+nfacctd_ip: 127.0.0.1
+nfacctd_port: %d
+plugins: print
+aggregate: src_host, dst_host, src_port, dst_port, proto
+print_num_protos: true
+print_output: json
+print_output_file: /data/flows.json
+print_output_file_append: true
+print_refresh_time: 1
+`, port)
+	if err := ioutil.WriteFile(filepath.Join(dir, "nfacctd.conf"), []byte(config), 0644); err != nil {
+		t.Fatalf("Cannot write nfacctd config: %v", err)
+	}
+	runContainer(t, dir, getInteropImage("INTEROP_NFACCTD_IMAGE", defaultNfacctdImage), "-f", "/data/nfacctd.conf")
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	go exportFlows(t, port, stopChan)
+	waitForJSONFlows(t, filepath.Join(dir, "flows.json"), interopFlow{
+		srcIP:   "ip_src",
+		dstIP:   "ip_dst",
+		srcPort: "port_src",
+		dstPort: "port_dst",
+		proto:   "ip_proto",
+		octets:  "bytes",
+		packets: "packets",
+	})
+}
+
+// writePcap writes the packets of capturedFlows to an Ethernet pcap file.
+func writePcap(t *testing.T, path string) {
+	type packet struct {
+		flow    interopFlow
+		reverse bool
+		flags   uint8
+		payload int
+	}
+	// TCP packets have 100 bytes of payload from the client and 200 bytes
+	// from the server, UDP packets 50 bytes.
+	packets := []packet{
+		{capturedFlows[0], false, 0x02, 100},
+		{capturedFlows[0], true, 0x12, 200},
+		{capturedFlows[0], false, 0x18, 100},
+		{capturedFlows[0], true, 0x18, 200},
+		{capturedFlows[0], false, 0x18, 100},
+		{capturedFlows[1], false, 0, 50},
+		{capturedFlows[1], false, 0, 50},
+		{capturedFlows[0], true, 0x11, 200},
+		{capturedFlows[0], false, 0x11, 100},
+	}
+	var buf bytes.Buffer
+	// Global header: magic number, version 2.4, time zone, accuracy, snapshot
+	// length and Ethernet link type.
+	binary.Write(&buf, binary.LittleEndian, []uint32{0xa1b2c3d4})
+	binary.Write(&buf, binary.LittleEndian, []uint16{2, 4})
+	binary.Write(&buf, binary.LittleEndian, []uint32{0, 0, 65535, 1})
+	start := time.Now().Add(-time.Minute)
+	for i, p := range packets {
+		srcIP, dstIP := net.ParseIP(p.flow.srcIP).To4(), net.ParseIP(p.flow.dstIP).To4()
+		srcPort, dstPort := parseUint16(p.flow.srcPort), parseUint16(p.flow.dstPort)
+		if p.reverse {
+			srcIP, dstIP = dstIP, srcIP
+			srcPort, dstPort = dstPort, srcPort
+		}
+		proto := uint8(parseUint16(p.flow.proto))
+		var transport bytes.Buffer
+		binary.Write(&transport, binary.BigEndian, []uint16{srcPort, dstPort})
+		if proto == 6 {
+			binary.Write(&transport, binary.BigEndian, []uint32{uint32(i), uint32(i)})
+			binary.Write(&transport, binary.BigEndian, []uint8{0x50, p.flags})
+			binary.Write(&transport, binary.BigEndian, []uint16{65535, 0, 0})
+		} else {
+			binary.Write(&transport, binary.BigEndian, []uint16{uint16(8 + p.payload), 0})
+		}
+		transport.Write(make([]byte, p.payload))
+		ipHeader := make([]byte, 20)
+		ipHeader[0] = 0x45
+		binary.BigEndian.PutUint16(ipHeader[2:4], uint16(20+transport.Len()))
+		binary.BigEndian.PutUint16(ipHeader[4:6], uint16(i))
+		ipHeader[6] = 0x40
+		ipHeader[8] = 64
+		ipHeader[9] = proto
+		copy(ipHeader[12:16], srcIP)
+		copy(ipHeader[16:20], dstIP)
+		var checksum uint32
+		for j := 0; j < len(ipHeader); j += 2 {
+			checksum += uint32(binary.BigEndian.Uint16(ipHeader[j : j+2]))
+		}
+		checksum = (checksum >> 16) + (checksum & 0xffff)
+		binary.BigEndian.PutUint16(ipHeader[10:12], ^uint16(checksum+(checksum>>16)))
+		frame := append([]byte{0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 1, 0x08, 0x00}, ipHeader...)
+		frame = append(frame, transport.Bytes()...)
+		timestamp := start.Add(time.Duration(i) * 10 * time.Millisecond)
+		binary.Write(&buf, binary.LittleEndian, []uint32{uint32(timestamp.Unix()), uint32(timestamp.Nanosecond() / 1000), uint32(len(frame)), uint32(len(frame))})
+		buf.Write(frame)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Cannot write pcap file: %v", err)
+	}
+}
+
+// startInteropCollector starts a UDP collecting process on a free port.
+func startInteropCollector(t *testing.T) (*collector.CollectingProcess, int) {
+	port := getFreeUDPPort(t)
+	cp, err := collector.InitCollectingProcess(collector.CollectorInput{
+		Address:       fmt.Sprintf("127.0.0.1:%d", port),
+		Protocol:      "udp",
+		MaxBufferSize: 65535,
+		TemplateTTL:   0,
+	})
+	if err != nil {
+		t.Fatalf("Cannot create collecting process: %v", err)
+	}
+	go cp.Start()
+	t.Cleanup(cp.Stop)
+	return cp, port
+}
+
+// waitForCollectedFlows waits until the collecting process receives data
+// records with all of capturedFlows, and checks their elements. Reverse
+// elements are checked if biflow is true.
+func waitForCollectedFlows(t *testing.T, cp *collector.CollectingProcess, biflow bool) {
+	getValue := func(record entities.Record, names ...string) string {
+		for _, name := range names {
+			if ieWithValue, exist := record.GetInfoElementWithValue(name); exist {
+				return fmt.Sprint(ieWithValue.Value)
+			}
+		}
+		return ""
+	}
+	received := make(map[string]interopFlow)
+	timer := time.NewTimer(interopTimeout)
+	defer timer.Stop()
+	for len(received) < len(capturedFlows) {
+		select {
+		case message := <-cp.GetMsgChan():
+			set := message.GetSet()
+			if set.GetSetType() != entities.Data {
+				continue
+			}
+			for _, record := range set.GetRecords() {
+				flow := interopFlow{
+					srcIP:   getValue(record, "sourceIPv4Address"),
+					dstIP:   getValue(record, "destinationIPv4Address"),
+					srcPort: getValue(record, "sourceTransportPort"),
+					dstPort: getValue(record, "destinationTransportPort"),
+					proto:   getValue(record, "protocolIdentifier"),
+					octets:  getValue(record, "octetDeltaCount", "octetTotalCount"),
+					packets: getValue(record, "packetDeltaCount", "packetTotalCount"),
+				}
+				if biflow {
+					flow.reverseOctets = getValue(record, "reverseOctetDeltaCount", "reverseOctetTotalCount")
+					flow.reversePackets = getValue(record, "reversePacketDeltaCount", "reversePacketTotalCount")
+				}
+				// Unidirectional exporters export the reverse direction of
+				// the TCP flow as a separate flow.
+				if flow.srcIP != "" && (biflow || flow.srcIP != capturedFlows[0].dstIP) {
+					received[flow.srcIP] = flow
+				}
+			}
+		case <-timer.C:
+			t.Fatalf("Flows were not received by the collecting process, got %v", received)
+		}
+	}
+	for _, expected := range capturedFlows {
+		if !biflow {
+			expected.reverseOctets, expected.reversePackets = "", ""
+		}
+		assert.Equal(t, expected, received[expected.srcIP])
+	}
+}
+
+func TestSoftflowdToCollector(t *testing.T) {
+	dir := createDataDir(t)
+	writePcap(t, filepath.Join(dir, "flows.pcap"))
+	cp, port := startInteropCollector(t)
+	// softflowd exports bidirectional flows with RFC5103 reverse elements
+	// in IPFIX mode, and exits once all the flows of the file are exported.
+	runContainer(t, dir, getInteropImage("INTEROP_SOFTFLOWD_IMAGE", defaultSoftflowdImage), "sh", "-c",
+		fmt.Sprintf("apk add --no-cache softflowd && softflowd -d -b -v 10 -r /data/flows.pcap -n 127.0.0.1:%d", port))
+	waitForCollectedFlows(t, cp, true)
+}
+
+func TestPmacctdToCollector(t *testing.T) {
+	dir := createDataDir(t)
+	writePcap(t, filepath.Join(dir, "flows.pcap"))
+	cp, port := startInteropCollector(t)
+	config := fmt.Sprintf(`daemonize: false
+pcap_savefile: /data/flows.pcap
+pcap_savefile_wait: true
+plugins: nfprobe
+aggregate: src_host, dst_host, src_port, dst_port, proto
+nfprobe_receiver: 127.0.0.1:%d
+nfprobe_version: 10
+nfprobe_timeouts: expint=1:general=1:maxlife=1
+`, port)
+	if err := ioutil.WriteFile(filepath.Join(dir, "pmacctd.conf"), []byte(config), 0644); err != nil {
+		t.Fatalf("Cannot write pmacctd config: %v", err)
+	}
+	runContainer(t, dir, getInteropImage("INTEROP_PMACCTD_IMAGE", defaultPmacctdImage), "-f", "/data/pmacctd.conf")
+	waitForCollectedFlows(t, cp, false)
+}
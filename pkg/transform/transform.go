@@ -0,0 +1,727 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+// Transform rewrites the Information Elements of records with rules written in
+// a small configuration language, one rule per line or separated by ';':
+//
+//	rename sourcePodName to sourceNodeName
+//	set octetTotalCount = octetDeltaCount + reverseOctetDeltaCount
+//	set observationDomainName = "site-1"
+//
+// "rename A to B" replaces element A by element B with the same value. "set C
+// = ..." sets element C to a constant (a number, a string in double quotes, or
+// true/false), or to an arithmetic expression of numeric elements and numbers
+// with +, -, *, / and parentheses. Rules are applied in order, so a rule sees
+// the elements renamed and set by the previous ones. Lines starting with '#'
+// are comments.
+//
+// The elements which are renamed to or set must be in the registry, which
+// must be loaded, so that the records can still be exported as IPFIX. A renamed
+// element must have the data type of the new element, and computed elements
+// must be numeric. Computed values are clamped to the range of their element,
+// and a division makes the computation use floating point numbers. Records
+// without an element used by a rule are left unchanged by the rule.
+type Transform struct {
+	source string
+	rules  []rule
+}
+
+type rule interface {
+	// apply returns the elements after the rule. Values are only computed
+	// if isData is true: template records have elements without values.
+	apply(elements []*entities.InfoElementWithValue, isData bool) ([]*entities.InfoElementWithValue, error)
+}
+
+// ParseTransform parses the rules, and returns an error describing the first
+// invalid rule.
+func ParseTransform(source string) (*Transform, error) {
+	t := &Transform{source: source}
+	for i, line := range strings.FieldsFunc(source, func(c rune) bool { return c == '\n' || c == ';' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := parseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %d %q: %v", i+1, line, err)
+		}
+		t.rules = append(t.rules, r)
+	}
+	return t, nil
+}
+
+// Apply returns the elements of a record after all the rules. The elements
+// passed are not modified. isData is false for the elements of template
+// records, which have no values, so that templates are transformed like their
+// data records.
+func (t *Transform) Apply(elements []*entities.InfoElementWithValue, isData bool) ([]*entities.InfoElementWithValue, error) {
+	var err error
+	for _, r := range t.rules {
+		elements, err = r.apply(elements, isData)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return elements, nil
+}
+
+func (t *Transform) String() string {
+	return t.source
+}
+
+// resolveElement returns the element with the name in the IANA or Antrea
+// registries.
+func resolveElement(name string) (*entities.InfoElement, error) {
+	for _, enterpriseID := range []uint32{registry.IANAEnterpriseID, registry.IANAReversedEnterpriseID, registry.AntreaEnterpriseID} {
+		if element, err := registry.GetInfoElement(name, enterpriseID); err == nil {
+			return element, nil
+		}
+	}
+	return nil, fmt.Errorf("element %s is not in the registry", name)
+}
+
+// indexOf returns the index of the element with the name, or -1.
+func indexOf(elements []*entities.InfoElementWithValue, name string) int {
+	for i, element := range elements {
+		if element.Element.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// setElement returns a copy of the elements where the element with the name of
+// the new one is replaced by it, or with the new element appended.
+func setElement(elements []*entities.InfoElementWithValue, element *entities.InfoElementWithValue) []*entities.InfoElementWithValue {
+	result := make([]*entities.InfoElementWithValue, 0, len(elements)+1)
+	replaced := false
+	for _, e := range elements {
+		if e.Element.Name == element.Element.Name {
+			result = append(result, element)
+			replaced = true
+		} else {
+			result = append(result, e)
+		}
+	}
+	if !replaced {
+		result = append(result, element)
+	}
+	return result
+}
+
+type renameRule struct {
+	from string
+	to   *entities.InfoElement
+}
+
+func (r *renameRule) apply(elements []*entities.InfoElementWithValue, isData bool) ([]*entities.InfoElementWithValue, error) {
+	i := indexOf(elements, r.from)
+	if i < 0 {
+		return elements, nil
+	}
+	if elements[i].Element.DataType != r.to.DataType {
+		return nil, fmt.Errorf("cannot rename %s to %s: data types %d and %d differ", r.from, r.to.Name, elements[i].Element.DataType, r.to.DataType)
+	}
+	renamed := entities.NewInfoElementWithValue(r.to, elements[i].Value)
+	result := make([]*entities.InfoElementWithValue, 0, len(elements))
+	for j, e := range elements {
+		if j == i {
+			result = append(result, renamed)
+		} else if e.Element.Name != r.to.Name {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+type constantRule struct {
+	element *entities.InfoElement
+	value   interface{}
+}
+
+func (r *constantRule) apply(elements []*entities.InfoElementWithValue, isData bool) ([]*entities.InfoElementWithValue, error) {
+	var value interface{}
+	if isData {
+		value = r.value
+	}
+	return setElement(elements, entities.NewInfoElementWithValue(r.element, value)), nil
+}
+
+type computeRule struct {
+	element    *entities.InfoElement
+	expression expression
+}
+
+func (r *computeRule) apply(elements []*entities.InfoElementWithValue, isData bool) ([]*entities.InfoElementWithValue, error) {
+	values := make(map[string]*entities.InfoElementWithValue, len(elements))
+	for _, element := range elements {
+		values[element.Element.Name] = element
+	}
+	if !r.expression.hasOperands(values) {
+		return elements, nil
+	}
+	if !isData {
+		return setElement(elements, entities.NewInfoElementWithValue(r.element, nil)), nil
+	}
+	n, err := r.expression.evaluate(values)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compute %s: %v", r.element.Name, err)
+	}
+	return setElement(elements, entities.NewInfoElementWithValue(r.element, n.convert(r.element.DataType))), nil
+}
+
+func parseRule(line string) (rule, error) {
+	tokens, err := tokenize(line)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	keyword := p.advance()
+	if keyword.kind != tokenIdentifier || (keyword.text != "rename" && keyword.text != "set") {
+		return nil, fmt.Errorf("expected \"rename\" or \"set\" instead of %s", keyword)
+	}
+	name := p.advance()
+	if name.kind != tokenIdentifier {
+		return nil, fmt.Errorf("expected element name instead of %s", name)
+	}
+	if keyword.text == "rename" {
+		if to := p.advance(); to.kind != tokenIdentifier || to.text != "to" {
+			return nil, fmt.Errorf("expected \"to\" instead of %s", to)
+		}
+		target := p.advance()
+		if target.kind != tokenIdentifier {
+			return nil, fmt.Errorf("expected element name instead of %s", target)
+		}
+		if err := p.expectEnd(); err != nil {
+			return nil, err
+		}
+		element, err := resolveElement(target.text)
+		if err != nil {
+			return nil, err
+		}
+		return &renameRule{name.text, element}, nil
+	}
+	if err := p.expectOperator("="); err != nil {
+		return nil, err
+	}
+	element, err := resolveElement(name.text)
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind == tokenString || (next.kind == tokenIdentifier && (next.text == "true" || next.text == "false")) {
+		p.advance()
+		if err := p.expectEnd(); err != nil {
+			return nil, err
+		}
+		value, err := convertConstant(element, next)
+		if err != nil {
+			return nil, err
+		}
+		return &constantRule{element, value}, nil
+	}
+	expr, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectEnd(); err != nil {
+		return nil, err
+	}
+	if literal, ok := expr.(*literalExpression); ok && !isNumeric(element.DataType) {
+		value, err := convertConstant(element, token{tokenNumber, literal.text, 0})
+		if err != nil {
+			return nil, err
+		}
+		return &constantRule{element, value}, nil
+	}
+	if !isNumeric(element.DataType) {
+		return nil, fmt.Errorf("computed element %s is not numeric", element.Name)
+	}
+	if expr.hasOperands(nil) {
+		// The expression only has numbers.
+		n, err := expr.evaluate(nil)
+		if err != nil {
+			return nil, err
+		}
+		return &constantRule{element, n.convert(element.DataType)}, nil
+	}
+	return &computeRule{element, expr}, nil
+}
+
+// convertConstant returns the value of a string, boolean or number constant
+// with the type of decoded values of the element.
+func convertConstant(element *entities.InfoElement, t token) (interface{}, error) {
+	invalid := fmt.Errorf("invalid value %s for element %s", t, element.Name)
+	switch element.DataType {
+	case entities.String:
+		if t.kind != tokenString {
+			return nil, invalid
+		}
+		return t.text, nil
+	case entities.Boolean:
+		if t.kind != tokenIdentifier {
+			return nil, invalid
+		}
+		return t.text == "true", nil
+	case entities.Ipv4Address, entities.Ipv6Address:
+		ip := net.ParseIP(t.text)
+		if t.kind != tokenString || ip == nil || (element.DataType == entities.Ipv4Address) != (ip.To4() != nil) {
+			return nil, invalid
+		}
+		if element.DataType == entities.Ipv4Address {
+			return ip.To4(), nil
+		}
+		return ip, nil
+	case entities.MacAddress:
+		mac, err := net.ParseMAC(t.text)
+		if t.kind != tokenString || err != nil {
+			return nil, invalid
+		}
+		return mac, nil
+	case entities.DateTimeSeconds, entities.DateTimeMilliseconds:
+		if t.kind != tokenNumber {
+			return nil, invalid
+		}
+		u, err := strconv.ParseUint(t.text, 10, 64)
+		if err != nil {
+			return nil, invalid
+		}
+		if element.DataType == entities.DateTimeSeconds {
+			return uint32(clampUnsigned(u, math.MaxUint32)), nil
+		}
+		return u, nil
+	}
+	return nil, fmt.Errorf("constants of element %s are not supported", element.Name)
+}
+
+func isNumeric(dataType entities.IEDataType) bool {
+	switch dataType {
+	case entities.Unsigned8, entities.Unsigned16, entities.Unsigned32, entities.Unsigned64,
+		entities.Signed8, entities.Signed16, entities.Signed32, entities.Signed64,
+		entities.Float32, entities.Float64:
+		return true
+	}
+	return false
+}
+
+// number is the value of an arithmetic expression. Integers are stored in i as
+// two's complement, so that additions, subtractions and multiplications are
+// the same for signed and unsigned numbers.
+type number struct {
+	isFloat  bool
+	isSigned bool
+	i        uint64
+	f        float64
+}
+
+func newNumber(value interface{}) (number, bool) {
+	switch v := value.(type) {
+	case uint8:
+		return number{i: uint64(v)}, true
+	case uint16:
+		return number{i: uint64(v)}, true
+	case uint32:
+		return number{i: uint64(v)}, true
+	case uint64:
+		return number{i: v}, true
+	case int8:
+		return number{isSigned: true, i: uint64(v)}, true
+	case int16:
+		return number{isSigned: true, i: uint64(v)}, true
+	case int32:
+		return number{isSigned: true, i: uint64(v)}, true
+	case int64:
+		return number{isSigned: true, i: uint64(v)}, true
+	case float32:
+		return number{isFloat: true, f: float64(v)}, true
+	case float64:
+		return number{isFloat: true, f: v}, true
+	}
+	return number{}, false
+}
+
+func (n number) float() float64 {
+	if n.isFloat {
+		return n.f
+	}
+	if n.isSigned {
+		return float64(int64(n.i))
+	}
+	return float64(n.i)
+}
+
+func clampUnsigned(u uint64, max uint64) uint64 {
+	if u > max {
+		return max
+	}
+	return u
+}
+
+func clampSigned(i int64, min int64, max int64) int64 {
+	if i < min {
+		return min
+	}
+	if i > max {
+		return max
+	}
+	return i
+}
+
+// convert returns the number with the type of decoded values of the numeric
+// data type, clamped to its range.
+func (n number) convert(dataType entities.IEDataType) interface{} {
+	var u uint64
+	var i int64
+	switch {
+	case n.isFloat:
+		f := n.f
+		if f < 0 || math.IsNaN(f) {
+			u = 0
+		} else if f >= math.MaxUint64 {
+			u = math.MaxUint64
+		} else {
+			u = uint64(f)
+		}
+		if f <= math.MinInt64 || math.IsNaN(f) {
+			i = math.MinInt64
+		} else if f >= math.MaxInt64 {
+			i = math.MaxInt64
+		} else {
+			i = int64(f)
+		}
+	case n.isSigned:
+		i = int64(n.i)
+		if i > 0 {
+			u = uint64(i)
+		}
+	default:
+		u = n.i
+		i = int64(clampUnsigned(n.i, math.MaxInt64))
+	}
+	switch dataType {
+	case entities.Unsigned8:
+		return uint8(clampUnsigned(u, math.MaxUint8))
+	case entities.Unsigned16:
+		return uint16(clampUnsigned(u, math.MaxUint16))
+	case entities.Unsigned32:
+		return uint32(clampUnsigned(u, math.MaxUint32))
+	case entities.Unsigned64:
+		return u
+	case entities.Signed8:
+		return int8(clampSigned(i, math.MinInt8, math.MaxInt8))
+	case entities.Signed16:
+		return int16(clampSigned(i, math.MinInt16, math.MaxInt16))
+	case entities.Signed32:
+		return int32(clampSigned(i, math.MinInt32, math.MaxInt32))
+	case entities.Signed64:
+		return i
+	case entities.Float32:
+		return float32(n.float())
+	default:
+		return n.float()
+	}
+}
+
+type expression interface {
+	// hasOperands returns true if the values contain every element of the
+	// expression.
+	hasOperands(values map[string]*entities.InfoElementWithValue) bool
+	evaluate(values map[string]*entities.InfoElementWithValue) (number, error)
+}
+
+type literalExpression struct {
+	text  string
+	value number
+}
+
+func (e *literalExpression) hasOperands(values map[string]*entities.InfoElementWithValue) bool {
+	return true
+}
+
+func (e *literalExpression) evaluate(values map[string]*entities.InfoElementWithValue) (number, error) {
+	return e.value, nil
+}
+
+type elementExpression struct {
+	name string
+}
+
+func (e *elementExpression) hasOperands(values map[string]*entities.InfoElementWithValue) bool {
+	_, exist := values[e.name]
+	return exist
+}
+
+func (e *elementExpression) evaluate(values map[string]*entities.InfoElementWithValue) (number, error) {
+	n, ok := newNumber(values[e.name].Value)
+	if !ok {
+		return number{}, fmt.Errorf("element %s is not numeric", e.name)
+	}
+	return n, nil
+}
+
+type negateExpression struct {
+	operand expression
+}
+
+func (e *negateExpression) hasOperands(values map[string]*entities.InfoElementWithValue) bool {
+	return e.operand.hasOperands(values)
+}
+
+func (e *negateExpression) evaluate(values map[string]*entities.InfoElementWithValue) (number, error) {
+	n, err := e.operand.evaluate(values)
+	if err != nil {
+		return number{}, err
+	}
+	if n.isFloat {
+		return number{isFloat: true, f: -n.f}, nil
+	}
+	return number{isSigned: true, i: -n.i}, nil
+}
+
+type binaryExpression struct {
+	op          byte
+	left, right expression
+}
+
+func (e *binaryExpression) hasOperands(values map[string]*entities.InfoElementWithValue) bool {
+	return e.left.hasOperands(values) && e.right.hasOperands(values)
+}
+
+func (e *binaryExpression) evaluate(values map[string]*entities.InfoElementWithValue) (number, error) {
+	left, err := e.left.evaluate(values)
+	if err != nil {
+		return number{}, err
+	}
+	right, err := e.right.evaluate(values)
+	if err != nil {
+		return number{}, err
+	}
+	if left.isFloat || right.isFloat || e.op == '/' {
+		l, r := left.float(), right.float()
+		var f float64
+		switch e.op {
+		case '+':
+			f = l + r
+		case '-':
+			f = l - r
+		case '*':
+			f = l * r
+		default:
+			if r == 0 {
+				return number{}, fmt.Errorf("division by zero")
+			}
+			f = l / r
+		}
+		return number{isFloat: true, f: f}, nil
+	}
+	result := number{isSigned: left.isSigned || right.isSigned || e.op == '-'}
+	switch e.op {
+	case '+':
+		result.i = left.i + right.i
+	case '-':
+		result.i = left.i - right.i
+	default:
+		result.i = left.i * right.i
+	}
+	return result, nil
+}
+
+type tokenKind uint8
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenNumber
+	tokenString
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// pos is the offset of the token in the rule
+	pos int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of rule"
+	}
+	return fmt.Sprintf("%q at position %d", t.text, t.pos)
+}
+
+func tokenize(source string) ([]token, error) {
+	tokens := make([]token, 0)
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(source) && (source[i] == '_' || unicode.IsLetter(rune(source[i])) || unicode.IsDigit(rune(source[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdentifier, source[start:i], start})
+		case c == '.' || unicode.IsDigit(c):
+			start := i
+			for i < len(source) && (source[i] == '.' || unicode.IsDigit(rune(source[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, source[start:i], start})
+		case c == '"':
+			start := i
+			for i++; i < len(source) && source[i] != '"'; i++ {
+				if source[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(source) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			text, err := strconv.Unquote(source[start:i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %v", start, err)
+			}
+			tokens = append(tokens, token{tokenString, text, start})
+		case strings.ContainsRune("+-*/()=", c):
+			tokens = append(tokens, token{tokenOperator, string(c), i})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(source)}), nil
+}
+
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+func (p *parser) isOperator(op string) bool {
+	t := p.peek()
+	return t.kind == tokenOperator && t.text == op
+}
+
+func (p *parser) expectOperator(op string) error {
+	if !p.isOperator(op) {
+		return fmt.Errorf("expected %q instead of %s", op, p.peek())
+	}
+	p.advance()
+	return nil
+}
+
+func (p *parser) expectEnd() error {
+	if t := p.peek(); t.kind != tokenEOF {
+		return fmt.Errorf("unexpected %s", t)
+	}
+	return nil
+}
+
+func (p *parser) parseSum() (expression, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("+") || p.isOperator("-") {
+		op := p.advance().text[0]
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpression{op, left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseProduct() (expression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("*") || p.isOperator("/") {
+		op := p.advance().text[0]
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpression{op, left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (expression, error) {
+	if p.isOperator("-") {
+		p.advance()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &negateExpression{operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expression, error) {
+	if p.isOperator("(") {
+		p.advance()
+		expr, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expectOperator(")")
+	}
+	t := p.advance()
+	switch t.kind {
+	case tokenIdentifier:
+		return &elementExpression{t.text}, nil
+	case tokenNumber:
+		if u, err := strconv.ParseUint(t.text, 10, 64); err == nil {
+			return &literalExpression{t.text, number{i: u}}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", t)
+		}
+		return &literalExpression{t.text, number{isFloat: true, f: f}}, nil
+	}
+	return nil, fmt.Errorf("expected element name or number instead of %s", t)
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+)
+
+func init() {
+	registry.LoadRegistry()
+}
+
+func createElements(t *testing.T, values map[string]interface{}, names ...string) []*entities.InfoElementWithValue {
+	elements := make([]*entities.InfoElementWithValue, 0, len(names))
+	for _, name := range names {
+		element, err := resolveElement(name)
+		assert.NoError(t, err)
+		elements = append(elements, entities.NewInfoElementWithValue(element, values[name]))
+	}
+	return elements
+}
+
+func getValues(elements []*entities.InfoElementWithValue) map[string]interface{} {
+	values := make(map[string]interface{}, len(elements))
+	for _, element := range elements {
+		values[element.Element.Name] = element.Value
+	}
+	return values
+}
+
+func TestParseTransform(t *testing.T) {
+	for _, source := range []string{
+		"delete sourcePodName",
+		"rename sourcePodName",
+		"rename sourcePodName to unknownElement",
+		"set octetTotalCount",
+		"set octetTotalCount = octetDeltaCount +",
+		"set octetTotalCount = (octetDeltaCount",
+		"set octetTotalCount = \"many\"",
+		"set sourcePodName = octetDeltaCount + 1",
+		"set sourceIPv4Address = \"2001:db8::1\"",
+		"set octetTotalCount = octetDeltaCount $ 2",
+	} {
+		_, err := ParseTransform(source)
+		assert.Error(t, err, source)
+	}
+	transform, err := ParseTransform("# comment\nrename sourcePodName to sourceNodeName; set octetTotalCount = 1\n\n")
+	assert.NoError(t, err)
+	assert.Len(t, transform.rules, 2)
+}
+
+func TestTransformApply(t *testing.T) {
+	transform, err := ParseTransform(`
+rename sourcePodName to sourceNodeName
+set octetTotalCount = octetDeltaCount + reverseOctetDeltaCount
+set packetTotalCount = (octetDeltaCount - reverseOctetDeltaCount) * 2
+set flowDurationMilliseconds = octetDeltaCount / 3
+set observationDomainName = "site-1"
+set sourceIPv4Address = "10.0.0.1"
+set ipClassOfService = 1000
+`)
+	assert.NoError(t, err)
+	elements := createElements(t, map[string]interface{}{
+		"sourcePodName":          "pod1",
+		"octetDeltaCount":        uint64(100),
+		"reverseOctetDeltaCount": uint64(150),
+		"sourceIPv4Address":      net.ParseIP("192.168.0.1").To4(),
+	}, "sourcePodName", "octetDeltaCount", "reverseOctetDeltaCount", "sourceIPv4Address")
+	transformed, err := transform.Apply(elements, true)
+	assert.NoError(t, err)
+	// The elements passed are not modified.
+	assert.Equal(t, "sourcePodName", elements[0].Element.Name)
+	assert.Equal(t, net.ParseIP("192.168.0.1").To4(), elements[3].Value)
+
+	names := make([]string, len(transformed))
+	for i, element := range transformed {
+		names[i] = element.Element.Name
+	}
+	assert.Equal(t, []string{"sourceNodeName", "octetDeltaCount", "reverseOctetDeltaCount", "sourceIPv4Address",
+		"octetTotalCount", "packetTotalCount", "flowDurationMilliseconds", "observationDomainName", "ipClassOfService"}, names)
+	values := getValues(transformed)
+	assert.Equal(t, "pod1", values["sourceNodeName"])
+	assert.Equal(t, uint64(250), values["octetTotalCount"])
+	// Negative results are clamped to 0 in unsigned elements.
+	assert.Equal(t, uint64(0), values["packetTotalCount"])
+	assert.Equal(t, uint32(33), values["flowDurationMilliseconds"])
+	assert.Equal(t, "site-1", values["observationDomainName"])
+	assert.Equal(t, net.ParseIP("10.0.0.1").To4(), values["sourceIPv4Address"])
+	assert.Equal(t, uint8(255), values["ipClassOfService"])
+
+	// Template records get the same elements without values.
+	templateElements := createElements(t, nil, "sourcePodName", "octetDeltaCount", "reverseOctetDeltaCount", "sourceIPv4Address")
+	transformed, err = transform.Apply(templateElements, false)
+	assert.NoError(t, err)
+	assert.Len(t, transformed, len(names))
+	for i, element := range transformed {
+		assert.Equal(t, names[i], element.Element.Name)
+		assert.Nil(t, element.Value)
+	}
+
+	// Rules whose elements are missing leave the record unchanged.
+	elements = createElements(t, map[string]interface{}{"octetDeltaCount": uint64(100)}, "octetDeltaCount")
+	transformed, err = transform.Apply(elements, true)
+	assert.NoError(t, err)
+	values = getValues(transformed)
+	assert.NotContains(t, values, "octetTotalCount")
+	assert.NotContains(t, values, "sourceNodeName")
+	assert.Equal(t, uint32(33), values["flowDurationMilliseconds"])
+}
+
+func TestTransformApplyErrors(t *testing.T) {
+	// The data types of renamed elements must match.
+	transform, err := ParseTransform("rename sourcePodName to octetDeltaCount")
+	assert.NoError(t, err)
+	_, err = transform.Apply(createElements(t, map[string]interface{}{"sourcePodName": "pod1"}, "sourcePodName"), true)
+	assert.Error(t, err)
+
+	transform, err = ParseTransform("set octetTotalCount = sourcePodName + 1")
+	assert.NoError(t, err)
+	_, err = transform.Apply(createElements(t, map[string]interface{}{"sourcePodName": "pod1"}, "sourcePodName"), true)
+	assert.Error(t, err)
+
+	transform, err = ParseTransform("set octetTotalCount = octetDeltaCount / 0")
+	assert.NoError(t, err)
+	_, err = transform.Apply(createElements(t, map[string]interface{}{"octetDeltaCount": uint64(1)}, "octetDeltaCount"), true)
+	assert.Error(t, err)
+}
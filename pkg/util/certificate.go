@@ -0,0 +1,102 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// CertificateGetter returns the certificate presented in TLS handshakes. It is
+// called for every handshake, so that long-running processes use rotated
+// certificates without restarting.
+type CertificateGetter func() (*tls.Certificate, error)
+
+// CertificateReloader loads a certificate and its private key from PEM files,
+// and reloads them when the files are modified, e.g. by a certificate manager
+// rotating them. Its GetCertificate method is a CertificateGetter.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+	// checkInterval is the minimum duration between two checks of the files
+	checkInterval time.Duration
+
+	mutex       sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	lastCheck   time.Time
+}
+
+// NewCertificateReloader loads the certificate and the key from the files, and
+// returns an error if they cannot be loaded. The files are checked for
+// modifications at most once per checkInterval, when the certificate is
+// requested; if 0, they are checked for every handshake.
+func NewCertificateReloader(certFile, keyFile string, checkInterval time.Duration) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		certFile:      certFile,
+		keyFile:       keyFile,
+		checkInterval: checkInterval,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the certificate, after reloading it if the files
+// were modified. If the modified files cannot be loaded, e.g. because the key
+// was not written yet, the error is logged and the previous certificate is
+// returned until the files are valid.
+func (r *CertificateReloader) GetCertificate() (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if time.Since(r.lastCheck) >= r.checkInterval {
+		if err := r.reload(); err != nil {
+			klog.Errorf("Cannot reload certificate: %v", err)
+		}
+	}
+	return r.cert, nil
+}
+
+// reload loads the certificate if the files were modified since it was loaded.
+func (r *CertificateReloader) reload() error {
+	r.lastCheck = time.Now()
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil && certInfo.ModTime().Equal(r.certModTime) && keyInfo.ModTime().Equal(r.keyModTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load certificate %s and key %s: %v", r.certFile, r.keyFile, err)
+	}
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+	klog.V(2).Infof("Loaded certificate %s", r.certFile)
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 VMware, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression of TCP connections between exporters and collectors of this
+// library is negotiated by the exporter, which sends CompressionHello at the
+// start of the connection. The collector replies with CompressionAccepted or
+// CompressionRejected, and both ends then exchange either compressed frames or
+// plain IPFIX messages. This is not part of IPFIX, so it must only be enabled
+// on links between processes using this library.
+var CompressionHello = []byte("IPFIX-ZSTD/1\n")
+
+const (
+	CompressionRejected byte = 0
+	CompressionAccepted byte = 1
+	// compressedFrameHeaderLen is the length of the length of the compressed
+	// data ahead of every frame.
+	compressedFrameHeaderLen = 4
+	// maxFrameLen is the maximum length of a frame, compressed or not. Frames
+	// contain a single message, which is at most 65535 bytes.
+	maxFrameLen = 1 << 20
+)
+
+// compressedConn compresses every write into a zstd frame, sent after its
+// length, and decompresses the frames it reads.
+type compressedConn struct {
+	net.Conn
+	reader  io.Reader
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	// pending contains the decompressed bytes not read yet.
+	pending []byte
+}
+
+// NewCompressedConn returns the connection with framed zstd compression, after
+// the compression was negotiated. Bytes are read from reader, which may buffer
+// bytes of conn, or from conn if reader is nil.
+func NewCompressedConn(conn net.Conn, reader io.Reader) (net.Conn, error) {
+	if reader == nil {
+		reader = conn
+	}
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxFrameLen))
+	if err != nil {
+		return nil, err
+	}
+	return &compressedConn{
+		Conn:    conn,
+		reader:  reader,
+		encoder: encoder,
+		decoder: decoder,
+	}, nil
+}
+
+// Write sends b in a single frame, and returns the length of b, as callers
+// check that whole messages are written.
+func (c *compressedConn) Write(b []byte) (int, error) {
+	frame := c.encoder.EncodeAll(b, make([]byte, compressedFrameHeaderLen, compressedFrameHeaderLen+len(b)/2))
+	binary.BigEndian.PutUint32(frame[0:compressedFrameHeaderLen], uint32(len(frame)-compressedFrameHeaderLen))
+	if _, err := c.Conn.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *compressedConn) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 {
+		header := make([]byte, compressedFrameHeaderLen)
+		if _, err := io.ReadFull(c.reader, header); err != nil {
+			return 0, err
+		}
+		frameLen := binary.BigEndian.Uint32(header)
+		if frameLen > maxFrameLen {
+			return 0, fmt.Errorf("compressed frame length %d exceeds the maximum length %d", frameLen, maxFrameLen)
+		}
+		frame := make([]byte, frameLen)
+		if _, err := io.ReadFull(c.reader, frame); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		var err error
+		if c.pending, err = c.decoder.DecodeAll(frame, nil); err != nil {
+			return 0, fmt.Errorf("cannot decompress frame: %v", err)
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *compressedConn) Close() error {
+	c.decoder.Close()
+	return c.Conn.Close()
+}